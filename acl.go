@@ -0,0 +1,163 @@
+package camellia
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	pathpkg "path"
+	"sync/atomic"
+)
+
+/*
+ACLRule grants Principal access to the Entries at the paths matching Path, and to all their children.
+
+Path follows the syntax of path.Match, like Schema patterns, while an empty Path matches the whole tree. The "*"
+Principal matches any principal, including anonymous ones.
+
+Read allows reading values and Entries, Write allows setting, forcing and deleting them.
+*/
+type ACLRule struct {
+	Principal string `json:"principal"`
+	Path      string `json:"path"`
+	Read      bool   `json:"read,omitempty"`
+	Write     bool   `json:"write,omitempty"`
+}
+
+/*
+ACL is a list of rules granting principals (like the components of a device, or the clients of a server) access to
+parts of the tree. Anything not explicitly granted by a rule is denied.
+*/
+type ACL struct {
+	Rules []ACLRule
+}
+
+var acl *ACL
+
+/*
+LoadACL reads an ACL from its JSON representation, a list of rules:
+
+	[
+	    { "principal": "network-manager", "path": "network", "read": true, "write": true },
+	    { "principal": "*", "path": "network/hostname", "read": true }
+	]
+*/
+func LoadACL(reader io.Reader) (*ACL, error) {
+	a := ACL{}
+	err := json.NewDecoder(reader).Decode(&a.Rules)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding ACL - %w", err)
+	}
+
+	err = a.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return &a, nil
+}
+
+/*
+SetACL sets the ACL enforced on the transactions run by UpdateAs. The rest of the API is not affected. A nil ACL
+disables enforcement.
+*/
+func SetACL(a *ACL) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if a != nil {
+		err := a.validate()
+		if err != nil {
+			return err
+		}
+	}
+
+	acl = a
+
+	return nil
+}
+
+/*
+UpdateAs behaves like Update, but every operation performed through the Tx is checked against the current ACL
+(see SetACL) on behalf of principal, failing with ErrAccessDenied if not allowed. principal is also recorded as the
+writer of the changed Entries (see SetWriter).
+*/
+func UpdateAs(principal string, fn func(tx *Tx) error) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return ErrNoDB
+	}
+
+	return update(context.Background(), &Tx{acl: acl, principal: principal, writer: principal}, fn)
+}
+
+/*
+Allows returns whether principal is allowed to read (or, with write == true, to write) the Entry at path.
+*/
+func (a *ACL) Allows(principal string, path string, write bool) bool {
+	path = normalizePath(path)
+
+	for _, rule := range a.Rules {
+		if rule.Principal != "*" && rule.Principal != principal {
+			continue
+		}
+
+		if (write && !rule.Write) || (!write && !rule.Read) {
+			continue
+		}
+
+		if matchesPathOrParent(normalizePath(rule.Path), path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+Check returns an error matching ErrAccessDenied if principal is not allowed to read (or, with write == true, to
+write) the Entry at path.
+*/
+func (a *ACL) Check(principal string, path string, write bool) error {
+	if a.Allows(principal, path, write) {
+		return nil
+	}
+
+	access := "read"
+	if write {
+		access = "write"
+	}
+
+	return fmt.Errorf("%s can't %s %s - %w", principal, access, normalizePath(path), ErrAccessDenied)
+}
+
+func (a *ACL) validate() error {
+	for _, rule := range a.Rules {
+		_, err := pathpkg.Match(rule.Path, "")
+		if err != nil {
+			return fmt.Errorf("invalid path pattern %s - %w", rule.Path, err)
+		}
+	}
+
+	return nil
+}
+
+/*
+matchesPathOrParent returns whether pattern matches path, or one of its parents
+*/
+func matchesPathOrParent(pattern string, path string) bool {
+	if pattern == "" {
+		return true
+	}
+
+	segments := splitPath(path)
+	for i := len(segments); i > 0; i-- {
+		if matched, _ := matchPath(pattern, joinPath(segments[:i])); matched {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,209 @@
+package camellia
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync/atomic"
+)
+
+const backupVersion = 1
+
+/*
+backup is the content of an archive written by BackupSince. Entries are the hierarchies changed since Since, in the
+extended JSON format, null for the deleted ones
+*/
+type backup struct {
+	Version  int           `json:"version"`
+	Since    uint64        `json:"since"`
+	Revision uint64        `json:"revision"`
+	Entries  []backupEntry `json:"entries"`
+}
+
+type backupEntry struct {
+	Path  string          `json:"path"`
+	Entry json.RawMessage `json:"entry"`
+}
+
+/*
+BackupSince writes to w a gzip compressed archive of the Entries changed after sinceRevision, returning the current
+revision of the DB, to be passed to the next call. Only the top-most changed hierarchies are archived, along with
+the deleted paths, so nightly backups of large DBs hold only what changed during the day. With sinceRevision == 0,
+the whole DB is archived, as a full backup.
+
+Changes are found in the change log, so sinceRevision must be within its last Options.ChangeHistory revisions,
+otherwise BackupSince fails with ErrRevisionCompacted, and a full backup is needed. Archives are restored, in order,
+with RestoreBackup.
+*/
+func BackupSince(sinceRevision uint64, w io.Writer) (uint64, error) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return 0, ErrNoDB
+	}
+
+	tx, err := beginReadTx(context.Background())
+	if err != nil {
+		return 0, fmt.Errorf("error beginning transaction - %w", err)
+	}
+
+	b := backup{Version: backupVersion, Since: sinceRevision, Entries: []backupEntry{}}
+
+	paths := []string{""}
+	if sinceRevision == 0 {
+		b.Revision, err = getRevision(tx)
+	} else {
+		var events []Event
+		events, b.Revision, err = getEvents("", sinceRevision, tx)
+		paths = changedRoots(events)
+	}
+
+	for _, p := range paths {
+		if err != nil {
+			break
+		}
+
+		ok := true
+		if p != "" {
+			ok, err = exists(p, tx)
+		}
+
+		if err != nil || !ok {
+			b.Entries = append(b.Entries, backupEntry{Path: p, Entry: json.RawMessage("null")})
+			continue
+		}
+
+		buffer := bytes.Buffer{}
+		err = writeJSON(p, &buffer, ExportOptions{Extended: true}, tx)
+		b.Entries = append(b.Entries, backupEntry{Path: p, Entry: buffer.Bytes()})
+	}
+
+	if err != nil {
+		rollbackTx(tx)
+		return 0, fmt.Errorf("error reading changed entries - %w", err)
+	}
+
+	err = endReadTx(context.Background(), tx)
+	if err != nil {
+		rollbackTx(tx)
+		return 0, fmt.Errorf("error committing transaction - %w", err)
+	}
+
+	zw := gzip.NewWriter(w)
+	err = json.NewEncoder(zw).Encode(b)
+	if err == nil {
+		err = zw.Close()
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("error writing backup - %w", err)
+	}
+
+	return b.Revision, nil
+}
+
+/*
+RestoreBackup applies an archive written by BackupSince to the open DB, in a single transaction, returning the
+revision the archive was taken at. The archived hierarchies replace the existing ones, and the deleted paths are
+deleted, so a full backup followed by the incremental ones taken after it, restored in order, rebuild the DB as it was.
+Hooks are not called.
+*/
+func RestoreBackup(r io.Reader) (uint64, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return 0, fmt.Errorf("error reading backup - %w", err)
+	}
+
+	var b backup
+	err = json.NewDecoder(zr).Decode(&b)
+	if err != nil {
+		return 0, fmt.Errorf("error decoding backup - %w", err)
+	}
+
+	if b.Version != backupVersion {
+		return 0, fmt.Errorf("unsupported backup version %d", b.Version)
+	}
+
+	entries := make([]*Entry, 0, len(b.Entries))
+	for _, e := range b.Entries {
+		var entry *Entry
+		err = json.Unmarshal(e.Entry, &entry)
+		if err != nil {
+			return 0, fmt.Errorf("error decoding entry %s - %w", e.Path, err)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return 0, ErrNoDB
+	}
+
+	tx, err := beginTx()
+	if err != nil {
+		return 0, fmt.Errorf("error beginning transaction - %w", err)
+	}
+
+	for i, e := range b.Entries {
+		err = applyReplicatedEntry(normalizePath(e.Path), entries[i], tx)
+		if err != nil {
+			rollbackTx(tx)
+			return 0, fmt.Errorf("error restoring entry %s - %w", e.Path, err)
+		}
+	}
+
+	err = checkRequired(tx)
+	if err != nil {
+		rollbackTx(tx)
+		return 0, err
+	}
+
+	err = commitTx(tx)
+	if err != nil {
+		return 0, fmt.Errorf("error committing transaction - %w", err)
+	}
+
+	return b.Revision, nil
+}
+
+/*
+changedRoots returns, in order, the paths changed by events, omitting the ones below other changed paths
+*/
+func changedRoots(events []Event) []string {
+	changed := map[string]bool{}
+	for _, e := range events {
+		changed[e.Path] = true
+	}
+
+	paths := make([]string, 0, len(changed))
+	for p := range changed {
+		paths = append(paths, p)
+	}
+
+	sort.Strings(paths)
+
+	roots := []string{}
+	for _, p := range paths {
+		covered := false
+		for _, r := range roots {
+			if inMount(p, r) {
+				covered = true
+				break
+			}
+		}
+
+		if !covered {
+			roots = append(roots, p)
+		}
+	}
+
+	return roots
+}
@@ -0,0 +1,144 @@
+package camellia
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+/*
+Binding keeps a struct in sync with the hierarchy of Entries at a path. See Bind.
+*/
+type Binding struct {
+	path     string
+	target   reflect.Value
+	defaults reflect.Value
+	mutex    sync.RWMutex
+	reload   sync.Mutex
+	unwatch  func()
+	onUpdate func(err error)
+	err      error
+}
+
+/*
+Bind populates the struct pointed by v with the hierarchy of Entries at the specified path (see GetStruct), and keeps it
+updated whenever an Entry under the path changes.
+
+The struct is updated on a background goroutine, once for each committed transaction changing the path, so readers must
+hold the read lock of the returned Binding (RLock and RUnlock) while accessing it. Updates are applied atomically under
+the write lock. Fields without a corresponding Entry
+keep the value they had when Bind was called.
+*/
+func Bind(path string, v any) (*Binding, error) {
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Pointer || value.IsNil() || value.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("value is not a pointer to a struct")
+	}
+
+	b := &Binding{
+		path:     normalizePath(path),
+		target:   value.Elem(),
+		defaults: reflect.New(value.Elem().Type()).Elem()}
+
+	b.defaults.Set(b.target)
+
+	err := b.Reload()
+	if err != nil {
+		return nil, err
+	}
+
+	// Reloading once per transaction keeps readers from seeing partially applied transactions
+	b.unwatch, err = watchCommits(b.path, func(changes []Change) {
+		err := b.Reload()
+
+		b.mutex.RLock()
+		onUpdate := b.onUpdate
+		b.mutex.RUnlock()
+
+		if onUpdate != nil {
+			onUpdate(err)
+		} else if err != nil {
+			logWarn("error reloading binding", "path", b.path, "error", err)
+		}
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+/*
+Reload populates the bound struct with the current state of the DB.
+
+If the bound path does not exist, the struct is reset to the values it had when Bind was called.
+*/
+func (b *Binding) Reload() error {
+	b.reload.Lock()
+	defer b.reload.Unlock()
+
+	value := reflect.New(b.target.Type())
+	value.Elem().Set(b.defaults)
+
+	err := GetStruct(b.path, value.Interface())
+	if err != nil && !errors.Is(err, ErrPathNotFound) {
+		b.mutex.Lock()
+		b.err = err
+		b.mutex.Unlock()
+
+		return err
+	}
+
+	b.mutex.Lock()
+	b.target.Set(value.Elem())
+	b.err = nil
+	b.mutex.Unlock()
+
+	return nil
+}
+
+/*
+OnUpdate registers a callback called after the bound struct is reloaded because of a change, with the eventual error
+of the reload.
+*/
+func (b *Binding) OnUpdate(callback func(err error)) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.onUpdate = callback
+}
+
+/*
+Err returns the error of the latest reload, if any.
+*/
+func (b *Binding) Err() error {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	return b.err
+}
+
+/*
+RLock locks the bound struct for reading.
+*/
+func (b *Binding) RLock() {
+	b.mutex.RLock()
+}
+
+/*
+RUnlock undoes a single RLock call.
+*/
+func (b *Binding) RUnlock() {
+	b.mutex.RUnlock()
+}
+
+/*
+Close stops updating the bound struct.
+*/
+func (b *Binding) Close() {
+	if b.unwatch != nil {
+		b.unwatch()
+	}
+}
@@ -0,0 +1,82 @@
+package camellia
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"sync/atomic"
+)
+
+/*
+SetBytes sets a binary value to the specified path.
+
+The value is stored in a BLOB column and tagged with TypeBytes. It is represented as a base64 string in Entry values,
+hooks and JSON exports.
+*/
+func SetBytes(path string, value []byte) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return ErrNoDB
+	}
+
+	tx, err := beginTx()
+	if err != nil {
+		return fmt.Errorf("error beginning transaction - %w", err)
+	}
+
+	err = setValue(normalizePath(path), base64.StdEncoding.EncodeToString(value), TypeBytes, tx, false, false)
+	if err != nil {
+		rollbackTx(tx)
+		return err
+	}
+
+	err = commitTx(tx)
+	if err != nil {
+		rollbackTx(tx)
+		return fmt.Errorf("error committing transaction - %w", err)
+	}
+
+	return nil
+}
+
+/*
+GetBytes reads the value at the specified path as a byte slice.
+
+For values not set with SetBytes or SetReader, the raw bytes of their string representation are returned.
+*/
+func GetBytes(path string) ([]byte, error) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return nil, ErrNoDB
+	}
+
+	tx, err := beginReadTx(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("error beginning transaction - %w", err)
+	}
+
+	path = normalizePath(path)
+	warnDeprecated(path, "get")
+
+	value, valueType, err := getVisibleValue(path, tx)
+	if err != nil {
+		rollbackTx(tx)
+		return nil, err
+	}
+
+	err = endReadTx(context.Background(), tx)
+	if err != nil {
+		rollbackTx(tx)
+		return nil, fmt.Errorf("error committing transaction - %w", err)
+	}
+
+	if valueType != TypeBytes && valueType != TypeStream {
+		return []byte(value), nil
+	}
+
+	return base64.StdEncoding.DecodeString(value)
+}
@@ -0,0 +1,255 @@
+package camellia
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+bufferedValue is a value set while write buffering is enabled (see Options.FlushInterval), not yet written to the DB
+*/
+type bufferedValue struct {
+	value     string
+	valueType ValueType
+}
+
+/*
+buffered holds the buffered values by path, bufferedOrder their paths in the order they were first set, and
+bufferedParents the parents of their paths. All of them are accessed while the global mutex is held
+*/
+var buffered = map[string]bufferedValue{}
+var bufferedOrder []string
+var bufferedParents = map[string]bool{}
+
+var flusherMutex sync.Mutex
+var flusherStop chan struct{}
+var flusherDone chan struct{}
+
+/*
+Flush writes the values buffered by Set (see Options.FlushInterval) to the DB, in a single transaction.
+
+Values that can't be written anymore, like the ones at paths that became non-value Entries in the meantime, are
+dropped, logging an error, without failing the other ones.
+*/
+func Flush() error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return ErrNoDB
+	}
+
+	return flushBuffered(context.Background())
+}
+
+/*
+bufferValue buffers a value set with Set, after validating it and calling the pre set hooks, like setValue would.
+Must be called while the global mutex is held
+*/
+func bufferValue(path string, value string, valueType ValueType) error {
+	if len(path) == 0 {
+		return ErrPathInvalid
+	}
+
+	err := checkPath(path)
+	if err != nil {
+		return err
+	}
+
+	err = checkWritable(path)
+	if err != nil {
+		return err
+	}
+
+	warnDeprecated(path, "set")
+
+	err = validateValue(path, value, valueType)
+	if err != nil {
+		return err
+	}
+
+	// A value at a parent or at a child of a buffered one is set immediately, after flushing the buffered ones (see
+	// beginTx), so that they are written in the order they were set, and the error of the conflicting path is reported
+	conflict := bufferedParents[path]
+	for p := parentPath(path); !conflict && p != ""; p = parentPath(p) {
+		_, conflict = buffered[p]
+	}
+
+	if conflict {
+		tx, err := beginTx()
+		if err != nil {
+			return fmt.Errorf("error beginning transaction - %w", err)
+		}
+
+		err = setValue(path, value, valueType, tx, false, false)
+		if err != nil {
+			rollbackTx(tx)
+			return err
+		}
+
+		err = commitTx(tx)
+		if err != nil {
+			rollbackTx(tx)
+			return fmt.Errorf("error committing transaction - %w", err)
+		}
+
+		return nil
+	}
+
+	err = callPreSetHooks(path, value)
+	if err != nil {
+		return fmt.Errorf("error calling pre set hooks - %w", err)
+	}
+
+	if _, ok := buffered[path]; !ok {
+		bufferedOrder = append(bufferedOrder, path)
+
+		for p := parentPath(path); p != ""; p = parentPath(p) {
+			bufferedParents[p] = true
+		}
+	}
+
+	buffered[path] = bufferedValue{value: value, valueType: valueType}
+
+	return nil
+}
+
+/*
+flushBuffered writes the buffered values to the DB, then calls their post set hooks. Must be called while the global
+mutex is held
+*/
+func flushBuffered(ctx context.Context) error {
+	if len(bufferedOrder) == 0 {
+		return nil
+	}
+
+	values, order, parents := buffered, bufferedOrder, bufferedParents
+	buffered, bufferedOrder, bufferedParents = map[string]bufferedValue{}, nil, map[string]bool{}
+
+	restore := func() {
+		buffered, bufferedOrder, bufferedParents = values, order, parents
+	}
+
+	tx, err := beginTxCtx(ctx)
+	if err != nil {
+		restore()
+		return fmt.Errorf("error beginning transaction - %w", err)
+	}
+
+	flushed := []string{}
+	for _, path := range order {
+		ok, err := flushValue(path, values[path], tx)
+		if err != nil {
+			rollbackTx(tx)
+			restore()
+			return err
+		}
+
+		if ok {
+			flushed = append(flushed, path)
+		}
+	}
+
+	err = commitTx(tx)
+	if err != nil {
+		rollbackTx(tx)
+		restore()
+		return fmt.Errorf("error committing transaction - %w", err)
+	}
+
+	logDebug("flushed buffered values", "count", len(flushed))
+
+	for _, path := range flushed {
+		err = callPostSetHooks(path, values[path].value)
+		if err != nil {
+			logError("post set hook failed on buffered value", "path", path, "error", err)
+		}
+	}
+
+	return nil
+}
+
+/*
+flushValue writes a buffered value inside a savepoint, so that a value that can't be written is dropped without
+failing the others. Returns whether the value was written
+*/
+func flushValue(path string, v bufferedValue, tx *sql.Tx) (bool, error) {
+	_, err := tx.Exec("SAVEPOINT flush_value")
+	if err != nil {
+		return false, err
+	}
+
+	recorded := len(recordedChanges)
+
+	setErr := setValue(path, v.value, v.valueType, tx, false, true)
+	if setErr != nil {
+		recordedChanges = recordedChanges[:recorded]
+
+		_, err = tx.Exec("ROLLBACK TO flush_value")
+		if err != nil {
+			return false, err
+		}
+
+		logError("dropped buffered value", "path", path, "error", setErr)
+	}
+
+	_, err = tx.Exec("RELEASE flush_value")
+	if err != nil {
+		return false, err
+	}
+
+	return setErr == nil, nil
+}
+
+/*
+startFlusher starts flushing the buffered values at interval (see Options.FlushInterval)
+*/
+func startFlusher(interval time.Duration) {
+	flusherMutex.Lock()
+	defer flusherMutex.Unlock()
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	flusherStop = stop
+	flusherDone = done
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				err := Flush()
+				if err != nil {
+					logError("error flushing buffered values", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+/*
+stopFlusher stops the flusher, if running, waiting for it to return. Must be called while the global mutex is NOT held
+*/
+func stopFlusher() {
+	flusherMutex.Lock()
+	stop := flusherStop
+	done := flusherDone
+	flusherStop = nil
+	flusherDone = nil
+	flusherMutex.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
@@ -6,8 +6,12 @@
 package camellia
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io/fs"
+	"os"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -21,60 +25,263 @@ type BaseType interface {
 }
 
 /*
-TODO: go1.18 doesn't support union of explicit types and interfaces when defining type sets in constraint interfaces
-So this is not possible for now:
+CustomStringable is the interface implemented by user-defined types that can be converted to and from a string.
 
-/*
+FromString is called on a pointer to the value.
+*/
 type CustomStringable interface {
 	String() string
 	FromString(s string) error
 }
 
-type Stringable interface {
-	BaseType | CustomStringable
-}
+/*
+Stringable is the type set of types accepted by Get/Set functions.
+
+go1.18 doesn't support union of explicit types and interfaces defining methods when defining type sets in constraint
+interfaces, so this is not possible:
+
+	type Stringable interface {
+		BaseType | CustomStringable
+	}
+
+For this reason, Get/Set functions only accept BaseType values, while CustomStringable implementations, types with a
+codec registered with RegisterCodec and encoding.TextMarshaler implementations are read and written by GetValue and
+SetValue.
 
 For more details: https://github.com/golang/go/issues/45346#issuecomment-862505803
 */
-
-/* Stringable is the type set of types accepted by Get/Set functions */
 type Stringable interface {
 	BaseType
 }
 
+/*
+ValueType is the type tag stored along with a value.
+
+Values are always handled as strings. The type tag records the original type of the value, when known: the type
+passed to Set (types converted by codecs or interfaces are tagged as strings), or the JSON type of values imported
+preserving native types. TypeUntyped means that no type information is available.
+
+Binary values (TypeBytes) are stored as BLOBs, and represented as base64 strings everywhere else (Entry values, hooks,
+JSON exports). Streamed values (TypeStream) are stored in chunks: their Entry value is always empty, but they are
+represented as base64 strings by Get and JSON exports.
+*/
+type ValueType string
+
+const (
+	TypeUntyped ValueType = ""
+	TypeString  ValueType = "string"
+	TypeInt     ValueType = "int"
+	TypeFloat   ValueType = "float"
+	TypeBool    ValueType = "bool"
+	TypeNull    ValueType = "null"
+	TypeList    ValueType = "list"
+	TypeBytes   ValueType = "bytes"
+	TypeStream  ValueType = "stream"
+)
+
 /*
 Entry represents a single node in the hierarchical store.
 
-When IsValue == true, the Entry carries a value, and it's a leaf node in the hierarchy.
+When IsValue == true, the Entry carries a value, and it's a leaf node in the hierarchy. Type carries the type tag of
+the value.
 
 When IsValue == false, the Entry does not carry a value, but its Children map can contain Entires.
+
+Writer is the identity of the last writer of the Entry (see SetWriter), if any.
+
+Revision is the revision of the DB (see GetRevision) at which the Entry was last changed, 0 if it was not changed
+since the DB was migrated to a version supporting revisions. See SetWithRevision.
+
+Children may not be loaded yet, for Entries returned by GetEntryLazy, or at the last level of GetEntryDepth: see
+ChildrenLoaded and LoadChildren.
 */
 type Entry struct {
 	Path       string
 	LastUpdate time.Time
 	IsValue    bool
 	Value      string
+	Type       ValueType
+	Writer     string
+	Revision   uint64
 	Children   map[string]*Entry
+
+	childrenPending bool
+}
+
+/*
+IsNull returns whether the Entry carries a null value (see SetNull).
+*/
+func (e *Entry) IsNull() bool {
+	return e.IsValue && e.Type == TypeNull
 }
 
 var (
-	ErrPathInvalid       = errors.New("invalid path")
-	ErrPathNotFound      = errors.New("path not found")
-	ErrPathIsNotAValue   = errors.New("path is not a value")
-	ErrValueEmpty        = errors.New("value is empty")
-	ErrNoDB              = errors.New("no DB currently opened")
-	ErrDBVersionMismatch = errors.New("DB version mismatch")
+	ErrPathInvalid             = errors.New("invalid path")
+	ErrPathNotFound            = errors.New("path not found")
+	ErrPathIsNotAValue         = errors.New("path is not a value")
+	ErrValueEmpty              = errors.New("value is empty")
+	ErrValueIsNotAList         = errors.New("value is not a list")
+	ErrUnsupportedType         = errors.New("unsupported type")
+	ErrNoDB                    = errors.New("no DB currently opened")
+	ErrDBVersionMismatch       = errors.New("DB version mismatch")
+	ErrValueCorrupted          = errors.New("value corrupted")
+	ErrEncryptionUnsupported   = errors.New("encryption not supported by the SQLite library")
+	ErrInvalidKey              = errors.New("invalid encryption key")
+	ErrValidationFailed        = errors.New("validation failed")
+	ErrTypeMismatch            = errors.New("type mismatch")
+	ErrConfigMigrationNotFound = errors.New("config migration not found")
+	ErrAccessDenied            = errors.New("access denied")
+	ErrRevisionCompacted       = errors.New("revision compacted")
+	ErrBusy                    = errors.New("DB is locked by another process")
+	ErrRevisionMismatch        = errors.New("revision mismatch")
+	ErrReadOnly                = errors.New("path is read-only")
+	ErrReferenceCycle          = errors.New("reference cycle")
+	ErrPatchTestFailed         = errors.New("patch test failed")
+	ErrNotModified             = errors.New("not modified")
+	ErrSavepointNotFound       = errors.New("savepoint not found")
+	ErrDBNotFound              = errors.New("DB not found")
 )
 
+/*
+PathError records the operation (like "get", "set", "delete" or "import") and the path an error occurred on. Err is the
+underlying error, so errors.Is keeps matching the errors above, like ErrPathNotFound, while errors.As extracts the
+PathError. Imports report the path of the Entry that failed, instead of the one imported at.
+*/
+type PathError struct {
+	Op   string
+	Path string
+	Err  error
+}
+
+func (e *PathError) Error() string {
+	return e.Op + " " + e.Path + ": " + e.Err.Error()
+}
+
+func (e *PathError) Unwrap() error {
+	return e.Err
+}
+
+/*
+pathError wraps err in a PathError for op on path, unless it's nil or already carries a path (like a ValidationError)
+*/
+func pathError(op string, path string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var pErr *PathError
+	var vErr *ValidationError
+	if errors.As(err, &pErr) || errors.As(err, &vErr) {
+		return err
+	}
+
+	return &PathError{Op: op, Path: path, Err: err}
+}
+
+/*
+Options configures how a DB is opened with OpenWithOptions.
+
+Checksums: store a checksum along with every value written, so that corrupted values are detected on read
+(surfacing ErrValueCorrupted). Checksums already stored are always verified, regardless of this option.
+
+EncryptionKey: encrypt the whole DB file with the specified key. Requires linking against SQLCipher (build with the
+libsqlite3 tag, with SQLCipher installed as the system SQLite library), otherwise opening the DB fails with
+ErrEncryptionUnsupported. Opening an encrypted DB with a wrong key, or without a key, fails with ErrInvalidKey.
+
+StrictTypes: reading a value as a type different from the one it was written as (like Get[int] on a value set as a
+string) fails with ErrTypeMismatch, instead of attempting a conversion. Untyped values (like the ones imported from
+JSON without preserving native types) can be read as any type, and ints can always be read as floats.
+
+NoMigrationBackup: do not back up the DB before migrating it to a new schema version. By default, Migrate copies the
+DB to a file named <DB path>.v<old version>-<UTC timestamp>.bak before applying any schema change (see
+GetMigrationBackupPath).
+
+ChangeHistory: the number of revisions kept in the change log (see GetEvents), 1000 by default.
+
+PollExternalChanges: the interval at which the change log is polled for the changes committed by other processes
+sharing the DB file. These are dispatched to the watchers and to the post set hooks of this process, like the
+changes made by the process itself, except that post set hooks can't fail them. Polling costs a read of a single row
+when nothing changed. With PollExternalChanges == 0 (the default), watchers and hooks only see the changes made by
+this process.
+
+BusyTimeout: how long operations wait for the locks held on the DB file by other processes before failing with
+ErrBusy, 5 seconds by default (a negative value makes them fail immediately). Every write transaction takes the write
+lock of the DB file when it begins, so that write transactions of different processes are serialized, waiting for each
+other, and the attempts are retried with an exponential backoff. Reads run concurrently with each other, also within
+the same process, and with the writes of other processes.
+
+MaxReadConns: the maximum number of connections used by concurrent reads, 4 by default. Writes always use a single,
+dedicated connection, so at most MaxReadConns + 1 connections are open.
+
+MaxIdleReadConns: the maximum number of reader connections kept open while unused, MaxReadConns by default (a
+negative value closes them as soon as they are unused).
+
+FlushInterval: buffer the values set with Set in memory, writing them to the DB in a single transaction at this
+interval, on Flush, on Close, and before any other write. Setting the same path many times between flushes writes
+it only once, trading a window of durability for less writes to the storage. Get returns the buffered values, while
+the other reads only see the flushed ones. Errors depending on the content of the DB, like ErrPathIsNotAValue, can't
+be returned by Set, and are logged when flushing, dropping the value. With FlushInterval == 0 (the default), values
+are written immediately.
+
+Durability: how much committed transactions are protected from power losses, as opposed to how many times the
+storage is synced. DurabilityNormal by default (see Durability).
+
+CheckpointInterval: write the write-ahead log back to the DB file at this interval, with CheckpointMode (see
+CheckpointWithMode), instead of letting SQLite do it whenever the log grows beyond 1000 pages, so that the writes to
+the storage happen only when chosen, like on flash. The automatic checkpoints of SQLite are then disabled for the
+writes of this process, and the log grows until the next checkpoint. With CheckpointInterval == 0 (the default), SQLite
+checkpoints automatically.
+
+CheckpointOnClose: checkpoint the DB on Close, with CheckpointMode, so that the log is written back before shutting
+down. Failures are logged without failing Close.
+
+CheckpointMode: the mode of the checkpoints made at CheckpointInterval and on Close, CheckpointTruncate by default.
+
+PathRules: how paths are normalized and which paths are valid. By default, empty segments are removed and any other
+path is accepted as is (see PathRules).
+
+Defaults: the path of a DB file holding the default Entries, opened read-only, so that it can reside on a read-only
+partition. The DB is then the user layer of an overlay: reads return the Entries of the user layer, falling through
+to the defaults, while writes go to the user layer only, overriding the defaults. Reset removes the overrides. The
+defaults can be overridden, but not deleted, nor changed from values to non-values and vice versa, which fails with
+ErrReadOnly. The defaults file must be at the schema version of the library (see Migrate), and can't be combined with
+EncryptionKey or Mount.
+*/
+type Options struct {
+	Checksums           bool
+	EncryptionKey       string
+	StrictTypes         bool
+	NoMigrationBackup   bool
+	ChangeHistory       int
+	PollExternalChanges time.Duration
+	BusyTimeout         time.Duration
+	MaxReadConns        int
+	MaxIdleReadConns    int
+	FlushInterval       time.Duration
+	Durability          Durability
+	CheckpointInterval  time.Duration
+	CheckpointOnClose   bool
+	CheckpointMode      CheckpointMode
+	PathRules           PathRules
+	Defaults            string
+}
+
 var initialized = int32(0)
-var mutex sync.Mutex
+var mutex sync.RWMutex
 
 /*
-Open initializes a camellia DB for usage.
+Open initializes a camellia DB for usage, with the default Options.
 
 Most of the API methods will return ErrNoDB if Open is not called first.
 */
 func Open(path string) (bool, error) {
+	return OpenWithOptions(path, Options{})
+}
+
+/*
+OpenWithOptions initializes a camellia DB for usage, with the specified Options.
+*/
+func OpenWithOptions(path string, options Options) (bool, error) {
 	mutex.Lock()
 	defer mutex.Unlock()
 
@@ -84,20 +291,76 @@ func Open(path string) (bool, error) {
 
 	wipeHooks()
 
-	created, err := openDB(path)
+	created, _, err := openDB(path, options, false)
 	if err != nil {
 		return false, fmt.Errorf("error opening DB - %w", err)
 	}
 
+	err = configureCheckpoints(options)
+	if err != nil {
+		closeDB()
+		return false, err
+	}
+
+	if options.PollExternalChanges > 0 {
+		err = startPoller(options.PollExternalChanges)
+		if err != nil {
+			closeDB()
+			return false, err
+		}
+	}
+
+	if options.CheckpointInterval > 0 {
+		startCheckpointer(options.CheckpointInterval, options.CheckpointMode)
+	}
+
+	if options.FlushInterval > 0 {
+		startFlusher(options.FlushInterval)
+	}
+
 	atomic.StoreInt32(&initialized, 1)
 
 	return created, nil
 }
 
+/*
+OpenExisting initializes the camellia DB at path like Open, but fails with ErrDBNotFound, instead of creating a new
+DB, if no file exists at path. Useful for tools that only read the DB, so that a mistyped path is reported instead of
+leaving an empty DB behind.
+*/
+func OpenExisting(path string) error {
+	return OpenExistingWithOptions(path, Options{})
+}
+
+/*
+OpenExistingWithOptions calls OpenExisting, with the specified Options.
+*/
+func OpenExistingWithOptions(path string, options Options) error {
+	// The parameters of the data source are not part of the file name
+	file, _, _ := strings.Cut(path, "?")
+
+	_, err := os.Stat(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("error opening DB %s - %w", path, ErrDBNotFound)
+	}
+
+	if err != nil {
+		return fmt.Errorf("error opening DB - %w", err)
+	}
+
+	_, err = OpenWithOptions(path, options)
+	return err
+}
+
 /*
 Close closes a camellia DB.
 */
 func Close() error {
+	// The poller, the flusher and the checkpointer lock the DB, so they're stopped before
+	stopPoller()
+	stopFlusher()
+	stopCheckpointer()
+
 	mutex.Lock()
 	defer mutex.Unlock()
 
@@ -105,12 +368,28 @@ func Close() error {
 		return ErrNoDB
 	}
 
-	err := closeDB()
+	err := flushBuffered(context.Background())
+	if err != nil {
+		return fmt.Errorf("error flushing buffered values - %w", err)
+	}
+
+	if dbOptions.CheckpointOnClose {
+		err = checkpoint(dbOptions.CheckpointMode)
+		if err != nil {
+			logError("error checkpointing DB", "error", err)
+		}
+	}
+
+	err = closeDB()
 	if err != nil {
 		return fmt.Errorf("error closing DB - %w", err)
 	}
 
+	mounts, mountsSchema = nil, ""
+	runtimeValues = map[string]runtimeValue{}
+
 	wipeHooks()
+	wipeProviders()
 
 	atomic.StoreInt32(&initialized, 0)
 
@@ -130,32 +409,55 @@ func IsOpen() bool {
 }
 
 /*
-Migrate migrates a DB at dbPath to the current supported DB schema version.
+Migrate opens the DB at dbPath, migrating it to the current supported DB schema version if needed.
 
-Returns true if the DB was actually migrated, false if it was already at the current supported DB schema version.
+Returns true if the DB was actually migrated (or created), false if it was already at the current supported DB
+schema version.
 */
 func Migrate(dbPath string) (bool, error) {
+	return MigrateWithOptions(dbPath, Options{})
+}
+
+/*
+MigrateWithOptions calls Migrate, opening the DB with the specified Options.
+*/
+func MigrateWithOptions(dbPath string, options Options) (bool, error) {
 	mutex.Lock()
 	defer mutex.Unlock()
 
-	created, err := Open(dbPath)
-	if err != nil {
-		return false, err
+	if atomic.LoadInt32(&initialized) == 1 {
+		return migrate(db)
 	}
 
-	if created {
-		return true, nil
+	wipeHooks()
+
+	created, migrated, err := openDB(dbPath, options, true)
+	if err != nil {
+		return false, fmt.Errorf("error opening DB - %w", err)
 	}
 
-	return migrate()
+	atomic.StoreInt32(&initialized, 1)
+
+	return created || migrated, nil
+}
+
+/*
+GetMigrationBackupPath returns the path of the backup created by the last Migrate call that actually migrated the DB,
+or an empty string if no backup was created.
+*/
+func GetMigrationBackupPath() string {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	return migrationBackupPath
 }
 
 /*
 GetDBPath returns the path of the current open DB.
 */
 func GetDBPath() string {
-	mutex.Lock()
-	defer mutex.Unlock()
+	mutex.RLock()
+	defer mutex.RUnlock()
 
 	return dbPath
 }
@@ -164,8 +466,8 @@ func GetDBPath() string {
 GetSupportedDBSchemaVersion returns the current supported DB schema version.
 */
 func GetSupportedDBSchemaVersion() uint64 {
-	mutex.Lock()
-	defer mutex.Unlock()
+	mutex.RLock()
+	defer mutex.RUnlock()
 
 	return dbVersion
 }
@@ -174,6 +476,88 @@ func GetSupportedDBSchemaVersion() uint64 {
 Set sets a value of type T to the specified path.
 */
 func Set[T Stringable](path string, value T) error {
+	return SetCtx(context.Background(), path, value)
+}
+
+/*
+SetCtx calls Set, tracing the operation as a child of ctx (see SetTracer). The transaction is rolled back if ctx is
+done before it is committed.
+*/
+func SetCtx[T Stringable](ctx context.Context, path string, value T) (err error) {
+	ctx, span := startSpan(ctx, "camellia.Set", path)
+	defer func() {
+		span.End(err)
+	}()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return ErrNoDB
+	}
+
+	return set(ctx, path, value)
+}
+
+/*
+SetValue sets a value of a type not accepted by Set to the specified path: a CustomStringable implementation, a type
+with a codec registered with RegisterCodec, or an encoding.TextMarshaler implementation. Values are converted to
+strings by the first of them available, in this order of precedence, and tagged as TypeString. BaseType values are
+converted like Set does.
+
+	err := camellia.SetValue("network/timeout", 30*time.Second)
+*/
+func SetValue[T any](path string, value T) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return ErrNoDB
+	}
+
+	return set(context.Background(), path, value)
+}
+
+/*
+set sets value to path, converted as described by SetValue. Must be called while the global mutex is held
+*/
+func set[T any](ctx context.Context, path string, value T) error {
+	valueString, err := encodeValue(value)
+	if err != nil {
+		return fmt.Errorf("error converting value to string - %w", err)
+	}
+
+	if dbOptions.FlushInterval > 0 {
+		return bufferValue(normalizePath(path), valueString, valueTypeOf[T]())
+	}
+
+	tx, err := beginTxCtx(ctx)
+	if err != nil {
+		return fmt.Errorf("error beginning transaction - %w", err)
+	}
+
+	err = setValue(normalizePath(path), valueString, valueTypeOf[T](), tx, false, false)
+	if err != nil {
+		rollbackTx(tx)
+		return err
+	}
+
+	err = commitTx(tx)
+	if err != nil {
+		rollbackTx(tx)
+		return fmt.Errorf("error committing transaction - %w", err)
+	}
+
+	return nil
+}
+
+/*
+SetNull sets an explicit null value to the specified path, distinct from an empty string.
+
+Null values are tagged with TypeNull, are read as the zero value of the requested type (or fail with ErrTypeMismatch in
+strict mode), and are exported to JSON as nulls.
+*/
+func SetNull(path string) error {
 	mutex.Lock()
 	defer mutex.Unlock()
 
@@ -181,26 +565,60 @@ func Set[T Stringable](path string, value T) error {
 		return ErrNoDB
 	}
 
-	tx, err := db.Begin()
+	tx, err := beginTx()
 	if err != nil {
 		return fmt.Errorf("error beginning transaction - %w", err)
 	}
 
-	err = setValue(normalizePath(path), fmt.Sprint(value), tx, false, false)
+	err = setValue(normalizePath(path), "", TypeNull, tx, false, false)
 	if err != nil {
-		tx.Rollback()
+		rollbackTx(tx)
 		return err
 	}
 
-	err = tx.Commit()
+	err = commitTx(tx)
 	if err != nil {
-		tx.Rollback()
+		rollbackTx(tx)
 		return fmt.Errorf("error committing transaction - %w", err)
 	}
 
 	return nil
 }
 
+/*
+IsNull returns whether the value at the specified path is null (see SetNull).
+*/
+func IsNull(path string) (bool, error) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return false, ErrNoDB
+	}
+
+	tx, err := beginReadTx(context.Background())
+	if err != nil {
+		return false, fmt.Errorf("error beginning transaction - %w", err)
+	}
+
+	path = normalizePath(path)
+	warnDeprecated(path, "get")
+
+	_, valueType, err := getVisibleValue(path, tx)
+	if err != nil {
+		rollbackTx(tx)
+		return false, err
+	}
+
+	err = endReadTx(context.Background(), tx)
+	if err != nil {
+		rollbackTx(tx)
+		return false, fmt.Errorf("error committing transaction - %w", err)
+	}
+
+	return valueType == TypeNull, nil
+}
+
 /*
 Force sets a value of type T to the specified path.
 
@@ -214,20 +632,25 @@ func Force[T Stringable](path string, value T) error {
 		return ErrNoDB
 	}
 
-	tx, err := db.Begin()
+	valueString, err := encodeValue(value)
+	if err != nil {
+		return fmt.Errorf("error converting value to string - %w", err)
+	}
+
+	tx, err := beginTx()
 	if err != nil {
 		return fmt.Errorf("error beginning transaction - %w", err)
 	}
 
-	err = setValue(normalizePath(path), fmt.Sprint(value), tx, true, false)
+	err = setValue(normalizePath(path), valueString, valueTypeOf[T](), tx, true, false)
 	if err != nil {
-		tx.Rollback()
+		rollbackTx(tx)
 		return err
 	}
 
-	err = tx.Commit()
+	err = commitTx(tx)
 	if err != nil {
-		tx.Rollback()
+		rollbackTx(tx)
 		return fmt.Errorf("error committing transaction - %w", err)
 	}
 
@@ -245,20 +668,25 @@ func SetOrPanic[T Stringable](path string, value T) {
 		panic(ErrNoDB)
 	}
 
-	tx, err := db.Begin()
+	valueString, err := encodeValue(value)
+	if err != nil {
+		panic(fmt.Errorf("error converting value to string - %w", err))
+	}
+
+	tx, err := beginTx()
 	if err != nil {
 		panic(fmt.Errorf("error beginning transaction - %w", err))
 	}
 
-	err = setValue(normalizePath(path), fmt.Sprint(value), tx, false, false)
+	err = setValue(normalizePath(path), valueString, valueTypeOf[T](), tx, false, false)
 	if err != nil {
-		tx.Rollback()
+		rollbackTx(tx)
 		panic(err)
 	}
 
-	err = tx.Commit()
+	err = commitTx(tx)
 	if err != nil {
-		tx.Rollback()
+		rollbackTx(tx)
 		panic(fmt.Errorf("error committing transaction - %w", err))
 	}
 }
@@ -274,20 +702,25 @@ func ForceOrPanic[T Stringable](path string, value T) {
 		panic(ErrNoDB)
 	}
 
-	tx, err := db.Begin()
+	valueString, err := encodeValue(value)
+	if err != nil {
+		panic(fmt.Errorf("error converting value to string - %w", err))
+	}
+
+	tx, err := beginTx()
 	if err != nil {
 		panic(fmt.Errorf("error beginning transaction - %w", err))
 	}
 
-	err = setValue(normalizePath(path), fmt.Sprint(value), tx, true, false)
+	err = setValue(normalizePath(path), valueString, valueTypeOf[T](), tx, true, false)
 	if err != nil {
-		tx.Rollback()
+		rollbackTx(tx)
 		panic(err)
 	}
 
-	err = tx.Commit()
+	err = commitTx(tx)
 	if err != nil {
-		tx.Rollback()
+		rollbackTx(tx)
 		panic(fmt.Errorf("error committing transaction - %w", err))
 	}
 }
@@ -296,75 +729,118 @@ func ForceOrPanic[T Stringable](path string, value T) {
 Get reads the value a the specified path and returns it as type T.
 */
 func Get[T Stringable](path string) (T, error) {
-	mutex.Lock()
-	defer mutex.Unlock()
+	return GetCtx[T](context.Background(), path)
+}
+
+/*
+GetCtx calls Get, tracing the operation as a child of ctx (see SetTracer).
+*/
+func GetCtx[T Stringable](ctx context.Context, path string) (value T, err error) {
+	ctx, span := startSpan(ctx, "camellia.Get", path)
+	defer func() {
+		span.End(err)
+	}()
 
-	var value T
+	mutex.RLock()
+	defer mutex.RUnlock()
 
 	if atomic.LoadInt32(&initialized) == 0 {
 		return value, ErrNoDB
 	}
 
-	tx, err := db.Begin()
-	if err != nil {
-		return value, fmt.Errorf("error beginning transaction - %w", err)
+	return get[T](ctx, path)
+}
+
+/*
+GetValue reads the value at the specified path and returns it as type T, which can be any type accepted by SetValue.
+Values are converted with the same conversion used to write them.
+
+	timeout, err := camellia.GetValue[time.Duration]("network/timeout")
+*/
+func GetValue[T any](path string) (value T, err error) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return value, ErrNoDB
 	}
 
-	valueString, err := getValue(normalizePath(path), tx)
+	return get[T](context.Background(), path)
+}
+
+/*
+get reads the value at path as type T. Must be called while the global mutex is held
+*/
+func get[T any](ctx context.Context, path string) (value T, err error) {
+	valueString, err := getValueString(ctx, path, valueTypeOf[T]())
 	if err != nil {
-		tx.Rollback()
 		return value, err
 	}
 
-	value, err = convertValue[T](valueString)
+	value, err = decodeValue[T](valueString)
 	if err != nil {
-		tx.Rollback()
 		return value, fmt.Errorf("error converting value %v to string - %w", value, err)
 	}
 
-	err = tx.Commit()
-	if err != nil {
-		tx.Rollback()
-		return value, fmt.Errorf("error committing transaction - %w", err)
-	}
-
 	return value, nil
 }
 
 /*
-GetOrPanic calls Get with the specified parameters, and panics in case of error.
+getValueString reads the value at path, which must be readable as the requested type, as a string. Buffered values
+(see Options.FlushInterval) are returned before they are written. Must be called while the global mutex is held
 */
-func GetOrPanic[T Stringable](path string) T {
-	mutex.Lock()
-	defer mutex.Unlock()
+func getValueString(ctx context.Context, path string, requested ValueType) (string, error) {
+	path = normalizePath(path)
 
-	var value T
+	if b, ok := buffered[path]; ok && !hasRuntimeValue(path) && !hasReferences(b.value, b.valueType) {
+		err := checkValueType(b.valueType, requested)
+		if err != nil {
+			return "", pathError("get", path, err)
+		}
 
-	if atomic.LoadInt32(&initialized) == 0 {
-		panic(ErrNoDB)
+		return b.value, nil
 	}
 
-	tx, err := db.Begin()
+	tx, err := beginReadTx(ctx)
 	if err != nil {
-		panic(fmt.Errorf("error beginning transaction - %w", err))
+		return "", fmt.Errorf("error beginning transaction - %w", err)
+	}
+
+	warnDeprecated(path, "get")
+
+	valueString, valueType, err := getVisibleValue(path, tx)
+	if err == nil {
+		err = checkValueType(valueType, requested)
 	}
 
-	valueString, err := getValue(normalizePath(path), tx)
 	if err != nil {
-		tx.Rollback()
-		panic(err)
+		rollbackTx(tx)
+		return "", pathError("get", path, err)
 	}
 
-	value, err = convertValue[T](valueString)
+	err = endReadTx(ctx, tx)
 	if err != nil {
-		tx.Rollback()
-		panic(fmt.Errorf("error converting value %v to string - %w", value, err))
+		rollbackTx(tx)
+		return "", fmt.Errorf("error committing transaction - %w", err)
+	}
+
+	return valueString, nil
+}
+
+/*
+GetOrPanic calls Get with the specified parameters, and panics in case of error.
+*/
+func GetOrPanic[T Stringable](path string) T {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		panic(ErrNoDB)
 	}
 
-	err = tx.Commit()
+	value, err := get[T](context.Background(), path)
 	if err != nil {
-		tx.Rollback()
-		panic(fmt.Errorf("error committing transaction - %w", err))
+		panic(err)
 	}
 
 	return value
@@ -374,41 +850,25 @@ func GetOrPanic[T Stringable](path string) T {
 GetOrPanic calls Get with the specified parameters, and panics if the read value is empty or in case of error.
 */
 func GetOrPanicEmpty[T Stringable](path string) T {
-	mutex.Lock()
-	defer mutex.Unlock()
+	mutex.RLock()
+	defer mutex.RUnlock()
 
 	if atomic.LoadInt32(&initialized) == 0 {
 		panic(ErrNoDB)
 	}
 
-	var value T
-
-	tx, err := db.Begin()
-	if err != nil {
-		panic(fmt.Errorf("error beginning transaction - %w", err))
-	}
-
-	valueString, err := getValue(normalizePath(path), tx)
+	valueString, err := getValueString(context.Background(), path, valueTypeOf[T]())
 	if err != nil {
-		tx.Rollback()
-		panic(fmt.Errorf("error getting value %s - %w", path, err))
+		panic(fmt.Errorf("error getting value %s - %w", normalizePath(path), err))
 	}
 
 	if valueString == "" {
-		tx.Rollback()
 		panic(ErrValueEmpty)
 	}
 
-	value, err = convertValue[T](valueString)
+	value, err := decodeValue[T](valueString)
 	if err != nil {
-		tx.Rollback()
-		panic(fmt.Errorf("error converting value %s - %w", path, err))
-	}
-
-	err = tx.Commit()
-	if err != nil {
-		tx.Rollback()
-		panic(fmt.Errorf("error committing transaction - %w", err))
+		panic(fmt.Errorf("error converting value %s - %w", normalizePath(path), err))
 	}
 
 	return value
@@ -429,61 +889,134 @@ With depth > 0, stops at the specified depth.
 
 With depth == 0, returns the Entry with an empty Children map.
 
+The children of the non-value Entries at the specified depth can then be loaded with LoadChildren.
+
 With depth < 0, returns the full hierarchy of children Entries.
 */
-func GetEntryDepth(path string, depth int) (*Entry, error) {
-	mutex.Lock()
-	defer mutex.Unlock()
+func GetEntryDepth(path string, depth int) (entry *Entry, err error) {
+	ctx, span := startSpan(context.Background(), "camellia.GetEntry", path)
+	defer func() {
+		span.End(err)
+	}()
+
+	mutex.RLock()
+	defer mutex.RUnlock()
 
 	if atomic.LoadInt32(&initialized) == 0 {
 		return nil, ErrNoDB
 	}
 
-	tx, err := db.Begin()
+	tx, err := beginReadTx(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("error beginning transaction - %w", err)
 	}
 
-	entry, err := getEntryDepth(normalizePath(path), depth, tx)
+	path = normalizePath(path)
+	entry, err = getEntryDepth(path, depth, tx)
+	entry, err = withRuntime(path, depth, entry, err)
+	if err == nil {
+		err = interpolateEntry(entry, tx)
+	}
+
 	if err != nil {
-		tx.Rollback()
-		return nil, err
+		rollbackTx(tx)
+		return nil, pathError("get", path, err)
 	}
 
-	err = tx.Commit()
+	err = endReadTx(ctx, tx)
 	if err != nil {
-		tx.Rollback()
+		rollbackTx(tx)
 		return nil, fmt.Errorf("error committing transaction - %w", err)
 	}
 
 	return entry, nil
 }
 
+/*
+GetStructure returns the Entry at the specified path like GetEntryDepth, but without reading the values: the Entries
+carry their path, last update, type, writer and revision, while Value is always empty. This allows showing the shape of
+hierarchies holding large values, like in browsers and completion engines, without loading them. References are not
+resolved, and the children loaded later with LoadChildren carry their values.
+*/
+func GetStructure(path string, depth int) (entry *Entry, err error) {
+	ctx, span := startSpan(context.Background(), "camellia.GetStructure", path)
+	defer func() {
+		span.End(err)
+	}()
+
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return nil, ErrNoDB
+	}
+
+	tx, err := beginReadTx(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error beginning transaction - %w", err)
+	}
+
+	path = normalizePath(path)
+	entry, err = getSubtreeEntry(path, depth, true, tx)
+	entry, err = withRuntime(path, depth, entry, err)
+	if err != nil {
+		rollbackTx(tx)
+		return nil, pathError("get", path, err)
+	}
+
+	err = endReadTx(ctx, tx)
+	if err != nil {
+		rollbackTx(tx)
+		return nil, fmt.Errorf("error committing transaction - %w", err)
+	}
+
+	// Runtime values are applied with their value
+	clearValues(entry)
+
+	return entry, nil
+}
+
+/*
+clearValues empties the values of entry and of its children
+*/
+func clearValues(entry *Entry) {
+	entry.Value = ""
+	for _, child := range entry.Children {
+		clearValues(child)
+	}
+}
+
 /*
 Exists returns whether an Entry exists at the specified path.
 */
 func Exists(path string) (bool, error) {
-	mutex.Lock()
-	defer mutex.Unlock()
+	mutex.RLock()
+	defer mutex.RUnlock()
 
 	if atomic.LoadInt32(&initialized) == 0 {
 		return false, ErrNoDB
 	}
 
-	tx, err := db.Begin()
+	tx, err := beginReadTx(context.Background())
 	if err != nil {
 		return false, fmt.Errorf("error beginning transaction - %w", err)
 	}
 
-	exists, err := exists(normalizePath(path), tx)
+	path = normalizePath(path)
+	if exists, ok := runtimeExists(path); ok {
+		rollbackTx(tx)
+		return exists, nil
+	}
+
+	exists, err := exists(path, tx)
 	if err != nil {
-		tx.Rollback()
+		rollbackTx(tx)
 		return false, err
 	}
 
-	err = tx.Commit()
+	err = endReadTx(context.Background(), tx)
 	if err != nil {
-		tx.Rollback()
+		rollbackTx(tx)
 		return false, fmt.Errorf("error committing transaction - %w", err)
 	}
 
@@ -494,28 +1027,43 @@ func Exists(path string) (bool, error) {
 Recurse recurses, breadth-first, the hierarchy of Entries at the specified path, starting with the Entry at the path.
 
 For each entry, calls the specified callback with the Entry itself, its parent Entry, and the current relative depth
-in the hierarchy, with 0 being the depth of the Entry at the specified path.
+in the hierarchy, with 0 being the depth of the Entry at the specified path. Only the Entries up to depth levels
+below the path are visited, or all of them if depth is negative. The visited hierarchy is read with a single query, so
+it is consistent even while other goroutines or processes write to the DB.
 */
 func Recurse(path string, depth int, cb func(entry *Entry, parent *Entry, depth uint) error) error {
-	mutex.Lock()
-	defer mutex.Unlock()
+	return RecurseCtx(context.Background(), path, depth, cb)
+}
+
+/*
+RecurseCtx calls Recurse, tracing the operation as a child of ctx (see SetTracer).
+*/
+func RecurseCtx(ctx context.Context, path string, depth int, cb func(entry *Entry, parent *Entry, depth uint) error) (
+	err error) {
+	ctx, span := startSpan(ctx, "camellia.Recurse", path)
+	defer func() {
+		span.End(err)
+	}()
+
+	mutex.RLock()
+	defer mutex.RUnlock()
 
 	if atomic.LoadInt32(&initialized) == 0 {
 		return ErrNoDB
 	}
 
-	tx, err := db.Begin()
+	tx, err := beginReadTx(ctx)
 	if err != nil {
 		return fmt.Errorf("error beginning transaction - %w", err)
 	}
 
 	err = recurse(normalizePath(path), depth, cb, tx)
 	if err != nil {
-		tx.Rollback()
-		return err
+		rollbackTx(tx)
+		return pathError("recurse", normalizePath(path), err)
 	}
 
-	err = tx.Commit()
+	err = endReadTx(ctx, tx)
 	if err != nil {
 		return fmt.Errorf("error committing transaction - %w", err)
 	}
@@ -534,18 +1082,24 @@ func Delete(path string) error {
 		return ErrNoDB
 	}
 
-	tx, err := db.Begin()
+	tx, err := beginTx()
 	if err != nil {
 		return fmt.Errorf("error beginning transaction - %w", err)
 	}
 
-	err = deleteEntry(normalizePath(path), tx)
+	err = deletePath(normalizePath(path), tx)
 	if err != nil {
-		tx.Rollback()
+		rollbackTx(tx)
 		return err
 	}
 
-	err = tx.Commit()
+	err = checkRequired(tx)
+	if err != nil {
+		rollbackTx(tx)
+		return err
+	}
+
+	err = commitTx(tx)
 	if err != nil {
 		return fmt.Errorf("error committing transaction - %w", err)
 	}
@@ -564,62 +1118,29 @@ func Wipe() error {
 		return ErrNoDB
 	}
 
-	tx, err := db.Begin()
+	tx, err := beginTx()
 	if err != nil {
 		return fmt.Errorf("error beginning transaction - %w", err)
 	}
 
 	root, err := getEntryDepth(normalizePath(""), 1, tx)
 	if err != nil {
+		rollbackTx(tx)
 		return err
 	}
 
 	for _, child := range root.Children {
-		err = deleteEntry(child.Path, tx)
+		err = deletePath(child.Path, tx)
 		if err != nil {
-			tx.Rollback()
+			rollbackTx(tx)
 			return err
 		}
 	}
 
-	err = tx.Commit()
+	err = commitTx(tx)
 	if err != nil {
 		return fmt.Errorf("error committing transaction - %w", err)
 	}
 
 	return nil
 }
-
-func convertValue[T Stringable](valueString string) (T, error) {
-	var value T
-
-	/*
-		TODO: See up
-
-		xValue := reflect.ValueOf(&value)
-		method := xValue.MethodByName("FromString")
-
-		if method.IsValid() {
-			ret := method.Call([]reflect.Value{reflect.ValueOf(valueString)})
-			if !ret[0].IsNil() {
-				err, ok := ret[1].Interface().(error)
-				if !ok {
-					return value, fmt.Errorf("error converting value to requested type")
-				} else {
-					return value, err
-				}
-			}
-		} else {
-	*/
-	n, err := fmt.Sscan(valueString, &value)
-	if n != 1 {
-		return value, fmt.Errorf("error converting value to requested type")
-	}
-
-	if err != nil {
-		return value, fmt.Errorf("error converting value to requested type - %w", err)
-	}
-	//}
-
-	return value, nil
-}
@@ -1,19 +1,33 @@
 package camellia
 
 import (
+	"archive/tar"
 	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"io/fs"
+	"net"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
 	"time"
 )
 
 var testDBPath string
 
-const currentDBVersion = 1
+const currentDBVersion = 2
 
 func resetDB(t *testing.T) {
 	if IsOpen() {
@@ -35,7 +49,7 @@ func resetDB(t *testing.T) {
 	}
 
 	err := Close()
-	if err != ErrNoDB {
+	if !errors.Is(err, ErrNoDB) {
 		t.FailNow()
 	}
 
@@ -215,60 +229,60 @@ func TestSetGet(t *testing.T) {
 
 	t.Log("Should return ErrPathIsNotAValue on getting the value of empty value (equals root path)")
 	_, err = Get[string]("")
-	if err != ErrPathIsNotAValue {
+	if !errors.Is(err, ErrPathIsNotAValue) {
 		t.FailNow()
 	}
 
 	t.Log("Should return ErrPathIsNotAValue on getting the value of root path")
 	_, err = Get[string]("/")
-	if err != ErrPathIsNotAValue {
+	if !errors.Is(err, ErrPathIsNotAValue) {
 		t.FailNow()
 	}
 
 	t.Log("Should return ErrPathInvalid on setting the value of empty path")
 	err = Set("", "a")
-	if err != ErrPathInvalid {
+	if !errors.Is(err, ErrPathInvalid) {
 		t.FailNow()
 	}
 
 	t.Log("Should return ErrPathInvalid on forcing the value of empty path")
 	err = Force("", "a")
-	if err != ErrPathInvalid {
+	if !errors.Is(err, ErrPathInvalid) {
 		t.FailNow()
 	}
 
 	t.Log("Should return ErrPathIsNotAValue on setting the value of / path")
 	err = Set("/", "a")
-	if err != ErrPathInvalid {
+	if !errors.Is(err, ErrPathInvalid) {
 		t.FailNow()
 	}
 
 	t.Log("Should return ErrPathInvalid on forcing the value of / path")
 	err = Force("/", "a")
-	if err != ErrPathInvalid {
+	if !errors.Is(err, ErrPathInvalid) {
 		t.FailNow()
 	}
 
 	t.Log("Should return ErrPathNotFound on getting value at non-existing path")
 	_, err = Get[string]("/a/b/c/d/e")
-	if err != ErrPathNotFound {
+	if !errors.Is(err, ErrPathNotFound) {
 		t.FailNow()
 	}
 
 	_, err = Get[string]("/z")
-	if err != ErrPathNotFound {
+	if !errors.Is(err, ErrPathNotFound) {
 		t.FailNow()
 	}
 
 	t.Log("Should return ErrPathIsNotAValue on getting the value of an entry that is not a value")
 	_, err = Get[string]("/a/b")
-	if err != ErrPathIsNotAValue {
+	if !errors.Is(err, ErrPathIsNotAValue) {
 		t.FailNow()
 	}
 
 	t.Log("Should return ErrPathIsNotAValue on setting the value of an entry that is not a value")
 	err = Set("/a/b", "b")
-	if err != ErrPathIsNotAValue {
+	if !errors.Is(err, ErrPathIsNotAValue) {
 		t.FailNow()
 	}
 
@@ -293,22 +307,22 @@ func TestSetGet(t *testing.T) {
 
 	t.Log("Should delete the children of an overwritten non-value entry")
 	_, err = Get[string]("/a1/b1/c1")
-	if err != ErrPathNotFound {
+	if !errors.Is(err, ErrPathNotFound) {
 		t.FailNow()
 	}
 
 	_, err = Get[string]("/a1/b1/c1/d1")
-	if err != ErrPathNotFound {
+	if !errors.Is(err, ErrPathNotFound) {
 		t.FailNow()
 	}
 
 	_, err = Get[string]("/a1/b1/c2")
-	if err != ErrPathNotFound {
+	if !errors.Is(err, ErrPathNotFound) {
 		t.FailNow()
 	}
 
 	_, err = Get[string]("/a1/b1/c2/d1")
-	if err != ErrPathNotFound {
+	if !errors.Is(err, ErrPathNotFound) {
 		t.FailNow()
 	}
 
@@ -414,8 +428,14 @@ func TestSetGet(t *testing.T) {
 	b1, err := GetEntry("a1/b1")
 	check(err, t)
 
+	if d := time.Since(b1.LastUpdate); d < -5*time.Second || d > 5*time.Second {
+		t.Fatalf("LastUpdate %v is not close to the current time", b1.LastUpdate)
+	}
+
 	oldTs := b1.LastUpdate
 
+	// Timestamps have millisecond resolution
+	time.Sleep(2 * time.Millisecond)
 	SetOrPanic("a1/b1/c2", "c2")
 
 	b1, err = GetEntry("a1/b1")
@@ -435,6 +455,7 @@ func TestSetGet(t *testing.T) {
 
 	oldTs = b1.LastUpdate
 
+	time.Sleep(2 * time.Millisecond)
 	err = Delete("a1/b1/c2")
 	check(err, t)
 
@@ -498,12 +519,12 @@ func TestDelete(t *testing.T) {
 
 	t.Log("Should return ErrPathInvalid on deleting the entry on root path")
 	err = Delete("/")
-	if err != ErrPathInvalid {
+	if !errors.Is(err, ErrPathInvalid) {
 		t.FailNow()
 	}
 
 	err = Delete("")
-	if err != ErrPathInvalid {
+	if !errors.Is(err, ErrPathInvalid) {
 		t.FailNow()
 	}
 
@@ -531,9 +552,6 @@ func TestDelete(t *testing.T) {
 	}
 }
 
-/*
-TODO: See api.go
-
 type TestData struct {
 	Prop1 string
 	Prop2 int
@@ -548,7 +566,6 @@ func (t *TestData) String() string {
 func (t *TestData) FromString(s string) error {
 	return json.Unmarshal([]byte(s), t)
 }
-*/
 
 func TestTypedSetGet(t *testing.T) {
 	resetDB(t)
@@ -568,14 +585,8 @@ func TestTypedSetGet(t *testing.T) {
 	err = Set("/v/bool", true)
 	check(err, t)
 
-	/*
-		TODO: See api.go
-
-		err = SetValue("/v/data", &TestData{Prop1: "Prop1", Prop2: 1234, Prop3: true})
-		if err != nil {
-			t.FailNow()
-		}
-	*/
+	err = SetValue("/v/data", TestData{Prop1: "Prop1", Prop2: 1234, Prop3: true})
+	check(err, t)
 
 	s, err := Get[string]("/v/string")
 	check(err, t)
@@ -607,504 +618,6377 @@ func TestTypedSetGet(t *testing.T) {
 		t.FailNow()
 	}
 
-	/*
-		TODO: See api.go
-
-		d, err := GetValue[TestData]("/v/data")
-		if err != nil {
-			t.FailNow()
-		}
+	d, err := GetValue[TestData]("/v/data")
+	if err != nil {
+		t.FailNow()
+	}
 
-		if d.Prop1 != "Prop1" {
-			t.FailNow()
-		}
+	if d.Prop1 != "Prop1" {
+		t.FailNow()
+	}
 
-		if d.Prop2 != 1234 {
-			t.FailNow()
-		}
+	if d.Prop2 != 1234 {
+		t.FailNow()
+	}
 
-		if !d.Prop3 {
-			t.FailNow()
-		}
+	if !d.Prop3 {
+		t.FailNow()
+	}
+}
 
-	*/
+type testPoint struct {
+	X int
+	Y int
 }
 
-func TestRecurse(t *testing.T) {
-	t.Log("Should recurse on an entry and on all of its children")
+func TestCodecs(t *testing.T) {
 	resetDB(t)
 
-	err := Set("/a1/b1/c1/d1", "d")
-	check(err, t)
+	t.Log("Should convert values with a registered codec")
 
-	err = Set("/a1/b1/c2/d1", "d")
+	RegisterCodec(func(p testPoint) (string, error) {
+		return fmt.Sprintf("%d,%d", p.X, p.Y), nil
+	}, func(s string) (testPoint, error) {
+		p := testPoint{}
+		_, err := fmt.Sscanf(s, "%d,%d", &p.X, &p.Y)
+		return p, err
+	})
+
+	err := SetValue("point", testPoint{X: 1, Y: -2})
 	check(err, t)
 
-	err = Set("/a2", "a")
+	s, err := Get[string]("point")
 	check(err, t)
+	if s != "1,-2" {
+		t.FailNow()
+	}
 
-	visited := map[string]bool{}
+	p, err := GetValue[testPoint]("point")
+	check(err, t)
+	if p.X != 1 || p.Y != -2 {
+		t.FailNow()
+	}
 
-	err = Recurse("/a1", -1, func(entry, parent *Entry, depth uint) error {
-		visited[entry.Path] = true
-		return nil
-	})
+	t.Log("Should convert values implementing encoding.TextMarshaler")
 
+	err = SetValue("ip", net.ParseIP("10.0.0.1"))
 	check(err, t)
 
-	if len(visited) != 6 {
+	s, err = Get[string]("ip")
+	check(err, t)
+	if s != "10.0.0.1" {
 		t.FailNow()
 	}
 
-	if !visited["a1"] || !visited["a1/b1"] || !visited["a1/b1/c1"] || !visited["a1/b1/c1/d1"] ||
-		!visited["a1/b1/c2"] || !visited["a1/b1/c2/d1"] {
+	ip, err := GetValue[net.IP]("ip")
+	check(err, t)
+	if !ip.Equal(net.ParseIP("10.0.0.1")) {
 		t.FailNow()
 	}
 
-	t.Log("Should recurse on an entry and on all of its children until a certain depth")
-	resetDB(t)
+	t.Log("Should convert pointers to values implementing CustomStringable")
 
-	err = Set("/a1/b1/c1/d1", "d")
+	err = SetValue("data", &TestData{Prop1: "Prop1"})
 	check(err, t)
 
-	err = Set("/a1/b1/c2/d1", "d")
+	d, err := GetValue[*TestData]("data")
 	check(err, t)
+	if d.Prop1 != "Prop1" {
+		t.FailNow()
+	}
 
-	err = Set("/a2", "a")
+	t.Log("Should get strings containing spaces")
+
+	err = Set("string", " a string ")
 	check(err, t)
 
-	visited = map[string]bool{}
+	s, err = Get[string]("string")
+	check(err, t)
+	if s != " a string " {
+		t.FailNow()
+	}
 
-	err = Recurse("/a1", 1, func(entry, parent *Entry, depth uint) error {
-		visited[entry.Path] = true
-		return nil
-	})
+	t.Log("Should fail on unsupported types")
+
+	err = SetValue("map", map[string]string{})
+	if !errors.Is(err, ErrUnsupportedType) {
+		t.FailNow()
+	}
+}
+
+func TestTimeValues(t *testing.T) {
+	resetDB(t)
+
+	t.Log("Should set and get durations")
 
+	err := Set("timeout", 90*time.Second)
 	check(err, t)
 
-	if len(visited) != 2 {
+	s, err := Get[string]("timeout")
+	check(err, t)
+	if s != "1m30s" {
 		t.FailNow()
 	}
 
-	if !visited["a1"] || !visited["a1/b1"] {
+	d, err := Get[time.Duration]("timeout")
+	check(err, t)
+	if d != 90*time.Second {
 		t.FailNow()
 	}
 
-	t.Log("Should report the error of the recurse callback")
-	resetDB(t)
+	t.Log("Should set and get times")
 
-	err = Set("a2", "a")
+	now := time.Date(2022, 1, 6, 17, 4, 5, 0, time.UTC)
+	err = SetValue("time", now)
 	check(err, t)
 
-	myError := fmt.Errorf("error1234")
+	s, err = Get[string]("time")
+	check(err, t)
+	if s != "2022-01-06T17:04:05Z" {
+		t.FailNow()
+	}
 
-	err = Recurse("a2", 1, func(entry, parent *Entry, depth uint) error {
-		return myError
-	})
+	tm, err := GetValue[time.Time]("time")
+	check(err, t)
+	if !tm.Equal(now) {
+		t.FailNow()
+	}
 
-	if !errors.Is(err, myError) {
+	t.Log("Should map durations in structs and lists")
+
+	type timeouts struct {
+		Read  time.Duration   `cml:"read"`
+		Retry []time.Duration `cml:"retry"`
+	}
+
+	err = SetStruct("timeouts", timeouts{Read: time.Second, Retry: []time.Duration{time.Millisecond, time.Minute}})
+	check(err, t)
+
+	read := timeouts{}
+	err = GetStruct("timeouts", &read)
+	check(err, t)
+	if read.Read != time.Second || len(read.Retry) != 2 || read.Retry[1] != time.Minute {
+		t.FailNow()
+	}
+
+	_, err = Get[time.Duration]("time")
+	if err == nil {
 		t.FailNow()
 	}
 }
 
-func TestToJSON(t *testing.T) {
+func TestBytes(t *testing.T) {
 	resetDB(t)
 
-	t.Log("Should convert the root Entry to JSON")
+	data := []byte{0x00, 0xff, 0x10, 'a', 0x00, '\n'}
 
-	err := Set("/a1/b1/c1/d1", "d1")
-	check(err, t)
+	t.Log("Should set and get binary values")
 
-	err = Set("/a1/b1/c1/d2", "d2")
+	err := SetBytes("certs/ca", data)
 	check(err, t)
 
-	err = Set("/a1/b1/c2/d1", "d1")
+	read, err := GetBytes("certs/ca")
 	check(err, t)
+	if !bytes.Equal(read, data) {
+		t.FailNow()
+	}
 
-	err = Set("/a1/b1/c2/d2", "d2")
+	entry, err := GetEntry("certs/ca")
 	check(err, t)
+	if entry.Type != TypeBytes || entry.Value != base64.StdEncoding.EncodeToString(data) {
+		t.FailNow()
+	}
 
-	err = Set("/a2/b1", "b1")
+	t.Log("Should get text values as bytes")
+
+	err = Set("certs/name", "ca")
 	check(err, t)
 
-	err = Set("/a3", "a3")
+	read, err = GetBytes("certs/name")
 	check(err, t)
+	if string(read) != "ca" {
+		t.FailNow()
+	}
 
-	j, err := EntryToJSON("")
+	t.Log("Should export binary values as base64 and import them back")
+
+	buf := bytes.Buffer{}
+	err = ExportJSON("", &buf, ExportOptions{Extended: true})
 	check(err, t)
 
-	var entries Entry
-	err = json.Unmarshal([]byte(j), &entries)
+	resetDB(t)
+
+	err = ImportJSON(&buf, ImportOptions{Extended: true})
 	check(err, t)
 
-	if entries.Children["a1"] == nil ||
-		entries.Children["a1"].Children["b1"] == nil ||
-		entries.Children["a1"].Children["b1"].Children["c1"] == nil ||
-		entries.Children["a1"].Children["b1"].Children["c1"].Children["d1"] == nil ||
-		entries.Children["a1"].Children["b1"].Children["c1"].Children["d2"] == nil ||
-		entries.Children["a1"].Children["b1"].Children["c2"].Children["d1"] == nil ||
-		entries.Children["a2"].Children["b1"] == nil ||
-		entries.Children["a3"] == nil {
+	read, err = GetBytes("certs/ca")
+	check(err, t)
+	if !bytes.Equal(read, data) {
 		t.FailNow()
 	}
+}
 
-	if entries.Children["a1"].Children["b1"].Children["c1"].Children["d1"].Value != "d1" {
-		t.FailNow()
-	}
+func TestStreams(t *testing.T) {
+	resetDB(t)
 
-	if entries.Children["a1"].Children["b1"].Children["c1"].Children["d2"].Value != "d2" {
-		t.FailNow()
+	data := make([]byte, streamChunkSize*3+123)
+	for i := range data {
+		data[i] = byte(i * 7)
 	}
 
-	if entries.Children["a1"].Children["b1"].Children["c2"].Children["d1"].Value != "d1" {
-		t.FailNow()
-	}
+	t.Log("Should set and read streamed values")
 
-	if entries.Children["a1"].Children["b1"].Children["c2"].Children["d2"].Value != "d2" {
+	err := SetReader("logs/boot", bytes.NewReader(data))
+	check(err, t)
+
+	r, err := GetReader("logs/boot")
+	check(err, t)
+
+	read, err := io.ReadAll(r)
+	check(err, t)
+	if !bytes.Equal(read, data) {
 		t.FailNow()
 	}
 
-	if entries.Children["a2"].Children["b1"].Value != "b1" {
+	err = r.Close()
+	check(err, t)
+
+	read, err = GetBytes("logs/boot")
+	check(err, t)
+	if !bytes.Equal(read, data) {
 		t.FailNow()
 	}
 
-	if entries.Children["a3"].Value != "a3" {
+	entry, err := GetEntry("logs/boot")
+	check(err, t)
+	if entry.Type != TypeStream || entry.Value != "" {
 		t.FailNow()
 	}
 
-	t.Log("Should convert all the values from root Entry to JSON")
+	t.Log("Should export streamed values as base64 and import them back")
+
+	buf := bytes.Buffer{}
+	err = ExportJSON("", &buf, ExportOptions{Extended: true})
+	check(err, t)
 
 	resetDB(t)
 
-	err = Set("/a1/b1/c1", "c1")
+	err = ImportJSON(&buf, ImportOptions{Extended: true})
 	check(err, t)
 
-	err = Set("/a1/b1/c2", "c2")
+	read, err = GetBytes("logs/boot")
 	check(err, t)
+	if !bytes.Equal(read, data) {
+		t.FailNow()
+	}
 
-	err = Set("/a1/b2/c1", "c1")
-	check(err, t)
+	t.Log("Should replace streamed values")
 
-	j, err = ValuesToJSON("")
+	err = Set("logs/boot", "empty")
 	check(err, t)
 
-	ji := make(map[string]interface{})
-	err = json.Unmarshal([]byte(j), &ji)
+	r, err = GetReader("logs/boot")
 	check(err, t)
 
-	jb, err := json.Marshal(ji)
+	read, err = io.ReadAll(r)
 	check(err, t)
+	if string(read) != "empty" {
+		t.FailNow()
+	}
 
-	compare := make(map[string]map[string]map[string]interface{})
-	compare["a1"] = make(map[string]map[string]interface{})
-	compare["a1"]["b1"] = make(map[string]interface{})
-	compare["a1"]["b1"]["c1"] = "c1"
-	compare["a1"]["b1"]["c2"] = "c2"
-	compare["a1"]["b2"] = make(map[string]interface{})
-	compare["a1"]["b2"]["c1"] = "c1"
-
-	jCompare, err := json.Marshal(&compare)
+	err = SetReader("logs/boot", strings.NewReader(""))
 	check(err, t)
 
-	if string(jb) != string(jCompare) {
+	read, err = GetBytes("logs/boot")
+	check(err, t)
+	if len(read) != 0 {
 		t.FailNow()
 	}
-
 }
 
-func TestFromJson(t *testing.T) {
-	t.Log("Should import values from JSON file")
-
+func TestChecksums(t *testing.T) {
 	resetDB(t)
 
-	j := `
-{
-	"a1": {
-		"b1": {
-			"c1": "c1",
-			"c2": "c2"
-		},
-		"b2": "overwritten"
-	},
-	"a2": "a2"
-}
-`
-	buf := bytes.Buffer{}
-	buf.WriteString(j)
+	err := Close()
+	check(err, t)
 
-	err := Set("a1/b2", "original")
+	_, err = OpenWithOptions(testDBPath, Options{Checksums: true})
 	check(err, t)
 
-	err = Set("a1/b3", "original")
+	defer func() {
+		Close()
+		Open(testDBPath)
+	}()
+
+	t.Log("Should verify values written with checksums")
+
+	err = Set("a/b", "value")
 	check(err, t)
 
-	err = SetValuesFromJSON(&buf, false)
+	err = SetBytes("a/c", []byte{1, 2, 3})
 	check(err, t)
 
-	v, err := Get[string]("a1/b1/c1")
+	err = SetReader("a/d", bytes.NewReader(make([]byte, streamChunkSize+1)))
 	check(err, t)
 
-	if v != "c1" {
+	corrupted, err := Verify()
+	check(err, t)
+	if len(corrupted) != 0 {
 		t.FailNow()
 	}
 
-	v, err = Get[string]("a1/b1/c2")
+	t.Log("Should detect corrupted values")
+
+	_, err = db.Exec("UPDATE camellia SET value = 'valeu' WHERE path = 'a/b'")
 	check(err, t)
 
-	if v != "c2" {
+	_, err = db.Exec("UPDATE camellia_chunks SET data = x'01' WHERE path = 'a/d' AND seq = 1")
+	check(err, t)
+
+	_, err = Get[string]("a/b")
+	if !errors.Is(err, ErrValueCorrupted) {
 		t.FailNow()
 	}
 
-	v, err = Get[string]("a2")
-	check(err, t)
+	_, err = GetEntry("a")
+	if !errors.Is(err, ErrValueCorrupted) {
+		t.FailNow()
+	}
 
-	if v != "a2" {
+	_, err = GetBytes("a/d")
+	if !errors.Is(err, ErrValueCorrupted) {
 		t.FailNow()
 	}
 
-	v, err = Get[string]("a1/b2")
+	corrupted, err = Verify()
 	check(err, t)
-
-	if v != "overwritten" {
+	if len(corrupted) != 2 || corrupted[0] != "a/b" || corrupted[1] != "a/d" {
 		t.FailNow()
 	}
 
-	v, err = Get[string]("a1/b3")
+	t.Log("Should fix corrupted values when rewritten")
+
+	err = Set("a/b", "value")
 	check(err, t)
 
-	if v != "original" {
+	err = Delete("a/d")
+	check(err, t)
+
+	corrupted, err = Verify()
+	check(err, t)
+	if len(corrupted) != 0 {
 		t.FailNow()
 	}
+}
 
-	t.Log("Should import entries from JSON file")
-
+func TestEncryption(t *testing.T) {
 	resetDB(t)
 
-	j = `
-{
-	"children": {
-		"a1": {
-			"children": {
-				"b1": {
-					"children": {
-						"c1": {
-							"value": "c1"
-						},
-						"c2": {
-							"value": "c2"
-						}
-					}
-				}
-			}
-		},
-		"a2": {
-			"value": "a2"
+	err := Close()
+	check(err, t)
+
+	defer Open(testDBPath)
+
+	encryptedDBPath := testDBPath + ".enc"
+	defer os.Remove(encryptedDBPath)
+
+	_, err = OpenWithOptions(encryptedDBPath, Options{EncryptionKey: "secret"})
+	if errors.Is(err, ErrEncryptionUnsupported) {
+		t.Log("Should refuse to open an encrypted DB without SQLCipher")
+
+		if IsOpen() {
+			t.FailNow()
+		}
+
+		t.Log("Should refuse to rotate the key of an unencrypted DB")
+
+		_, err = Open(encryptedDBPath)
+		check(err, t)
+
+		err = RotateKey("", "secret")
+		if !errors.Is(err, ErrInvalidKey) {
+			t.FailNow()
 		}
+
+		err = Close()
+		check(err, t)
+
+		return
 	}
-}
-`
+	check(err, t)
 
-	buf = bytes.Buffer{}
-	buf.WriteString(j)
+	t.Log("Should read an encrypted DB only with the right key")
 
-	err = SetEntriesFromJSON(&buf, false)
+	err = Set("a", "secret value")
 	check(err, t)
 
-	v, err = Get[string]("a1/b1/c1")
+	err = Close()
 	check(err, t)
-	if v != "c1" {
+
+	_, err = OpenWithOptions(encryptedDBPath, Options{EncryptionKey: "wrong"})
+	if !errors.Is(err, ErrInvalidKey) {
 		t.FailNow()
 	}
 
-	v, err = Get[string]("a1/b1/c2")
+	_, err = OpenWithOptions(encryptedDBPath, Options{EncryptionKey: "secret"})
 	check(err, t)
-	if v != "c2" {
+
+	v, err := Get[string]("a")
+	check(err, t)
+	if v != "secret value" {
 		t.FailNow()
 	}
 
-	v, err = Get[string]("a2")
-	check(err, t)
-	if v != "a2" {
+	t.Log("Should rotate the encryption key")
+
+	err = RotateKey("wrong", "new secret")
+	if !errors.Is(err, ErrInvalidKey) {
 		t.FailNow()
 	}
 
-	t.Log("Should only merge values from JSON file")
+	err = RotateKey("secret", "new secret")
+	check(err, t)
 
-	resetDB(t)
+	v, err = Get[string]("a")
+	check(err, t)
+	if v != "secret value" {
+		t.FailNow()
+	}
 
-	j = `
-{
-	"e1": {
-		"e2": "merged"
-	},
-	"n1": {
-		"n2": "merged"
+	err = Close()
+	check(err, t)
+
+	_, err = OpenWithOptions(encryptedDBPath, Options{EncryptionKey: "secret"})
+	if !errors.Is(err, ErrInvalidKey) {
+		t.FailNow()
 	}
+
+	_, err = OpenWithOptions(encryptedDBPath, Options{EncryptionKey: "new secret"})
+	check(err, t)
+
+	err = Close()
+	check(err, t)
 }
-`
-	err = Set("e1/e2", "original")
+
+func TestSchema(t *testing.T) {
+	resetDB(t)
+
+	defer SetSchema(nil)
+
+	s, err := LoadSchema(strings.NewReader(`{
+		"network/mtu": { "type": "int", "min": 576, "max": 9000, "required": true },
+		"network/hostname": { "type": "string", "pattern": "^[a-z0-9-]+$" },
+		"network/dns": { "type": "list", "max": 2 },
+		"network/interfaces/*": { "type": "bool" }
+	}`))
 	check(err, t)
 
-	buf = bytes.Buffer{}
-	buf.WriteString(j)
+	err = Set("network/mtu", 1500)
+	check(err, t)
 
-	err = SetValuesFromJSON(&buf, true)
+	err = SetSchema(s)
 	check(err, t)
 
-	v, err = Get[string]("e1/e2")
+	t.Log("Should accept valid values")
+
+	err = Set("network/mtu", 9000)
 	check(err, t)
-	if v != "original" {
+
+	err = Set("network/hostname", "device-1")
+	check(err, t)
+
+	err = SetList("network/dns", []string{"1.1.1.1", "8.8.8.8"})
+	check(err, t)
+
+	err = Set("network/interfaces/eth0", true)
+	check(err, t)
+
+	t.Log("Should reject invalid values")
+
+	var vErr *ValidationError
+
+	err = Set("network/mtu", 9001)
+	if !errors.As(err, &vErr) || vErr.Path != "network/mtu" || !errors.Is(err, ErrValidationFailed) {
 		t.FailNow()
 	}
 
-	v, err = Get[string]("n1/n2")
+	err = Force("network/mtu", "big")
+	if !errors.As(err, &vErr) {
+		t.FailNow()
+	}
+
+	err = Set("network/hostname", "Device 1")
+	if !errors.As(err, &vErr) || vErr.Pattern != "network/hostname" {
+		t.FailNow()
+	}
+
+	err = AppendToList("network/dns", "9.9.9.9")
+	if !errors.As(err, &vErr) {
+		t.FailNow()
+	}
+
+	err = Set("network/interfaces/eth1", "maybe")
+	if !errors.As(err, &vErr) || vErr.Path != "network/interfaces/eth1" {
+		t.FailNow()
+	}
+
+	err = SetValuesFromJSON(strings.NewReader(`{"network": {"mtu": "100"}}`), false)
+	if !errors.As(err, &vErr) {
+		t.FailNow()
+	}
+
+	v, err := Get[int]("network/mtu")
 	check(err, t)
-	if v != "merged" {
+	if v != 9000 {
 		t.FailNow()
 	}
 
-	t.Log("Should only merge entries from JSON file")
+	t.Log("Should enforce required paths")
 
-	resetDB(t)
+	err = Delete("network/mtu")
+	if !errors.As(err, &vErr) || vErr.Path != "network/mtu" {
+		t.FailNow()
+	}
 
-	j = `
-{
-	"children": {
-		"e1": {
-			"children": {
-				"e2": {
-					"value": "merged"
-				}
-			}
-		},
-		"n1": {
-			"children": {
-				"n2": {
-					"value": "merged"
-				}
-			}
-		}
+	err = Delete("network")
+	if !errors.As(err, &vErr) {
+		t.FailNow()
 	}
-}
-`
-	err = Set("e1/e2", "original")
+
+	t.Log("Should validate values already in the DB")
+
+	err = SetSchema(nil)
 	check(err, t)
 
-	buf = bytes.Buffer{}
-	buf.WriteString(j)
+	err = Set("network/mtu", 1)
+	check(err, t)
 
-	err = SetEntriesFromJSON(&buf, true)
+	err = Delete("network/hostname")
 	check(err, t)
 
-	v, err = Get[string]("e1/e2")
+	err = SetSchema(s)
 	check(err, t)
-	if v != "original" {
+
+	violations, err := Validate()
+	check(err, t)
+	if len(violations) != 1 || violations[0].Path != "network/mtu" {
 		t.FailNow()
 	}
 
-	v, err = Get[string]("n1/n2")
+	err = Wipe()
 	check(err, t)
-	if v != "merged" {
+
+	violations, err = Validate()
+	check(err, t)
+	if len(violations) != 1 || violations[0].Reason != "required path is missing" {
 		t.FailNow()
 	}
 }
 
-func testHooks(t *testing.T, shouldBeCalled bool) {
+func TestValidators(t *testing.T) {
 	resetDB(t)
 
-	wipeHooks()
+	errNegative := errors.New("negative age")
 
-	var p = "a/b/hook"
-	const v = "called"
+	unregister, err := RegisterValidator("users/*/age", func(path, value string) error {
+		if strings.HasPrefix(value, "-") {
+			return errNegative
+		}
 
-	err := Set(p, "a")
+		return nil
+	})
 	check(err, t)
 
-	var preCalled, postCalled bool
+	t.Log("Should reject invalid values with a ValidationError")
 
-	SetPreSetHook(p, func(path, value string) error {
-		if value != v {
-			return fmt.Errorf("hook value is different")
-		}
+	err = Set("users/alice/age", 30)
+	check(err, t)
 
-		if path != p {
-			return fmt.Errorf("hook path is different")
-		}
+	err = Set("users/alice/age", -1)
+	var vErr *ValidationError
+	if !errors.As(err, &vErr) || vErr.Path != "users/alice/age" || vErr.Pattern != "users/*/age" {
+		t.FailNow()
+	}
 
-		preCalled = true
+	if !errors.Is(err, ErrValidationFailed) || !errors.Is(err, errNegative) {
+		t.FailNow()
+	}
 
-		return nil
-	})
+	t.Log("Should roll back the whole operation")
 
-	SetPostSetHook(p, func(path, value string) error {
-		if value != v {
-			return fmt.Errorf("hook value is different")
-		}
+	err = SetValuesFromJSON(strings.NewReader(`{"users": {"bob": {"age": "20"}, "carol": {"age": "-3"}}}`), false)
+	if !errors.Is(err, errNegative) {
+		t.FailNow()
+	}
 
-		if path != p {
-			return fmt.Errorf("hook path is different")
-		}
+	e, err := Exists("users/bob")
+	check(err, t)
+	if e {
+		t.FailNow()
+	}
 
-		postCalled = true
+	t.Log("Should not call unregistered validators")
 
-		return nil
-	}, false)
+	unregister()
 
-	err = Set(p, v)
+	err = Set("users/alice/age", -1)
 	check(err, t)
+}
 
-	if shouldBeCalled != preCalled || shouldBeCalled != postCalled {
-		t.FailNow()
-	}
+func TestStrictTypes(t *testing.T) {
+	resetDB(t)
 
-	t.Log("Should call async post set hook")
+	err := Close()
+	check(err, t)
 
-	resetDB(t)
+	_, err = OpenWithOptions(testDBPath, Options{StrictTypes: true})
+	check(err, t)
 
-	p = "a/b/asyncHook"
-	postCalled = false
-	c := make(chan interface{})
+	defer func() {
+		Close()
+		Open(testDBPath)
+	}()
 
-	SetPostSetHook(p, func(path, value string) error {
-		if value != v {
-			return fmt.Errorf("hook value is different")
-		}
+	t.Log("Should store the type of values")
 
-		if path != p {
-			return fmt.Errorf("hook path is different")
-		}
+	err = Set("a/string", "42")
+	check(err, t)
 
-		postCalled = true
-		c <- nil
+	err = Set("a/int", 42)
+	check(err, t)
 
-		return nil
-	}, true)
+	err = Set("a/duration", time.Second)
+	check(err, t)
 
-	err = Set(p, v)
+	e, err := GetEntry("a/int")
 	check(err, t)
+	if e.Type != TypeInt {
+		t.FailNow()
+	}
 
-	timer := time.NewTimer(1 * time.Second)
+	t.Log("Should refuse to read values as a different type")
 
-	select {
-	case <-timer.C:
-	case <-c:
+	_, err = Get[int]("a/string")
+	if !errors.Is(err, ErrTypeMismatch) {
+		t.FailNow()
 	}
 
-	if shouldBeCalled != postCalled {
+	_, err = Get[string]("a/int")
+	if !errors.Is(err, ErrTypeMismatch) {
+		t.FailNow()
+	}
+
+	type strict struct {
+		String int `cml:"string"`
+	}
+
+	err = GetStruct("a", &strict{})
+	if !errors.Is(err, ErrTypeMismatch) {
+		t.FailNow()
+	}
+
+	t.Log("Should read values as the same type, ints as floats and untyped values as any type")
+
+	s, err := Get[string]("a/string")
+	check(err, t)
+	if s != "42" {
+		t.FailNow()
+	}
+
+	f, err := Get[float64]("a/int")
+	check(err, t)
+	if f != 42 {
+		t.FailNow()
+	}
+
+	d, err := Get[time.Duration]("a/duration")
+	check(err, t)
+	if d != time.Second {
 		t.FailNow()
 	}
 
+	err = SetValuesFromJSON(strings.NewReader(`{"b": 7}`), false)
+	check(err, t)
+
+	i, err := Get[int]("b")
+	check(err, t)
+	if i != 7 {
+		t.FailNow()
+	}
 }
 
-func TestHooks(t *testing.T) {
-	t.Log("Should call hooks by default")
-	testHooks(t, true)
+func TestNull(t *testing.T) {
+	resetDB(t)
 
-	t.Log("Should not call hooks")
-	SetHooksEnabled(false)
-	testHooks(t, false)
+	t.Log("Should distinguish null values from empty strings")
 
-	t.Log("Should call hooks")
-	SetHooksEnabled(true)
-	testHooks(t, true)
+	err := SetNull("proxy/host")
+	check(err, t)
+
+	err = Set("proxy/user", "")
+	check(err, t)
+
+	null, err := IsNull("proxy/host")
+	check(err, t)
+	if !null {
+		t.FailNow()
+	}
+
+	null, err = IsNull("proxy/user")
+	check(err, t)
+	if null {
+		t.FailNow()
+	}
+
+	entry, err := GetEntry("proxy")
+	check(err, t)
+	if !entry.Children["host"].IsNull() || entry.Children["user"].IsNull() || entry.IsNull() {
+		t.FailNow()
+	}
+
+	v, err := Get[string]("proxy/host")
+	check(err, t)
+	if v != "" {
+		t.FailNow()
+	}
+
+	t.Log("Should export and import null values as JSON nulls")
+
+	j, err := ValuesToJSON("proxy")
+	check(err, t)
+
+	var exported map[string]interface{}
+	err = json.Unmarshal([]byte(j), &exported)
+	check(err, t)
+	if h, ok := exported["host"]; !ok || h != nil || exported["user"] != "" {
+		t.FailNow()
+	}
+
+	resetDB(t)
+
+	err = SetValuesFromJSON(strings.NewReader(j), false)
+	check(err, t)
+
+	null, err = IsNull("host")
+	check(err, t)
+	if !null {
+		t.FailNow()
+	}
+}
+
+type testLogger struct {
+	mutex    sync.Mutex
+	infos    []string
+	warnings []string
+	errors   []string
+}
+
+func (l *testLogger) Debug(msg string, args ...any) {}
+
+func (l *testLogger) Info(msg string, args ...any) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.infos = append(l.infos, fmt.Sprint(append([]any{msg}, args...)...))
+}
+
+func (l *testLogger) Warn(msg string, args ...any) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.warnings = append(l.warnings, fmt.Sprint(append([]any{msg}, args...)...))
+}
+
+func (l *testLogger) Error(msg string, args ...any) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.errors = append(l.errors, fmt.Sprint(append([]any{msg}, args...)...))
+}
+
+func TestDeprecations(t *testing.T) {
+	resetDB(t)
+
+	l := &testLogger{}
+	SetLogger(l)
+	defer SetLogger(nil)
+
+	err := Set("network/ip", "10.0.0.1")
+	check(err, t)
+
+	t.Log("Should mark paths as deprecated")
+
+	err = Deprecate("network", "net")
+	check(err, t)
+
+	d, err := GetDeprecation("network/ip")
+	check(err, t)
+	if d == nil || d.Path != "network" || d.Replacement != "net/ip" {
+		t.FailNow()
+	}
+
+	d, err = GetDeprecation("other")
+	check(err, t)
+	if d != nil {
+		t.FailNow()
+	}
+
+	t.Log("Should warn when accessing deprecated paths")
+
+	v, err := Get[string]("network/ip")
+	check(err, t)
+	if v != "10.0.0.1" || len(l.warnings) != 1 || !strings.Contains(l.warnings[0], "net/ip") {
+		t.FailNow()
+	}
+
+	err = Set("network/mask", "255.0.0.0")
+	check(err, t)
+
+	_, err = GetEntry("network")
+	check(err, t)
+
+	err = Set("other", "value")
+	check(err, t)
+
+	if len(l.warnings) != 3 {
+		t.FailNow()
+	}
+
+	t.Log("Should persist deprecations")
+
+	err = Close()
+	check(err, t)
+
+	_, err = Open(testDBPath)
+	check(err, t)
+
+	deprecations, err := GetDeprecations()
+	check(err, t)
+	if len(deprecations) != 1 || deprecations[0].Path != "network" {
+		t.FailNow()
+	}
+
+	err = Undeprecate("network")
+	check(err, t)
+
+	_, err = Get[string]("network/ip")
+	check(err, t)
+	if len(l.warnings) != 3 {
+		t.FailNow()
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	resetDB(t)
+
+	t.Log("Should commit all the operations of a transaction")
+
+	err := Update(func(tx *Tx) error {
+		err := tx.Set("a/b", 1)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Set("a/c/d", "d")
+		if err != nil {
+			return err
+		}
+
+		return tx.Move("a", "x/a")
+	})
+	check(err, t)
+
+	v, err := Get[int]("x/a/b")
+	check(err, t)
+	if v != 1 {
+		t.FailNow()
+	}
+
+	e, err := Exists("a")
+	check(err, t)
+	if e {
+		t.FailNow()
+	}
+
+	t.Log("Should roll back all the operations of a failed transaction")
+
+	err = Update(func(tx *Tx) error {
+		err := tx.Delete("x")
+		if err != nil {
+			return err
+		}
+
+		return fmt.Errorf("failure")
+	})
+	if err == nil {
+		t.FailNow()
+	}
+
+	s, err := Get[string]("x/a/c/d")
+	check(err, t)
+	if s != "d" {
+		t.FailNow()
+	}
+}
+
+func TestConfigMigrations(t *testing.T) {
+	resetDB(t)
+
+	err := RegisterConfigMigration(0, 1, func(tx *Tx) error {
+		return tx.Set("network/ip", "10.0.0.1")
+	})
+	check(err, t)
+
+	err = RegisterConfigMigration(1, 2, func(tx *Tx) error {
+		return tx.Set("network/mask", "255.0.0.0")
+	})
+	check(err, t)
+
+	err = RegisterConfigMigration(1, 3, func(tx *Tx) error {
+		return tx.Move("network", "net")
+	})
+	check(err, t)
+
+	err = RegisterConfigMigration(3, 4, func(tx *Tx) error {
+		err := tx.Delete("net")
+		if err != nil {
+			return err
+		}
+
+		return fmt.Errorf("broken migration")
+	})
+	check(err, t)
+
+	t.Log("Should apply the chain of migrations reaching the target version")
+
+	steps, err := MigrateConfig(3)
+	check(err, t)
+	if len(steps) != 2 || steps[0] != 1 || steps[1] != 3 {
+		t.FailNow()
+	}
+
+	version, err := GetConfigVersion()
+	check(err, t)
+	if version != 3 {
+		t.FailNow()
+	}
+
+	v, err := Get[string]("net/ip")
+	check(err, t)
+	if v != "10.0.0.1" {
+		t.FailNow()
+	}
+
+	steps, err = MigrateConfig(3)
+	check(err, t)
+	if len(steps) != 0 {
+		t.FailNow()
+	}
+
+	t.Log("Should roll back failed migrations")
+
+	_, err = MigrateConfig(4)
+	if err == nil {
+		t.FailNow()
+	}
+
+	_, err = MigrateConfig(2)
+	if !errors.Is(err, ErrConfigMigrationNotFound) {
+		t.FailNow()
+	}
+
+	version, err = GetConfigVersion()
+	check(err, t)
+	if version != 3 {
+		t.FailNow()
+	}
+
+	e, err := Exists("net/ip")
+	check(err, t)
+	if !e {
+		t.FailNow()
+	}
+}
+
+func TestACL(t *testing.T) {
+	resetDB(t)
+	defer SetACL(nil)
+
+	acl, err := LoadACL(strings.NewReader(`[
+		{ "principal": "network-manager", "path": "network", "read": true, "write": true },
+		{ "principal": "*", "path": "network/interfaces/*/ip", "read": true }
+	]`))
+	check(err, t)
+
+	err = SetACL(acl)
+	check(err, t)
+
+	t.Log("Should allow granted principals")
+
+	err = UpdateAs("network-manager", func(tx *Tx) error {
+		return tx.Set("network/interfaces/eth0/ip", "10.0.0.1")
+	})
+	check(err, t)
+
+	err = UpdateAs("ui", func(tx *Tx) error {
+		v, err := tx.Get("network/interfaces/eth0/ip")
+		if err != nil {
+			return err
+		}
+
+		if v != "10.0.0.1" {
+			return fmt.Errorf("unexpected value %s", v)
+		}
+
+		return nil
+	})
+	check(err, t)
+
+	t.Log("Should deny anything not granted")
+
+	err = UpdateAs("ui", func(tx *Tx) error {
+		return tx.Set("network/interfaces/eth0/ip", "10.0.0.2")
+	})
+	if !errors.Is(err, ErrAccessDenied) {
+		t.FailNow()
+	}
+
+	err = UpdateAs("ui", func(tx *Tx) error {
+		_, err := tx.GetEntry("network")
+		return err
+	})
+	if !errors.Is(err, ErrAccessDenied) {
+		t.FailNow()
+	}
+
+	err = UpdateAs("network-manager", func(tx *Tx) error {
+		return tx.Move("network", "net")
+	})
+	if !errors.Is(err, ErrAccessDenied) {
+		t.FailNow()
+	}
+
+	t.Log("Should not restrict the rest of the API")
+
+	err = Set("network/interfaces/eth0/ip", "10.0.0.3")
+	check(err, t)
+
+	_, err = LoadACL(strings.NewReader(`[{ "principal": "*", "path": "[" }]`))
+	if err == nil {
+		t.FailNow()
+	}
+}
+
+func TestWriter(t *testing.T) {
+	resetDB(t)
+	defer SetWriter("")
+
+	t.Log("Should record the writer of the handle")
+
+	SetWriter("component-a")
+
+	err := Set("a/b", "v1")
+	check(err, t)
+
+	entry, err := GetEntry("a")
+	check(err, t)
+	if entry.Writer != "component-a" || entry.Children["b"].Writer != "component-a" {
+		t.FailNow()
+	}
+
+	t.Log("Should record the writer of a transaction")
+
+	err = Update(func(tx *Tx) error {
+		tx.SetWriter("component-b")
+		return tx.Set("a/b", "v2")
+	})
+	check(err, t)
+
+	entry, err = GetEntry("a/b")
+	check(err, t)
+	if entry.Writer != "component-b" {
+		t.FailNow()
+	}
+
+	err = ImportJSON(strings.NewReader(`{"a": {"c": "v3"}}`), ImportOptions{Writer: "importer"})
+	check(err, t)
+
+	entry, err = GetEntry("a/c")
+	check(err, t)
+	if entry.Writer != "importer" {
+		t.FailNow()
+	}
+
+	t.Log("Should export the writer in the extended format only")
+
+	j, err := EntryToJSON("a/c")
+	check(err, t)
+	if !strings.Contains(j, `"writer": "importer"`) {
+		t.FailNow()
+	}
+
+	w := bytes.Buffer{}
+	err = ExportJSON("a/c", &w, ExportOptions{Extended: true, Canonical: true})
+	check(err, t)
+	if strings.Contains(w.String(), "writer") {
+		t.FailNow()
+	}
+
+	err = Set("a/c", "v4")
+	check(err, t)
+
+	entry, err = GetEntry("a/c")
+	check(err, t)
+	if entry.Writer != "component-a" {
+		t.FailNow()
+	}
+}
+
+func TestRecurse(t *testing.T) {
+	t.Log("Should recurse on an entry and on all of its children")
+	resetDB(t)
+
+	err := Set("/a1/b1/c1/d1", "d")
+	check(err, t)
+
+	err = Set("/a1/b1/c2/d1", "d")
+	check(err, t)
+
+	err = Set("/a2", "a")
+	check(err, t)
+
+	visited := map[string]bool{}
+
+	err = Recurse("/a1", -1, func(entry, parent *Entry, depth uint) error {
+		visited[entry.Path] = true
+		return nil
+	})
+
+	check(err, t)
+
+	if len(visited) != 6 {
+		t.FailNow()
+	}
+
+	if !visited["a1"] || !visited["a1/b1"] || !visited["a1/b1/c1"] || !visited["a1/b1/c1/d1"] ||
+		!visited["a1/b1/c2"] || !visited["a1/b1/c2/d1"] {
+		t.FailNow()
+	}
+
+	t.Log("Should recurse on an entry and on all of its children until a certain depth")
+	resetDB(t)
+
+	err = Set("/a1/b1/c1/d1", "d")
+	check(err, t)
+
+	err = Set("/a1/b1/c2/d1", "d")
+	check(err, t)
+
+	err = Set("/a2", "a")
+	check(err, t)
+
+	visited = map[string]bool{}
+
+	err = Recurse("/a1", 1, func(entry, parent *Entry, depth uint) error {
+		visited[entry.Path] = true
+		return nil
+	})
+
+	check(err, t)
+
+	if len(visited) != 2 {
+		t.FailNow()
+	}
+
+	if !visited["a1"] || !visited["a1/b1"] {
+		t.FailNow()
+	}
+
+	t.Log("Should report the depth and the parent of every entry, level by level")
+
+	depths := map[string]uint{}
+	last := uint(0)
+
+	err = Recurse("/a1", 2, func(entry, parent *Entry, depth uint) error {
+		if depth < last || (depth == 0) != (parent == nil) ||
+			(parent != nil && !strings.HasPrefix(entry.Path, parent.Path+"/")) {
+			return fmt.Errorf("unexpected visit of %s", entry.Path)
+		}
+
+		depths[entry.Path] = depth
+		last = depth
+		return nil
+	})
+
+	check(err, t)
+
+	if len(depths) != 4 || depths["a1"] != 0 || depths["a1/b1"] != 1 || depths["a1/b1/c1"] != 2 ||
+		depths["a1/b1/c2"] != 2 {
+		t.FailNow()
+	}
+
+	t.Log("Should report the error of the recurse callback")
+	resetDB(t)
+
+	err = Set("a2", "a")
+	check(err, t)
+
+	myError := fmt.Errorf("error1234")
+
+	err = Recurse("a2", 1, func(entry, parent *Entry, depth uint) error {
+		return myError
+	})
+
+	if !errors.Is(err, myError) {
+		t.FailNow()
+	}
+}
+
+func TestToJSON(t *testing.T) {
+	resetDB(t)
+
+	t.Log("Should convert the root Entry to JSON")
+
+	err := Set("/a1/b1/c1/d1", "d1")
+	check(err, t)
+
+	err = Set("/a1/b1/c1/d2", "d2")
+	check(err, t)
+
+	err = Set("/a1/b1/c2/d1", "d1")
+	check(err, t)
+
+	err = Set("/a1/b1/c2/d2", "d2")
+	check(err, t)
+
+	err = Set("/a2/b1", "b1")
+	check(err, t)
+
+	err = Set("/a3", "a3")
+	check(err, t)
+
+	j, err := EntryToJSON("")
+	check(err, t)
+
+	var entries Entry
+	err = json.Unmarshal([]byte(j), &entries)
+	check(err, t)
+
+	if entries.Children["a1"] == nil ||
+		entries.Children["a1"].Children["b1"] == nil ||
+		entries.Children["a1"].Children["b1"].Children["c1"] == nil ||
+		entries.Children["a1"].Children["b1"].Children["c1"].Children["d1"] == nil ||
+		entries.Children["a1"].Children["b1"].Children["c1"].Children["d2"] == nil ||
+		entries.Children["a1"].Children["b1"].Children["c2"].Children["d1"] == nil ||
+		entries.Children["a2"].Children["b1"] == nil ||
+		entries.Children["a3"] == nil {
+		t.FailNow()
+	}
+
+	if entries.Children["a1"].Children["b1"].Children["c1"].Children["d1"].Value != "d1" {
+		t.FailNow()
+	}
+
+	if entries.Children["a1"].Children["b1"].Children["c1"].Children["d2"].Value != "d2" {
+		t.FailNow()
+	}
+
+	if entries.Children["a1"].Children["b1"].Children["c2"].Children["d1"].Value != "d1" {
+		t.FailNow()
+	}
+
+	if entries.Children["a1"].Children["b1"].Children["c2"].Children["d2"].Value != "d2" {
+		t.FailNow()
+	}
+
+	if entries.Children["a2"].Children["b1"].Value != "b1" {
+		t.FailNow()
+	}
+
+	if entries.Children["a3"].Value != "a3" {
+		t.FailNow()
+	}
+
+	t.Log("Should convert all the values from root Entry to JSON")
+
+	resetDB(t)
+
+	err = Set("/a1/b1/c1", "c1")
+	check(err, t)
+
+	err = Set("/a1/b1/c2", "c2")
+	check(err, t)
+
+	err = Set("/a1/b2/c1", "c1")
+	check(err, t)
+
+	j, err = ValuesToJSON("")
+	check(err, t)
+
+	ji := make(map[string]interface{})
+	err = json.Unmarshal([]byte(j), &ji)
+	check(err, t)
+
+	jb, err := json.Marshal(ji)
+	check(err, t)
+
+	compare := make(map[string]map[string]map[string]interface{})
+	compare["a1"] = make(map[string]map[string]interface{})
+	compare["a1"]["b1"] = make(map[string]interface{})
+	compare["a1"]["b1"]["c1"] = "c1"
+	compare["a1"]["b1"]["c2"] = "c2"
+	compare["a1"]["b2"] = make(map[string]interface{})
+	compare["a1"]["b2"]["c1"] = "c1"
+
+	jCompare, err := json.Marshal(&compare)
+	check(err, t)
+
+	if string(jb) != string(jCompare) {
+		t.FailNow()
+	}
+
+}
+
+func TestToJSONWriter(t *testing.T) {
+	resetDB(t)
+
+	t.Log("Should stream the same JSON produced by encoding the Entry tree")
+
+	err := Set("/a1/b1/c1", "<c1>")
+	check(err, t)
+
+	err = Set("/a1/b1/c2", "c2 & \"c2\"")
+	check(err, t)
+
+	err = Set("/a1/b2", "b2")
+	check(err, t)
+
+	err = Set("/a2/b1/c1/d1", "d1")
+	check(err, t)
+
+	entry, err := GetEntry("")
+	check(err, t)
+
+	compare := bytes.Buffer{}
+	encoder := json.NewEncoder(&compare)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "    ")
+	err = encoder.Encode(entry)
+	check(err, t)
+
+	w := bytes.Buffer{}
+	err = EntryToJSONWriter("", &w)
+	check(err, t)
+
+	if w.String() != compare.String() {
+		t.FailNow()
+	}
+
+	t.Log("Should stream values at a path")
+
+	w = bytes.Buffer{}
+	err = ValuesToJSONWriter("a1/b1", &w)
+	check(err, t)
+
+	if w.String() != "{\n    \"c1\": \"<c1>\",\n    \"c2\": \"c2 & \\\"c2\\\"\"\n}\n" {
+		t.FailNow()
+	}
+
+	t.Log("Should fail on a non existing path")
+
+	err = ValuesToJSONWriter("a3", &w)
+	if !errors.Is(err, ErrPathNotFound) {
+		t.FailNow()
+	}
+}
+
+func TestGzipJSON(t *testing.T) {
+	resetDB(t)
+
+	check(Set("compressed/a", "1"), t)
+	check(Set("compressed/b/c", "2"), t)
+
+	t.Log("Should write a gzip-compressed export")
+
+	buffer := bytes.Buffer{}
+	check(ExportJSON("compressed", &buffer, ExportOptions{Extended: true, Gzip: true}), t)
+
+	data := buffer.Bytes()
+	if len(data) < 2 || data[0] != 0x1f || data[1] != 0x8b {
+		t.Fatal("Expected a gzip stream")
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	check(err, t)
+	var entry Entry
+	check(json.NewDecoder(zr).Decode(&entry), t)
+	if entry.Children["b"] == nil || entry.Children["b"].Children["c"].Value != "2" {
+		t.Fatalf("Unexpected export %v", entry)
+	}
+
+	t.Log("Should detect and decompress gzip-compressed imports")
+
+	check(ImportJSON(bytes.NewReader(data), ImportOptions{Extended: true, Path: "imported"}), t)
+	values, err := GetFlat("imported")
+	check(err, t)
+	if !reflect.DeepEqual(values, map[string]string{"a": "1", "b/c": "2"}) {
+		t.Fatalf("Unexpected values %v", values)
+	}
+
+	buffer.Reset()
+	zw := gzip.NewWriter(&buffer)
+	_, err = zw.Write([]byte(`{"plain": {"d": "3"}}`))
+	check(err, t)
+	check(zw.Close(), t)
+
+	check(ImportJSON(&buffer, ImportOptions{}), t)
+	value, err := Get[string]("plain/d")
+	check(err, t)
+	if value != "3" {
+		t.Fatalf("Expected 3, got %s", value)
+	}
+}
+
+func TestCanonicalJSON(t *testing.T) {
+	t.Log("Should produce identical canonical exports for identical hierarchies")
+
+	set := func() string {
+		resetDB(t)
+
+		err := Set("/a1/b1/c1", "c1")
+		check(err, t)
+
+		err = Set("/a1/b2", "b2")
+		check(err, t)
+
+		err = Set("/a2", "a2")
+		check(err, t)
+
+		w := bytes.Buffer{}
+		err = ExportJSON("", &w, ExportOptions{Extended: true, Canonical: true})
+		check(err, t)
+
+		return w.String()
+	}
+
+	first := set()
+	time.Sleep(5 * time.Millisecond)
+	second := set()
+
+	if first != second {
+		t.FailNow()
+	}
+
+	if strings.Contains(first, propLastUpdate) {
+		t.FailNow()
+	}
+
+	t.Log("Should import a canonical export")
+
+	resetDB(t)
+
+	err := SetEntriesFromJSON(strings.NewReader(first), false)
+	check(err, t)
+
+	v, err := Get[string]("a1/b1/c1")
+	check(err, t)
+	if v != "c1" {
+		t.FailNow()
+	}
+}
+
+func TestExportFilters(t *testing.T) {
+	resetDB(t)
+
+	check(Set("bundle/secrets/api/token", "t"), t)
+	check(Set("bundle/secrets/key", "k"), t)
+	check(Set("bundle/net/eth0/port", "80"), t)
+	check(Set("bundle/net/eth0/password", "p"), t)
+	check(Set("bundle/net/eth1/port", "81"), t)
+	check(Set("bundle/name", "n"), t)
+
+	export := func(options ExportOptions) map[string]any {
+		buffer := bytes.Buffer{}
+		check(ExportJSON("bundle", &buffer, options), t)
+
+		var values map[string]any
+		check(json.Unmarshal(buffer.Bytes(), &values), t)
+		return values
+	}
+
+	t.Log("Should skip the excluded entries and their children")
+
+	values := export(ExportOptions{Exclude: []string{"secrets/**", "*/*/password"}})
+	expected := map[string]any{
+		"secrets": map[string]any{},
+		"net": map[string]any{
+			"eth0": map[string]any{"port": "80"},
+			"eth1": map[string]any{"port": "81"}},
+		"name": "n"}
+	if !reflect.DeepEqual(values, expected) {
+		t.Fatalf("Unexpected export %v", values)
+	}
+
+	t.Log("Should export only the included entries, their children and the entries leading to them")
+
+	values = export(ExportOptions{Include: []string{"net/eth0", "secrets/api"}, Exclude: []string{"net/*/password"}})
+	expected = map[string]any{
+		"secrets": map[string]any{"api": map[string]any{"token": "t"}},
+		"net":     map[string]any{"eth0": map[string]any{"port": "80"}}}
+	if !reflect.DeepEqual(values, expected) {
+		t.Fatalf("Unexpected export %v", values)
+	}
+
+	t.Log("Should fail with invalid globs")
+
+	err := ExportJSON("bundle", &bytes.Buffer{}, ExportOptions{Exclude: []string{"**/port"}})
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+}
+
+func TestFromJson(t *testing.T) {
+	t.Log("Should import values from JSON file")
+
+	resetDB(t)
+
+	j := `
+{
+	"a1": {
+		"b1": {
+			"c1": "c1",
+			"c2": "c2"
+		},
+		"b2": "overwritten"
+	},
+	"a2": "a2"
+}
+`
+	buf := bytes.Buffer{}
+	buf.WriteString(j)
+
+	err := Set("a1/b2", "original")
+	check(err, t)
+
+	err = Set("a1/b3", "original")
+	check(err, t)
+
+	err = SetValuesFromJSON(&buf, false)
+	check(err, t)
+
+	v, err := Get[string]("a1/b1/c1")
+	check(err, t)
+
+	if v != "c1" {
+		t.FailNow()
+	}
+
+	v, err = Get[string]("a1/b1/c2")
+	check(err, t)
+
+	if v != "c2" {
+		t.FailNow()
+	}
+
+	v, err = Get[string]("a2")
+	check(err, t)
+
+	if v != "a2" {
+		t.FailNow()
+	}
+
+	v, err = Get[string]("a1/b2")
+	check(err, t)
+
+	if v != "overwritten" {
+		t.FailNow()
+	}
+
+	v, err = Get[string]("a1/b3")
+	check(err, t)
+
+	if v != "original" {
+		t.FailNow()
+	}
+
+	t.Log("Should import entries from JSON file")
+
+	resetDB(t)
+
+	j = `
+{
+	"children": {
+		"a1": {
+			"children": {
+				"b1": {
+					"children": {
+						"c1": {
+							"value": "c1"
+						},
+						"c2": {
+							"value": "c2"
+						}
+					}
+				}
+			}
+		},
+		"a2": {
+			"value": "a2"
+		}
+	}
+}
+`
+
+	buf = bytes.Buffer{}
+	buf.WriteString(j)
+
+	err = SetEntriesFromJSON(&buf, false)
+	check(err, t)
+
+	v, err = Get[string]("a1/b1/c1")
+	check(err, t)
+	if v != "c1" {
+		t.FailNow()
+	}
+
+	v, err = Get[string]("a1/b1/c2")
+	check(err, t)
+	if v != "c2" {
+		t.FailNow()
+	}
+
+	v, err = Get[string]("a2")
+	check(err, t)
+	if v != "a2" {
+		t.FailNow()
+	}
+
+	t.Log("Should only merge values from JSON file")
+
+	resetDB(t)
+
+	j = `
+{
+	"e1": {
+		"e2": "merged"
+	},
+	"n1": {
+		"n2": "merged"
+	}
+}
+`
+	err = Set("e1/e2", "original")
+	check(err, t)
+
+	buf = bytes.Buffer{}
+	buf.WriteString(j)
+
+	err = SetValuesFromJSON(&buf, true)
+	check(err, t)
+
+	v, err = Get[string]("e1/e2")
+	check(err, t)
+	if v != "original" {
+		t.FailNow()
+	}
+
+	v, err = Get[string]("n1/n2")
+	check(err, t)
+	if v != "merged" {
+		t.FailNow()
+	}
+
+	t.Log("Should only merge entries from JSON file")
+
+	resetDB(t)
+
+	j = `
+{
+	"children": {
+		"e1": {
+			"children": {
+				"e2": {
+					"value": "merged"
+				}
+			}
+		},
+		"n1": {
+			"children": {
+				"n2": {
+					"value": "merged"
+				}
+			}
+		}
+	}
+}
+`
+	err = Set("e1/e2", "original")
+	check(err, t)
+
+	buf = bytes.Buffer{}
+	buf.WriteString(j)
+
+	err = SetEntriesFromJSON(&buf, true)
+	check(err, t)
+
+	v, err = Get[string]("e1/e2")
+	check(err, t)
+	if v != "original" {
+		t.FailNow()
+	}
+
+	v, err = Get[string]("n1/n2")
+	check(err, t)
+	if v != "merged" {
+		t.FailNow()
+	}
+}
+
+func TestDryRunFromJSON(t *testing.T) {
+	t.Log("Should report the changes of an import without applying them")
+
+	resetDB(t)
+
+	err := Set("a1/b1", "original")
+	check(err, t)
+
+	err = Set("a1/b2", "same")
+	check(err, t)
+
+	err = Set("a2/b1", "b1")
+	check(err, t)
+
+	j := `
+{
+	"a1": {
+		"b1": "updated",
+		"b2": "same",
+		"b3": "created"
+	},
+	"a2": "overwritten"
+}
+`
+	buf := bytes.Buffer{}
+	buf.WriteString(j)
+
+	changes, err := DryRunValuesFromJSON(&buf, false)
+	check(err, t)
+
+	if len(changes) != 3 {
+		t.FailNow()
+	}
+
+	if changes[0].Type != ChangeUpdated || changes[0].Path != "a1/b1" ||
+		changes[0].OldValue != "original" || changes[0].Value != "updated" {
+		t.FailNow()
+	}
+
+	if changes[1].Type != ChangeCreated || changes[1].Path != "a1/b3" || changes[1].Value != "created" {
+		t.FailNow()
+	}
+
+	if changes[2].Type != ChangeOverwritten || changes[2].Path != "a2" || changes[2].Value != "overwritten" {
+		t.FailNow()
+	}
+
+	v, err := Get[string]("a1/b1")
+	check(err, t)
+	if v != "original" {
+		t.FailNow()
+	}
+
+	e, err := Exists("a1/b3")
+	check(err, t)
+	if e {
+		t.FailNow()
+	}
+
+	t.Log("Should report the changes of an extended import without applying them")
+
+	j = `
+{
+	"children": {
+		"a1": {
+			"children": {
+				"b1": {
+					"value": "updated"
+				}
+			}
+		},
+		"a3": {
+			"value": "created"
+		}
+	}
+}
+`
+	buf = bytes.Buffer{}
+	buf.WriteString(j)
+
+	changes, err = DryRunEntriesFromJSON(&buf, false)
+	check(err, t)
+
+	if len(changes) != 2 {
+		t.FailNow()
+	}
+
+	if changes[0].Type != ChangeUpdated || changes[0].Path != "a1/b1" {
+		t.FailNow()
+	}
+
+	if changes[1].Type != ChangeCreated || changes[1].Path != "a3" {
+		t.FailNow()
+	}
+
+	e, err = Exists("a3")
+	check(err, t)
+	if e {
+		t.FailNow()
+	}
+}
+
+func TestBulkImportJSON(t *testing.T) {
+	resetDB(t)
+
+	err := Set("a/b", "value")
+	check(err, t)
+
+	err = Set("c/d", "kept")
+	check(err, t)
+
+	t.Log("Should create the parents of the imported values, overwriting values in the way")
+
+	j := `{"a": {"b": {"c": {"d": 1, "e": 2}}, "f": 3}, "c": {"e": 4}, "g": {"h": {}}}`
+
+	changes, err := DryRunValuesFromJSON(strings.NewReader(j), false)
+	check(err, t)
+
+	expected := []Change{
+		{Type: ChangeOverwritten, Path: "a/b", OldValue: "value"},
+		{Type: ChangeCreated, Path: "a/b/c"},
+		{Type: ChangeCreated, Path: "a/b/c/d", IsValue: true, Value: "1"},
+		{Type: ChangeCreated, Path: "a/b/c/e", IsValue: true, Value: "2"},
+		{Type: ChangeCreated, Path: "a/f", IsValue: true, Value: "3"},
+		{Type: ChangeCreated, Path: "c/e", IsValue: true, Value: "4"},
+	}
+
+	if len(changes) != len(expected) {
+		t.FailNow()
+	}
+
+	for i, c := range changes {
+		if c != expected[i] {
+			t.FailNow()
+		}
+	}
+
+	err = SetValuesFromJSON(strings.NewReader(j), false)
+	check(err, t)
+
+	values := map[string]string{"a/b/c/d": "1", "a/b/c/e": "2", "a/f": "3", "c/d": "kept", "c/e": "4"}
+	for path, value := range values {
+		v, err := Get[string](path)
+		check(err, t)
+
+		if v != value {
+			t.FailNow()
+		}
+	}
+
+	exists, err := Exists("g")
+	check(err, t)
+
+	if exists {
+		t.FailNow()
+	}
+
+	t.Log("Should set the LastUpdate timestamp of the imported Entries to the current time")
+
+	entry, err := GetEntry("a/b/c/d")
+	check(err, t)
+
+	if d := time.Since(entry.LastUpdate); d < -5*time.Second || d > 5*time.Second {
+		t.Fatalf("LastUpdate %v is not close to the current time", entry.LastUpdate)
+	}
+
+	t.Log("Should only merge missing values, also under new parents")
+
+	err = SetValuesFromJSON(strings.NewReader(`{"a": {"f": 5, "i": {"j": 6}}}`), true)
+	check(err, t)
+
+	f, err := Get[int]("a/f")
+	check(err, t)
+
+	ij, err := Get[int]("a/i/j")
+	check(err, t)
+
+	if f != 3 || ij != 6 {
+		t.FailNow()
+	}
+}
+
+func TestNativeTypesJSON(t *testing.T) {
+	t.Log("Should round trip native JSON types")
+
+	resetDB(t)
+
+	j := `{"a":{"int":42,"float":-1.5,"bool":true,"null":null,"string":"42"}}`
+
+	err := ImportJSON(strings.NewReader(j), ImportOptions{NativeTypes: true})
+	check(err, t)
+
+	i, err := Get[int]("a/int")
+	check(err, t)
+	if i != 42 {
+		t.FailNow()
+	}
+
+	e, err := GetEntry("a/float")
+	check(err, t)
+	if e.Type != TypeFloat {
+		t.FailNow()
+	}
+
+	w := bytes.Buffer{}
+	err = ExportJSON("", &w, ExportOptions{NativeTypes: true})
+	check(err, t)
+
+	var compare, exported interface{}
+	err = json.Unmarshal([]byte(j), &compare)
+	check(err, t)
+
+	err = json.Unmarshal(w.Bytes(), &exported)
+	check(err, t)
+
+	jCompare, err := json.Marshal(compare)
+	check(err, t)
+
+	jExported, err := json.Marshal(exported)
+	check(err, t)
+
+	if string(jCompare) != string(jExported) {
+		t.FailNow()
+	}
+
+	t.Log("Should stringify native JSON types by default")
+
+	s, err := ValuesToJSON("a/int")
+	check(err, t)
+	if s != "\"42\"\n" {
+		t.FailNow()
+	}
+
+	t.Log("Should replace the type tag when setting a value")
+
+	err = Set("a/int", "43")
+	check(err, t)
+
+	e, err = GetEntry("a/int")
+	check(err, t)
+	if e.Type != TypeString {
+		t.FailNow()
+	}
+
+	t.Log("Should keep type tags in the extended format")
+
+	entry, err := EntryToJSON("")
+	check(err, t)
+
+	resetDB(t)
+
+	err = SetEntriesFromJSON(strings.NewReader(entry), false)
+	check(err, t)
+
+	e, err = GetEntry("a/bool")
+	check(err, t)
+	if e.Type != TypeBool || e.Value != "true" {
+		t.FailNow()
+	}
+
+	t.Log("Should not report changes of the type tag alone")
+
+	err = Set("a/retyped", "42")
+	check(err, t)
+
+	changes, err := DryRunImportJSON(strings.NewReader(`{"a": {"retyped": 42}}`), ImportOptions{NativeTypes: true})
+	check(err, t)
+	if len(changes) != 0 {
+		t.Fatalf("Expected no changes, got %v", changes)
+	}
+
+	err = ImportJSON(strings.NewReader(`{"a": {"retyped": 42}}`), ImportOptions{NativeTypes: true})
+	check(err, t)
+
+	e, err = GetEntry("a/retyped")
+	check(err, t)
+	if e.Type != TypeInt || e.Value != "42" {
+		t.FailNow()
+	}
+}
+
+func TestLists(t *testing.T) {
+	resetDB(t)
+
+	t.Log("Should set and get a list")
+
+	err := SetList("net/dns", []string{"1.1.1.1", "8.8.8.8"})
+	check(err, t)
+
+	dns, err := GetList[string]("net/dns")
+	check(err, t)
+	if len(dns) != 2 || dns[0] != "1.1.1.1" || dns[1] != "8.8.8.8" {
+		t.FailNow()
+	}
+
+	t.Log("Should append to and remove from a list")
+
+	err = AppendToList("net/dns", "9.9.9.9")
+	check(err, t)
+
+	err = RemoveFromList("net/dns", "1.1.1.1")
+	check(err, t)
+
+	dns, err = GetList[string]("net/dns")
+	check(err, t)
+	if len(dns) != 2 || dns[0] != "8.8.8.8" || dns[1] != "9.9.9.9" {
+		t.FailNow()
+	}
+
+	err = AppendToList("net/ports", 80, 443)
+	check(err, t)
+
+	ports, err := GetList[int]("net/ports")
+	check(err, t)
+	if len(ports) != 2 || ports[0] != 80 || ports[1] != 443 {
+		t.FailNow()
+	}
+
+	t.Log("Should fail on values that are not lists")
+
+	err = Set("net/hostname", "host")
+	check(err, t)
+
+	_, err = GetList[string]("net/hostname")
+	if !errors.Is(err, ErrValueIsNotAList) {
+		t.FailNow()
+	}
+
+	t.Log("Should import and export JSON arrays")
+
+	resetDB(t)
+
+	err = ImportJSON(strings.NewReader(`{"hosts": ["a", "b"], "ports": [80, 443]}`), ImportOptions{NativeTypes: true})
+	check(err, t)
+
+	hosts, err := GetList[string]("hosts")
+	check(err, t)
+	if len(hosts) != 2 || hosts[0] != "a" || hosts[1] != "b" {
+		t.FailNow()
+	}
+
+	j, err := ValuesToJSON("")
+	check(err, t)
+
+	if j != "{\n    \"hosts\": [\n        \"a\",\n        \"b\"\n    ],\n    \"ports\": [\n        \"80\",\n        \"443\"\n    ]\n}\n" {
+		t.FailNow()
+	}
+
+	w := bytes.Buffer{}
+	err = ExportJSON("ports", &w, ExportOptions{NativeTypes: true})
+	check(err, t)
+
+	if w.String() != "[\n    80,\n    443\n]\n" {
+		t.FailNow()
+	}
+}
+
+type testNetwork struct {
+	Hostname string   `cml:"hostname"`
+	DNS      []string `cml:"dns"`
+	MTU      int
+}
+
+type testBase struct {
+	Version uint `cml:"version"`
+}
+
+type testConfig struct {
+	testBase
+	Name    string       `cml:"name"`
+	Enabled bool         `cml:"enabled"`
+	Ratio   float64      `cml:"ratio"`
+	Network testNetwork  `cml:"network"`
+	Backup  *testNetwork `cml:"backup"`
+	Ignored string       `cml:"-"`
+	private string
+}
+
+func TestStructs(t *testing.T) {
+	resetDB(t)
+
+	t.Log("Should map a struct onto a hierarchy of Entries")
+
+	config := testConfig{
+		testBase: testBase{Version: 3},
+		Name:     "device 1",
+		Enabled:  true,
+		Ratio:    0.5,
+		Network: testNetwork{
+			Hostname: "host",
+			DNS:      []string{"1.1.1.1", "8.8.8.8"},
+			MTU:      1500},
+		Ignored: "ignored",
+		private: "private"}
+
+	err := SetStruct("config", &config)
+	check(err, t)
+
+	v, err := Get[string]("config/network/hostname")
+	check(err, t)
+	if v != "host" {
+		t.FailNow()
+	}
+
+	mtu, err := Get[int]("config/network/MTU")
+	check(err, t)
+	if mtu != 1500 {
+		t.FailNow()
+	}
+
+	version, err := Get[uint]("config/version")
+	check(err, t)
+	if version != 3 {
+		t.FailNow()
+	}
+
+	for _, p := range []string{"config/Ignored", "config/private", "config/backup"} {
+		e, err := Exists(p)
+		check(err, t)
+		if e {
+			t.FailNow()
+		}
+	}
+
+	t.Log("Should populate a struct from a hierarchy of Entries")
+
+	err = Set("config/backup/hostname", "backup")
+	check(err, t)
+
+	read := testConfig{Ignored: "untouched"}
+	err = GetStruct("config", &read)
+	check(err, t)
+
+	if read.Name != "device 1" || !read.Enabled || read.Ratio != 0.5 || read.Version != 3 ||
+		read.Network.Hostname != "host" || read.Network.MTU != 1500 || len(read.Network.DNS) != 2 ||
+		read.Network.DNS[1] != "8.8.8.8" || read.Backup == nil || read.Backup.Hostname != "backup" ||
+		read.Ignored != "untouched" {
+		t.FailNow()
+	}
+
+	t.Log("Should fail on non-struct values")
+
+	err = SetStruct("config", "string")
+	if err == nil {
+		t.FailNow()
+	}
+
+	err = GetStruct("config", read)
+	if err == nil {
+		t.FailNow()
+	}
+
+	t.Log("Should fail with ErrTypeMismatch reading a value as a struct")
+
+	err = GetStruct("config/name", &read)
+	if !errors.Is(err, ErrTypeMismatch) {
+		t.Fatalf("Expected ErrTypeMismatch, got %v", err)
+	}
+
+	err = GetStruct("config", &struct {
+		Name testConfig `cml:"name"`
+	}{})
+	if !errors.Is(err, ErrTypeMismatch) {
+		t.Fatalf("Expected ErrTypeMismatch, got %v", err)
+	}
+
+	t.Log("Should round trip array fields as lists")
+
+	type withArray struct {
+		Servers [2]string `cml:"servers"`
+	}
+
+	err = SetStruct("arrays", withArray{Servers: [2]string{"a", "b"}})
+	check(err, t)
+
+	arrays := withArray{}
+	err = GetStruct("arrays", &arrays)
+	check(err, t)
+	if arrays.Servers != [2]string{"a", "b"} {
+		t.Fatalf("Unexpected array %v", arrays.Servers)
+	}
+
+	t.Log("Should fail reading lists of a different length as arrays")
+
+	err = GetStruct("arrays", &struct {
+		Servers [3]string `cml:"servers"`
+	}{})
+	if !errors.Is(err, ErrTypeMismatch) {
+		t.Fatalf("Expected ErrTypeMismatch, got %v", err)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	resetDB(t)
+
+	t.Log("Should notify changes under the watched path")
+
+	changes := make(chan Change, 10)
+	unwatch, err := Watch("a/b", func(change Change) {
+		changes <- change
+	})
+	check(err, t)
+
+	err = Set("a/b/c", "1")
+	check(err, t)
+
+	err = Set("a/other", "1")
+	check(err, t)
+
+	err = Set("a/b/c", "2")
+	check(err, t)
+
+	err = Delete("a")
+	check(err, t)
+
+	expected := []Change{
+		{Type: ChangeCreated, Path: "a/b"},
+		{Type: ChangeCreated, Path: "a/b/c", IsValue: true, Value: "1"},
+		{Type: ChangeUpdated, Path: "a/b/c", IsValue: true, OldValue: "1", Value: "2"},
+		{Type: ChangeDeleted, Path: "a"}}
+
+	for _, e := range expected {
+		select {
+		case c := <-changes:
+			if c != e {
+				t.Fatalf("unexpected change %v", c)
+			}
+		case <-time.After(time.Second):
+			t.FailNow()
+		}
+	}
+
+	t.Log("Should stop notifying changes")
+
+	unwatch()
+
+	err = Set("a/b/c", "3")
+	check(err, t)
+
+	select {
+	case <-changes:
+		t.FailNow()
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestBind(t *testing.T) {
+	resetDB(t)
+
+	t.Log("Should keep a struct updated")
+
+	network := testNetwork{MTU: 1400}
+	err := SetStruct("network", &testNetwork{Hostname: "host", MTU: 1500})
+	check(err, t)
+
+	binding, err := Bind("network", &network)
+	check(err, t)
+	defer binding.Close()
+
+	if network.Hostname != "host" || network.MTU != 1500 {
+		t.FailNow()
+	}
+
+	updated := make(chan error, 10)
+	binding.OnUpdate(func(err error) {
+		updated <- err
+	})
+
+	err = Set("network/hostname", "updated")
+	check(err, t)
+
+	select {
+	case err = <-updated:
+		check(err, t)
+	case <-time.After(time.Second):
+		t.FailNow()
+	}
+
+	binding.RLock()
+	if network.Hostname != "updated" {
+		t.FailNow()
+	}
+	binding.RUnlock()
+
+	t.Log("Should reload the struct once for each transaction")
+
+	err = SetStruct("network", &testNetwork{Hostname: "batch", MTU: 9000})
+	check(err, t)
+
+	select {
+	case err = <-updated:
+		check(err, t)
+	case <-time.After(time.Second):
+		t.FailNow()
+	}
+
+	select {
+	case <-updated:
+		t.Fatal("Unexpected second reload")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	binding.RLock()
+	if network.Hostname != "batch" || network.MTU != 9000 {
+		t.FailNow()
+	}
+	binding.RUnlock()
+
+	t.Log("Should reset the struct when the path is deleted")
+
+	err = Delete("network")
+	check(err, t)
+
+	select {
+	case err = <-updated:
+		check(err, t)
+	case <-time.After(time.Second):
+		t.FailNow()
+	}
+
+	binding.RLock()
+	if network.Hostname != "" || network.MTU != 1400 {
+		t.FailNow()
+	}
+	binding.RUnlock()
+}
+
+func TestMigrate(t *testing.T) {
+	t.Log("Should migrate a version 1 DB")
+
+	err := Close()
+	check(err, t)
+
+	v1DBPath := testDBPath + ".v1"
+	defer os.Remove(v1DBPath)
+
+	v1DB, err := sql.Open("sqlite3", v1DBPath)
+	check(err, t)
+
+	_, err = v1DB.Exec(`CREATE TABLE camellia (
+		path TEXT NOT NULL UNIQUE,
+		last_update_ms INTEGER NOT NULL,
+		is_value BIT DEFAULT 0,
+		parent TEXT DEFAULT '',
+		value TEXT DEFAULT '',
+		PRIMARY KEY (path))`)
+	check(err, t)
+
+	_, err = v1DB.Exec(`INSERT INTO camellia VALUES ('', 0, 0, NULL, ''), ('a', 0, 1, '', 'v1'), ('b', 0, 0, '', ''),
+		('b/c', 0, 0, 'b', ''), ('b/c/d', 0, 1, 'b/c', 'v2'); PRAGMA user_version = 1`)
+	check(err, t)
+
+	err = v1DB.Close()
+	check(err, t)
+
+	_, err = Open(v1DBPath)
+	if !errors.Is(err, ErrDBVersionMismatch) {
+		t.FailNow()
+	}
+
+	migrated, err := Migrate(v1DBPath)
+	check(err, t)
+	if !migrated {
+		t.FailNow()
+	}
+
+	t.Log("Should back up the DB before migrating it")
+
+	backupPath := GetMigrationBackupPath()
+	if !strings.HasPrefix(backupPath, v1DBPath+".v1-") {
+		t.FailNow()
+	}
+	defer os.Remove(backupPath)
+
+	backupDB, err := sql.Open("sqlite3", backupPath)
+	check(err, t)
+
+	var backupVersion int
+	err = backupDB.QueryRow("PRAGMA user_version").Scan(&backupVersion)
+	check(err, t)
+	if backupVersion != 1 {
+		t.FailNow()
+	}
+
+	err = backupDB.Close()
+	check(err, t)
+
+	v, err := Get[string]("a")
+	check(err, t)
+	if v != "v1" {
+		t.FailNow()
+	}
+
+	t.Log("Should reference the parents by ID, cascading deletes")
+
+	entry, err := GetEntry("b")
+	check(err, t)
+	if entry.Children["c"].Children["d"].Value != "v2" {
+		t.FailNow()
+	}
+
+	err = Delete("b")
+	check(err, t)
+
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM camellia WHERE path LIKE 'b%'").Scan(&count)
+	check(err, t)
+	if count != 0 {
+		t.FailNow()
+	}
+
+	var orphans int
+	err = db.QueryRow("SELECT COUNT(*) FROM camellia WHERE path != '' AND parent_id IS NULL").Scan(&orphans)
+	check(err, t)
+	if orphans != 0 {
+		t.FailNow()
+	}
+
+	err = Close()
+	check(err, t)
+
+	migrated, err = Migrate(v1DBPath)
+	check(err, t)
+	if migrated {
+		t.FailNow()
+	}
+
+	resetDB(t)
+}
+
+func testHooks(t *testing.T, shouldBeCalled bool) {
+	resetDB(t)
+
+	wipeHooks()
+
+	var p = "a/b/hook"
+	const v = "called"
+
+	err := Set(p, "a")
+	check(err, t)
+
+	var preCalled, postCalled bool
+
+	SetPreSetHook(p, func(path, value string) error {
+		if value != v {
+			return fmt.Errorf("hook value is different")
+		}
+
+		if path != p {
+			return fmt.Errorf("hook path is different")
+		}
+
+		preCalled = true
+
+		return nil
+	})
+
+	SetPostSetHook(p, func(path, value string) error {
+		if value != v {
+			return fmt.Errorf("hook value is different")
+		}
+
+		if path != p {
+			return fmt.Errorf("hook path is different")
+		}
+
+		postCalled = true
+
+		return nil
+	}, false)
+
+	err = Set(p, v)
+	check(err, t)
+
+	if shouldBeCalled != preCalled || shouldBeCalled != postCalled {
+		t.FailNow()
+	}
+
+	t.Log("Should call async post set hook")
+
+	resetDB(t)
+
+	p = "a/b/asyncHook"
+	postCalled = false
+	c := make(chan interface{})
+
+	SetPostSetHook(p, func(path, value string) error {
+		if value != v {
+			return fmt.Errorf("hook value is different")
+		}
+
+		if path != p {
+			return fmt.Errorf("hook path is different")
+		}
+
+		postCalled = true
+		c <- nil
+
+		return nil
+	}, true)
+
+	err = Set(p, v)
+	check(err, t)
+
+	timer := time.NewTimer(1 * time.Second)
+
+	select {
+	case <-timer.C:
+	case <-c:
+	}
+
+	if shouldBeCalled != postCalled {
+		t.FailNow()
+	}
+
+}
+
+func TestHooks(t *testing.T) {
+	t.Log("Should call hooks by default")
+	testHooks(t, true)
+
+	t.Log("Should not call hooks")
+	SetHooksEnabled(false)
+	testHooks(t, false)
+
+	t.Log("Should call hooks")
+	SetHooksEnabled(true)
+	testHooks(t, true)
+}
+
+func TestEvents(t *testing.T) {
+	resetDB(t)
+
+	err := Close()
+	check(err, t)
+
+	_, err = OpenWithOptions(testDBPath, Options{ChangeHistory: 3})
+	check(err, t)
+
+	defer func() {
+		Close()
+		Open(testDBPath)
+	}()
+
+	t.Log("Should increment the revision once per transaction")
+
+	revision, err := GetRevision()
+	check(err, t)
+	if revision != 0 {
+		t.FailNow()
+	}
+
+	err = Set("a/b", "1")
+	check(err, t)
+
+	err = Update(func(tx *Tx) error {
+		err := tx.Set("a/b", "2")
+		if err != nil {
+			return err
+		}
+
+		return tx.Set("c", "1")
+	})
+	check(err, t)
+
+	revision, err = GetRevision()
+	check(err, t)
+	if revision != 2 {
+		t.FailNow()
+	}
+
+	t.Log("Should return the events after a revision, under a path")
+
+	events, revision, err := GetEvents("a", 0)
+	check(err, t)
+
+	expected := []Event{
+		{Change: Change{Type: ChangeCreated, Path: "a"}, Revision: 1},
+		{Change: Change{Type: ChangeCreated, Path: "a/b", IsValue: true, Value: "1"}, Revision: 1},
+		{Change: Change{Type: ChangeUpdated, Path: "a/b", IsValue: true, OldValue: "1", Value: "2"}, Revision: 2}}
+
+	if revision != 2 || len(events) != len(expected) {
+		t.FailNow()
+	}
+
+	for i, e := range expected {
+		if events[i] != e {
+			t.Fatalf("unexpected event %v", events[i])
+		}
+	}
+
+	events, _, err = GetEvents("", 2)
+	check(err, t)
+	if len(events) != 0 {
+		t.FailNow()
+	}
+
+	t.Log("Should not increment the revision without changes")
+
+	err = Set("c", "1")
+	check(err, t)
+
+	revision, err = GetRevision()
+	check(err, t)
+	if revision != 2 {
+		t.FailNow()
+	}
+
+	t.Log("Should discard the revisions exceeding the history")
+
+	for _, v := range []string{"2", "3", "4"} {
+		err = Set("c", v)
+		check(err, t)
+	}
+
+	_, _, err = GetEvents("", 1)
+	if !errors.Is(err, ErrRevisionCompacted) {
+		t.FailNow()
+	}
+
+	events, revision, err = GetEvents("", 2)
+	check(err, t)
+	if revision != 5 || len(events) != 3 || events[0].Revision != 3 || events[2].Value != "4" {
+		t.FailNow()
+	}
+}
+
+type testSpanKey struct{}
+
+type testSpan struct {
+	name   string
+	path   string
+	parent string
+	ended  bool
+	err    error
+}
+
+func (s *testSpan) End(err error) {
+	s.ended = true
+	s.err = err
+}
+
+type testTracer struct {
+	spans []*testSpan
+}
+
+func (t *testTracer) Start(ctx context.Context, name string, path string) (context.Context, Span) {
+	parent, _ := ctx.Value(testSpanKey{}).(string)
+	span := &testSpan{name: name, path: path, parent: parent}
+	t.spans = append(t.spans, span)
+
+	return context.WithValue(ctx, testSpanKey{}, name), span
+}
+
+func TestTracing(t *testing.T) {
+	resetDB(t)
+
+	tracer := &testTracer{}
+	SetTracer(tracer)
+	defer SetTracer(nil)
+
+	t.Log("Should trace operations and their commits, propagating the context")
+
+	ctx := context.WithValue(context.Background(), testSpanKey{}, "request")
+	err := SetCtx(ctx, "a/b", "1")
+	check(err, t)
+
+	if len(tracer.spans) != 2 {
+		t.FailNow()
+	}
+
+	set, commit := tracer.spans[0], tracer.spans[1]
+	if set.name != "camellia.Set" || set.path != "a/b" || set.parent != "request" || !set.ended || set.err != nil {
+		t.FailNow()
+	}
+
+	if commit.name != "camellia.sql.commit" || commit.parent != "camellia.Set" || !commit.ended {
+		t.FailNow()
+	}
+
+	t.Log("Should end spans with the error of the operation")
+
+	tracer.spans = nil
+	_, err = Get[string]("a/missing")
+	if !errors.Is(err, ErrPathNotFound) {
+		t.FailNow()
+	}
+
+	if len(tracer.spans) != 1 || tracer.spans[0].name != "camellia.Get" || tracer.spans[0].parent != "" ||
+		!errors.Is(tracer.spans[0].err, ErrPathNotFound) {
+		t.FailNow()
+	}
+
+	t.Log("Should roll back transactions whose context is done")
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err = UpdateCtx(cancelled, func(tx *Tx) error {
+		return tx.Set("a/c", "1")
+	})
+	if err == nil {
+		t.FailNow()
+	}
+
+	exists, err := Exists("a/c")
+	check(err, t)
+	if exists {
+		t.FailNow()
+	}
+}
+
+func TestLogger(t *testing.T) {
+	resetDB(t)
+
+	l := &testLogger{}
+	SetLogger(l)
+	defer SetLogger(nil)
+
+	t.Log("Should log the creation of the DB")
+
+	err := Close()
+	check(err, t)
+
+	err = os.Remove(testDBPath)
+	check(err, t)
+
+	_, err = Open(testDBPath)
+	check(err, t)
+
+	if len(l.infos) != 1 || !strings.HasPrefix(l.infos[0], "created DB") {
+		t.FailNow()
+	}
+
+	t.Log("Should log the errors of async hooks")
+
+	done := make(chan struct{})
+	err = SetPostSetHook("a", func(path string, value string) error {
+		defer close(done)
+		return errors.New("hook failure")
+	}, true)
+	check(err, t)
+
+	err = Set("a", "1")
+	check(err, t)
+
+	<-done
+	time.Sleep(10 * time.Millisecond)
+
+	l.mutex.Lock()
+	if len(l.errors) != 1 || !strings.Contains(l.errors[0], "hook failure") {
+		t.FailNow()
+	}
+	l.mutex.Unlock()
+
+	t.Log("Should recover and log panics of watch callbacks")
+
+	changes := make(chan Change, 10)
+	_, err = Watch("b", func(change Change) {
+		if change.Value == "panic" {
+			panic("watch failure")
+		}
+
+		changes <- change
+	})
+	check(err, t)
+
+	err = Set("b", "panic")
+	check(err, t)
+
+	err = Set("b", "1")
+	check(err, t)
+
+	select {
+	case c := <-changes:
+		if c.Value != "1" {
+			t.FailNow()
+		}
+	case <-time.After(time.Second):
+		t.FailNow()
+	}
+
+	l.mutex.Lock()
+	if len(l.errors) != 2 || !strings.Contains(l.errors[1], "watch failure") {
+		t.FailNow()
+	}
+	l.mutex.Unlock()
+}
+
+func TestSlowOperations(t *testing.T) {
+	resetDB(t)
+
+	l := &testLogger{}
+	SetLogger(l)
+	defer SetLogger(nil)
+
+	t.Log("Should not log operations without a threshold")
+
+	err := Set("a/b", "1")
+	check(err, t)
+
+	if len(l.warnings) != 0 {
+		t.FailNow()
+	}
+
+	t.Log("Should log operations exceeding the threshold")
+
+	SetSlowOperationThreshold(time.Nanosecond)
+	defer SetSlowOperationThreshold(0)
+
+	err = Set("a/c", "2")
+	check(err, t)
+
+	if len(l.warnings) != 2 || !strings.Contains(l.warnings[0], "camellia.sql.commit") ||
+		!strings.Contains(l.warnings[1], "camellia.Set") {
+		t.FailNow()
+	}
+
+	t.Log("Should report the rows read")
+
+	l.warnings = nil
+	err = Recurse("a", -1, func(entry *Entry, parent *Entry, depth uint) error {
+		return nil
+	})
+	check(err, t)
+
+	if len(l.warnings) != 2 || !strings.Contains(l.warnings[1], "camellia.Recurse") ||
+		!strings.Contains(l.warnings[1], "rows3") {
+		t.FailNow()
+	}
+}
+
+func TestHealth(t *testing.T) {
+	resetDB(t)
+
+	err := Set("a", "1")
+	check(err, t)
+
+	t.Log("Should report a healthy DB")
+
+	report, err := Health()
+	check(err, t)
+
+	revision, err := GetRevision()
+	check(err, t)
+
+	if report.Path != testDBPath || !report.Writable || report.Revision != revision {
+		t.FailNow()
+	}
+
+	t.Log("Should not change the DB")
+
+	report, err = Health()
+	check(err, t)
+
+	if report.Revision != revision {
+		t.FailNow()
+	}
+
+	t.Log("Should fail without a DB")
+
+	err = Close()
+	check(err, t)
+
+	_, err = Health()
+	if !errors.Is(err, ErrNoDB) {
+		t.FailNow()
+	}
+
+	_, err = Open(testDBPath)
+	check(err, t)
+}
+
+func TestWaitAsyncHooks(t *testing.T) {
+	resetDB(t)
+
+	release := make(chan struct{})
+	err := SetPostSetHook("a", func(path string, value string) error {
+		<-release
+		return nil
+	}, true)
+	check(err, t)
+
+	err = Set("a", "1")
+	check(err, t)
+
+	t.Log("Should time out while async hooks are running")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err = WaitAsyncHooks(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.FailNow()
+	}
+
+	t.Log("Should return once async hooks are done")
+
+	close(release)
+
+	err = WaitAsyncHooks(context.Background())
+	check(err, t)
+}
+
+func TestExternalChanges(t *testing.T) {
+	resetDB(t)
+
+	err := Close()
+	check(err, t)
+
+	_, err = OpenWithOptions(testDBPath, Options{PollExternalChanges: 10 * time.Millisecond})
+	check(err, t)
+
+	defer func() {
+		Close()
+		Open(testDBPath)
+	}()
+
+	changes := make(chan Change, 10)
+	_, err = Watch("ext", func(change Change) {
+		changes <- change
+	})
+	check(err, t)
+
+	hooked := make(chan string, 10)
+	err = SetPostSetHook("ext/b", func(path string, value string) error {
+		hooked <- value
+		return nil
+	}, false)
+	check(err, t)
+
+	receive := func() Change {
+		select {
+		case c := <-changes:
+			return c
+		case <-time.After(time.Second):
+			t.Fatal("change not received")
+			return Change{}
+		}
+	}
+
+	t.Log("Should dispatch the own changes once")
+
+	err = Set("ext/a", "1")
+	check(err, t)
+
+	c := receive()
+	if c.Path != "ext" {
+		t.FailNow()
+	}
+
+	c = receive()
+	if c.Path != "ext/a" || c.Value != "1" {
+		t.FailNow()
+	}
+
+	t.Log("Should dispatch the changes committed by other processes")
+
+	// Commit a change like another process would, through another connection
+	other, err := sql.Open("sqlite3", testDBPath)
+	check(err, t)
+	defer other.Close()
+
+	revision, err := GetRevision()
+	check(err, t)
+
+	_, err = other.Exec(fmt.Sprintf("UPDATE %s SET %s = ? WHERE %s = ?", metaTable, colValue, colKey),
+		strconv.FormatUint(revision+1, 10), metaRevision)
+	check(err, t)
+
+	_, err = other.Exec(fmt.Sprintf("INSERT INTO %s (%s, %s, %s, %s, %s, %s) VALUES (?, ?, ?, ?, ?, ?)", eventsTable,
+		colRevision, colChangeType, colPath, colIsValue, colOldValue, colValue),
+		revision+1, uint(ChangeCreated), "ext/b", true, "", "2")
+	check(err, t)
+
+	c = receive()
+	if c.Path != "ext/b" || c.Value != "2" || c.Type != ChangeCreated {
+		t.FailNow()
+	}
+
+	select {
+	case value := <-hooked:
+		if value != "2" {
+			t.FailNow()
+		}
+	case <-time.After(time.Second):
+		t.FailNow()
+	}
+
+	select {
+	case c := <-changes:
+		t.Fatalf("unexpected change %v", c)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestBusy(t *testing.T) {
+	resetDB(t)
+
+	err := Close()
+	check(err, t)
+
+	_, err = OpenWithOptions(testDBPath, Options{BusyTimeout: 200 * time.Millisecond})
+	check(err, t)
+
+	defer func() {
+		Close()
+		Open(testDBPath)
+	}()
+
+	// Lock the DB like another process would, through another connection
+	other, err := sql.Open("sqlite3", testDBPath)
+	check(err, t)
+	defer other.Close()
+
+	conn, err := other.Conn(context.Background())
+	check(err, t)
+	defer conn.Close()
+
+	_, err = conn.ExecContext(context.Background(), "BEGIN IMMEDIATE")
+	check(err, t)
+
+	t.Log("Should fail with ErrBusy after the busy timeout")
+
+	start := time.Now()
+	err = Set("a", "1")
+	if !errors.Is(err, ErrBusy) || time.Since(start) < 200*time.Millisecond {
+		t.FailNow()
+	}
+
+	t.Log("Should wait for the lock to be released")
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		conn.ExecContext(context.Background(), "ROLLBACK")
+	}()
+
+	err = Set("a", "1")
+	check(err, t)
+}
+
+func TestSetWithRevision(t *testing.T) {
+	resetDB(t)
+
+	t.Log("Should set a missing value only with revision 0")
+
+	err := SetWithRevision("a/b", 1, 1)
+	if !errors.Is(err, ErrRevisionMismatch) {
+		t.FailNow()
+	}
+
+	err = SetWithRevision("a/b", 1, 0)
+	check(err, t)
+
+	t.Log("Should record the revision of changed Entries")
+
+	entry, err := GetEntry("a/b")
+	check(err, t)
+
+	revision, err := GetRevision()
+	check(err, t)
+
+	if entry.Revision == 0 || entry.Revision != revision {
+		t.FailNow()
+	}
+
+	t.Log("Should fail if the Entry changed since it was read")
+
+	err = Set("a/b", 2)
+	check(err, t)
+
+	err = SetWithRevision("a/b", 3, entry.Revision)
+	if !errors.Is(err, ErrRevisionMismatch) {
+		t.FailNow()
+	}
+
+	entry, err = GetEntry("a/b")
+	check(err, t)
+
+	err = Update(func(tx *Tx) error {
+		return tx.SetWithRevision("a/b", 3, entry.Revision)
+	})
+	check(err, t)
+
+	value, err := Get[int]("a/b")
+	check(err, t)
+	if value != 3 {
+		t.FailNow()
+	}
+
+	t.Log("Should not change the revision if the value is unchanged")
+
+	entry, err = GetEntry("a/b")
+	check(err, t)
+
+	err = Set("a/b", 3)
+	check(err, t)
+
+	unchanged, err := GetEntry("a/b")
+	check(err, t)
+	if unchanged.Revision != entry.Revision {
+		t.FailNow()
+	}
+}
+
+func TestConcurrentReads(t *testing.T) {
+	resetDB(t)
+
+	err := Set("a/b", "1")
+	check(err, t)
+
+	err = Set("a/c", "2")
+	check(err, t)
+
+	t.Log("Should read while another read is in progress")
+
+	err = Recurse("a", -1, func(entry *Entry, parent *Entry, depth uint) error {
+		done := make(chan error)
+		go func() {
+			_, err := Get[string]("a/b")
+			done <- err
+		}()
+
+		select {
+		case err := <-done:
+			return err
+		case <-time.After(time.Second):
+			return errors.New("read blocked by another read")
+		}
+	})
+	check(err, t)
+
+	t.Log("Should read while the DB is locked for writing by another process")
+
+	other, err := sql.Open("sqlite3", testDBPath)
+	check(err, t)
+	defer other.Close()
+
+	conn, err := other.Conn(context.Background())
+	check(err, t)
+	defer conn.Close()
+
+	_, err = conn.ExecContext(context.Background(), "BEGIN IMMEDIATE")
+	check(err, t)
+	defer conn.ExecContext(context.Background(), "ROLLBACK")
+
+	value, err := Get[string]("a/c")
+	check(err, t)
+
+	if value != "2" {
+		t.FailNow()
+	}
+
+	t.Log("Should read concurrently with writes")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				_, err := GetEntry("a")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+
+	conn.ExecContext(context.Background(), "ROLLBACK")
+
+	for i := 0; i < 20; i++ {
+		err = Set("a/d", i)
+		check(err, t)
+	}
+
+	wg.Wait()
+}
+
+func TestConnectionPool(t *testing.T) {
+	resetDB(t)
+
+	err := Close()
+	check(err, t)
+
+	_, err = OpenWithOptions(testDBPath, Options{MaxReadConns: 2})
+	check(err, t)
+
+	defer func() {
+		Close()
+		Open(testDBPath)
+	}()
+
+	t.Log("Should switch the DB to WAL mode")
+
+	mode, err := pragma("PRAGMA journal_mode")
+	check(err, t)
+
+	if mode != "wal" {
+		t.FailNow()
+	}
+
+	t.Log("Should size the pool for the readers and the writer")
+
+	if db.Stats().MaxOpenConnections != 3 {
+		t.FailNow()
+	}
+
+	t.Log("Should read while another process is writing")
+
+	err = Set("a", "1")
+	check(err, t)
+
+	other, err := sql.Open("sqlite3", testDBPath)
+	check(err, t)
+	defer other.Close()
+
+	conn, err := other.Conn(context.Background())
+	check(err, t)
+	defer conn.Close()
+
+	_, err = conn.ExecContext(context.Background(), "BEGIN IMMEDIATE")
+	check(err, t)
+
+	_, err = conn.ExecContext(context.Background(), "DELETE FROM camellia")
+	check(err, t)
+
+	value, err := Get[string]("a")
+	check(err, t)
+
+	if value != "1" {
+		t.FailNow()
+	}
+
+	_, err = conn.ExecContext(context.Background(), "ROLLBACK")
+	check(err, t)
+}
+
+func TestTxStmts(t *testing.T) {
+	resetDB(t)
+
+	count := func() int {
+		n := 0
+		txStmts.Range(func(key, value any) bool {
+			n++
+			return true
+		})
+
+		return n
+	}
+
+	t.Log("Should release the statements bound to committed and rolled back transactions")
+
+	err := Set("a/b", "1")
+	check(err, t)
+
+	_, err = Get[string]("a/b")
+	check(err, t)
+
+	_, err = Get[string]("a/c")
+	if !errors.Is(err, ErrPathNotFound) {
+		t.FailNow()
+	}
+
+	err = Update(func(tx *Tx) error {
+		err := tx.Set("a/c", "2")
+		if err != nil {
+			return err
+		}
+
+		return errors.New("failure")
+	})
+
+	if err == nil {
+		t.FailNow()
+	}
+
+	_, err = GetEntry("a")
+	check(err, t)
+
+	if count() != 0 {
+		t.FailNow()
+	}
+}
+
+func TestLazyEntry(t *testing.T) {
+	resetDB(t)
+
+	err := Set("a/b/c", "1")
+	check(err, t)
+
+	err = Set("a/d", "2")
+	check(err, t)
+
+	t.Log("Should return an Entry without its children")
+
+	entry, err := GetEntryLazy("a")
+	check(err, t)
+
+	if entry.ChildrenLoaded() || len(entry.Children) != 0 {
+		t.FailNow()
+	}
+
+	t.Log("Should load the direct children on demand")
+
+	children, err := entry.GetChildren()
+	check(err, t)
+
+	if !entry.ChildrenLoaded() || len(children) != 2 || children["d"].Value != "2" {
+		t.FailNow()
+	}
+
+	b := children["b"]
+	if b.ChildrenLoaded() || len(b.Children) != 0 || !children["d"].ChildrenLoaded() {
+		t.FailNow()
+	}
+
+	err = b.LoadChildren()
+	check(err, t)
+
+	if !b.ChildrenLoaded() || b.Children["c"].Value != "1" {
+		t.FailNow()
+	}
+
+	t.Log("Should leave the last level of GetEntryDepth to be loaded")
+
+	entry, err = GetEntryDepth("a", 1)
+	check(err, t)
+
+	if !entry.ChildrenLoaded() || entry.Children["b"].ChildrenLoaded() {
+		t.FailNow()
+	}
+
+	entry, err = GetEntry("a")
+	check(err, t)
+
+	if !entry.ChildrenLoaded() || !entry.Children["b"].ChildrenLoaded() {
+		t.FailNow()
+	}
+}
+
+func TestFlushInterval(t *testing.T) {
+	resetDB(t)
+
+	err := Close()
+	check(err, t)
+
+	_, err = OpenWithOptions(testDBPath, Options{FlushInterval: time.Hour})
+	check(err, t)
+
+	defer func() {
+		Close()
+		Open(testDBPath)
+	}()
+
+	changes := make(chan Change, 16)
+	_, err = Watch("", func(c Change) {
+		changes <- c
+	})
+	check(err, t)
+
+	t.Log("Should buffer the values set, returning them with Get")
+
+	for i := 0; i < 10; i++ {
+		err = Set("a/b", i)
+		check(err, t)
+	}
+
+	v, err := Get[int]("a/b")
+	check(err, t)
+
+	exists, err := Exists("a/b")
+	check(err, t)
+
+	if v != 9 || exists || len(changes) != 0 {
+		t.FailNow()
+	}
+
+	if GetOrPanic[int]("a/b") != 9 || GetOrPanicEmpty[int]("a/b") != 9 {
+		t.FailNow()
+	}
+
+	t.Log("Should write the last buffered value once, on Flush")
+
+	err = Flush()
+	check(err, t)
+
+	entry, err := GetEntry("a/b")
+	check(err, t)
+
+	if entry.Value != "9" {
+		t.FailNow()
+	}
+
+	revision, err := GetRevision()
+	check(err, t)
+
+	if revision != 1 {
+		t.FailNow()
+	}
+
+	t.Log("Should flush before other writes, and report the errors of conflicting paths")
+
+	err = Set("c", 1)
+	check(err, t)
+
+	err = Set("c/d", 2)
+	if !errors.Is(err, ErrPathInvalid) {
+		t.FailNow()
+	}
+
+	t.Log("Should drop the values that can't be written when flushing")
+
+	err = Set("e", 1)
+	check(err, t)
+
+	err = Update(func(tx *Tx) error {
+		return tx.Set("f/g", 1)
+	})
+	check(err, t)
+
+	err = Set("f", 2)
+	check(err, t)
+
+	err = Set("h", 3)
+	check(err, t)
+
+	err = Flush()
+	check(err, t)
+
+	e, err := Get[int]("e")
+	check(err, t)
+
+	h, err := Get[int]("h")
+	check(err, t)
+
+	f, err := GetEntry("f")
+	check(err, t)
+
+	if e != 1 || h != 3 || f.IsValue {
+		t.FailNow()
+	}
+
+	t.Log("Should flush on Close")
+
+	err = Set("i", 4)
+	check(err, t)
+
+	err = Close()
+	check(err, t)
+
+	_, err = Open(testDBPath)
+	check(err, t)
+
+	i, err := Get[int]("i")
+	check(err, t)
+
+	if i != 4 {
+		t.FailNow()
+	}
+}
+
+func TestDurability(t *testing.T) {
+	resetDB(t)
+
+	err := Close()
+	check(err, t)
+
+	defer func() {
+		Close()
+		Open(testDBPath)
+	}()
+
+	t.Log("Should fail with an invalid durability")
+
+	_, err = OpenWithOptions(testDBPath, Options{Durability: Durability(10)})
+	if err == nil {
+		t.FailNow()
+	}
+
+	t.Log("Should sync the storage as selected")
+
+	levels := map[Durability]string{DurabilityNormal: "1", DurabilityFull: "2", DurabilityOff: "0"}
+	for durability, synchronous := range levels {
+		_, err = OpenWithOptions(testDBPath, Options{Durability: durability})
+		check(err, t)
+
+		value, err := pragma("PRAGMA synchronous")
+		check(err, t)
+
+		if value != synchronous {
+			t.FailNow()
+		}
+
+		err = Close()
+		check(err, t)
+	}
+
+	t.Log("Should truncate the write-ahead log on Checkpoint")
+
+	_, err = Open(testDBPath)
+	check(err, t)
+
+	err = Set("a", "1")
+	check(err, t)
+
+	info, err := os.Stat(testDBPath + "-wal")
+	check(err, t)
+
+	if info.Size() == 0 {
+		t.FailNow()
+	}
+
+	err = Checkpoint()
+	check(err, t)
+
+	info, err = os.Stat(testDBPath + "-wal")
+	check(err, t)
+
+	if info.Size() != 0 {
+		t.FailNow()
+	}
+
+	t.Log("Should checkpoint with the selected mode")
+
+	err = Set("a", "2")
+	check(err, t)
+
+	err = CheckpointWithMode(CheckpointPassive)
+	check(err, t)
+
+	err = CheckpointWithMode(CheckpointMode(10))
+	if err == nil {
+		t.FailNow()
+	}
+
+	t.Log("Should fail with an invalid checkpoint mode")
+
+	err = Close()
+	check(err, t)
+
+	_, err = OpenWithOptions(testDBPath, Options{CheckpointOnClose: true, CheckpointMode: CheckpointMode(10)})
+	if err == nil {
+		t.FailNow()
+	}
+
+	t.Log("Should checkpoint at CheckpointInterval")
+
+	_, err = OpenWithOptions(testDBPath, Options{CheckpointInterval: 20 * time.Millisecond, CheckpointOnClose: true})
+	check(err, t)
+
+	err = Set("a", "3")
+	check(err, t)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		info, err = os.Stat(testDBPath + "-wal")
+		check(err, t)
+
+		if info.Size() == 0 {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the write-ahead log to be truncated")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPathRules(t *testing.T) {
+	resetDB(t)
+
+	err := Close()
+	check(err, t)
+
+	defer func() {
+		Close()
+		Open(testDBPath)
+	}()
+
+	t.Log("Should fail with invalid rules")
+
+	_, err = OpenWithOptions(testDBPath, Options{PathRules: PathRules{AllowedChars: "z-a"}})
+	if err == nil {
+		t.FailNow()
+	}
+
+	_, err = OpenWithOptions(testDBPath, Options{PathRules: PathRules{DotSegments: DotSegments(10)}})
+	if err == nil {
+		t.FailNow()
+	}
+
+	t.Log("Should resolve dot segments and trim white space")
+
+	_, err = OpenWithOptions(testDBPath, Options{PathRules: PathRules{DotSegments: DotSegmentsResolve, TrimSpace: true}})
+	check(err, t)
+
+	err = Set(" a / b /./../c ", "1")
+	check(err, t)
+
+	v, err := Get[string]("a/c")
+	check(err, t)
+	if v != "1" {
+		t.FailNow()
+	}
+
+	v, err = Get[string]("../../a/ /c/.")
+	check(err, t)
+	if v != "1" {
+		t.FailNow()
+	}
+
+	err = Close()
+	check(err, t)
+
+	t.Log("Should reject paths breaking the rules")
+
+	_, err = OpenWithOptions(testDBPath, Options{PathRules: PathRules{
+		DotSegments:      DotSegmentsReject,
+		MaxSegmentLength: 4,
+		AllowedChars:     "a-z0-9_",
+	}})
+	check(err, t)
+
+	for _, p := range []string{"a/../b", "a/./b", "a/abcde", "a/B", "a/b-c"} {
+		err = Set(p, "1")
+		if !errors.Is(err, ErrPathInvalid) {
+			t.FailNow()
+		}
+
+		err = SetValuesFromJSON(strings.NewReader(fmt.Sprintf(`{"%s": "1"}`, p)), false)
+		if !errors.Is(err, ErrPathInvalid) {
+			t.FailNow()
+		}
+	}
+
+	err = Set("a/b_1", "1")
+	check(err, t)
+
+	_, err = Get[string]("a/../b")
+	if !errors.Is(err, ErrPathNotFound) {
+		t.FailNow()
+	}
+}
+
+func TestEscapeSegment(t *testing.T) {
+	resetDB(t)
+
+	t.Log("Should escape separators and backslashes")
+
+	names := []string{"hosts", "http://example.com/", `C:\dir`, "plain"}
+	path := JoinSegments(names...)
+	if path != `hosts/http:\/\/example.com\//C:\\dir/plain` {
+		t.FailNow()
+	}
+
+	split := SplitSegments("/" + path + "/")
+	if len(split) != len(names) {
+		t.FailNow()
+	}
+
+	for i := range names {
+		if split[i] != names[i] || UnescapeSegment(EscapeSegment(names[i])) != names[i] {
+			t.FailNow()
+		}
+	}
+
+	t.Log("Should store escaped names as single segments")
+
+	err := Set(path, "up")
+	check(err, t)
+
+	entry, err := GetEntry("hosts")
+	check(err, t)
+	if len(entry.Children) != 1 {
+		t.FailNow()
+	}
+
+	child := entry.Children[EscapeSegment("http://example.com/")]
+	if child == nil || child.Children[EscapeSegment(`C:\dir`)].Children["plain"].Value != "up" {
+		t.FailNow()
+	}
+
+	t.Log("Should round-trip escaped names through JSON")
+
+	for _, extended := range []bool{false, true} {
+		buf := bytes.Buffer{}
+		err = ExportJSON("", &buf, ExportOptions{Extended: extended})
+		check(err, t)
+
+		resetDB(t)
+
+		if extended {
+			err = SetEntriesFromJSON(&buf, false)
+		} else {
+			err = SetValuesFromJSON(&buf, false)
+		}
+		check(err, t)
+
+		v, err := Get[string](path)
+		check(err, t)
+		if v != "up" {
+			t.FailNow()
+		}
+
+		exists, err := Exists("hosts/http:")
+		check(err, t)
+		if exists {
+			t.FailNow()
+		}
+	}
+}
+
+func TestPath(t *testing.T) {
+	resetDB(t)
+
+	t.Log("Should build and inspect paths")
+
+	p := NewPath("hosts", "http://example.com")
+	if p.String() != `hosts/http:\/\/example.com` || p.Name() != "http://example.com" {
+		t.FailNow()
+	}
+
+	status := p.Join("status", "a/b")
+	if status.Parent().Parent() != p || status.Name() != "a/b" {
+		t.FailNow()
+	}
+
+	split := status.Split()
+	if len(split) != 4 || split[1] != "http://example.com" || split[3] != "a/b" {
+		t.FailNow()
+	}
+
+	if !Path("//").IsRoot() || !Path("a").Parent().IsRoot() || Path("").Join().String() != "" ||
+		Path("/a//b/").String() != "a/b" {
+		t.FailNow()
+	}
+
+	t.Log("Should match patterns segment by segment")
+
+	matches := map[string]bool{"hosts/*": true, "hosts/*/*": false, "*/http:*": true, "hosts/http:\\/\\/*": true}
+	for pattern, expected := range matches {
+		matched, err := p.Match(pattern)
+		check(err, t)
+		if matched != expected {
+			t.FailNow()
+		}
+	}
+
+	_, err := p.Match("[")
+	if err == nil {
+		t.FailNow()
+	}
+
+	t.Log("Should be usable with the API")
+
+	err = Set(status.String(), "up")
+	check(err, t)
+
+	v, err := Get[string](p.Join("status", "a/b").String())
+	check(err, t)
+	if v != "up" {
+		t.FailNow()
+	}
+
+	t.Log("Should validate paths")
+
+	err = Path("").Validate()
+	if !errors.Is(err, ErrPathInvalid) {
+		t.FailNow()
+	}
+
+	err = status.Validate()
+	check(err, t)
+}
+
+func TestNamespace(t *testing.T) {
+	resetDB(t)
+
+	a := Namespace("tenants/a")
+	b := Namespace("tenants/b")
+
+	t.Log("Should prefix the paths of the namespace")
+
+	err := a.Set("x/y", 1)
+	check(err, t)
+
+	err = b.Set("x/y", 2)
+	check(err, t)
+
+	v, err := a.Get("x/y")
+	check(err, t)
+	if v != "1" {
+		t.FailNow()
+	}
+
+	v, err = Get[string]("tenants/b/x/y")
+	check(err, t)
+	if v != "2" {
+		t.FailNow()
+	}
+
+	entry, err := a.GetEntry("")
+	check(err, t)
+	if entry.Path != "" || entry.Children["x"].Children["y"].Path != "x/y" {
+		t.FailNow()
+	}
+
+	err = a.Update(func(tx *Tx) error {
+		entry, err := tx.GetEntry("x")
+		if err != nil {
+			return err
+		}
+
+		if entry.Path != "x" {
+			return fmt.Errorf("unexpected path %s", entry.Path)
+		}
+
+		return tx.Move("x", "z")
+	})
+	check(err, t)
+
+	exists, err := Exists("tenants/a/z/y")
+	check(err, t)
+	if !exists {
+		t.FailNow()
+	}
+
+	t.Log("Should call hooks and watchers with relative paths")
+
+	hooked := ""
+	err = a.SetPostSetHook("z/y", func(path string, value string) error {
+		hooked = path
+		return nil
+	}, false)
+	check(err, t)
+
+	changes := make(chan Change, 10)
+	unwatch, err := a.Watch("", func(change Change) {
+		changes <- change
+	})
+	check(err, t)
+	defer unwatch()
+
+	err = a.Set("z/y", 3)
+	check(err, t)
+
+	if hooked != "z/y" {
+		t.FailNow()
+	}
+
+	select {
+	case c := <-changes:
+		if c.Path != "z/y" {
+			t.FailNow()
+		}
+	case <-time.After(time.Second):
+		t.FailNow()
+	}
+
+	t.Log("Should export and import within the namespace")
+
+	buf := bytes.Buffer{}
+	err = a.ExportJSON("", &buf, ExportOptions{Extended: true})
+	check(err, t)
+
+	c := Namespace("tenants").Namespace("c")
+	err = c.ImportJSON(&buf, ImportOptions{Extended: true})
+	check(err, t)
+
+	err = c.ImportJSON(strings.NewReader(`{"w": "4"}`), ImportOptions{Path: "v"})
+	check(err, t)
+
+	v, err = Get[string]("tenants/c/z/y")
+	check(err, t)
+	if v != "3" {
+		t.FailNow()
+	}
+
+	v, err = c.Get("v/w")
+	check(err, t)
+	if v != "4" {
+		t.FailNow()
+	}
+
+	t.Log("Should wipe only the namespace")
+
+	err = a.Wipe()
+	check(err, t)
+
+	exists, err = a.Exists("z/y")
+	check(err, t)
+	if exists {
+		t.FailNow()
+	}
+
+	exists, err = b.Exists("x/y")
+	check(err, t)
+	if !exists {
+		t.FailNow()
+	}
+}
+
+func TestMount(t *testing.T) {
+	resetDB(t)
+
+	dir := t.TempDir()
+	userPath := filepath.Join(dir, "user.db")
+	factoryPath := filepath.Join(dir, "factory.db")
+
+	err := Set("system/name", "main")
+	check(err, t)
+
+	t.Log("Should route the writes under a mount to the mounted file")
+
+	err = Mount("settings/user", userPath)
+	check(err, t)
+
+	err = Set("settings/user/theme", "dark")
+	check(err, t)
+
+	err = Set("settings/global", 1)
+	check(err, t)
+
+	v, err := Get[string]("settings/user/theme")
+	check(err, t)
+	if v != "dark" {
+		t.FailNow()
+	}
+
+	user, err := sql.Open("sqlite3", userPath)
+	check(err, t)
+	defer user.Close()
+
+	var count int
+	err = user.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s = ?", table, colPath),
+		"settings/user/theme").Scan(&count)
+	check(err, t)
+	if count != 1 {
+		t.FailNow()
+	}
+
+	err = user.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s = ?", table, colPath),
+		"settings/global").Scan(&count)
+	check(err, t)
+	if count != 0 {
+		t.FailNow()
+	}
+
+	t.Log("Should read the mounted Entries as part of the hierarchy")
+
+	entry, err := GetEntry("")
+	check(err, t)
+	if entry.Children["settings"].Children["user"].Children["theme"].Value != "dark" ||
+		entry.Children["settings"].Children["global"].Value != "1" ||
+		entry.Children["system"].Children["name"].Value != "main" {
+		t.FailNow()
+	}
+
+	children, err := GetEntryLazy("settings")
+	check(err, t)
+	loaded, err := children.GetChildren()
+	check(err, t)
+	if len(loaded) != 2 || loaded["user"] == nil || loaded["user"].IsValue {
+		t.FailNow()
+	}
+
+	t.Log("Should stream values under a mount")
+
+	data := bytes.Repeat([]byte{1, 2, 3}, streamChunkSize)
+	err = SetReader("settings/user/avatar", bytes.NewReader(data))
+	check(err, t)
+
+	read, err := GetBytes("settings/user/avatar")
+	check(err, t)
+	if !bytes.Equal(read, data) {
+		t.FailNow()
+	}
+
+	t.Log("Should fail to mount over existing or overlapping paths")
+
+	err = Mount("system", factoryPath)
+	if !errors.Is(err, ErrPathInvalid) {
+		t.FailNow()
+	}
+
+	err = Mount("settings/user/nested", factoryPath)
+	if !errors.Is(err, ErrPathInvalid) {
+		t.FailNow()
+	}
+
+	t.Log("Should fail to write to read-only mounts")
+
+	factory, err := sql.Open("sqlite3", factoryPath)
+	check(err, t)
+	defer factory.Close()
+
+	_, err = migrate(factory)
+	check(err, t)
+
+	_, err = factory.Exec(fmt.Sprintf("INSERT INTO %s (%s, %s, %s, %s, %s, %s) VALUES (?, 0, 0, '', '', "+
+		"(SELECT %s FROM %s WHERE %s = ''))", table, colPath, colLastUpdateMs, colIsValue, colValue, colWriter,
+		colParentID, colID, table, colPath), "defaults")
+	check(err, t)
+
+	_, err = factory.Exec(fmt.Sprintf("INSERT INTO %s (%s, %s, %s, %s, %s, %s, %s) VALUES (?, 0, 1, ?, ?, '', "+
+		"(SELECT %s FROM %s WHERE %s = 'defaults'))", table, colPath, colLastUpdateMs, colIsValue, colValue, colValueType,
+		colWriter, colParentID, colID, table, colPath), "defaults/volume", "10", TypeInt)
+	check(err, t)
+
+	err = MountWithOptions("defaults", factoryPath, MountOptions{ReadOnly: true})
+	check(err, t)
+
+	volume, err := Get[int]("defaults/volume")
+	check(err, t)
+	if volume != 10 {
+		t.FailNow()
+	}
+
+	err = Set("defaults/volume", 11)
+	if !errors.Is(err, ErrReadOnly) {
+		t.FailNow()
+	}
+
+	err = Delete("defaults")
+	if !errors.Is(err, ErrReadOnly) {
+		t.FailNow()
+	}
+
+	t.Log("Should delete the mounted Entries along with their parents")
+
+	err = Delete("settings")
+	check(err, t)
+
+	err = user.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s LIKE ?", table, colPath),
+		"settings/user%").Scan(&count)
+	check(err, t)
+	if count != 0 {
+		t.FailNow()
+	}
+
+	err = user.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s", chunksTable)).Scan(&count)
+	check(err, t)
+	if count != 0 {
+		t.FailNow()
+	}
+
+	t.Log("Should hide the Entries of a file once unmounted")
+
+	err = Set("settings/user/theme", "light")
+	check(err, t)
+
+	err = Unmount("settings/user")
+	check(err, t)
+
+	exists, err := Exists("settings/user/theme")
+	check(err, t)
+	if exists {
+		t.FailNow()
+	}
+
+	exists, err = Exists("defaults/volume")
+	check(err, t)
+	if !exists {
+		t.FailNow()
+	}
+
+	err = Unmount("settings/user")
+	if !errors.Is(err, ErrPathNotFound) {
+		t.FailNow()
+	}
+
+	t.Log("Should undo the mounts on close")
+
+	err = Close()
+	check(err, t)
+
+	_, err = Open(testDBPath)
+	check(err, t)
+
+	exists, err = Exists("defaults/volume")
+	check(err, t)
+	if exists {
+		t.FailNow()
+	}
+}
+
+func TestDefaults(t *testing.T) {
+	resetDB(t)
+
+	defaultsPath := filepath.Join(t.TempDir(), "defaults.db")
+
+	err := Close()
+	check(err, t)
+
+	_, err = Open(defaultsPath)
+	check(err, t)
+
+	err = Set("net/port", 80)
+	check(err, t)
+
+	err = Set("net/host", "localhost")
+	check(err, t)
+
+	err = Set("ui/theme", "light")
+	check(err, t)
+
+	err = Close()
+	check(err, t)
+
+	err = os.Remove(testDBPath)
+	check(err, t)
+
+	_, err = OpenWithOptions(testDBPath, Options{Defaults: defaultsPath})
+	check(err, t)
+
+	t.Log("Should fall through to the defaults")
+
+	port, err := Get[int]("net/port")
+	check(err, t)
+	if port != 80 {
+		t.FailNow()
+	}
+
+	t.Log("Should write the overrides to the user layer")
+
+	err = Set("net/port", 8080)
+	check(err, t)
+
+	err = Set("net/proxy", "none")
+	check(err, t)
+
+	port, err = Get[int]("net/port")
+	check(err, t)
+	if port != 8080 {
+		t.FailNow()
+	}
+
+	defaults, err := sql.Open("sqlite3", defaultsPath)
+	check(err, t)
+	defer defaults.Close()
+
+	var value string
+	err = defaults.QueryRow(fmt.Sprintf("SELECT %s FROM %s WHERE %s = ?", colValue, table, colPath),
+		"net/port").Scan(&value)
+	check(err, t)
+	if value != "80" {
+		t.FailNow()
+	}
+
+	entry, err := GetEntry("")
+	check(err, t)
+	net := entry.Children["net"]
+	if len(net.Children) != 3 || net.Children["port"].Value != "8080" || net.Children["host"].Value != "localhost" ||
+		net.Children["proxy"].Value != "none" || entry.Children["ui"].Children["theme"].Value != "light" {
+		t.FailNow()
+	}
+
+	entry, err = GetEntryLazy("net")
+	check(err, t)
+	children, err := entry.GetChildren()
+	check(err, t)
+	if len(children) != 3 {
+		t.FailNow()
+	}
+
+	t.Log("Should fail to delete the defaults")
+
+	err = Delete("net/host")
+	if !errors.Is(err, ErrReadOnly) {
+		t.FailNow()
+	}
+
+	err = Force("net", 1)
+	if !errors.Is(err, ErrReadOnly) {
+		t.FailNow()
+	}
+
+	err = Delete("net/proxy")
+	check(err, t)
+
+	t.Log("Should restore the defaults on reset")
+
+	changes := make(chan Change, 10)
+	unwatch, err := Watch("", func(change Change) {
+		changes <- change
+	})
+	check(err, t)
+	defer unwatch()
+
+	err = Reset("net/port")
+	check(err, t)
+
+	port, err = Get[int]("net/port")
+	check(err, t)
+	if port != 80 {
+		t.FailNow()
+	}
+
+	c := <-changes
+	if c.Type != ChangeUpdated || c.Path != "net/port" || c.OldValue != "8080" || c.Value != "80" {
+		t.FailNow()
+	}
+
+	err = Set("ui/theme", "dark")
+	check(err, t)
+
+	err = Set("extra/x", 1)
+	check(err, t)
+
+	<-changes
+	<-changes
+	<-changes
+
+	err = Reset("")
+	check(err, t)
+
+	theme, err := Get[string]("ui/theme")
+	check(err, t)
+	if theme != "light" {
+		t.FailNow()
+	}
+
+	exists, err := Exists("extra")
+	check(err, t)
+	if exists {
+		t.FailNow()
+	}
+
+	received := map[string]ChangeType{}
+	for i := 0; i < 2; i++ {
+		c = <-changes
+		received[c.Path] = c.Type
+	}
+
+	if received["ui/theme"] != ChangeUpdated || received["extra"] != ChangeDeleted {
+		t.FailNow()
+	}
+}
+
+func TestRuntime(t *testing.T) {
+	resetDB(t)
+
+	err := Set("net/ip", "0.0.0.0")
+	check(err, t)
+
+	err = Set("net/mode", "dhcp")
+	check(err, t)
+
+	changes := make(chan Change, 10)
+	unwatch, err := Watch("net", func(change Change) {
+		changes <- change
+	})
+	check(err, t)
+	defer unwatch()
+
+	t.Log("Should shadow and complement the persistent Entries")
+
+	err = SetRuntime("net/ip", "10.0.0.2")
+	check(err, t)
+
+	err = SetRuntime("net/link/up", true)
+	check(err, t)
+
+	ip, err := Get[string]("net/ip")
+	check(err, t)
+	if ip != "10.0.0.2" {
+		t.FailNow()
+	}
+
+	up, err := Get[bool]("net/link/up")
+	check(err, t)
+	if !up {
+		t.FailNow()
+	}
+
+	exists, err := Exists("net/link")
+	check(err, t)
+	if !exists {
+		t.FailNow()
+	}
+
+	entry, err := GetEntry("net")
+	check(err, t)
+	if entry.Children["ip"].Value != "10.0.0.2" || entry.Children["mode"].Value != "dhcp" ||
+		entry.Children["link"].Children["up"].Value != "true" {
+		t.FailNow()
+	}
+
+	entry, err = GetEntryLazy("net")
+	check(err, t)
+	children, err := entry.GetChildren()
+	check(err, t)
+	if len(children) != 3 || children["ip"].Value != "10.0.0.2" || children["link"].ChildrenLoaded() {
+		t.FailNow()
+	}
+
+	c := <-changes
+	if c.Type != ChangeCreated || c.Path != "net/ip" || c.Value != "10.0.0.2" {
+		t.FailNow()
+	}
+
+	<-changes
+
+	t.Log("Should leave the runtime values out of the DB and of the exports")
+
+	err = Update(func(tx *Tx) error {
+		v, err := tx.Get("net/ip")
+		if err == nil && v != "0.0.0.0" {
+			t.FailNow()
+		}
+
+		return err
+	})
+	check(err, t)
+
+	b := bytes.Buffer{}
+	err = ExportJSON("net", &b, ExportOptions{})
+	check(err, t)
+	if strings.Contains(b.String(), "10.0.0.2") || strings.Contains(b.String(), "link") {
+		t.FailNow()
+	}
+
+	b.Reset()
+	err = ExportJSON("net", &b, ExportOptions{Runtime: true})
+	check(err, t)
+	if !strings.Contains(b.String(), "10.0.0.2") || !strings.Contains(b.String(), "link") {
+		t.FailNow()
+	}
+
+	t.Log("Should uncover the persistent Entries once deleted")
+
+	err = DeleteRuntime("net")
+	check(err, t)
+
+	ip, err = Get[string]("net/ip")
+	check(err, t)
+	if ip != "0.0.0.0" {
+		t.FailNow()
+	}
+
+	exists, err = Exists("net/link")
+	check(err, t)
+	if exists {
+		t.FailNow()
+	}
+
+	t.Log("Should drop the runtime values on close")
+
+	err = SetRuntime("net/ip", "10.0.0.3")
+	check(err, t)
+
+	err = Close()
+	check(err, t)
+
+	_, err = Open(testDBPath)
+	check(err, t)
+
+	ip, err = Get[string]("net/ip")
+	check(err, t)
+	if ip != "0.0.0.0" {
+		t.FailNow()
+	}
+}
+
+func TestLoadDefaults(t *testing.T) {
+	resetDB(t)
+
+	err := Set("net/mode", "static")
+	check(err, t)
+
+	t.Log("Should set only the missing values")
+
+	err = LoadDefaults(strings.NewReader(`{"net": {"mode": "dhcp", "mtu": 1500, "ipv6": true}}`))
+	check(err, t)
+
+	mode, err := Get[string]("net/mode")
+	check(err, t)
+	if mode != "static" {
+		t.Fatalf("Expected static, got %s", mode)
+	}
+
+	entry, err := GetEntry("net/mtu")
+	check(err, t)
+	if entry.Value != "1500" || entry.Type != TypeInt {
+		t.Fatalf("Expected int 1500, got %s %s", entry.Type, entry.Value)
+	}
+
+	t.Log("Should load the matching files in lexical order")
+
+	fsys := fstest.MapFS{
+		"defaults/a.json": {Data: []byte(`{"app": {"name": "first", "level": "info"}}`)},
+		"defaults/b.json": {Data: []byte(`{"app": {"name": "second", "port": 8080}}`)},
+		"defaults/c.txt":  {Data: []byte(`not json`)},
+		"other/d.json":    {Data: []byte(`{"other": "x"}`)},
+		"broken/e.json":   {Data: []byte(`{`)},
+		"yaml/f.yaml":     {Data: []byte(`app: {}`)},
+		"yaml/g.json":     {Data: []byte(`{"yaml": "json"}`)}}
+
+	err = LoadDefaultsFS(fsys, "defaults/*.json")
+	check(err, t)
+
+	name, err := Get[string]("app/name")
+	check(err, t)
+	if name != "first" {
+		t.Fatalf("Expected first, got %s", name)
+	}
+
+	port, err := Get[int]("app/port")
+	check(err, t)
+	if port != 8080 {
+		t.Fatalf("Expected 8080, got %d", port)
+	}
+
+	exists, err := Exists("other")
+	check(err, t)
+	if exists {
+		t.Fatalf("Expected other to not exist")
+	}
+
+	t.Log("Should fail on malformed and YAML files")
+
+	err = LoadDefaultsFS(fsys, "broken/*")
+	if err == nil {
+		t.Fatalf("Expected error loading malformed file")
+	}
+
+	err = LoadDefaultsFS(fsys, "yaml/*")
+	if err == nil {
+		t.Fatalf("Expected error loading YAML file")
+	}
+
+	exists, err = Exists("yaml")
+	check(err, t)
+	if exists {
+		t.Fatalf("Expected no file to be loaded")
+	}
+}
+
+func TestSettings(t *testing.T) {
+	resetDB(t)
+
+	err := Set("app/log/level", "info")
+	check(err, t)
+
+	err = Set("app/port", 8080)
+	check(err, t)
+
+	err = Set("app/debug", false)
+	check(err, t)
+
+	t.Log("Should return the hierarchy as nested maps")
+
+	settings, err := GetSettings("app")
+	check(err, t)
+
+	log, ok := settings["log"].(map[string]any)
+	if !ok || log["level"] != "info" || settings["port"] != float64(8080) || settings["debug"] != false {
+		t.Fatalf("Unexpected settings %v", settings)
+	}
+
+	_, err = GetSettings("app/port")
+	if !errors.Is(err, ErrTypeMismatch) {
+		t.Fatalf("Expected ErrTypeMismatch, got %v", err)
+	}
+
+	t.Log("Should persist nested maps with their types")
+
+	changes := make(chan map[string]any, 10)
+	unwatch, err := WatchSettings("app", func(settings map[string]any, err error) {
+		if err == nil {
+			changes <- settings
+		}
+	})
+	check(err, t)
+	defer unwatch()
+
+	err = SetSettings("app", map[string]any{"log": map[string]any{"level": "debug"}, "workers": 4})
+	check(err, t)
+
+	level, err := Get[string]("app/log/level")
+	check(err, t)
+	if level != "debug" {
+		t.Fatalf("Expected debug, got %s", level)
+	}
+
+	entry, err := GetEntry("app/workers")
+	check(err, t)
+	if entry.Value != "4" || entry.Type != TypeInt {
+		t.Fatalf("Expected int 4, got %s %s", entry.Type, entry.Value)
+	}
+
+	port, err := Get[int]("app/port")
+	check(err, t)
+	if port != 8080 {
+		t.Fatalf("Expected untouched port, got %d", port)
+	}
+
+	t.Log("Should notify the updated settings")
+
+	select {
+	case settings := <-changes:
+		if _, ok := settings["workers"]; !ok {
+			t.Fatalf("Expected updated settings, got %v", settings)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timeout waiting for settings")
+	}
+
+	t.Log("Should notify the settings once for each transaction")
+
+	select {
+	case settings := <-changes:
+		t.Fatalf("Unexpected second notification %v", settings)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestBindFlags(t *testing.T) {
+	resetDB(t)
+
+	err := Set("server/host", "localhost")
+	check(err, t)
+
+	err = Set("server/tls/port", 443)
+	check(err, t)
+
+	err = Set("server/verbose", false)
+	check(err, t)
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	binding, err := BindFlags(fs, "server")
+	check(err, t)
+
+	t.Log("Should register a flag for each value")
+
+	for _, name := range []string{"host", "tls.port", "verbose"} {
+		if fs.Lookup(name) == nil {
+			t.Fatalf("Expected flag %s", name)
+		}
+	}
+
+	if fs.Lookup("tls.port").DefValue != "443" {
+		t.Fatalf("Expected default 443, got %s", fs.Lookup("tls.port").DefValue)
+	}
+
+	t.Log("Should reject values of the wrong type")
+
+	err = fs.Parse([]string{"-tls.port", "abc"})
+	if err == nil {
+		t.Fatalf("Expected error parsing invalid int")
+	}
+
+	t.Log("Should apply the overrides as runtime values")
+
+	err = fs.Parse([]string{"-tls.port", "8443", "-verbose"})
+	check(err, t)
+
+	port, err := Get[int]("server/tls/port")
+	check(err, t)
+	if port != 8443 {
+		t.Fatalf("Expected 8443, got %d", port)
+	}
+
+	verbose, err := Get[bool]("server/verbose")
+	check(err, t)
+	if !verbose {
+		t.Fatalf("Expected verbose to be true")
+	}
+
+	changed := binding.Changed()
+	if len(changed) != 2 || changed[0] != "server/tls/port" || changed[1] != "server/verbose" {
+		t.Fatalf("Unexpected changed paths %v", changed)
+	}
+
+	err = DeleteRuntime("server")
+	check(err, t)
+
+	port, err = Get[int]("server/tls/port")
+	check(err, t)
+	if port != 443 {
+		t.Fatalf("Expected the persistent value to be untouched, got %d", port)
+	}
+
+	t.Log("Should persist the overrides")
+
+	err = fs.Parse([]string{"-host", "example.com"})
+	check(err, t)
+
+	err = binding.Persist()
+	check(err, t)
+
+	err = DeleteRuntime("server")
+	check(err, t)
+
+	host, err := Get[string]("server/host")
+	check(err, t)
+	if host != "example.com" {
+		t.Fatalf("Expected example.com, got %s", host)
+	}
+
+	t.Log("Should fail on flags already defined")
+
+	_, err = BindFlags(fs, "server")
+	if err == nil {
+		t.Fatalf("Expected error binding flags twice")
+	}
+}
+
+func TestInterpolation(t *testing.T) {
+	resetDB(t)
+	defer SetInterpolationEnabled(false)
+
+	err := Set("network/hostname", "device-01")
+	check(err, t)
+
+	err = Set("network/port", 1883)
+	check(err, t)
+
+	err = Set("mqtt/client_id", "${network/hostname}-mqtt")
+	check(err, t)
+
+	err = Set("mqtt/port", "${/network/port}")
+	check(err, t)
+
+	err = Set("mqtt/url", "tcp://${mqtt/client_id}:${mqtt/port} $${literal}")
+	check(err, t)
+
+	t.Log("Should read references as they are when disabled")
+
+	id, err := Get[string]("mqtt/client_id")
+	check(err, t)
+	if id != "${network/hostname}-mqtt" {
+		t.Fatalf("Expected the raw value, got %s", id)
+	}
+
+	t.Log("Should resolve references when enabled")
+
+	SetInterpolationEnabled(true)
+
+	id, err = Get[string]("mqtt/client_id")
+	check(err, t)
+	if id != "device-01-mqtt" {
+		t.Fatalf("Expected device-01-mqtt, got %s", id)
+	}
+
+	port, err := Get[int]("mqtt/port")
+	check(err, t)
+	if port != 1883 {
+		t.Fatalf("Expected 1883, got %d", port)
+	}
+
+	entry, err := GetEntry("mqtt")
+	check(err, t)
+	if entry.Children["url"].Value != "tcp://device-01-mqtt:1883 ${literal}" {
+		t.Fatalf("Unexpected url %s", entry.Children["url"].Value)
+	}
+
+	if entry.Children["port"].Type != TypeInt {
+		t.Fatalf("Expected the type of the referenced value, got %s", entry.Children["port"].Type)
+	}
+
+	entry, err = GetEntryDepth("", 1)
+	check(err, t)
+
+	mqtt := entry.Children["mqtt"]
+	err = mqtt.LoadChildren()
+	check(err, t)
+	if mqtt.Children["client_id"].Value != "device-01-mqtt" {
+		t.Fatalf("Unexpected loaded client_id %s", mqtt.Children["client_id"].Value)
+	}
+
+	t.Log("Should follow runtime values")
+
+	err = SetRuntime("network/hostname", "device-02")
+	check(err, t)
+
+	id, err = Get[string]("mqtt/client_id")
+	check(err, t)
+	if id != "device-02-mqtt" {
+		t.Fatalf("Expected device-02-mqtt, got %s", id)
+	}
+
+	err = DeleteRuntime("network")
+	check(err, t)
+
+	t.Log("Should fail on cycles and missing references")
+
+	err = Set("a", "${b}")
+	check(err, t)
+
+	err = Set("b", "x${a}")
+	check(err, t)
+
+	_, err = Get[string]("a")
+	if !errors.Is(err, ErrReferenceCycle) {
+		t.Fatalf("Expected ErrReferenceCycle, got %v", err)
+	}
+
+	err = Set("c", "${missing}")
+	check(err, t)
+
+	_, err = Get[string]("c")
+	if !errors.Is(err, ErrPathNotFound) {
+		t.Fatalf("Expected ErrPathNotFound, got %v", err)
+	}
+
+	t.Log("Should export values as they are")
+
+	buf := bytes.Buffer{}
+	err = ExportJSON("mqtt", &buf, ExportOptions{})
+	check(err, t)
+	if !strings.Contains(buf.String(), "${network/hostname}-mqtt") {
+		t.Fatalf("Expected raw values in export, got %s", buf.String())
+	}
+}
+
+func TestProviders(t *testing.T) {
+	resetDB(t)
+
+	err := Set("sys/hostname", "device")
+	check(err, t)
+
+	unregister, err := RegisterProvider("sys/uptime", func(path string) (string, error) {
+		return "42", nil
+	})
+	check(err, t)
+
+	_, err = RegisterProvider("sys/thermal/**", func(path string) (string, error) {
+		if path == "sys/thermal/cpu" {
+			return "55.5", nil
+		}
+
+		return "", ErrPathNotFound
+	})
+	check(err, t)
+
+	t.Log("Should compute the values of the matching paths")
+
+	uptime, err := Get[int]("sys/uptime")
+	check(err, t)
+	if uptime != 42 {
+		t.Fatalf("Expected 42, got %d", uptime)
+	}
+
+	temp, err := Get[float64]("sys/thermal/cpu")
+	check(err, t)
+	if temp != 55.5 {
+		t.Fatalf("Expected 55.5, got %f", temp)
+	}
+
+	_, err = Get[string]("sys/thermal/gpu")
+	if !errors.Is(err, ErrPathNotFound) {
+		t.Fatalf("Expected ErrPathNotFound, got %v", err)
+	}
+
+	t.Log("Should list the values of single paths")
+
+	entry, err := GetEntry("sys")
+	check(err, t)
+	if len(entry.Children) != 2 || entry.Children["uptime"].Value != "42" ||
+		entry.Children["hostname"].Value != "device" {
+		t.Fatalf("Unexpected children %v", entry.Children)
+	}
+
+	exists, err := Exists("sys/uptime")
+	check(err, t)
+	if !exists {
+		t.Fatalf("Expected sys/uptime to exist")
+	}
+
+	buf := bytes.Buffer{}
+	err = ExportJSON("sys", &buf, ExportOptions{Runtime: true})
+	check(err, t)
+	if !strings.Contains(buf.String(), `"uptime": "42"`) {
+		t.Fatalf("Expected the computed value in export, got %s", buf.String())
+	}
+
+	t.Log("Should reject writes to the computed paths")
+
+	err = Set("sys/thermal/cpu", 10)
+	if !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("Expected ErrReadOnly, got %v", err)
+	}
+
+	t.Log("Should stop computing values once unregistered")
+
+	unregister()
+
+	_, err = Get[string]("sys/uptime")
+	if !errors.Is(err, ErrPathNotFound) {
+		t.Fatalf("Expected ErrPathNotFound, got %v", err)
+	}
+
+	t.Log("Should reject invalid patterns")
+
+	_, err = RegisterProvider("sys/**/x", func(path string) (string, error) {
+		return "", nil
+	})
+	if err == nil {
+		t.Fatalf("Expected error registering invalid pattern")
+	}
+}
+
+func TestVirtualMounts(t *testing.T) {
+	resetDB(t)
+
+	dir := t.TempDir()
+	err := os.MkdirAll(filepath.Join(dir, "ipv4"), 0755)
+	check(err, t)
+
+	err = os.WriteFile(filepath.Join(dir, "ipv4", "ip_forward"), []byte("1\n"), 0644)
+	check(err, t)
+
+	err = os.WriteFile(filepath.Join(dir, "hostname"), []byte("device"), 0644)
+	check(err, t)
+
+	t.Setenv("CAMELLIA_TEST_VAR", "value")
+
+	err = MountEnv("env")
+	check(err, t)
+
+	err = MountDir("sys/net", dir)
+	check(err, t)
+
+	t.Log("Should read the mounted sources")
+
+	v, err := Get[string]("env/CAMELLIA_TEST_VAR")
+	check(err, t)
+	if v != "value" {
+		t.Fatalf("Expected value, got %s", v)
+	}
+
+	forward, err := Get[int]("sys/net/ipv4/ip_forward")
+	check(err, t)
+	if forward != 1 {
+		t.Fatalf("Expected 1, got %d", forward)
+	}
+
+	_, err = Get[string]("sys/net/ipv4")
+	if !errors.Is(err, ErrPathIsNotAValue) {
+		t.Fatalf("Expected ErrPathIsNotAValue, got %v", err)
+	}
+
+	_, err = Get[string]("sys/net/../hostname")
+	if err == nil {
+		t.Fatalf("Expected error reading outside of the directory")
+	}
+
+	t.Log("Should browse the mounted sources")
+
+	entry, err := GetEntry("sys")
+	check(err, t)
+
+	net := entry.Children["net"]
+	if net == nil || net.Children["hostname"].Value != "device" ||
+		net.Children["ipv4"].Children["ip_forward"].Value != "1" {
+		t.Fatalf("Unexpected entry %v", entry)
+	}
+
+	exists, err := Exists("sys/net/ipv4")
+	check(err, t)
+	if !exists {
+		t.Fatalf("Expected sys/net/ipv4 to exist")
+	}
+
+	entry, err = GetEntry("env")
+	check(err, t)
+	if entry.Children["CAMELLIA_TEST_VAR"] == nil {
+		t.Fatalf("Expected the environment variable in %v", entry.Children)
+	}
+
+	t.Log("Should reject writes and overlapping mounts")
+
+	err = Set("sys/net/hostname", "other")
+	if !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("Expected ErrReadOnly, got %v", err)
+	}
+
+	err = MountEnv("sys/net/env")
+	if !errors.Is(err, ErrPathInvalid) {
+		t.Fatalf("Expected ErrPathInvalid, got %v", err)
+	}
+
+	t.Log("Should unmount the sources")
+
+	err = Unmount("sys/net")
+	check(err, t)
+
+	exists, err = Exists("sys/net/hostname")
+	check(err, t)
+	if exists {
+		t.Fatalf("Expected sys/net/hostname to not exist")
+	}
+}
+
+func TestExportToDir(t *testing.T) {
+	resetDB(t)
+
+	err := Set("app/name", "camellia")
+	check(err, t)
+
+	err = Set("app/ports/http", 8080)
+	check(err, t)
+
+	err = SetBytes("app/logo", []byte{0xff, 0x00, 0xfe})
+	check(err, t)
+
+	err = Set("app/"+EscapeSegment("http://host")+"/status", "up")
+	check(err, t)
+
+	err = ImportJSON(strings.NewReader(`{"children": {"empty": {"children": {}}}}`), ImportOptions{Extended: true, Path: "app"})
+	check(err, t)
+
+	entry, err := GetEntry("app/name")
+	check(err, t)
+
+	t.Log("Should write values as files and branches as directories")
+
+	dir := t.TempDir()
+	err = ExportToDir("app", dir)
+	check(err, t)
+
+	content, err := os.ReadFile(filepath.Join(dir, "ports", "http"))
+	check(err, t)
+	if string(content) != "8080" {
+		t.Fatalf("Expected 8080, got %s", content)
+	}
+
+	content, err = os.ReadFile(filepath.Join(dir, "logo"))
+	check(err, t)
+	if !bytes.Equal(content, []byte{0xff, 0x00, 0xfe}) {
+		t.Fatalf("Unexpected binary content %v", content)
+	}
+
+	_, err = os.Stat(filepath.Join(dir, "http:%2F%2Fhost", "status"))
+	check(err, t)
+
+	info, err := os.Stat(filepath.Join(dir, "empty"))
+	check(err, t)
+	if !info.IsDir() {
+		t.Fatalf("Expected a directory for the empty non-value Entry")
+	}
+
+	t.Log("Should import the directory back")
+
+	err = ImportFromDir(dir, ImportOptions{Path: "copy"})
+	check(err, t)
+
+	for _, path := range []string{"name", "ports/http", EscapeSegment("http://host") + "/status"} {
+		original, err := Get[string]("app/" + path)
+		check(err, t)
+
+		copied, err := Get[string]("copy/" + path)
+		check(err, t)
+
+		if original != copied {
+			t.Fatalf("Expected %s, got %s at %s", original, copied, path)
+		}
+	}
+
+	logo, err := GetBytes("copy/logo")
+	check(err, t)
+	if !bytes.Equal(logo, []byte{0xff, 0x00, 0xfe}) {
+		t.Fatalf("Unexpected imported binary value %v", logo)
+	}
+
+	t.Log("Should round-trip through a tar archive")
+
+	buf := bytes.Buffer{}
+	err = ExportToTar("app", &buf)
+	check(err, t)
+
+	found := false
+	tr := tar.NewReader(bytes.NewReader(buf.Bytes()))
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		check(err, t)
+
+		if header.Name == "name" {
+			found = true
+			diff := header.ModTime.Sub(entry.LastUpdate)
+			if diff < -time.Second || diff > time.Second {
+				t.Fatalf("Expected time %v, got %v", entry.LastUpdate, header.ModTime)
+			}
+		}
+	}
+
+	if !found {
+		t.Fatalf("Expected name in the archive")
+	}
+
+	err = ImportFromTar(&buf, ImportOptions{Path: "tar", OnlyMerge: true})
+	check(err, t)
+
+	port, err := Get[int]("tar/ports/http")
+	check(err, t)
+	if port != 8080 {
+		t.Fatalf("Expected 8080, got %d", port)
+	}
+
+	exists, err := Exists("tar/empty")
+	check(err, t)
+	if !exists {
+		t.Fatalf("Expected tar/empty to exist")
+	}
+
+	t.Log("Should fail on values")
+
+	err = ExportToDir("app/name", t.TempDir())
+	if !errors.Is(err, ErrTypeMismatch) {
+		t.Fatalf("Expected ErrTypeMismatch, got %v", err)
+	}
+}
+
+func TestApplyReplicated(t *testing.T) {
+	resetDB(t)
+
+	err := Set("app/stale", "s")
+	check(err, t)
+
+	err = Set("app/kept", "old")
+	check(err, t)
+
+	err = Set("other", "o")
+	check(err, t)
+
+	entry := Entry{}
+	err = json.Unmarshal([]byte(`{"children": {
+		"kept": {"value": "new"},
+		"port": {"value": "8080", "type": "int"},
+		"logo": {"value": "/wD+", "type": "bytes"},
+		"sub": {"children": {}}}}`), &entry)
+	check(err, t)
+
+	t.Log("Should replace the hierarchy with the replicated one")
+
+	err = ApplyReplicated([]ReplicatedEntry{{Path: "app", Entry: &entry}}, 42)
+	check(err, t)
+
+	exists, err := Exists("app/stale")
+	check(err, t)
+	if exists {
+		t.Fatal("Expected app/stale to be deleted")
+	}
+
+	kept, err := Get[string]("app/kept")
+	check(err, t)
+	if kept != "new" {
+		t.Fatalf("Expected new, got %s", kept)
+	}
+
+	port, err := GetEntry("app/port")
+	check(err, t)
+	if port.Type != TypeInt || port.Value != "8080" {
+		t.Fatalf("Unexpected replicated value %+v", port)
+	}
+
+	logo, err := GetBytes("app/logo")
+	check(err, t)
+	if !bytes.Equal(logo, []byte{0xff, 0x00, 0xfe}) {
+		t.Fatalf("Unexpected replicated bytes %v", logo)
+	}
+
+	sub, err := GetEntry("app/sub")
+	check(err, t)
+	if sub.IsValue {
+		t.Fatal("Expected app/sub to be a non-value")
+	}
+
+	other, err := Get[string]("other")
+	check(err, t)
+	if other != "o" {
+		t.Fatalf("Expected entries outside the replicated path to be left alone, got %s", other)
+	}
+
+	t.Log("Should record the replicated revision")
+
+	revision, err := GetReplicatedRevision()
+	check(err, t)
+	if revision != 42 {
+		t.Fatalf("Expected 42, got %d", revision)
+	}
+
+	t.Log("Should delete the Entries missing on the primary")
+
+	err = ApplyReplicated([]ReplicatedEntry{{Path: "app/kept"}, {Path: "missing"}}, 43)
+	check(err, t)
+
+	exists, err = Exists("app/kept")
+	check(err, t)
+	if exists {
+		t.Fatal("Expected app/kept to be deleted")
+	}
+
+	t.Log("Should replace a value with a non-value")
+
+	entry = Entry{}
+	err = json.Unmarshal([]byte(`{"children": {"x": {"value": "1"}}}`), &entry)
+	check(err, t)
+
+	err = ApplyReplicated([]ReplicatedEntry{{Path: "other", Entry: &entry}}, 44)
+	check(err, t)
+
+	x, err := Get[string]("other/x")
+	check(err, t)
+	if x != "1" {
+		t.Fatalf("Expected 1, got %s", x)
+	}
+}
+
+func TestImportJSONWithBase(t *testing.T) {
+	resetDB(t)
+
+	base := `{"net": {"mtu": "1500", "dns": "8.8.8.8", "old": {"x": "1"}}, "ui": {"theme": "light"}, "gone": "1",
+		"same": "a"}`
+	incoming := `{"net": {"mtu": "9000", "dns": "9.9.9.9", "new": "n"}, "ui": {"theme": "light", "lang": "en"},
+		"same": "b"}`
+
+	err := ImportJSON(strings.NewReader(base), ImportOptions{})
+	check(err, t)
+
+	check(Set("ui/theme", "dark"), t)
+	check(Set("net/dns", "1.1.1.1"), t)
+	check(Set("gone", "2"), t)
+	check(Set("same", "b"), t)
+	check(Set("user/x", "y"), t)
+
+	t.Log("Should preview the merge without applying it")
+
+	changes, conflicts, err := DryRunImportJSONWithBase(strings.NewReader(base), strings.NewReader(incoming),
+		ImportOptions{})
+	check(err, t)
+
+	if len(changes) == 0 || len(conflicts) != 2 {
+		t.Fatalf("Expected changes and 2 conflicts, got %v and %v", changes, conflicts)
+	}
+
+	mtu, err := Get[string]("net/mtu")
+	check(err, t)
+	if mtu != "1500" {
+		t.Fatalf("Expected 1500, got %s", mtu)
+	}
+
+	t.Log("Should apply only the changes of the incoming side, keeping the local ones")
+
+	conflicts, err = ImportJSONWithBase(strings.NewReader(base), strings.NewReader(incoming), ImportOptions{})
+	check(err, t)
+
+	if len(conflicts) != 2 || conflicts[0] != "gone" || conflicts[1] != "net/dns" {
+		t.Fatalf("Expected conflicts on gone and net/dns, got %v", conflicts)
+	}
+
+	expected := map[string]string{
+		"net/mtu":  "9000",
+		"net/dns":  "1.1.1.1",
+		"net/new":  "n",
+		"ui/theme": "dark",
+		"ui/lang":  "en",
+		"gone":     "2",
+		"same":     "b",
+		"user/x":   "y",
+	}
+
+	for p, v := range expected {
+		value, err := Get[string](p)
+		check(err, t)
+		if value != v {
+			t.Fatalf("Expected %s at %s, got %s", v, p, value)
+		}
+	}
+
+	exists, err := Exists("net/old")
+	check(err, t)
+	if exists {
+		t.Fatal("Expected net/old to be deleted")
+	}
+
+	t.Log("Should not replace a non-value changed locally with a value")
+
+	base = `{"a": {"b": "1"}}`
+	incoming = `{"a": "2"}`
+
+	check(ImportJSON(strings.NewReader(base), ImportOptions{Path: "tree"}), t)
+	check(Set("tree/a/c", "3"), t)
+
+	conflicts, err = ImportJSONWithBase(strings.NewReader(base), strings.NewReader(incoming),
+		ImportOptions{Path: "tree"})
+	check(err, t)
+
+	if len(conflicts) != 1 || conflicts[0] != "tree/a" {
+		t.Fatalf("Expected conflict on tree/a, got %v", conflicts)
+	}
+
+	c, err := Get[string]("tree/a/c")
+	check(err, t)
+	if c != "3" {
+		t.Fatalf("Expected 3, got %s", c)
+	}
+
+	check(Delete("tree/a/c"), t)
+
+	conflicts, err = ImportJSONWithBase(strings.NewReader(base), strings.NewReader(incoming),
+		ImportOptions{Path: "tree"})
+	check(err, t)
+
+	a, err := Get[string]("tree/a")
+	check(err, t)
+	if len(conflicts) != 0 || a != "2" {
+		t.Fatalf("Expected no conflicts and 2, got %v and %s", conflicts, a)
+	}
+}
+
+func TestJSONPatch(t *testing.T) {
+	resetDB(t)
+
+	check(ImportJSON(strings.NewReader(`{"app": {"name": "x", "net": {"mtu": "1500"}, "tags": ["a", "b"]}}`),
+		ImportOptions{}), t)
+	check(SetList("app/tags", []string{"a", "b"}), t)
+
+	t.Log("Should apply the operations of a patch")
+
+	patch := `[
+		{"op": "test", "path": "/name", "value": "x"},
+		{"op": "replace", "path": "/name", "value": "y"},
+		{"op": "add", "path": "/net/gw", "value": "10.0.0.1"},
+		{"op": "add", "path": "/opts", "value": {"a": {"b": 1}}},
+		{"op": "copy", "from": "/net", "path": "/net2"},
+		{"op": "move", "from": "/net2/mtu", "path": "/mtu"},
+		{"op": "add", "path": "/tags/-", "value": "c"},
+		{"op": "remove", "path": "/tags/0"},
+		{"op": "add", "path": "/we~1ird~0", "value": "w"}
+	]`
+
+	changes, err := ApplyJSONPatch("app", strings.NewReader(patch))
+	check(err, t)
+
+	if len(changes) == 0 {
+		t.Fatal("Expected changes")
+	}
+
+	expected := map[string]string{
+		"app/name":     "y",
+		"app/net/gw":   "10.0.0.1",
+		"app/net/mtu":  "1500",
+		"app/opts/a/b": "1",
+		"app/net2/gw":  "10.0.0.1",
+		"app/mtu":      "1500",
+		`app/we\/ird~`: "w",
+	}
+
+	for p, v := range expected {
+		value, err := Get[string](p)
+		check(err, t)
+		if value != v {
+			t.Fatalf("Expected %s at %s, got %s", v, p, value)
+		}
+	}
+
+	tags, err := GetList[string]("app/tags")
+	check(err, t)
+	if len(tags) != 2 || tags[0] != "b" || tags[1] != "c" {
+		t.Fatalf("Expected [b c], got %v", tags)
+	}
+
+	t.Log("Should apply nothing if an operation fails")
+
+	_, err = ApplyJSONPatch("app", strings.NewReader(`[
+		{"op": "replace", "path": "/name", "value": "z"},
+		{"op": "test", "path": "/net", "value": {"mtu": "1500"}}
+	]`))
+	if !errors.Is(err, ErrPatchTestFailed) {
+		t.Fatalf("Expected ErrPatchTestFailed, got %v", err)
+	}
+
+	_, err = ApplyJSONPatch("app", strings.NewReader(`[{"op": "remove", "path": "/missing"}]`))
+	if !errors.Is(err, ErrPathNotFound) {
+		t.Fatalf("Expected ErrPathNotFound, got %v", err)
+	}
+
+	name, err := Get[string]("app/name")
+	check(err, t)
+	if name != "y" {
+		t.Fatalf("Expected y, got %s", name)
+	}
+
+	t.Log("Should emit a patch reproducing the changes of an import")
+
+	changes, err = DryRunImportJSON(strings.NewReader(`{"name": "z", "new": {"k": "v"}}`),
+		ImportOptions{Path: "app"})
+	check(err, t)
+
+	operations := JSONPatchFromChanges("app", changes)
+	encoded, err := json.Marshal(operations)
+	check(err, t)
+
+	_, err = ApplyJSONPatch("app", bytes.NewReader(encoded))
+	check(err, t)
+
+	k, err := Get[string]("app/new/k")
+	check(err, t)
+	name, err = Get[string]("app/name")
+	check(err, t)
+	if k != "v" || name != "z" {
+		t.Fatalf("Expected v and z, got %s and %s", k, name)
+	}
+}
+
+func TestJSONMergePatch(t *testing.T) {
+	resetDB(t)
+
+	check(ImportJSON(strings.NewReader(`{"app": {"name": "x", "net": {"mtu": "1500", "gw": "10.0.0.1"}, "v": "1"}}`),
+		ImportOptions{}), t)
+
+	t.Log("Should merge objects, replace values and delete nulls")
+
+	changes, err := ApplyJSONMergePatch("app", strings.NewReader(
+		`{"name": "y", "net": {"gw": null, "dns": "1.1.1.1"}, "v": {"major": 2}, "missing": null}`))
+	check(err, t)
+
+	if len(changes) == 0 {
+		t.Fatal("Expected changes")
+	}
+
+	expected := map[string]string{
+		"app/name":    "y",
+		"app/net/mtu": "1500",
+		"app/net/dns": "1.1.1.1",
+		"app/v/major": "2",
+	}
+
+	for p, v := range expected {
+		value, err := Get[string](p)
+		check(err, t)
+		if value != v {
+			t.Fatalf("Expected %s at %s, got %s", v, p, value)
+		}
+	}
+
+	exists, err := Exists("app/net/gw")
+	check(err, t)
+	if exists {
+		t.Fatal("Expected app/net/gw to be deleted")
+	}
+
+	t.Log("Should not apply a dry run")
+
+	_, err = DryRunJSONMergePatch("app", strings.NewReader(`{"name": "z"}`))
+	check(err, t)
+
+	name, err := Get[string]("app/name")
+	check(err, t)
+	if name != "y" {
+		t.Fatalf("Expected y, got %s", name)
+	}
+}
+
+func TestBackupSince(t *testing.T) {
+	resetDB(t)
+
+	check(Set("app/name", "x"), t)
+	check(Set("app/net/mtu", 1500), t)
+	check(Set("other/key", "v"), t)
+
+	t.Log("Should archive the whole DB without a revision")
+
+	full := bytes.Buffer{}
+	revision, err := BackupSince(0, &full)
+	check(err, t)
+
+	check(Set("app/name", "y"), t)
+	check(Set("app/net/gw", "10.0.0.1"), t)
+	check(Delete("other"), t)
+
+	t.Log("Should archive only the changes after a revision")
+
+	incremental := bytes.Buffer{}
+	next, err := BackupSince(revision, &incremental)
+	check(err, t)
+
+	if next <= revision {
+		t.Fatalf("Expected a revision after %d, got %d", revision, next)
+	}
+
+	if incremental.Len() >= full.Len()*2 {
+		t.Fatalf("Expected a compact archive, got %d bytes", incremental.Len())
+	}
+
+	t.Log("Should rebuild the DB restoring the archives in order")
+
+	check(Wipe(), t)
+	check(Set("stale", "s"), t)
+
+	restored, err := RestoreBackup(&full)
+	check(err, t)
+
+	if restored != revision {
+		t.Fatalf("Expected revision %d, got %d", revision, restored)
+	}
+
+	exists, err := Exists("stale")
+	check(err, t)
+	if exists {
+		t.Fatal("Expected stale to be replaced by the full backup")
+	}
+
+	_, err = RestoreBackup(&incremental)
+	check(err, t)
+
+	name, err := Get[string]("app/name")
+	check(err, t)
+	mtu, err := Get[int]("app/net/mtu")
+	check(err, t)
+	gw, err := Get[string]("app/net/gw")
+	check(err, t)
+	if name != "y" || mtu != 1500 || gw != "10.0.0.1" {
+		t.Fatalf("Unexpected values %s, %d and %s", name, mtu, gw)
+	}
+
+	entry, err := GetEntry("app/net/mtu")
+	check(err, t)
+	if entry.Type != TypeInt {
+		t.Fatalf("Expected type %s, got %s", TypeInt, entry.Type)
+	}
+
+	exists, err = Exists("other")
+	check(err, t)
+	if exists {
+		t.Fatal("Expected other to be deleted")
+	}
+}
+
+func TestListHooks(t *testing.T) {
+	resetDB(t)
+
+	check(SetPostSetHook("hooks/b", func(path, value string) error {
+		return nil
+	}, false), t)
+
+	check(SetPreSetHook("hooks/b", func(path, value string) error {
+		if value == "bad" {
+			return errors.New("bad value")
+		}
+
+		return nil
+	}), t)
+
+	check(SetPreSetHook("hooks/a", func(path, value string) error {
+		return nil
+	}), t)
+
+	t.Log("Should count the invocations and the errors of the hooks")
+
+	check(Set("hooks/b", "good"), t)
+	if Set("hooks/b", "bad") == nil {
+		t.Fatal("Expected error")
+	}
+
+	check(Set("hooks/a/c", "child"), t)
+
+	infos := ListHooks()
+	if len(infos) != 3 {
+		t.Fatalf("Expected 3 hooks, got %v", infos)
+	}
+
+	expected := []HookInfo{
+		{Path: "hooks/a", Type: "pre"},
+		{Path: "hooks/b", Type: "pre", Invocations: 2, Errors: 1},
+		{Path: "hooks/b", Type: "post", Invocations: 1},
+	}
+
+	for i, e := range expected {
+		if infos[i] != e {
+			t.Fatalf("Expected %v, got %v", e, infos[i])
+		}
+	}
+
+	if !GetHooksEnabled() {
+		t.Fatal("Expected hooks to be enabled")
+	}
+}
+
+func TestImportFireHooks(t *testing.T) {
+	resetDB(t)
+
+	pre := []string{}
+	post := []string{}
+
+	check(SetPreSetHook("imported/a", func(path, value string) error {
+		if value == "bad" {
+			return errors.New("bad value")
+		}
+
+		pre = append(pre, path+"="+value)
+		return nil
+	}), t)
+
+	check(SetPostSetHook("imported/a", func(path, value string) error {
+		post = append(post, path+"="+value)
+		return nil
+	}, false), t)
+
+	check(SetPostSetHook("imported/b", func(path, value string) error {
+		post = append(post, path+"="+value)
+		return nil
+	}, false), t)
+
+	check(Set("imported/b", "2"), t)
+	post = []string{}
+
+	t.Log("Should not call hooks by default")
+
+	check(ImportJSON(strings.NewReader(`{"imported": {"a": "1"}}`), ImportOptions{}), t)
+	if len(pre) != 0 || len(post) != 0 {
+		t.Fatalf("Expected no hook calls, got %v %v", pre, post)
+	}
+
+	t.Log("Should call the hooks of the changed values only")
+
+	check(ImportJSON(strings.NewReader(`{"imported": {"a": "3", "b": "2"}}`), ImportOptions{FireHooks: true}), t)
+	if len(pre) != 1 || pre[0] != "imported/a=3" {
+		t.Fatalf("Unexpected pre set hook calls %v", pre)
+	}
+
+	if len(post) != 1 || post[0] != "imported/a=3" {
+		t.Fatalf("Unexpected post set hook calls %v", post)
+	}
+
+	t.Log("Should abort the import if a pre set hook fails")
+
+	err := ImportJSON(strings.NewReader(`{"imported": {"a": "bad", "b": "4"}}`), ImportOptions{FireHooks: true})
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+
+	value, err := Get[string]("imported/b")
+	check(err, t)
+	if value != "2" {
+		t.Fatalf("Expected the import to be rolled back, got %s", value)
+	}
+
+	if len(post) != 1 {
+		t.Fatalf("Unexpected post set hook calls %v", post)
+	}
+
+	t.Log("Should not call hooks on dry runs")
+
+	_, err = DryRunImportJSON(strings.NewReader(`{"imported": {"a": "5"}}`), ImportOptions{FireHooks: true})
+	check(err, t)
+	if len(pre) != 1 || len(post) != 1 {
+		t.Fatalf("Unexpected hook calls %v %v", pre, post)
+	}
+}
+
+func TestImportFilter(t *testing.T) {
+	resetDB(t)
+
+	filter := func(path string, value string) (string, bool, error) {
+		if strings.HasSuffix(path, "/secret") {
+			return "", false, nil
+		}
+
+		if value == "bad" {
+			return "", false, errors.New("bad value")
+		}
+
+		return strings.TrimSpace(value), true, nil
+	}
+
+	check(Set("filtered/secret", "kept"), t)
+
+	t.Log("Should transform and skip values of the default format")
+
+	check(ImportJSON(strings.NewReader(`{"filtered": {"a": " 1 ", "secret": "leaked"}}`),
+		ImportOptions{Filter: filter}), t)
+
+	values, err := GetFlat("filtered")
+	check(err, t)
+	if !reflect.DeepEqual(values, map[string]string{"a": "1", "secret": "kept"}) {
+		t.Fatalf("Unexpected values %v", values)
+	}
+
+	t.Log("Should transform and skip values of the extended format")
+
+	check(Set("source/b", " 2 "), t)
+	check(Set("source/secret", "leaked"), t)
+	buffer := bytes.Buffer{}
+	check(ExportJSON("source", &buffer, ExportOptions{Extended: true}), t)
+
+	check(ImportJSON(&buffer, ImportOptions{Extended: true, Path: "filtered", Filter: filter}), t)
+
+	values, err = GetFlat("filtered")
+	check(err, t)
+	if !reflect.DeepEqual(values, map[string]string{"a": "1", "b": "2", "secret": "kept"}) {
+		t.Fatalf("Unexpected values %v", values)
+	}
+
+	t.Log("Should reject the whole import if the filter fails")
+
+	err = ImportJSON(strings.NewReader(`{"filtered": {"a": "3", "c": "bad"}}`), ImportOptions{Filter: filter})
+	var pErr *PathError
+	if !errors.As(err, &pErr) || pErr.Path != "filtered/c" {
+		t.Fatalf("Expected PathError at filtered/c, got %v", err)
+	}
+
+	value, err := Get[string]("filtered/a")
+	check(err, t)
+	if value != "1" {
+		t.Fatalf("Expected the import to be rolled back, got %s", value)
+	}
+}
+
+func TestPathError(t *testing.T) {
+	resetDB(t)
+
+	t.Log("Should return a PathError matching the underlying error")
+
+	_, err := Get[string]("missing/value")
+	var pErr *PathError
+	if !errors.As(err, &pErr) || !errors.Is(err, ErrPathNotFound) {
+		t.Fatalf("Expected a PathError matching ErrPathNotFound, got %v", err)
+	}
+
+	if pErr.Op != "get" || pErr.Path != "missing/value" {
+		t.Fatalf("Unexpected PathError %v", pErr)
+	}
+
+	check(Set("errors/a", "1"), t)
+
+	err = Set("errors/a/b", "2")
+	if !errors.As(err, &pErr) || !errors.Is(err, ErrPathInvalid) || pErr.Op != "set" || pErr.Path != "errors/a/b" {
+		t.Fatalf("Expected a PathError on errors/a/b, got %v", err)
+	}
+
+	t.Log("Should report the path of the Entry failing an import")
+
+	err = ImportJSON(strings.NewReader(`{"errors": {"b": "2", "c": {"d": [{"e": "3"}]}}}`), ImportOptions{})
+	if !errors.As(err, &pErr) || pErr.Op != "import" || pErr.Path != "errors/c/d" {
+		t.Fatalf("Expected a PathError on errors/c/d, got %v", err)
+	}
+
+	check(SetPreSetHook("errors/f", func(path, value string) error {
+		return ErrValueEmpty
+	}), t)
+
+	err = ImportJSON(strings.NewReader(`{"errors": {"f": "4"}}`), ImportOptions{FireHooks: true})
+	if !errors.As(err, &pErr) || !errors.Is(err, ErrValueEmpty) || pErr.Path != "errors/f" {
+		t.Fatalf("Expected a PathError on errors/f, got %v", err)
+	}
+
+	t.Log("Should not wrap errors twice")
+
+	err = Delete("")
+	if !errors.As(err, &pErr) || errors.As(pErr.Err, new(*PathError)) || pErr.Op != "delete" {
+		t.Fatalf("Expected a single PathError, got %v", err)
+	}
+}
+
+func TestGetIfChanged(t *testing.T) {
+	resetDB(t)
+
+	check(Set("conditional/a", 1), t)
+
+	t.Log("Should always read the value with revision 0")
+
+	value, revision, err := GetIfChanged[int]("conditional/a", 0)
+	check(err, t)
+	if value != 1 || revision == 0 {
+		t.Fatalf("Unexpected value %d at revision %d", value, revision)
+	}
+
+	t.Log("Should fail with ErrNotModified on an unchanged value")
+
+	check(Set("conditional/b", 2), t)
+
+	_, same, err := GetIfChanged[int]("conditional/a", revision)
+	if !errors.Is(err, ErrNotModified) || same != revision {
+		t.Fatalf("Expected ErrNotModified at revision %d, got %v at %d", revision, err, same)
+	}
+
+	t.Log("Should read the value once changed")
+
+	check(Set("conditional/a", 3), t)
+
+	value, changed, err := GetIfChanged[int]("conditional/a", revision)
+	check(err, t)
+	if value != 3 || changed <= revision {
+		t.Fatalf("Unexpected value %d at revision %d", value, changed)
+	}
+
+	_, _, err = GetIfChanged[int]("conditional", revision)
+	if !errors.Is(err, ErrPathIsNotAValue) {
+		t.Fatalf("Expected ErrPathIsNotAValue, got %v", err)
+	}
+
+	_, _, err = GetIfChanged[int]("conditional/missing", revision)
+	if !errors.Is(err, ErrPathNotFound) {
+		t.Fatalf("Expected ErrPathNotFound, got %v", err)
+	}
+}
+
+func TestGetStructure(t *testing.T) {
+	resetDB(t)
+
+	check(Set("structure/a/b", 1), t)
+	check(Set("structure/c", "value"), t)
+	check(SetBytes("structure/d", []byte{1, 2, 3}), t)
+
+	t.Log("Should return the hierarchy without the values")
+
+	entry, err := GetStructure("structure", -1)
+	check(err, t)
+
+	if len(entry.Children) != 3 || len(entry.Children["a"].Children) != 1 {
+		t.Fatalf("Unexpected structure %v", entry)
+	}
+
+	b := entry.Children["a"].Children["b"]
+	if !b.IsValue || b.Value != "" || b.Type != TypeInt || b.Revision == 0 {
+		t.Fatalf("Unexpected Entry %v", b)
+	}
+
+	for _, name := range []string{"c", "d"} {
+		if !entry.Children[name].IsValue || entry.Children[name].Value != "" {
+			t.Fatalf("Unexpected Entry %v", entry.Children[name])
+		}
+	}
+
+	t.Log("Should stop at the specified depth")
+
+	entry, err = GetStructure("structure", 1)
+	check(err, t)
+	if entry.Children["a"].ChildrenLoaded() {
+		t.Fatal("Expected the children of structure/a not to be loaded")
+	}
+
+	t.Log("Should leave the values read afterwards untouched")
+
+	value, err := Get[int]("structure/a/b")
+	check(err, t)
+	if value != 1 {
+		t.Fatalf("Expected 1, got %d", value)
+	}
+
+	_, err = GetStructure("structure/missing", -1)
+	if !errors.Is(err, ErrPathNotFound) {
+		t.Fatalf("Expected ErrPathNotFound, got %v", err)
+	}
+}
+
+func TestCommitTree(t *testing.T) {
+	resetDB(t)
+
+	check(Set("tree/old/a", "1"), t)
+
+	tree := NewTree()
+	tree.Delete("tree/old")
+	check(tree.Set("tree/new/a", 2), t)
+	check(tree.Set("tree/new/b", true), t)
+	check(tree.Force("tree/new", "forced"), t)
+	check(tree.Set("tree/other/a", "3"), t)
+
+	if tree.Set("tree/nil", nil) == nil {
+		t.Fatal("Expected error")
+	}
+
+	if tree.Len() != 5 {
+		t.Fatalf("Expected 5 changes, got %d", tree.Len())
+	}
+
+	t.Log("Should list the changes of a dry run without applying them")
+
+	changes, err := DryRunCommitTree(tree)
+	check(err, t)
+	if len(changes) == 0 {
+		t.Fatal("Expected changes")
+	}
+
+	ok, err := Exists("tree/old/a")
+	check(err, t)
+	if !ok {
+		t.Fatal("Expected tree/old/a to exist")
+	}
+
+	t.Log("Should apply the changes in order")
+
+	check(CommitTree(tree), t)
+
+	ok, err = Exists("tree/old")
+	check(err, t)
+	if ok {
+		t.Fatal("Expected tree/old to be deleted")
+	}
+
+	value, err := Get[string]("tree/new")
+	check(err, t)
+	if value != "forced" {
+		t.Fatalf("Expected forced, got %s", value)
+	}
+
+	t.Log("Should apply none of the changes on failure")
+
+	failing := NewTree()
+	check(failing.Set("tree/other/b", "4"), t)
+	check(failing.Set("tree/new/c", "5"), t)
+
+	err = CommitTree(failing)
+	if !errors.Is(err, ErrPathInvalid) {
+		t.Fatalf("Expected ErrPathInvalid, got %v", err)
+	}
+
+	ok, err = Exists("tree/other/b")
+	check(err, t)
+	if ok {
+		t.Fatal("Expected tree/other/b not to be set")
+	}
+}
+
+func TestEntryUtilities(t *testing.T) {
+	resetDB(t)
+
+	check(Set("utils/eth0/mtu", 1500), t)
+	check(Set("utils/eth0/ipv4/address", "10.0.0.1"), t)
+	check(Set("utils/eth1/mtu", 9000), t)
+	check(Set("utils/name", "device"), t)
+
+	entry, err := GetEntry("utils")
+	check(err, t)
+
+	t.Log("Should get descendants by relative path")
+
+	child, err := entry.Get("eth0/ipv4/address")
+	check(err, t)
+	if child.Value != "10.0.0.1" {
+		t.Fatalf("Unexpected Entry %v", child)
+	}
+
+	self, err := entry.Get("")
+	check(err, t)
+	if self != entry {
+		t.Fatal("Expected the Entry itself")
+	}
+
+	_, err = entry.Get("eth2")
+	if !errors.Is(err, ErrPathNotFound) {
+		t.Fatalf("Expected ErrPathNotFound, got %v", err)
+	}
+
+	t.Log("Should walk parents before children, in order")
+
+	visited := []string{}
+	check(entry.Walk(func(relativePath string, entry *Entry) error {
+		visited = append(visited, relativePath)
+		if relativePath == "eth0" {
+			return ErrSkipChildren
+		}
+
+		return nil
+	}), t)
+
+	expected := []string{"", "eth0", "eth1", "eth1/mtu", "name"}
+	if strings.Join(visited, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Expected %v, got %v", expected, visited)
+	}
+
+	t.Log("Should flatten the values")
+
+	values := entry.Flatten()
+	if len(values) != 4 || values["eth0/ipv4/address"] != "10.0.0.1" || values["name"] != "device" {
+		t.Fatalf("Unexpected values %v", values)
+	}
+
+	t.Log("Should find the descendants matching a glob")
+
+	found, err := entry.Find("*/mtu")
+	check(err, t)
+	if len(found) != 2 || found[0].Path != "utils/eth0/mtu" || found[1].Path != "utils/eth1/mtu" {
+		t.Fatalf("Unexpected Entries %v", found)
+	}
+
+	_, err = entry.Find("[")
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+}
+
+func TestSetMap(t *testing.T) {
+	resetDB(t)
+
+	t.Log("Should set nested maps, keeping the types of their values")
+
+	check(SetMap("map", map[string]any{
+		"name":  "device",
+		"mtu":   1500,
+		"ratio": 0.5,
+		"up":    true,
+		"none":  nil,
+		"raw":   []byte{1, 2, 3},
+		"ports": []any{80, 443},
+		"eth0": map[string]any{
+			"address": "10.0.0.1",
+			"tags":    []string{"a", "b"},
+		},
+		"empty": map[string]any{},
+	}), t)
+
+	mtu, err := Get[int]("map/mtu")
+	check(err, t)
+	if mtu != 1500 {
+		t.Fatalf("Expected 1500, got %d", mtu)
+	}
+
+	up, err := Get[bool]("map/up")
+	check(err, t)
+	if !up {
+		t.Fatal("Expected true")
+	}
+
+	null, err := IsNull("map/none")
+	check(err, t)
+	if !null {
+		t.Fatal("Expected map/none to be null")
+	}
+
+	raw, err := GetBytes("map/raw")
+	check(err, t)
+	if !bytes.Equal(raw, []byte{1, 2, 3}) {
+		t.Fatalf("Unexpected bytes %v", raw)
+	}
+
+	ports, err := GetList[int]("map/ports")
+	check(err, t)
+	if !reflect.DeepEqual(ports, []int{80, 443}) {
+		t.Fatalf("Unexpected list %v", ports)
+	}
+
+	tags, err := GetList[string]("map/eth0/tags")
+	check(err, t)
+	if !reflect.DeepEqual(tags, []string{"a", "b"}) {
+		t.Fatalf("Unexpected list %v", tags)
+	}
+
+	address, err := Get[string]("map/eth0/address")
+	check(err, t)
+	if address != "10.0.0.1" {
+		t.Fatalf("Expected 10.0.0.1, got %s", address)
+	}
+
+	ok, err := Exists("map/empty")
+	check(err, t)
+	if ok {
+		t.Fatal("Expected map/empty not to be created")
+	}
+
+	t.Log("Should set none of the values on failure")
+
+	err = SetMap("map", map[string]any{"eth1": map[string]any{"mtu": 9000}, "unsupported": struct{ c chan int }{}})
+	var pathErr *PathError
+	if !errors.As(err, &pathErr) || pathErr.Path != "map/unsupported" {
+		t.Fatalf("Expected PathError on map/unsupported, got %v", err)
+	}
+
+	ok, err = Exists("map/eth1")
+	check(err, t)
+	if ok {
+		t.Fatal("Expected map/eth1 not to be set")
+	}
+}
+
+func TestGetFlat(t *testing.T) {
+	resetDB(t)
+
+	check(Set("flat/eth0/mtu", 1500), t)
+	check(Set("flat/eth0/ipv4/address", "10.0.0.1"), t)
+	check(Set("flat/name", "device"), t)
+
+	t.Log("Should return the values under the path, keyed by their relative path")
+
+	values, err := GetFlat("/flat/")
+	check(err, t)
+
+	expected := map[string]string{"eth0/mtu": "1500", "eth0/ipv4/address": "10.0.0.1", "name": "device"}
+	if !reflect.DeepEqual(values, expected) {
+		t.Fatalf("Unexpected values %v", values)
+	}
+
+	t.Log("Should return a value under the empty path")
+
+	values, err = GetFlat("flat/name")
+	check(err, t)
+	if !reflect.DeepEqual(values, map[string]string{"": "device"}) {
+		t.Fatalf("Unexpected values %v", values)
+	}
+
+	_, err = GetFlat("flat/missing")
+	if !errors.Is(err, ErrPathNotFound) {
+		t.Fatalf("Expected ErrPathNotFound, got %v", err)
+	}
+}
+
+func TestApply(t *testing.T) {
+	resetDB(t)
+
+	check(Set("apply/legacy", "1"), t)
+
+	var max string
+	check(SetPreSetHook("apply/max", func(path, value string) error {
+		max = value
+		return nil
+	}), t)
+
+	check(SetPostSetHook("apply/min", func(path, value string) error {
+		if value > max {
+			return errors.New("min exceeds max")
+		}
+
+		return nil
+	}, false), t)
+
+	t.Log("Should apply all the operations")
+
+	check(Apply([]Op{
+		{Type: OpSet, Path: "apply/max", Value: 5},
+		{Type: OpSet, Path: "apply/min", Value: 2},
+		{Type: OpDelete, Path: "apply/legacy"},
+		{Type: OpForce, Path: "apply/other", Value: true},
+	}), t)
+
+	ok, err := Exists("apply/legacy")
+	check(err, t)
+	if ok {
+		t.Fatal("Expected apply/legacy to be deleted")
+	}
+
+	t.Log("Should roll back all the operations when a hook fails")
+
+	err = Apply([]Op{
+		{Type: OpSet, Path: "apply/max", Value: 3},
+		{Type: OpSet, Path: "apply/min", Value: 4},
+	})
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+
+	value, err := Get[string]("apply/max")
+	check(err, t)
+	if value != "5" {
+		t.Fatalf("Expected 5, got %s", value)
+	}
+
+	t.Log("Should roll back all the operations when a validator fails")
+
+	_, err = RegisterValidator("apply/other", func(path, value string) error {
+		return errors.New("invalid")
+	})
+	check(err, t)
+
+	err = Apply([]Op{
+		{Type: OpSet, Path: "apply/max", Value: 7},
+		{Type: OpSet, Path: "apply/other", Value: false},
+	})
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) || validationErr.Path != "apply/other" {
+		t.Fatalf("Expected ValidationError on apply/other, got %v", err)
+	}
+
+	value, err = Get[string]("apply/max")
+	check(err, t)
+	if value != "5" {
+		t.Fatalf("Expected 5, got %s", value)
+	}
+
+	err = Apply([]Op{{Type: OpType(42), Path: "apply/max"}})
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+}
+
+func TestSavepoints(t *testing.T) {
+	resetDB(t)
+
+	check(Set("sp/base", "0"), t)
+	_, revision, err := GetEvents("sp", 0)
+	check(err, t)
+
+	t.Log("Should undo only the operations performed after a savepoint")
+
+	err = Update(func(tx *Tx) error {
+		check(tx.Set("sp/a", "1"), t)
+
+		for _, v := range []string{"2", "bad", "3"} {
+			check(tx.Savepoint("record"), t)
+			check(tx.Set("sp/"+v, v), t)
+
+			if v == "bad" {
+				check(tx.Savepoint("inner"), t)
+				check(tx.Set("sp/inner", "x"), t)
+				check(tx.RollbackTo("record"), t)
+
+				if !errors.Is(tx.Release("inner"), ErrSavepointNotFound) {
+					t.Fatal("Expected inner savepoint to be released")
+				}
+			}
+
+			check(tx.Release("record"), t)
+		}
+
+		if !errors.Is(tx.RollbackTo("record"), ErrSavepointNotFound) {
+			t.Fatal("Expected ErrSavepointNotFound")
+		}
+
+		return nil
+	})
+	check(err, t)
+
+	for p, expected := range map[string]bool{"sp/a": true, "sp/2": true, "sp/3": true, "sp/bad": false, "sp/inner": false} {
+		ok, err := Exists(p)
+		check(err, t)
+		if ok != expected {
+			t.Fatalf("Expected existence of %s to be %v", p, expected)
+		}
+	}
+
+	t.Log("Should not log the undone changes")
+
+	events, _, err := GetEvents("sp", revision)
+	check(err, t)
+	for _, e := range events {
+		if e.Path == "sp/bad" || e.Path == "sp/inner" {
+			t.Fatalf("Unexpected event %v", e)
+		}
+	}
+
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 events, got %v", events)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	resetDB(t)
+
+	check(Set("snap/a", "1"), t)
+	check(Set("snap/b", "2"), t)
+
+	snapshot, err := BeginSnapshot()
+	check(err, t)
+
+	revision, err := GetRevision()
+	check(err, t)
+	if snapshot.Revision() != revision {
+		t.Fatalf("Expected revision %d, got %d", revision, snapshot.Revision())
+	}
+
+	check(Set("snap/a", "10"), t)
+	check(Delete("snap/b"), t)
+	check(Set("snap/c", "3"), t)
+
+	t.Log("Should not observe the writes committed after the snapshot")
+
+	value, err := snapshot.Get("snap/a")
+	check(err, t)
+	if value != "1" {
+		t.Fatalf("Expected 1, got %s", value)
+	}
+
+	ok, err := snapshot.Exists("snap/c")
+	check(err, t)
+	if ok {
+		t.Fatal("Expected snap/c not to exist")
+	}
+
+	entry, err := snapshot.GetEntry("snap")
+	check(err, t)
+	if len(entry.Children) != 2 || entry.Children["b"] == nil {
+		t.Fatalf("Unexpected Entry %v", entry)
+	}
+
+	visited := 0
+	check(snapshot.Recurse("snap", -1, func(entry *Entry, parent *Entry, depth uint) error {
+		visited++
+		return nil
+	}), t)
+	if visited != 3 {
+		t.Fatalf("Expected 3 Entries, got %d", visited)
+	}
+
+	buffer := bytes.Buffer{}
+	check(snapshot.ExportJSON("snap", &buffer, ExportOptions{}), t)
+	exported := map[string]string{}
+	check(json.Unmarshal(buffer.Bytes(), &exported), t)
+	if !reflect.DeepEqual(exported, map[string]string{"a": "1", "b": "2"}) {
+		t.Fatalf("Unexpected export %s", buffer.String())
+	}
+
+	check(snapshot.End(), t)
+	check(snapshot.End(), t)
+
+	_, err = snapshot.Get("snap/a")
+	if !errors.Is(err, ErrSnapshotEnded) {
+		t.Fatalf("Expected ErrSnapshotEnded, got %v", err)
+	}
+
+	value, err = Get[string]("snap/a")
+	check(err, t)
+	if value != "10" {
+		t.Fatalf("Expected 10, got %s", value)
+	}
+}
+
+func TestGetDBFileSchemaVersion(t *testing.T) {
+	resetDB(t)
+
+	check(Set("version/a", "1"), t)
+
+	t.Log("Should read the schema version of a file without opening it")
+
+	version, err := GetDBFileSchemaVersion(testDBPath, "")
+	check(err, t)
+	if version != GetSupportedDBSchemaVersion() {
+		t.Fatalf("Expected version %d, got %d", GetSupportedDBSchemaVersion(), version)
+	}
+
+	missing := filepath.Join(t.TempDir(), "missing.db")
+	_, err = GetDBFileSchemaVersion(missing, "")
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+
+	_, err = os.Stat(missing)
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected %s not to be created, got %v", missing, err)
+	}
+
+	if Version() == "" {
+		t.Fatal("Expected a version")
+	}
+}
+
+func TestOpenExisting(t *testing.T) {
+	resetDB(t)
+
+	check(Set("existing/a", "1"), t)
+	check(Close(), t)
+
+	t.Log("Should not create missing DBs")
+
+	missing := filepath.Join(t.TempDir(), "missing.db")
+	err := OpenExisting(missing)
+	if !errors.Is(err, ErrDBNotFound) {
+		t.Fatalf("Expected ErrDBNotFound, got %v", err)
+	}
+
+	if IsOpen() {
+		t.Fatal("Expected no DB to be open")
+	}
+
+	_, err = os.Stat(missing)
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected %s not to be created, got %v", missing, err)
+	}
+
+	t.Log("Should open existing DBs")
+
+	check(OpenExisting(testDBPath), t)
+
+	value, err := Get[string]("existing/a")
+	check(err, t)
+	if value != "1" {
+		t.Fatalf("Expected 1, got %s", value)
+	}
+}
+
+func TestSalvage(t *testing.T) {
+	resetDB(t)
+	check(Close(), t)
+
+	defer func() {
+		Close()
+		Open(testDBPath)
+	}()
+
+	_, err := OpenWithOptions(testDBPath, Options{Checksums: true})
+	check(err, t)
+
+	check(Set("salvage/a/b", "1"), t)
+	check(Set("salvage/a/c", "2"), t)
+	check(Set("salvage/d", "3"), t)
+	check(Close(), t)
+
+	dst := filepath.Join(t.TempDir(), "salvaged.db")
+
+	t.Log("Should copy every row of a healthy DB")
+
+	report, err := Salvage(testDBPath, dst, "")
+	check(err, t)
+	if !report.Complete() || report.Salvaged[table] == 0 {
+		t.Fatalf("Unexpected report %+v", report)
+	}
+
+	t.Log("Should not overwrite existing files")
+
+	_, err = Salvage(testDBPath, dst, "")
+	if !errors.Is(err, fs.ErrExist) {
+		t.Fatalf("Expected fs.ErrExist, got %v", err)
+	}
+
+	t.Log("Should drop corrupted values and recreate lost parents")
+
+	d, err := sql.Open("sqlite3", testDBPath)
+	check(err, t)
+	_, err = d.Exec(fmt.Sprintf("UPDATE %s SET %s = 'tampered' WHERE %s = 'salvage/d'", table, colValue, colPath))
+	check(err, t)
+	_, err = d.Exec(fmt.Sprintf("DELETE FROM %s WHERE %s = 'salvage/a'", table, colPath))
+	check(err, t)
+	check(d.Close(), t)
+
+	dst = filepath.Join(t.TempDir(), "salvaged.db")
+	report, err = Salvage(testDBPath, dst, "")
+	check(err, t)
+	if !reflect.DeepEqual(report.Corrupted, []string{"salvage/d"}) ||
+		!reflect.DeepEqual(report.Recreated, []string{"salvage/a"}) || report.Complete() {
+		t.Fatalf("Unexpected report %+v", report)
+	}
+
+	check(OpenExisting(dst), t)
+
+	values, err := GetFlat("salvage")
+	check(err, t)
+	if !reflect.DeepEqual(values, map[string]string{"a/b": "1", "a/c": "2"}) {
+		t.Fatalf("Unexpected values %v", values)
+	}
+
+	check(Delete("salvage/a"), t)
+	exists, err := Exists("salvage/a/b")
+	check(err, t)
+	if exists {
+		t.Fatal("Expected children of the recreated entry to be deleted with it")
+	}
 }
@@ -0,0 +1,226 @@
+package camellia
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+)
+
+type ChangeType uint
+
+const (
+	ChangeCreated     ChangeType = 1
+	ChangeUpdated     ChangeType = 2
+	ChangeOverwritten ChangeType = 3
+	ChangeDeleted     ChangeType = 4
+)
+
+/*
+Change describes a modification to a single Entry.
+
+ChangeCreated: a new Entry was created at Path.
+
+ChangeUpdated: the value at Path was changed from OldValue to Value. Values rewritten with a different type tag (see
+ValueType) but the same value are not reported as changed.
+
+ChangeOverwritten: the Entry at Path was replaced with an Entry of a different kind (a value replacing a non-value
+Entry and its children, or vice versa).
+
+ChangeDeleted: the Entry at Path, and its children, were deleted. If the Entry was a value, OldValue carries it.
+*/
+type Change struct {
+	Type     ChangeType
+	Path     string
+	IsValue  bool
+	OldValue string
+	Value    string
+}
+
+/*
+Changes are recorded while a write transaction is in progress (always under the global mutex), logged under a new
+revision, and dispatched to watchers once the transaction is committed
+*/
+var recordChanges = false
+var recordedChanges []Change
+
+/*
+txStmts maps the transactions in progress to the statements bound to them (see txStmt). Each transaction is used by a
+single goroutine, but read transactions run concurrently
+*/
+var txStmts sync.Map
+
+func (t ChangeType) String() string {
+	switch t {
+	case ChangeCreated:
+		return "created"
+	case ChangeUpdated:
+		return "updated"
+	case ChangeOverwritten:
+		return "overwritten"
+	case ChangeDeleted:
+		return "deleted"
+	default:
+		return "unknown"
+	}
+}
+
+/*
+beginTx begins a transaction, starting to record changes
+*/
+func beginTx() (*sql.Tx, error) {
+	return beginTxCtx(context.Background())
+}
+
+/*
+beginTxCtx calls beginTx, binding the transaction to ctx: the transaction is rolled back if ctx is done before it is
+committed
+*/
+func beginTxCtx(ctx context.Context) (*sql.Tx, error) {
+	// Buffered values are written first, so that they don't overwrite the changes of the transaction when flushed later
+	err := flushBuffered(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error flushing buffered values - %w", err)
+	}
+
+	tx, err := beginWithRetry(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	txCtx = ctx
+	trackOperation(ctx, tx)
+	recordChanges = true
+	recordedChanges = nil
+
+	return tx, nil
+}
+
+/*
+beginReadTx begins a read-only transaction, which runs concurrently with the other read transactions, while the global
+mutex is held for reading. It must be ended with endReadTx, or rolled back
+*/
+func beginReadTx(ctx context.Context) (*sql.Tx, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, wrapBusy(err)
+	}
+
+	trackOperation(ctx, tx)
+
+	return tx, nil
+}
+
+/*
+endReadTx ends a transaction begun with beginReadTx, with the same ctx
+*/
+func endReadTx(ctx context.Context, tx *sql.Tx) error {
+	_, span := startSpan(ctx, "camellia.sql.commit", "")
+
+	releaseTxStmts(tx)
+
+	err := wrapBusy(tx.Commit())
+	span.End(err)
+
+	return err
+}
+
+/*
+commitTx logs the changes recorded since beginTx in the change log, commits the transaction, and dispatches the
+changes to the watchers. If logging fails, the transaction is rolled back
+*/
+func commitTx(tx *sql.Tx) error {
+	changes := recordedChanges
+	recordChanges = false
+	recordedChanges = nil
+
+	ctx := txCtx
+	txCtx = nil
+	if o, ok := txOperations.Load(tx); ok {
+		o.(*operation).changes += len(changes)
+	}
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	_, span := startSpan(ctx, "camellia.sql.commit", "")
+
+	if len(changes) > 0 {
+		revision, err := logChanges(changes, tx)
+		if err != nil {
+			span.End(err)
+			rollbackTx(tx)
+			return err
+		}
+
+		if dbOptions.PollExternalChanges > 0 {
+			ownRevisions[revision] = struct{}{}
+		}
+	}
+
+	releaseTxStmts(tx)
+
+	err := wrapBusy(tx.Commit())
+	span.End(err)
+	if err != nil {
+		return err
+	}
+
+	if len(changes) > 0 {
+		notifyWatchers(changes)
+	}
+
+	return nil
+}
+
+/*
+rollbackTx rolls back a transaction begun with beginTx or beginReadTx
+*/
+func rollbackTx(tx *sql.Tx) error {
+	releaseTxStmts(tx)
+
+	return tx.Rollback()
+}
+
+/*
+txStmt returns the prepared statement called name, bound to tx. Statements are bound once per transaction, and reused
+until the transaction ends
+*/
+func txStmt(tx *sql.Tx, name string) *sql.Stmt {
+	var bound map[string]*sql.Stmt
+	if v, ok := txStmts.Load(tx); ok {
+		bound = v.(map[string]*sql.Stmt)
+	} else {
+		bound = map[string]*sql.Stmt{}
+		txStmts.Store(tx, bound)
+	}
+
+	stmt, ok := bound[name]
+	if !ok {
+		stmt = tx.Stmt(stmts[name])
+		bound[name] = stmt
+	}
+
+	return stmt
+}
+
+/*
+releaseTxStmts forgets the statements bound to tx, which are closed along with it
+*/
+func releaseTxStmts(tx *sql.Tx) {
+	txStmts.Delete(tx)
+}
+
+func recordChange(t ChangeType, path string, isValue bool, oldValue string, value string) {
+	if !recordChanges {
+		return
+	}
+
+	recordedChanges = append(recordedChanges, Change{
+		Type:     t,
+		Path:     path,
+		IsValue:  isValue,
+		OldValue: oldValue,
+		Value:    value})
+}
@@ -0,0 +1,113 @@
+package camellia
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/crc32"
+	"sync/atomic"
+)
+
+/*
+Verify checks the checksums of all the values stored in the DB, returning the paths of the corrupted ones.
+
+Values written without checksums (see Options) are not checked.
+*/
+func Verify() ([]string, error) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return nil, ErrNoDB
+	}
+
+	tx, err := beginReadTx(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("error beginning transaction - %w", err)
+	}
+
+	corrupted, err := verifyAll(tx)
+	if err != nil {
+		rollbackTx(tx)
+		return nil, err
+	}
+
+	err = endReadTx(context.Background(), tx)
+	if err != nil {
+		rollbackTx(tx)
+		return nil, fmt.Errorf("error committing transaction - %w", err)
+	}
+
+	return corrupted, nil
+}
+
+func verifyAll(tx *sql.Tx) ([]string, error) {
+	corrupted := []string{}
+	seen := map[string]bool{}
+
+	queries := []string{
+		fmt.Sprintf("SELECT %s, %s, %s, %s FROM %s WHERE %s IS NOT NULL ORDER BY %s",
+			colPath, colValue, colBlobValue, colChecksum, entriesSource(), colChecksum, colPath),
+		fmt.Sprintf("SELECT %s, '', %s, %s FROM %s WHERE %s IS NOT NULL ORDER BY %s, %s",
+			colPath, colData, colChecksum, chunksSource(), colChecksum, colPath, colSeq),
+	}
+
+	for _, query := range queries {
+		rows, err := tx.Query(query)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			var path, value string
+			var blob []byte
+			var checksum sql.NullInt64
+
+			err = rows.Scan(&path, &value, &blob, &checksum)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+
+			if verifyChecksum(path, value, blob, checksum) != nil && !seen[path] {
+				corrupted = append(corrupted, path)
+				seen[path] = true
+			}
+		}
+
+		err = rows.Err()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return corrupted, nil
+}
+
+/*
+valueChecksum computes the checksum of a value in its stored form, or returns a NULL checksum if checksums are disabled
+*/
+func valueChecksum(value string, blob []byte) sql.NullInt64 {
+	if !dbOptions.Checksums {
+		return sql.NullInt64{}
+	}
+
+	return sql.NullInt64{Int64: int64(computeChecksum(value, blob)), Valid: true}
+}
+
+func computeChecksum(value string, blob []byte) uint32 {
+	checksum := crc32.ChecksumIEEE([]byte(value))
+	return crc32.Update(checksum, crc32.IEEETable, blob)
+}
+
+func verifyChecksum(path string, value string, blob []byte, checksum sql.NullInt64) error {
+	if !checksum.Valid {
+		return nil
+	}
+
+	if uint32(checksum.Int64) != computeChecksum(value, blob) {
+		return fmt.Errorf("error verifying value %s - %w", path, ErrValueCorrupted)
+	}
+
+	return nil
+}
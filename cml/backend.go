@@ -0,0 +1,245 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	cml "github.com/debevv/camellia"
+	"github.com/debevv/camellia/server"
+)
+
+/*
+backend abstracts the operations available both on a local DB file and on a remote camellia server
+*/
+type backend interface {
+	getDeprecation(path string) (*cml.Deprecation, error)
+	get(path string) (string, error)
+	exportJSON(path string, w io.Writer, options cml.ExportOptions) error
+	set(path string, value string, force bool) error
+	setAll(assignments []assignment, force bool) error
+	delete(path string) error
+	importJSON(reader io.Reader, options cml.ImportOptions, dryRun bool) ([]cml.Change, error)
+	getRevision() (uint64, error)
+	info() (*server.Info, error)
+	getEvents(path string, sinceRevision uint64, timeout time.Duration) ([]cml.Event, uint64, error)
+}
+
+/*
+assignment is a value to set to a path, passed to the set command as <path>=<value>
+*/
+type assignment struct {
+	path  string
+	value string
+}
+
+/*
+eventsPollInterval is how often the change log of a local DB is read while waiting for changes, made by other processes
+too
+*/
+const eventsPollInterval = 250 * time.Millisecond
+
+type localBackend struct{}
+
+func (localBackend) getDeprecation(path string) (*cml.Deprecation, error) {
+	return cml.GetDeprecation(path)
+}
+
+func (localBackend) get(path string) (string, error) {
+	return cml.Get[string](path)
+}
+
+func (localBackend) exportJSON(path string, w io.Writer, options cml.ExportOptions) error {
+	return cml.ExportJSON(path, w, options)
+}
+
+func (localBackend) set(path string, value string, force bool) error {
+	if force {
+		return cml.Force(path, value)
+	}
+
+	return cml.Set(path, value)
+}
+
+/*
+setAll sets the values of assignments in a single transaction. Each value is set inside a savepoint, so that the
+following ones are checked against a clean state, and all the failures are reported, before rolling back
+*/
+func (localBackend) setAll(assignments []assignment, force bool) error {
+	return cml.Update(func(tx *cml.Tx) error {
+		failures := []string{}
+		for _, a := range assignments {
+			err := tx.Savepoint("assignment")
+			if err != nil {
+				return err
+			}
+
+			if force {
+				err = tx.Force(a.path, a.value)
+			} else {
+				err = tx.Set(a.path, a.value)
+			}
+
+			if err != nil {
+				// Most errors already carry the path
+				var pathErr *cml.PathError
+				if errors.As(err, &pathErr) {
+					failures = append(failures, err.Error())
+				} else {
+					failures = append(failures, fmt.Sprintf("%s: %v", a.path, err))
+				}
+
+				err = tx.RollbackTo("assignment")
+				if err != nil {
+					return err
+				}
+			}
+
+			err = tx.Release("assignment")
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(failures) > 0 {
+			return fmt.Errorf("%d of %d values failed, none was set:\n%s", len(failures), len(assignments),
+				strings.Join(failures, "\n"))
+		}
+
+		return nil
+	})
+}
+
+func (localBackend) delete(path string) error {
+	return cml.Delete(path)
+}
+
+func (localBackend) importJSON(reader io.Reader, options cml.ImportOptions, dryRun bool) ([]cml.Change, error) {
+	if dryRun {
+		return cml.DryRunImportJSON(reader, options)
+	}
+
+	return nil, cml.ImportJSON(reader, options)
+}
+
+func (localBackend) getRevision() (uint64, error) {
+	return cml.GetRevision()
+}
+
+/*
+info describes the DB and the hooks registered in this process, which are none unless the DB is served by it
+*/
+func (localBackend) info() (*server.Info, error) {
+	revision, err := cml.GetRevision()
+	if err != nil {
+		return nil, err
+	}
+
+	return &server.Info{
+		SchemaVersion: cml.GetSupportedDBSchemaVersion(),
+		Revision:      revision,
+		HooksEnabled:  cml.GetHooksEnabled(),
+		Hooks:         cml.ListHooks()}, nil
+}
+
+/*
+getEvents polls the change log until changes after sinceRevision are found, or timeout expires
+*/
+func (localBackend) getEvents(path string, sinceRevision uint64, timeout time.Duration) ([]cml.Event, uint64,
+	error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		events, revision, err := cml.GetEvents(path, sinceRevision)
+		if err != nil || len(events) > 0 || time.Now().After(deadline) {
+			return events, revision, err
+		}
+
+		time.Sleep(eventsPollInterval)
+	}
+}
+
+type remoteBackend struct {
+	client *server.Client
+}
+
+/*
+getDeprecation always returns no deprecation, since deprecations are not exposed by the server
+*/
+func (remoteBackend) getDeprecation(path string) (*cml.Deprecation, error) {
+	return nil, nil
+}
+
+func (b remoteBackend) get(path string) (string, error) {
+	return b.client.Get(path)
+}
+
+func (b remoteBackend) exportJSON(path string, w io.Writer, options cml.ExportOptions) error {
+	return b.client.ExportJSON(path, w, options)
+}
+
+func (b remoteBackend) set(path string, value string, force bool) error {
+	if force {
+		return b.client.Force(path, value)
+	}
+
+	return b.client.Set(path, value)
+}
+
+/*
+setAll sets a single value, since the server has no API to set several values in a single transaction
+*/
+func (b remoteBackend) setAll(assignments []assignment, force bool) error {
+	if len(assignments) != 1 {
+		return fmt.Errorf("multiple assignments are not supported on a remote server")
+	}
+
+	return b.set(assignments[0].path, assignments[0].value, force)
+}
+
+func (b remoteBackend) delete(path string) error {
+	return b.client.Delete(path)
+}
+
+func (b remoteBackend) importJSON(reader io.Reader, options cml.ImportOptions, dryRun bool) ([]cml.Change, error) {
+	if dryRun {
+		return b.client.DryRunImportJSON(reader, options)
+	}
+
+	return nil, b.client.ImportJSON(reader, options)
+}
+
+func (b remoteBackend) getRevision() (uint64, error) {
+	return b.client.GetRevision()
+}
+
+func (b remoteBackend) info() (*server.Info, error) {
+	return b.client.Info()
+}
+
+func (b remoteBackend) getEvents(path string, sinceRevision uint64, timeout time.Duration) ([]cml.Event, uint64,
+	error) {
+	return b.client.GetEvents(path, sinceRevision, timeout)
+}
+
+/*
+parseAssignments parses args as a list of <path>=<value> assignments, split on the first =
+*/
+func parseAssignments(args []string) ([]assignment, bool) {
+	if len(args) == 0 {
+		return nil, false
+	}
+
+	assignments := make([]assignment, 0, len(args))
+	for _, a := range args {
+		path, value, ok := strings.Cut(a, "=")
+		if !ok || path == "" {
+			return nil, false
+		}
+
+		assignments = append(assignments, assignment{path: path, value: value})
+	}
+
+	return assignments, true
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	cml "github.com/debevv/camellia"
+)
+
+/*
+userConfigPath returns the path of the per-user config file of cml, camellia/config in the user config directory
+($XDG_CONFIG_HOME, or ~/.config, on Linux, ~/Library/Application Support on macOS and %AppData% on Windows)
+*/
+func userConfigPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "camellia", "config"), nil
+}
+
+/*
+readUserConfig reads the per-user config file, made of "name = value" lines, with # starting comments. A missing file
+is an empty config
+*/
+func readUserConfig() (map[string]string, error) {
+	config := map[string]string{}
+
+	p, err := userConfigPath()
+	if err != nil {
+		// Without a home directory there is no config to read
+		return config, nil
+	}
+
+	f, err := os.Open(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return config, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		l := strings.TrimSpace(scanner.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+
+		name, value, ok := strings.Cut(l, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected name = value", p, line)
+		}
+
+		config[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+/*
+setUserConfig sets name to value in the per-user config file, replacing its line, if any, and keeping the others. The
+file is replaced atomically, so that concurrent commands read either the old or the new config
+*/
+func setUserConfig(name string, value string) error {
+	p, err := userConfigPath()
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(p)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	lines := []string{}
+	found := false
+	for _, l := range strings.Split(string(data), "\n") {
+		n, _, ok := strings.Cut(l, "=")
+		if ok && !strings.HasPrefix(strings.TrimSpace(l), "#") && strings.TrimSpace(n) == name {
+			if found {
+				continue
+			}
+
+			l = name + " = " + value
+			found = true
+		}
+
+		lines = append(lines, l)
+	}
+
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	if !found {
+		lines = append(lines, name+" = "+value)
+	}
+
+	err = os.MkdirAll(filepath.Dir(p), 0700)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(p), "config-*")
+	if err != nil {
+		return err
+	}
+
+	_, err = f.WriteString(strings.Join(lines, "\n") + "\n")
+	if err == nil {
+		err = f.Sync()
+	}
+
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(f.Name(), p)
+	}
+
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return nil
+}
+
+/*
+checkDBFile verifies that the file at p is a camellia DB, without modifying it. Unencrypted DBs are recognized by their
+SQLite header, so that other files are not initialized as new DBs when opened
+*/
+func checkDBFile(p string, options cml.Options) error {
+	info, err := os.Stat(p)
+	if err != nil {
+		return err
+	}
+
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", p)
+	}
+
+	if options.EncryptionKey == "" {
+		header := make([]byte, 64)
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+
+		_, err = io.ReadFull(f, header)
+		f.Close()
+
+		// The schema version of camellia DBs is their SQLite user_version, at offset 60 of the header
+		if err != nil || string(header[:16]) != "SQLite format 3\x00" || binary.BigEndian.Uint32(header[60:]) == 0 {
+			return fmt.Errorf("%s is not a camellia DB", p)
+		}
+	}
+
+	_, err = cml.OpenWithOptions(p, options)
+	if err != nil {
+		return err
+	}
+
+	return cml.Close()
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	cml "github.com/debevv/camellia"
+)
+
+/*
+Exit codes of cml, stable across versions, so that scripts can tell the failures apart without matching their messages
+*/
+const (
+	exitFailure         = 1
+	exitNotFound        = 3
+	exitNotAValue       = 4
+	exitVersionMismatch = 5
+	exitLocked          = 6
+	exitDBNotFound      = 7
+)
+
+/*
+jsonErrors is whether errors are written to stderr as JSON objects, selected with --errors json
+*/
+var jsonErrors = false
+
+/*
+cliError is an error written to stderr with --errors json. Code names the kind of failure, like the exit code
+*/
+type cliError struct {
+	Error    string `json:"error"`
+	Code     string `json:"code"`
+	ExitCode int    `json:"exit_code"`
+}
+
+/*
+classifyError returns the code and the exit code of err
+*/
+func classifyError(err error) (string, int) {
+	switch {
+	case err == nil:
+		return "error", exitFailure
+	case errors.Is(err, cml.ErrPathNotFound):
+		return "not_found", exitNotFound
+	case errors.Is(err, cml.ErrPathIsNotAValue):
+		return "not_a_value", exitNotAValue
+	case errors.Is(err, cml.ErrDBVersionMismatch):
+		return "version_mismatch", exitVersionMismatch
+	case errors.Is(err, cml.ErrBusy):
+		return "locked", exitLocked
+	case errors.Is(err, cml.ErrDBNotFound):
+		return "db_not_found", exitDBNotFound
+	default:
+		return "error", exitFailure
+	}
+}
+
+/*
+reportError writes the message formatted from format and a to stderr, as text or as JSON (see jsonErrors), and returns
+the exit code of the first error in a, if any
+*/
+func reportError(format string, a ...any) int {
+	var err error
+	for _, arg := range a {
+		if e, ok := arg.(error); ok {
+			err = e
+			break
+		}
+	}
+
+	code, exitCode := classifyError(err)
+	message := fmt.Sprintf(format, a...)
+
+	if !jsonErrors {
+		printStderrLn("%s", message)
+		return exitCode
+	}
+
+	encoded, _ := json.Marshal(cliError{Error: message, Code: code, ExitCode: exitCode})
+	os.Stderr.Write(append(encoded, '\n'))
+
+	return exitCode
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	cml "github.com/debevv/camellia"
+)
+
+/*
+Verbosity levels, selected with --quiet and --verbose
+*/
+const (
+	verbosityQuiet   = -1
+	verbosityDefault = 0
+	verbosityInfo    = 1
+	verbosityDebug   = 2
+)
+
+var verbosity = verbosityDefault
+
+/*
+stderrLogger prints the messages reported by the library to stderr: warnings and errors by default, errors only with
+--quiet, and informational and debug messages with --verbose
+*/
+type stderrLogger struct{}
+
+func (stderrLogger) Debug(msg string, args ...any) {
+	if verbosity >= verbosityDebug {
+		printStderrLn("Debug: %s", formatLog(msg, args))
+	}
+}
+
+func (stderrLogger) Info(msg string, args ...any) {
+	if verbosity >= verbosityInfo {
+		printStderrLn("Info: %s", formatLog(msg, args))
+	}
+}
+
+func (stderrLogger) Warn(msg string, args ...any) {
+	if verbosity > verbosityQuiet {
+		printStderrLn("Warning: %s", formatLog(msg, args))
+	}
+}
+
+func (stderrLogger) Error(msg string, args ...any) {
+	printStderrLn("Error: %s", formatLog(msg, args))
+}
+
+/*
+formatLog formats a message followed by alternating keys and values, like "msg key1=value1 key2=value2"
+*/
+func formatLog(msg string, args []any) string {
+	b := strings.Builder{}
+	b.WriteString(msg)
+
+	for i := 0; i+1 < len(args); i += 2 {
+		b.WriteString(fmt.Sprintf(" %v=%v", args[i], args[i+1]))
+	}
+
+	return b.String()
+}
+
+/*
+timingTracer prints the duration of the operations of the library, and of the commits of their transactions, at the
+highest verbosity
+*/
+type timingTracer struct{}
+
+type timingSpan struct {
+	name  string
+	path  string
+	start time.Time
+}
+
+func (timingTracer) Start(ctx context.Context, name string, path string) (context.Context, cml.Span) {
+	return ctx, &timingSpan{name: name, path: path, start: time.Now()}
+}
+
+func (s *timingSpan) End(err error) {
+	args := []any{"op", s.name, "path", s.path, "duration", time.Since(s.start)}
+	if err != nil {
+		args = append(args, "error", err)
+	}
+
+	printStderrLn("Debug: %s", formatLog("operation", args))
+}
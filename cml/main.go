@@ -1,43 +1,106 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
+	"net"
 	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	cml "github.com/debevv/camellia"
+	"github.com/debevv/camellia/etcd"
+	"github.com/debevv/camellia/server"
 )
 
 const (
 	defaultDBPath = "./camellia.db"
-	dbPathFile    = "/tmp/camellia.db.path"
+
+	defaultShutdownTimeout = 10 * time.Second
 )
 
 var initialized = false
+var remote = ""
+var dbPath = ""
+
+/*
+openExisting is whether the command only reads the DB, so that a missing DB is reported instead of being created
+*/
+var openExisting = false
 
+/*
+getDBPath returns the path of the DB selected by the --db option, the CAMELLIA_DB_PATH env variable or the db entry
+of the per-user config file, in this order, or the default one
+*/
 func getDBPath() (string, error) {
-	// Try to get it from an environment variable first
+	if dbPath != "" {
+		return dbPath, nil
+	}
+
 	path := os.Getenv("CAMELLIA_DB_PATH")
 	if path != "" {
 		return path, nil
 	}
 
-	// Try to get it from tmpfile
-	f, err := os.OpenFile(dbPathFile, os.O_RDONLY, 0666)
-	if err == nil {
-		defer f.Close()
-		path, err := io.ReadAll(io.LimitReader(f, 10000))
-		if err != nil {
-			return string(path), nil
-		}
+	config, err := readUserConfig()
+	if err != nil {
+		return "", fmt.Errorf("error reading config file - %w", err)
+	}
+
+	if config["db"] != "" {
+		return config["db"], nil
 	}
 
-	// We give up
 	return defaultDBPath, nil
 }
 
+func getOptions() cml.Options {
+	return cml.Options{EncryptionKey: os.Getenv("CAMELLIA_DB_KEY")}
+}
+
+/*
+getParams parses the arguments starting from os.Args[from] as a list of "--name value" pairs. The names in switches
+take no value, and are set to "true" when present
+*/
+func getParams(from uint, switches ...string) map[string]string {
+	params := make(map[string]string)
+	for i := int(from); i < len(os.Args); i += 2 {
+		name := os.Args[i]
+		if _, ok := params[name]; ok {
+			return nil
+		}
+
+		isSwitch := false
+		for _, s := range switches {
+			if name == s {
+				isSwitch = true
+			}
+		}
+
+		if isSwitch {
+			params[name] = "true"
+			i--
+			continue
+		}
+
+		if !strings.HasPrefix(name, "--") || i+1 >= len(os.Args) {
+			return nil
+		}
+
+		params[name] = os.Args[i+1]
+	}
+
+	return params
+}
+
 func getFlags(from uint) map[string]bool {
 	params := make(map[string]bool)
 	for i := int(from); i < len(os.Args); i++ {
@@ -54,6 +117,29 @@ func getFlags(from uint) map[string]bool {
 	return params
 }
 
+/*
+takeOptionValues removes every name <value> pair from the arguments of the command, returning the values, or false if
+name is not followed by a value
+*/
+func takeOptionValues(name string) ([]string, bool) {
+	values := []string{}
+	for i := 2; i < len(os.Args); i++ {
+		if os.Args[i] != name {
+			continue
+		}
+
+		if i+1 >= len(os.Args) {
+			return nil, false
+		}
+
+		values = append(values, os.Args[i+1])
+		os.Args = append(os.Args[:i], os.Args[i+2:]...)
+		i--
+	}
+
+	return values, true
+}
+
 func printStderr(format string, a ...any) {
 	os.Stderr.WriteString(fmt.Sprintf(format, a...))
 }
@@ -62,69 +148,449 @@ func printStderrLn(format string, a ...any) {
 	printStderr(format+"\n", a...)
 }
 
+/*
+printNotice prints a diagnostic message to stderr, unless running with --quiet
+*/
+func printNotice(format string, a ...any) {
+	if verbosity > verbosityQuiet {
+		printStderrLn(format, a...)
+	}
+}
+
+/*
+errExit reports an error (see reportError), returning the exit code of cml
+*/
 func errExit(format string, a ...any) int {
-	printStderrLn(format, a...)
-	return 1
+	return reportError(format, a...)
 }
 
 func usageExit() int {
+	configPath, err := userConfigPath()
+	if err != nil {
+		configPath = "camellia/config in the user config directory"
+	}
+
 	printStderrLn(
 		`cml - The camellia hierarchical key-value store utility
 Usage:
-cfg get [-e] [-v] <path>        Displays the configuration entry (and its children) at <path> in JSON format
+cfg [--remote <remote>] <command>
+                                Runs get, set, delete, import, merge, seed, monitor, on-change and info on the camellia
+                                server at <remote> (an HTTP URL or a Unix domain socket path) instead of on the DB file
+cfg [--db <path>] <command>     Runs <command> on the DB file at <path>
+cfg [-q|--quiet] [-v|--verbose] <command>
+                                Suppresses the diagnostic messages, like the creation of new DBs, keeping only the
+                                errors, or displays more of them: -v the informational messages of the library, -v -v
+                                also its debug messages, and the duration of each operation and SQL commit
+cfg [--errors text|json] <command>
+                                Writes errors to stderr as text (the default) or, with json, as JSON objects with
+                                the error message, the code and the exit code of the error
+cfg get [-e] [-c] [-n] [-r] [-v] [--gzip] [-o <file>] [--default <value>] [--include <glob>] [--exclude <glob>]
+        <path>
+                                Displays the configuration entry (and its children) at <path> in JSON format
                                 -e        Displays entries in the extended JSON format
+                                -c        Omits volatile properties (timestamps) from the extended JSON format
+                                -n        Displays typed values as native JSON numbers and booleans
+                                -r        Includes runtime values and virtual mounts (on a server, see serve)
                                 -v        Fails (returns nonzero) if the entry is not a value
+                                --gzip    Compresses the output with gzip
+                                -o        Writes the output to <file> instead of stdout, compressed with gzip if
+                                          <file> ends with .gz
+                                --default Displays <value>, and succeeds, if no entry exists at <path>
+                                --include Displays only the entries matching <glob>, relative to <path>, and their
+                                          children. Can be repeated
+                                --exclude Omits the entries matching <glob>, relative to <path>, and their children.
+                                          Can be repeated. A final ** segment in <glob> matches any number of segments
 cfg set [-f] <path> <value>     Sets the configuration entry at <path> to <value>
                                 -f        Forces overwrite of non-value entries
+cfg set [-f] <path>=<value> [<path>=<value>...]
+                                Sets each configuration entry at <path> to its <value>, in a single transaction: if
+                                any of them fails, all the failures are reported, and none is set. Not supported on
+                                a remote server with more than one assignment
+                                -f        Forces overwrite of non-value entries
 cfg delete <path>               Deletes a configuration entry (and its children)
-cfg import [-e] <file>          Imports config entries from JSON <file>
+cfg import [-e] [-n] [--fire-hooks] [--dry-run] <file>
+                                Imports config entries from JSON <file>, which can be compressed with gzip
+                                -e        Use the extended JSON format
+                                -n        Preserve the type of JSON numbers and booleans
+                                --fire-hooks Calls the hooks of the imported values
+                                --dry-run Displays the changes without applying them
+cfg merge [-e] [-n] [--fire-hooks] [--dry-run] <file>
+                                Imports only non-existing config entries from JSON <file>, which can be compressed
+                                with gzip
                                 -e        Use the extended JSON format
-cfg merge [-e] <file>           Imports only non-existing config entries from JSON <file>
+                                -n        Preserve the type of JSON numbers and booleans
+                                --fire-hooks Calls the hooks of the imported values
+                                --dry-run Displays the changes without applying them
+cfg seed <file>                 Sets the default values in JSON <file> where no config entries exist yet, preserving
+                                the type of JSON numbers and booleans (like merge -n)
+cfg monitor [<path>] [--since <revision>]
+                                Writes to stdout the changes to the entries at <path> (and its children), as they are
+                                made, until interrupted. Each change is a line of JSON, with the type, path, old and new
+                                value, revision and timestamp of the change. With --since, starts from the changes after
+                                <revision>
+cfg on-change <glob> -- <command> [<args>]
+                                Runs <command> for each change to the entries whose path matches <glob>, as they are
+                                made, until interrupted. * and ? match within a single segment of the path, like
+                                config/*/port. The change is passed in the CAMELLIA_CHANGE (created, updated,
+                                overwritten or deleted), CAMELLIA_PATH, CAMELLIA_VALUE, CAMELLIA_OLD_VALUE,
+                                CAMELLIA_IS_VALUE and CAMELLIA_REVISION env variables. Commands run one at a time, in
+                                the order of the changes, and their failures are reported without stopping
+cfg update [-e] [-n] [--fire-hooks] [--dry-run] <base> <file>
+                                Applies the changes made by JSON <file> relative to JSON <base>, the file the entries
+                                were last imported from, preserving the entries changed locally in the meantime
                                 -e        Use the extended JSON format
-cfg migrate                     Migrates the DB to the current supported version
+                                -n        Preserve the type of JSON numbers and booleans
+                                --fire-hooks Calls the hooks of the imported values
+                                --dry-run Displays the changes without applying them
+cfg apply <file> [--type patch|merge] [--path <path>] [--dry-run]
+                                Applies the JSON Patch (RFC 6902) or, with --type merge, the JSON Merge Patch
+                                (RFC 7386) in <file> to the entries at <path> (the root by default) in a single
+                                transaction, displaying the resulting changes
+                                --dry-run Displays the changes without applying them
+cfg migrate [--no-backup]       Migrates the DB to the current supported version
+                                --no-backup Does not back up the DB before migrating it
+cfg rekey                       Re-encrypts the DB with the key in the CAMELLIA_DB_NEW_KEY env variable
+cfg migrate-config [<file>...]  Applies the config migrations in the JSON <file>s, up to the highest version they reach
+                                Without files, displays the current config version
+cfg serve [--listen <addr>] [--socket <path>] [--resp <addr>] [--auth <file>] [--acl <file>]
+          [--tls-cert <file> --tls-key <file> [--tls-client-ca <file>]]
+          [--rate-limit <n> [--rate-burst <n>]] [--max-body-size <bytes>] [--ui] [--shutdown-timeout <seconds>]
+          [--mount-env <prefix>] [--mount-dir <prefix>=<dir>]
+                                Serves the DB over HTTP on the TCP address <addr> (like :8080),
+                                and/or over the line-based JSON protocol on the Unix domain socket at <path>,
+                                and/or over the Redis protocol on the TCP address of --resp
+                                --auth          Requires HTTP and Redis clients to present one of the credentials in the JSON <file>
+                                --acl           Enforces the ACL in the JSON <file> on every request
+                                --tls-cert      Serves HTTPS with the PEM certificate in <file>
+                                --tls-key       Serves HTTPS with the PEM key in <file>
+                                --tls-client-ca Requires clients to present a certificate signed by the CAs in <file>
+                                --rate-limit    Allows each client <n> requests per second (--rate-burst in a burst)
+                                --max-body-size Rejects requests larger than <bytes> (16 MiB by default)
+                                --ui            Serves the web UI at /ui/ on <addr>
+                                --shutdown-timeout On SIGTERM or SIGINT, waits up to <seconds> (10 by default) for the
+                                                requests in flight before closing the DB
+                                --mount-env     Exposes the environment variables read-only under <prefix>
+                                --mount-dir     Exposes the files in <dir>, like /proc/sys, read-only under <prefix>
+                                When socket activated by systemd, also serves the sockets passed by it: the ones
+                                named (with FileDescriptorName=) "resp" over the Redis protocol, "socket" over the
+                                socket protocol, and the others over HTTP. Readiness and watchdog pings are sent
+                                to systemd if requested by the service (Type=notify, WatchdogSec=)
+cfg etcd <import|export|mirror> <endpoint> [--prefix <prefix>] [--path <path>] [--keep]
+                                Synchronizes the entries at <path> with the keys under <prefix> of the etcd cluster
+                                at <endpoint> (like http://127.0.0.1:2379): import reads them from etcd, export
+                                writes them to etcd, and mirror does both, then keeps them in sync until SIGTERM or SIGINT
+                                --keep    Does not overwrite the existing entries and keys, only creating the missing ones
+cfg replicate <primary> [--path <path>]
+                                Keeps the entries at <path> (the root by default) a replica of the ones on the camellia
+                                HTTP server at <primary>, until SIGTERM or SIGINT. Resumes from the last change applied
+cfg push <remote> [--path <path>]
+                                Sends the entries at <path> (the root by default) changed since the last push to the
+                                camellia HTTP server at <remote>, deleting the ones deleted locally
+cfg pull <remote> [--path <path>]
+                                Applies the entries at <path> changed on the camellia HTTP server at <remote> since the
+                                last pull. The first pull copies them in full
+cfg backup <file> [--since <revision>]
+                                Writes to <file> a compressed archive of the entries changed after <revision>, or of
+                                all of them without --since, displaying the revision to pass to the next backup
+cfg restore <file>              Applies an archive written by backup to the DB. Restore a full backup first, then the
+                                incremental ones taken after it, in order
+cfg info                        Displays the schema version and revision of the DB, and the hooks registered, with
+                                their invocation and error counts. The hooks of an application are only visible on
+                                the camellia server it runs, with --remote
+cfg fsck                        Verifies the checksums of the values in the DB, listing the corrupted ones
+cfg salvage <src> <dst>         Copies the entries still readable in the damaged DB file <src> to the new DB file
+                                <dst>, reporting the rows lost by table, the values dropped because corrupted and
+                                the entries recreated because lost while their children weren't
 cfg wipe [-y]                   Wipes the DB
                                 -y        Does not ask for confirmation
+cfg version                     Displays the version of cml and of the camellia library, the DB schema version they
+                                support, and the schema version of the DB (on the server, with --remote)
+cfg use <path>                  Selects the DB at <path> as the default one for the next commands, recording it in
+                                the per-user config file. The DB must exist
+cfg use --show                  Displays the path of the DB the commands run on
 cfg help                        Displays this help message
 
 DB path is selected in this order:
-- Reading the CONFIG_DB_PATH env variable
-- Reading %s
-- cml.db in the working directory`,
-		dbPathFile)
+- The --db option
+- Reading the CAMELLIA_DB_PATH env variable
+- Reading the "db = <path>" line of %s
+- camellia.db in the working directory
+
+Commands that only read the DB (get, monitor, on-change, info, backup, fsck), rekey and wipe fail if the DB
+doesn't exist, while the other ones create it
+
+Exit codes are 0 on success, 3 if the entry is not found (code not_found), 4 if the entry is not a value
+(not_a_value), 5 if the DB needs migration (version_mismatch), 6 if the DB is locked by another process (locked),
+7 if the DB doesn't exist (db_not_found) and 1 on any other error (error) and on invalid arguments
+
+The key of encrypted DBs is read from the CAMELLIA_DB_KEY env variable
+The identity recorded as the writer of the changed entries is read from the CAMELLIA_WRITER env variable
+The remote server can also be selected with the CAMELLIA_REMOTE env variable, and the bearer token
+sent to it with CAMELLIA_REMOTE_TOKEN. For HTTPS servers, CAMELLIA_REMOTE_CA selects the CA to trust, while
+CAMELLIA_REMOTE_CERT and CAMELLIA_REMOTE_KEY select the client certificate`,
+		configPath)
 
 	return 1
 }
 
+/*
+monitorTimeout is how long each read of the changes waits for new ones
+*/
+const monitorTimeout = 30 * time.Second
+
+/*
+monitorEvent is a change written by the monitor command as a line of JSON. Timestamp is the time the change was read
+*/
+type monitorEvent struct {
+	Type      string `json:"type"`
+	Path      string `json:"path"`
+	IsValue   bool   `json:"is_value"`
+	Old       string `json:"old"`
+	New       string `json:"new"`
+	Revision  uint64 `json:"revision"`
+	Timestamp string `json:"timestamp"`
+}
+
+type configMigrationOp struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	To    string `json:"to"`
+	Value string `json:"value"`
+}
+
+type configMigrationFile struct {
+	From uint64              `json:"from"`
+	To   uint64              `json:"to"`
+	Ops  []configMigrationOp `json:"ops"`
+}
+
+/*
+loadConfigMigration registers the migration described by a JSON file, returning the version it reaches
+*/
+func loadConfigMigration(filePath string) (uint64, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return 0, err
+	}
+
+	defer file.Close()
+
+	var m configMigrationFile
+	err = json.NewDecoder(file).Decode(&m)
+	if err != nil {
+		return 0, err
+	}
+
+	err = cml.RegisterConfigMigration(m.From, m.To, func(tx *cml.Tx) error {
+		for _, op := range m.Ops {
+			var err error
+
+			switch op.Op {
+			case "set":
+				err = tx.Force(op.Path, op.Value)
+			case "delete":
+				err = tx.Delete(op.Path)
+			case "move":
+				err = tx.Move(op.Path, op.To)
+			default:
+				err = fmt.Errorf("unknown operation %s", op.Op)
+			}
+
+			if err != nil {
+				return fmt.Errorf("error applying %s on %s - %w", op.Op, op.Path, err)
+			}
+		}
+
+		return nil
+	})
+
+	return m.To, err
+}
+
+/*
+loadCredentials reads the list of credentials accepted by the server from a JSON file
+*/
+func loadCredentials(filePath string) ([]server.Credential, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	var credentials []server.Credential
+	err = json.NewDecoder(file).Decode(&credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	return credentials, nil
+}
+
+func printChanges(changes []cml.Change) {
+	for _, c := range changes {
+		switch c.Type {
+		case cml.ChangeUpdated:
+			fmt.Printf("%-12s %s: \"%s\" -> \"%s\"\n", c.Type, c.Path, c.OldValue, c.Value)
+		default:
+			if c.IsValue {
+				fmt.Printf("%-12s %s = \"%s\"\n", c.Type, c.Path, c.Value)
+			} else {
+				fmt.Printf("%-12s %s/\n", c.Type, c.Path)
+			}
+		}
+	}
+}
+
 func initialize() {
 	dbPath, err := getDBPath()
 	if err != nil {
-		os.Exit(errExit("Error getting DB path from environment - %v", err))
+		os.Exit(errExit("Error getting DB path - %v", err))
+	}
+
+	created := false
+	if openExisting {
+		err = cml.OpenExistingWithOptions(dbPath, getOptions())
+	} else {
+		created, err = cml.OpenWithOptions(dbPath, getOptions())
 	}
 
-	created, err := cml.Open(dbPath)
 	if err != nil {
 		if errors.Is(err, cml.ErrDBVersionMismatch) {
-			os.Exit(errExit("DB version mismatch, needs migration (cml migrate)"))
+			os.Exit(errExit("%v, needs migration (cml migrate)", err))
 		} else {
 			os.Exit(errExit("Error intializing camellia (DB path: %s) - %v", dbPath, err))
 		}
 	}
 
 	if created {
-		printStderrLn("Created new DB file at %s - version %d", dbPath, cml.GetSupportedDBSchemaVersion())
+		printNotice("Created new DB file at %s - version %d", dbPath, cml.GetSupportedDBSchemaVersion())
 	}
 
+	cml.SetWriter(os.Getenv("CAMELLIA_WRITER"))
+
 	initialized = true
 }
 
+/*
+getBackend opens the local DB, or connects to the remote server, if any
+*/
+func getBackend() backend {
+	if remote == "" {
+		initialize()
+		return localBackend{}
+	}
+
+	return remoteBackend{client: newClient(remote)}
+}
+
+/*
+newClient connects to the server at remote, with the credentials and the TLS configuration read from the environment
+*/
+func newClient(remote string) *server.Client {
+	client, err := server.NewClient(remote)
+	if err != nil {
+		os.Exit(errExit("Error connecting to remote %s - %v", remote, err))
+	}
+
+	client.SetToken(os.Getenv("CAMELLIA_REMOTE_TOKEN"))
+
+	tlsConfig, err := server.ClientTLSConfig(os.Getenv("CAMELLIA_REMOTE_CA"), os.Getenv("CAMELLIA_REMOTE_CERT"),
+		os.Getenv("CAMELLIA_REMOTE_KEY"))
+	if err != nil {
+		os.Exit(errExit("Error loading TLS configuration - %v", err))
+	}
+
+	client.SetTLSConfig(tlsConfig)
+
+	return client
+}
+
+/*
+parseGlobalOptions reads the options preceding the command, --remote, --db, --errors, --quiet and --verbose, removing
+them from os.Args. The remote server can also be selected by the environment
+*/
+func parseGlobalOptions() bool {
+	remote = os.Getenv("CAMELLIA_REMOTE")
+
+	for len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "-q", "--quiet":
+			verbosity = verbosityQuiet
+			os.Args = append(os.Args[:1], os.Args[2:]...)
+			continue
+		case "-v", "--verbose":
+			verbosity++
+			os.Args = append(os.Args[:1], os.Args[2:]...)
+			continue
+		case "--remote", "--db", "--errors":
+		default:
+			return true
+		}
+
+		if len(os.Args) < 3 || os.Args[2] == "" {
+			return false
+		}
+
+		switch os.Args[1] {
+		case "--remote":
+			remote = os.Args[2]
+		case "--db":
+			dbPath = os.Args[2]
+		default:
+			if os.Args[2] != "text" && os.Args[2] != "json" {
+				return false
+			}
+
+			jsonErrors = os.Args[2] == "json"
+		}
+
+		os.Args = append(os.Args[:1], os.Args[3:]...)
+	}
+
+	return true
+}
+
 func run() int {
-	if len(os.Args) < 2 {
+	if !parseGlobalOptions() || len(os.Args) < 2 {
 		return usageExit()
 	}
+
+	if verbosity >= verbosityDebug {
+		cml.SetTracer(timingTracer{})
+	}
+
+	switch os.Args[1] {
+	case "get", "monitor", "on-change", "info", "backup", "fsck", "rekey", "wipe":
+		openExisting = true
+	}
 	var onlyMerge bool
 
+	switch os.Args[1] {
+	case "get", "set", "delete", "import", "merge", "seed", "monitor", "on-change", "info", "version", "help":
+	default:
+		if remote != "" {
+			return errExit("Command %s is not supported on a remote server", os.Args[1])
+		}
+	}
+
 	switch os.Args[1] {
 	case "get":
+		// -o, --default, --include and --exclude take a value, which may look like a flag, so they are removed before
+		// parsing the flags
+		outputs, okOutput := takeOptionValues("-o")
+		defaults, okDefault := takeOptionValues("--default")
+		includes, okInclude := takeOptionValues("--include")
+		excludes, okExclude := takeOptionValues("--exclude")
+		if !okOutput || !okDefault || !okInclude || !okExclude || len(outputs) > 1 || len(defaults) > 1 ||
+			(len(defaults) == 1 && len(os.Args) < 3) {
+			return usageExit()
+		}
+
+		defaultValue, hasDefault := "", len(defaults) == 1
+		if hasDefault {
+			defaultValue = defaults[0]
+		}
 
 		var path string
 		if len(os.Args) > 2 {
@@ -139,60 +605,105 @@ func run() int {
 			}
 		}
 
-		initialize()
+		b := getBackend()
+
+		deprecation, err := b.getDeprecation(path)
+		if err != nil {
+			return errExit("Error getting deprecation - %v", err)
+		}
+
+		if deprecation != nil {
+			if deprecation.Replacement != "" {
+				printNotice("Notice: %s is deprecated, use %s instead", path, deprecation.Replacement)
+			} else {
+				printNotice("Notice: %s is deprecated", path)
+			}
+		}
 
 		var out string
-		var err error
 
 		if flags["-v"] {
-			out, err = cml.Get[string](path)
-			if err != nil {
-				return errExit("Error getting value - %v", err)
-			}
+			out, err = b.get(path)
 		}
 
-		if flags["-e"] {
-			out, err = cml.EntryToJSON(path)
-			if err != nil {
-				return errExit("Error getting value - %v", err)
-			}
-		} else {
-			out, err = cml.ValuesToJSON(path)
+		compress := flags["--gzip"] || (len(outputs) == 1 && strings.HasSuffix(outputs[0], ".gz"))
+
+		w := strings.Builder{}
+		if err == nil {
+			err = b.exportJSON(path, &w, cml.ExportOptions{
+				Extended:    flags["-e"],
+				Canonical:   flags["-c"],
+				NativeTypes: flags["-n"],
+				Runtime:     flags["-r"],
+				Include:     includes,
+				Exclude:     excludes,
+				Gzip:        compress})
+		}
+
+		if hasDefault && errors.Is(err, cml.ErrPathNotFound) {
+			fmt.Println(defaultValue)
+			break
+		}
+
+		if err != nil {
+			return errExit("Error getting value - %v", err)
+		}
+
+		out = w.String()
+
+		// Single values are displayed without quotes, while compressed outputs are written as they are
+		if !compress {
+			out = strings.Trim(out, "\n")
+			out = strings.Trim(out, "\"")
+			out += "\n"
+		}
+
+		if len(outputs) == 1 {
+			// Exports may hold credentials, so the file is readable by its owner only
+			err = os.WriteFile(outputs[0], []byte(out), 0600)
 			if err != nil {
-				return errExit("Error getting value - %v", err)
+				return errExit("Error writing file %s - %v", outputs[0], err)
 			}
+
+			break
 		}
 
-		out = strings.Trim(out, "\n")
-		out = strings.Trim(out, "\"")
 		os.Stdout.WriteString(out)
-		os.Stdout.WriteString("\n")
 
 	case "set":
-		if len(os.Args) < 4 {
-			return usageExit()
+		args := os.Args[2:]
+		force := len(args) > 0 && args[0] == "-f"
+		if force {
+			args = args[1:]
 		}
 
-		path := os.Args[len(os.Args)-2]
-		value := os.Args[len(os.Args)-1]
-
-		var flags map[string]bool
-		if len(os.Args) > 4 {
-			flags = getFlags(2)
-			if flags == nil {
+		// Without a single <path> <value> pair, every argument is a <path>=<value> assignment
+		if len(args) != 2 || strings.Contains(args[0], "=") {
+			assignments, ok := parseAssignments(args)
+			if !ok {
 				return usageExit()
 			}
+
+			err := getBackend().setAll(assignments, force)
+			if err != nil {
+				return errExit("Error setting values - %v", err)
+			}
+
+			break
 		}
 
-		initialize()
+		path := args[0]
+		value := args[1]
 
-		if flags["-f"] {
-			err := cml.Force(path, value)
+		b := getBackend()
+
+		if force {
+			err := b.set(path, value, true)
 			if err != nil {
 				return errExit("Error forcing value - %v", err)
 			}
 		} else {
-			err := cml.Set(path, value)
+			err := b.set(path, value, false)
 			if err != nil {
 				return errExit("Error setting value - %v", err)
 			}
@@ -203,11 +714,11 @@ func run() int {
 			return usageExit()
 		}
 
-		initialize()
+		b := getBackend()
 
 		path := os.Args[2]
 
-		err := cml.Delete(path)
+		err := b.delete(path)
 		if err != nil {
 			return errExit("Error deleting entry - %v", err)
 		}
@@ -228,7 +739,7 @@ func run() int {
 
 		var flags map[string]bool
 		if len(os.Args) > 3 {
-			flags := getFlags(2)
+			flags = getFlags(2)
 			if flags == nil {
 				return usageExit()
 			}
@@ -239,91 +750,931 @@ func run() int {
 			return errExit("Error opening file %s - %v", filePath, err)
 		}
 
-		initialize()
+		b := getBackend()
 
-		if flags["-e"] {
-			err = cml.SetEntriesFromJSON(file, onlyMerge)
-		} else {
-			err = cml.SetValuesFromJSON(file, onlyMerge)
+		action := "importing"
+		if onlyMerge {
+			action = "merging"
+		}
+
+		options := cml.ImportOptions{
+			Extended:    flags["-e"],
+			OnlyMerge:   onlyMerge,
+			NativeTypes: flags["-n"],
+			FireHooks:   flags["--fire-hooks"]}
+
+		if flags["--dry-run"] {
+			changes, err := b.importJSON(file, options, true)
+			if err != nil {
+				return errExit("Error %s file %s - %v", action, filePath, err)
+			}
+
+			printChanges(changes)
+			break
 		}
 
+		_, err = b.importJSON(file, options, false)
 		if err != nil {
-			return errExit("Error merging file %s - %v", filePath, err)
+			return errExit("Error %s file %s - %v", action, filePath, err)
 		}
 
-	case "migrate":
-		dbPath, err := getDBPath()
+	case "seed":
+		if len(os.Args) != 3 || os.Args[2] == "" {
+			return usageExit()
+		}
+
+		filePath := os.Args[2]
+		file, err := os.Open(filePath)
 		if err != nil {
-			os.Exit(errExit("Error getting DB path from environment - %v", err))
+			return errExit("Error opening file %s - %v", filePath, err)
 		}
 
-		migrated, err := cml.Migrate(dbPath)
+		b := getBackend()
+
+		_, err = b.importJSON(file, cml.ImportOptions{OnlyMerge: true, NativeTypes: true}, false)
 		if err != nil {
-			return errExit("Error migrating DB - %v", err)
+			return errExit("Error seeding from file %s - %v", filePath, err)
 		}
 
-		if migrated {
-			printStderrLn("Migrated DB to version %d", cml.GetSupportedDBSchemaVersion())
+	case "monitor":
+		params := getParams(2)
+		path := ""
+		if len(os.Args) > 2 && !strings.HasPrefix(os.Args[2], "--") {
+			path = os.Args[2]
+			params = getParams(3)
 		}
 
-	case "wipe":
-		flags := getFlags(2)
-		if flags == nil {
+		if params == nil {
 			return usageExit()
 		}
 
-		c := "n"
+		b := getBackend()
 
-		initialize()
+		revision, err := b.getRevision()
+		if params["--since"] != "" {
+			revision, err = strconv.ParseUint(params["--since"], 10, 64)
+		}
 
-		if !flags["-y"] {
-			printStderr("Do you really want to wipe the DB at %s ? [y/N] ", cml.GetDBPath())
-			fmt.Scanf("%s\n", &c)
-			c = strings.TrimSpace(c)
-			c = strings.ToLower(c)
-		} else {
-			c = "y"
+		if err != nil {
+			return errExit("Error getting revision - %v", err)
 		}
 
-		if c == "y" {
-			err := cml.Wipe()
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetEscapeHTML(false)
+
+		for {
+			events, next, err := b.getEvents(path, revision, monitorTimeout)
+			if errors.Is(err, cml.ErrRevisionCompacted) {
+				printNotice("Changes after revision %d discarded, resuming from the current revision", revision)
+				revision, err = b.getRevision()
+				if err == nil {
+					continue
+				}
+			}
+
 			if err != nil {
-				return errExit("Error wiping the DB - %v", err)
-			} else {
-				printStderrLn("DB was wiped")
+				return errExit("Error getting changes - %v", err)
 			}
-		} else {
-			printStderrLn("DB was NOT wiped")
+
+			timestamp := time.Now().UTC().Format(time.RFC3339Nano)
+			for _, e := range events {
+				err = encoder.Encode(monitorEvent{
+					Type:      e.Type.String(),
+					Path:      e.Path,
+					IsValue:   e.IsValue,
+					Old:       e.OldValue,
+					New:       e.Value,
+					Revision:  e.Revision,
+					Timestamp: timestamp})
+
+				if err != nil {
+					return errExit("Error writing change - %v", err)
+				}
+			}
+
+			revision = next
 		}
 
-	case "info":
-		/* TODO
-		type info struct {
-			Path    string
-			Version uint64
-			Entries uint
-			Size    uint
+	case "on-change":
+		if len(os.Args) < 5 || os.Args[3] != "--" {
+			return usageExit()
 		}
 
-		initialize()
+		glob, prefix, err := onChangeGlob(os.Args[2])
+		if err != nil {
+			return errExit("%v", err)
+		}
 
-		var i info
-		i.Path = cml.GetDBPath()
-		i.Version = cml.GetSupportedDBVersion()
-		*/
-		fallthrough
+		command := os.Args[4:]
 
-	case "help":
-		return usageExit()
+		b := getBackend()
 
-	default:
-		return usageExit()
-	}
+		revision, err := b.getRevision()
+		if err != nil {
+			return errExit("Error getting revision - %v", err)
+		}
 
-	return 0
-}
+		for {
+			events, next, err := b.getEvents(prefix, revision, monitorTimeout)
+			if errors.Is(err, cml.ErrRevisionCompacted) {
+				printNotice("Changes after revision %d discarded, resuming from the current revision", revision)
+				revision, err = b.getRevision()
+				if err == nil {
+					continue
+				}
+			}
+
+			if err != nil {
+				return errExit("Error getting changes - %v", err)
+			}
+
+			for _, e := range events {
+				if ok, _ := path.Match(glob, e.Path); !ok {
+					continue
+				}
+
+				err = runOnChange(command, e)
+				if err != nil {
+					printStderrLn("Error running %s on change to %s - %v", command[0], e.Path, err)
+				}
+			}
+
+			revision = next
+		}
+
+	case "update":
+		if len(os.Args) < 4 {
+			return usageExit()
+		}
+
+		basePath := os.Args[len(os.Args)-2]
+		filePath := os.Args[len(os.Args)-1]
+
+		var flags map[string]bool
+		if len(os.Args) > 4 {
+			flags = getFlags(2)
+			if flags == nil {
+				return usageExit()
+			}
+		}
+
+		base, err := os.Open(basePath)
+		if err != nil {
+			return errExit("Error opening file %s - %v", basePath, err)
+		}
+
+		file, err := os.Open(filePath)
+		if err != nil {
+			return errExit("Error opening file %s - %v", filePath, err)
+		}
+
+		initialize()
+
+		options := cml.ImportOptions{Extended: flags["-e"], NativeTypes: flags["-n"], FireHooks: flags["--fire-hooks"]}
+
+		var conflicts []string
+		if flags["--dry-run"] {
+			var changes []cml.Change
+			changes, conflicts, err = cml.DryRunImportJSONWithBase(base, file, options)
+			if err == nil {
+				printChanges(changes)
+			}
+		} else {
+			conflicts, err = cml.ImportJSONWithBase(base, file, options)
+		}
+
+		if err != nil {
+			return errExit("Error updating from file %s - %v", filePath, err)
+		}
+
+		for _, path := range conflicts {
+			printNotice("Kept local value of %s", path)
+		}
+
+	case "apply":
+		if len(os.Args) < 3 {
+			return usageExit()
+		}
+
+		params := getParams(3, "--dry-run")
+		if params == nil {
+			return usageExit()
+		}
+
+		filePath := os.Args[2]
+		file, err := os.Open(filePath)
+		if err != nil {
+			return errExit("Error opening file %s - %v", filePath, err)
+		}
+
+		initialize()
+
+		var changes []cml.Change
+		switch params["--type"] {
+		case "", "patch":
+			if params["--dry-run"] != "" {
+				changes, err = cml.DryRunJSONPatch(params["--path"], file)
+			} else {
+				changes, err = cml.ApplyJSONPatch(params["--path"], file)
+			}
+		case "merge":
+			if params["--dry-run"] != "" {
+				changes, err = cml.DryRunJSONMergePatch(params["--path"], file)
+			} else {
+				changes, err = cml.ApplyJSONMergePatch(params["--path"], file)
+			}
+		default:
+			return usageExit()
+		}
+
+		if err != nil {
+			return errExit("Error applying patch %s - %v", filePath, err)
+		}
+
+		printChanges(changes)
+
+	case "migrate":
+		dbPath, err := getDBPath()
+		if err != nil {
+			os.Exit(errExit("Error getting DB path - %v", err))
+		}
+
+		var flags map[string]bool
+		if len(os.Args) > 2 {
+			flags = getFlags(2)
+			if flags == nil {
+				return usageExit()
+			}
+		}
+
+		options := getOptions()
+		options.NoMigrationBackup = flags["--no-backup"]
+
+		migrated, err := cml.MigrateWithOptions(dbPath, options)
+		if err != nil {
+			return errExit("Error migrating DB - %v", err)
+		}
+
+		if migrated {
+			if cml.GetMigrationBackupPath() != "" {
+				printNotice("Backed up DB to %s", cml.GetMigrationBackupPath())
+			}
+
+			printNotice("Migrated DB to version %d", cml.GetSupportedDBSchemaVersion())
+		}
+
+	case "rekey":
+		newKey := os.Getenv("CAMELLIA_DB_NEW_KEY")
+		if newKey == "" {
+			return errExit("No new key specified (CAMELLIA_DB_NEW_KEY)")
+		}
+
+		initialize()
+
+		err := cml.RotateKey(getOptions().EncryptionKey, newKey)
+		if err != nil {
+			return errExit("Error rotating the DB key - %v", err)
+		}
+
+		printNotice("DB was re-encrypted with the new key")
+
+	case "migrate-config":
+		target := uint64(0)
+		for _, filePath := range os.Args[2:] {
+			to, err := loadConfigMigration(filePath)
+			if err != nil {
+				return errExit("Error loading config migration %s - %v", filePath, err)
+			}
+
+			if to > target {
+				target = to
+			}
+		}
+
+		initialize()
+
+		if len(os.Args) < 3 {
+			version, err := cml.GetConfigVersion()
+			if err != nil {
+				return errExit("Error getting config version - %v", err)
+			}
+
+			fmt.Println(version)
+			break
+		}
+
+		steps, err := cml.MigrateConfig(target)
+		if err != nil {
+			return errExit("Error migrating config - %v", err)
+		}
+
+		for _, step := range steps {
+			printNotice("Migrated config to version %d", step)
+		}
+
+	case "serve":
+		params := getParams(2, "--ui")
+		if params == nil {
+			return usageExit()
+		}
+
+		activated, err := systemdListeners()
+		if err != nil {
+			return errExit("Error using the sockets passed by systemd - %v", err)
+		}
+
+		if activated == nil && params["--listen"] == "" && params["--socket"] == "" && params["--resp"] == "" {
+			return usageExit()
+		}
+
+		options := server.Options{
+			TLSCertFile:     params["--tls-cert"],
+			TLSKeyFile:      params["--tls-key"],
+			TLSClientCAFile: params["--tls-client-ca"],
+			UI:              params["--ui"] != ""}
+
+		if params["--auth"] != "" {
+			var err error
+			options.Credentials, err = loadCredentials(params["--auth"])
+			if err != nil {
+				return errExit("Error loading credentials %s - %v", params["--auth"], err)
+			}
+		}
+
+		if params["--rate-limit"] != "" {
+			options.RateLimit, err = strconv.ParseFloat(params["--rate-limit"], 64)
+			if err != nil {
+				return errExit("Invalid rate limit %s - %v", params["--rate-limit"], err)
+			}
+		}
+
+		if params["--rate-burst"] != "" {
+			options.RateBurst, err = strconv.Atoi(params["--rate-burst"])
+			if err != nil {
+				return errExit("Invalid rate burst %s - %v", params["--rate-burst"], err)
+			}
+		}
+
+		if params["--max-body-size"] != "" {
+			options.MaxBodySize, err = strconv.ParseInt(params["--max-body-size"], 10, 64)
+			if err != nil {
+				return errExit("Invalid maximum body size %s - %v", params["--max-body-size"], err)
+			}
+		}
+
+		shutdownTimeout := defaultShutdownTimeout
+		if params["--shutdown-timeout"] != "" {
+			seconds, err := strconv.ParseFloat(params["--shutdown-timeout"], 64)
+			if err != nil {
+				return errExit("Invalid shutdown timeout %s - %v", params["--shutdown-timeout"], err)
+			}
+
+			shutdownTimeout = time.Duration(seconds * float64(time.Second))
+		}
+
+		if params["--acl"] != "" {
+			file, err := os.Open(params["--acl"])
+			if err != nil {
+				return errExit("Error opening ACL %s - %v", params["--acl"], err)
+			}
+
+			options.ACL, err = cml.LoadACL(file)
+			file.Close()
+			if err != nil {
+				return errExit("Error loading ACL %s - %v", params["--acl"], err)
+			}
+		}
+
+		initialize()
+
+		if params["--mount-env"] != "" {
+			err = cml.MountEnv(params["--mount-env"])
+			if err != nil {
+				return errExit("Error mounting environment at %s - %v", params["--mount-env"], err)
+			}
+		}
+
+		if params["--mount-dir"] != "" {
+			prefix, dir, ok := strings.Cut(params["--mount-dir"], "=")
+			if !ok {
+				return usageExit()
+			}
+
+			err = cml.MountDir(prefix, dir)
+			if err != nil {
+				return errExit("Error mounting directory %s at %s - %v", dir, prefix, err)
+			}
+		}
+
+		// Sockets passed by systemd are served by name: "resp" over the Redis protocol, "socket" over the socket
+		// protocol, any other over HTTP
+		var httpListeners, socketListeners, respListeners []net.Listener
+		for name, listeners := range activated {
+			switch name {
+			case "resp":
+				respListeners = append(respListeners, listeners...)
+			case "socket":
+				socketListeners = append(socketListeners, listeners...)
+			default:
+				httpListeners = append(httpListeners, listeners...)
+			}
+		}
+
+		if params["--listen"] != "" {
+			l, err := net.Listen("tcp", params["--listen"])
+			if err != nil {
+				return errExit("Error listening on %s - %v", params["--listen"], err)
+			}
+
+			httpListeners = append(httpListeners, l)
+		}
+
+		if params["--socket"] != "" {
+			err = os.Remove(params["--socket"])
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return errExit("Error removing stale socket %s - %v", params["--socket"], err)
+			}
+
+			l, err := net.Listen("unix", params["--socket"])
+			if err != nil {
+				return errExit("Error listening on %s - %v", params["--socket"], err)
+			}
+
+			socketListeners = append(socketListeners, l)
+		}
+
+		if params["--resp"] != "" {
+			l, err := net.Listen("tcp", params["--resp"])
+			if err != nil {
+				return errExit("Error listening on %s - %v", params["--resp"], err)
+			}
+
+			respListeners = append(respListeners, l)
+		}
+
+		srv := server.NewWithOptions(options)
+		errs := make(chan error, len(httpListeners)+len(socketListeners)+len(respListeners))
+
+		for _, l := range httpListeners {
+			printNotice("Serving DB %s over HTTP on %s", cml.GetDBPath(), l.Addr())
+
+			go func(l net.Listener) {
+				errs <- srv.Serve(l)
+			}(l)
+		}
+
+		for _, l := range socketListeners {
+			printNotice("Serving DB %s on socket %s", cml.GetDBPath(), l.Addr())
+
+			go func(l net.Listener) {
+				defer l.Close()
+				errs <- srv.ServeSocket(l)
+			}(l)
+		}
+
+		for _, l := range respListeners {
+			printNotice("Serving DB %s over the Redis protocol on %s", cml.GetDBPath(), l.Addr())
+
+			go func(l net.Listener) {
+				errs <- srv.ServeRESP(l)
+			}(l)
+		}
+
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+
+		sdNotify("READY=1\nSTATUS=Serving DB " + cml.GetDBPath())
+		startWatchdog()
+
+		select {
+		case err = <-errs:
+			return errExit("Error serving the DB - %v", err)
+
+		case sig := <-signals:
+			printNotice("Received %s, shutting down", sig)
+		}
+
+		// The DB is closed cleanly by main, once the in-flight requests and the hooks they triggered are done
+		sdNotify("STOPPING=1")
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		err = srv.Shutdown(ctx)
+		if err != nil {
+			printStderrLn("Error draining the requests in flight - %v", err)
+		}
+
+		err = cml.WaitAsyncHooks(ctx)
+		if err != nil {
+			printStderrLn("Error waiting for the hooks to complete - %v", err)
+		}
+
+	case "etcd":
+		if len(os.Args) < 4 {
+			return usageExit()
+		}
+
+		params := getParams(4, "--keep")
+		if params == nil {
+			return usageExit()
+		}
+
+		conflict := etcd.ConflictOverwrite
+		if params["--keep"] != "" {
+			conflict = etcd.ConflictKeep
+		}
+
+		s, err := etcd.New(etcd.Options{
+			Endpoint: os.Args[3],
+			Prefix:   params["--prefix"],
+			Path:     params["--path"],
+			Conflict: conflict})
+
+		if err != nil {
+			return errExit("Error configuring etcd sync - %v", err)
+		}
+
+		initialize()
+
+		ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+		defer cancel()
+
+		switch os.Args[2] {
+		case "import":
+			_, err = s.Import(ctx)
+			if err != nil {
+				return errExit("Error importing from etcd - %v", err)
+			}
+
+		case "export":
+			err = s.Export(ctx)
+			if err != nil {
+				return errExit("Error exporting to etcd - %v", err)
+			}
+
+		case "mirror":
+			revision, err := s.Import(ctx)
+			if err != nil {
+				return errExit("Error importing from etcd - %v", err)
+			}
+
+			err = s.Export(ctx)
+			if err != nil {
+				return errExit("Error exporting to etcd - %v", err)
+			}
+
+			printNotice("Mirroring DB %s with etcd at %s", cml.GetDBPath(), os.Args[3])
+
+			errs := make(chan error, 2)
+			go func() {
+				errs <- s.MirrorFrom(ctx, revision)
+			}()
+
+			go func() {
+				errs <- s.MirrorTo(ctx)
+			}()
+
+			err = <-errs
+			cancel()
+			<-errs
+
+			if err != nil {
+				return errExit("Error mirroring etcd - %v", err)
+			}
+
+		default:
+			return usageExit()
+		}
+
+	case "replicate":
+		if len(os.Args) < 3 {
+			return usageExit()
+		}
+
+		params := getParams(3)
+		if params == nil {
+			return usageExit()
+		}
+
+		client := newClient(os.Args[2])
+
+		initialize()
+
+		ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+		defer cancel()
+
+		printNotice("Replicating %s into DB %s", os.Args[2], cml.GetDBPath())
+
+		err := client.Replicate(ctx, server.ReplicateOptions{Path: params["--path"]})
+		if err != nil {
+			return errExit("Error replicating %s - %v", os.Args[2], err)
+		}
+
+	case "push", "pull":
+		if len(os.Args) < 3 {
+			return usageExit()
+		}
+
+		params := getParams(3)
+		if params == nil {
+			return usageExit()
+		}
+
+		client := newClient(os.Args[2])
+
+		initialize()
+
+		options := server.SyncOptions{Path: params["--path"]}
+
+		if os.Args[1] == "push" {
+			n, err := client.Push(context.Background(), options)
+			if err != nil {
+				return errExit("Error pushing to %s - %v", os.Args[2], err)
+			}
+
+			printNotice("Pushed %d changed entries to %s", n, os.Args[2])
+		} else {
+			n, err := client.Pull(context.Background(), options)
+			if err != nil {
+				return errExit("Error pulling from %s - %v", os.Args[2], err)
+			}
+
+			printNotice("Pulled %d changed entries from %s", n, os.Args[2])
+		}
+
+	case "backup":
+		if len(os.Args) < 3 {
+			return usageExit()
+		}
+
+		params := getParams(3)
+		if params == nil {
+			return usageExit()
+		}
+
+		var since uint64
+		if params["--since"] != "" {
+			var err error
+			since, err = strconv.ParseUint(params["--since"], 10, 64)
+			if err != nil {
+				return errExit("Invalid revision %s - %v", params["--since"], err)
+			}
+		}
+
+		initialize()
+
+		filePath := os.Args[2]
+		// Backups may hold secrets, like the config they are taken of
+		file, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return errExit("Error creating file %s - %v", filePath, err)
+		}
+
+		revision, err := cml.BackupSince(since, file)
+		if err == nil {
+			err = file.Close()
+		}
+
+		if err != nil {
+			file.Close()
+			os.Remove(filePath)
+			return errExit("Error backing up to %s - %v", filePath, err)
+		}
+
+		printNotice("Backed up up to revision %d (pass --since %d to the next backup)", revision, revision)
+
+	case "restore":
+		if len(os.Args) != 3 {
+			return usageExit()
+		}
+
+		filePath := os.Args[2]
+		file, err := os.Open(filePath)
+		if err != nil {
+			return errExit("Error opening file %s - %v", filePath, err)
+		}
+
+		initialize()
+
+		revision, err := cml.RestoreBackup(file)
+		if err != nil {
+			return errExit("Error restoring %s - %v", filePath, err)
+		}
+
+		printNotice("Restored backup of revision %d", revision)
+
+	case "fsck":
+		initialize()
+
+		corrupted, err := cml.Verify()
+		if err != nil {
+			return errExit("Error verifying the DB - %v", err)
+		}
+
+		for _, path := range corrupted {
+			fmt.Printf("%s: checksum mismatch\n", path)
+		}
+
+		if len(corrupted) > 0 {
+			return errExit("%d corrupted values found", len(corrupted))
+		}
+
+	case "salvage":
+		if len(os.Args) != 4 || os.Args[2] == "" || os.Args[3] == "" {
+			return usageExit()
+		}
+
+		report, err := cml.Salvage(os.Args[2], os.Args[3], getOptions().EncryptionKey)
+		if err != nil {
+			return errExit("Error salvaging DB %s - %v", os.Args[2], err)
+		}
+
+		tables := make([]string, 0, len(report.Salvaged)+len(report.Lost))
+		for t := range report.Salvaged {
+			tables = append(tables, t)
+		}
+
+		for t := range report.Lost {
+			if _, ok := report.Salvaged[t]; !ok {
+				tables = append(tables, t)
+			}
+		}
+
+		sort.Strings(tables)
+
+		for _, t := range tables {
+			fmt.Printf("%s: %d rows salvaged, %d lost\n", t, report.Salvaged[t], report.Lost[t])
+		}
+
+		for _, t := range report.LostTables {
+			fmt.Printf("%s: unreadable, all rows lost\n", t)
+		}
+
+		for _, path := range report.Corrupted {
+			fmt.Printf("%s: checksum mismatch, value dropped\n", path)
+		}
+
+		for _, path := range report.Recreated {
+			fmt.Printf("%s: entry lost, recreated empty\n", path)
+		}
+
+		if report.Complete() {
+			printNotice("DB %s salvaged to %s with no losses", os.Args[2], os.Args[3])
+		} else {
+			printNotice("DB %s salvaged to %s with losses", os.Args[2], os.Args[3])
+		}
+
+	case "wipe":
+		flags := getFlags(2)
+		if flags == nil {
+			return usageExit()
+		}
+
+		c := "n"
+
+		initialize()
+
+		if !flags["-y"] {
+			printStderr("Do you really want to wipe the DB at %s ? [y/N] ", cml.GetDBPath())
+			fmt.Scanf("%s\n", &c)
+			c = strings.TrimSpace(c)
+			c = strings.ToLower(c)
+		} else {
+			c = "y"
+		}
+
+		if c == "y" {
+			err := cml.Wipe()
+			if err != nil {
+				return errExit("Error wiping the DB - %v", err)
+			} else {
+				printNotice("DB was wiped")
+			}
+		} else {
+			printNotice("DB was NOT wiped")
+		}
+
+	case "info":
+		if len(os.Args) != 2 {
+			return usageExit()
+		}
+
+		b := getBackend()
+
+		info, err := b.info()
+		if err != nil {
+			return errExit("Error getting info - %v", err)
+		}
+
+		if remote == "" {
+			fmt.Printf("DB path:        %s\n", cml.GetDBPath())
+		} else {
+			fmt.Printf("Remote:         %s\n", remote)
+		}
+
+		fmt.Printf("Schema version: %d\n", info.SchemaVersion)
+		fmt.Printf("Revision:       %d\n", info.Revision)
+		fmt.Printf("Hooks enabled:  %t\n", info.HooksEnabled)
+		fmt.Printf("Hooks:          %d\n", len(info.Hooks))
+
+		for _, h := range info.Hooks {
+			mode := "sync"
+			if h.Async {
+				mode = "async"
+			}
+
+			fmt.Printf("  %-4s %-5s %s (%d invocations, %d errors)\n", h.Type, mode, h.Path, h.Invocations,
+				h.Errors)
+		}
+
+	case "version":
+		if len(os.Args) != 2 {
+			return usageExit()
+		}
+
+		fmt.Printf("cml version:              %s\n", cliVersion())
+		fmt.Printf("Library version:          %s\n", cml.Version())
+		fmt.Printf("Supported schema version: %d\n", cml.GetSupportedDBSchemaVersion())
+
+		if remote != "" {
+			fmt.Printf("Remote:                   %s\n", remote)
+
+			info, err := newClient(remote).Info()
+			if err != nil {
+				return errExit("Error getting info - %v", err)
+			}
+
+			fmt.Printf("DB schema version:        %d\n", info.SchemaVersion)
+			break
+		}
+
+		dbPath, err := getDBPath()
+		if err != nil {
+			return errExit("Error getting DB path - %v", err)
+		}
+
+		fmt.Printf("DB path:                  %s\n", dbPath)
+
+		// The DB is not opened, so that it is neither created nor required to be migrated
+		_, err = os.Stat(dbPath)
+		if err != nil {
+			fmt.Printf("DB schema version:        unavailable (%v)\n", err)
+			break
+		}
+
+		version, err := cml.GetDBFileSchemaVersion(dbPath, getOptions().EncryptionKey)
+		if err != nil {
+			return errExit("Error getting DB schema version - %v", err)
+		}
+
+		fmt.Printf("DB schema version:        %d\n", version)
+
+	case "use":
+		if len(os.Args) != 3 || os.Args[2] == "" {
+			return usageExit()
+		}
+
+		if os.Args[2] == "--show" {
+			dbPath, err := getDBPath()
+			if err != nil {
+				return errExit("Error getting DB path - %v", err)
+			}
+
+			fmt.Println(dbPath)
+			break
+		}
+
+		dbPath, err := filepath.Abs(os.Args[2])
+		if err != nil {
+			return errExit("Error resolving path %s - %v", os.Args[2], err)
+		}
+
+		err = checkDBFile(dbPath, getOptions())
+		if err != nil {
+			return errExit("Error opening DB %s - %v", dbPath, err)
+		}
+
+		err = setUserConfig("db", dbPath)
+		if err != nil {
+			return errExit("Error writing config file - %v", err)
+		}
+
+		printNotice("Using DB at %s", dbPath)
+
+	case "help":
+		return usageExit()
+
+	default:
+		return usageExit()
+	}
+
+	return 0
+}
+
+func main() {
+	cml.SetLogger(stderrLogger{})
 
-func main() {
 	ret := run()
 
 	if initialized {
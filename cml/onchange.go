@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+
+	cml "github.com/debevv/camellia"
+)
+
+/*
+onChangeGlob returns the normalized glob, and the path of the hierarchy holding all the paths matching it, to read the
+changes of. Segments are matched like path.Match does, so * and ? never match a /
+*/
+func onChangeGlob(glob string) (string, string, error) {
+	glob = strings.Trim(glob, "/")
+
+	_, err := path.Match(glob, "")
+	if err != nil {
+		return "", "", fmt.Errorf("invalid glob %s - %w", glob, err)
+	}
+
+	segments := []string{}
+	for _, s := range strings.Split(glob, "/") {
+		if strings.ContainsAny(s, `*?[\`) {
+			break
+		}
+
+		segments = append(segments, s)
+	}
+
+	// A glob without wildcards matches only the path itself
+	if len(segments) == len(strings.Split(glob, "/")) {
+		return glob, glob, nil
+	}
+
+	return glob, strings.Join(segments, "/"), nil
+}
+
+/*
+runOnChange runs command for the change e, passing it the change in the CAMELLIA_CHANGE, CAMELLIA_PATH,
+CAMELLIA_VALUE, CAMELLIA_OLD_VALUE, CAMELLIA_IS_VALUE and CAMELLIA_REVISION env variables, with the standard streams of
+cml, and waits for it to exit
+*/
+func runOnChange(command []string, e cml.Event) error {
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"CAMELLIA_CHANGE="+e.Type.String(),
+		"CAMELLIA_PATH="+e.Path,
+		"CAMELLIA_VALUE="+e.Value,
+		"CAMELLIA_OLD_VALUE="+e.OldValue,
+		"CAMELLIA_IS_VALUE="+strconv.FormatBool(e.IsValue),
+		"CAMELLIA_REVISION="+strconv.FormatUint(e.Revision, 10))
+
+	return cmd.Run()
+}
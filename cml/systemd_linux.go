@@ -0,0 +1,112 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	cml "github.com/debevv/camellia"
+)
+
+/*
+listenFDsStart is the first file descriptor passed by systemd with socket activation
+*/
+const listenFDsStart = 3
+
+/*
+systemdListeners returns the listeners passed by systemd with socket activation, grouped by their name (set with
+FileDescriptorName= in the socket unit, the name of the unit by default), or nil if the process was not socket
+activated
+*/
+func systemdListeners() (map[string][]net.Listener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid LISTEN_FDS - %w", err)
+	}
+
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	listeners := make(map[string][]net.Listener)
+	for i := 0; i < count; i++ {
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+
+		file := os.NewFile(uintptr(listenFDsStart+i), name)
+		l, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error using socket %d passed by systemd - %w", listenFDsStart+i, err)
+		}
+
+		listeners[name] = append(listeners[name], l)
+	}
+
+	return listeners, nil
+}
+
+/*
+sdNotify sends state to the service manager, if the process was started by systemd with a notification socket (see
+sd_notify(3))
+*/
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+
+	conn, err := net.Dial("unixgram", socket)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+/*
+startWatchdog pings the systemd watchdog of the service, if enabled with WatchdogSec=, at half its interval. Pings are
+skipped while cml.Health reports the DB as unhealthy, so that systemd restarts a server stuck on its DB
+*/
+func startWatchdog() {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return
+	}
+
+	pid := os.Getenv("WATCHDOG_PID")
+	if pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(usec) * time.Microsecond / 2)
+		for range ticker.C {
+			_, err := cml.Health()
+			if err != nil {
+				printStderrLn("Skipping watchdog ping, DB is unhealthy - %v", err)
+				continue
+			}
+
+			sdNotify("WATCHDOG=1")
+		}
+	}()
+}
@@ -0,0 +1,25 @@
+//go:build !linux
+
+package main
+
+import "net"
+
+/*
+systemdListeners returns nil, since socket activation by systemd is available only on Linux
+*/
+func systemdListeners() (map[string][]net.Listener, error) {
+	return nil, nil
+}
+
+/*
+sdNotify does nothing, since systemd is available only on Linux
+*/
+func sdNotify(state string) error {
+	return nil
+}
+
+/*
+startWatchdog does nothing, since the systemd watchdog is available only on Linux
+*/
+func startWatchdog() {
+}
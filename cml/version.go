@@ -0,0 +1,22 @@
+package main
+
+import "runtime/debug"
+
+/*
+cliVersion returns the version cml was built with, followed by the VCS revision, when recorded by the Go toolchain
+*/
+func cliVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+
+	version := info.Main.Version
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" {
+			version += " (" + s.Value + ")"
+		}
+	}
+
+	return version
+}
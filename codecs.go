@@ -0,0 +1,240 @@
+package camellia
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+type codec struct {
+	encode func(value any) (string, error)
+	decode func(s string) (any, error)
+}
+
+var codecs = map[reflect.Type]codec{}
+var codecsMutex sync.RWMutex
+
+var customStringableType = reflect.TypeOf((*CustomStringable)(nil)).Elem()
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+/*
+Built-in codecs: time.Time values are represented in the RFC3339 format (with nanoseconds, if any), time.Duration
+values as Go duration strings (like "1h30m" or "250ms")
+*/
+func init() {
+	RegisterCodec(func(value time.Time) (string, error) {
+		return value.Format(time.RFC3339Nano), nil
+	}, func(s string) (time.Time, error) {
+		return time.Parse(time.RFC3339Nano, s)
+	})
+
+	RegisterCodec(func(value time.Duration) (string, error) {
+		return value.String(), nil
+	}, time.ParseDuration)
+}
+
+/*
+RegisterCodec registers the functions used to convert values of type T to and from their string representation.
+
+Registered codecs take precedence over any other conversion method, including the built-in ones for base types.
+*/
+func RegisterCodec[T any](encode func(value T) (string, error), decode func(s string) (T, error)) {
+	codecsMutex.Lock()
+	defer codecsMutex.Unlock()
+
+	codecs[reflect.TypeOf((*T)(nil)).Elem()] = codec{
+		encode: func(value any) (string, error) {
+			return encode(value.(T))
+		},
+		decode: func(s string) (any, error) {
+			return decode(s)
+		},
+	}
+}
+
+func lookupCodec(t reflect.Type) (codec, bool) {
+	codecsMutex.RLock()
+	defer codecsMutex.RUnlock()
+
+	c, ok := codecs[t]
+	return c, ok
+}
+
+/*
+isScalarType returns whether values of type t are converted to a single string value, in contrast with structs and
+slices, which are mapped to hierarchies and lists
+*/
+func isScalarType(t reflect.Type) bool {
+	_, ok := lookupCodec(t)
+	if ok {
+		return true
+	}
+
+	if t.Implements(customStringableType) || t.Implements(textUnmarshalerType) {
+		return true
+	}
+
+	pt := reflect.PointerTo(t)
+	if pt.Implements(customStringableType) || pt.Implements(textUnmarshalerType) {
+		return true
+	}
+
+	return isBaseKind(t.Kind())
+}
+
+func encodeValue[T any](value T) (string, error) {
+	return encodeReflectValue(reflect.ValueOf(&value).Elem())
+}
+
+/*
+valueTypeOf returns the type tag stored along with values of type T
+*/
+func valueTypeOf[T any]() ValueType {
+	return reflectValueType(reflect.TypeOf((*T)(nil)).Elem())
+}
+
+/*
+reflectValueType returns the type tag stored along with values of type t: types converted by codecs or interfaces are
+tagged as strings, base types after their kind
+*/
+func reflectValueType(t reflect.Type) ValueType {
+	_, ok := lookupCodec(t)
+	if ok {
+		return TypeString
+	}
+
+	if t.Kind() == reflect.Pointer && (t.Implements(customStringableType) || t.Implements(textMarshalerType)) {
+		return TypeString
+	}
+
+	pt := reflect.PointerTo(t)
+	if pt.Implements(customStringableType) || pt.Implements(textMarshalerType) {
+		return TypeString
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return TypeBool
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return TypeInt
+	case reflect.Float32, reflect.Float64:
+		return TypeFloat
+	default:
+		return TypeString
+	}
+}
+
+/*
+checkValueType verifies, in strict mode, that a value stored with the stored type tag can be read as the requested
+type. Untyped values and ints read as floats are always accepted.
+*/
+func checkValueType(stored ValueType, requested ValueType) error {
+	if !dbOptions.StrictTypes || stored == TypeUntyped || stored == requested {
+		return nil
+	}
+
+	if stored == TypeInt && requested == TypeFloat {
+		return nil
+	}
+
+	return fmt.Errorf("value is of type %s, requested %s - %w", stored, requested, ErrTypeMismatch)
+}
+
+func decodeValue[T any](s string) (T, error) {
+	var value T
+
+	err := decodeReflectValue(reflect.ValueOf(&value).Elem(), s)
+	return value, err
+}
+
+/*
+encodeReflectValue converts value to its string representation, using, in order of precedence:
+a registered codec, the CustomStringable interface, the encoding.TextMarshaler interface, or fmt.Sprint for base types
+*/
+func encodeReflectValue(value reflect.Value) (string, error) {
+	t := value.Type()
+
+	c, ok := lookupCodec(t)
+	if ok {
+		return c.encode(value.Interface())
+	}
+
+	if t.Kind() == reflect.Pointer && !value.IsNil() && (t.Implements(customStringableType) ||
+		t.Implements(textMarshalerType)) {
+		value = value.Elem()
+		t = value.Type()
+	}
+
+	if !value.CanAddr() {
+		addressable := reflect.New(t).Elem()
+		addressable.Set(value)
+		value = addressable
+	}
+
+	pt := reflect.PointerTo(t)
+	if pt.Implements(customStringableType) {
+		return value.Addr().Interface().(CustomStringable).String(), nil
+	}
+
+	if pt.Implements(textMarshalerType) {
+		text, err := value.Addr().Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return "", err
+		}
+
+		return string(text), nil
+	}
+
+	if isBaseKind(t.Kind()) {
+		return fmt.Sprint(value.Interface()), nil
+	}
+
+	return "", fmt.Errorf("%w %s", ErrUnsupportedType, t)
+}
+
+/*
+decodeReflectValue converts s to the type of value, storing it in value, which must be addressable. See
+encodeReflectValue for the conversion methods used
+*/
+func decodeReflectValue(value reflect.Value, s string) error {
+	t := value.Type()
+
+	c, ok := lookupCodec(t)
+	if ok {
+		decoded, err := c.decode(s)
+		if err != nil {
+			return err
+		}
+
+		value.Set(reflect.ValueOf(decoded))
+		return nil
+	}
+
+	if t.Kind() == reflect.Pointer && (t.Implements(customStringableType) || t.Implements(textUnmarshalerType)) {
+		if value.IsNil() {
+			value.Set(reflect.New(t.Elem()))
+		}
+
+		value = value.Elem()
+		t = value.Type()
+	}
+
+	pt := reflect.PointerTo(t)
+	if pt.Implements(customStringableType) {
+		return value.Addr().Interface().(CustomStringable).FromString(s)
+	}
+
+	if pt.Implements(textUnmarshalerType) {
+		return value.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+
+	if isBaseKind(t.Kind()) {
+		return setBaseValue(value, s)
+	}
+
+	return fmt.Errorf("%w %s", ErrUnsupportedType, t)
+}
@@ -0,0 +1,172 @@
+package camellia
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+const metaConfigVersion = "config_version"
+
+type configMigration struct {
+	from      uint64
+	to        uint64
+	migration func(tx *Tx) error
+}
+
+var configMigrations []configMigration
+var configMigrationsMutex sync.Mutex
+
+/*
+RegisterConfigMigration registers a user-defined migration of the configuration from version fromVer to version toVer
+(with toVer > fromVer).
+
+The configuration version is stored in the DB, and is independent of the internal DB schema version. Migrations are
+applied by MigrateConfig. Migrations can be registered before opening the DB.
+*/
+func RegisterConfigMigration(fromVer uint64, toVer uint64, migration func(tx *Tx) error) error {
+	if toVer <= fromVer {
+		return fmt.Errorf("invalid config migration from version %d to version %d", fromVer, toVer)
+	}
+
+	configMigrationsMutex.Lock()
+	defer configMigrationsMutex.Unlock()
+
+	configMigrations = append(configMigrations, configMigration{from: fromVer, to: toVer, migration: migration})
+
+	return nil
+}
+
+/*
+GetConfigVersion returns the configuration version stored in the DB (0 if it was never set).
+*/
+func GetConfigVersion() (uint64, error) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return 0, ErrNoDB
+	}
+
+	tx, err := beginReadTx(context.Background())
+	if err != nil {
+		return 0, fmt.Errorf("error beginning transaction - %w", err)
+	}
+
+	version, err := getConfigVersion(tx)
+	if err != nil {
+		rollbackTx(tx)
+		return 0, err
+	}
+
+	err = endReadTx(context.Background(), tx)
+	if err != nil {
+		rollbackTx(tx)
+		return 0, fmt.Errorf("error committing transaction - %w", err)
+	}
+
+	return version, nil
+}
+
+/*
+SetConfigVersion stores the configuration version in the DB, without applying any migration (for example, after
+provisioning a new DB with a configuration already at the latest version).
+*/
+func SetConfigVersion(version uint64) error {
+	return Update(func(tx *Tx) error {
+		return setConfigVersion(version, tx.tx)
+	})
+}
+
+/*
+MigrateConfig applies the registered config migrations needed to bring the configuration from its current version to
+targetVer, all inside a single transaction. At each step, the migration starting from the current version and reaching
+the highest version not greater than targetVer is chosen.
+
+Returns the list of versions the configuration went through (empty if it was already at targetVer), or
+ErrConfigMigrationNotFound if no chain of migrations reaches targetVer.
+*/
+func MigrateConfig(targetVer uint64) ([]uint64, error) {
+	configMigrationsMutex.Lock()
+	migrations := append([]configMigration{}, configMigrations...)
+	configMigrationsMutex.Unlock()
+
+	steps := []uint64{}
+
+	err := Update(func(tx *Tx) error {
+		version, err := getConfigVersion(tx.tx)
+		if err != nil {
+			return err
+		}
+
+		if version > targetVer {
+			return fmt.Errorf("config version %d is newer than %d - %w", version, targetVer,
+				ErrConfigMigrationNotFound)
+		}
+
+		for version < targetVer {
+			var next *configMigration
+			for i, m := range migrations {
+				if m.from == version && m.to <= targetVer && (next == nil || m.to > next.to) {
+					next = &migrations[i]
+				}
+			}
+
+			if next == nil {
+				return fmt.Errorf("no config migration from version %d - %w", version, ErrConfigMigrationNotFound)
+			}
+
+			err = next.migration(tx)
+			if err != nil {
+				return fmt.Errorf("error migrating config from version %d to version %d - %w", next.from, next.to,
+					err)
+			}
+
+			version = next.to
+			steps = append(steps, version)
+		}
+
+		if len(steps) == 0 {
+			return nil
+		}
+
+		return setConfigVersion(version, tx.tx)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return steps, nil
+}
+
+func getConfigVersion(tx *sql.Tx) (uint64, error) {
+	value, err := getMeta(metaConfigVersion, tx)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, nil
+		}
+
+		return 0, fmt.Errorf("error getting config version - %w", err)
+	}
+
+	version, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid config version %s - %w", value, err)
+	}
+
+	return version, nil
+}
+
+func setConfigVersion(version uint64, tx *sql.Tx) error {
+	err := setMeta(metaConfigVersion, strconv.FormatUint(version, 10), tx)
+	if err != nil {
+		return fmt.Errorf("error setting config version - %w", err)
+	}
+
+	return nil
+}
@@ -3,18 +3,21 @@ package camellia
 import (
 	"context"
 	"database/sql"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"strconv"
 	"strings"
 	"time"
-
-	_ "github.com/mattn/go-sqlite3"
 )
 
 const (
-	dbVersion = uint64(1)
-	table     = "camellia"
+	dbVersion         = uint64(2)
+	table             = "camellia"
+	chunksTable       = "camellia_chunks"
+	deprecationsTable = "camellia_deprecations"
+	metaTable         = "camellia_meta"
+	eventsTable       = "camellia_events"
 )
 
 const (
@@ -22,11 +25,27 @@ const (
 	colLastUpdateMs = "last_update_ms"
 	colIsValue      = "is_value"
 	colParent       = "parent"
+	colID           = "id"
+	colParentID     = "parent_id"
 	colValue        = "value"
+	colValueType    = "value_type"
+	colBlobValue    = "blob_value"
+	colSeq          = "seq"
+	colData         = "data"
+	colChecksum     = "checksum"
+	colReplacement  = "replacement"
+	colKey          = "key"
+	colWriter       = "writer"
+	colRevision     = "revision"
+	colChangeType   = "change_type"
+	colOldValue     = "old_value"
 )
 
 var db *sql.DB
+var writeConn *sql.Conn
 var dbPath = ""
+var dbOptions Options
+var migrationBackupPath = ""
 var stmts map[string]*sql.Stmt
 
 func newEntry() *Entry {
@@ -49,27 +68,41 @@ func pragma(pragma string) (string, error) {
 func joinPath(p []string) string {
 	split := []string{}
 	for _, part := range p {
-		split = append(split, strings.Trim(part, "/"))
+		split = append(split, trimSeparators(part))
 	}
 
 	return strings.Join(split, "/")
 }
 
+/*
+splitPath splits a path in its non-empty segments, at the separators not escaped (see EscapeSegment). The segments
+are returned escaped
+*/
 func splitPath(p string) []string {
-	split := strings.Split(p, "/")
 	normalized := []string{}
-	for _, s := range split {
-		if s != "" {
-			normalized = append(normalized, s)
+	start := 0
+	for i := 0; i < len(p); i++ {
+		switch p[i] {
+		case '\\':
+			i++
+		case '/':
+			if i > start {
+				normalized = append(normalized, p[start:i])
+			}
+
+			start = i + 1
 		}
 	}
 
+	if start < len(p) {
+		normalized = append(normalized, p[start:])
+	}
+
 	return normalized
 }
 
 func normalizePath(p string) string {
-	//return joinPath(splitPath(p))
-	return joinPath(splitPath(p))
+	return joinPath(normalizeSegments(splitPath(p)))
 }
 
 func parentPath(p string) string {
@@ -92,57 +125,157 @@ func namePath(p string) string {
 	}
 }
 
-func openDB(path string) (bool, error) {
+func openDB(path string, options Options, allowMigration bool) (bool, bool, error) {
 	var err error
 	if path == "" {
-		return false, fmt.Errorf("DB path is empty")
+		return false, false, fmt.Errorf("DB path is empty")
 	}
 
 	created := false
+	migrated := false
+
+	chars, err := options.PathRules.compile()
+	if err != nil {
+		return false, false, fmt.Errorf("invalid path rules - %w", err)
+	}
+
+	defaultsPath = ""
+	defaults, err := checkDefaults(options)
+	if err != nil {
+		return false, false, err
+	}
+
+	dbKey = options.EncryptionKey
+
+	dataSource, err := dsn(path, options)
+	if err != nil {
+		return false, false, err
+	}
+
+	db, err = sql.Open(driverName, dataSource)
+	if err != nil {
+		return false, false, fmt.Errorf("error opening DB - %v", err)
+	}
+
+	if dbKey != "" {
+		err = checkEncryption()
+		if err != nil {
+			db.Close()
+			return false, false, err
+		}
+	}
 
-	db, err = sql.Open("sqlite3", path)
+	err = configurePool(options)
 	if err != nil {
-		return false, fmt.Errorf("error opening DB - %v", err)
+		db.Close()
+		return false, false, fmt.Errorf("error configuring DB connections - %w", wrapKeyError(err))
 	}
 
-	currentDBVersion, err := getDBVersion()
+	currentDBVersion, err := getDBVersion(db)
 	if err != nil {
 		db.Close()
-		return false, fmt.Errorf("error getting current DB version - %w", err)
+		return false, false, fmt.Errorf("error getting current DB version - %w", wrapKeyError(err))
 	}
 
 	if currentDBVersion == 0 {
 		// DB file is new
-		_, err = migrate()
+		_, err = migrate(db)
 		if err != nil {
 			db.Close()
-			return false, fmt.Errorf("error initializing DB - %w", err)
+			return false, false, fmt.Errorf("error initializing DB - %w", err)
 		}
 
+		logInfo("created DB", "path", path, "version", dbVersion)
 		created = true
 	} else if dbVersion != currentDBVersion {
-		db.Close()
-		return false, ErrDBVersionMismatch
+		if !allowMigration || currentDBVersion > dbVersion {
+			db.Close()
+			return false, false, ErrDBVersionMismatch
+		}
+
+		migrationBackupPath = ""
+		if !options.NoMigrationBackup {
+			migrationBackupPath, err = backupDB(path, currentDBVersion)
+			if err != nil {
+				db.Close()
+				return false, false, fmt.Errorf("error backing up DB before migration - %w", err)
+			}
+		}
+
+		migrated, err = migrate(db)
+		if err != nil {
+			db.Close()
+			logError("DB migration failed", "path", path, "from", currentDBVersion, "to", dbVersion, "error", err)
+			return false, false, fmt.Errorf("error migrating DB - %w", err)
+		}
+
+		logInfo("migrated DB", "path", path, "from", currentDBVersion, "to", dbVersion, "backup", migrationBackupPath)
+	}
+
+	if defaults != "" {
+		err = openDefaults(defaults, dataSource, options)
+		if err != nil {
+			db.Close()
+			return false, false, fmt.Errorf("error opening defaults - %w", err)
+		}
 	}
 
 	err = prepareStaments()
 	if err != nil {
 		db.Close()
-		return false, fmt.Errorf("error creating prepared statements - %w", err)
+		return false, false, fmt.Errorf("error creating prepared statements - %w", err)
+	}
+
+	err = loadDeprecations()
+	if err != nil {
+		db.Close()
+		return false, false, fmt.Errorf("error loading deprecations - %w", err)
+	}
+
+	writeConn, err = db.Conn(context.Background())
+	if err != nil {
+		db.Close()
+		return false, false, fmt.Errorf("error opening writer connection - %w", err)
 	}
 
 	dbPath = path
+	dbOptions = options
+	pathRules = options.PathRules
+	pathChars = chars
 
-	return created, nil
+	logDebug("opened DB", "path", path, "version", dbVersion)
+
+	return created, migrated, nil
+}
+
+/*
+backupDB copies the DB to a file next to path, named after the schema version and the current time, returning the
+path of the copy
+*/
+func backupDB(path string, version uint64) (string, error) {
+	backupPath := fmt.Sprintf("%s.v%d-%s.bak", path, version, time.Now().UTC().Format("20060102T150405Z"))
+
+	_, err := db.Exec("VACUUM INTO ?", backupPath)
+	if err != nil {
+		return "", err
+	}
+
+	return backupPath, nil
 }
 
 func closeDB() error {
+	writeConn.Close()
+
 	err := db.Close()
 	if err != nil {
 		return err
 	}
 
 	dbPath = ""
+	dbKey = ""
+	defaultsPath = ""
+	pathRules = PathRules{}
+	pathChars = nil
 
 	return nil
 }
@@ -151,17 +284,21 @@ func prepareStaments() error {
 	var err error
 	stmts = make(map[string]*sql.Stmt)
 
+	// With mounts, Entries and chunks are accessed through views over all the DB files (see Mount)
+	entries, chunks := entriesSource(), chunksSource()
+
 	stmts["getValue"], err = db.Prepare(fmt.Sprintf(
-		"SELECT %s, %s FROM %s WHERE %s = ?",
-		colIsValue, colValue, table, colPath))
+		"SELECT %s, %s, %s, %s, %s FROM %s WHERE %s = ?",
+		colIsValue, colValue, colValueType, colBlobValue, colChecksum, entries, colPath))
 
 	if err != nil {
 		return err
 	}
 
 	stmts["getEntry"], err = db.Prepare(fmt.Sprintf(
-		"SELECT %s, %s, %s, %s FROM %s WHERE %s = ?",
-		colPath, colLastUpdateMs, colIsValue, colValue, table, colPath))
+		"SELECT %s, %s, %s, %s, %s, %s, %s, %s, %s FROM %s WHERE %s = ?",
+		colPath, colLastUpdateMs, colIsValue, colValue, colValueType, colBlobValue, colChecksum, colWriter,
+		colRevision, entries, colPath))
 
 	if err != nil {
 		return err
@@ -169,61 +306,143 @@ func prepareStaments() error {
 
 	stmts["getIsValue"], err = db.Prepare(fmt.Sprintf(
 		"SELECT %s FROM %s WHERE %s = ?",
-		colIsValue, table, colPath))
+		colIsValue, entries, colPath))
+
+	if err != nil {
+		return err
+	}
 
+	// Writes are routed by path to the open DB or to the mounted files, which have their own statements
+	err = prepareWriteStatements("", table, chunksTable)
 	if err != nil {
 		return err
 	}
 
-	stmts["updateValue"], err = db.Prepare(fmt.Sprintf(
-		"UPDATE %s SET %s = ?, %s = ? WHERE %s = ?",
-		table, colLastUpdateMs, colValue, colPath))
+	for i, m := range mounts {
+		err = prepareWriteStatements(mountStmtSuffix(i), mountSchemaName(i)+"."+table,
+			mountSchemaName(i)+"."+chunksTable)
+		if err != nil {
+			return fmt.Errorf("error preparing statements of %s - %w", m.path, err)
+		}
+	}
+
+	if defaultsPath != "" {
+		err = prepareOverlayStatements()
+		if err != nil {
+			return err
+		}
+	}
+
+	children := fmt.Sprintf("%s = (SELECT %s FROM %s WHERE %s = ?)", colParentID, colID, entries, colPath)
+	if len(mounts) > 0 {
+		children = fmt.Sprintf("(%s, %s) = (SELECT %s, %s FROM %s WHERE %s = ?)",
+			colParentFile, colParentID, colFile, colID, entries, colPath)
+	}
+
+	childrenQuery := fmt.Sprintf(
+		"SELECT %s, %s, %s, %s, %s, %s, %s, %s, %s FROM %s WHERE %s ORDER BY %s",
+		colPath, colLastUpdateMs, colIsValue, colValue, colValueType, colBlobValue, colChecksum, colWriter,
+		colRevision, entries, children, colPath)
+
+	if defaultsPath != "" {
+		childrenQuery = overlayChildrenQuery()
+	}
+
+	stmts["getChildren"], err = db.Prepare(childrenQuery)
 
 	if err != nil {
 		return err
 	}
 
-	stmts["updateLastUpdate"], err = db.Prepare(fmt.Sprintf(
-		"UPDATE %s SET %s = ? WHERE %s = ?",
-		table, colLastUpdateMs, colPath))
+	// Walks the subtree level by level through parent_index, up to the depth in the second parameter (all the levels
+	// if negative), skipping the values if the third one is true
+	subtree := fmt.Sprintf(
+		`WITH RECURSIVE subtree (id, depth) AS (
+			SELECT %[1]s, 0 FROM %[2]s WHERE %[3]s = ?1
+			UNION ALL
+			SELECT e.%[1]s, s.depth + 1 FROM %[2]s e JOIN subtree s ON e.%[4]s = s.id WHERE ?2 < 0 OR s.depth < ?2
+		)
+		SELECT %[5]s, p.%[3]s, s.depth
+		FROM subtree s JOIN %[2]s e ON e.%[1]s = s.id LEFT JOIN %[2]s p ON p.%[1]s = e.%[4]s
+		ORDER BY s.depth, e.%[3]s`,
+		colID, entries, colPath, colParentID, subtreeColumns())
+
+	if len(mounts) > 0 {
+		subtree = mountedSubtreeQuery()
+	} else if defaultsPath != "" {
+		subtree = overlaySubtreeQuery()
+	}
+
+	stmts["getSubtree"], err = db.Prepare(subtree)
 
 	if err != nil {
 		return err
 	}
 
-	stmts["insertValueEntry"], err = db.Prepare(fmt.Sprintf(
-		"INSERT INTO %s (%s, %s, %s, %s, %s) VALUES (?, ?, 1, ?, ?)",
-		table, colPath, colLastUpdateMs, colIsValue, colParent, colValue))
+	stmts["getChunk"], err = db.Prepare(fmt.Sprintf(
+		"SELECT %s, %s FROM %s WHERE %s = ? AND %s = ?",
+		colData, colChecksum, chunks, colPath, colSeq))
 
 	if err != nil {
 		return err
 	}
 
-	stmts["insertNonValueEntry"], err = db.Prepare(fmt.Sprintf(
-		"INSERT INTO %s (%s, %s, %s, %s) VALUES (?, ?, 0, ?)",
-		table, colPath, colLastUpdateMs, colIsValue, colParent))
+	stmts["setDeprecation"], err = db.Prepare(fmt.Sprintf(
+		"INSERT OR REPLACE INTO %s (%s, %s) VALUES (?, ?)",
+		deprecationsTable, colPath, colReplacement))
 
 	if err != nil {
 		return err
 	}
 
-	stmts["getChildren"], err = db.Prepare(fmt.Sprintf(
-		"SELECT %s, %s, %s, %s FROM %s WHERE %s = ?",
-		colPath, colLastUpdateMs, colIsValue, colValue, table, colParent))
+	stmts["deleteDeprecation"], err = db.Prepare(fmt.Sprintf(
+		"DELETE FROM %s WHERE %s = ?",
+		deprecationsTable, colPath))
 
 	if err != nil {
 		return err
 	}
 
-	stmts["getChildrenPaths"], err = db.Prepare(fmt.Sprintf(
+	stmts["getMeta"], err = db.Prepare(fmt.Sprintf(
 		"SELECT %s FROM %s WHERE %s = ?",
-		colPath, table, colParent))
+		colValue, metaTable, colKey))
 
 	if err != nil {
 		return err
 	}
 
-	stmts["deleteEntry"], err = db.Prepare(fmt.Sprintf("DELETE FROM %s WHERE %s = ?", table, colPath))
+	stmts["setMeta"], err = db.Prepare(fmt.Sprintf(
+		"INSERT OR REPLACE INTO %s (%s, %s) VALUES (?, ?)",
+		metaTable, colKey, colValue))
+
+	if err != nil {
+		return err
+	}
+
+	stmts["insertEvent"], err = db.Prepare(fmt.Sprintf(
+		"INSERT INTO %s (%s, %s, %s, %s, %s, %s) VALUES (?, ?, ?, ?, ?, ?)",
+		eventsTable, colRevision, colChangeType, colPath, colIsValue, colOldValue, colValue))
+
+	if err != nil {
+		return err
+	}
+
+	stmts["getEvents"], err = db.Prepare(fmt.Sprintf(
+		"SELECT %s, %s, %s, %s, %s, %s FROM %s WHERE %s > ? ORDER BY %s, rowid",
+		colRevision, colChangeType, colPath, colIsValue, colOldValue, colValue, eventsTable, colRevision,
+		colRevision))
+
+	if err != nil {
+		return err
+	}
+
+	stmts["getOldestRevision"], err = db.Prepare(fmt.Sprintf("SELECT MIN(%s) FROM %s", colRevision, eventsTable))
+
+	if err != nil {
+		return err
+	}
+
+	stmts["deleteEvents"], err = db.Prepare(fmt.Sprintf("DELETE FROM %s WHERE %s <= ?", eventsTable, colRevision))
 
 	if err != nil {
 		return err
@@ -232,8 +451,89 @@ func prepareStaments() error {
 	return nil
 }
 
-func getDBVersion() (uint64, error) {
-	dbVersionStr, err := pragma("PRAGMA user_version")
+/*
+prepareWriteStatements prepares the statements writing the Entries and the chunks in the tables entries and chunks,
+appending suffix to their names (see pathStmt)
+*/
+func prepareWriteStatements(suffix string, entries string, chunks string) error {
+	var err error
+
+	stmts["updateValue"+suffix], err = db.Prepare(fmt.Sprintf(
+		"UPDATE %s SET %s = ?, %s = ?, %s = ?, %s = ?, %s = ?, %s = ? WHERE %s = ?",
+		entries, colLastUpdateMs, colValue, colValueType, colBlobValue, colChecksum, colWriter, colPath))
+
+	if err != nil {
+		return err
+	}
+
+	stmts["setEntryRevision"+suffix], err = db.Prepare(fmt.Sprintf(
+		"UPDATE %s SET %s = ? WHERE %s = ?",
+		entries, colRevision, colPath))
+
+	if err != nil {
+		return err
+	}
+
+	stmts["updateLastUpdate"+suffix], err = db.Prepare(fmt.Sprintf(
+		"UPDATE %s SET %s = ? WHERE %s = ?",
+		entries, colLastUpdateMs, colPath))
+
+	if err != nil {
+		return err
+	}
+
+	// Entries are inserted with the path of their parent, resolved to its ID
+	parentID := fmt.Sprintf("(SELECT %s FROM %s WHERE %s = ?)", colID, entries, colPath)
+
+	stmts["insertValueEntry"+suffix], err = db.Prepare(fmt.Sprintf(
+		"INSERT INTO %s (%s, %s, %s, %s, %s, %s, %s, %s, %s) VALUES (?, ?, 1, %s, ?, ?, ?, ?, ?)",
+		entries, colPath, colLastUpdateMs, colIsValue, colParentID, colValue, colValueType, colBlobValue, colChecksum,
+		colWriter, parentID))
+
+	if err != nil {
+		return err
+	}
+
+	stmts["insertNonValueEntry"+suffix], err = db.Prepare(fmt.Sprintf(
+		"INSERT INTO %s (%s, %s, %s, %s, %s) VALUES (?, ?, 0, %s, ?)",
+		entries, colPath, colLastUpdateMs, colIsValue, colParentID, colWriter, parentID))
+
+	if err != nil {
+		return err
+	}
+
+	// The children are deleted by the cascade on parent_id
+	stmts["deleteEntry"+suffix], err = db.Prepare(fmt.Sprintf("DELETE FROM %s WHERE %s = ?", entries, colPath))
+
+	if err != nil {
+		return err
+	}
+
+	stmts["insertChunk"+suffix], err = db.Prepare(fmt.Sprintf(
+		"INSERT INTO %s (%s, %s, %s, %s) VALUES (?, ?, ?, ?)",
+		chunks, colPath, colSeq, colData, colChecksum))
+
+	if err != nil {
+		return err
+	}
+
+	stmts["deleteChunks"+suffix], err = db.Prepare(fmt.Sprintf("DELETE FROM %s WHERE %s = ?", chunks, colPath))
+
+	if err != nil {
+		return err
+	}
+
+	// Paths under ?1 sort between "?1/" and "?1" followed by the character after '/'
+	stmts["deleteSubtreeChunks"+suffix], err = db.Prepare(fmt.Sprintf(
+		"DELETE FROM %[1]s WHERE %[2]s = ?1 OR (%[2]s >= ?1 || '/' AND %[2]s < ?1 || '0')",
+		chunks, colPath))
+
+	return err
+}
+
+func getDBVersion(d *sql.DB) (uint64, error) {
+	var dbVersionStr string
+	err := d.QueryRow("PRAGMA user_version").Scan(&dbVersionStr)
 	if err != nil {
 		return 0, err
 	}
@@ -246,13 +546,13 @@ func getDBVersion() (uint64, error) {
 	return version, nil
 }
 
-func migrate() (bool, error) {
-	version, err := getDBVersion()
+func migrate(d *sql.DB) (bool, error) {
+	version, err := getDBVersion(d)
 	if err != nil {
 		return false, fmt.Errorf("error getting current DB version - %w", err)
 	}
 
-	tx, err := db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelSerializable})
+	tx, err := d.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelSerializable})
 	if err != nil {
 		return false, err
 	}
@@ -278,7 +578,7 @@ func migrate() (bool, error) {
 			colPath))
 
 		if err != nil {
-			tx.Rollback()
+			rollbackTx(tx)
 			return false, err
 		}
 
@@ -289,7 +589,7 @@ func migrate() (bool, error) {
 			colPath))
 
 		if err != nil {
-			tx.Rollback()
+			rollbackTx(tx)
 			return false, err
 		}
 
@@ -300,7 +600,7 @@ func migrate() (bool, error) {
 			colParent))
 
 		if err != nil {
-			tx.Rollback()
+			rollbackTx(tx)
 			return false, err
 		}
 
@@ -310,16 +610,32 @@ func migrate() (bool, error) {
 			"", time.Now().UnixMilli(), 0, sql.NullString{}, "")
 
 		if err != nil {
-			tx.Rollback()
+			rollbackTx(tx)
+			return false, err
+		}
+
+		version = 1
+	}
+
+	if version < 2 {
+		err := migrateEntries(tx)
+		if err != nil {
+			rollbackTx(tx)
+			return false, err
+		}
+
+		err = createTables(tx)
+		if err != nil {
+			rollbackTx(tx)
 			return false, err
 		}
 
 		migrated = true
 	}
 
-	_, err = tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", 1))
+	_, err = tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", dbVersion))
 	if err != nil {
-		tx.Rollback()
+		rollbackTx(tx)
 		return false, err
 	}
 
@@ -331,15 +647,197 @@ func migrate() (bool, error) {
 	return migrated, nil
 }
 
-func setValue(path, value string, tx *sql.Tx, force bool, skipHooks bool) error {
+/*
+migrateEntries rebuilds the version 1 Entries table with integer IDs, referencing the parent of each Entry by ID, with
+cascading deletes, instead of by path, and with the columns of the version 2 Entries
+*/
+func migrateEntries(tx *sql.Tx) error {
+	newTable := table + "_v2"
+
+	_, err := tx.Exec(fmt.Sprintf(
+		`CREATE TABLE %[1]s (
+			%[2]s INTEGER PRIMARY KEY,
+			%[3]s TEXT NOT NULL UNIQUE,
+			%[4]s INTEGER NOT NULL,
+			%[5]s BIT DEFAULT 0,
+			%[6]s INTEGER REFERENCES %[1]s (%[2]s) ON DELETE CASCADE,
+			%[7]s TEXT DEFAULT '',
+			%[8]s TEXT DEFAULT '',
+			%[9]s BLOB DEFAULT NULL,
+			%[10]s INTEGER DEFAULT NULL,
+			%[11]s TEXT DEFAULT '',
+			%[12]s INTEGER DEFAULT 0
+		)`,
+		newTable, colID, colPath, colLastUpdateMs, colIsValue, colParentID, colValue, colValueType, colBlobValue,
+		colChecksum, colWriter, colRevision))
+
+	if err != nil {
+		return err
+	}
+
+	columns := strings.Join([]string{colPath, colLastUpdateMs, colIsValue, colValue}, ", ")
+
+	_, err = tx.Exec(fmt.Sprintf(
+		"INSERT INTO %s (%s) SELECT %s FROM %s ORDER BY %s",
+		newTable, columns, columns, table, colPath))
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(fmt.Sprintf(
+		`UPDATE %[1]s SET %[2]s = (
+			SELECT p.%[3]s FROM %[4]s o JOIN %[1]s p ON p.%[5]s = o.%[6]s WHERE o.%[5]s = %[1]s.%[5]s)`,
+		newTable, colParentID, colID, table, colPath, colParent))
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(fmt.Sprintf("DROP TABLE %s", table))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s RENAME TO %s", newTable, table))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(fmt.Sprintf(
+		`CREATE INDEX IF NOT EXISTS
+			parent_index ON %s (%s)`,
+		table,
+		colParentID))
+
+	return err
+}
+
+/*
+createTables creates the tables added by version 2, next to the Entries table: the chunks of large values, the
+deprecated paths, the metadata of the DB and the events log
+*/
+func createTables(tx *sql.Tx) error {
+	_, err := tx.Exec(fmt.Sprintf(
+		`CREATE TABLE %s (
+			%s TEXT NOT NULL,
+			%s INTEGER NOT NULL,
+			%s BLOB,
+			%s INTEGER DEFAULT NULL,
+			PRIMARY KEY (%s, %s)
+		)`,
+		chunksTable,
+		colPath,
+		colSeq,
+		colData,
+		colChecksum,
+		colPath,
+		colSeq))
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(fmt.Sprintf(
+		`CREATE TABLE %s (
+			%s TEXT NOT NULL,
+			%s TEXT DEFAULT '',
+			PRIMARY KEY (%s)
+		)`,
+		deprecationsTable,
+		colPath,
+		colReplacement,
+		colPath))
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(fmt.Sprintf(
+		`CREATE TABLE %s (
+			%s TEXT NOT NULL,
+			%s TEXT DEFAULT '',
+			PRIMARY KEY (%s)
+		)`,
+		metaTable,
+		colKey,
+		colValue,
+		colKey))
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(fmt.Sprintf(
+		`CREATE TABLE %s (
+			%s INTEGER NOT NULL,
+			%s INTEGER NOT NULL,
+			%s TEXT NOT NULL,
+			%s BIT DEFAULT 0,
+			%s TEXT DEFAULT '',
+			%s TEXT DEFAULT ''
+		)`,
+		eventsTable,
+		colRevision,
+		colChangeType,
+		colPath,
+		colIsValue,
+		colOldValue,
+		colValue))
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(fmt.Sprintf(
+		`CREATE INDEX IF NOT EXISTS
+			revision_index ON %s (%s)`,
+		eventsTable,
+		colRevision))
+
+	return err
+}
+
+/*
+setValue sets value at path, as described by force and skipHooks. Errors are wrapped in a PathError
+*/
+func setValue(path, value string, valueType ValueType, tx *sql.Tx, force bool, skipHooks bool) error {
+	return pathError("set", path, writeValue(path, value, valueType, tx, force, skipHooks))
+}
+
+func writeValue(path, value string, valueType ValueType, tx *sql.Tx, force bool, skipHooks bool) error {
 	sPath := splitPath(path)
 	if len(path) == 0 {
 		return ErrPathInvalid
 	}
 
-	now := time.Now().UnixMicro()
+	err := checkPath(path)
+	if err != nil {
+		return err
+	}
+
+	err = checkWritable(path)
+	if err != nil {
+		return err
+	}
+
+	warnDeprecated(path, "set")
+
+	err = validateValue(path, value, valueType)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UnixMilli()
 
 	entry, err := getEntry(path, tx)
+	if errors.Is(err, ErrValueCorrupted) {
+		// Corrupted values can still be overwritten, losing their old value
+		logWarn("overwriting corrupted value", "path", path)
+		entry = &Entry{Path: path, IsValue: true}
+		err = nil
+	}
+
 	if err != nil {
 		if !errors.Is(err, ErrPathNotFound) {
 			return err
@@ -364,12 +862,14 @@ func setValue(path, value string, tx *sql.Tx, force bool, skipHooks bool) error
 				}
 			}
 
-			_, err := tx.Stmt(stmts["insertValueEntry"]).Exec(path, now, parentPath(path), value)
+			_, err := insertValueEntry(path, now, parentPath(path), value, valueType, tx)
 			if err != nil {
 				return err
 			}
 
-			_, err = tx.Stmt(stmts["updateLastUpdate"]).Exec(now, parentPath(path))
+			recordChange(ChangeOverwritten, path, true, "", value)
+
+			_, err = pathStmt(tx, "updateLastUpdate", parentPath(path)).Exec(now, parentPath(path))
 			if err != nil {
 				return err
 			}
@@ -381,10 +881,14 @@ func setValue(path, value string, tx *sql.Tx, force bool, skipHooks bool) error
 				}
 			}
 
-			_, err := tx.Stmt(stmts["updateValue"]).Exec(now, value, path)
+			_, err := updateValue(path, now, value, valueType, tx)
 			if err != nil {
 				return err
 			}
+
+			if entry.Value != value {
+				recordChange(ChangeUpdated, path, true, entry.Value, value)
+			}
 		}
 
 		if !skipHooks {
@@ -398,6 +902,7 @@ func setValue(path, value string, tx *sql.Tx, force bool, skipHooks bool) error
 	}
 
 	parent := ""
+	overwritten := ""
 
 	// Path does not exist, create every entry in the path
 	i := 0
@@ -405,16 +910,25 @@ func setValue(path, value string, tx *sql.Tx, force bool, skipHooks bool) error
 		part := joinPath(sPath[:i])
 
 		isValue := false
-		row := tx.Stmt(stmts["getIsValue"]).QueryRow(part)
+		row := txStmt(tx, "getIsValue").QueryRow(part)
 		err = row.Scan(&isValue)
 
 		if err != nil {
 			if errors.Is(err, sql.ErrNoRows) {
-				_, err := tx.Stmt(stmts["insertNonValueEntry"]).Exec(part, now, parent)
+				err := copyUp(parent, tx)
+				if err != nil {
+					return err
+				}
+
+				_, err = pathStmt(tx, "insertNonValueEntry", part).Exec(part, now, parent, currentWriter())
 				if err != nil {
 					return nil
 				}
 
+				if part != overwritten {
+					recordChange(ChangeCreated, part, false, "", "")
+				}
+
 				parent = part
 			} else {
 				return err
@@ -425,11 +939,19 @@ func setValue(path, value string, tx *sql.Tx, force bool, skipHooks bool) error
 					return ErrPathInvalid
 				}
 
-				err := deleteEntry(part, tx)
+				oldValue, err := getValue(part, tx)
 				if err != nil {
 					return err
 				}
 
+				err = deleteEntry(part, tx)
+				if err != nil {
+					return err
+				}
+
+				recordChange(ChangeOverwritten, part, false, oldValue, "")
+				overwritten = part
+
 				i--
 			} else {
 				parent = part
@@ -446,12 +968,14 @@ func setValue(path, value string, tx *sql.Tx, force bool, skipHooks bool) error
 		}
 	}
 
-	_, err = tx.Stmt(stmts["insertValueEntry"]).Exec(path, now, parent, value)
+	_, err = insertValueEntry(path, now, parent, value, valueType, tx)
 	if err != nil {
 		return err
 	}
 
-	_, err = tx.Stmt(stmts["updateLastUpdate"]).Exec(now, parent)
+	recordChange(ChangeCreated, path, true, "", value)
+
+	_, err = pathStmt(tx, "updateLastUpdate", parent).Exec(now, parent)
 	if err != nil {
 		return err
 	}
@@ -472,11 +996,19 @@ func setRootEntry(entry *Entry, tx *sql.Tx, force bool, skipHooks bool, onlyMerg
 	}
 
 	parent := ""
+	// Whether parent was created by the import, so that its children can't exist yet
+	parentCreated := false
 	var visit func(entry *Entry) error
 
-	visit = func(entry *Entry) error {
+	// Failures are attributed to the deepest Entry
+	visitEntry := func(entry *Entry) error {
 		exists := false
-		isValue, err := pathIsValue(entry.Path, tx)
+		overwritten := false
+		isValue, err := false, ErrPathNotFound
+		if !parentCreated {
+			isValue, err = pathIsValue(entry.Path, tx)
+		}
+
 		if err != nil {
 			if errors.Is(err, ErrPathNotFound) {
 				exists = false
@@ -496,25 +1028,67 @@ func setRootEntry(entry *Entry, tx *sql.Tx, force bool, skipHooks bool, onlyMerg
 					}
 
 					exists = false
+					overwritten = true
 				}
 			}
 		}
 
+		if entry.IsValue && (!exists || !onlyMerge) {
+			err = validateValue(entry.Path, entry.Value, entry.Type)
+			if err != nil {
+				return err
+			}
+		}
+
 		if !exists {
+			err = checkPath(entry.Path)
+			if err != nil {
+				return err
+			}
+
+			err = checkWritable(entry.Path)
+			if err != nil {
+				return err
+			}
+
 			if entry.IsValue {
-				_, err := tx.Stmt(stmts["insertValueEntry"]).Exec(entry.Path, entry.LastUpdate, parent, entry.Value)
+				_, err := insertValueEntry(entry.Path, entry.LastUpdate.UnixMilli(), parent, entry.Value, entry.Type,
+					tx)
 				if err != nil {
 					return fmt.Errorf("error inserting value entry %s - %w", entry.Path, err)
 				}
 			} else {
-				_, err := tx.Stmt(stmts["insertNonValueEntry"]).Exec(entry.Path, entry.LastUpdate, parent)
+				err := copyUp(parent, tx)
+				if err != nil {
+					return err
+				}
+
+				_, err = pathStmt(tx, "insertNonValueEntry", entry.Path).Exec(entry.Path,
+					entry.LastUpdate.UnixMilli(), parent, currentWriter())
 				if err != nil {
 					return fmt.Errorf("error inserting non-value entry %s - %w", entry.Path, err)
 				}
 			}
+
+			if overwritten {
+				recordChange(ChangeOverwritten, entry.Path, entry.IsValue, "", entry.Value)
+			} else {
+				recordChange(ChangeCreated, entry.Path, entry.IsValue, "", entry.Value)
+			}
 		} else if !onlyMerge {
 			if entry.IsValue {
-				_, err := tx.Stmt(stmts["updateValue"]).Exec(entry.LastUpdate, entry.Value, entry.Path)
+				if recordChanges {
+					oldValue, err := getValue(entry.Path, tx)
+					if err != nil {
+						return err
+					}
+
+					if oldValue != entry.Value {
+						recordChange(ChangeUpdated, entry.Path, true, oldValue, entry.Value)
+					}
+				}
+
+				_, err := updateValue(entry.Path, entry.LastUpdate.UnixMilli(), entry.Value, entry.Type, tx)
 				if err != nil {
 					return err
 				}
@@ -523,7 +1097,7 @@ func setRootEntry(entry *Entry, tx *sql.Tx, force bool, skipHooks bool, onlyMerg
 					return fmt.Errorf("error updating value entry %s - %w", entry.Path, err)
 				}
 			} else {
-				_, err = tx.Stmt(stmts["updateLastUpdate"]).Exec(entry.LastUpdate, parent)
+				_, err = pathStmt(tx, "updateLastUpdate", parent).Exec(entry.LastUpdate.UnixMilli(), parent)
 				if err != nil {
 					return err
 				}
@@ -531,8 +1105,8 @@ func setRootEntry(entry *Entry, tx *sql.Tx, force bool, skipHooks bool, onlyMerg
 		}
 
 		if !entry.IsValue {
-			prevParent := parent
-			parent = entry.Path
+			prevParent, prevParentCreated := parent, parentCreated
+			parent, parentCreated = entry.Path, !exists
 
 			for _, child := range entry.Children {
 				err = visit(child)
@@ -541,50 +1115,173 @@ func setRootEntry(entry *Entry, tx *sql.Tx, force bool, skipHooks bool, onlyMerg
 				}
 			}
 
-			parent = prevParent
+			parent, parentCreated = prevParent, prevParentCreated
 		}
 
 		return nil
 	}
 
+	visit = func(entry *Entry) error {
+		return pathError("import", entry.Path, visitEntry(entry))
+	}
+
 	return visit(entry)
 }
 
+/*
+storedValue converts a value to the form stored in the DB. Binary values are carried around as base64 strings, but
+stored in their own BLOB column.
+*/
+func storedValue(value string, valueType ValueType) (string, []byte, error) {
+	if valueType == TypeStream {
+		// Stored in chunks by replaceChunks
+		return "", nil, nil
+	}
+
+	if valueType != TypeBytes {
+		return value, nil, nil
+	}
+
+	blob, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return "", nil, fmt.Errorf("error decoding binary value - %w", err)
+	}
+
+	return "", blob, nil
+}
+
+func loadedValue(value string, valueType ValueType, blob []byte) string {
+	if valueType != TypeBytes {
+		return value
+	}
+
+	return base64.StdEncoding.EncodeToString(blob)
+}
+
+func insertValueEntry(path string, lastUpdate int64, parent string, value string, valueType ValueType,
+	tx *sql.Tx) (sql.Result, error) {
+	text, blob, err := storedValue(value, valueType)
+	if err != nil {
+		return nil, err
+	}
+
+	err = copyUp(parent, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := pathStmt(tx, "insertValueEntry", path).Exec(path, lastUpdate, parent, text, valueType, blob,
+		valueChecksum(text, blob), currentWriter())
+	if err != nil {
+		return nil, err
+	}
+
+	return result, replaceChunks(path, value, valueType, tx)
+}
+
+func updateValue(path string, lastUpdate int64, value string, valueType ValueType, tx *sql.Tx) (sql.Result, error) {
+	text, blob, err := storedValue(value, valueType)
+	if err != nil {
+		return nil, err
+	}
+
+	err = copyUp(path, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := pathStmt(tx, "updateValue", path).Exec(lastUpdate, text, valueType, blob,
+		valueChecksum(text, blob), currentWriter(), path)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, replaceChunks(path, value, valueType, tx)
+}
+
 func getValue(path string, tx *sql.Tx) (string, error) {
-	row := tx.Stmt(stmts["getValue"]).QueryRow(path)
+	value, _, err := getTypedValue(path, tx)
+	return value, err
+}
+
+func getTypedValue(path string, tx *sql.Tx) (string, ValueType, error) {
+	row := txStmt(tx, "getValue").QueryRow(path)
 
 	var isValue bool
 	var value string
-	err := row.Scan(&isValue, &value)
+	var valueType ValueType
+	var blob []byte
+	var checksum sql.NullInt64
+	err := row.Scan(&isValue, &value, &valueType, &blob, &checksum)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return "", ErrPathNotFound
+			return "", TypeUntyped, ErrPathNotFound
 		} else {
-			return "", err
+			return "", TypeUntyped, err
 		}
 	}
 
+	countRows(tx, 1)
+
 	if !isValue {
-		return "", ErrPathIsNotAValue
+		return "", TypeUntyped, ErrPathIsNotAValue
 	}
 
-	return value, nil
+	err = verifyChecksum(path, value, blob, checksum)
+	if err != nil {
+		return "", TypeUntyped, err
+	}
+
+	if valueType == TypeStream {
+		value, err = getChunksBase64(path, tx)
+		if err != nil {
+			return "", TypeUntyped, err
+		}
+
+		return value, valueType, nil
+	}
+
+	return loadedValue(value, valueType, blob), valueType, nil
+}
+
+/*
+scanEntry scans the Entry in the current row, followed by the columns in extra, if any
+*/
+func scanEntry(rows *sql.Rows, extra ...any) (*Entry, error) {
+	entry := newEntry()
+	lastUpdateMs := int64(0)
+	var blob []byte
+	var checksum sql.NullInt64
+
+	dest := []any{&entry.Path, &lastUpdateMs, &entry.IsValue, &entry.Value, &entry.Type, &blob, &checksum,
+		&entry.Writer, &entry.Revision}
+
+	err := rows.Scan(append(dest, extra...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = verifyChecksum(entry.Path, entry.Value, blob, checksum)
+	if err != nil {
+		return nil, err
+	}
+
+	entry.Value = loadedValue(entry.Value, entry.Type, blob)
+
+	entry.LastUpdate = time.Unix(lastUpdateMs/1000, (lastUpdateMs*1000000)%1000000000)
+
+	return entry, nil
 }
 
-func entriesFromRows(rows *sql.Rows) ([]*Entry, error) {
+func entriesFromRows(rows *sql.Rows, tx *sql.Tx) ([]*Entry, error) {
 	entries := []*Entry{}
 
 	for rows.Next() {
-		entry := newEntry()
-		lastUpdateMs := int64(0)
-
-		err := rows.Scan(&entry.Path, &lastUpdateMs, &entry.IsValue, &entry.Value)
+		entry, err := scanEntry(rows)
 		if err != nil {
 			return nil, err
 		}
 
-		entry.LastUpdate = time.Unix(lastUpdateMs/1000, (lastUpdateMs*1000000)%1000000000)
-
 		entries = append(entries, entry)
 	}
 
@@ -593,11 +1290,13 @@ func entriesFromRows(rows *sql.Rows) ([]*Entry, error) {
 		return nil, err
 	}
 
+	countRows(tx, len(entries))
+
 	return entries, nil
 }
 
 func getEntry(path string, tx *sql.Tx) (*Entry, error) {
-	rows, err := tx.Stmt(stmts["getEntry"]).Query(path)
+	rows, err := txStmt(tx, "getEntry").Query(path)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrPathNotFound
@@ -606,7 +1305,11 @@ func getEntry(path string, tx *sql.Tx) (*Entry, error) {
 		}
 	}
 
-	entries, err := entriesFromRows(rows)
+	entries, err := entriesFromRows(rows, tx)
+	if err != nil {
+		return nil, err
+	}
+
 	if len(entries) == 0 {
 		return nil, ErrPathNotFound
 	}
@@ -614,10 +1317,32 @@ func getEntry(path string, tx *sql.Tx) (*Entry, error) {
 	return entries[0], nil
 }
 
+func getChildren(path string, tx *sql.Tx) ([]*Entry, error) {
+	rows, err := txStmt(tx, "getChildren").Query(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	return entriesFromRows(rows, tx)
+}
+
 func getEntryDepth(path string, depth int, tx *sql.Tx) (*Entry, error) {
+	return getSubtreeEntry(path, depth, false, tx)
+}
+
+/*
+getSubtreeEntry calls getEntryDepth, leaving the values of the Entries empty, without reading them, if skipValues is
+true
+*/
+func getSubtreeEntry(path string, depth int, skipValues bool, tx *sql.Tx) (*Entry, error) {
 	var root *Entry
 
-	err := recurse(path, depth, func(entry *Entry, parent *Entry, d uint) error {
+	err := recurseSubtree(path, depth, skipValues, func(entry *Entry, parent *Entry, d uint) error {
+		// The children of the last level are left to be loaded on demand
+		entry.childrenPending = !entry.IsValue && depth >= 0 && int(d) == depth
+
 		if root == nil {
 			root = entry
 			return nil
@@ -632,49 +1357,92 @@ func getEntryDepth(path string, depth int, tx *sql.Tx) (*Entry, error) {
 	return root, err
 }
 
+/*
+subtreeColumns returns the columns of the Entries read by the getSubtree statement, from the table aliased as e. Values
+are replaced by empty ones when the third parameter of the statement is true, so that they are not read at all
+*/
+func subtreeColumns() string {
+	cols := []string{}
+	for _, c := range []string{colPath, colLastUpdateMs, colIsValue, colValue, colValueType, colBlobValue,
+		colChecksum, colWriter, colRevision} {
+		switch c {
+		case colValue:
+			cols = append(cols, fmt.Sprintf("CASE WHEN ?3 THEN '' ELSE e.%s END", c))
+		case colBlobValue, colChecksum:
+			cols = append(cols, fmt.Sprintf("CASE WHEN ?3 THEN NULL ELSE e.%s END", c))
+		default:
+			cols = append(cols, "e."+c)
+		}
+	}
+
+	return strings.Join(cols, ", ")
+}
+
+/*
+recurse calls cb on the Entry at path and on its children, up to depth (all of them if negative), level by level and,
+within a level, in path order. The whole subtree is fetched with a single query before calling cb, so cb is free to
+change it
+*/
 func recurse(path string, depth int, cb func(entry *Entry, parent *Entry, depth uint) error, tx *sql.Tx) error {
+	return recurseSubtree(path, depth, false, cb, tx)
+}
+
+/*
+recurseSubtree calls recurse, leaving the values of the Entries empty, without reading them, if skipValues is true
+*/
+func recurseSubtree(path string, depth int, skipValues bool, cb func(entry *Entry, parent *Entry, depth uint) error,
+	tx *sql.Tx) error {
 	if cb == nil {
 		return fmt.Errorf("not callback function specified")
 	}
 
-	root, err := getEntry(path, tx)
+	warnDeprecated(path, "get")
+
+	rows, err := txStmt(tx, "getSubtree").Query(path, depth, skipValues)
 	if err != nil {
 		return err
 	}
 
-	d := 0
-	queue := [][]*Entry{}
-	queue = append(queue, []*Entry{root, nil})
+	defer rows.Close()
 
-	for len(queue) != 0 {
-		pair := queue[0]
-		queue = queue[1:]
+	type node struct {
+		entry  *Entry
+		parent *Entry
+		depth  uint
+	}
 
-		if depth < 0 || d < depth {
-			rows, err := tx.Stmt(stmts["getChildren"]).Query(pair[0].Path)
-			if err != nil {
-				return err
-			}
+	nodes := []node{}
+	entries := map[string]*Entry{}
 
-			children, err := entriesFromRows(rows)
-			if err != nil {
-				return err
-			}
+	for rows.Next() {
+		var parentPath sql.NullString
+		var d uint
 
-			for _, child := range children {
-				queue = append(queue, []*Entry{child, pair[0]})
-			}
+		entry, err := scanEntry(rows, &parentPath, &d)
+		if err != nil {
+			return err
 		}
 
-		err = cb(pair[0], pair[1], uint(d))
+		entries[entry.Path] = entry
+		nodes = append(nodes, node{entry, entries[parentPath.String], d})
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+
+	countRows(tx, len(nodes))
+
+	if len(nodes) == 0 {
+		return ErrPathNotFound
+	}
+
+	for _, n := range nodes {
+		err = cb(n.entry, n.parent, n.depth)
 		if err != nil {
 			return fmt.Errorf("error from recurse callback - %w", err)
 		}
-
-		// We retrieve the children first, then provide the Entry, since it could be deleted in the cb
-		if d < depth {
-			d++
-		}
 	}
 
 	return nil
@@ -685,49 +1453,88 @@ func deleteEntry(path string, tx *sql.Tx) error {
 		return ErrPathInvalid
 	}
 
-	queue := []string{}
-	queue = append(queue, path)
+	err := checkDeletable(path)
+	if err != nil {
+		return err
+	}
 
-	for len(queue) != 0 {
-		p := queue[0]
-		queue = queue[1:]
+	err = checkNotDefault(path, tx)
+	if err != nil {
+		return err
+	}
 
-		rows, err := tx.Stmt(stmts["getChildrenPaths"]).Query(p)
-		if err != nil {
-			return err
-		}
+	_, err = pathStmt(tx, "deleteEntry", path).Exec(path)
+	if err != nil {
+		return err
+	}
 
-		for rows.Next() {
-			child := ""
-			err = rows.Scan(&child)
-			if err != nil {
-				return err
-			}
+	_, err = pathStmt(tx, "deleteSubtreeChunks", path).Exec(path)
+	if err != nil {
+		return err
+	}
 
-			queue = append(queue, child)
-		}
+	err = deleteMounted(path, tx)
+	if err != nil {
+		return err
+	}
 
-		err = rows.Err()
-		if err != nil {
-			return err
-		}
+	_, err = pathStmt(tx, "updateLastUpdate", parentPath(path)).Exec(time.Now().UnixMilli(), parentPath(path))
+	if err != nil {
+		return err
+	}
 
-		_, err = tx.Stmt(stmts["deleteEntry"]).Exec(p)
-		if err != nil {
-			return err
-		}
+	return nil
+}
 
-		_, err = tx.Stmt(stmts["updateLastUpdate"]).Exec(time.Now().UnixMilli(), parentPath(path))
-		if err != nil {
-			return err
+/*
+deletePath deletes the Entry at path and its children, recording the deletion
+*/
+func deletePath(path string, tx *sql.Tx) error {
+	if path == "" {
+		return &PathError{Op: "delete", Path: path, Err: ErrPathInvalid}
+	}
+
+	entry, err := getEntry(path, tx)
+	if errors.Is(err, ErrValueCorrupted) {
+		logWarn("deleting corrupted value", "path", path)
+		entry = &Entry{Path: path, IsValue: true}
+		err = nil
+	}
+
+	if err != nil {
+		if errors.Is(err, ErrPathNotFound) {
+			return nil
 		}
+
+		return pathError("delete", path, err)
 	}
 
+	err = deleteEntry(path, tx)
+	if err != nil {
+		return pathError("delete", path, err)
+	}
+
+	recordChange(ChangeDeleted, path, entry.IsValue, entry.Value, "")
+
 	return nil
 }
 
+/*
+getMeta reads a property of the DB (not bound to any Entry) from the meta table
+*/
+func getMeta(key string, tx *sql.Tx) (string, error) {
+	value := ""
+	err := txStmt(tx, "getMeta").QueryRow(key).Scan(&value)
+	return value, err
+}
+
+func setMeta(key string, value string, tx *sql.Tx) error {
+	_, err := txStmt(tx, "setMeta").Exec(key, value)
+	return err
+}
+
 func pathIsValue(path string, tx *sql.Tx) (bool, error) {
-	row := tx.Stmt(stmts["getIsValue"]).QueryRow(path)
+	row := txStmt(tx, "getIsValue").QueryRow(path)
 	isValue := false
 	err := row.Scan(&isValue)
 	if err != nil {
@@ -0,0 +1,198 @@
+package camellia
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+/*
+Deprecation marks a path (and its children) as deprecated, optionally pointing to the path replacing it.
+*/
+type Deprecation struct {
+	Path        string
+	Replacement string
+}
+
+/* Deprecations of the open DB, loaded when it's opened */
+var deprecations = map[string]string{}
+
+/*
+Deprecate marks the specified path, and its children, as deprecated, optionally specifying the path replacing it
+(replacement can be empty).
+
+Reading or writing deprecated paths still succeeds, but emits a warning through the Logger (see SetLogger).
+Deprecations are stored in the DB, so they are visible to every user of the DB (like the cml command).
+*/
+func Deprecate(path string, replacement string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return ErrNoDB
+	}
+
+	path = normalizePath(path)
+	if path == "" {
+		return ErrPathInvalid
+	}
+
+	replacement = normalizePath(replacement)
+
+	tx, err := beginTx()
+	if err != nil {
+		return fmt.Errorf("error beginning transaction - %w", err)
+	}
+
+	_, err = txStmt(tx, "setDeprecation").Exec(path, replacement)
+	if err != nil {
+		rollbackTx(tx)
+		return fmt.Errorf("error storing deprecation - %w", err)
+	}
+
+	err = commitTx(tx)
+	if err != nil {
+		rollbackTx(tx)
+		return fmt.Errorf("error committing transaction - %w", err)
+	}
+
+	deprecations[path] = replacement
+
+	return nil
+}
+
+/*
+Undeprecate removes the deprecation mark from the specified path.
+*/
+func Undeprecate(path string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return ErrNoDB
+	}
+
+	path = normalizePath(path)
+
+	tx, err := beginTx()
+	if err != nil {
+		return fmt.Errorf("error beginning transaction - %w", err)
+	}
+
+	_, err = txStmt(tx, "deleteDeprecation").Exec(path)
+	if err != nil {
+		rollbackTx(tx)
+		return fmt.Errorf("error deleting deprecation - %w", err)
+	}
+
+	err = commitTx(tx)
+	if err != nil {
+		rollbackTx(tx)
+		return fmt.Errorf("error committing transaction - %w", err)
+	}
+
+	delete(deprecations, path)
+
+	return nil
+}
+
+/*
+GetDeprecation returns the Deprecation applying to the specified path (marked on the path itself or on one of its
+ancestors), or nil if the path is not deprecated.
+*/
+func GetDeprecation(path string) (*Deprecation, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return nil, ErrNoDB
+	}
+
+	return findDeprecation(normalizePath(path)), nil
+}
+
+/*
+GetDeprecations returns all the deprecated paths, sorted by path.
+*/
+func GetDeprecations() ([]Deprecation, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return nil, ErrNoDB
+	}
+
+	result := []Deprecation{}
+	for path, replacement := range deprecations {
+		result = append(result, Deprecation{Path: path, Replacement: replacement})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Path < result[j].Path
+	})
+
+	return result, nil
+}
+
+func loadDeprecations() error {
+	deprecations = map[string]string{}
+
+	rows, err := db.Query(fmt.Sprintf("SELECT %s, %s FROM %s", colPath, colReplacement, deprecationsTable))
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var path, replacement string
+		err = rows.Scan(&path, &replacement)
+		if err != nil {
+			return err
+		}
+
+		deprecations[path] = replacement
+	}
+
+	return rows.Err()
+}
+
+func findDeprecation(path string) *Deprecation {
+	if len(deprecations) == 0 {
+		return nil
+	}
+
+	for p := path; ; p = parentPath(p) {
+		replacement, ok := deprecations[p]
+		if ok {
+			d := Deprecation{Path: p, Replacement: replacement}
+			if replacement != "" && p != path {
+				// Point to the replacement of the child, not of the deprecated ancestor
+				d.Replacement = joinPath([]string{replacement, strings.TrimPrefix(path, p+"/")})
+			}
+
+			return &d
+		}
+
+		if p == "" {
+			return nil
+		}
+	}
+}
+
+/*
+warnDeprecated emits a warning if path is deprecated
+*/
+func warnDeprecated(path string, op string) {
+	d := findDeprecation(path)
+	if d == nil {
+		return
+	}
+
+	if d.Replacement != "" {
+		logWarn("access to deprecated path", "op", op, "path", path, "replacement", d.Replacement)
+	} else {
+		logWarn("access to deprecated path", "op", op, "path", path)
+	}
+}
@@ -0,0 +1,424 @@
+package camellia
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+/*
+fileNameEscaper escapes the names of Entries into file names, and fileNameUnescaper does the opposite
+*/
+var fileNameEscaper = strings.NewReplacer("%", "%25", "/", "%2F")
+var fileNameUnescaper = strings.NewReplacer("%2F", "/", "%2E", ".", "%25", "%")
+
+/*
+treeWriter writes an exported hierarchy as files. Paths are relative to the exported Entry, and dir is called before
+the children of each non-value Entry are written
+*/
+type treeWriter interface {
+	dir(rel string, mtime time.Time) error
+	file(rel string, content []byte, mtime time.Time) error
+}
+
+/*
+ExportToDir writes the hierarchy at the specified path to the directory dir, created if missing: each non-value Entry
+becomes a directory, and each value a file holding it, with the modification time set to the last update of the
+Entry. The resulting tree diffs naturally, so it can be committed to version control or shipped as an update artifact.
+
+Binary values are written as they are, so the content of a file is the value returned by GetBytes. Names containing
+slashes are escaped as "%2F", percent signs as "%25", and "." and ".." as "%2E" and "%2E%2E". Existing files are
+overwritten, while the others are left as they are. Fails with ErrTypeMismatch if the Entry at path is a value.
+*/
+func ExportToDir(path string, dir string) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return fmt.Errorf("error creating directory %s - %w", dir, err)
+	}
+
+	w := &dirTreeWriter{root: dir}
+
+	err = exportTree(path, w)
+	if err != nil {
+		return err
+	}
+
+	// Directories are timestamped last, since writing their children updates them
+	for i := len(w.dirs) - 1; i >= 0; i-- {
+		err = os.Chtimes(w.dirs[i].name, w.dirs[i].mtime, w.dirs[i].mtime)
+		if err != nil {
+			return fmt.Errorf("error setting time of %s - %w", w.dirs[i].name, err)
+		}
+	}
+
+	return nil
+}
+
+/*
+ExportToTar writes the hierarchy at the specified path to w as a tar archive, laid out like ExportToDir does.
+*/
+func ExportToTar(path string, w io.Writer) error {
+	tw := tar.NewWriter(w)
+
+	err := exportTree(path, &tarTreeWriter{w: tw})
+	if err != nil {
+		return err
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return fmt.Errorf("error writing archive - %w", err)
+	}
+
+	return nil
+}
+
+/*
+ImportFromDir sets (forces) the values found in the directory dir, laid out like ExportToDir does, in a single
+transaction. Files holding valid UTF-8 text are imported as untyped values, and the others as binary values, as type
+tags are not exported. Timestamps are not imported, like the ones of JSON imports. Only OnlyMerge, Writer and Path are
+used from options.
+*/
+func ImportFromDir(dir string, options ImportOptions) error {
+	root := &treeNode{}
+
+	err := fs.WalkDir(os.DirFS(dir), ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if name == "." {
+			return nil
+		}
+
+		if d.IsDir() {
+			root.node(name)
+			return nil
+		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		content, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			return err
+		}
+
+		node := root.node(name)
+		node.isValue = true
+		node.value = content
+
+		return nil
+	})
+
+	if err != nil {
+		return fmt.Errorf("error reading directory %s - %w", dir, err)
+	}
+
+	return importTree(root, options)
+}
+
+/*
+ImportFromTar sets (forces) the values found in the tar archive read from r, laid out like ExportToTar does, as
+ImportFromDir would. Entries of the archive other than directories and regular files are ignored.
+*/
+func ImportFromTar(r io.Reader, options ImportOptions) error {
+	root := &treeNode{}
+	tr := tar.NewReader(r)
+
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return fmt.Errorf("error reading archive - %w", err)
+		}
+
+		name := strings.Trim(pathpkg.Clean("/"+header.Name), "/")
+		if name == "" {
+			continue
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			root.node(name)
+		case tar.TypeReg:
+			content, err := io.ReadAll(tr)
+			if err != nil {
+				return fmt.Errorf("error reading %s from archive - %w", header.Name, err)
+			}
+
+			node := root.node(name)
+			node.isValue = true
+			node.value = content
+		}
+	}
+
+	return importTree(root, options)
+}
+
+/*
+exportTree writes the hierarchy at path to w, reading it in a single transaction
+*/
+func exportTree(path string, w treeWriter) error {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return ErrNoDB
+	}
+
+	tx, err := beginReadTx(context.Background())
+	if err != nil {
+		return fmt.Errorf("error beginning transaction - %w", err)
+	}
+
+	path = normalizePath(path)
+
+	entry, err := getEntryDepth(path, -1, tx)
+	if err != nil {
+		rollbackTx(tx)
+		return err
+	}
+
+	if entry.IsValue {
+		rollbackTx(tx)
+		return fmt.Errorf("%w - %s is a value", ErrTypeMismatch, path)
+	}
+
+	err = exportTreeEntry(entry, "", w, tx)
+	if err != nil {
+		rollbackTx(tx)
+		return err
+	}
+
+	err = endReadTx(context.Background(), tx)
+	if err != nil {
+		rollbackTx(tx)
+		return fmt.Errorf("error committing transaction - %w", err)
+	}
+
+	return nil
+}
+
+func exportTreeEntry(entry *Entry, rel string, w treeWriter, tx *sql.Tx) error {
+	if entry.IsValue {
+		content, err := valueContent(entry, tx)
+		if err != nil {
+			return err
+		}
+
+		return w.file(rel, content, entry.LastUpdate)
+	}
+
+	err := w.dir(rel, entry.LastUpdate)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(entry.Children))
+	for name := range entry.Children {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		err = exportTreeEntry(entry.Children[name], pathpkg.Join(rel, fileName(name)), w, tx)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+valueContent returns the content of the value of entry, decoding binary values
+*/
+func valueContent(entry *Entry, tx *sql.Tx) ([]byte, error) {
+	switch entry.Type {
+	case TypeBytes:
+		return base64.StdEncoding.DecodeString(entry.Value)
+	case TypeStream:
+		b := bytes.Buffer{}
+		err := copyChunks(entry.Path, &b, tx)
+		return b.Bytes(), err
+	default:
+		return []byte(entry.Value), nil
+	}
+}
+
+/*
+fileName returns the file name of the Entry named by segment
+*/
+func fileName(segment string) string {
+	name := UnescapeSegment(segment)
+
+	switch name {
+	case ".":
+		return "%2E"
+	case "..":
+		return "%2E%2E"
+	default:
+		return fileNameEscaper.Replace(name)
+	}
+}
+
+type exportedDir struct {
+	name  string
+	mtime time.Time
+}
+
+type dirTreeWriter struct {
+	root string
+	dirs []exportedDir
+}
+
+func (w *dirTreeWriter) dir(rel string, mtime time.Time) error {
+	name := filepath.Join(w.root, filepath.FromSlash(rel))
+
+	err := os.MkdirAll(name, 0755)
+	if err != nil {
+		return fmt.Errorf("error creating directory %s - %w", name, err)
+	}
+
+	w.dirs = append(w.dirs, exportedDir{name: name, mtime: mtime})
+
+	return nil
+}
+
+func (w *dirTreeWriter) file(rel string, content []byte, mtime time.Time) error {
+	name := filepath.Join(w.root, filepath.FromSlash(rel))
+
+	err := os.WriteFile(name, content, 0644)
+	if err != nil {
+		return fmt.Errorf("error writing file %s - %w", name, err)
+	}
+
+	err = os.Chtimes(name, mtime, mtime)
+	if err != nil {
+		return fmt.Errorf("error setting time of %s - %w", name, err)
+	}
+
+	return nil
+}
+
+type tarTreeWriter struct {
+	w *tar.Writer
+}
+
+func (w *tarTreeWriter) dir(rel string, mtime time.Time) error {
+	// The exported Entry is the root of the archive
+	if rel == "" {
+		return nil
+	}
+
+	err := w.w.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: rel + "/", Mode: 0755, ModTime: mtime})
+	if err != nil {
+		return fmt.Errorf("error writing archive - %w", err)
+	}
+
+	return nil
+}
+
+func (w *tarTreeWriter) file(rel string, content []byte, mtime time.Time) error {
+	err := w.w.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     rel,
+		Mode:     0644,
+		Size:     int64(len(content)),
+		ModTime:  mtime})
+
+	if err == nil {
+		_, err = w.w.Write(content)
+	}
+
+	if err != nil {
+		return fmt.Errorf("error writing archive - %w", err)
+	}
+
+	return nil
+}
+
+/*
+treeNode is a file or directory read by ImportFromDir or ImportFromTar
+*/
+type treeNode struct {
+	children map[string]*treeNode
+	isValue  bool
+	value    []byte
+}
+
+/*
+node returns the node at the slash separated path name below n, creating it and its parents as directories if missing
+*/
+func (n *treeNode) node(name string) *treeNode {
+	for _, s := range strings.Split(name, "/") {
+		if n.children == nil {
+			n.children = map[string]*treeNode{}
+		}
+
+		child, ok := n.children[s]
+		if !ok {
+			child = &treeNode{}
+			n.children[s] = child
+		}
+
+		n = child
+	}
+
+	return n
+}
+
+/*
+extended returns the representation of the node in the extended JSON format
+*/
+func (n *treeNode) extended() map[string]any {
+	if n.isValue {
+		if utf8.Valid(n.value) {
+			return map[string]any{propValue: string(n.value)}
+		}
+
+		return map[string]any{propType: TypeBytes, propValue: base64.StdEncoding.EncodeToString(n.value)}
+	}
+
+	children := map[string]any{}
+	for name, child := range n.children {
+		children[EscapeSegment(fileNameUnescaper.Replace(name))] = child.extended()
+	}
+
+	return map[string]any{propChildren: children}
+}
+
+/*
+importTree imports the tree at root, as the extended JSON representation it maps to
+*/
+func importTree(root *treeNode, options ImportOptions) error {
+	encoded, err := json.Marshal(root.extended())
+	if err != nil {
+		return fmt.Errorf("error encoding tree - %w", err)
+	}
+
+	options.Extended = true
+
+	return ImportJSON(bytes.NewReader(encoded), options)
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd
+
+package camellia
+
+/*
+freeSpace returns 0, since the free space of file systems is determined only on Linux, macOS and FreeBSD
+*/
+func freeSpace(dir string) uint64 {
+	return 0
+}
@@ -0,0 +1,19 @@
+//go:build linux || darwin || freebsd
+
+package camellia
+
+import "syscall"
+
+/*
+freeSpace returns the bytes available to unprivileged processes on the file system containing dir, or 0 if they can't
+be determined
+*/
+func freeSpace(dir string) uint64 {
+	var stat syscall.Statfs_t
+	err := syscall.Statfs(dir, &stat)
+	if err != nil {
+		return 0
+	}
+
+	return uint64(stat.Bavail) * uint64(stat.Bsize)
+}
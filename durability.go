@@ -0,0 +1,251 @@
+package camellia
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+Durability selects how much committed transactions are protected from power losses and OS crashes, as opposed to how
+many times the storage is synced (see Options.Durability). The DB is never corrupted by an application crash, with
+any Durability.
+
+DurabilityNormal: the default. The storage is synced at checkpoints only (see Checkpoint), not on every commit: the
+DB can't be corrupted, but the last transactions committed before a power loss may be rolled back.
+
+DurabilityFull: the storage is synced on every commit, so committed transactions survive power losses.
+
+DurabilityOff: the storage is never synced, leaving it to the OS: the DB may be corrupted by a power loss.
+*/
+type Durability int
+
+const (
+	DurabilityNormal Durability = 0
+	DurabilityFull   Durability = 1
+	DurabilityOff    Durability = 2
+)
+
+func (d Durability) String() string {
+	switch d {
+	case DurabilityNormal:
+		return "normal"
+	case DurabilityFull:
+		return "full"
+	case DurabilityOff:
+		return "off"
+	default:
+		return fmt.Sprintf("Durability(%d)", int(d))
+	}
+}
+
+/*
+synchronous returns the value of the synchronous pragma implementing d
+*/
+func (d Durability) synchronous() (string, error) {
+	switch d {
+	case DurabilityNormal:
+		return "NORMAL", nil
+	case DurabilityFull:
+		return "FULL", nil
+	case DurabilityOff:
+		return "OFF", nil
+	default:
+		return "", fmt.Errorf("invalid durability %d", int(d))
+	}
+}
+
+/*
+CheckpointMode selects how much of the write-ahead log a checkpoint writes back to the DB file, and how long it waits
+for the reads and writes of other processes (see CheckpointWithMode).
+
+CheckpointTruncate: the default. Waits for the writers and the readers of the log, writes the whole log back to the DB
+file, syncing it, and truncates the log to zero bytes, so that it doesn't take space on the storage.
+
+CheckpointPassive: writes back as much of the log as possible without waiting for the readers and writers of other
+processes. Never fails with ErrBusy, but may leave part of the log to the next checkpoint.
+
+CheckpointFull: like CheckpointTruncate, but leaves the log file as it is, to be overwritten from its start by the next
+transactions.
+
+CheckpointRestart: like CheckpointFull, but also waits for the readers of the log to finish, so that the next
+transactions overwrite it from its start.
+*/
+type CheckpointMode int
+
+const (
+	CheckpointTruncate CheckpointMode = 0
+	CheckpointPassive  CheckpointMode = 1
+	CheckpointFull     CheckpointMode = 2
+	CheckpointRestart  CheckpointMode = 3
+)
+
+func (m CheckpointMode) String() string {
+	switch m {
+	case CheckpointTruncate:
+		return "truncate"
+	case CheckpointPassive:
+		return "passive"
+	case CheckpointFull:
+		return "full"
+	case CheckpointRestart:
+		return "restart"
+	default:
+		return fmt.Sprintf("CheckpointMode(%d)", int(m))
+	}
+}
+
+/*
+pragma returns the argument of the wal_checkpoint pragma implementing m
+*/
+func (m CheckpointMode) pragma() (string, error) {
+	switch m {
+	case CheckpointTruncate:
+		return "TRUNCATE", nil
+	case CheckpointPassive:
+		return "PASSIVE", nil
+	case CheckpointFull:
+		return "FULL", nil
+	case CheckpointRestart:
+		return "RESTART", nil
+	default:
+		return "", fmt.Errorf("invalid checkpoint mode %d", int(m))
+	}
+}
+
+var checkpointerMutex sync.Mutex
+var checkpointerStop chan struct{}
+var checkpointerDone chan struct{}
+
+/*
+Checkpoint writes the transactions in the write-ahead log back to the DB file, syncing it, and truncates the log, like
+CheckpointWithMode(CheckpointTruncate). SQLite also checkpoints automatically, when the log grows beyond 1000 pages
+(unless Options.CheckpointInterval is set), so Checkpoint is only needed to bound the size of the log, or to choose
+when the storage is synced with DurabilityNormal.
+
+Fails with ErrBusy if the log can't be fully written back because of reads in progress in other processes.
+*/
+func Checkpoint() error {
+	return CheckpointWithMode(CheckpointTruncate)
+}
+
+/*
+CheckpointWithMode writes the transactions in the write-ahead log back to the DB file as selected by mode. The values
+buffered by Set (see Options.FlushInterval) are flushed first, so that they are written back too.
+
+Fails with ErrBusy if, with a mode other than CheckpointPassive, the log can't be fully written back because of reads
+in progress in other processes.
+*/
+func CheckpointWithMode(mode CheckpointMode) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return ErrNoDB
+	}
+
+	err := flushBuffered(context.Background())
+	if err != nil {
+		return fmt.Errorf("error flushing buffered values - %w", err)
+	}
+
+	return checkpoint(mode)
+}
+
+/*
+checkpoint writes the write-ahead log back to the DB file as selected by mode. Must be called while the global mutex is
+held
+*/
+func checkpoint(mode CheckpointMode) error {
+	pragma, err := mode.pragma()
+	if err != nil {
+		return err
+	}
+
+	var busy, logPages, checkpointedPages int
+	err = writeConn.QueryRowContext(context.Background(), "PRAGMA wal_checkpoint("+pragma+")").Scan(&busy, &logPages,
+		&checkpointedPages)
+	if err != nil {
+		return fmt.Errorf("error checkpointing DB - %w", wrapBusy(err))
+	}
+
+	if busy != 0 {
+		return fmt.Errorf("%w - %d of %d pages checkpointed", ErrBusy, checkpointedPages, logPages)
+	}
+
+	logDebug("checkpointed DB", "mode", mode, "pages", checkpointedPages, "log_pages", logPages)
+
+	return nil
+}
+
+/*
+configureCheckpoints verifies the checkpoint mode selected by options, and disables the automatic checkpoints of SQLite
+on the writer connection if the DB is to be checkpointed at Options.CheckpointInterval instead
+*/
+func configureCheckpoints(options Options) error {
+	_, err := options.CheckpointMode.pragma()
+	if err != nil {
+		return err
+	}
+
+	if options.CheckpointInterval > 0 {
+		_, err = writeConn.ExecContext(context.Background(), "PRAGMA wal_autocheckpoint = 0")
+		if err != nil {
+			return fmt.Errorf("error disabling automatic checkpoints - %w", err)
+		}
+	}
+
+	return nil
+}
+
+/*
+startCheckpointer starts a goroutine checkpointing the DB at interval, with mode
+*/
+func startCheckpointer(interval time.Duration, mode CheckpointMode) {
+	checkpointerMutex.Lock()
+	defer checkpointerMutex.Unlock()
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	checkpointerStop = stop
+	checkpointerDone = done
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				err := CheckpointWithMode(mode)
+				if err != nil {
+					logError("error checkpointing DB", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+/*
+stopCheckpointer stops the checkpointer, if running, waiting for it to return. Must be called while the global mutex is
+NOT held
+*/
+func stopCheckpointer() {
+	checkpointerMutex.Lock()
+	stop := checkpointerStop
+	done := checkpointerDone
+	checkpointerStop = nil
+	checkpointerDone = nil
+	checkpointerMutex.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
@@ -0,0 +1,137 @@
+package camellia
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+/* Name of the driver used to open DBs, which sets the encryption key and attaches the other files on every connection */
+const driverName = "camellia_sqlite3"
+
+/* Encryption key of the DB currently being opened or open (empty if not encrypted) */
+var dbKey = ""
+
+func init() {
+	sql.Register(driverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			if dbKey != "" {
+				// The key must be set before any other statement is executed on the connection
+				_, err := conn.Exec(keyPragma("key", dbKey), nil)
+				if err != nil {
+					return err
+				}
+			}
+
+			err := attachDefaults(conn)
+			if err != nil {
+				return err
+			}
+
+			return attachMounts(conn)
+		},
+	})
+}
+
+/*
+RotateKey re-encrypts the whole DB, currently open with oldKey, under newKey.
+
+The DB is re-encrypted atomically, and is reopened with the new key. Fails with ErrInvalidKey if oldKey is not the
+key the DB was opened with, or if the DB is not encrypted.
+*/
+func RotateKey(oldKey string, newKey string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return ErrNoDB
+	}
+
+	if dbKey == "" {
+		return fmt.Errorf("DB is not encrypted - %w", ErrInvalidKey)
+	}
+
+	if oldKey != dbKey {
+		return ErrInvalidKey
+	}
+
+	if newKey == "" {
+		return fmt.Errorf("new key is empty - %w", ErrInvalidKey)
+	}
+
+	err := rekey(newKey)
+	if err != nil {
+		return fmt.Errorf("error re-encrypting DB - %w", err)
+	}
+
+	return nil
+}
+
+func rekey(newKey string) error {
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.ExecContext(context.Background(), keyPragma("rekey", newKey))
+	conn.Close()
+	if err != nil {
+		return err
+	}
+
+	// Pooled connections still use the old key, so the DB is reopened
+	path := dbPath
+	options := dbOptions
+	options.EncryptionKey = newKey
+
+	err = closeDB()
+	if err != nil {
+		return err
+	}
+
+	_, _, err = openDB(path, options, false)
+	if err != nil {
+		atomic.StoreInt32(&initialized, 0)
+		return fmt.Errorf("error reopening DB - %w", err)
+	}
+
+	return nil
+}
+
+func keyPragma(pragma string, key string) string {
+	return fmt.Sprintf("PRAGMA %s = '%s'", pragma, strings.ReplaceAll(key, "'", "''"))
+}
+
+/*
+checkEncryption verifies that the SQLite library in use supports encryption, since any other library silently ignores
+the key pragma
+*/
+func checkEncryption() error {
+	version, err := pragma("PRAGMA cipher_version")
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return wrapKeyError(err)
+	}
+
+	if version == "" {
+		return ErrEncryptionUnsupported
+	}
+
+	return nil
+}
+
+/*
+wrapKeyError reports errors caused by reading an encrypted DB with a wrong (or without a) key as ErrInvalidKey
+*/
+func wrapKeyError(err error) error {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.Code == sqlite3.ErrNotADB {
+		return fmt.Errorf("%v - %w", err, ErrInvalidKey)
+	}
+
+	return err
+}
@@ -0,0 +1,128 @@
+package camellia
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+)
+
+/*
+ErrSkipChildren can be returned by the callback of Entry.Walk to skip the children of the visited Entry.
+*/
+var ErrSkipChildren = errors.New("skip children")
+
+/*
+Get returns the descendant of the Entry at childPath, relative to the Entry, or the Entry itself if childPath is empty.
+Only the loaded children are looked up (see ChildrenLoaded), the DB is never read: a descendant missing from them fails
+with ErrPathNotFound.
+*/
+func (e *Entry) Get(childPath string) (*Entry, error) {
+	entry := e
+	for _, s := range splitPath(childPath) {
+		child, ok := entry.Children[s]
+		if !ok {
+			return nil, &PathError{Op: "get", Path: namespacePath(e.Path, childPath), Err: ErrPathNotFound}
+		}
+
+		entry = child
+	}
+
+	return entry, nil
+}
+
+/*
+Walk calls cb with the Entry and each of its loaded descendants, depth-first, parents before their children, and
+siblings sorted by name. relativePath is the path of the visited Entry relative to the Entry Walk is called on, empty
+for the Entry itself.
+
+If cb returns ErrSkipChildren, the children of the visited Entry are skipped. Any other error stops the walk, and is
+returned by Walk.
+*/
+func (e *Entry) Walk(cb func(relativePath string, entry *Entry) error) error {
+	err := e.walk("", cb)
+	if errors.Is(err, ErrSkipChildren) {
+		return nil
+	}
+
+	return err
+}
+
+func (e *Entry) walk(relativePath string, cb func(relativePath string, entry *Entry) error) error {
+	err := cb(relativePath, e)
+	if errors.Is(err, ErrSkipChildren) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(e.Children))
+	for name := range e.Children {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		p := name
+		if relativePath != "" {
+			p = relativePath + "/" + name
+		}
+
+		err = e.Children[name].walk(p, cb)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+Flatten returns the values of the Entry and of its loaded descendants, keyed by their path relative to the Entry (the
+Entry itself, if it's a value, under the empty path).
+*/
+func (e *Entry) Flatten() map[string]string {
+	values := map[string]string{}
+
+	// The callback never fails
+	_ = e.Walk(func(relativePath string, entry *Entry) error {
+		if entry.IsValue {
+			values[relativePath] = entry.Value
+		}
+
+		return nil
+	})
+
+	return values
+}
+
+/*
+Find returns the loaded descendants of the Entry whose path relative to the Entry matches glob, in the order of Walk.
+Patterns are matched like path.Match does, segment by segment: * and ? never match a /, so "eth0/*" matches
+"eth0/port", but not "eth0/ipv4/port". Malformed patterns fail with path.ErrBadPattern.
+*/
+func (e *Entry) Find(glob string) ([]*Entry, error) {
+	_, err := path.Match(glob, "")
+	if err != nil {
+		return nil, fmt.Errorf("invalid glob %s - %w", glob, err)
+	}
+
+	found := []*Entry{}
+	err = e.Walk(func(relativePath string, entry *Entry) error {
+		if relativePath == "" {
+			return nil
+		}
+
+		ok, _ := path.Match(glob, relativePath)
+		if ok {
+			found = append(found, entry)
+		}
+
+		return nil
+	})
+
+	return found, err
+}
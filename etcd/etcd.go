@@ -0,0 +1,631 @@
+/*
+Package etcd synchronizes a camellia hierarchy with the keys under a prefix of an etcd cluster, one-shot or
+continuously, through the JSON gateway of the etcd v3 API, without depending on the etcd client.
+*/
+package etcd
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	cml "github.com/debevv/camellia"
+)
+
+/*
+Conflict selects what happens to a value existing on both sides with different content.
+
+ConflictOverwrite: the default. The destination is overwritten with the value of the source.
+
+ConflictKeep: the destination is kept, so only the missing values are created.
+*/
+type Conflict int
+
+const (
+	ConflictOverwrite Conflict = 0
+	ConflictKeep      Conflict = 1
+)
+
+/*
+Options configures a Sync.
+
+Endpoint: the base URL of an etcd member, like http://127.0.0.1:2379.
+
+Prefix: the prefix of the etcd keys mapped to Path. A key is the prefix followed by the path of the value relative to
+Path, so with Prefix "/config" the value at Path + "/net/mtu" is the key "/config/net/mtu". Empty maps the whole key
+space.
+
+Path: the camellia path mapped to Prefix, the root if empty.
+
+Conflict: what happens to values existing on both sides, ConflictOverwrite by default.
+
+HTTPClient: the client making the requests, like one configured for TLS. http.DefaultClient if nil.
+
+Token: the etcd authentication token, if the cluster requires one.
+*/
+type Options struct {
+	Endpoint   string
+	Prefix     string
+	Path       string
+	Conflict   Conflict
+	HTTPClient *http.Client
+	Token      string
+}
+
+/*
+Sync synchronizes the hierarchy at a path of the open DB with the keys under a prefix of etcd. See New.
+*/
+type Sync struct {
+	options Options
+	client  *http.Client
+	prefix  string
+}
+
+/*
+Error is an error returned by etcd.
+*/
+type Error struct {
+	Status  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("etcd error %d - %s", e.Status, e.Message)
+}
+
+/*
+New creates a Sync as specified by options. Values are mapped to etcd keys holding their string representation, and
+non-value Entries to the key prefixes of their children: names containing slashes are split in several segments.
+*/
+func New(options Options) (*Sync, error) {
+	if options.Endpoint == "" {
+		return nil, fmt.Errorf("endpoint is empty")
+	}
+
+	if options.Conflict != ConflictOverwrite && options.Conflict != ConflictKeep {
+		return nil, fmt.Errorf("invalid conflict policy %d", int(options.Conflict))
+	}
+
+	client := options.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	prefix := strings.TrimSuffix(options.Prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	options.Endpoint = strings.TrimSuffix(options.Endpoint, "/")
+	options.Path = cml.Path(options.Path).String()
+
+	return &Sync{options: options, client: client, prefix: prefix}, nil
+}
+
+/*
+Import sets the values of the keys under the prefix to the mapped paths, in a single transaction, returning the etcd
+revision they were read at, to continue from with MirrorFrom. With ConflictKeep, existing Entries are not overwritten.
+*/
+func (s *Sync) Import(ctx context.Context) (int64, error) {
+	kvs, revision, err := s.rangeKeys(ctx, s.prefix, true)
+	if err != nil {
+		return 0, err
+	}
+
+	values := map[string]any{}
+	for _, kv := range kvs {
+		segments := s.segments(kv.Key)
+		if len(segments) == 0 {
+			continue
+		}
+
+		node := values
+		for i, segment := range segments {
+			if i == len(segments)-1 {
+				if _, ok := node[segment].(map[string]any); ok {
+					return 0, fmt.Errorf("key %s is both a value and a prefix of other keys", kv.Key)
+				}
+
+				node[segment] = kv.Value
+				break
+			}
+
+			child, ok := node[segment].(map[string]any)
+			if !ok {
+				if _, isValue := node[segment]; isValue {
+					return 0, fmt.Errorf("key %s is below the value of another key", kv.Key)
+				}
+
+				child = map[string]any{}
+				node[segment] = child
+			}
+
+			node = child
+		}
+	}
+
+	if len(values) == 0 {
+		return revision, nil
+	}
+
+	encoded, err := json.Marshal(values)
+	if err != nil {
+		return 0, fmt.Errorf("error encoding values - %w", err)
+	}
+
+	err = cml.ImportJSON(bytes.NewReader(encoded), cml.ImportOptions{
+		OnlyMerge: s.options.Conflict == ConflictKeep,
+		Path:      s.options.Path})
+	if err != nil {
+		return 0, fmt.Errorf("error importing values - %w", err)
+	}
+
+	return revision, nil
+}
+
+/*
+Export puts the values under the path to the mapped keys. With ConflictKeep, existing keys are not overwritten. Keys
+without a corresponding value are left as they are.
+*/
+func (s *Sync) Export(ctx context.Context) error {
+	entry, err := cml.GetEntry(s.options.Path)
+	if errors.Is(err, cml.ErrPathNotFound) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return s.exportEntry(ctx, entry)
+}
+
+func (s *Sync) exportEntry(ctx context.Context, entry *cml.Entry) error {
+	if entry.IsValue {
+		return s.put(ctx, s.key(entry.Path), entry.Value)
+	}
+
+	for _, child := range entry.Children {
+		err := s.exportEntry(ctx, child)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+MirrorTo puts the changes to the values under the path to etcd, as they happen (see camellia.Watch), until ctx is done,
+returning nil. Deleted Entries delete their keys, and the ones below them. Call Export first to put the values existing
+before. Fails on the first request to etcd failing.
+*/
+func (s *Sync) MirrorTo(ctx context.Context) error {
+	changes := make(chan cml.Change, 1024)
+
+	unwatch, err := cml.Watch(s.options.Path, func(change cml.Change) {
+		select {
+		case changes <- change:
+		case <-ctx.Done():
+		}
+	})
+
+	if err != nil {
+		return err
+	}
+
+	defer unwatch()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case change := <-changes:
+			err = s.mirrorChange(ctx, change)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+
+				return err
+			}
+		}
+	}
+}
+
+func (s *Sync) mirrorChange(ctx context.Context, change cml.Change) error {
+	// Changes at the path or above it, like the deletion of a parent, affect the whole prefix
+	if change.Path == s.options.Path || !s.inPath(change.Path) {
+		if change.Type != cml.ChangeDeleted && change.Type != cml.ChangeOverwritten {
+			return nil
+		}
+
+		return s.deleteRange(ctx, s.prefix)
+	}
+
+	key := s.key(change.Path)
+
+	switch change.Type {
+	case cml.ChangeDeleted:
+		return s.deleteKey(ctx, key)
+	case cml.ChangeOverwritten:
+		err := s.deleteKey(ctx, key)
+		if err != nil || !change.IsValue {
+			return err
+		}
+	}
+
+	if !change.IsValue {
+		return nil
+	}
+
+	return s.put(ctx, key, change.Value)
+}
+
+/*
+MirrorFrom applies the changes to the keys under the prefix made after revision, like the one returned by Import, as
+they happen, until ctx is done, returning nil. Deleted keys delete their values. Fails if the watch is canceled by
+etcd, like when revision was compacted.
+*/
+func (s *Sync) MirrorFrom(ctx context.Context, revision int64) error {
+	key, end := s.keyRange(s.prefix)
+	req := map[string]any{"create_request": map[string]any{
+		"key":            key,
+		"range_end":      end,
+		"start_revision": strconv.FormatInt(revision+1, 10)}}
+
+	body, err := s.request(ctx, "/v3/watch", req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		return err
+	}
+
+	defer body.Close()
+
+	decoder := json.NewDecoder(body)
+	for {
+		var res struct {
+			Result struct {
+				Canceled     bool   `json:"canceled"`
+				CancelReason string `json:"cancel_reason"`
+				Events       []struct {
+					Type string `json:"type"`
+					KV   kv     `json:"kv"`
+				} `json:"events"`
+			} `json:"result"`
+			Error *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+
+		err = decoder.Decode(&res)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return fmt.Errorf("error reading watch - %w", err)
+		}
+
+		if res.Error != nil {
+			return &Error{Status: http.StatusOK, Message: res.Error.Message}
+		}
+
+		if res.Result.Canceled {
+			return fmt.Errorf("watch canceled - %s", res.Result.CancelReason)
+		}
+
+		for _, event := range res.Result.Events {
+			err = s.applyEvent(event.Type == "DELETE", event.KV)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *Sync) applyEvent(deleted bool, kv kv) error {
+	key, err := base64.StdEncoding.DecodeString(kv.Key)
+	if err != nil {
+		return fmt.Errorf("invalid key %s - %w", kv.Key, err)
+	}
+
+	segments := s.segments(string(key))
+	if len(segments) == 0 {
+		return nil
+	}
+
+	path := cml.Path(s.options.Path + "/" + strings.Join(segments, "/")).String()
+
+	if deleted {
+		err = cml.Delete(path)
+		if errors.Is(err, cml.ErrPathNotFound) {
+			return nil
+		}
+
+		return err
+	}
+
+	value, err := base64.StdEncoding.DecodeString(kv.Value)
+	if err != nil {
+		return fmt.Errorf("invalid value of key %s - %w", key, err)
+	}
+
+	current, err := cml.Get[string](path)
+	if err == nil && (current == string(value) || s.options.Conflict == ConflictKeep) {
+		return nil
+	}
+
+	if err != nil && !errors.Is(err, cml.ErrPathNotFound) && !errors.Is(err, cml.ErrPathIsNotAValue) {
+		return err
+	}
+
+	if errors.Is(err, cml.ErrPathIsNotAValue) && s.options.Conflict == ConflictKeep {
+		return nil
+	}
+
+	return cml.Force(path, string(value))
+}
+
+/*
+kv is a key-value pair of etcd, with the key and the value base64 encoded
+*/
+type kv struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+/*
+decodedKV is a key-value pair of etcd
+*/
+type decodedKV struct {
+	Key   string
+	Value string
+}
+
+/*
+rangeKeys returns the key-value pairs with the specified prefix, or with the specified key if prefix == false, along with
+the revision of etcd they were read at
+*/
+func (s *Sync) rangeKeys(ctx context.Context, key string, prefix bool) ([]decodedKV, int64, error) {
+	req := map[string]any{"key": base64.StdEncoding.EncodeToString([]byte(key))}
+	if prefix {
+		req["key"], req["range_end"] = s.keyRange(key)
+	}
+
+	var res struct {
+		Header struct {
+			Revision json.Number `json:"revision"`
+		} `json:"header"`
+		KVs []kv `json:"kvs"`
+	}
+
+	err := s.call(ctx, "/v3/kv/range", req, &res)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	revision, err := parseInt(res.Header.Revision)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid revision %s - %w", res.Header.Revision, err)
+	}
+
+	kvs := make([]decodedKV, 0, len(res.KVs))
+	for _, kv := range res.KVs {
+		key, err := base64.StdEncoding.DecodeString(kv.Key)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid key %s - %w", kv.Key, err)
+		}
+
+		value, err := base64.StdEncoding.DecodeString(kv.Value)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid value of key %s - %w", key, err)
+		}
+
+		kvs = append(kvs, decodedKV{Key: string(key), Value: string(value)})
+	}
+
+	return kvs, revision, nil
+}
+
+/*
+put sets key to value, unless it already holds value or, with ConflictKeep, it exists
+*/
+func (s *Sync) put(ctx context.Context, key string, value string) error {
+	kvs, _, err := s.rangeKeys(ctx, key, false)
+	if err != nil {
+		return err
+	}
+
+	if len(kvs) > 0 && (kvs[0].Value == value || s.options.Conflict == ConflictKeep) {
+		return nil
+	}
+
+	put := map[string]any{
+		"key":   base64.StdEncoding.EncodeToString([]byte(key)),
+		"value": base64.StdEncoding.EncodeToString([]byte(value))}
+
+	if s.options.Conflict == ConflictOverwrite {
+		return s.call(ctx, "/v3/kv/put", put, nil)
+	}
+
+	// The key could have been created in the meantime
+	txn := map[string]any{
+		"compare": []any{map[string]any{
+			"key":             put["key"],
+			"target":          "CREATE",
+			"result":          "EQUAL",
+			"create_revision": "0"}},
+		"success": []any{map[string]any{"request_put": put}}}
+
+	return s.call(ctx, "/v3/kv/txn", txn, nil)
+}
+
+/*
+deleteKey deletes key and the keys below it
+*/
+func (s *Sync) deleteKey(ctx context.Context, key string) error {
+	err := s.call(ctx, "/v3/kv/deleterange", map[string]any{"key": base64.StdEncoding.EncodeToString([]byte(key))}, nil)
+	if err != nil {
+		return err
+	}
+
+	return s.deleteRange(ctx, key+"/")
+}
+
+/*
+deleteRange deletes the keys with the specified prefix
+*/
+func (s *Sync) deleteRange(ctx context.Context, prefix string) error {
+	key, end := s.keyRange(prefix)
+	return s.call(ctx, "/v3/kv/deleterange", map[string]any{"key": key, "range_end": end}, nil)
+}
+
+/*
+keyRange returns the base64 encoded range of the keys with the specified prefix, the whole key space if empty
+*/
+func (s *Sync) keyRange(prefix string) (string, string) {
+	if prefix == "" {
+		zero := base64.StdEncoding.EncodeToString([]byte{0})
+		return zero, zero
+	}
+
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			end = end[:i+1]
+			break
+		}
+	}
+
+	return base64.StdEncoding.EncodeToString([]byte(prefix)), base64.StdEncoding.EncodeToString(end)
+}
+
+/*
+key returns the etcd key of the Entry at path
+*/
+func (s *Sync) key(path string) string {
+	rel := strings.TrimPrefix(strings.TrimPrefix(path, s.options.Path), "/")
+	return s.prefix + strings.Join(cml.SplitSegments(rel), "/")
+}
+
+/*
+segments returns the escaped path segments, relative to the path, of the Entry of key
+*/
+func (s *Sync) segments(key string) []string {
+	if !strings.HasPrefix(key, s.prefix) {
+		return nil
+	}
+
+	segments := []string{}
+	for _, name := range strings.Split(strings.TrimPrefix(key, s.prefix), "/") {
+		if name != "" {
+			segments = append(segments, cml.EscapeSegment(name))
+		}
+	}
+
+	return segments
+}
+
+/*
+inPath returns whether the Entry at path is the mapped path or one of its children
+*/
+func (s *Sync) inPath(path string) bool {
+	return s.options.Path == "" || path == s.options.Path || strings.HasPrefix(path, s.options.Path+"/")
+}
+
+/*
+call sends req to the etcd endpoint at api, decoding the response in res, if not nil
+*/
+func (s *Sync) call(ctx context.Context, api string, req any, res any) error {
+	body, err := s.request(ctx, api, req)
+	if err != nil {
+		return err
+	}
+
+	defer body.Close()
+
+	if res == nil {
+		_, err = io.Copy(io.Discard, body)
+		return err
+	}
+
+	decoder := json.NewDecoder(body)
+	decoder.UseNumber()
+
+	err = decoder.Decode(res)
+	if err != nil {
+		return fmt.Errorf("error decoding response of %s - %w", api, err)
+	}
+
+	return nil
+}
+
+/*
+request sends req to the etcd endpoint at api, returning the body of the response, or an Error if the request failed
+*/
+func (s *Sync) request(ctx context.Context, api string, req any) (io.ReadCloser, error) {
+	encoded, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding request - %w", err)
+	}
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, s.options.Endpoint+api, bytes.NewReader(encoded))
+	if err != nil {
+		return nil, err
+	}
+
+	r.Header.Set("Content-Type", "application/json")
+	if s.options.Token != "" {
+		r.Header.Set("Authorization", s.options.Token)
+	}
+
+	res, err := s.client.Do(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return res.Body, nil
+	}
+
+	defer res.Body.Close()
+
+	var jError struct {
+		Message string `json:"message"`
+		Error   string `json:"error"`
+	}
+
+	err = json.NewDecoder(res.Body).Decode(&jError)
+	if err != nil || (jError.Message == "" && jError.Error == "") {
+		return nil, &Error{Status: res.StatusCode, Message: res.Status}
+	}
+
+	if jError.Message == "" {
+		jError.Message = jError.Error
+	}
+
+	return nil, &Error{Status: res.StatusCode, Message: jError.Message}
+}
+
+/*
+parseInt parses an int64 of the etcd API, encoded either as a JSON number or string
+*/
+func parseInt(n json.Number) (int64, error) {
+	if n == "" {
+		return 0, nil
+	}
+
+	return strconv.ParseInt(strings.Trim(string(n), `"`), 10, 64)
+}
@@ -0,0 +1,438 @@
+package etcd
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	cml "github.com/debevv/camellia"
+)
+
+func check(err error, t *testing.T) {
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMain(m *testing.M) {
+	testDBFile, err := os.CreateTemp("", "camellia-etcd")
+	if err != nil {
+		os.Stderr.WriteString("Error creating test DB file")
+		os.Exit(1)
+	}
+
+	testDBPath := testDBFile.Name()
+	testDBFile.Close()
+
+	_, err = cml.Open(testDBPath)
+	if err != nil {
+		os.Exit(1)
+	}
+
+	ret := m.Run()
+
+	err = cml.Close()
+	if err != nil {
+		os.Exit(1)
+	}
+
+	os.Remove(testDBPath)
+
+	os.Exit(ret)
+}
+
+type fakeEvent struct {
+	deleted  bool
+	key      string
+	value    string
+	revision int64
+}
+
+/*
+fakeEtcd implements the subset of the JSON gateway of etcd used by Sync
+*/
+type fakeEtcd struct {
+	mutex    sync.Mutex
+	kvs      map[string]string
+	events   []fakeEvent
+	revision int64
+	changed  chan struct{}
+}
+
+func newFakeEtcd() *fakeEtcd {
+	return &fakeEtcd{kvs: map[string]string{}, revision: 1, changed: make(chan struct{})}
+}
+
+func (e *fakeEtcd) put(key string, value string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.revision++
+	e.kvs[key] = value
+	e.events = append(e.events, fakeEvent{key: key, value: value, revision: e.revision})
+	close(e.changed)
+	e.changed = make(chan struct{})
+}
+
+func (e *fakeEtcd) delete(key string, end string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	for _, k := range e.keys(key, end) {
+		e.revision++
+		delete(e.kvs, k)
+		e.events = append(e.events, fakeEvent{deleted: true, key: k, revision: e.revision})
+	}
+
+	close(e.changed)
+	e.changed = make(chan struct{})
+}
+
+func (e *fakeEtcd) get(key string) (string, bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	v, ok := e.kvs[key]
+	return v, ok
+}
+
+func (e *fakeEtcd) currentRevision() int64 {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return e.revision
+}
+
+func (e *fakeEtcd) len() int {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return len(e.kvs)
+}
+
+func inRange(k string, key string, end string) bool {
+	if end == "" {
+		return k == key
+	}
+
+	return k >= key && (end == "\x00" || k < end)
+}
+
+func (e *fakeEtcd) keys(key string, end string) []string {
+	keys := []string{}
+	for k := range e.kvs {
+		if inRange(k, key, end) {
+			keys = append(keys, k)
+		}
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+func decode(s string) string {
+	b, _ := base64.StdEncoding.DecodeString(s)
+	return string(b)
+}
+
+func encode(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+func (e *fakeEtcd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Key      string `json:"key"`
+		RangeEnd string `json:"range_end"`
+		Value    string `json:"value"`
+		Compare  []struct {
+			Key string `json:"key"`
+		} `json:"compare"`
+		Success []struct {
+			RequestPut struct {
+				Key   string `json:"key"`
+				Value string `json:"value"`
+			} `json:"request_put"`
+		} `json:"success"`
+		CreateRequest struct {
+			Key           string `json:"key"`
+			RangeEnd      string `json:"range_end"`
+			StartRevision string `json:"start_revision"`
+		} `json:"create_request"`
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": "invalid request", "code": 3, "message": "invalid request"}`))
+		return
+	}
+
+	switch r.URL.Path {
+	case "/v3/kv/range":
+		e.mutex.Lock()
+		kvs := []map[string]string{}
+		for _, k := range e.keys(decode(req.Key), decode(req.RangeEnd)) {
+			kvs = append(kvs, map[string]string{"key": encode(k), "value": encode(e.kvs[k])})
+		}
+
+		res := map[string]any{"header": map[string]string{"revision": strconv.FormatInt(e.revision, 10)}}
+		if len(kvs) > 0 {
+			res["kvs"] = kvs
+		}
+
+		e.mutex.Unlock()
+		json.NewEncoder(w).Encode(res)
+	case "/v3/kv/put":
+		e.put(decode(req.Key), decode(req.Value))
+		w.Write([]byte(`{}`))
+	case "/v3/kv/txn":
+		_, exists := e.get(decode(req.Compare[0].Key))
+		if !exists {
+			e.put(decode(req.Success[0].RequestPut.Key), decode(req.Success[0].RequestPut.Value))
+		}
+
+		w.Write([]byte(`{}`))
+	case "/v3/kv/deleterange":
+		e.delete(decode(req.Key), decode(req.RangeEnd))
+		w.Write([]byte(`{}`))
+	case "/v3/watch":
+		key, end := decode(req.CreateRequest.Key), decode(req.CreateRequest.RangeEnd)
+		revision, _ := strconv.ParseInt(req.CreateRequest.StartRevision, 10, 64)
+
+		json.NewEncoder(w).Encode(map[string]any{"result": map[string]any{"created": true}})
+		w.(http.Flusher).Flush()
+
+		for {
+			e.mutex.Lock()
+			events := []map[string]any{}
+			for _, event := range e.events {
+				if event.revision < revision || !inRange(event.key, key, end) {
+					continue
+				}
+
+				kv := map[string]string{"key": encode(event.key)}
+				if event.deleted {
+					events = append(events, map[string]any{"type": "DELETE", "kv": kv})
+				} else {
+					kv["value"] = encode(event.value)
+					events = append(events, map[string]any{"kv": kv})
+				}
+			}
+
+			revision = e.revision + 1
+			changed := e.changed
+			e.mutex.Unlock()
+
+			if len(events) > 0 {
+				json.NewEncoder(w).Encode(map[string]any{"result": map[string]any{"events": events}})
+				w.(http.Flusher).Flush()
+			}
+
+			select {
+			case <-r.Context().Done():
+				return
+			case <-changed:
+			}
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": "not found", "code": 5, "message": "Not Found"}`))
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	for i := 0; i < 200; i++ {
+		if cond() {
+			return
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("Timeout waiting for %s", what)
+}
+
+func TestImportExport(t *testing.T) {
+	e := newFakeEtcd()
+	ts := httptest.NewServer(e)
+	defer ts.Close()
+
+	defer cml.Wipe()
+
+	e.put("/config/net/mtu", "1500")
+	e.put("/config/net/hostname", "device-01")
+	e.put("/config/url/http:", "a")
+	e.put("/other/key", "b")
+
+	t.Log("Should fail with an empty endpoint")
+
+	_, err := New(Options{})
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+
+	t.Log("Should import the keys under the prefix")
+
+	s, err := New(Options{Endpoint: ts.URL, Prefix: "/config/", Path: "etcd"})
+	check(err, t)
+
+	revision, err := s.Import(context.Background())
+	check(err, t)
+
+	if revision != 5 {
+		t.Fatalf("Expected revision 5, got %d", revision)
+	}
+
+	mtu, err := cml.Get[int]("etcd/net/mtu")
+	check(err, t)
+
+	if mtu != 1500 {
+		t.Fatalf("Expected 1500, got %d", mtu)
+	}
+
+	exists, err := cml.Exists("etcd/key")
+	check(err, t)
+
+	if exists {
+		t.Fatal("Expected key outside prefix not to be imported")
+	}
+
+	t.Log("Should keep existing values with ConflictKeep")
+
+	check(cml.Set("etcd/net/mtu", 9000), t)
+
+	keep, err := New(Options{Endpoint: ts.URL, Prefix: "/config", Path: "etcd", Conflict: ConflictKeep})
+	check(err, t)
+
+	_, err = keep.Import(context.Background())
+	check(err, t)
+
+	mtu, err = cml.Get[int]("etcd/net/mtu")
+	check(err, t)
+
+	if mtu != 9000 {
+		t.Fatalf("Expected 9000, got %d", mtu)
+	}
+
+	t.Log("Should export the values, keeping existing keys with ConflictKeep")
+
+	check(cml.Set("etcd/net/gateway", "192.168.1.1"), t)
+
+	err = keep.Export(context.Background())
+	check(err, t)
+
+	if v, _ := e.get("/config/net/mtu"); v != "1500" {
+		t.Fatalf("Expected 1500, got %s", v)
+	}
+
+	if v, _ := e.get("/config/net/gateway"); v != "192.168.1.1" {
+		t.Fatalf("Expected 192.168.1.1, got %s", v)
+	}
+
+	t.Log("Should export the values, overwriting existing keys")
+
+	err = s.Export(context.Background())
+	check(err, t)
+
+	if v, _ := e.get("/config/net/mtu"); v != "9000" {
+		t.Fatalf("Expected 9000, got %s", v)
+	}
+
+	t.Log("Should fail importing a key that is both a value and a prefix")
+
+	e.put("/config/net/mtu/sub", "x")
+
+	_, err = s.Import(context.Background())
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+
+	t.Log("Should return the errors of etcd")
+
+	_, err = (&Sync{options: Options{Endpoint: ts.URL + "/missing"}, client: http.DefaultClient}).Import(
+		context.Background())
+
+	var etcdErr *Error
+	if !errors.As(err, &etcdErr) || etcdErr.Status != http.StatusNotFound {
+		t.Fatalf("Expected etcd error, got %v", err)
+	}
+}
+
+func TestMirror(t *testing.T) {
+	e := newFakeEtcd()
+	ts := httptest.NewServer(e)
+	defer ts.Close()
+
+	defer cml.Wipe()
+
+	s, err := New(Options{Endpoint: ts.URL, Prefix: "/mirror", Path: "mirror"})
+	check(err, t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	toDone := make(chan error, 1)
+	go func() {
+		toDone <- s.MirrorTo(ctx)
+	}()
+
+	t.Log("Should mirror the changes to etcd")
+
+	// MirrorTo starts watching asynchronously
+	i := 0
+	waitFor(t, "mirror to etcd", func() bool {
+		i++
+		check(cml.Set("mirror/a/b", i), t)
+		time.Sleep(10 * time.Millisecond)
+		v, _ := e.get("/mirror/a/b")
+		return v == strconv.Itoa(i)
+	})
+
+	check(cml.Set("mirror/a/c", "v2"), t)
+	waitFor(t, "mirrored value", func() bool {
+		v, _ := e.get("/mirror/a/c")
+		return v == "v2"
+	})
+
+	check(cml.Delete("mirror/a"), t)
+	waitFor(t, "mirrored deletion", func() bool {
+		return e.len() == 0
+	})
+
+	t.Log("Should mirror the changes from etcd")
+
+	revision := e.currentRevision()
+	fromDone := make(chan error, 1)
+	go func() {
+		fromDone <- s.MirrorFrom(ctx, revision)
+	}()
+
+	e.put("/mirror/x/y", "v3")
+	waitFor(t, "mirror from etcd", func() bool {
+		v, err := cml.Get[string]("mirror/x/y")
+		return err == nil && v == "v3"
+	})
+
+	e.delete("/mirror/x/y", "")
+	waitFor(t, "mirrored deletion from etcd", func() bool {
+		exists, err := cml.Exists("mirror/x/y")
+		return err == nil && !exists
+	})
+
+	t.Log("Should stop mirroring when the context is done")
+
+	cancel()
+
+	check(<-toDone, t)
+	check(<-fromDone, t)
+}
@@ -0,0 +1,173 @@
+package camellia
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+polledRevision is the last revision of the change log seen by the poller, and ownRevisions the revisions committed
+by this process after it, whose changes were already dispatched. Both are accessed while the global mutex is held
+*/
+var polledRevision uint64
+var ownRevisions = map[uint64]struct{}{}
+
+var pollerMutex sync.Mutex
+var pollerStop chan struct{}
+var pollerDone chan struct{}
+
+/*
+startPoller starts polling the change log for external changes (see Options.PollExternalChanges), from the current
+revision. Must be called while the global mutex is held
+*/
+func startPoller(interval time.Duration) error {
+	tx, err := beginTx()
+	if err != nil {
+		return fmt.Errorf("error beginning transaction - %w", err)
+	}
+
+	revision, err := getRevision(tx)
+	if err != nil {
+		rollbackTx(tx)
+		return err
+	}
+
+	err = commitTx(tx)
+	if err != nil {
+		rollbackTx(tx)
+		return fmt.Errorf("error committing transaction - %w", err)
+	}
+
+	polledRevision = revision
+	ownRevisions = map[uint64]struct{}{}
+
+	pollerMutex.Lock()
+	defer pollerMutex.Unlock()
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	pollerStop = stop
+	pollerDone = done
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				dispatchExternalChanges(pollExternalChanges())
+			}
+		}
+	}()
+
+	return nil
+}
+
+/*
+stopPoller stops the poller, if running, waiting for it to return. Must be called while the global mutex is NOT held
+*/
+func stopPoller() {
+	pollerMutex.Lock()
+	stop := pollerStop
+	done := pollerDone
+	pollerStop = nil
+	pollerDone = nil
+	pollerMutex.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+/*
+pollExternalChanges returns the changes committed by other processes since the last poll
+*/
+func pollExternalChanges() []Change {
+	// Locked for writing, since the poll state is shared with commitTx
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return nil
+	}
+
+	tx, err := beginReadTx(context.Background())
+	if err != nil {
+		logWarn("error polling external changes", "error", err)
+		return nil
+	}
+
+	revision, err := getRevision(tx)
+	if err != nil || revision == polledRevision {
+		rollbackTx(tx)
+		return nil
+	}
+
+	// Same transaction, so the events are up to revision
+	events, _, err := getEvents("", polledRevision, tx)
+	if errors.Is(err, ErrRevisionCompacted) {
+		logWarn("external changes missed, change log compacted", "from", polledRevision, "to", revision)
+		events = nil
+		err = nil
+	}
+
+	if err != nil {
+		rollbackTx(tx)
+		logWarn("error polling external changes", "error", err)
+		return nil
+	}
+
+	err = endReadTx(context.Background(), tx)
+	if err != nil {
+		rollbackTx(tx)
+		logWarn("error polling external changes", "error", err)
+		return nil
+	}
+
+	changes := []Change{}
+	for _, e := range events {
+		if _, own := ownRevisions[e.Revision]; !own {
+			changes = append(changes, e.Change)
+		}
+	}
+
+	for r := range ownRevisions {
+		if r <= revision {
+			delete(ownRevisions, r)
+		}
+	}
+
+	polledRevision = revision
+
+	return changes
+}
+
+/*
+dispatchExternalChanges dispatches changes committed by other processes to the watchers and the post set hooks
+*/
+func dispatchExternalChanges(changes []Change) {
+	if len(changes) == 0 {
+		return
+	}
+
+	notifyWatchers(changes)
+
+	for _, c := range changes {
+		if c.IsValue && c.Type != ChangeDeleted {
+			err := callPostSetHooks(c.Path, c.Value)
+			if err != nil {
+				logError("post set hook failed on external change", "path", c.Path, "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package camellia
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+FlagBinding tracks the command line flags registered for the values under a path. See BindFlags.
+*/
+type FlagBinding struct {
+	prefix string
+	mutex  sync.Mutex
+	set    map[string]*flagValue
+}
+
+/*
+flagValue is the flag.Value of the value at path, of type valueType, holding the string set on the command line
+*/
+type flagValue struct {
+	binding   *FlagBinding
+	path      string
+	valueType ValueType
+	value     string
+}
+
+func (v *flagValue) String() string {
+	if v == nil {
+		return ""
+	}
+
+	return v.value
+}
+
+func (v *flagValue) Set(s string) error {
+	err := setFlagValue(v.path, s, v.valueType, false)
+	if err != nil {
+		return err
+	}
+
+	v.value = s
+
+	v.binding.mutex.Lock()
+	v.binding.set[v.path] = v
+	v.binding.mutex.Unlock()
+
+	return nil
+}
+
+func (v *flagValue) IsBoolFlag() bool {
+	return v.valueType == TypeBool
+}
+
+/*
+BindFlags registers a flag in fs for each value under the specified path, so that CLIs built around camellia get a
+flag to override each setting with no glue code. Flags are named after the path of their value relative to prefix,
+with segments separated by dots, so "server/tls/port" is bound to -tls.port with prefix "server". Their default is the
+value found when BindFlags is called.
+
+Values set on the command line are validated and applied, when fs is parsed, as runtime values (see SetRuntime): they
+override the persistent ones until Close, without modifying them. Call Persist to write them to the DB instead.
+
+Typed values only accept values of their type, and boolean ones are set by flags without values, like -verbose. Lists,
+binary values and streams are not bound.
+*/
+func BindFlags(fs *flag.FlagSet, prefix string) (*FlagBinding, error) {
+	prefix = normalizePath(prefix)
+
+	entry, err := GetEntryDepth(prefix, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &FlagBinding{prefix: prefix, set: map[string]*flagValue{}}
+
+	values := []*Entry{}
+	collectFlagValues(entry, &values)
+
+	for _, e := range values {
+		name := strings.Join(SplitSegments(strings.TrimPrefix(strings.TrimPrefix(e.Path, prefix), "/")), ".")
+		if name == "" {
+			name = UnescapeSegment(namePath(e.Path))
+		}
+
+		if fs.Lookup(name) != nil {
+			return nil, fmt.Errorf("flag %s of path %s already defined", name, e.Path)
+		}
+
+		fs.Var(&flagValue{binding: b, path: e.Path, valueType: e.Type, value: e.Value}, name, "sets "+e.Path)
+	}
+
+	return b, nil
+}
+
+/*
+collectFlagValues appends to values the value Entries of the tree at entry that can be bound to flags, sorted by path
+*/
+func collectFlagValues(entry *Entry, values *[]*Entry) {
+	if entry.IsValue {
+		switch entry.Type {
+		case TypeList, TypeBytes, TypeStream:
+		default:
+			*values = append(*values, entry)
+		}
+
+		return
+	}
+
+	names := make([]string, 0, len(entry.Children))
+	for name := range entry.Children {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		collectFlagValues(entry.Children[name], values)
+	}
+}
+
+/*
+Persist writes the values set on the command line to the DB, removing the runtime values they were applied as.
+*/
+func (b *FlagBinding) Persist() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	paths := make([]string, 0, len(b.set))
+	for path := range b.set {
+		paths = append(paths, path)
+	}
+
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		v := b.set[path]
+
+		err := setFlagValue(path, v.value, v.valueType, true)
+		if err != nil {
+			return fmt.Errorf("error persisting flag of path %s - %w", path, err)
+		}
+
+		err = DeleteRuntime(path)
+		if err != nil {
+			return err
+		}
+
+		delete(b.set, path)
+	}
+
+	return nil
+}
+
+/*
+Changed returns the paths of the values set on the command line and not yet persisted, sorted.
+*/
+func (b *FlagBinding) Changed() []string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	paths := make([]string, 0, len(b.set))
+	for path := range b.set {
+		paths = append(paths, path)
+	}
+
+	sort.Strings(paths)
+
+	return paths
+}
+
+/*
+setFlagValue converts s to valueType and sets it at path, as a runtime value or, if persist == true, in the DB
+*/
+func setFlagValue(path string, s string, valueType ValueType, persist bool) error {
+	switch valueType {
+	case TypeInt:
+		v, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w - %s is not an int", ErrTypeMismatch, s)
+		}
+
+		return setFlagTyped(path, v, persist)
+	case TypeFloat:
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("%w - %s is not a float", ErrTypeMismatch, s)
+		}
+
+		return setFlagTyped(path, v, persist)
+	case TypeBool:
+		v, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("%w - %s is not a bool", ErrTypeMismatch, s)
+		}
+
+		return setFlagTyped(path, v, persist)
+	default:
+		return setFlagTyped(path, s, persist)
+	}
+}
+
+func setFlagTyped[T Stringable](path string, value T, persist bool) error {
+	if persist {
+		return Set(path, value)
+	}
+
+	return SetRuntime(path, value)
+}
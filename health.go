@@ -0,0 +1,73 @@
+package camellia
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const metaHealthProbe = "health_probe"
+
+/*
+HealthReport is the result of Health.
+
+Revision: the current revision of the DB. Writable: whether a write could be performed on the DB. WALSize: the size,
+in bytes, of the write-ahead log of the DB, 0 if the DB does not use one. FreeSpace: the bytes available to the
+process on the file system of the DB, 0 if they can't be determined.
+*/
+type HealthReport struct {
+	Path      string
+	Revision  uint64
+	Writable  bool
+	WALSize   int64
+	FreeSpace uint64
+}
+
+/*
+Health checks that the DB is usable, with a cheap read and a write that is rolled back, so that nothing is written to
+the storage, and reports the state of the DB and of its file system.
+
+The report is filled as much as possible also when the DB is unhealthy, in which case the error of the failed probe
+is returned.
+*/
+func Health() (HealthReport, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return HealthReport{}, ErrNoDB
+	}
+
+	report := HealthReport{Path: dbPath}
+
+	info, err := os.Stat(dbPath + "-wal")
+	if err == nil {
+		report.WALSize = info.Size()
+	}
+
+	report.FreeSpace = freeSpace(filepath.Dir(dbPath))
+
+	tx, err := beginTx()
+	if err != nil {
+		return report, fmt.Errorf("error beginning transaction - %w", err)
+	}
+
+	defer rollbackTx(tx)
+
+	report.Revision, err = getRevision(tx)
+	if err != nil {
+		return report, err
+	}
+
+	err = setMeta(metaHealthProbe, strconv.FormatInt(time.Now().UnixMilli(), 10), tx)
+	if err != nil {
+		return report, fmt.Errorf("error writing DB - %w", err)
+	}
+
+	report.Writable = true
+
+	return report, nil
+}
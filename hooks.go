@@ -1,7 +1,9 @@
 package camellia
 
 import (
+	"context"
 	"fmt"
+	"sort"
 	"sync"
 	"sync/atomic"
 )
@@ -13,18 +15,93 @@ const (
 	hookTypePost hookType = 2
 )
 
+func (t hookType) String() string {
+	switch t {
+	case hookTypePre:
+		return "pre"
+	case hookTypePost:
+		return "post"
+	default:
+		return "unknown"
+	}
+}
+
 type hook struct {
+	// Accessed atomically, kept first for 64-bit alignment on 32-bit platforms
+	invocations uint64
+	errors      uint64
+
 	callback func(path string, value string) error
 	async    bool
 	hT       hookType
 }
 
+/*
+HookInfo describes a hook registered with SetPreSetHook or SetPostSetHook, as returned by ListHooks.
+
+Type is "pre" or "post". Invocations counts the calls of the hook since it was registered, and Errors the ones that
+returned an error.
+*/
+type HookInfo struct {
+	Path        string `json:"path"`
+	Type        string `json:"type"`
+	Async       bool   `json:"async"`
+	Invocations uint64 `json:"invocations"`
+	Errors      uint64 `json:"errors"`
+}
+
 var hooksEnabled = uint32(1)
 var hooksEmpty = uint32(1)
 
 var hooks = map[hookType]map[string][]*hook{}
 var hooksMutex sync.Mutex
 
+/*
+asyncHooks tracks the asynchronous hooks running, for WaitAsyncHooks
+*/
+var asyncHooks sync.WaitGroup
+
+/*
+GetHooksEnabled returns whether hooks are called, as set with SetHooksEnabled.
+*/
+func GetHooksEnabled() bool {
+	return atomic.LoadUint32(&hooksEnabled) == 1
+}
+
+/*
+ListHooks returns the hooks registered, sorted by path, with the pre set hooks of a path first, then in the order they
+were registered. Hooks are registered on exact paths, and are not called on the children of their path.
+*/
+func ListHooks() []HookInfo {
+	hooksMutex.Lock()
+	defer hooksMutex.Unlock()
+
+	infos := []HookInfo{}
+	for _, hT := range []hookType{hookTypePre, hookTypePost} {
+		for path, pathHooks := range hooks[hT] {
+			for _, h := range pathHooks {
+				infos = append(infos, HookInfo{
+					Path:        path,
+					Type:        hT.String(),
+					Async:       h.async,
+					Invocations: atomic.LoadUint64(&h.invocations),
+					Errors:      atomic.LoadUint64(&h.errors)})
+			}
+		}
+	}
+
+	// The order of registration is kept within each path and type
+	sort.SliceStable(infos, func(i, j int) bool {
+		if infos[i].Path != infos[j].Path {
+			return infos[i].Path < infos[j].Path
+		}
+
+		return infos[i].Type == hookTypePre.String() && infos[j].Type != hookTypePre.String()
+	})
+
+	return infos
+}
+
 func SetHooksEnabled(enabled bool) {
 	if enabled {
 		atomic.StoreUint32(&hooksEnabled, 1)
@@ -57,7 +134,8 @@ SetPreSetHook registers a callback to be called after the value at the specified
 If async == false, if one of the registered callbacks on a path returns an error,
 the setting of the value at that path fails.
 
-If async == true, the registered callback will be called inside a new goroutine, and its returned error is ignored.
+If async == true, the registered callback will be called inside a new goroutine, and its returned error (or panic) is
+only reported to the Logger (see SetLogger).
 
 Callback are always called in the same order as they were registered.
 */
@@ -84,9 +162,12 @@ func callHooks(path string, value string, hT hookType) error {
 	if hooks[hT] != nil && hooks[hT][path] != nil {
 		for i, h := range hooks[hT][path] {
 			if h != nil {
+				atomic.AddUint64(&h.invocations, 1)
+
 				if !h.async {
 					err := h.callback(path, value)
 					if err != nil {
+						atomic.AddUint64(&h.errors, 1)
 						switch hT {
 						case hookTypePre:
 							return fmt.Errorf("error calling pre set hook %d - %w", i, err)
@@ -97,7 +178,8 @@ func callHooks(path string, value string, hT hookType) error {
 						}
 					}
 				} else {
-					go h.callback(path, value)
+					asyncHooks.Add(1)
+					go callAsyncHook(h, path, value)
 				}
 			}
 		}
@@ -106,11 +188,43 @@ func callHooks(path string, value string, hT hookType) error {
 	return nil
 }
 
+/*
+WaitAsyncHooks waits for the asynchronous post set hooks still running to return, or for ctx to be done, in which case
+the error of ctx is returned. Useful to flush the hooks before closing the DB.
+*/
+func WaitAsyncHooks(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		asyncHooks.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func callAsyncHook(h *hook, path string, value string) {
+	defer asyncHooks.Done()
+	defer recoverCallback("async post set hook", path)
+
+	err := h.callback(path, value)
+	if err != nil {
+		atomic.AddUint64(&h.errors, 1)
+		logError("async post set hook failed", "path", path, "error", err)
+	}
+}
+
 func wipeHooks() {
 	hooksMutex.Lock()
 	defer hooksMutex.Unlock()
 
 	hooks = map[hookType]map[string][]*hook{}
+	wipeWatchers()
+	wipeValidators()
 }
 
 func callPreSetHooks(path string, value string) error {
@@ -121,6 +235,36 @@ func callPostSetHooks(path string, value string) error {
 	return callHooks(path, value, hookTypePost)
 }
 
+/*
+callImportedPreSetHooks calls the pre set hooks of the values set by changes, stopping at the first failing one
+*/
+func callImportedPreSetHooks(changes []Change) error {
+	for _, c := range changes {
+		if c.IsValue && c.Type != ChangeDeleted {
+			err := callPreSetHooks(c.Path, c.Value)
+			if err != nil {
+				return &PathError{Op: "import", Path: c.Path, Err: err}
+			}
+		}
+	}
+
+	return nil
+}
+
+/*
+callImportedPostSetHooks calls the post set hooks of the values set by changes, once committed. Failures are logged
+*/
+func callImportedPostSetHooks(changes []Change) {
+	for _, c := range changes {
+		if c.IsValue && c.Type != ChangeDeleted {
+			err := callPostSetHooks(c.Path, c.Value)
+			if err != nil {
+				logError("post set hook failed on imported value", "path", c.Path, "error", err)
+			}
+		}
+	}
+}
+
 func setHook(path string, callback func(path string, value string) error, async bool, hT hookType) error {
 	if hooks[hT] == nil {
 		hooks[hT] = make(map[string][]*hook)
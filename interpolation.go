@@ -0,0 +1,158 @@
+package camellia
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+/*
+interpolationEnabled is 1 if references between values are resolved (see SetInterpolationEnabled)
+*/
+var interpolationEnabled uint32
+
+/*
+SetInterpolationEnabled enables or disables the resolution of references between values, disabled by default.
+
+When enabled, a reference "${path}" found in a string or untyped value is replaced, when the value is read, with the
+value at path, so that shared base values are written once:
+
+	camellia.Set("network/hostname", "device-01")
+	camellia.Set("mqtt/client_id", "${network/hostname}-mqtt")
+	camellia.SetInterpolationEnabled(true)
+	id, err := camellia.Get[string]("mqtt/client_id") // "device-01-mqtt"
+
+A value made of a single reference takes the type of the referenced value, so it can be read as an int or a bool. The
+referenced values are resolved in turn, failing with ErrReferenceCycle if a value refers back to itself. "$${" is
+read as a literal "${".
+
+References are resolved by Get and its variants, GetEntry, LoadChildren and GetStruct, failing if a referenced path
+does not exist or is not a value. Values are stored, exported and read inside transactions as they are.
+*/
+func SetInterpolationEnabled(enabled bool) {
+	if enabled {
+		atomic.StoreUint32(&interpolationEnabled, 1)
+	} else {
+		atomic.StoreUint32(&interpolationEnabled, 0)
+	}
+}
+
+/*
+hasReferences returns whether the references in a value of type valueType must be resolved
+*/
+func hasReferences(value string, valueType ValueType) bool {
+	return atomic.LoadUint32(&interpolationEnabled) == 1 && (valueType == TypeUntyped || valueType == TypeString) &&
+		strings.Contains(value, "${")
+}
+
+/*
+resolveReferences returns the value at path, of type valueType, with its references resolved. Must be called while the
+global mutex is held
+*/
+func resolveReferences(path string, value string, valueType ValueType, tx *sql.Tx) (string, ValueType, error) {
+	if !hasReferences(value, valueType) {
+		return value, valueType, nil
+	}
+
+	return interpolate(value, valueType, tx, []string{path})
+}
+
+/*
+interpolateEntry resolves the references in the values of the tree at entry
+*/
+func interpolateEntry(entry *Entry, tx *sql.Tx) error {
+	if entry.IsValue {
+		var err error
+		entry.Value, entry.Type, err = resolveReferences(entry.Path, entry.Value, entry.Type, tx)
+		return err
+	}
+
+	for _, child := range entry.Children {
+		err := interpolateEntry(child, tx)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+interpolate replaces the references in value with the values they refer to. stack holds the paths being resolved, to
+detect cycles
+*/
+func interpolate(value string, valueType ValueType, tx *sql.Tx, stack []string) (string, ValueType, error) {
+	if strings.HasPrefix(value, "${") && strings.IndexByte(value, '}') == len(value)-1 {
+		return resolveReference(normalizePath(value[2:len(value)-1]), tx, stack)
+	}
+
+	resolved := strings.Builder{}
+	for i := 0; i < len(value); {
+		if strings.HasPrefix(value[i:], "$${") {
+			resolved.WriteString("${")
+			i += 3
+			continue
+		}
+
+		if !strings.HasPrefix(value[i:], "${") {
+			resolved.WriteByte(value[i])
+			i++
+			continue
+		}
+
+		end := strings.IndexByte(value[i+2:], '}')
+		if end < 0 {
+			resolved.WriteString(value[i:])
+			break
+		}
+
+		refValue, _, err := resolveReference(normalizePath(value[i+2:i+2+end]), tx, stack)
+		if err != nil {
+			return "", "", err
+		}
+
+		resolved.WriteString(refValue)
+		i += end + 3
+	}
+
+	return resolved.String(), valueType, nil
+}
+
+/*
+resolveReference reads the value referenced by path, with its references resolved
+*/
+func resolveReference(path string, tx *sql.Tx, stack []string) (string, ValueType, error) {
+	for _, p := range stack {
+		if p == path {
+			return "", "", fmt.Errorf("%w - %s -> %s", ErrReferenceCycle, strings.Join(stack, " -> "), path)
+		}
+	}
+
+	value, valueType, err := getReferencedValue(path, tx)
+	if err != nil {
+		return "", "", fmt.Errorf("error resolving reference to %s - %w", path, err)
+	}
+
+	if !hasReferences(value, valueType) {
+		return value, valueType, nil
+	}
+
+	return interpolate(value, valueType, tx, append(stack, path))
+}
+
+/*
+getReferencedValue reads the value at path as Get would, without resolving its references
+*/
+func getReferencedValue(path string, tx *sql.Tx) (string, ValueType, error) {
+	value, valueType, ok, err := getRuntimeValue(path)
+	if ok {
+		return value, valueType, err
+	}
+
+	if b, ok := buffered[path]; ok {
+		return b.value, b.valueType, nil
+	}
+
+	return getTypedValue(path, tx)
+}
@@ -1,20 +1,119 @@
 package camellia
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"sort"
+	"strconv"
+	"strings"
 	"sync/atomic"
 	"time"
 )
 
 const (
 	propValue      = "value"
+	propType       = "type"
 	propChildren   = "children"
 	propLastUpdate = "last_update_ms"
+	propWriter     = "writer"
+	propRevision   = "revision"
 )
 
+/*
+ExportOptions controls the JSON representation produced by ExportJSON.
+
+With Extended == true, Entries are exported in the extended JSON format, otherwise in the default one.
+
+With Canonical == true, volatile properties (like last_update_ms) are omitted from the extended format, so that two
+identical hierarchies always produce byte-identical output. Keys are always sorted and indented in the same way, so
+the default format is canonical regardless of this option.
+
+With NativeTypes == true, values tagged as numbers or booleans are written in the default format as their native JSON
+type, instead of as strings. Null values are always written as JSON nulls.
+
+With Runtime == true, the runtime values (see SetRuntime) are exported too, shadowing the persistent Entries at their
+paths. Otherwise, only the persistent Entries are exported.
+
+Include and Exclude, if not empty, select the Entries to export by their path relative to the exported one, with
+globs following the syntax of path.Match segment by segment, where a final "**" segment matches any number of
+segments, like in RegisterProvider. The Entries matching an Exclude glob are skipped, along with their descendants.
+With Include, only the Entries matching an Include glob are exported, along with their descendants, and the non-value
+Entries leading to them, even if nothing below them matches. Entries are selected while the hierarchy is read, so
+the skipped ones are never read from the DB:
+
+	err := camellia.ExportJSON("", w, camellia.ExportOptions{Exclude: []string{"secrets/**", "credentials"}})
+
+With Gzip == true, the representation is compressed with gzip while it's written. Imports detect compressed
+representations by their magic bytes, and decompress them transparently.
+*/
+type ExportOptions struct {
+	Extended    bool
+	Canonical   bool
+	NativeTypes bool
+	Runtime     bool
+	Include     []string
+	Exclude     []string
+	Gzip        bool
+
+	// Path of the exported Entry, which Include and Exclude are relative to
+	root string
+}
+
+/*
+ImportOptions controls how ImportJSON reads and applies a JSON representation.
+
+With Extended == true, the input is read in the extended JSON format, otherwise in the default one.
+
+With OnlyMerge == true, Entries already existing in the DB are not overwritten.
+
+Writer, if not empty, is recorded as the writer of the imported Entries, instead of the one set with SetWriter.
+
+Path, if not empty, is the path of the Entry the representation is imported at, instead of the root.
+
+With NativeTypes == true, numbers, booleans and nulls found in the default JSON format are stored along with their type
+tag, so that they can be exported back as their original JSON type. Otherwise, they are stored as untyped strings.
+Strings are always tagged as strings, and nulls as null values.
+
+With FireHooks == true, the hooks of the imported values are called, otherwise they are skipped. Only the values
+actually changed by the import are considered: their pre set hooks are called once the whole representation is read,
+before committing, and a failing one aborts the import, while their post set hooks are called after committing, in
+order. Hooks are never called by dry runs.
+
+Filter, if not nil, is called with the path and the value of each imported value, before it is written (see
+ImportFilter), allowing to sanitize third-party representations while importing them.
+
+Representations compressed with gzip (see ExportOptions.Gzip) are detected by their magic bytes, and decompressed
+transparently.
+*/
+type ImportOptions struct {
+	Extended    bool
+	OnlyMerge   bool
+	NativeTypes bool
+	Writer      string
+	Path        string
+	FireHooks   bool
+	Filter      ImportFilter
+}
+
+/*
+ImportFilter is called by the import functions with the path and the value of each imported value, before it is
+written. It returns the value to import in its place, which keeps the type of the original one, and whether to import
+it at all: returning false skips the value, leaving the DB untouched at its path. A non-nil error rejects the whole
+import, which fails with a PathError wrapping it. Non-value Entries are not passed to the filter, and are imported only
+if they have children, or are empty in the representation.
+
+The filter is called while the DB is locked, so it can't call the functions of the package.
+*/
+type ImportFilter func(path string, value string) (string, bool, error)
+
 func (e *Entry) UnmarshalJSON(b []byte) error {
 	jEntry := make(map[string]interface{})
 	if err := json.Unmarshal(b, &jEntry); err != nil {
@@ -28,8 +127,17 @@ func (e Entry) MarshalJSON() ([]byte, error) {
 	jEntry := make(map[string]interface{})
 
 	jEntry[propLastUpdate] = e.LastUpdate.UnixMilli()
+	if e.Writer != "" {
+		jEntry[propWriter] = e.Writer
+	}
+	if e.Revision != 0 {
+		jEntry[propRevision] = e.Revision
+	}
 	if e.IsValue {
 		jEntry[propValue] = e.Value
+		if e.Type != TypeUntyped {
+			jEntry[propType] = e.Type
+		}
 	} else {
 		children := make(map[string]interface{})
 		for name, child := range e.Children {
@@ -55,214 +163,910 @@ func (e Entry) MarshalJSON() ([]byte, error) {
 ValuesToJSON represents the hierarchy of values at the specified path in the default JSON format.
 */
 func ValuesToJSON(path string) (string, error) {
-	mutex.Lock()
-	defer mutex.Unlock()
+	w := bytes.Buffer{}
+	err := ValuesToJSONWriter(path, &w)
+	if err != nil {
+		return "", err
+	}
+
+	return w.String(), nil
+}
+
+/*
+ValuesToJSONWriter writes the hierarchy of values at the specified path to w, in the default JSON format.
+
+Entries are read from the DB and written to w one level at a time, so the whole hierarchy is never held in memory.
+*/
+func ValuesToJSONWriter(path string, w io.Writer) error {
+	return ExportJSON(path, w, ExportOptions{})
+}
+
+/*
+EntryToJSON represents the hierarchy of Entries at the specified path in the extended JSON format.
+*/
+func EntryToJSON(path string) (string, error) {
+	w := bytes.Buffer{}
+	err := EntryToJSONWriter(path, &w)
+	if err != nil {
+		return "", err
+	}
+
+	return w.String(), nil
+}
+
+/*
+EntryToJSONWriter writes the hierarchy of Entries at the specified path to w, in the extended JSON format.
+
+Entries are read from the DB and written to w one level at a time, so the whole hierarchy is never held in memory.
+*/
+func EntryToJSONWriter(path string, w io.Writer) error {
+	return ExportJSON(path, w, ExportOptions{Extended: true})
+}
+
+/*
+ExportJSON writes the hierarchy of Entries at the specified path to w, in the JSON format selected by options.
+
+Entries are read from the DB and written to w one level at a time, so the whole hierarchy is never held in memory.
+They are read in a single read transaction, so the export is consistent even while other goroutines or processes
+write to the DB. Use a Snapshot to export several hierarchies consistently with each other.
+*/
+func ExportJSON(path string, w io.Writer, options ExportOptions) error {
+	mutex.RLock()
+	defer mutex.RUnlock()
 
 	if atomic.LoadInt32(&initialized) == 0 {
-		return "", ErrNoDB
+		return ErrNoDB
 	}
 
-	tx, err := db.Begin()
+	tx, err := beginReadTx(context.Background())
 	if err != nil {
-		return "", fmt.Errorf("error beginning transaction - %w", err)
+		return fmt.Errorf("error beginning transaction - %w", err)
 	}
 
-	entry, err := getEntryDepth(normalizePath(path), -1, tx)
+	err = writeJSON(normalizePath(path), w, options, tx)
 	if err != nil {
-		tx.Rollback()
-		return "", err
+		rollbackTx(tx)
+		return err
 	}
 
-	err = tx.Commit()
+	err = endReadTx(context.Background(), tx)
 	if err != nil {
-		return "", fmt.Errorf("error committing transaction - %w", err)
+		return fmt.Errorf("error committing transaction - %w", err)
 	}
 
-	jEntry := entryToJSONValues(entry)
+	return nil
+}
 
-	w := bytes.Buffer{}
-	encoder := json.NewEncoder(&w)
-	encoder.SetEscapeHTML(false)
-	encoder.SetIndent("", "    ")
+/*
+SetValuesFromJSON set (forces) the values found in the JSON representation read from reader.
 
-	err = encoder.Encode(jEntry)
-	if err != nil {
-		return "", fmt.Errorf("error converting values to JSON - %w", err)
-	}
+If onlyMerge == true, does not overwrite an Entry with the value found in the JSON, if it already exists in the DB.
+Hooks are not called (see ImportOptions.FireHooks).
+*/
+func SetValuesFromJSON(reader io.Reader, onlyMerge bool) error {
+	_, err := importJSON(context.Background(), reader, ImportOptions{OnlyMerge: onlyMerge}, false)
+	return err
+}
 
-	return w.String(), nil
+/*
+DryRunValuesFromJSON behaves like SetValuesFromJSON, but instead of committing the changes to the DB, returns the list
+of changes that would be applied.
+*/
+func DryRunValuesFromJSON(reader io.Reader, onlyMerge bool) ([]Change, error) {
+	return importJSON(context.Background(), reader, ImportOptions{OnlyMerge: onlyMerge}, true)
 }
 
 /*
-ValuesToJSON represents the hierarchy of Entries at the specified path in the extended JSON format.
+SetEntriesFromJSON set (forces) the values found in the extended JSON representation read from reader.
+
+If onlyMerge == true, does not overwrite an Entry with the one found in the JSON, if it already exists in the DB.
+Hooks are not called (see ImportOptions.FireHooks).
 */
-func EntryToJSON(path string) (string, error) {
+func SetEntriesFromJSON(reader io.Reader, onlyMerge bool) error {
+	_, err := importJSON(context.Background(), reader, ImportOptions{Extended: true, OnlyMerge: onlyMerge}, false)
+	return err
+}
+
+/*
+DryRunEntriesFromJSON behaves like SetEntriesFromJSON, but instead of committing the changes to the DB, returns the list
+of changes that would be applied.
+*/
+func DryRunEntriesFromJSON(reader io.Reader, onlyMerge bool) ([]Change, error) {
+	return importJSON(context.Background(), reader, ImportOptions{Extended: true, OnlyMerge: onlyMerge}, true)
+}
+
+/*
+ImportJSON set (forces) the Entries found in the JSON representation read from reader, as specified by options.
+*/
+func ImportJSON(reader io.Reader, options ImportOptions) error {
+	return ImportJSONCtx(context.Background(), reader, options)
+}
+
+/*
+ImportJSONCtx calls ImportJSON, tracing the operation as a child of ctx (see SetTracer). The transaction is rolled back
+if ctx is done before it is committed.
+*/
+func ImportJSONCtx(ctx context.Context, reader io.Reader, options ImportOptions) error {
+	_, err := importJSON(ctx, reader, options, false)
+	return err
+}
+
+/*
+DryRunImportJSON behaves like ImportJSON, but instead of committing the changes to the DB, returns the list of changes
+that would be applied.
+*/
+func DryRunImportJSON(reader io.Reader, options ImportOptions) ([]Change, error) {
+	return importJSON(context.Background(), reader, options, true)
+}
+
+func importJSON(ctx context.Context, reader io.Reader, options ImportOptions, dryRun bool) (changes []Change,
+	err error) {
+	name := "camellia.ImportJSON"
+	if dryRun {
+		name = "camellia.DryRunImportJSON"
+	}
+
+	ctx, span := startSpan(ctx, name, "")
+	defer func() {
+		span.End(err)
+	}()
+
+	reader, err = decompressedReader(reader)
+	if err != nil {
+		return nil, err
+	}
+
 	mutex.Lock()
 	defer mutex.Unlock()
 
 	if atomic.LoadInt32(&initialized) == 0 {
-		return "", ErrNoDB
+		return nil, ErrNoDB
 	}
 
-	tx, err := db.Begin()
+	tx, err := beginTxCtx(ctx)
 	if err != nil {
-		return "", fmt.Errorf("error beginning transaction - %w", err)
+		return nil, fmt.Errorf("error beginning transaction - %w", err)
 	}
 
-	entry, err := getEntryDepth(normalizePath(path), -1, tx)
-	if err != nil {
-		tx.Rollback()
-		return "", err
+	txWriter = options.Writer
+	defer func() {
+		txWriter = ""
+	}()
+
+	if options.Extended {
+		err = setEntriesFromJSON(reader, normalizePath(options.Path), options.OnlyMerge, options.Filter, tx)
+	} else {
+		err = setValuesFromJSON(reader, normalizePath(options.Path), options.OnlyMerge, options.NativeTypes,
+			options.Filter, tx)
+	}
+
+	if err == nil {
+		err = checkRequired(tx)
+	}
+
+	var imported []Change
+	if err == nil && options.FireHooks && !dryRun {
+		imported = append(imported, recordedChanges...)
+		err = callImportedPreSetHooks(imported)
 	}
 
-	err = tx.Commit()
 	if err != nil {
-		return "", fmt.Errorf("error committing transaction - %w", err)
+		rollbackTx(tx)
+		return nil, err
 	}
 
-	w := bytes.Buffer{}
-	encoder := json.NewEncoder(&w)
-	encoder.SetEscapeHTML(false)
-	encoder.SetIndent("", "    ")
+	if dryRun {
+		changes = recordedChanges
+		recordChanges = false
+		recordedChanges = nil
+
+		sort.SliceStable(changes, func(i, j int) bool {
+			return changes[i].Path < changes[j].Path
+		})
+
+		err = rollbackTx(tx)
+		if err != nil {
+			return nil, fmt.Errorf("error rolling back transaction - %w", err)
+		}
 
-	err = encoder.Encode(entry)
+		return changes, nil
+	}
+
+	err = commitTx(tx)
 	if err != nil {
-		return "", fmt.Errorf("error converting entry to JSON - %w", err)
+		return nil, fmt.Errorf("error committing transaction - %w", err)
 	}
 
-	return w.String(), nil
+	callImportedPostSetHooks(imported)
+
+	return nil, nil
 }
 
 /*
-SetValuesFromJSON set (forces) the values found in the JSON representation read from reader.
-
-If onlyMerge == true, does not overwrite an Entry with the value found in the JSON, if it already exists in the DB.
+decompressedReader returns a reader decompressing the content of reader, if it's compressed with gzip, as detected by
+its magic bytes, or reading it as is otherwise
 */
-func SetValuesFromJSON(reader io.Reader, onlyMerge bool) error {
-	mutex.Lock()
-	defer mutex.Unlock()
-
-	if atomic.LoadInt32(&initialized) == 0 {
-		return ErrNoDB
+func decompressedReader(reader io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(reader)
+	magic, err := br.Peek(2)
+	if err != nil || magic[0] != 0x1f || magic[1] != 0x8b {
+		// Inputs too short to be compressed are left to the JSON decoder to reject
+		return br, nil
 	}
 
-	tx, err := db.Begin()
+	zr, err := gzip.NewReader(br)
 	if err != nil {
-		return fmt.Errorf("error beginning transaction - %w", err)
+		return nil, fmt.Errorf("error reading gzip header - %w", err)
 	}
 
+	return zr, nil
+}
+
+func setValuesFromJSON(reader io.Reader, root string, onlyMerge bool, nativeTypes bool, filter ImportFilter,
+	tx *sql.Tx) error {
 	values := make(map[string]interface{})
 	decoder := json.NewDecoder(reader)
-	err = decoder.Decode(&values)
+	decoder.UseNumber()
+	err := decoder.Decode(&values)
 	if err != nil {
 		return err
 	}
 
-	path := []string{}
+	path := splitPath(root)
+	importer := newValuesImporter(tx)
 
 	var visit func(entry interface{}) error
 	visit = func(entry interface{}) error {
 		p := joinPath(path)
 
-		str, ok := entry.(string)
+		m, ok := entry.(map[string]interface{})
 		if ok {
-			if onlyMerge {
-				exists, err := exists(p, tx)
+			for k, v := range m {
+				path = append(path, k)
+				err = visit(v)
 				if err != nil {
-					return fmt.Errorf("error checking existence of value %s - %w", p, err)
+					return err
 				}
 
-				if exists {
-					return nil
-				}
+				path = path[:len(path)-1]
 			}
 
-			err = setValue(p, str, tx, true, true)
+			return nil
+		}
+
+		value, valueType, err := importedJSONValue(entry, nativeTypes)
+		if err != nil {
+			return &PathError{Op: "import", Path: p, Err: fmt.Errorf("invalid JSON entry - %w", err)}
+		}
+
+		value, keep, err := filterImportedValue(filter, p, value)
+		if err != nil {
+			return err
+		}
+
+		if !keep {
+			return nil
+		}
+
+		err = importer.set(p, value, valueType, onlyMerge)
+		if err != nil {
+			return pathError("import", p, err)
+		}
+
+		return nil
+	}
+
+	return visit(values)
+}
+
+/*
+importedJSONValue returns the value, and its type, imported from a JSON value of the default format
+*/
+func importedJSONValue(entry interface{}, nativeTypes bool) (string, ValueType, error) {
+	var value string
+	var valueType ValueType
+	var err error
+
+	array, ok := entry.([]interface{})
+	if ok {
+		value, err = jsonToList(array, nativeTypes)
+		valueType = TypeList
+	} else {
+		value, valueType, err = jsonToValue(entry)
+	}
+
+	if err != nil {
+		return "", "", err
+	}
+
+	if !nativeTypes && valueType != TypeList && valueType != TypeString && valueType != TypeNull {
+		valueType = TypeUntyped
+	}
+
+	return value, valueType, nil
+}
+
+/*
+valuesImporter sets the values of an import, creating every non-value Entry in their paths only once, and skipping
+the existence checks under the ones it created, which can only have imported children
+*/
+type valuesImporter struct {
+	tx       *sql.Tx
+	now      int64
+	created  map[string]bool
+	existing map[string]bool
+}
+
+func newValuesImporter(tx *sql.Tx) *valuesImporter {
+	return &valuesImporter{
+		tx:       tx,
+		now:      time.Now().UnixMilli(),
+		created:  map[string]bool{},
+		existing: map[string]bool{}}
+}
+
+func (i *valuesImporter) set(path string, value string, valueType ValueType, onlyMerge bool) error {
+	if len(path) == 0 {
+		return ErrPathInvalid
+	}
+
+	err := checkPath(path)
+	if err != nil {
+		return err
+	}
+
+	err = checkWritable(path)
+	if err != nil {
+		return err
+	}
+
+	parent := parentPath(path)
+	err = i.ensureNonValue(parent)
+	if err != nil {
+		return err
+	}
+
+	if !i.created[parent] {
+		if onlyMerge {
+			exists, err := exists(path, i.tx)
 			if err != nil {
-				return fmt.Errorf("error setting value %s - %w", p, err)
+				return fmt.Errorf("error checking existence of value %s - %w", path, err)
 			}
-		} else {
-			m, ok := entry.(map[string]interface{})
-			if ok {
-				for k, v := range m {
-					path = append(path, k)
-					err = visit(v)
-					if err != nil {
-						return err
-					}
-
-					path = path[:len(path)-1]
-				}
-			} else {
-				return fmt.Errorf("invalid JSON entry at %s", p)
+
+			if exists {
+				return nil
 			}
 		}
 
-		return nil
+		return setValue(path, value, valueType, i.tx, true, true)
 	}
 
-	err = visit(values)
+	warnDeprecated(path, "set")
+
+	err = validateValue(path, value, valueType)
 	if err != nil {
 		return err
 	}
 
-	err = tx.Commit()
+	// No need to replace chunks, since the path didn't exist
+	text, blob, err := storedValue(value, valueType)
 	if err != nil {
-		return fmt.Errorf("error committing transaction - %w", err)
+		return err
+	}
+
+	err = copyUp(parent, i.tx)
+	if err != nil {
+		return err
+	}
+
+	_, err = pathStmt(i.tx, "insertValueEntry", path).Exec(path, i.now, parent, text, valueType, blob,
+		valueChecksum(text, blob), currentWriter())
+	if err != nil {
+		return err
 	}
 
+	if valueType == TypeStream && value != "" {
+		err = writeChunks(path, base64.NewDecoder(base64.StdEncoding, strings.NewReader(value)), i.tx)
+		if err != nil {
+			return err
+		}
+	}
+
+	recordChange(ChangeCreated, path, true, "", value)
+
 	return nil
 }
 
 /*
-SetValuesFromJSON set (forces) the values found in the extended JSON representation read from reader.
-
-If onlyMerge == true, does not overwrite an Entry with the one found in the JSON, if it already exists in the DB.
+ensureNonValue creates the non-value Entry at path, along with its parents, unless it already exists. Values in the
+path are overwritten
 */
-func SetEntriesFromJSON(reader io.Reader, onlyMerge bool) error {
-	mutex.Lock()
-	defer mutex.Unlock()
+func (i *valuesImporter) ensureNonValue(path string) error {
+	if i.created[path] || i.existing[path] {
+		return nil
+	}
 
-	if atomic.LoadInt32(&initialized) == 0 {
-		return ErrNoDB
+	parent := ""
+	if path != "" {
+		parent = parentPath(path)
+
+		err := i.ensureNonValue(parent)
+		if err != nil {
+			return err
+		}
+	}
+
+	overwritten := false
+	if !i.created[parent] || path == "" {
+		isValue, err := pathIsValue(path, i.tx)
+		if err != nil && !errors.Is(err, ErrPathNotFound) {
+			return err
+		}
+
+		if err == nil && !isValue {
+			i.existing[path] = true
+			return nil
+		}
+
+		if err == nil {
+			oldValue, err := getValue(path, i.tx)
+			if err != nil {
+				return err
+			}
+
+			err = deleteEntry(path, i.tx)
+			if err != nil {
+				return err
+			}
+
+			recordChange(ChangeOverwritten, path, false, oldValue, "")
+			overwritten = true
+		}
 	}
 
-	tx, err := db.Begin()
+	err := copyUp(parent, i.tx)
 	if err != nil {
-		return fmt.Errorf("error beginning transaction - %w", err)
+		return err
+	}
+
+	_, err = pathStmt(i.tx, "insertNonValueEntry", path).Exec(path, i.now, parent, currentWriter())
+	if err != nil {
+		return err
+	}
+
+	if !overwritten {
+		recordChange(ChangeCreated, path, false, "", "")
+	}
+
+	i.created[path] = true
+
+	return nil
+}
+
+/*
+jsonToValue converts a value decoded from JSON (using json.Decoder.UseNumber) to its string representation and its
+type tag
+*/
+func jsonToValue(v interface{}) (string, ValueType, error) {
+	switch t := v.(type) {
+	case string:
+		return t, TypeString, nil
+	case bool:
+		return strconv.FormatBool(t), TypeBool, nil
+	case json.Number:
+		_, err := t.Int64()
+		if err == nil {
+			return t.String(), TypeInt, nil
+		}
+
+		return t.String(), TypeFloat, nil
+	case nil:
+		return "", TypeNull, nil
+	default:
+		return "", TypeUntyped, fmt.Errorf("unsupported JSON type")
 	}
+}
 
+func setEntriesFromJSON(reader io.Reader, root string, onlyMerge bool, filter ImportFilter, tx *sql.Tx) error {
 	entry := Entry{}
 	decoder := json.NewDecoder(reader)
-	err = decoder.Decode(&entry)
+	err := decoder.Decode(&entry)
+	if err != nil {
+		return err
+	}
+
+	if root == "" {
+		_, err = filterImportedEntry(filter, &entry)
+		if err != nil {
+			return err
+		}
+
+		return setRootEntry(&entry, tx, true, true, onlyMerge)
+	}
+
+	// The Entry is imported at root as a descendant of the root Entry, through non-value Entries merged with the
+	// existing ones
+	rebaseEntry(&entry, root)
+
+	keep, err := filterImportedEntry(filter, &entry)
+	if err != nil || !keep {
+		return err
+	}
+
+	segments := splitPath(root)
+	for i := len(segments) - 1; i >= 0; i-- {
+		child := entry
+		entry = Entry{
+			Path:       joinPath(segments[:i]),
+			LastUpdate: time.Now(),
+			Children:   map[string]*Entry{segments[i]: &child},
+		}
+	}
+
+	return setRootEntry(&entry, tx, true, true, onlyMerge)
+}
+
+/*
+filterImportedValue returns the value imported at path in place of value, and whether to import it, as returned by
+filter, if any
+*/
+func filterImportedValue(filter ImportFilter, path string, value string) (string, bool, error) {
+	if filter == nil {
+		return value, true, nil
+	}
+
+	value, keep, err := filter(path, value)
+	if err != nil {
+		return "", false, &PathError{Op: "import", Path: path, Err: err}
+	}
+
+	return value, keep, nil
+}
+
+/*
+filterImportedEntry filters the values of entry and of its descendants with filter, removing the skipped ones, and
+returns whether entry itself is to be imported
+*/
+func filterImportedEntry(filter ImportFilter, entry *Entry) (bool, error) {
+	if filter == nil {
+		return true, nil
+	}
+
+	if entry.IsValue {
+		value, keep, err := filterImportedValue(filter, entry.Path, entry.Value)
+		entry.Value = value
+		return keep, err
+	}
+
+	names := make([]string, 0, len(entry.Children))
+	for name := range entry.Children {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		keep, err := filterImportedEntry(filter, entry.Children[name])
+		if err != nil {
+			return false, err
+		}
+
+		if !keep {
+			delete(entry.Children, name)
+		}
+	}
+
+	return true, nil
+}
+
+func writeJSON(path string, w io.Writer, options ExportOptions, tx *sql.Tx) error {
+	warnDeprecated(path, "export")
+
+	for _, pattern := range append(append([]string{}, options.Include...), options.Exclude...) {
+		err := checkGlob(pattern)
+		if err != nil {
+			return err
+		}
+	}
+
+	options.root = path
+
+	entry, err := getEntry(path, tx)
+	if options.Runtime {
+		entry, err = withRuntime(path, 0, entry, err)
+	}
+
 	if err != nil {
 		return err
 	}
 
-	err = setRootEntry(&entry, tx, true, true, onlyMerge)
+	var zw *gzip.Writer
+	if options.Gzip {
+		zw = gzip.NewWriter(w)
+		w = zw
+	}
+
+	bw := bufio.NewWriter(w)
+
+	err = writeEntryJSON(bw, entry, options, 0, tx)
 	if err != nil {
 		return err
 	}
 
-	err = tx.Commit()
+	bw.WriteString("\n")
+
+	err = bw.Flush()
+	if err == nil && zw != nil {
+		err = zw.Close()
+	}
+
 	if err != nil {
-		return fmt.Errorf("error committing transaction - %w", err)
+		return fmt.Errorf("error writing JSON - %w", err)
 	}
 
 	return nil
 }
 
-func entryToJSONValues(entry *Entry) interface{} {
+/*
+writeEntryJSON writes entry to w, producing the same layout json.Encoder would produce with a 4 spaces indent and
+sorted keys
+*/
+func writeEntryJSON(w *bufio.Writer, entry *Entry, options ExportOptions, level int, tx *sql.Tx) error {
+	if !options.Extended {
+		if entry.IsValue {
+			if entry.Type == TypeList {
+				return writeJSONList(w, entry.Value, options.NativeTypes, level)
+			}
+
+			if entry.Type == TypeStream {
+				return writeJSONStream(w, entry.Path, tx)
+			}
+
+			if options.NativeTypes || entry.Type == TypeNull {
+				return writeJSONNative(w, entry.Value, entry.Type)
+			}
+
+			return writeJSONString(w, entry.Value)
+		}
+
+		return writeChildrenJSON(w, entry, options, level, tx)
+	}
+
+	w.WriteString("{\n")
+
+	if !entry.IsValue {
+		writeJSONIndent(w, level+1)
+		writeJSONString(w, propChildren)
+		w.WriteString(": ")
+
+		err := writeChildrenJSON(w, entry, options, level+1, tx)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !options.Canonical {
+		if !entry.IsValue {
+			w.WriteString(",\n")
+		}
+
+		writeJSONIndent(w, level+1)
+		writeJSONString(w, propLastUpdate)
+		w.WriteString(": ")
+		w.WriteString(strconv.FormatInt(entry.LastUpdate.UnixMilli(), 10))
+
+		if entry.Revision != 0 {
+			w.WriteString(",\n")
+			writeJSONIndent(w, level+1)
+			writeJSONString(w, propRevision)
+			w.WriteString(": ")
+			w.WriteString(strconv.FormatUint(entry.Revision, 10))
+		}
+	}
+
 	if entry.IsValue {
-		return entry.Value
-	} else {
-		jEntry := make(map[string]interface{})
-		for name, child := range entry.Children {
-			jEntry[name] = entryToJSONValues(child)
+		if !options.Canonical {
+			w.WriteString(",\n")
+		}
+
+		if entry.Type != TypeUntyped {
+			writeJSONIndent(w, level+1)
+			writeJSONString(w, propType)
+			w.WriteString(": ")
+			writeJSONString(w, string(entry.Type))
+			w.WriteString(",\n")
+		}
+
+		writeJSONIndent(w, level+1)
+		writeJSONString(w, propValue)
+		w.WriteString(": ")
+
+		if entry.Type == TypeStream {
+			err := writeJSONStream(w, entry.Path, tx)
+			if err != nil {
+				return err
+			}
+		} else {
+			writeJSONString(w, entry.Value)
+		}
+	}
+
+	if !options.Canonical && entry.Writer != "" {
+		w.WriteString(",\n")
+		writeJSONIndent(w, level+1)
+		writeJSONString(w, propWriter)
+		w.WriteString(": ")
+		writeJSONString(w, entry.Writer)
+	}
+
+	w.WriteString("\n")
+	writeJSONIndent(w, level)
+	w.WriteString("}")
+
+	return nil
+}
+
+func writeChildrenJSON(w *bufio.Writer, entry *Entry, options ExportOptions, level int, tx *sql.Tx) error {
+	children, err := getChildren(entry.Path, tx)
+	if err != nil {
+		return err
+	}
+
+	if options.Runtime {
+		children = withRuntimeChildren(entry.Path, children)
+	}
+
+	if len(options.Include) > 0 || len(options.Exclude) > 0 {
+		selected := children[:0:0]
+		for _, child := range children {
+			if options.selects(child) {
+				selected = append(selected, child)
+			}
+		}
+
+		children = selected
+	}
+
+	if len(children) == 0 {
+		w.WriteString("{}")
+		return nil
+	}
+
+	w.WriteString("{\n")
+
+	for i, child := range children {
+		writeJSONIndent(w, level+1)
+		writeJSONString(w, namePath(child.Path))
+		w.WriteString(": ")
+
+		err = writeEntryJSON(w, child, options, level+1, tx)
+		if err != nil {
+			return err
+		}
+
+		if i < len(children)-1 {
+			w.WriteString(",")
+		}
+
+		w.WriteString("\n")
+	}
+
+	writeJSONIndent(w, level)
+	w.WriteString("}")
+
+	return nil
+}
+
+/*
+selects returns whether entry is exported, as selected by the Include and Exclude globs of the options
+*/
+func (o ExportOptions) selects(entry *Entry) bool {
+	path := relativePath(o.root, entry.Path)
+
+	for _, pattern := range o.Exclude {
+		if matchGlob(pattern, path) {
+			return false
+		}
+	}
+
+	if len(o.Include) == 0 {
+		return true
+	}
+
+	// An Entry is included along with its descendants
+	segments := splitPath(path)
+	for _, pattern := range o.Include {
+		for i := 1; i <= len(segments); i++ {
+			if matchGlob(pattern, joinPath(segments[:i])) {
+				return true
+			}
+		}
+
+		if !entry.IsValue && matchGlobAncestor(pattern, path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func writeJSONIndent(w *bufio.Writer, level int) {
+	for i := 0; i < level; i++ {
+		w.WriteString("    ")
+	}
+}
+
+func writeJSONList(w *bufio.Writer, list string, nativeTypes bool, level int) error {
+	elements, err := decodeList(list)
+	if err != nil {
+		return err
+	}
+
+	if len(elements) == 0 {
+		w.WriteString("[]")
+		return nil
+	}
+
+	w.WriteString("[\n")
+
+	for i, element := range elements {
+		writeJSONIndent(w, level+1)
+
+		value, valueType, err := jsonToValue(element)
+		if err != nil {
+			return err
+		}
+
+		if nativeTypes {
+			err = writeJSONNative(w, value, valueType)
+		} else {
+			err = writeJSONString(w, value)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if i < len(elements)-1 {
+			w.WriteString(",")
+		}
+
+		w.WriteString("\n")
+	}
+
+	writeJSONIndent(w, level)
+	w.WriteString("]")
+
+	return nil
+}
+
+func writeJSONNative(w *bufio.Writer, value string, valueType ValueType) error {
+	switch valueType {
+	case TypeInt, TypeFloat, TypeBool:
+		if json.Valid([]byte(value)) {
+			w.WriteString(value)
+			return nil
 		}
+	case TypeNull:
+		w.WriteString("null")
+		return nil
+	}
+
+	return writeJSONString(w, value)
+}
 
-		return &jEntry
+func writeJSONString(w *bufio.Writer, s string) error {
+	buffer := bytes.Buffer{}
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	err := encoder.Encode(s)
+	if err != nil {
+		return err
 	}
+
+	w.Write(bytes.TrimSuffix(buffer.Bytes(), []byte("\n")))
+
+	return nil
 }
 
 func (e *Entry) fromJSONInterface(path string, i map[string]interface{}) error {
@@ -275,6 +1079,15 @@ func (e *Entry) fromJSONInterface(path string, i map[string]interface{}) error {
 		return fmt.Errorf("both value and children fields are defined")
 	}
 
+	if i[propRevision] != nil {
+		revision, ok := i[propRevision].(float64)
+		if !ok || revision < 0 {
+			return fmt.Errorf("invalid revision field")
+		}
+
+		e.Revision = uint64(revision)
+	}
+
 	if i[propValue] != nil {
 		value, ok := i[propValue].(string)
 		if !ok {
@@ -283,6 +1096,15 @@ func (e *Entry) fromJSONInterface(path string, i map[string]interface{}) error {
 
 		e.Value = value
 		e.IsValue = true
+
+		if i[propType] != nil {
+			valueType, ok := i[propType].(string)
+			if !ok {
+				return fmt.Errorf("invalid type field")
+			}
+
+			e.Type = ValueType(valueType)
+		}
 	} else {
 		e.Children = make(map[string]*Entry)
 		e.IsValue = false
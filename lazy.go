@@ -0,0 +1,98 @@
+package camellia
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+)
+
+/*
+GetEntryLazy returns the Entry at the specified path, without loading its children, which are loaded on demand with
+GetChildren or LoadChildren. This allows visiting only the needed branches of large hierarchies.
+*/
+func GetEntryLazy(path string) (*Entry, error) {
+	return GetEntryDepth(path, 0)
+}
+
+/*
+ChildrenLoaded returns whether the children of the Entry were loaded. It's false only for non-value Entries returned
+by GetEntryLazy, or at the last level of GetEntryDepth, until their children are loaded.
+*/
+func (e *Entry) ChildrenLoaded() bool {
+	return !e.childrenPending
+}
+
+/*
+GetChildren returns the children of the Entry, loading them with LoadChildren first, if not loaded yet.
+*/
+func (e *Entry) GetChildren() (map[string]*Entry, error) {
+	err := e.LoadChildren()
+	if err != nil {
+		return nil, err
+	}
+
+	return e.Children, nil
+}
+
+/*
+LoadChildren loads the direct children of the Entry into Children, if not loaded yet (see ChildrenLoaded), as they
+are in the DB at the time of the call. The children of the loaded Entries are not loaded.
+
+LoadChildren must not be called concurrently on the same Entry.
+*/
+func (e *Entry) LoadChildren() (err error) {
+	if !e.childrenPending {
+		return nil
+	}
+
+	ctx, span := startSpan(context.Background(), "camellia.LoadChildren", e.Path)
+	defer func() {
+		span.End(err)
+	}()
+
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return ErrNoDB
+	}
+
+	tx, err := beginReadTx(ctx)
+	if err != nil {
+		return fmt.Errorf("error beginning transaction - %w", err)
+	}
+
+	children, err := getChildren(e.Path, tx)
+	if err != nil {
+		rollbackTx(tx)
+		return err
+	}
+
+	loaded := &Entry{Path: e.Path, Children: map[string]*Entry{}}
+	for _, child := range children {
+		child.childrenPending = !child.IsValue
+		loaded.Children[namePath(child.Path)] = child
+	}
+
+	graftRuntime(loaded, 1)
+
+	err = interpolateEntry(loaded, tx)
+	if err != nil {
+		rollbackTx(tx)
+		return err
+	}
+
+	err = endReadTx(ctx, tx)
+	if err != nil {
+		rollbackTx(tx)
+		return fmt.Errorf("error committing transaction - %w", err)
+	}
+
+	for name, child := range loaded.Children {
+		e.Children[name] = child
+	}
+
+	e.childrenPending = false
+
+	return nil
+}
@@ -0,0 +1,304 @@
+package camellia
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+)
+
+/*
+SetList sets a list of values of type T to the specified path.
+
+Lists are stored as a single value, tagged with TypeList, carrying the JSON representation of its elements.
+*/
+func SetList[T Stringable](path string, values []T) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return ErrNoDB
+	}
+
+	list, err := listToJSON(values)
+	if err != nil {
+		return err
+	}
+
+	tx, err := beginTx()
+	if err != nil {
+		return fmt.Errorf("error beginning transaction - %w", err)
+	}
+
+	err = setValue(normalizePath(path), list, TypeList, tx, false, false)
+	if err != nil {
+		rollbackTx(tx)
+		return err
+	}
+
+	err = commitTx(tx)
+	if err != nil {
+		rollbackTx(tx)
+		return fmt.Errorf("error committing transaction - %w", err)
+	}
+
+	return nil
+}
+
+/*
+GetList reads the list at the specified path and returns its elements as type T.
+
+Returns ErrValueIsNotAList if the value at path is not a list.
+*/
+func GetList[T Stringable](path string) ([]T, error) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return nil, ErrNoDB
+	}
+
+	tx, err := beginReadTx(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("error beginning transaction - %w", err)
+	}
+
+	path = normalizePath(path)
+	warnDeprecated(path, "get")
+
+	values, err := getList[T](path, tx)
+	if err != nil {
+		rollbackTx(tx)
+		return nil, err
+	}
+
+	err = endReadTx(context.Background(), tx)
+	if err != nil {
+		rollbackTx(tx)
+		return nil, fmt.Errorf("error committing transaction - %w", err)
+	}
+
+	return values, nil
+}
+
+/*
+AppendToList appends values to the end of the list at the specified path.
+
+If no Entry exists at path, a new list is created.
+*/
+func AppendToList[T Stringable](path string, values ...T) error {
+	return updateList(path, func(list []T) ([]T, error) {
+		return append(list, values...), nil
+	})
+}
+
+/*
+RemoveFromList removes every element equal to value from the list at the specified path.
+*/
+func RemoveFromList[T Stringable](path string, value T) error {
+	return updateList(path, func(list []T) ([]T, error) {
+		filtered := []T{}
+		for _, v := range list {
+			if v != value {
+				filtered = append(filtered, v)
+			}
+		}
+
+		return filtered, nil
+	})
+}
+
+func updateList[T any](path string, update func(list []T) ([]T, error)) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return ErrNoDB
+	}
+
+	path = normalizePath(path)
+
+	tx, err := beginTx()
+	if err != nil {
+		return fmt.Errorf("error beginning transaction - %w", err)
+	}
+
+	list, err := getList[T](path, tx)
+	if err != nil {
+		if !errors.Is(err, ErrPathNotFound) {
+			rollbackTx(tx)
+			return err
+		}
+
+		list = []T{}
+	}
+
+	list, err = update(list)
+	if err != nil {
+		rollbackTx(tx)
+		return err
+	}
+
+	jList, err := listToJSON(list)
+	if err != nil {
+		rollbackTx(tx)
+		return err
+	}
+
+	err = setValue(path, jList, TypeList, tx, false, false)
+	if err != nil {
+		rollbackTx(tx)
+		return err
+	}
+
+	err = commitTx(tx)
+	if err != nil {
+		rollbackTx(tx)
+		return fmt.Errorf("error committing transaction - %w", err)
+	}
+
+	return nil
+}
+
+func getList[T any](path string, tx *sql.Tx) ([]T, error) {
+	entry, err := getEntry(path, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	if !entry.IsValue {
+		return nil, ErrPathIsNotAValue
+	}
+
+	if entry.Type != TypeList {
+		return nil, ErrValueIsNotAList
+	}
+
+	elements, err := listElements(entry.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	values := []T{}
+	for i, element := range elements {
+		value, err := decodeValue[T](element)
+		if err != nil {
+			return nil, fmt.Errorf("error converting list element %d - %w", i, err)
+		}
+
+		values = append(values, value)
+	}
+
+	return values, nil
+}
+
+/*
+listToJSON represents values as a JSON array. Numbers and booleans are represented as their native JSON type, every
+other type as a string
+*/
+func listToJSON[T any](values []T) (string, error) {
+	return reflectListToJSON(reflect.ValueOf(values))
+}
+
+func reflectListToJSON(values reflect.Value) (string, error) {
+	elements := []interface{}{}
+	for i := 0; i < values.Len(); i++ {
+		element := values.Index(i)
+
+		// Elements of []any are converted by their dynamic type, nil ones to nulls
+		if element.Kind() == reflect.Interface {
+			if element.IsNil() {
+				elements = append(elements, nil)
+				continue
+			}
+
+			element = element.Elem()
+		}
+
+		value, err := encodeReflectValue(element)
+		if err != nil {
+			return "", fmt.Errorf("error converting list element %d - %w", i, err)
+		}
+
+		_, hasCodec := lookupCodec(element.Type())
+		if !hasCodec && isBaseKind(element.Kind()) && element.Kind() != reflect.String {
+			elements = append(elements, json.RawMessage(value))
+		} else {
+			elements = append(elements, value)
+		}
+	}
+
+	return encodeList(elements)
+}
+
+/*
+jsonToList converts a JSON array decoded with json.Decoder.UseNumber to its stored representation. Only arrays of
+strings, numbers, booleans and nulls are supported. If nativeTypes == false, elements are converted to strings
+*/
+func jsonToList(array []interface{}, nativeTypes bool) (string, error) {
+	elements := []interface{}{}
+	for i, element := range array {
+		value, valueType, err := jsonToValue(element)
+		if err != nil {
+			return "", fmt.Errorf("invalid list element %d - %w", i, err)
+		}
+
+		if nativeTypes || valueType == TypeNull {
+			elements = append(elements, element)
+		} else {
+			elements = append(elements, value)
+		}
+	}
+
+	return encodeList(elements)
+}
+
+/*
+listElements returns the string representation of the elements of a stored list
+*/
+func listElements(list string) ([]string, error) {
+	array, err := decodeList(list)
+	if err != nil {
+		return nil, err
+	}
+
+	elements := []string{}
+	for i, element := range array {
+		value, _, err := jsonToValue(element)
+		if err != nil {
+			return nil, fmt.Errorf("invalid list element %d - %w", i, err)
+		}
+
+		elements = append(elements, value)
+	}
+
+	return elements, nil
+}
+
+func decodeList(list string) ([]interface{}, error) {
+	array := []interface{}{}
+	decoder := json.NewDecoder(bytes.NewBufferString(list))
+	decoder.UseNumber()
+	err := decoder.Decode(&array)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding list - %w", err)
+	}
+
+	return array, nil
+}
+
+func encodeList(elements []interface{}) (string, error) {
+	buffer := bytes.Buffer{}
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	err := encoder.Encode(elements)
+	if err != nil {
+		return "", fmt.Errorf("error encoding list - %w", err)
+	}
+
+	return string(bytes.TrimSuffix(buffer.Bytes(), []byte("\n"))), nil
+}
@@ -0,0 +1,150 @@
+package camellia
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+const (
+	defaultBusyTimeout  = 5 * time.Second
+	defaultMaxReadConns = 4
+
+	minBusyBackoff = 10 * time.Millisecond
+	maxBusyBackoff = 500 * time.Millisecond
+)
+
+/*
+writeLockQuery is a no-op write, executed at the beginning of write transactions to take the write lock of the DB file
+*/
+var writeLockQuery = fmt.Sprintf("UPDATE %s SET value = value WHERE 0", metaTable)
+
+/*
+dsn returns the data source name opening the DB at path with the locking and durability behaviors selected by options:
+SQLite waits up to the busy timeout for the locks held by other connections, and syncs the storage as selected by
+Options.Durability. Foreign keys are enforced, so that deletes cascade to children Entries
+*/
+func dsn(path string, options Options) (string, error) {
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+
+	synchronous, err := options.Durability.synchronous()
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s%s_busy_timeout=%d&_synchronous=%s&_foreign_keys=1", path, separator,
+		busyTimeout(options).Milliseconds(), synchronous), nil
+}
+
+func busyTimeout(options Options) time.Duration {
+	if options.BusyTimeout < 0 {
+		return 0
+	}
+
+	if options.BusyTimeout == 0 {
+		return defaultBusyTimeout
+	}
+
+	return options.BusyTimeout
+}
+
+/*
+configurePool switches the DB to WAL mode, so that reads don't block, and aren't blocked by, the write transactions
+of other processes, and sizes the connection pool for the reader connections selected by options, plus the dedicated writer connection
+*/
+func configurePool(options Options) error {
+	// The journal mode is persistent, so it's set once for all the connections. Only on the open DB, as the mounted files
+	// may be read-only (see Mount)
+	_, err := db.Exec("PRAGMA main.journal_mode = WAL")
+	if err != nil {
+		return err
+	}
+
+	maxReadConns := defaultMaxReadConns
+	if options.MaxReadConns > 0 {
+		maxReadConns = options.MaxReadConns
+	}
+
+	maxIdleReadConns := maxReadConns
+	if options.MaxIdleReadConns < 0 {
+		maxIdleReadConns = 0
+	} else if options.MaxIdleReadConns > 0 && options.MaxIdleReadConns < maxReadConns {
+		maxIdleReadConns = options.MaxIdleReadConns
+	}
+
+	db.SetMaxOpenConns(maxReadConns + 1)
+	db.SetMaxIdleConns(maxIdleReadConns + 1)
+
+	return nil
+}
+
+/*
+isBusy returns whether err is caused by a lock held on the DB by another connection
+*/
+func isBusy(err error) bool {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+
+	return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+}
+
+/*
+wrapBusy wraps err with ErrBusy if it is caused by a lock held on the DB by another connection
+*/
+func wrapBusy(err error) error {
+	if err != nil && isBusy(err) {
+		return fmt.Errorf("%w - %v", ErrBusy, err)
+	}
+
+	return err
+}
+
+/*
+beginWithRetry begins a write transaction, taking the write lock of the DB file up front, so that concurrent write
+transactions of different processes wait for each other, instead of failing when upgrading from a read lock. Retries
+with exponential backoff while the DB is locked by another process, up to the busy timeout. Read transactions don't
+take the write lock, so they run concurrently with each other, and with the write transaction
+*/
+func beginWithRetry(ctx context.Context) (*sql.Tx, error) {
+	deadline := time.Now().Add(busyTimeout(dbOptions))
+	backoff := minBusyBackoff
+
+	for {
+		tx, err := writeConn.BeginTx(ctx, nil)
+		if err == nil {
+			_, err = tx.ExecContext(ctx, writeLockQuery)
+			if err == nil {
+				return tx, nil
+			}
+
+			rollbackTx(tx)
+		}
+
+		if !isBusy(err) || time.Now().Add(backoff).After(deadline) {
+			return nil, wrapBusy(err)
+		}
+
+		logDebug("DB busy, retrying", "backoff", backoff, "error", err)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		backoff *= 2
+		if backoff > maxBusyBackoff {
+			backoff = maxBusyBackoff
+		}
+	}
+}
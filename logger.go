@@ -0,0 +1,97 @@
+package camellia
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+Logger is the interface used by the library to report events that do not cause an API call to fail, like accesses to
+deprecated paths, errors of asynchronous hooks, panics recovered in callbacks, and DB creations and migrations.
+
+Its methods follow the conventions of log/slog (a message followed by alternating keys and values), so a
+*slog.Logger can be used directly.
+*/
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+var logger Logger
+var loggerMutex sync.Mutex
+var slowOperationThreshold time.Duration
+
+/*
+SetLogger sets the Logger used by the library. A nil logger (the default) disables logging.
+
+The logger can be called while the DB is locked, so it must not call the API.
+*/
+func SetLogger(l Logger) {
+	loggerMutex.Lock()
+	defer loggerMutex.Unlock()
+
+	logger = l
+}
+
+/*
+SetSlowOperationThreshold makes the library log, with Warn, the Set, Get, GetEntry, Recurse, ImportJSON and Update
+operations (and the commits of their transactions) lasting at least threshold, along with their path, their duration (including the time spent waiting for
+the DB lock), the number of rows they read and the number of changes they made. A threshold <= 0 (the default)
+disables it.
+*/
+func SetSlowOperationThreshold(threshold time.Duration) {
+	loggerMutex.Lock()
+	defer loggerMutex.Unlock()
+
+	slowOperationThreshold = threshold
+}
+
+func getSlowOperationThreshold() time.Duration {
+	loggerMutex.Lock()
+	defer loggerMutex.Unlock()
+
+	return slowOperationThreshold
+}
+
+func getLogger() Logger {
+	loggerMutex.Lock()
+	defer loggerMutex.Unlock()
+
+	return logger
+}
+
+func logDebug(msg string, args ...any) {
+	if l := getLogger(); l != nil {
+		l.Debug(msg, args...)
+	}
+}
+
+func logInfo(msg string, args ...any) {
+	if l := getLogger(); l != nil {
+		l.Info(msg, args...)
+	}
+}
+
+func logWarn(msg string, args ...any) {
+	if l := getLogger(); l != nil {
+		l.Warn(msg, args...)
+	}
+}
+
+func logError(msg string, args ...any) {
+	if l := getLogger(); l != nil {
+		l.Error(msg, args...)
+	}
+}
+
+/*
+recoverCallback recovers from a panic of a callback run on a library goroutine, logging it instead of crashing the
+process. Must be deferred
+*/
+func recoverCallback(kind string, path string) {
+	if r := recover(); r != nil {
+		logError("panic in "+kind, "path", path, "panic", r)
+	}
+}
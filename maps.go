@@ -0,0 +1,159 @@
+package camellia
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync/atomic"
+)
+
+/*
+SetMap sets (forces) the values found in values, a nested map, under the specified path, in a single transaction, like
+SetValuesFromJSON does with a JSON representation, but without converting values to JSON first.
+
+Maps with string keys become non-value Entries, while their other values are stored with the type tag of their Go
+type, like Set does: numbers, booleans and strings, but also the types supported by codecs and by the
+CustomStringable and encoding.TextMarshaler interfaces. Slices and arrays become lists (see SetList), byte slices
+binary values (see SetBytes), and nils null values. Empty maps create no Entry. Hooks are not called.
+*/
+func SetMap(path string, values map[string]any) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return ErrNoDB
+	}
+
+	tx, err := beginTx()
+	if err != nil {
+		return fmt.Errorf("error beginning transaction - %w", err)
+	}
+
+	err = setMapValue(normalizePath(path), reflect.ValueOf(values), newValuesImporter(tx))
+	if err == nil {
+		err = checkRequired(tx)
+	}
+
+	if err != nil {
+		rollbackTx(tx)
+		return err
+	}
+
+	err = commitTx(tx)
+	if err != nil {
+		return fmt.Errorf("error committing transaction - %w", err)
+	}
+
+	return nil
+}
+
+/*
+setMapValue sets value, an element of a map passed to SetMap, at path, recursing into nested maps
+*/
+func setMapValue(path string, value reflect.Value, importer *valuesImporter) error {
+	if value.Kind() == reflect.Interface && !value.IsNil() {
+		value = value.Elem()
+	}
+
+	_, hasCodec := lookupCodec(value.Type())
+	if value.Kind() == reflect.Map && value.Type().Key().Kind() == reflect.String && !hasCodec {
+		keys := make([]string, 0, value.Len())
+		for _, k := range value.MapKeys() {
+			keys = append(keys, k.String())
+		}
+
+		// Sorted, so that failures are reported on the same path every time
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			err := setMapValue(namespacePath(path, k), value.MapIndex(reflect.ValueOf(k).Convert(value.Type().Key())),
+				importer)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	valueString, valueType, err := mapValue(value, hasCodec)
+	if err != nil {
+		return &PathError{Op: "set", Path: path, Err: err}
+	}
+
+	return pathError("set", path, importer.set(path, valueString, valueType, false))
+}
+
+/*
+mapValue returns the stored representation of value, a leaf of a map passed to SetMap, and its type
+*/
+func mapValue(value reflect.Value, hasCodec bool) (string, ValueType, error) {
+	switch {
+	case value.Kind() == reflect.Interface || (value.Kind() == reflect.Pointer && value.IsNil()):
+		return "", TypeNull, nil
+	case hasCodec:
+	case value.Kind() == reflect.Slice && value.Type().Elem().Kind() == reflect.Uint8:
+		return base64.StdEncoding.EncodeToString(value.Bytes()), TypeBytes, nil
+	case value.Kind() == reflect.Slice || value.Kind() == reflect.Array:
+		list, err := reflectListToJSON(value)
+		return list, TypeList, err
+	}
+
+	valueString, err := encodeReflectValue(value)
+	if err != nil {
+		return "", "", fmt.Errorf("error converting value to string - %w", err)
+	}
+
+	return valueString, reflectValueType(value.Type()), nil
+}
+
+/*
+GetFlat returns the values under the specified path, keyed by their path relative to it, reading the whole hierarchy
+in a single query, like GetEntry. Runtime values and references are applied like GetEntry does, while non-value
+Entries are omitted:
+
+	values, err := camellia.GetFlat("network/eth0")
+	// map[ipv4/address:10.0.0.1 mtu:1500]
+
+If the path is a value, it is returned under the empty path.
+*/
+func GetFlat(path string) (values map[string]string, err error) {
+	ctx, span := startSpan(context.Background(), "camellia.GetFlat", path)
+	defer func() {
+		span.End(err)
+	}()
+
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return nil, ErrNoDB
+	}
+
+	tx, err := beginReadTx(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error beginning transaction - %w", err)
+	}
+
+	path = normalizePath(path)
+	entry, err := getEntryDepth(path, -1, tx)
+	entry, err = withRuntime(path, -1, entry, err)
+	if err == nil {
+		err = interpolateEntry(entry, tx)
+	}
+
+	if err != nil {
+		rollbackTx(tx)
+		return nil, pathError("get", path, err)
+	}
+
+	err = endReadTx(ctx, tx)
+	if err != nil {
+		rollbackTx(tx)
+		return nil, fmt.Errorf("error committing transaction - %w", err)
+	}
+
+	return entry.Flatten(), nil
+}
@@ -0,0 +1,462 @@
+package camellia
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync/atomic"
+)
+
+/*
+mergedEntry is an Entry of a JSON representation imported with ImportJSONWithBase, without its children
+*/
+type mergedEntry struct {
+	isValue   bool
+	value     string
+	valueType ValueType
+}
+
+/*
+ImportJSONWithBase applies to the DB the changes made by the JSON representation read from reader relative to the one
+read from base, a snapshot of the same hierarchy the DB was last updated from, like a three-way merge. Both are read as
+specified by options, and OnlyMerge is ignored. options.Filter, if any, is applied to both, so that the values it skips
+are left untouched.
+
+Only the Entries the incoming representation added, changed or removed with respect to base are touched, so the local
+modifications made since base are preserved. Values added or changed by the incoming side are set, and the ones it
+removed are deleted, unless they were changed locally too. Non-value Entries removed by the incoming side are deleted
+once they are left empty.
+
+Values changed on both sides are conflicts: their local version is kept and their paths are returned, in order. Values
+changed on both sides in the same way are not conflicts. Untyped values are equal to typed ones with the same
+representation, so a DB updated with NativeTypes is merged correctly with a representation imported without it.
+
+Changes are applied in a single transaction. Hooks are called as specified by options.FireHooks.
+*/
+func ImportJSONWithBase(base io.Reader, reader io.Reader, options ImportOptions) ([]string, error) {
+	_, conflicts, err := importJSONWithBase(context.Background(), base, reader, options, false)
+	return conflicts, err
+}
+
+/*
+DryRunImportJSONWithBase behaves like ImportJSONWithBase, but instead of committing the changes to the DB, returns the
+list of changes that would be applied, along with the conflicts.
+*/
+func DryRunImportJSONWithBase(base io.Reader, reader io.Reader, options ImportOptions) ([]Change, []string, error) {
+	return importJSONWithBase(context.Background(), base, reader, options, true)
+}
+
+func importJSONWithBase(ctx context.Context, base io.Reader, reader io.Reader, options ImportOptions,
+	dryRun bool) (changes []Change, conflicts []string, err error) {
+	name := "camellia.ImportJSONWithBase"
+	if dryRun {
+		name = "camellia.DryRunImportJSONWithBase"
+	}
+
+	ctx, span := startSpan(ctx, name, "")
+	defer func() {
+		span.End(err)
+	}()
+
+	root := normalizePath(options.Path)
+
+	baseEntries, err := mergedEntries(base, root, options)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading base - %w", err)
+	}
+
+	incomingEntries, err := mergedEntries(reader, root, options)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return nil, nil, ErrNoDB
+	}
+
+	tx, err := beginTxCtx(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error beginning transaction - %w", err)
+	}
+
+	txWriter = options.Writer
+	defer func() {
+		txWriter = ""
+	}()
+
+	conflicts, err = mergeEntries(baseEntries, incomingEntries, tx)
+	if err == nil {
+		err = checkRequired(tx)
+	}
+
+	var imported []Change
+	if err == nil && options.FireHooks && !dryRun {
+		imported = append(imported, recordedChanges...)
+		err = callImportedPreSetHooks(imported)
+	}
+
+	if err != nil {
+		rollbackTx(tx)
+		return nil, nil, err
+	}
+
+	if dryRun {
+		changes = recordedChanges
+		recordChanges = false
+		recordedChanges = nil
+
+		sort.SliceStable(changes, func(i, j int) bool {
+			return changes[i].Path < changes[j].Path
+		})
+
+		err = rollbackTx(tx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error rolling back transaction - %w", err)
+		}
+
+		return changes, conflicts, nil
+	}
+
+	err = commitTx(tx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error committing transaction - %w", err)
+	}
+
+	callImportedPostSetHooks(imported)
+
+	return nil, conflicts, nil
+}
+
+/*
+mergedEntries reads the JSON representation from reader, returning its Entries by their path below root. root itself
+is omitted if it is the root Entry
+*/
+func mergedEntries(reader io.Reader, root string, options ImportOptions) (map[string]mergedEntry, error) {
+	entries := map[string]mergedEntry{}
+
+	reader, err := decompressedReader(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.Extended {
+		entry := Entry{}
+		err := json.NewDecoder(reader).Decode(&entry)
+		if err != nil {
+			return nil, err
+		}
+
+		rebaseEntry(&entry, root)
+
+		keep, err := filterImportedEntry(options.Filter, &entry)
+		if err != nil {
+			return nil, err
+		}
+
+		if keep {
+			flattenMergedEntry(&entry, entries)
+		}
+
+		return entries, nil
+	}
+
+	values := make(map[string]interface{})
+	decoder := json.NewDecoder(reader)
+	decoder.UseNumber()
+	err = decoder.Decode(&values)
+	if err != nil {
+		return nil, err
+	}
+
+	var visit func(path string, entry interface{}) error
+	visit = func(path string, entry interface{}) error {
+		m, ok := entry.(map[string]interface{})
+		if ok {
+			if path != "" {
+				entries[path] = mergedEntry{}
+			}
+
+			for k, v := range m {
+				err := visit(namespacePath(path, k), v)
+				if err != nil {
+					return err
+				}
+			}
+
+			return nil
+		}
+
+		value, valueType, err := importedJSONValue(entry, options.NativeTypes)
+		if err != nil {
+			return fmt.Errorf("invalid JSON entry at %s - %w", path, err)
+		}
+
+		value, keep, err := filterImportedValue(options.Filter, path, value)
+		if err != nil || !keep {
+			return err
+		}
+
+		entries[path] = mergedEntry{isValue: true, value: value, valueType: valueType}
+
+		return nil
+	}
+
+	err = visit(root, values)
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+/*
+flattenMergedEntry adds entry and its descendants to entries, by their path
+*/
+func flattenMergedEntry(entry *Entry, entries map[string]mergedEntry) {
+	if entry.Path != "" {
+		entries[entry.Path] = mergedEntry{isValue: entry.IsValue, value: entry.Value, valueType: entry.Type}
+	}
+
+	for _, child := range entry.Children {
+		flattenMergedEntry(child, entries)
+	}
+}
+
+/*
+equals returns whether e and other represent the same Entry. Untyped values equal typed ones with the same
+representation
+*/
+func (e mergedEntry) equals(other mergedEntry) bool {
+	if e.isValue != other.isValue {
+		return false
+	}
+
+	if !e.isValue {
+		return true
+	}
+
+	return e.value == other.value &&
+		(e.valueType == other.valueType || e.valueType == TypeUntyped || other.valueType == TypeUntyped)
+}
+
+/*
+mergeEntries applies to the DB the differences between base and incoming, skipping the Entries changed locally, whose
+paths are returned
+*/
+func mergeEntries(base map[string]mergedEntry, incoming map[string]mergedEntry, tx *sql.Tx) ([]string, error) {
+	paths := make([]string, 0, len(base)+len(incoming))
+	for p := range base {
+		paths = append(paths, p)
+	}
+
+	for p := range incoming {
+		if _, ok := base[p]; !ok {
+			paths = append(paths, p)
+		}
+	}
+
+	// Parents are sorted before their children
+	sort.Strings(paths)
+
+	conflicts := []string{}
+	inConflict := func(path string) bool {
+		for _, c := range conflicts {
+			if inMount(path, c) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	for _, p := range paths {
+		if inConflict(p) {
+			continue
+		}
+
+		b, inBase := base[p]
+		n, inIncoming := incoming[p]
+
+		// Unchanged by the incoming side
+		if inBase && inIncoming && b.equals(n) {
+			continue
+		}
+
+		local, err := localMergedEntry(p, tx)
+		if err != nil {
+			return nil, err
+		}
+
+		if !inIncoming {
+			// Removed non-value Entries are pruned once their children are merged
+			if !b.isValue || local == nil {
+				continue
+			}
+
+			if !local.equals(b) {
+				conflicts = append(conflicts, p)
+				continue
+			}
+
+			err = checkWritable(p)
+			if err == nil {
+				err = deletePath(p, tx)
+			}
+
+			if err != nil {
+				return nil, fmt.Errorf("error deleting %s - %w", p, err)
+			}
+
+			continue
+		}
+
+		if local != nil && local.equals(n) {
+			continue
+		}
+
+		unchanged, err := unchangedLocally(p, local, b, inBase, base, tx)
+		if err != nil {
+			return nil, err
+		}
+
+		if !unchanged {
+			conflicts = append(conflicts, p)
+			continue
+		}
+
+		if n.isValue {
+			err = setValue(p, n.value, n.valueType, tx, true, true)
+		} else {
+			if local != nil {
+				err = checkWritable(p)
+				if err == nil {
+					err = deletePath(p, tx)
+				}
+			}
+
+			if err == nil {
+				err = newValuesImporter(tx).ensureNonValue(p)
+			}
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("error merging %s - %w", p, err)
+		}
+	}
+
+	// Children are pruned before their parents
+	for i := len(paths) - 1; i >= 0; i-- {
+		p := paths[i]
+		b, inBase := base[p]
+		_, inIncoming := incoming[p]
+		if !inBase || b.isValue || inIncoming || inConflict(p) {
+			continue
+		}
+
+		local, err := getEntryDepth(p, 1, tx)
+		if errors.Is(err, ErrPathNotFound) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if local.IsValue || len(local.Children) > 0 {
+			continue
+		}
+
+		err = checkWritable(p)
+		if err == nil {
+			err = deletePath(p, tx)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("error deleting %s - %w", p, err)
+		}
+	}
+
+	return conflicts, nil
+}
+
+/*
+localMergedEntry returns the Entry at path in the DB, or nil if it doesn't exist
+*/
+func localMergedEntry(path string, tx *sql.Tx) (*mergedEntry, error) {
+	entry, err := getEntryDepth(path, 0, tx)
+	if errors.Is(err, ErrPathNotFound) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error getting %s - %w", path, err)
+	}
+
+	return &mergedEntry{isValue: entry.IsValue, value: entry.Value, valueType: entry.Type}, nil
+}
+
+/*
+unchangedLocally returns whether the Entry at path, local, is still the one in base, so that the incoming side can
+replace it. A missing Entry is unchanged only if it is missing from base too, and none of its ancestors became a value.
+A non-value Entry is unchanged only if its whole hierarchy is
+*/
+func unchangedLocally(path string, local *mergedEntry, b mergedEntry, inBase bool, base map[string]mergedEntry,
+	tx *sql.Tx) (bool, error) {
+	if local == nil {
+		if inBase {
+			return false, nil
+		}
+
+		for p := parentPath(path); p != ""; p = parentPath(p) {
+			isValue, err := pathIsValue(p, tx)
+			if errors.Is(err, ErrPathNotFound) {
+				continue
+			}
+
+			if err != nil {
+				return false, err
+			}
+
+			return !isValue, nil
+		}
+
+		return true, nil
+	}
+
+	if !inBase || !local.equals(b) {
+		return false, nil
+	}
+
+	if local.isValue {
+		return true, nil
+	}
+
+	entry, err := getEntryDepth(path, -1, tx)
+	if err != nil {
+		return false, fmt.Errorf("error getting %s - %w", path, err)
+	}
+
+	entries := map[string]mergedEntry{}
+	flattenMergedEntry(entry, entries)
+
+	for p, e := range entries {
+		if b, ok := base[p]; !ok || !b.equals(e) {
+			return false, nil
+		}
+	}
+
+	for p := range base {
+		if _, ok := entries[p]; !ok && inMount(p, path) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
@@ -0,0 +1,562 @@
+package camellia
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+const (
+	mountedTable       = "camellia_mounted"
+	mountedChunksTable = "camellia_chunks_mounted"
+	colFile            = "file"
+	colParentFile      = "parent_file"
+)
+
+/*
+MountOptions controls how a DB file is mounted with MountWithOptions.
+
+ReadOnly: open the file read-only, so that it can reside on a read-only partition. Writing, or deleting, Entries under
+the prefix fails with ErrReadOnly.
+*/
+type MountOptions struct {
+	ReadOnly bool
+}
+
+/*
+mountPoint is a DB file mounted at prefix (see Mount)
+*/
+type mountPoint struct {
+	prefix   string
+	path     string
+	readOnly bool
+}
+
+/*
+mounts are the DB files currently mounted, and mountsSchema the statements creating the views over them on every
+connection (see attachMounts). Both are modified while the global mutex is held, and the DB is reopened
+*/
+var mounts []mountPoint
+var mountsSchema string
+
+/*
+Mount makes the Entries under prefix in the DB file at the specified path appear under prefix in the open DB, so that
+several DB files, like factory defaults on a read-only partition and user settings on a data partition, are accessed
+as a single hierarchy. The file is created if it doesn't exist.
+
+Reads and writes of the Entries under prefix, including prefix itself, are routed to the mounted file, and those of
+the other Entries to the open DB, which holds the parents of prefix, created by Mount if needed. A mounted file is a
+regular camellia DB: it can be opened on its own, where its Entries are at the same paths, and only the ones under
+prefix are visible when mounted. The change log, the revision, deprecations and config versions are kept in the open
+DB only.
+
+Transactions span all the files, but are atomic only within each file, as the open DB uses a write-ahead log.
+
+Mount fails with ErrPathInvalid if prefix is the root, overlaps another mount, or already exists in the open DB. The
+mounted file must be at the schema version of the library (see Migrate). Mounts are not supported on encrypted DBs, and
+are undone by Close.
+*/
+func Mount(prefix string, file string) error {
+	return MountWithOptions(prefix, file, MountOptions{})
+}
+
+/*
+MountWithOptions calls Mount, mounting the file as specified by options.
+*/
+func MountWithOptions(prefix string, file string, options MountOptions) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return ErrNoDB
+	}
+
+	prefix = normalizePath(prefix)
+	if prefix == "" {
+		return ErrPathInvalid
+	}
+
+	if dbKey != "" {
+		return fmt.Errorf("mounts are not supported on encrypted DBs")
+	}
+
+	if defaultsPath != "" {
+		return fmt.Errorf("mounts are not supported with defaults")
+	}
+
+	path, err := filepath.Abs(file)
+	if err != nil {
+		return fmt.Errorf("error resolving path of %s - %w", file, err)
+	}
+
+	main, err := filepath.Abs(dbPath)
+	if err == nil && main == path {
+		return fmt.Errorf("%s is the open DB", file)
+	}
+
+	for _, m := range mounts {
+		if m.prefix == prefix || strings.HasPrefix(prefix, m.prefix+"/") || strings.HasPrefix(m.prefix, prefix+"/") {
+			return fmt.Errorf("%w - %s overlaps the mount at %s", ErrPathInvalid, prefix, m.prefix)
+		}
+
+		if m.path == path {
+			return fmt.Errorf("%s is already mounted at %s", file, m.prefix)
+		}
+	}
+
+	for _, p := range providers {
+		if p.prefix != "" && (p.prefix == prefix || strings.HasPrefix(prefix, p.prefix+"/") ||
+			strings.HasPrefix(p.prefix, prefix+"/")) {
+			return fmt.Errorf("%w - %s overlaps the mount at %s", ErrPathInvalid, prefix, p.prefix)
+		}
+	}
+
+	mount := mountPoint{prefix: prefix, path: path, readOnly: options.ReadOnly}
+
+	err = createMountParents(prefix)
+	if err != nil {
+		return err
+	}
+
+	err = initMountFile(mount)
+	if err != nil {
+		return fmt.Errorf("error initializing %s - %w", file, err)
+	}
+
+	err = remount(append(mounts[:len(mounts):len(mounts)], mount))
+	if err != nil {
+		return err
+	}
+
+	logInfo("mounted DB", "prefix", prefix, "path", path, "read_only", options.ReadOnly)
+
+	return nil
+}
+
+/*
+Unmount removes the DB file mounted at prefix (see Mount), or the virtual mount at prefix (see MountEnv). Its Entries
+are not visible anymore, while the parents of prefix created by Mount are left in the open DB.
+*/
+func Unmount(prefix string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return ErrNoDB
+	}
+
+	prefix = normalizePath(prefix)
+
+	if unmountVirtual(prefix) {
+		logInfo("unmounted virtual source", "prefix", prefix)
+		return nil
+	}
+
+	remaining := []mountPoint{}
+	for _, m := range mounts {
+		if m.prefix != prefix {
+			remaining = append(remaining, m)
+		}
+	}
+
+	if len(remaining) == len(mounts) {
+		return fmt.Errorf("%w - nothing is mounted at %s", ErrPathNotFound, prefix)
+	}
+
+	err := remount(remaining)
+	if err != nil {
+		return err
+	}
+
+	logInfo("unmounted DB", "prefix", prefix)
+
+	return nil
+}
+
+/*
+initMountFile creates the file of mount, with the parents of its prefix, if missing and writable, and verifies its
+schema version
+*/
+func initMountFile(mount mountPoint) error {
+	d, err := sql.Open("sqlite3", mountURI(mount))
+	if err != nil {
+		return err
+	}
+
+	defer d.Close()
+
+	version, err := getDBVersion(d)
+	if err != nil {
+		return err
+	}
+
+	if version == 0 && !mount.readOnly {
+		_, err = migrate(d)
+		if err != nil {
+			return err
+		}
+
+		version = dbVersion
+	}
+
+	if version != dbVersion {
+		return ErrDBVersionMismatch
+	}
+
+	if mount.readOnly {
+		return nil
+	}
+
+	// Like the open DB, so that reads don't block writes
+	_, err = d.Exec("PRAGMA journal_mode = WAL")
+	if err != nil {
+		return err
+	}
+
+	// The parents of prefix are created in the file too, so that it's a complete hierarchy when opened on its own
+	segments := splitPath(mount.prefix)
+	for i := 1; i < len(segments); i++ {
+		path := joinPath(segments[:i])
+		_, err = d.Exec(fmt.Sprintf(
+			"INSERT OR IGNORE INTO %s (%s, %s, %s, %s, %s) VALUES (?, ?, 0, (SELECT %s FROM %s WHERE %s = ?), ?)",
+			table, colPath, colLastUpdateMs, colIsValue, colParentID, colWriter, colID, table, colPath),
+			path, time.Now().UnixMilli(), parentPath(path), currentWriter())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+createMountParents creates the parents of prefix in the open DB, failing if prefix already exists
+*/
+func createMountParents(prefix string) error {
+	tx, err := beginTx()
+	if err != nil {
+		return fmt.Errorf("error beginning transaction - %w", err)
+	}
+
+	found, err := exists(prefix, tx)
+	if err == nil && found {
+		err = fmt.Errorf("%w - %s already exists", ErrPathInvalid, prefix)
+	}
+
+	segments := splitPath(prefix)
+	for i := 1; i < len(segments) && err == nil; i++ {
+		path := joinPath(segments[:i])
+
+		var isValue bool
+		isValue, err = pathIsValue(path, tx)
+		if err == nil && isValue {
+			err = fmt.Errorf("%w - %s is a value", ErrPathInvalid, path)
+		} else if errors.Is(err, ErrPathNotFound) {
+			_, err = pathStmt(tx, "insertNonValueEntry", path).Exec(path, time.Now().UnixMilli(), parentPath(path),
+				currentWriter())
+		}
+	}
+
+	if err != nil {
+		rollbackTx(tx)
+		return err
+	}
+
+	err = commitTx(tx)
+	if err != nil {
+		rollbackTx(tx)
+		return fmt.Errorf("error committing transaction - %w", err)
+	}
+
+	return nil
+}
+
+/*
+remount reopens the DB with the mounts in m, restoring the current ones on failure. Must be called while the global
+mutex is held
+*/
+func remount(m []mountPoint) error {
+	err := flushBuffered(context.Background())
+	if err != nil {
+		return fmt.Errorf("error flushing buffered values - %w", err)
+	}
+
+	previous, previousSchema := mounts, mountsSchema
+	path, options := dbPath, dbOptions
+
+	err = closeDB()
+	if err != nil {
+		return fmt.Errorf("error closing DB - %w", err)
+	}
+
+	mounts, mountsSchema = m, buildMountsSchema(m)
+
+	_, _, err = openDB(path, options, false)
+	if err != nil {
+		mounts, mountsSchema = previous, previousSchema
+
+		_, _, reopenErr := openDB(path, options, false)
+		if reopenErr != nil {
+			atomic.StoreInt32(&initialized, 0)
+			logError("error reopening DB after failed mount", "path", path, "error", reopenErr)
+		}
+
+		return fmt.Errorf("error reopening DB - %w", err)
+	}
+
+	return nil
+}
+
+/*
+attachMounts attaches the mounted files to a new connection, and creates the views over them. Called by the driver
+*/
+func attachMounts(conn *sqlite3.SQLiteConn) error {
+	if len(mounts) == 0 {
+		return nil
+	}
+
+	for i, m := range mounts {
+		_, err := conn.Exec(fmt.Sprintf("ATTACH DATABASE ? AS %s", mountSchemaName(i)), []driver.Value{mountURI(m)})
+		if err != nil {
+			return fmt.Errorf("error attaching %s - %w", m.path, err)
+		}
+	}
+
+	_, err := conn.Exec(mountsSchema, nil)
+	return err
+}
+
+/*
+inMount returns whether path is prefix or one of its descendants
+*/
+func inMount(path string, prefix string) bool {
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+/*
+checkWritable verifies that the Entry at path can be written, failing with ErrReadOnly if it's in a read-only mount or
+computed by a provider
+*/
+func checkWritable(path string) error {
+	for _, m := range mounts {
+		if m.readOnly && inMount(path, m.prefix) {
+			return fmt.Errorf("%w - %s is mounted read-only at %s", ErrReadOnly, path, m.prefix)
+		}
+	}
+
+	if p := providerFor(path); p != nil {
+		return fmt.Errorf("%w - %s is computed by the provider of %s", ErrReadOnly, path, p.pattern)
+	}
+
+	for _, p := range providers {
+		if p.prefix == path {
+			return fmt.Errorf("%w - %s is a virtual mount", ErrReadOnly, path)
+		}
+	}
+
+	return nil
+}
+
+/*
+checkDeletable verifies that the Entry at path and its children can be deleted, failing with ErrReadOnly if any of them
+is in a read-only mount
+*/
+func checkDeletable(path string) error {
+	for _, m := range mounts {
+		if m.readOnly && (path == "" || inMount(m.prefix, path)) {
+			return fmt.Errorf("%w - %s contains the read-only mount at %s", ErrReadOnly, path, m.prefix)
+		}
+	}
+
+	return checkWritable(path)
+}
+
+/*
+pathStmt returns the write statement name, bound to tx, of the file where the Entry at path is stored: the one of the
+mount containing path, or the open DB
+*/
+func pathStmt(tx *sql.Tx, name string, path string) *sql.Stmt {
+	for i, m := range mounts {
+		if inMount(path, m.prefix) {
+			return txStmt(tx, name+mountStmtSuffix(i))
+		}
+	}
+
+	return txStmt(tx, name)
+}
+
+/*
+deleteMounted deletes the mounted Entries, along with their chunks, under the Entry at path, which is being deleted
+from its own file
+*/
+func deleteMounted(path string, tx *sql.Tx) error {
+	for i, m := range mounts {
+		if !strings.HasPrefix(m.prefix, path+"/") {
+			continue
+		}
+
+		_, err := txStmt(tx, "deleteEntry"+mountStmtSuffix(i)).Exec(m.prefix)
+		if err != nil {
+			return err
+		}
+
+		_, err = txStmt(tx, "deleteSubtreeChunks"+mountStmtSuffix(i)).Exec(m.prefix)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+entriesSource and chunksSource return the table, or the view with mounts, the Entries and the chunks are read from
+*/
+func entriesSource() string {
+	if len(mounts) > 0 {
+		return mountedTable
+	} else if defaultsPath != "" {
+		return overlayTable
+	}
+
+	return table
+}
+
+func chunksSource() string {
+	if len(mounts) > 0 {
+		return mountedChunksTable
+	} else if defaultsPath != "" {
+		return overlayChunksTable
+	}
+
+	return chunksTable
+}
+
+func mountSchemaName(i int) string {
+	return fmt.Sprintf("camellia_mount_%d", i)
+}
+
+func mountStmtSuffix(i int) string {
+	return fmt.Sprintf("@mount%d", i)
+}
+
+func mountURI(m mountPoint) string {
+	mode := "rwc"
+	if m.readOnly {
+		mode = "ro"
+	}
+
+	u := url.URL{Scheme: "file", Path: filepath.ToSlash(m.path), RawQuery: "mode=" + mode}
+	return u.String()
+}
+
+/*
+quoteSQL quotes s as an SQL string literal
+*/
+func quoteSQL(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+/*
+buildMountsSchema returns the statements creating, on a connection where the files in m are attached, the views over
+the Entries and the chunks of all the files. Writes are not made through the views, but routed to the file of each
+path by pathStmt, as SQLite triggers can't write to attached files.
+
+Each file has its own IDs, so the views identify the rows by file (0 for the open DB, i + 1 for the mount i) and ID.
+The mounted prefixes appear as children of their parents in the open DB, while the Entries of the mounted files
+outside of the prefixes are hidden
+*/
+func buildMountsSchema(m []mountPoint) string {
+	if len(m) == 0 {
+		return ""
+	}
+
+	cols := strings.Join([]string{colPath, colLastUpdateMs, colIsValue, colValue, colValueType, colBlobValue,
+		colChecksum, colWriter, colRevision}, ", ")
+
+	entriesView := []string{fmt.Sprintf("SELECT %s, %s, %s, 0 AS %s, 0 AS %s FROM main.%s",
+		colID, colParentID, cols, colFile, colParentFile, table)}
+	chunksView := []string{fmt.Sprintf("SELECT %s, %s, %s, %s, 0 AS %s FROM main.%s",
+		colPath, colSeq, colData, colChecksum, colFile, chunksTable)}
+
+	for i, mount := range m {
+		schema := mountSchemaName(i)
+		file := i + 1
+		prefix := quoteSQL(mount.prefix)
+
+		// Paths under prefix sort between "prefix/" and prefix followed by the character after '/'
+		descendant := fmt.Sprintf("(%[1]s > %[2]s AND %[1]s < %[3]s)", colPath, quoteSQL(mount.prefix+"/"),
+			quoteSQL(mount.prefix+"0"))
+
+		entriesView = append(entriesView,
+			fmt.Sprintf("SELECT %s, (SELECT %s FROM main.%s WHERE %s = %s), %s, %d, 0 FROM %s.%s WHERE %s = %s",
+				colID, colID, table, colPath, quoteSQL(parentPath(mount.prefix)), cols, file, schema, table, colPath,
+				prefix),
+			fmt.Sprintf("SELECT %s, %s, %s, %d, %d FROM %s.%s WHERE %s",
+				colID, colParentID, cols, file, file, schema, table, descendant))
+		chunksView = append(chunksView, fmt.Sprintf("SELECT %s, %s, %s, %s, %d FROM %s.%s WHERE %s = %s OR %s",
+			colPath, colSeq, colData, colChecksum, file, schema, chunksTable, colPath, prefix, descendant))
+	}
+
+	return fmt.Sprintf("CREATE TEMP VIEW %s (%s, %s, %s, %s, %s) AS %s;\nCREATE TEMP VIEW %s AS %s;",
+		mountedTable, colID, colParentID, cols, colFile, colParentFile, strings.Join(entriesView, " UNION ALL "),
+		mountedChunksTable, strings.Join(chunksView, " UNION ALL "))
+}
+
+/*
+mountedSubtreeQuery returns the query of the getSubtree statement with mounts. It walks the subtree like the one
+without mounts, except that the children are looked up in each file separately, through their parent_index, since the
+views over all the files can't be joined efficiently
+*/
+func mountedSubtreeQuery() string {
+	selectCols := subtreeColumns()
+	limit := "(?2 < 0 OR s.depth < ?2)"
+
+	steps := []string{fmt.Sprintf(
+		"SELECT 0, e.%s, s.depth + 1 FROM subtree s JOIN main.%s e ON e.%s = s.id WHERE s.file = 0 AND %s",
+		colID, table, colParentID, limit)}
+	results := []string{fmt.Sprintf(
+		"SELECT %s, p.%s, s.depth FROM subtree s JOIN main.%s e ON e.%s = s.id LEFT JOIN main.%s p ON p.%s = e.%s "+
+			"WHERE s.file = 0",
+		selectCols, colPath, table, colID, table, colID, colParentID)}
+
+	for i, mount := range mounts {
+		schema := mountSchemaName(i)
+		file := i + 1
+		prefix := quoteSQL(mount.prefix)
+		parent := quoteSQL(parentPath(mount.prefix))
+
+		steps = append(steps,
+			fmt.Sprintf("SELECT %d, e.%s, s.depth + 1 FROM subtree s JOIN %s.%s e ON e.%s = %s "+
+				"WHERE s.file = 0 AND s.id = (SELECT %s FROM main.%s WHERE %s = %s) AND %s",
+				file, colID, schema, table, colPath, prefix, colID, table, colPath, parent, limit),
+			fmt.Sprintf("SELECT %d, e.%s, s.depth + 1 FROM subtree s JOIN %s.%s e ON e.%s = s.id "+
+				"WHERE s.file = %d AND %s",
+				file, colID, schema, table, colParentID, file, limit))
+		results = append(results, fmt.Sprintf(
+			"SELECT %s, CASE WHEN e.%s = %s THEN %s ELSE p.%s END, s.depth FROM subtree s JOIN %s.%s e ON e.%s = s.id "+
+				"LEFT JOIN %s.%s p ON p.%s = e.%s WHERE s.file = %d",
+			selectCols, colPath, prefix, parent, colPath, schema, table, colID, schema, table, colID, colParentID,
+			file))
+	}
+
+	return fmt.Sprintf(
+		`WITH RECURSIVE subtree (file, id, depth) AS (
+			SELECT %s, %s, 0 FROM %s WHERE %s = ?1
+			UNION ALL
+			%s
+		)
+		%s
+		ORDER BY 11, 1`,
+		colFile, colID, mountedTable, colPath, strings.Join(steps, "\nUNION ALL\n"),
+		strings.Join(results, "\nUNION ALL\n"))
+}
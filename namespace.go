@@ -0,0 +1,233 @@
+package camellia
+
+import (
+	"context"
+	"io"
+	"strings"
+)
+
+/*
+NS is a handle to a namespace of the DB, returned by Namespace.
+*/
+type NS struct {
+	prefix string
+}
+
+/*
+Namespace returns a handle to the namespace at the specified path, so that multiple applications can share the same DB
+file without interfering with each other.
+
+Every operation of the handle is confined under the path: paths are relative to it, including the ones of the returned
+Entries and Changes and the ones passed to hooks, and Wipe only deletes the Entries of the namespace. Paths can't
+escape the namespace, even when resolving ".." segments (see PathRules). The namespace itself is created with its
+first value.
+
+The package-level API still sees the whole hierarchy, with the namespaces as regular Entries.
+*/
+func Namespace(path string) *NS {
+	return &NS{prefix: normalizePath(path)}
+}
+
+/*
+Namespace returns a handle to the namespace at the specified path, relative to n.
+*/
+func (n *NS) Namespace(path string) *NS {
+	return &NS{prefix: n.Path(path)}
+}
+
+/*
+Path returns the path in the DB of the specified path of the namespace.
+*/
+func (n *NS) Path(path string) string {
+	return namespacePath(n.prefix, path)
+}
+
+/*
+Update runs fn inside a write transaction, like the package-level Update, resolving the paths passed to the Tx in the
+namespace.
+*/
+func (n *NS) Update(fn func(tx *Tx) error) error {
+	return n.UpdateCtx(context.Background(), fn)
+}
+
+/*
+UpdateCtx calls Update, tracing the transaction as a child of ctx (see SetTracer).
+*/
+func (n *NS) UpdateCtx(ctx context.Context, fn func(tx *Tx) error) error {
+	return UpdateCtx(ctx, func(tx *Tx) error {
+		prefix := tx.prefix
+		tx.prefix = n.prefix
+		defer func() {
+			tx.prefix = prefix
+		}()
+
+		return fn(tx)
+	})
+}
+
+/*
+Set sets value to the specified path of the namespace. value can be of any type supported by the package-level Set.
+*/
+func (n *NS) Set(path string, value any) error {
+	return n.Update(func(tx *Tx) error {
+		return tx.Set(path, value)
+	})
+}
+
+/*
+Force sets value to the specified path of the namespace, deleting any non-value Entry existing at the path first.
+*/
+func (n *NS) Force(path string, value any) error {
+	return n.Update(func(tx *Tx) error {
+		return tx.Force(path, value)
+	})
+}
+
+/*
+Get returns the value at the specified path of the namespace, as a string.
+*/
+func (n *NS) Get(path string) (string, error) {
+	return Get[string](n.Path(path))
+}
+
+/*
+GetEntry returns the Entry at the specified path of the namespace, including its children, with paths relative to the
+namespace.
+*/
+func (n *NS) GetEntry(path string) (*Entry, error) {
+	entry, err := GetEntry(n.Path(path))
+	if err != nil {
+		return nil, err
+	}
+
+	relativizeEntry(entry, n.prefix)
+
+	return entry, nil
+}
+
+/*
+Exists returns whether an Entry exists at the specified path of the namespace.
+*/
+func (n *NS) Exists(path string) (bool, error) {
+	return Exists(n.Path(path))
+}
+
+/*
+Delete recursively deletes the Entry at the specified path of the namespace and its children, if any.
+*/
+func (n *NS) Delete(path string) error {
+	return Delete(n.Path(path))
+}
+
+/*
+Wipe deletes every Entry of the namespace, leaving the rest of the DB untouched.
+*/
+func (n *NS) Wipe() error {
+	if n.prefix == "" {
+		return Wipe()
+	}
+
+	return Delete(n.prefix)
+}
+
+/*
+ExportJSON writes the hierarchy of Entries at the specified path of the namespace to w, like the package-level
+ExportJSON.
+*/
+func (n *NS) ExportJSON(path string, w io.Writer, options ExportOptions) error {
+	return ExportJSON(n.Path(path), w, options)
+}
+
+/*
+ImportJSON sets the Entries found in the JSON representation read from reader in the namespace, at options.Path
+relative to it, like the package-level ImportJSON.
+*/
+func (n *NS) ImportJSON(reader io.Reader, options ImportOptions) error {
+	options.Path = n.Path(options.Path)
+	return ImportJSON(reader, options)
+}
+
+/*
+SetPreSetHook registers a callback to be called before the value at the specified path of the namespace is changed,
+like the package-level SetPreSetHook. The callback receives paths relative to the namespace.
+*/
+func (n *NS) SetPreSetHook(path string, callback func(path string, value string) error) error {
+	return SetPreSetHook(n.Path(path), n.relativeCallback(callback))
+}
+
+/*
+SetPostSetHook registers a callback to be called after the value at the specified path of the namespace is changed,
+like the package-level SetPostSetHook. The callback receives paths relative to the namespace.
+*/
+func (n *NS) SetPostSetHook(path string, callback func(path string, value string) error, async bool) error {
+	return SetPostSetHook(n.Path(path), n.relativeCallback(callback), async)
+}
+
+/*
+Watch calls callback with the changes of the Entry at the specified path of the namespace, or of its children, like the
+package-level Watch. The Changes have paths relative to the namespace.
+*/
+func (n *NS) Watch(path string, callback func(change Change)) (func(), error) {
+	return Watch(n.Path(path), func(change Change) {
+		change.Path = relativePath(n.prefix, change.Path)
+		callback(change)
+	})
+}
+
+func (n *NS) relativeCallback(callback func(path string, value string) error) func(path string, value string) error {
+	return func(path string, value string) error {
+		return callback(relativePath(n.prefix, path), value)
+	}
+}
+
+/*
+namespacePath normalizes path and prepends prefix to it. path is normalized alone, so that resolving ".." segments
+never escapes prefix
+*/
+func namespacePath(prefix string, path string) string {
+	path = normalizePath(path)
+	if prefix == "" {
+		return path
+	}
+
+	if path == "" {
+		return prefix
+	}
+
+	return prefix + "/" + path
+}
+
+/*
+relativePath returns path relative to prefix, which must be a parent of path, or path itself
+*/
+func relativePath(prefix string, path string) string {
+	if prefix == "" {
+		return path
+	}
+
+	return strings.TrimPrefix(strings.TrimPrefix(path, prefix), "/")
+}
+
+/*
+relativizeEntry makes the paths of entry and of its children relative to prefix
+*/
+func relativizeEntry(entry *Entry, prefix string) {
+	if prefix == "" {
+		return
+	}
+
+	entry.Path = relativePath(prefix, entry.Path)
+	for _, child := range entry.Children {
+		relativizeEntry(child, prefix)
+	}
+}
+
+/*
+rebaseEntry prepends prefix to the paths of entry and of its children
+*/
+func rebaseEntry(entry *Entry, prefix string) {
+	entry.Path = namespacePath(prefix, entry.Path)
+	for _, child := range entry.Children {
+		rebaseEntry(child, prefix)
+	}
+}
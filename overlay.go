@@ -0,0 +1,422 @@
+package camellia
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+const (
+	defaultsSchema     = "camellia_defaults"
+	overlayTable       = "camellia_overlay"
+	overlayChunksTable = "camellia_chunks_overlay"
+)
+
+/*
+overlayEntryColumns are the columns of the Entries read through the views over both layers
+*/
+var overlayEntryColumns = strings.Join([]string{colPath, colLastUpdateMs, colIsValue, colValue, colValueType,
+	colBlobValue, colChecksum, colWriter, colRevision}, ", ")
+
+/*
+defaultsPath is the absolute path of the defaults layer of the open DB (see Options.Defaults), or an empty string
+*/
+var defaultsPath string
+
+/*
+checkDefaults verifies that the defaults layer in options can be opened, returning its absolute path
+*/
+func checkDefaults(options Options) (string, error) {
+	if options.Defaults == "" {
+		return "", nil
+	}
+
+	if options.EncryptionKey != "" {
+		return "", fmt.Errorf("defaults are not supported on encrypted DBs")
+	}
+
+	path, err := filepath.Abs(options.Defaults)
+	if err != nil {
+		return "", fmt.Errorf("error resolving path of %s - %w", options.Defaults, err)
+	}
+
+	d, err := sql.Open("sqlite3", mountURI(mountPoint{path: path, readOnly: true}))
+	if err != nil {
+		return "", err
+	}
+
+	defer d.Close()
+
+	version, err := getDBVersion(d)
+	if err != nil {
+		return "", fmt.Errorf("error opening defaults %s - %w", options.Defaults, err)
+	}
+
+	if version != dbVersion {
+		return "", fmt.Errorf("defaults %s - %w", options.Defaults, ErrDBVersionMismatch)
+	}
+
+	return path, nil
+}
+
+/*
+openDefaults reopens the DB, opened from dataSource, so that the defaults at path are attached to every connection.
+The views over the defaults are created only once the DB is migrated, as they would prevent renaming its tables
+*/
+func openDefaults(path string, dataSource string, options Options) error {
+	err := db.Close()
+	if err != nil {
+		return err
+	}
+
+	defaultsPath = path
+
+	db, err = sql.Open(driverName, dataSource)
+	if err != nil {
+		return err
+	}
+
+	return configurePool(options)
+}
+
+/*
+attachDefaults attaches the defaults layer to a new connection, and creates the views over both layers. Called by
+the driver
+*/
+func attachDefaults(conn *sqlite3.SQLiteConn) error {
+	if defaultsPath == "" {
+		return nil
+	}
+
+	_, err := conn.Exec(fmt.Sprintf("ATTACH DATABASE ? AS %s", defaultsSchema),
+		[]driver.Value{mountURI(mountPoint{path: defaultsPath, readOnly: true})})
+	if err != nil {
+		return fmt.Errorf("error attaching defaults %s - %w", defaultsPath, err)
+	}
+
+	_, err = conn.Exec(overlaySchema(), nil)
+	return err
+}
+
+/*
+overlaySchema returns the statements creating the views over the Entries and the chunks of both layers, where the
+ones of the user layer (the open DB) hide the ones at the same paths of the defaults. Writes are not made through the
+views, but to the user layer, copying the overridden Entries from the defaults first (see copyUp)
+*/
+func overlaySchema() string {
+	notOverridden := fmt.Sprintf("NOT EXISTS (SELECT 1 FROM main.%s u WHERE u.%s = d.%s)", table, colPath, colPath)
+
+	return fmt.Sprintf(`CREATE TEMP VIEW %[1]s AS
+			SELECT %[3]s FROM main.%[4]s
+			UNION ALL
+			SELECT %[3]s FROM %[5]s.%[4]s d WHERE %[6]s;
+		CREATE TEMP VIEW %[2]s AS
+			SELECT %[7]s, %[8]s, %[9]s, %[10]s FROM main.%[11]s
+			UNION ALL
+			SELECT %[7]s, %[8]s, %[9]s, %[10]s FROM %[5]s.%[11]s d WHERE %[6]s;`,
+		overlayTable, overlayChunksTable, overlayEntryColumns, table, defaultsSchema, notOverridden, colPath, colSeq,
+		colData, colChecksum, chunksTable)
+}
+
+/*
+overlayChildrenQuery returns the query of the getChildren statement with defaults, reading the children of both
+layers through their parent_index
+*/
+func overlayChildrenQuery() string {
+	return fmt.Sprintf(`SELECT %[1]s FROM main.%[2]s WHERE %[3]s = (SELECT %[4]s FROM main.%[2]s WHERE %[5]s = ?1)
+		UNION ALL
+		SELECT %[1]s FROM %[6]s.%[2]s d WHERE %[3]s = (SELECT %[4]s FROM %[6]s.%[2]s WHERE %[5]s = ?1)
+			AND NOT EXISTS (SELECT 1 FROM main.%[2]s u WHERE u.%[5]s = d.%[5]s)
+		ORDER BY %[5]s`,
+		overlayEntryColumns, table, colParentID, colID, colPath, defaultsSchema)
+}
+
+/*
+overlaySubtreeQuery returns the query of the getSubtree statement with defaults. It walks the subtree like the one
+without defaults, in each layer separately. The user layer holds all the parents of its Entries, so the children in
+the defaults are looked up from the user layer by path, and the children of the Entries in the defaults only are in
+the defaults only
+*/
+func overlaySubtreeQuery() string {
+	return fmt.Sprintf(
+		`WITH RECURSIVE subtree (layer, id, depth) AS (
+			SELECT 0, %[1]s, 0 FROM main.%[2]s WHERE %[3]s = ?1
+			UNION ALL
+			SELECT 1, %[1]s, 0 FROM %[4]s.%[2]s WHERE %[3]s = ?1
+				AND NOT EXISTS (SELECT 1 FROM main.%[2]s WHERE %[3]s = ?1)
+			UNION ALL
+			SELECT 0, e.%[1]s, s.depth + 1 FROM subtree s JOIN main.%[2]s e ON e.%[5]s = s.id
+				WHERE s.layer = 0 AND %[6]s
+			UNION ALL
+			SELECT 1, e.%[1]s, s.depth + 1 FROM subtree s JOIN main.%[2]s u ON u.%[1]s = s.id
+				JOIN %[4]s.%[2]s p ON p.%[3]s = u.%[3]s JOIN %[4]s.%[2]s e ON e.%[5]s = p.%[1]s
+				WHERE s.layer = 0 AND %[6]s AND NOT EXISTS (SELECT 1 FROM main.%[2]s o WHERE o.%[3]s = e.%[3]s)
+			UNION ALL
+			SELECT 1, e.%[1]s, s.depth + 1 FROM subtree s JOIN %[4]s.%[2]s e ON e.%[5]s = s.id
+				WHERE s.layer = 1 AND %[6]s
+		)
+		SELECT %[7]s, p.%[3]s, s.depth FROM subtree s JOIN main.%[2]s e ON e.%[1]s = s.id
+			LEFT JOIN main.%[2]s p ON p.%[1]s = e.%[5]s WHERE s.layer = 0
+		UNION ALL
+		SELECT %[7]s, p.%[3]s, s.depth FROM subtree s JOIN %[4]s.%[2]s e ON e.%[1]s = s.id
+			LEFT JOIN %[4]s.%[2]s p ON p.%[1]s = e.%[5]s WHERE s.layer = 1
+		ORDER BY 11, 1`,
+		colID, table, colPath, defaultsSchema, colParentID, "(?2 < 0 OR s.depth < ?2)", subtreeColumns())
+}
+
+/*
+prepareOverlayStatements prepares the statements copying the Entries from the defaults to the user layer
+*/
+func prepareOverlayStatements() error {
+	var err error
+
+	stmts["copyUpEntry"], err = db.Prepare(fmt.Sprintf(
+		`INSERT INTO main.%[1]s (%[2]s, %[3]s) SELECT %[2]s, (SELECT %[4]s FROM main.%[1]s WHERE %[5]s = ?2)
+		FROM %[6]s.%[1]s WHERE %[5]s = ?1 AND NOT EXISTS (SELECT 1 FROM main.%[1]s WHERE %[5]s = ?1)`,
+		table, overlayEntryColumns, colParentID, colID, colPath, defaultsSchema))
+
+	if err != nil {
+		return err
+	}
+
+	stmts["copyUpChunks"], err = db.Prepare(fmt.Sprintf(
+		"INSERT INTO main.%[1]s (%[2]s, %[3]s, %[4]s, %[5]s) SELECT %[2]s, %[3]s, %[4]s, %[5]s FROM %[6]s.%[1]s "+
+			"WHERE %[2]s = ?",
+		chunksTable, colPath, colSeq, colData, colChecksum, defaultsSchema))
+
+	if err != nil {
+		return err
+	}
+
+	stmts["isDefault"], err = db.Prepare(fmt.Sprintf(
+		"SELECT EXISTS (SELECT 1 FROM %s.%s WHERE %s = ?)",
+		defaultsSchema, table, colPath))
+
+	if err != nil {
+		return err
+	}
+
+	stmts["getOverrideChildren"], err = db.Prepare(fmt.Sprintf(
+		"SELECT %[1]s FROM main.%[2]s WHERE %[3]s = (SELECT %[4]s FROM main.%[2]s WHERE %[1]s = ?)",
+		colPath, table, colParentID, colID))
+
+	return err
+}
+
+/*
+copyUp copies the Entry at path, along with its parents, from the defaults to the user layer, unless already there,
+so that it can be written. The user layer always holds all the parents of its Entries
+*/
+func copyUp(path string, tx *sql.Tx) error {
+	if defaultsPath == "" || path == "" {
+		return nil
+	}
+
+	err := copyUp(parentPath(path), tx)
+	if err != nil {
+		return err
+	}
+
+	result, err := txStmt(tx, "copyUpEntry").Exec(path, parentPath(path))
+	if err != nil {
+		return fmt.Errorf("error copying default %s - %w", path, err)
+	}
+
+	copied, err := result.RowsAffected()
+	if err != nil || copied == 0 {
+		return err
+	}
+
+	_, err = txStmt(tx, "copyUpChunks").Exec(path)
+	if err != nil {
+		return fmt.Errorf("error copying default chunks of %s - %w", path, err)
+	}
+
+	return nil
+}
+
+/*
+checkNotDefault verifies that the Entry at path is not in the defaults, failing with ErrReadOnly otherwise, as the
+defaults can't be deleted, but only overridden
+*/
+func checkNotDefault(path string, tx *sql.Tx) error {
+	if defaultsPath == "" {
+		return nil
+	}
+
+	isDefault := false
+	err := txStmt(tx, "isDefault").QueryRow(path).Scan(&isDefault)
+	if err != nil {
+		return err
+	}
+
+	if isDefault {
+		return fmt.Errorf("%w - %s is a default, use Reset to remove its override", ErrReadOnly, path)
+	}
+
+	return nil
+}
+
+/*
+Reset removes the overrides of the Entry at the specified path, and of its children, from the user layer of a DB
+opened with Options.Defaults, so that their default values are returned again. The Entries not in the defaults are
+deleted, and those overridden by a different value are reported as updated to the watchers and the post set hooks.
+Reset("") restores the whole DB to the defaults, like a factory reset.
+
+Without Options.Defaults, Reset is the same as Delete.
+*/
+func Reset(path string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return ErrNoDB
+	}
+
+	tx, err := beginTx()
+	if err != nil {
+		return fmt.Errorf("error beginning transaction - %w", err)
+	}
+
+	err = resetPath(normalizePath(path), tx)
+	if err != nil {
+		rollbackTx(tx)
+		return err
+	}
+
+	err = checkRequired(tx)
+	if err != nil {
+		rollbackTx(tx)
+		return err
+	}
+
+	err = commitTx(tx)
+	if err != nil {
+		return fmt.Errorf("error committing transaction - %w", err)
+	}
+
+	return nil
+}
+
+/*
+resetPath removes the overrides under path, recording the changes of the visible Entries
+*/
+func resetPath(path string, tx *sql.Tx) error {
+	if defaultsPath == "" {
+		return deletePath(path, tx)
+	}
+
+	before, err := getEntryDepth(path, -1, tx)
+	if errors.Is(err, ErrPathNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	// The root is never deleted, only its children
+	targets := []string{path}
+	if path == "" {
+		targets, err = getOverrideChildren(tx)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, target := range targets {
+		_, err = txStmt(tx, "deleteEntry").Exec(target)
+		if err != nil {
+			return err
+		}
+
+		_, err = txStmt(tx, "deleteSubtreeChunks").Exec(target)
+		if err != nil {
+			return err
+		}
+	}
+
+	if path != "" {
+		_, err = txStmt(tx, "updateLastUpdate").Exec(time.Now().UnixMilli(), parentPath(path))
+		if err != nil {
+			return err
+		}
+	}
+
+	after, err := getEntryDepth(path, -1, tx)
+	if errors.Is(err, ErrPathNotFound) {
+		after = nil
+	} else if err != nil {
+		return err
+	}
+
+	recordResetChanges(before, after)
+
+	return nil
+}
+
+/*
+getOverrideChildren returns the paths of the children of the root in the user layer
+*/
+func getOverrideChildren(tx *sql.Tx) ([]string, error) {
+	rows, err := txStmt(tx, "getOverrideChildren").Query("")
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	paths := []string{}
+	for rows.Next() {
+		var path string
+		err = rows.Scan(&path)
+		if err != nil {
+			return nil, err
+		}
+
+		paths = append(paths, path)
+	}
+
+	return paths, rows.Err()
+}
+
+/*
+recordResetChanges records the differences between the Entry before being reset and after (nil if deleted)
+*/
+func recordResetChanges(before *Entry, after *Entry) {
+	if after == nil {
+		if before.Path != "" {
+			recordChange(ChangeDeleted, before.Path, before.IsValue, before.Value, "")
+		}
+
+		return
+	}
+
+	if before.IsValue != after.IsValue {
+		recordChange(ChangeOverwritten, after.Path, after.IsValue, before.Value, after.Value)
+		return
+	}
+
+	if before.IsValue {
+		if before.Value != after.Value {
+			recordChange(ChangeUpdated, after.Path, true, before.Value, after.Value)
+		}
+
+		return
+	}
+
+	for name, child := range before.Children {
+		recordResetChanges(child, after.Children[name])
+	}
+
+	for name, child := range after.Children {
+		if _, ok := before.Children[name]; !ok {
+			recordChange(ChangeCreated, child.Path, child.IsValue, "", child.Value)
+		}
+	}
+}
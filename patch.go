@@ -0,0 +1,759 @@
+package camellia
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	PatchAdd     = "add"
+	PatchRemove  = "remove"
+	PatchReplace = "replace"
+	PatchMove    = "move"
+	PatchCopy    = "copy"
+	PatchTest    = "test"
+)
+
+/*
+PatchOperation is an operation of a JSON Patch (RFC 6902).
+
+Op is one of PatchAdd, PatchRemove, PatchReplace, PatchMove, PatchCopy and PatchTest. Path, and From for PatchMove and
+PatchCopy, are JSON Pointers (RFC 6901) to Entries, relative to the path the patch is applied at. Value, for PatchAdd,
+PatchReplace and PatchTest, is a JSON document in the default format.
+*/
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+/*
+ApplyJSONPatch applies the JSON Patch (RFC 6902) read from reader to the hierarchy at path, atomically, returning the
+changes applied. If any operation fails, including a failed PatchTest (ErrPatchTestFailed), none is applied.
+
+Objects are Entries and their members their children, while scalars are values, stored untyped like the ones imported
+with ImportJSON. Arrays are list values (see SetList), whose elements are addressed by index, or by "-" to append to
+them. Elements of lists can't be moved or copied. Hooks are not called.
+*/
+func ApplyJSONPatch(path string, reader io.Reader) ([]Change, error) {
+	return ApplyJSONPatchCtx(context.Background(), path, reader)
+}
+
+/*
+ApplyJSONPatchCtx calls ApplyJSONPatch, tracing the operation as a child of ctx (see SetTracer). The transaction is
+rolled back if ctx is done before it is committed.
+*/
+func ApplyJSONPatchCtx(ctx context.Context, path string, reader io.Reader) ([]Change, error) {
+	return applyJSONPatch(ctx, path, reader, false)
+}
+
+/*
+DryRunJSONPatch behaves like ApplyJSONPatch, but instead of committing the changes to the DB, returns the list of
+changes that would be applied.
+*/
+func DryRunJSONPatch(path string, reader io.Reader) ([]Change, error) {
+	return applyJSONPatch(context.Background(), path, reader, true)
+}
+
+/*
+JSONPatchFromChanges returns the JSON Patch (RFC 6902) applying changes, like the ones returned by DryRunImportJSON or
+GetEvents, to the hierarchy at path. Changes outside of path are skipped. Since changes don't carry the type of the
+values, values are represented as JSON strings.
+*/
+func JSONPatchFromChanges(path string, changes []Change) []PatchOperation {
+	path = normalizePath(path)
+
+	operations := []PatchOperation{}
+	for _, c := range changes {
+		if c.Path == path || !inMount(c.Path, path) && path != "" {
+			continue
+		}
+
+		pointer := jsonPointer(relativePath(path, c.Path))
+
+		value := json.RawMessage("{}")
+		if c.IsValue {
+			value, _ = json.Marshal(c.Value)
+		}
+
+		switch c.Type {
+		case ChangeCreated:
+			operations = append(operations, PatchOperation{Op: PatchAdd, Path: pointer, Value: value})
+		case ChangeUpdated, ChangeOverwritten:
+			operations = append(operations, PatchOperation{Op: PatchReplace, Path: pointer, Value: value})
+		case ChangeDeleted:
+			operations = append(operations, PatchOperation{Op: PatchRemove, Path: pointer})
+		}
+	}
+
+	return operations
+}
+
+/*
+ApplyJSONMergePatch applies the JSON Merge Patch (RFC 7386) read from reader to the hierarchy at path, atomically,
+returning the changes applied. Members of the patch set to null are deleted, objects are merged with the existing
+Entries, and every other value replaces the Entry at its path, as in ApplyJSONPatch. Hooks are not called.
+*/
+func ApplyJSONMergePatch(path string, reader io.Reader) ([]Change, error) {
+	return applyJSONMergePatch(context.Background(), path, reader, false)
+}
+
+/*
+DryRunJSONMergePatch behaves like ApplyJSONMergePatch, but instead of committing the changes to the DB, returns the list
+of changes that would be applied.
+*/
+func DryRunJSONMergePatch(path string, reader io.Reader) ([]Change, error) {
+	return applyJSONMergePatch(context.Background(), path, reader, true)
+}
+
+func applyJSONPatch(ctx context.Context, path string, reader io.Reader, dryRun bool) ([]Change, error) {
+	name := "camellia.ApplyJSONPatch"
+	if dryRun {
+		name = "camellia.DryRunJSONPatch"
+	}
+
+	operations := []PatchOperation{}
+	err := json.NewDecoder(reader).Decode(&operations)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding patch - %w", err)
+	}
+
+	return applyPatch(ctx, name, path, dryRun, func(root string, tx *sql.Tx) error {
+		for i, o := range operations {
+			err := applyPatchOperation(root, o, tx)
+			if err != nil {
+				return fmt.Errorf("error applying operation %d (%s %s) - %w", i, o.Op, o.Path, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+func applyJSONMergePatch(ctx context.Context, path string, reader io.Reader, dryRun bool) ([]Change, error) {
+	name := "camellia.ApplyJSONMergePatch"
+	if dryRun {
+		name = "camellia.DryRunJSONMergePatch"
+	}
+
+	var patch interface{}
+	decoder := json.NewDecoder(reader)
+	decoder.UseNumber()
+	err := decoder.Decode(&patch)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding patch - %w", err)
+	}
+
+	return applyPatch(ctx, name, path, dryRun, func(root string, tx *sql.Tx) error {
+		return mergePatch(root, patch, tx)
+	})
+}
+
+/*
+applyPatch runs apply on the hierarchy at path in a transaction, committed unless dryRun == true, returning the changes
+it made
+*/
+func applyPatch(ctx context.Context, name string, path string, dryRun bool,
+	apply func(root string, tx *sql.Tx) error) (changes []Change, err error) {
+	ctx, span := startSpan(ctx, name, path)
+	defer func() {
+		span.End(err)
+	}()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return nil, ErrNoDB
+	}
+
+	tx, err := beginTxCtx(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error beginning transaction - %w", err)
+	}
+
+	err = apply(normalizePath(path), tx)
+	if err == nil {
+		err = checkRequired(tx)
+	}
+
+	if err != nil {
+		rollbackTx(tx)
+		return nil, err
+	}
+
+	changes = recordedChanges
+
+	if dryRun {
+		recordChanges = false
+		recordedChanges = nil
+
+		err = rollbackTx(tx)
+		if err != nil {
+			return nil, fmt.Errorf("error rolling back transaction - %w", err)
+		}
+
+		return changes, nil
+	}
+
+	err = commitTx(tx)
+	if err != nil {
+		return nil, fmt.Errorf("error committing transaction - %w", err)
+	}
+
+	return changes, nil
+}
+
+/*
+mergePatch merges the value of a JSON Merge Patch, decoded with json.Decoder.UseNumber, into the Entry at path
+*/
+func mergePatch(path string, patch interface{}, tx *sql.Tx) error {
+	m, ok := patch.(map[string]interface{})
+	if !ok {
+		entry, err := jsonToPatchEntry(patch)
+		if err != nil {
+			return err
+		}
+
+		return writePatchEntry(path, entry, tx)
+	}
+
+	isValue, err := pathIsValue(path, tx)
+	if err != nil && !errors.Is(err, ErrPathNotFound) {
+		return err
+	}
+
+	if (err != nil || isValue) && path != "" {
+		err = writePatchEntry(path, &Entry{}, tx)
+		if err != nil {
+			return err
+		}
+	}
+
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		child := joinPath(append(splitPath(path), EscapeSegment(name)))
+		if m[name] == nil {
+			err = removePatchEntry(child, tx)
+			if errors.Is(err, ErrPathNotFound) {
+				err = nil
+			}
+		} else {
+			err = mergePatch(child, m[name], tx)
+		}
+
+		if err != nil {
+			return fmt.Errorf("error merging %s - %w", child, err)
+		}
+	}
+
+	return nil
+}
+
+/*
+applyPatchOperation applies a single operation of a JSON Patch to the hierarchy at root
+*/
+func applyPatchOperation(root string, o PatchOperation, tx *sql.Tx) error {
+	target, err := pointerPath(root, o.Path)
+	if err != nil {
+		return err
+	}
+
+	var value interface{}
+	switch o.Op {
+	case PatchAdd, PatchReplace, PatchTest:
+		if o.Value == nil {
+			return fmt.Errorf("%w - missing value", ErrPathInvalid)
+		}
+
+		decoder := json.NewDecoder(bytes.NewReader(o.Value))
+		decoder.UseNumber()
+		err = decoder.Decode(&value)
+		if err != nil {
+			return fmt.Errorf("invalid value - %w", err)
+		}
+	}
+
+	list, index, err := patchListElement(target, tx)
+	if err != nil {
+		return err
+	}
+
+	switch o.Op {
+	case PatchAdd:
+		if list != nil {
+			return updateListElement(target, list, index, value, o.Op, tx)
+		}
+
+		err = checkPatchParent(root, target, tx)
+		if err != nil {
+			return err
+		}
+
+		entry, err := jsonToPatchEntry(value)
+		if err != nil {
+			return err
+		}
+
+		return writePatchEntry(target, entry, tx)
+
+	case PatchRemove:
+		if list != nil {
+			return updateListElement(target, list, index, nil, o.Op, tx)
+		}
+
+		return removePatchEntry(target, tx)
+
+	case PatchReplace:
+		if list != nil {
+			return updateListElement(target, list, index, value, o.Op, tx)
+		}
+
+		_, err = getEntryDepth(target, 0, tx)
+		if err != nil {
+			return err
+		}
+
+		entry, err := jsonToPatchEntry(value)
+		if err != nil {
+			return err
+		}
+
+		return writePatchEntry(target, entry, tx)
+
+	case PatchMove, PatchCopy:
+		from, err := pointerPath(root, o.From)
+		if err != nil {
+			return err
+		}
+
+		fromList, _, err := patchListElement(from, tx)
+		if err != nil {
+			return err
+		}
+
+		if list != nil || fromList != nil {
+			return fmt.Errorf("%w - list elements can't be moved or copied", ErrPathInvalid)
+		}
+
+		if o.Op == PatchMove && inMount(target, from) && target != from {
+			return fmt.Errorf("%w - %s can't be moved to one of its children", ErrPathInvalid, from)
+		}
+
+		entry, err := getEntryDepth(from, -1, tx)
+		if err != nil {
+			return err
+		}
+
+		if o.Op == PatchMove {
+			if target == from {
+				return nil
+			}
+
+			err = removePatchEntry(from, tx)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = checkPatchParent(root, target, tx)
+		if err != nil {
+			return err
+		}
+
+		return writePatchEntry(target, entry, tx)
+
+	case PatchTest:
+		var actual interface{}
+		if list != nil {
+			if index >= len(list) {
+				return fmt.Errorf("%w - no element %d in list", ErrPathNotFound, index)
+			}
+
+			actual = list[index]
+		} else {
+			entry, err := getEntryDepth(target, -1, tx)
+			if err != nil {
+				return err
+			}
+
+			actual, err = patchEntryToJSON(entry)
+			if err != nil {
+				return err
+			}
+		}
+
+		expected, err := comparableJSON(value)
+		if err != nil {
+			return err
+		}
+
+		actual, err = comparableJSON(actual)
+		if err != nil {
+			return err
+		}
+
+		if !reflect.DeepEqual(actual, expected) {
+			return ErrPatchTestFailed
+		}
+
+		return nil
+
+	default:
+		return fmt.Errorf("%w - unsupported operation %s", ErrPathInvalid, o.Op)
+	}
+}
+
+/*
+pointerPath returns the path of the Entry addressed by the JSON Pointer pointer, relative to root
+*/
+func pointerPath(root string, pointer string) (string, error) {
+	if pointer == "" {
+		return root, nil
+	}
+
+	if !strings.HasPrefix(pointer, "/") {
+		return "", fmt.Errorf("%w - invalid JSON pointer %s", ErrPathInvalid, pointer)
+	}
+
+	segments := splitPath(root)
+	for _, token := range strings.Split(pointer[1:], "/") {
+		if token == "" {
+			return "", fmt.Errorf("%w - empty segment in JSON pointer %s", ErrPathInvalid, pointer)
+		}
+
+		token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+		segments = append(segments, EscapeSegment(token))
+	}
+
+	return joinPath(segments), nil
+}
+
+/*
+jsonPointer returns the JSON Pointer addressing the Entry at the relative path
+*/
+func jsonPointer(path string) string {
+	pointer := strings.Builder{}
+	for _, s := range splitPath(path) {
+		pointer.WriteString("/")
+		pointer.WriteString(strings.NewReplacer("~", "~0", "/", "~1").Replace(UnescapeSegment(s)))
+	}
+
+	return pointer.String()
+}
+
+/*
+patchListElement returns the elements of the list holding the element at path, along with its index, or nil if path
+doesn't address a list element. The index of "-" is the length of the list
+*/
+func patchListElement(path string, tx *sql.Tx) ([]interface{}, int, error) {
+	if path == "" {
+		return nil, 0, nil
+	}
+
+	parent, err := getEntryDepth(parentPath(path), 0, tx)
+	if errors.Is(err, ErrPathNotFound) {
+		return nil, 0, nil
+	}
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if !parent.IsValue || parent.Type != TypeList {
+		return nil, 0, nil
+	}
+
+	list, err := decodeList(parent.Value)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	name := UnescapeSegment(namePath(path))
+	if name == "-" {
+		return list, len(list), nil
+	}
+
+	index, err := strconv.Atoi(name)
+	if err != nil || index < 0 || index > len(list) || strconv.Itoa(index) != name {
+		return nil, 0, fmt.Errorf("%w - invalid list index %s", ErrPathInvalid, name)
+	}
+
+	return list, index, nil
+}
+
+/*
+updateListElement applies op (PatchAdd, PatchRemove or PatchReplace) to the element at index of list, storing the list
+at the parent of path
+*/
+func updateListElement(path string, list []interface{}, index int, value interface{}, op string, tx *sql.Tx) error {
+	switch value.(type) {
+	case map[string]interface{}, []interface{}:
+		return fmt.Errorf("%w - list elements can't be objects or arrays", ErrUnsupportedType)
+	}
+
+	if op != PatchAdd && index >= len(list) {
+		return fmt.Errorf("%w - no element %d in list", ErrPathNotFound, index)
+	}
+
+	switch op {
+	case PatchAdd:
+		list = append(list[:index], append([]interface{}{value}, list[index:]...)...)
+	case PatchRemove:
+		list = append(list[:index], list[index+1:]...)
+	case PatchReplace:
+		list[index] = value
+	}
+
+	encoded, err := encodeList(list)
+	if err != nil {
+		return err
+	}
+
+	return setValue(parentPath(path), encoded, TypeList, tx, true, true)
+}
+
+/*
+checkPatchParent checks that the parent of path, below root, exists and is not a value, as required to add path
+*/
+func checkPatchParent(root string, path string, tx *sql.Tx) error {
+	if path == root || path == "" {
+		return nil
+	}
+
+	isValue, err := pathIsValue(parentPath(path), tx)
+	if errors.Is(err, ErrPathNotFound) && parentPath(path) == "" {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if isValue {
+		return fmt.Errorf("%w - %s", ErrPathIsNotAValue, parentPath(path))
+	}
+
+	return nil
+}
+
+/*
+removePatchEntry deletes the Entry at path, which must exist. The root Entry is emptied instead
+*/
+func removePatchEntry(path string, tx *sql.Tx) error {
+	entry, err := getEntryDepth(path, 1, tx)
+	if err != nil {
+		return err
+	}
+
+	if path != "" {
+		err = checkWritable(path)
+		if err != nil {
+			return err
+		}
+
+		return deletePath(path, tx)
+	}
+
+	for _, child := range entry.Children {
+		err = checkWritable(child.Path)
+		if err == nil {
+			err = deletePath(child.Path, tx)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+jsonToPatchEntry converts a value of a JSON Patch, decoded with json.Decoder.UseNumber, to an Entry without paths
+*/
+func jsonToPatchEntry(value interface{}) (*Entry, error) {
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		v, valueType, err := importedJSONValue(value, false)
+		if err != nil {
+			return nil, err
+		}
+
+		return &Entry{IsValue: true, Value: v, Type: valueType}, nil
+	}
+
+	entry := &Entry{Children: map[string]*Entry{}}
+	for name, v := range m {
+		child, err := jsonToPatchEntry(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of %s - %w", name, err)
+		}
+
+		entry.Children[EscapeSegment(name)] = child
+	}
+
+	return entry, nil
+}
+
+/*
+writePatchEntry replaces the Entry at path with entry, keeping the existing Entries equal to the ones in entry
+*/
+func writePatchEntry(path string, entry *Entry, tx *sql.Tx) error {
+	if entry.IsValue {
+		if path == "" {
+			return fmt.Errorf("%w - the root can't be a value", ErrPathInvalid)
+		}
+
+		local, err := getEntryDepth(path, 0, tx)
+		if err == nil && local.IsValue && local.Value == entry.Value && local.Type == entry.Type {
+			return nil
+		}
+
+		return setValue(path, entry.Value, entry.Type, tx, true, true)
+	}
+
+	local, err := getEntryDepth(path, 1, tx)
+	if err != nil && !errors.Is(err, ErrPathNotFound) {
+		return err
+	}
+
+	if err == nil && local.IsValue {
+		err = checkWritable(path)
+		if err == nil {
+			err = deletePath(path, tx)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		local = nil
+	}
+
+	if local == nil && path != "" {
+		err = newValuesImporter(tx).ensureNonValue(path)
+		if err != nil {
+			return err
+		}
+	}
+
+	if local != nil {
+		for name, child := range local.Children {
+			if _, ok := entry.Children[name]; ok {
+				continue
+			}
+
+			err = checkWritable(child.Path)
+			if err == nil {
+				err = deletePath(child.Path, tx)
+			}
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	names := make([]string, 0, len(entry.Children))
+	for name := range entry.Children {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		err = writePatchEntry(joinPath(append(splitPath(path), name)), entry.Children[name], tx)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+patchEntryToJSON returns the JSON value representing entry, as compared by the test operation
+*/
+func patchEntryToJSON(entry *Entry) (interface{}, error) {
+	if entry.IsValue {
+		switch entry.Type {
+		case TypeNull:
+			return nil, nil
+		case TypeList:
+			return decodeList(entry.Value)
+		default:
+			return entry.Value, nil
+		}
+	}
+
+	m := map[string]interface{}{}
+	for name, child := range entry.Children {
+		v, err := patchEntryToJSON(child)
+		if err != nil {
+			return nil, err
+		}
+
+		m[UnescapeSegment(name)] = v
+	}
+
+	return m, nil
+}
+
+/*
+comparableJSON converts the scalars in a JSON value to their string representation, since values are compared
+regardless of their type
+*/
+func comparableJSON(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		m := map[string]interface{}{}
+		for name, child := range v {
+			c, err := comparableJSON(child)
+			if err != nil {
+				return nil, err
+			}
+
+			m[name] = c
+		}
+
+		return m, nil
+
+	case []interface{}:
+		array := []interface{}{}
+		for _, element := range v {
+			e, err := comparableJSON(element)
+			if err != nil {
+				return nil, err
+			}
+
+			array = append(array, e)
+		}
+
+		return array, nil
+
+	case nil:
+		return nil, nil
+
+	default:
+		s, _, err := jsonToValue(v)
+		return s, err
+	}
+}
@@ -0,0 +1,424 @@
+package camellia
+
+import (
+	"fmt"
+	pathpkg "path"
+	"regexp"
+	"strings"
+)
+
+/*
+DotSegments selects how the "." and ".." segments of paths are handled (see PathRules).
+
+DotSegmentsKeep: the default. "." and ".." are regular names, like any other.
+
+DotSegmentsResolve: "." segments are removed, and ".." segments remove the previous one, like in file system paths.
+".." segments above the root are removed.
+
+DotSegmentsReject: paths containing "." or ".." segments are invalid.
+*/
+type DotSegments int
+
+const (
+	DotSegmentsKeep    DotSegments = 0
+	DotSegmentsResolve DotSegments = 1
+	DotSegmentsReject  DotSegments = 2
+)
+
+/*
+PathRules controls how paths are normalized and which paths are valid (see Options.PathRules). The zero value keeps the
+default behavior: empty segments are removed, so leading, trailing and repeated separators are ignored, and any other
+segment is accepted as is.
+
+DotSegments: how "." and ".." segments are handled, DotSegmentsKeep by default.
+
+MaxSegmentLength: the maximum length of a segment, in bytes, once unescaped (see EscapeSegment). 0 (the default)
+means no limit.
+
+AllowedChars: the characters allowed in segments once unescaped, in the syntax of a regular expression character class
+without the brackets, like "a-zA-Z0-9_-". Empty (the default) allows any character.
+
+TrimSpace: remove leading and trailing white space from segments, so " a / b " is the same path as "a/b". Segments made
+only of white space are removed, like empty ones.
+
+Paths are normalized with DotSegmentsResolve and TrimSpace by every function accepting a path, so they read and write
+the same Entries. Paths breaking the other rules are rejected with ErrPathInvalid when Entries are created, including
+imports, while reading them just fails with ErrPathNotFound.
+*/
+type PathRules struct {
+	DotSegments      DotSegments
+	MaxSegmentLength int
+	AllowedChars     string
+	TrimSpace        bool
+}
+
+/*
+pathRules are the PathRules of the open DB, and pathChars the regular expression matching the segments made of
+pathRules.AllowedChars only
+*/
+var pathRules PathRules
+var pathChars *regexp.Regexp
+
+/*
+compile validates the rules, returning the regular expression matching the segments made of AllowedChars only, or nil
+if any character is allowed
+*/
+func (r PathRules) compile() (*regexp.Regexp, error) {
+	if r.DotSegments < DotSegmentsKeep || r.DotSegments > DotSegmentsReject {
+		return nil, fmt.Errorf("invalid dot segments handling %d", int(r.DotSegments))
+	}
+
+	if r.MaxSegmentLength < 0 {
+		return nil, fmt.Errorf("invalid max segment length %d", r.MaxSegmentLength)
+	}
+
+	if r.AllowedChars == "" {
+		return nil, nil
+	}
+
+	chars, err := regexp.Compile("^[" + r.AllowedChars + "]+$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid allowed chars %s - %w", r.AllowedChars, err)
+	}
+
+	return chars, nil
+}
+
+/*
+normalizeSegments applies the transformations of the PathRules of the open DB to the segments of a path
+*/
+func normalizeSegments(segments []string) []string {
+	if pathRules.TrimSpace {
+		trimmed := segments[:0]
+		for _, s := range segments {
+			s = strings.TrimSpace(s)
+			if s != "" {
+				trimmed = append(trimmed, s)
+			}
+		}
+
+		segments = trimmed
+	}
+
+	if pathRules.DotSegments == DotSegmentsResolve {
+		resolved := segments[:0]
+		for _, s := range segments {
+			switch s {
+			case ".":
+			case "..":
+				if len(resolved) > 0 {
+					resolved = resolved[:len(resolved)-1]
+				}
+			default:
+				resolved = append(resolved, s)
+			}
+		}
+
+		segments = resolved
+	}
+
+	return segments
+}
+
+/*
+checkPath verifies that a normalized path follows the PathRules of the open DB, failing with ErrPathInvalid
+*/
+func checkPath(path string) error {
+	if pathRules == (PathRules{}) {
+		return nil
+	}
+
+	for _, s := range splitPath(path) {
+		if pathRules.DotSegments == DotSegmentsReject && (s == "." || s == "..") {
+			return fmt.Errorf("%w - %s contains a %s segment", ErrPathInvalid, path, s)
+		}
+
+		// Escaped separators count as characters of the names
+		name := UnescapeSegment(s)
+
+		if pathRules.MaxSegmentLength > 0 && len(name) > pathRules.MaxSegmentLength {
+			return fmt.Errorf("%w - %s has a segment longer than %d bytes", ErrPathInvalid, path,
+				pathRules.MaxSegmentLength)
+		}
+
+		if pathChars != nil && !pathChars.MatchString(name) {
+			return fmt.Errorf("%w - %s has a segment with characters outside of [%s]", ErrPathInvalid, path,
+				pathRules.AllowedChars)
+		}
+	}
+
+	return nil
+}
+
+/*
+EscapeSegment escapes a name, like a URL or any key containing slashes, so that it's stored as a single path segment,
+instead of being split in nested Entries. Slashes are escaped as "\/" and backslashes as "\\":
+
+	err := camellia.Set("hosts/"+camellia.EscapeSegment("http://example.com"), "up")
+
+The escaped name is the name of the Entry, the key of its map in Entry.Children and in JSON exports, so JSON exports
+round-trip through imports. Names without slashes and backslashes are left as they are.
+*/
+func EscapeSegment(name string) string {
+	if !strings.ContainsAny(name, `\/`) {
+		return name
+	}
+
+	return strings.NewReplacer(`\`, `\\`, `/`, `\/`).Replace(name)
+}
+
+/*
+UnescapeSegment returns the name escaped in a path segment by EscapeSegment. A trailing single backslash is left as it
+is.
+*/
+func UnescapeSegment(segment string) string {
+	if !strings.Contains(segment, `\`) {
+		return segment
+	}
+
+	name := strings.Builder{}
+	for i := 0; i < len(segment); i++ {
+		if segment[i] == '\\' && i+1 < len(segment) {
+			i++
+		}
+
+		name.WriteByte(segment[i])
+	}
+
+	return name.String()
+}
+
+/*
+JoinSegments builds a path from names, escaping them with EscapeSegment, so that each one is a single segment.
+*/
+func JoinSegments(names ...string) string {
+	segments := make([]string, 0, len(names))
+	for _, name := range names {
+		if name != "" {
+			segments = append(segments, EscapeSegment(name))
+		}
+	}
+
+	return strings.Join(segments, "/")
+}
+
+/*
+SplitSegments splits a path in the names of its segments, unescaped with UnescapeSegment. Empty segments are ignored,
+like in any other path.
+*/
+func SplitSegments(path string) []string {
+	names := []string{}
+	for _, segment := range splitPath(path) {
+		names = append(names, UnescapeSegment(segment))
+	}
+
+	return names
+}
+
+/*
+NormalizePath returns path as stored by the open DB, applying the PathRules it was opened with: empty segments are
+removed and, if enabled, white space is trimmed and dot segments are resolved. Code checking paths against prefixes
+or patterns, like access checks, must compare normalized paths, since "a/ ../b" may be the same Entry as "b".
+*/
+func NormalizePath(path string) string {
+	return normalizePath(path)
+}
+
+/*
+trimSeparators removes the leading separators and the trailing ones not escaped from a path
+*/
+func trimSeparators(p string) string {
+	p = strings.TrimLeft(p, "/")
+
+	for strings.HasSuffix(p, "/") {
+		backslashes := 0
+		for i := len(p) - 2; i >= 0 && p[i] == '\\'; i-- {
+			backslashes++
+		}
+
+		if backslashes%2 == 1 {
+			break
+		}
+
+		p = p[:len(p)-1]
+	}
+
+	return p
+}
+
+/*
+matchPath returns whether path matches pattern, segment by segment, with the syntax of path.Match. The segments of
+path are unescaped before being matched (see EscapeSegment), so an escaped separator in pattern matches a separator
+inside a name
+*/
+func matchPath(pattern string, path string) (bool, error) {
+	patterns := splitPath(pattern)
+	segments := splitPath(path)
+	if len(patterns) != len(segments) {
+		return false, nil
+	}
+
+	// path.Match never matches separators with wildcards, so the ones inside names are replaced, both in the names and
+	// escaped in the patterns, by a noncharacter that can't appear in valid text
+	for i, p := range patterns {
+		p = strings.ReplaceAll(p, `\/`, "\uffff")
+		name := strings.ReplaceAll(UnescapeSegment(segments[i]), "/", "\uffff")
+
+		matched, err := pathpkg.Match(p, name)
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+/*
+checkGlob verifies that pattern is a valid glob: segments follow the syntax of path.Match, and "**" is allowed only as
+the last one
+*/
+func checkGlob(pattern string) error {
+	segments := splitPath(pattern)
+	for i, s := range segments {
+		if s == "**" && i != len(segments)-1 {
+			return fmt.Errorf("invalid pattern %s - ** is allowed only as the last segment", pattern)
+		}
+
+		_, err := pathpkg.Match(s, "")
+		if err != nil {
+			return fmt.Errorf("invalid pattern %s - %w", pattern, err)
+		}
+	}
+
+	return nil
+}
+
+/*
+matchGlob returns whether path matches pattern, like matchPath does, except that a final "**" segment matches any
+number of segments, so "sys/**" matches "sys/uptime" and "sys/thermal/cpu", but not "sys"
+*/
+func matchGlob(pattern string, path string) bool {
+	if strings.HasSuffix(pattern, "/**") || pattern == "**" {
+		pattern = strings.TrimSuffix(strings.TrimSuffix(pattern, "**"), "/")
+
+		prefixLen := len(splitPath(pattern))
+		segments := splitPath(path)
+		if len(segments) <= prefixLen {
+			return false
+		}
+
+		path = joinPath(segments[:prefixLen])
+	}
+
+	matched, err := matchPath(pattern, path)
+	return err == nil && matched
+}
+
+/*
+matchGlobAncestor returns whether some descendant of path may match pattern
+*/
+func matchGlobAncestor(pattern string, path string) bool {
+	patterns := splitPath(pattern)
+	segments := splitPath(path)
+
+	if len(patterns) > 0 && patterns[len(patterns)-1] == "**" {
+		patterns = patterns[:len(patterns)-1]
+		if len(segments) == len(patterns) {
+			matched, err := matchPath(joinPath(patterns), path)
+			return err == nil && matched
+		}
+	}
+
+	if len(segments) >= len(patterns) {
+		return false
+	}
+
+	matched, err := matchPath(joinPath(patterns[:len(segments)]), path)
+	return err == nil && matched
+}
+
+/*
+Path is a path of the hierarchy, with methods to build and inspect it without handling separators and escaping (see
+EscapeSegment). Paths are normalized like any path passed to the API, following the PathRules of the open DB.
+
+The API accepts paths as strings, so Path converts to them with String:
+
+	p := camellia.NewPath("hosts", "http://example.com")
+	err := camellia.Set(p.Join("status").String(), "up")
+*/
+type Path string
+
+/*
+NewPath builds a Path from the names of its segments, escaping them.
+*/
+func NewPath(names ...string) Path {
+	return Path(JoinSegments(names...))
+}
+
+/*
+String returns the normalized path, as accepted by the API.
+*/
+func (p Path) String() string {
+	return normalizePath(string(p))
+}
+
+/*
+Join returns the Path of the descendant of p named by names, escaping them.
+*/
+func (p Path) Join(names ...string) Path {
+	return Path(normalizePath(string(p) + "/" + JoinSegments(names...)))
+}
+
+/*
+Parent returns the Path of the parent of p. The parent of the root, and of its children, is the root.
+*/
+func (p Path) Parent() Path {
+	return Path(parentPath(p.String()))
+}
+
+/*
+Name returns the unescaped name of the last segment of p, or an empty string for the root.
+*/
+func (p Path) Name() string {
+	return UnescapeSegment(namePath(p.String()))
+}
+
+/*
+Split returns the unescaped names of the segments of p, or an empty slice for the root.
+*/
+func (p Path) Split() []string {
+	return SplitSegments(p.String())
+}
+
+/*
+IsRoot returns whether p is the path of the root Entry.
+*/
+func (p Path) IsRoot() bool {
+	return p.String() == ""
+}
+
+/*
+Match returns whether p matches pattern, with the syntax of path.Match, used also by Schema, ACL and validator
+patterns: a "*" matches a single segment, and the names are matched unescaped. Fails only if pattern is malformed.
+*/
+func (p Path) Match(pattern string) (bool, error) {
+	_, err := pathpkg.Match(pattern, "")
+	if err != nil {
+		return false, err
+	}
+
+	return matchPath(pattern, p.String())
+}
+
+/*
+Validate verifies that an Entry can be created at p, following the PathRules of the open DB, failing with
+ErrPathInvalid otherwise.
+*/
+func (p Path) Validate() error {
+	path := p.String()
+	if path == "" {
+		return ErrPathInvalid
+	}
+
+	return checkPath(path)
+}
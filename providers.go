@@ -0,0 +1,166 @@
+package camellia
+
+import (
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+/*
+provider computes the values at the paths matching pattern. See RegisterProvider
+*/
+type provider struct {
+	pattern  string
+	literal  bool
+	callback func(path string) (string, error)
+
+	// Set on virtual mounts only (see MountEnv), list returns the paths of the values at and below a path
+	prefix string
+	list   func(path string) []string
+}
+
+/*
+providers holds the registered providers, in the order they were registered. It's accessed while the global mutex is
+held
+*/
+var providers []*provider
+
+/*
+RegisterProvider registers a callback computing the values at the paths matching pattern, so that live system data,
+like the uptime or the temperatures, is browsed through the same hierarchy as the configuration. Computed values are
+never stored, and the paths matching pattern can't be written, failing with ErrReadOnly.
+
+Patterns follow the syntax of path.Match segment by segment, like Schema patterns, and a final "**" segment matches any
+number of segments, so "sys/**" matches "sys/uptime" and "sys/thermal/cpu". Values are computed by callback, called
+with the path read, on every read: Get and its variants read any path matching pattern, failing with the error returned
+by callback, which returns ErrPathNotFound for paths it doesn't provide. Providers registered for a single path, without
+wildcards, also appear in GetEntry, GetStruct, Exists and, with ExportOptions.Runtime, in exports, like runtime values
+(see SetRuntime), skipping the ones that fail.
+
+Values computed by the first provider registered with a matching pattern take precedence over the persistent Entries,
+and runtime values take precedence over them. Callbacks are called while the DB is locked, so they must not call the
+API. Providers are unregistered on Close.
+
+Returns a function that unregisters the provider.
+*/
+func RegisterProvider(pattern string, callback func(path string) (string, error)) (func(), error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return nil, ErrNoDB
+	}
+
+	pattern = normalizePath(pattern)
+	if pattern == "" {
+		return nil, ErrPathInvalid
+	}
+
+	err := checkGlob(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &provider{
+		pattern:  pattern,
+		literal:  !strings.ContainsAny(pattern, `*?[\`),
+		callback: callback}
+
+	providers = append(providers, p)
+
+	return func() {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		for i, registered := range providers {
+			if registered == p {
+				providers = append(providers[:i:i], providers[i+1:]...)
+				break
+			}
+		}
+	}, nil
+}
+
+/*
+matches returns whether the provider computes the value at path
+*/
+func (p *provider) matches(path string) bool {
+	if p.prefix != "" {
+		return strings.HasPrefix(path, p.prefix+"/")
+	}
+
+	if p.literal {
+		return p.pattern == path
+	}
+
+	return matchGlob(p.pattern, path)
+}
+
+/*
+providerFor returns the first provider registered computing the value at path, or nil
+*/
+func providerFor(path string) *provider {
+	for _, p := range providers {
+		if p.matches(path) {
+			return p
+		}
+	}
+
+	return nil
+}
+
+/*
+providedPath returns whether a provider is registered for path only
+*/
+func providedPath(path string) bool {
+	for _, p := range providers {
+		if p.literal && p.pattern == path {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+providedPathsUnder returns the paths of the values at path and below it computed by the providers registered for a
+single path and by the virtual mounts
+*/
+func providedPathsUnder(path string) []string {
+	paths := []string{}
+	for _, p := range providers {
+		if p.list != nil {
+			paths = append(paths, p.list(path)...)
+		} else if p.literal && (path == "" || p.pattern == path || strings.HasPrefix(p.pattern, path+"/")) {
+			paths = append(paths, p.pattern)
+		}
+	}
+
+	return paths
+}
+
+/*
+virtualValue returns the runtime value at path or, if none, the value computed by its provider. Returns false if there
+is neither. Must be called while the global mutex is held
+*/
+func virtualValue(path string) (runtimeValue, bool, error) {
+	if v, ok := runtimeValues[path]; ok {
+		return v, true, nil
+	}
+
+	p := providerFor(path)
+	if p == nil {
+		return runtimeValue{}, false, nil
+	}
+
+	value, err := p.callback(path)
+	if err != nil {
+		return runtimeValue{}, true, err
+	}
+
+	return runtimeValue{value: value, valueType: TypeUntyped, lastUpdate: time.Now()}, true, nil
+}
+
+func wipeProviders() {
+	providers = nil
+}
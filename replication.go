@@ -0,0 +1,270 @@
+package camellia
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	metaReplicatedRevision = "replicated_revision"
+	metaPushedRevision     = "pushed_revision"
+)
+
+/*
+ReplicatedEntry is the state of the hierarchy at Path on a primary DB, applied to a replica by ApplyReplicated. Entry
+is the Entry at Path, as decoded from its export in the extended JSON format, with paths relative to Path, or nil if
+Path doesn't exist on the primary.
+*/
+type ReplicatedEntry struct {
+	Path  string
+	Entry *Entry
+}
+
+/*
+ApplyReplicated replaces, in a single transaction, the hierarchies at the paths of entries with the ones read from a
+primary DB at primaryRevision, so that the open DB follows the primary: values are set along with their type, and the
+Entries missing on the primary are deleted. primaryRevision is recorded along with the changes, so that a replica
+resumes from it (see GetReplicatedRevision) after a restart.
+
+Changes are recorded and watched like any other, but hooks are not called. See server.Client.Replicate for a complete
+replication loop.
+*/
+func ApplyReplicated(entries []ReplicatedEntry, primaryRevision uint64) error {
+	return ApplyReplicatedFrom("", entries, primaryRevision)
+}
+
+/*
+ApplyReplicatedFrom calls ApplyReplicated, recording primaryRevision as the revision reached of the DB identified by
+source, like the URL of the server serving it, so that the open DB follows several DBs (see server.Client.Pull).
+*/
+func ApplyReplicatedFrom(source string, entries []ReplicatedEntry, primaryRevision uint64) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return ErrNoDB
+	}
+
+	tx, err := beginTx()
+	if err != nil {
+		return fmt.Errorf("error beginning transaction - %w", err)
+	}
+
+	for _, e := range entries {
+		err = applyReplicatedEntry(normalizePath(e.Path), e.Entry, tx)
+		if err != nil {
+			rollbackTx(tx)
+			return fmt.Errorf("error applying replicated entry %s - %w", e.Path, err)
+		}
+	}
+
+	err = setMeta(sourceMetaKey(metaReplicatedRevision, source), strconv.FormatUint(primaryRevision, 10), tx)
+	if err != nil {
+		rollbackTx(tx)
+		return fmt.Errorf("error setting replicated revision - %w", err)
+	}
+
+	err = checkRequired(tx)
+	if err != nil {
+		rollbackTx(tx)
+		return err
+	}
+
+	err = commitTx(tx)
+	if err != nil {
+		return fmt.Errorf("error committing transaction - %w", err)
+	}
+
+	return nil
+}
+
+/*
+GetReplicatedRevision returns the revision of the primary DB last applied with ApplyReplicated, or 0 if none was.
+*/
+func GetReplicatedRevision() (uint64, error) {
+	return getRevisionMeta(metaReplicatedRevision)
+}
+
+/*
+GetReplicatedRevisionFrom returns the revision of the DB identified by source last applied with ApplyReplicatedFrom,
+or 0 if none was.
+*/
+func GetReplicatedRevisionFrom(source string) (uint64, error) {
+	return getRevisionMeta(sourceMetaKey(metaReplicatedRevision, source))
+}
+
+/*
+SetPushedRevision records revision as the revision of the open DB whose changes were last sent to the DB identified by
+target, like the URL of the server serving it (see server.Client.Push).
+*/
+func SetPushedRevision(target string, revision uint64) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return ErrNoDB
+	}
+
+	tx, err := beginTx()
+	if err != nil {
+		return fmt.Errorf("error beginning transaction - %w", err)
+	}
+
+	err = setMeta(sourceMetaKey(metaPushedRevision, target), strconv.FormatUint(revision, 10), tx)
+	if err != nil {
+		rollbackTx(tx)
+		return fmt.Errorf("error setting pushed revision - %w", err)
+	}
+
+	err = commitTx(tx)
+	if err != nil {
+		return fmt.Errorf("error committing transaction - %w", err)
+	}
+
+	return nil
+}
+
+/*
+GetPushedRevision returns the revision recorded by SetPushedRevision for target, or 0 if none was.
+*/
+func GetPushedRevision(target string) (uint64, error) {
+	return getRevisionMeta(sourceMetaKey(metaPushedRevision, target))
+}
+
+/*
+sourceMetaKey returns the key of the meta table holding the property key of the DB identified by source
+*/
+func sourceMetaKey(key string, source string) string {
+	if source == "" {
+		return key
+	}
+
+	return key + "/" + source
+}
+
+/*
+getRevisionMeta reads the revision stored in the meta table under key, 0 if missing
+*/
+func getRevisionMeta(key string) (uint64, error) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return 0, ErrNoDB
+	}
+
+	tx, err := beginReadTx(context.Background())
+	if err != nil {
+		return 0, fmt.Errorf("error beginning transaction - %w", err)
+	}
+
+	value, err := getMeta(key, tx)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		rollbackTx(tx)
+		return 0, fmt.Errorf("error getting %s - %w", key, err)
+	}
+
+	err = endReadTx(context.Background(), tx)
+	if err != nil {
+		rollbackTx(tx)
+		return 0, fmt.Errorf("error committing transaction - %w", err)
+	}
+
+	if value == "" {
+		return 0, nil
+	}
+
+	revision, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %s - %w", key, value, err)
+	}
+
+	return revision, nil
+}
+
+/*
+applyReplicatedEntry replaces the hierarchy at path with entry, deleting it if entry is nil
+*/
+func applyReplicatedEntry(path string, entry *Entry, tx *sql.Tx) error {
+	if entry == nil {
+		if path != "" {
+			return deletePath(path, tx)
+		}
+
+		root, err := getEntryDepth("", 1, tx)
+		if err != nil {
+			return err
+		}
+
+		for _, child := range root.Children {
+			err = deletePath(child.Path, tx)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	rebaseEntry(entry, path)
+
+	err := pruneReplicated(entry, tx)
+	if err != nil {
+		return err
+	}
+
+	// Wrapped in its ancestors, the Entry is set at path through non-value Entries merged with the existing ones
+	segments := splitPath(path)
+	for i := len(segments) - 1; i >= 0; i-- {
+		child := entry
+		entry = &Entry{
+			Path:       joinPath(segments[:i]),
+			LastUpdate: time.Now(),
+			Children:   map[string]*Entry{segments[i]: child},
+		}
+	}
+
+	return setRootEntry(entry, tx, true, true, false)
+}
+
+/*
+pruneReplicated deletes the Entries below entry existing in the DB but not in entry
+*/
+func pruneReplicated(entry *Entry, tx *sql.Tx) error {
+	if entry.IsValue {
+		return nil
+	}
+
+	local, err := getEntryDepth(entry.Path, 1, tx)
+	if errors.Is(err, ErrPathNotFound) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if local.IsValue {
+		return nil
+	}
+
+	for name, child := range local.Children {
+		replicated, ok := entry.Children[name]
+		if !ok {
+			err = deletePath(child.Path, tx)
+		} else {
+			err = pruneReplicated(replicated, tx)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
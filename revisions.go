@@ -0,0 +1,299 @@
+package camellia
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+)
+
+const (
+	metaRevision = "revision"
+
+	defaultChangeHistory = 1000
+)
+
+/*
+Event is a Change recorded in the change log of the DB, along with the revision of the transaction that made it.
+
+Every write transaction committing at least one change increments the revision of the DB by one, so all the changes of
+a transaction share the same revision, and revisions grow monotonically, also across processes sharing the DB file.
+*/
+type Event struct {
+	Change
+	Revision uint64
+}
+
+/*
+GetRevision returns the current revision of the DB, which is 0 until the first change is committed.
+*/
+func GetRevision() (uint64, error) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return 0, ErrNoDB
+	}
+
+	tx, err := beginReadTx(context.Background())
+	if err != nil {
+		return 0, fmt.Errorf("error beginning transaction - %w", err)
+	}
+
+	revision, err := getRevision(tx)
+	if err != nil {
+		rollbackTx(tx)
+		return 0, err
+	}
+
+	err = endReadTx(context.Background(), tx)
+	if err != nil {
+		rollbackTx(tx)
+		return 0, fmt.Errorf("error committing transaction - %w", err)
+	}
+
+	return revision, nil
+}
+
+/*
+GetEvents returns, in order, the Events with a revision greater than sinceRevision, affecting the Entry at the
+specified path or its children (like Watch), along with the current revision of the DB.
+
+Only the latest Options.ChangeHistory revisions are kept in the change log. If Events after sinceRevision were
+already discarded, GetEvents fails with ErrRevisionCompacted: the caller should then read the hierarchy again, and
+continue from the current revision.
+*/
+func GetEvents(path string, sinceRevision uint64) ([]Event, uint64, error) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return nil, 0, ErrNoDB
+	}
+
+	tx, err := beginReadTx(context.Background())
+	if err != nil {
+		return nil, 0, fmt.Errorf("error beginning transaction - %w", err)
+	}
+
+	events, revision, err := getEvents(normalizePath(path), sinceRevision, tx)
+	if err != nil {
+		rollbackTx(tx)
+		return nil, 0, err
+	}
+
+	err = endReadTx(context.Background(), tx)
+	if err != nil {
+		rollbackTx(tx)
+		return nil, 0, fmt.Errorf("error committing transaction - %w", err)
+	}
+
+	return events, revision, nil
+}
+
+/*
+SetWithRevision sets a value of type T to the specified path, like Set, only if the Entry at the path was not changed
+since expectedRevision, its Revision when the caller read it. Otherwise, fails with ErrRevisionMismatch. A missing
+Entry is considered at revision 0, so an expectedRevision of 0 sets the value only if the path doesn't exist yet.
+
+This allows safe read-modify-write cycles, even across processes: read the Entry with GetEntry, compute the new value,
+and set it with the Revision of the Entry, starting over on ErrRevisionMismatch.
+*/
+func SetWithRevision[T Stringable](path string, value T, expectedRevision uint64) error {
+	valueString, err := encodeValue(value)
+	if err != nil {
+		return fmt.Errorf("error converting value to string - %w", err)
+	}
+
+	return Update(func(tx *Tx) error {
+		return tx.setWithRevision(path, valueString, valueTypeOf[T](), expectedRevision)
+	})
+}
+
+/*
+GetIfChanged reads the value at the specified path as type T, like Get, only if the Entry at the path was changed after
+sinceRevision, the revision returned by the previous call. Otherwise, fails with ErrNotModified, without reading the
+value. The revision at which the Entry was last changed is returned along with the value, to be passed to the next
+call, so that polling readers skip the unchanged values. With sinceRevision == 0, the value is always read.
+
+Runtime and buffered values carry no revision: they are always read, along with revision 0. Changes to the values
+referenced by the value (see SetInterpolationEnabled) are not detected.
+*/
+func GetIfChanged[T Stringable](path string, sinceRevision uint64) (value T, revision uint64, err error) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return value, 0, ErrNoDB
+	}
+
+	path = normalizePath(path)
+	if _, ok := buffered[path]; ok || hasRuntimeValue(path) {
+		value, err = get[T](context.Background(), path)
+		return value, 0, err
+	}
+
+	tx, err := beginReadTx(context.Background())
+	if err != nil {
+		return value, 0, fmt.Errorf("error beginning transaction - %w", err)
+	}
+
+	entry, err := getEntryDepth(path, 0, tx)
+	if err != nil {
+		rollbackTx(tx)
+		return value, 0, pathError("get", path, err)
+	}
+
+	err = endReadTx(context.Background(), tx)
+	if err != nil {
+		rollbackTx(tx)
+		return value, 0, fmt.Errorf("error committing transaction - %w", err)
+	}
+
+	if !entry.IsValue {
+		return value, 0, pathError("get", path, ErrPathIsNotAValue)
+	}
+
+	if sinceRevision > 0 && entry.Revision <= sinceRevision {
+		return value, entry.Revision, ErrNotModified
+	}
+
+	value, err = get[T](context.Background(), path)
+	if err != nil {
+		return value, 0, err
+	}
+
+	return value, entry.Revision, nil
+}
+
+/*
+checkRevision verifies that the Entry at path is at expectedRevision, a missing Entry being at revision 0
+*/
+func checkRevision(path string, expectedRevision uint64, tx *sql.Tx) error {
+	revision := uint64(0)
+
+	entry, err := getEntry(path, tx)
+	if err == nil {
+		revision = entry.Revision
+	} else if !errors.Is(err, ErrPathNotFound) {
+		return err
+	}
+
+	if revision != expectedRevision {
+		return fmt.Errorf("%w - %s is at revision %d, expected %d", ErrRevisionMismatch, path, revision,
+			expectedRevision)
+	}
+
+	return nil
+}
+
+func getRevision(tx *sql.Tx) (uint64, error) {
+	value, err := getMeta(metaRevision, tx)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, nil
+		}
+
+		return 0, fmt.Errorf("error getting revision - %w", err)
+	}
+
+	revision, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid revision %s - %w", value, err)
+	}
+
+	return revision, nil
+}
+
+func getEvents(path string, sinceRevision uint64, tx *sql.Tx) ([]Event, uint64, error) {
+	revision, err := getRevision(tx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if sinceRevision >= revision {
+		return []Event{}, revision, nil
+	}
+
+	var oldest sql.NullInt64
+	err = txStmt(tx, "getOldestRevision").QueryRow().Scan(&oldest)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if !oldest.Valid || sinceRevision+1 < uint64(oldest.Int64) {
+		return nil, 0, ErrRevisionCompacted
+	}
+
+	rows, err := txStmt(tx, "getEvents").Query(sinceRevision)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	defer rows.Close()
+
+	events := []Event{}
+	for rows.Next() {
+		var e Event
+		var changeType uint
+		err = rows.Scan(&e.Revision, &changeType, &e.Path, &e.IsValue, &e.OldValue, &e.Value)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		e.Type = ChangeType(changeType)
+		if watchMatches(path, e.Change) {
+			events = append(events, e)
+		}
+	}
+
+	return events, revision, rows.Err()
+}
+
+/*
+logChanges records the changes of a transaction in the change log, under a new revision, also set as the revision of
+the changed Entries, discarding the revisions exceeding the history size. Returns the new revision
+*/
+func logChanges(changes []Change, tx *sql.Tx) (uint64, error) {
+	revision, err := getRevision(tx)
+	if err != nil {
+		return 0, err
+	}
+
+	revision++
+
+	err = setMeta(metaRevision, strconv.FormatUint(revision, 10), tx)
+	if err != nil {
+		return 0, fmt.Errorf("error setting revision - %w", err)
+	}
+
+	for _, c := range changes {
+		_, err = txStmt(tx, "insertEvent").Exec(revision, uint(c.Type), c.Path, c.IsValue, c.OldValue, c.Value)
+		if err != nil {
+			return 0, fmt.Errorf("error logging change - %w", err)
+		}
+
+		if c.Type != ChangeDeleted {
+			_, err = pathStmt(tx, "setEntryRevision", c.Path).Exec(revision, c.Path)
+			if err != nil {
+				return 0, fmt.Errorf("error setting revision of %s - %w", c.Path, err)
+			}
+		}
+	}
+
+	history := uint64(defaultChangeHistory)
+	if dbOptions.ChangeHistory > 0 {
+		history = uint64(dbOptions.ChangeHistory)
+	}
+
+	if revision > history {
+		_, err = txStmt(tx, "deleteEvents").Exec(revision - history)
+		if err != nil {
+			return 0, fmt.Errorf("error compacting change log - %w", err)
+		}
+	}
+
+	return revision, nil
+}
@@ -0,0 +1,373 @@
+package camellia
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+/*
+runtimeValue is a value set with SetRuntime, kept in memory only
+*/
+type runtimeValue struct {
+	value      string
+	valueType  ValueType
+	lastUpdate time.Time
+}
+
+/*
+runtimeValues holds the runtime values by path. It's accessed while the global mutex is held
+*/
+var runtimeValues = map[string]runtimeValue{}
+
+/*
+SetRuntime sets a runtime value of type T to the specified path. Runtime values are kept in memory only, and vanish
+on Close, so that runtime state, like the current IP address or the state of a link, is accessed along with the
+persistent configuration without wearing the storage, nor ending up in backups.
+
+Runtime values are returned by Get, GetEntry, Exists and GetStruct, complementing the persistent Entries or shadowing
+the ones at their paths, along with their children. They are exported by ExportJSON only with ExportOptions.Runtime.
+Transactions (see Update) only see the persistent Entries.
+
+Values are validated against the Schema, and their changes are notified to the watchers, but not to the hooks, nor
+logged in the change log. A runtime value set at a path where runtime values are children fails with
+ErrPathIsNotAValue, while one set below a runtime value overwrites it.
+*/
+func SetRuntime[T Stringable](path string, value T) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return ErrNoDB
+	}
+
+	valueString, err := encodeValue(value)
+	if err != nil {
+		return fmt.Errorf("error converting value to string - %w", err)
+	}
+
+	path = normalizePath(path)
+	if path == "" {
+		return ErrPathInvalid
+	}
+
+	err = checkPath(path)
+	if err != nil {
+		return err
+	}
+
+	err = validateValue(path, valueString, valueTypeOf[T]())
+	if err != nil {
+		return err
+	}
+
+	for p := range runtimeValues {
+		if strings.HasPrefix(p, path+"/") {
+			return ErrPathIsNotAValue
+		}
+	}
+
+	changes := []Change{}
+	for p := parentPath(path); p != ""; p = parentPath(p) {
+		if old, ok := runtimeValues[p]; ok {
+			delete(runtimeValues, p)
+			changes = append(changes, Change{Type: ChangeOverwritten, Path: p, OldValue: old.value})
+		}
+	}
+
+	old, exists := runtimeValues[path]
+	runtimeValues[path] = runtimeValue{value: valueString, valueType: valueTypeOf[T](), lastUpdate: time.Now()}
+
+	if !exists {
+		changes = append(changes, Change{Type: ChangeCreated, Path: path, IsValue: true, Value: valueString})
+	} else if old.value != valueString {
+		changes = append(changes, Change{Type: ChangeUpdated, Path: path, IsValue: true, OldValue: old.value,
+			Value: valueString})
+	}
+
+	if len(changes) > 0 {
+		notifyWatchers(changes)
+	}
+
+	return nil
+}
+
+/*
+DeleteRuntime deletes the runtime values at the specified path and below it (see SetRuntime), uncovering the
+persistent Entries they shadowed, if any.
+*/
+func DeleteRuntime(path string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return ErrNoDB
+	}
+
+	path = normalizePath(path)
+
+	changes := []Change{}
+	for _, p := range runtimePathsUnder(path) {
+		changes = append(changes, Change{Type: ChangeDeleted, Path: p, IsValue: true,
+			OldValue: runtimeValues[p].value})
+		delete(runtimeValues, p)
+	}
+
+	if len(changes) > 0 {
+		notifyWatchers(changes)
+	}
+
+	return nil
+}
+
+/*
+runtimePathsUnder returns the sorted paths of the runtime values at path and below it
+*/
+func runtimePathsUnder(path string) []string {
+	paths := []string{}
+	for p := range runtimeValues {
+		if path == "" || p == path || strings.HasPrefix(p, path+"/") {
+			paths = append(paths, p)
+		}
+	}
+
+	sort.Strings(paths)
+
+	return paths
+}
+
+/*
+virtualPathsUnder returns the sorted paths of the runtime values and of the values computed by providers registered
+for a single path (see RegisterProvider) or by virtual mounts (see MountEnv), at path and below it
+*/
+func virtualPathsUnder(path string) []string {
+	paths := runtimePathsUnder(path)
+
+	for _, p := range providedPathsUnder(path) {
+		if _, ok := runtimeValues[p]; !ok {
+			paths = append(paths, p)
+		}
+	}
+
+	sort.Strings(paths)
+
+	return paths
+}
+
+/*
+hasVirtualValues returns whether any runtime value is set or any provider is registered
+*/
+func hasVirtualValues() bool {
+	return len(runtimeValues) > 0 || len(providers) > 0
+}
+
+/*
+runtimeShadowed returns whether the Entry at path is hidden by a runtime value, or by a value computed by a provider
+registered for a single path, at one of its parents
+*/
+func runtimeShadowed(path string) bool {
+	for p := parentPath(path); p != ""; p = parentPath(p) {
+		if _, ok := runtimeValues[p]; ok {
+			return true
+		}
+
+		if providedPath(p) {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+getRuntimeValue returns the runtime value at path, if the value at path is determined by the runtime values: either
+set at path, or hidden by them. Must be called while the global mutex is held
+*/
+func getRuntimeValue(path string) (string, ValueType, bool, error) {
+	if !hasVirtualValues() {
+		return "", "", false, nil
+	}
+
+	v, ok, err := virtualValue(path)
+	if ok {
+		return v.value, v.valueType, true, err
+	}
+
+	if runtimeShadowed(path) {
+		return "", "", true, ErrPathNotFound
+	}
+
+	if len(virtualPathsUnder(path)) > 0 {
+		return "", "", true, ErrPathIsNotAValue
+	}
+
+	return "", "", false, nil
+}
+
+/*
+hasRuntimeValue returns whether the value at path is determined by the runtime values (see getRuntimeValue)
+*/
+func hasRuntimeValue(path string) bool {
+	_, _, ok, _ := getRuntimeValue(path)
+	return ok
+}
+
+/*
+getVisibleValue reads the value at path like getTypedValue, unless determined by the runtime values or buffered, with
+its references resolved (see SetInterpolationEnabled)
+*/
+func getVisibleValue(path string, tx *sql.Tx) (string, ValueType, error) {
+	value, valueType, err := getReferencedValue(path, tx)
+	if err != nil {
+		return value, valueType, err
+	}
+
+	return resolveReferences(path, value, valueType, tx)
+}
+
+/*
+runtimeExists returns whether an Entry exists at path, if determined by the runtime values. Must be called while the
+global mutex is held
+*/
+func runtimeExists(path string) (bool, bool) {
+	if !hasVirtualValues() {
+		return false, false
+	}
+
+	if runtimeShadowed(path) {
+		return false, true
+	}
+
+	_, ok, err := virtualValue(path)
+	if ok && !errors.Is(err, ErrPathIsNotAValue) {
+		return err == nil, true
+	}
+
+	if len(virtualPathsUnder(path)) > 0 {
+		return true, true
+	}
+
+	return false, false
+}
+
+/*
+withRuntime applies the runtime values to entry, the persistent Entry at path read up to depth, or to err if it
+couldn't be read. Must be called while the global mutex is held
+*/
+func withRuntime(path string, depth int, entry *Entry, err error) (*Entry, error) {
+	if !hasVirtualValues() {
+		return entry, err
+	}
+
+	if runtimeShadowed(path) {
+		return nil, ErrPathNotFound
+	}
+
+	v, ok, virtualErr := virtualValue(path)
+	if ok && !errors.Is(virtualErr, ErrPathIsNotAValue) {
+		if virtualErr != nil {
+			return nil, virtualErr
+		}
+
+		return runtimeEntry(path, v), nil
+	}
+
+	if len(virtualPathsUnder(path)) == 0 {
+		return entry, err
+	}
+
+	if err != nil && !errors.Is(err, ErrPathNotFound) {
+		return nil, err
+	}
+
+	if entry == nil || entry.IsValue {
+		entry = &Entry{Path: path, LastUpdate: time.Now(), Children: map[string]*Entry{}}
+	}
+
+	graftRuntime(entry, depth)
+
+	return entry, nil
+}
+
+/*
+graftRuntime adds the runtime values below entry to its children, up to depth levels below it, replacing the Entries
+they shadow
+*/
+func graftRuntime(entry *Entry, depth int) {
+	base := len(splitPath(entry.Path))
+
+	for _, p := range virtualPathsUnder(entry.Path) {
+		v, _, err := virtualValue(p)
+		if err != nil {
+			logDebug("error computing provided value", "path", p, "error", err)
+			continue
+		}
+
+		segments := splitPath(p)
+		node := entry
+
+		for i := base; i < len(segments); i++ {
+			level := i - base + 1
+			if depth >= 0 && level > depth {
+				break
+			}
+
+			name := segments[i]
+			if i == len(segments)-1 {
+				node.Children[name] = runtimeEntry(p, v)
+				break
+			}
+
+			child := node.Children[name]
+			if child == nil || child.IsValue {
+				child = &Entry{Path: joinPath(segments[:i+1]), LastUpdate: v.lastUpdate,
+					Children: map[string]*Entry{}, childrenPending: depth >= 0 && level == depth}
+				node.Children[name] = child
+			}
+
+			node = child
+		}
+	}
+}
+
+/*
+withRuntimeChildren applies the runtime values to children, the persistent children of the Entry at path, returning
+them sorted by path
+*/
+func withRuntimeChildren(path string, children []*Entry) []*Entry {
+	if !hasVirtualValues() {
+		return children
+	}
+
+	parent := &Entry{Path: path, Children: map[string]*Entry{}}
+	for _, child := range children {
+		parent.Children[namePath(child.Path)] = child
+	}
+
+	graftRuntime(parent, 1)
+
+	merged := make([]*Entry, 0, len(parent.Children))
+	for _, child := range parent.Children {
+		merged = append(merged, child)
+	}
+
+	sort.Slice(merged, func(i, j int) bool {
+		return merged[i].Path < merged[j].Path
+	})
+
+	return merged
+}
+
+func runtimeEntry(path string, v runtimeValue) *Entry {
+	return &Entry{
+		Path:       path,
+		LastUpdate: v.lastUpdate,
+		IsValue:    true,
+		Value:      v.value,
+		Type:       v.valueType,
+		Children:   map[string]*Entry{}}
+}
@@ -0,0 +1,475 @@
+package camellia
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+)
+
+/*
+salvageWindow is the number of rows read at once by Salvage, and the number of rows read one by one after a failed
+read, to skip past the damaged ones
+*/
+const salvageWindow = 256
+
+/*
+SalvageReport describes the outcome of Salvage.
+
+Salvaged and Lost hold, by table, the number of rows copied, and the number of rows that couldn't be read. Damaged
+pages don't tell which rows they held, so Lost is an estimate. LostTables lists the tables that couldn't be read at
+all.
+
+Corrupted lists the paths of the values dropped because they don't match their checksum (see Options.Checksums), and
+Recreated the paths of the non-value Entries recreated, empty, because their rows were lost while the ones of some of
+their descendants were not.
+*/
+type SalvageReport struct {
+	Salvaged   map[string]int
+	Lost       map[string]int
+	LostTables []string
+	Corrupted  []string
+	Recreated  []string
+}
+
+/*
+Complete returns whether nothing was lost.
+*/
+func (r *SalvageReport) Complete() bool {
+	for _, lost := range r.Lost {
+		if lost != 0 {
+			return false
+		}
+	}
+
+	return len(r.LostTables) == 0 && len(r.Corrupted) == 0 && len(r.Recreated) == 0
+}
+
+/*
+Salvage copies the rows still readable in the damaged DB file at srcPath to a new DB file at dstPath, which must not
+exist, returning a report of what was lost. It's meant to recover what survived a corruption, like one caused by a
+power loss with DurabilityOff, when the DB can't be opened, or reads fail with SQLite errors.
+
+srcPath is opened read-only, with key if encrypted, and independently of the DB currently open, like
+GetDBFileSchemaVersion does, and must be at the schema version of the library. Its tables are read in small batches,
+skipping the rows that can't be read, so that a damaged page loses only the rows it holds. dstPath is created at the
+current schema version, encrypted with key too. The hierarchy of dstPath is repaired, recreating the non-value Entries
+whose rows were lost, so that it can be opened and used right away.
+
+Fails with ErrDBVersionMismatch if srcPath is at another schema version, and with ErrInvalidKey if key is wrong.
+*/
+func Salvage(srcPath string, dstPath string, key string) (*SalvageReport, error) {
+	if srcPath == "" || dstPath == "" {
+		return nil, fmt.Errorf("DB path is empty")
+	}
+
+	_, err := os.Stat(dstPath)
+	if err == nil {
+		return nil, fmt.Errorf("error creating DB %s - %w", dstPath, fs.ErrExist)
+	}
+
+	src, err := openSalvageDB(mountURI(mountPoint{path: srcPath, readOnly: true}), key)
+	if err != nil {
+		return nil, fmt.Errorf("error opening DB %s - %w", srcPath, err)
+	}
+
+	defer src.Close()
+
+	version, err := getDBVersion(src)
+	if err != nil {
+		return nil, fmt.Errorf("error getting DB version - %w", wrapKeyError(err))
+	}
+
+	if version != dbVersion {
+		return nil, ErrDBVersionMismatch
+	}
+
+	dst, err := openSalvageDB(dstPath, key)
+	if err != nil {
+		return nil, fmt.Errorf("error creating DB %s - %w", dstPath, err)
+	}
+
+	defer dst.Close()
+
+	_, err = dst.Exec("PRAGMA journal_mode = WAL")
+	if err == nil {
+		_, err = migrate(dst)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error initializing DB %s - %w", dstPath, err)
+	}
+
+	tables, err := salvageTables(dst)
+	if err != nil {
+		return nil, fmt.Errorf("error listing tables - %w", err)
+	}
+
+	tx, err := dst.BeginTx(context.Background(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("error beginning transaction - %w", err)
+	}
+
+	report := &SalvageReport{
+		Salvaged:   map[string]int{},
+		Lost:       map[string]int{},
+		LostTables: []string{},
+		Corrupted:  []string{},
+		Recreated:  []string{}}
+
+	for _, t := range tables {
+		err = salvageTable(src, tx, t, report)
+		if err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("error salvaging table %s - %w", t, err)
+		}
+	}
+
+	err = repairHierarchy(tx, report)
+	if err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("error repairing hierarchy - %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("error committing transaction - %w", err)
+	}
+
+	logInfo("salvaged DB", "path", srcPath, "destination", dstPath, "salvaged", report.Salvaged, "lost", report.Lost)
+
+	return report, nil
+}
+
+/*
+openSalvageDB opens the DB at dataSource on a single connection, so that key, set on it before any other statement,
+applies to every statement
+*/
+func openSalvageDB(dataSource string, key string) (*sql.DB, error) {
+	d, err := sql.Open("sqlite3", dataSource)
+	if err != nil {
+		return nil, err
+	}
+
+	d.SetMaxOpenConns(1)
+	d.SetMaxIdleConns(1)
+
+	if key != "" {
+		_, err = d.Exec(keyPragma("key", key))
+		if err != nil {
+			d.Close()
+			return nil, fmt.Errorf("error setting encryption key - %w", err)
+		}
+	}
+
+	return d, nil
+}
+
+/*
+salvageTables returns the tables of the DB d, in the order they were created
+*/
+func salvageTables(d *sql.DB) ([]string, error) {
+	rows, err := d.Query("SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY rowid")
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	tables := []string{}
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			return nil, err
+		}
+
+		tables = append(tables, name)
+	}
+
+	return tables, rows.Err()
+}
+
+/*
+salvageTable copies the readable rows of table t from src through tx, in the order of their rowid, recording the
+outcome in report. Only failures writing to tx are returned
+*/
+func salvageTable(src *sql.DB, tx *sql.Tx, t string, report *SalvageReport) error {
+	columns, err := tableColumns(tx, t)
+	if err != nil {
+		return err
+	}
+
+	var maxRowID sql.NullInt64
+	err = src.QueryRow(fmt.Sprintf("SELECT MAX(rowid) FROM %s", t)).Scan(&maxRowID)
+	if err != nil {
+		logWarn("table can't be salvaged", "table", t, "error", err)
+		report.LostTables = append(report.LostTables, t)
+		return nil
+	}
+
+	insert, err := tx.Prepare(fmt.Sprintf("INSERT OR REPLACE INTO %s (%s) VALUES (%s)", t,
+		strings.Join(columns, ", "), strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")))
+	if err != nil {
+		return err
+	}
+
+	defer insert.Close()
+
+	query := fmt.Sprintf("SELECT rowid, %s FROM %s WHERE rowid > ? ORDER BY rowid LIMIT %d", strings.Join(columns, ", "),
+		t, salvageWindow)
+	queryRow := fmt.Sprintf("SELECT rowid, %s FROM %s WHERE rowid = ?", strings.Join(columns, ", "), t)
+
+	// Rowids start from 1
+	last := int64(0)
+	for maxRowID.Valid && last < maxRowID.Int64 {
+		var writeErr error
+		copied := 0
+		err = queryRows(src, query, []any{last}, len(columns), func(rowID int64, values []any) error {
+			last = rowID
+			copied++
+			writeErr = salvageRow(insert, t, columns, values, report)
+			return writeErr
+		})
+
+		if writeErr != nil {
+			return writeErr
+		}
+
+		if err == nil {
+			if copied < salvageWindow {
+				break
+			}
+
+			continue
+		}
+
+		// The batch failed at a damaged row, so the rows following it are read one by one, until past the window
+		end := last + salvageWindow
+		for rowID := last + 1; rowID <= end && rowID <= maxRowID.Int64; rowID++ {
+			err = queryRows(src, queryRow, []any{rowID}, len(columns), func(_ int64, values []any) error {
+				writeErr = salvageRow(insert, t, columns, values, report)
+				return writeErr
+			})
+
+			if writeErr != nil {
+				return writeErr
+			}
+
+			if err != nil {
+				report.Lost[t]++
+			}
+		}
+
+		last = end
+	}
+
+	return nil
+}
+
+/*
+tableColumns returns the names of the columns of table t
+*/
+func tableColumns(tx *sql.Tx, t string) ([]string, error) {
+	rows, err := tx.Query(fmt.Sprintf("SELECT name FROM pragma_table_info('%s')", t))
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	columns := []string{}
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			return nil, err
+		}
+
+		columns = append(columns, name)
+	}
+
+	return columns, rows.Err()
+}
+
+/*
+queryRows calls cb with the rowid and the values of the rows returned by query, failing if any of them can't be read.
+The rows passed to cb before a failure stay passed
+*/
+func queryRows(src *sql.DB, query string, args []any, columns int, cb func(rowID int64, values []any) error) error {
+	rows, err := src.Query(query, args...)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var rowID int64
+		values := make([]any, columns)
+		dest := []any{&rowID}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+
+		err = rows.Scan(dest...)
+		if err != nil {
+			return err
+		}
+
+		err = cb(rowID, values)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+/*
+salvageRow writes a row read from table t, unless it's a value not matching its checksum
+*/
+func salvageRow(insert *sql.Stmt, t string, columns []string, values []any, report *SalvageReport) error {
+	if t == table {
+		row := map[string]any{}
+		for i, c := range columns {
+			row[c] = values[i]
+		}
+
+		var checksum sql.NullInt64
+		checksum.Int64, checksum.Valid = row[colChecksum].(int64)
+
+		path := salvageString(row[colPath])
+		err := verifyChecksum(path, salvageString(row[colValue]), salvageBytes(row[colBlobValue]), checksum)
+		if errors.Is(err, ErrValueCorrupted) {
+			report.Corrupted = append(report.Corrupted, path)
+			return nil
+		}
+	}
+
+	_, err := insert.Exec(values...)
+	if err != nil {
+		return err
+	}
+
+	report.Salvaged[t]++
+
+	return nil
+}
+
+func salvageString(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	default:
+		return ""
+	}
+}
+
+func salvageBytes(v any) []byte {
+	switch v := v.(type) {
+	case string:
+		return []byte(v)
+	case []byte:
+		return v
+	default:
+		return nil
+	}
+}
+
+/*
+repairHierarchy recreates the non-value Entries whose rows were lost, reattaching to them the Entries left without a
+parent
+*/
+func repairHierarchy(tx *sql.Tx, report *SalvageReport) error {
+	rows, err := tx.Query(fmt.Sprintf(
+		`SELECT e.%[1]s, e.%[2]s FROM %[3]s e
+			WHERE e.%[4]s IS NOT NULL AND NOT EXISTS (SELECT 1 FROM %[3]s p WHERE p.%[1]s = e.%[4]s)
+			ORDER BY e.%[2]s`,
+		colID, colPath, table, colParentID))
+	if err != nil {
+		return err
+	}
+
+	type orphan struct {
+		id   int64
+		path string
+	}
+
+	orphans := []orphan{}
+	for rows.Next() {
+		var o orphan
+		err = rows.Scan(&o.id, &o.path)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+
+		orphans = append(orphans, o)
+	}
+
+	rows.Close()
+	if rows.Err() != nil {
+		return rows.Err()
+	}
+
+	for _, o := range orphans {
+		parentID, err := salvageParent(tx, parentPath(o.path), report)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET %s = ? WHERE %s = ?", table, colParentID, colID), parentID, o.id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+salvageParent returns the ID of the Entry at path, recreating it, and its missing ancestors, as a non-value Entry if
+its row was lost. The root has no ID
+*/
+func salvageParent(tx *sql.Tx, path string, report *SalvageReport) (sql.NullInt64, error) {
+	if path == "" {
+		return sql.NullInt64{}, nil
+	}
+
+	var id int64
+	err := tx.QueryRow(fmt.Sprintf("SELECT %s FROM %s WHERE %s = ?", colID, table, colPath), path).Scan(&id)
+	if err == nil {
+		return sql.NullInt64{Int64: id, Valid: true}, nil
+	}
+
+	if !errors.Is(err, sql.ErrNoRows) {
+		return sql.NullInt64{}, err
+	}
+
+	parentID, err := salvageParent(tx, parentPath(path), report)
+	if err != nil {
+		return sql.NullInt64{}, err
+	}
+
+	res, err := tx.Exec(fmt.Sprintf("INSERT INTO %s (%s, %s, %s, %s) VALUES (?, ?, 0, ?)", table, colPath,
+		colLastUpdateMs, colIsValue, colParentID), path, time.Now().UnixMilli(), parentID)
+	if err != nil {
+		return sql.NullInt64{}, err
+	}
+
+	id, err = res.LastInsertId()
+	if err != nil {
+		return sql.NullInt64{}, err
+	}
+
+	report.Recreated = append(report.Recreated, path)
+
+	return sql.NullInt64{Int64: id, Valid: true}, nil
+}
@@ -0,0 +1,335 @@
+package camellia
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	pathpkg "path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+/*
+SchemaRule constrains the values at the paths matching its pattern.
+
+Type: the type the value must be convertible to (TypeString, TypeInt, TypeFloat, TypeBool, TypeList, TypeBytes or
+TypeNull). TypeUntyped accepts any value.
+
+Min, Max: the allowed range. Applies to the value itself for TypeInt and TypeFloat, to the number of elements for
+TypeList, and to the length of the value otherwise.
+
+Pattern: a regular expression the value must match.
+
+Required: the path must always exist. Applies only to patterns without wildcards.
+*/
+type SchemaRule struct {
+	Type     ValueType `json:"type,omitempty"`
+	Min      *float64  `json:"min,omitempty"`
+	Max      *float64  `json:"max,omitempty"`
+	Pattern  string    `json:"pattern,omitempty"`
+	Required bool      `json:"required,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+/*
+Schema maps path patterns to the rules enforced on them.
+
+Patterns follow the syntax of path.Match, so a "*" matches a single path segment (like in "network/interfaces/*").
+When multiple patterns match a path, all their rules are enforced.
+*/
+type Schema struct {
+	Rules map[string]*SchemaRule
+
+	patterns []string
+}
+
+/*
+ValidationError describes a value (or a missing required path) violating the Schema, or rejected by a validator.
+
+Pattern is the path pattern of the violated rule or validator. Err is the error returned by the validator, if any.
+ValidationErrors always match ErrValidationFailed with errors.Is.
+*/
+type ValidationError struct {
+	Path    string
+	Value   string
+	Pattern string
+	Reason  string
+	Err     error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s (rule %s)", e.Path, e.Reason, e.Pattern)
+}
+
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrValidationFailed
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+var schema *Schema
+
+/*
+LoadSchema reads a Schema from its JSON representation, a map of path patterns to rules:
+
+	{
+	    "network/mtu": { "type": "int", "min": 576, "max": 9000, "required": true },
+	    "network/hostname": { "type": "string", "pattern": "^[a-z0-9-]+$" },
+	    "network/dns": { "type": "list", "max": 3 }
+	}
+
+Only JSON is supported: camellia doesn't depend on a YAML library, so schemas written in YAML must be converted to
+JSON first, for example with yq -o json.
+*/
+func LoadSchema(reader io.Reader) (*Schema, error) {
+	s := Schema{}
+	err := json.NewDecoder(reader).Decode(&s.Rules)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding schema - %w", err)
+	}
+
+	err = s.compile()
+	if err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+/*
+SetSchema sets the Schema enforced when setting, forcing, importing and deleting values. Violations make the whole
+operation fail with a *ValidationError.
+
+Values already in the DB are not checked (see Validate). A nil schema disables validation.
+*/
+func SetSchema(s *Schema) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if s != nil {
+		err := s.compile()
+		if err != nil {
+			return err
+		}
+	}
+
+	schema = s
+
+	return nil
+}
+
+/*
+Validate checks all the values in the DB, and the existence of the required paths, against the current Schema.
+*/
+func Validate() ([]*ValidationError, error) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return nil, ErrNoDB
+	}
+
+	violations := []*ValidationError{}
+	if schema == nil {
+		return violations, nil
+	}
+
+	tx, err := beginReadTx(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("error beginning transaction - %w", err)
+	}
+
+	err = recurse("", -1, func(entry *Entry, parent *Entry, depth uint) error {
+		if !entry.IsValue {
+			return nil
+		}
+
+		err := validateValue(entry.Path, entry.Value, entry.Type)
+		var vErr *ValidationError
+		if errors.As(err, &vErr) {
+			violations = append(violations, vErr)
+			return nil
+		}
+
+		return err
+	}, tx)
+
+	if err != nil {
+		rollbackTx(tx)
+		return nil, err
+	}
+
+	missing, err := missingRequired(tx)
+	if err != nil {
+		rollbackTx(tx)
+		return nil, err
+	}
+
+	err = endReadTx(context.Background(), tx)
+	if err != nil {
+		rollbackTx(tx)
+		return nil, fmt.Errorf("error committing transaction - %w", err)
+	}
+
+	return append(violations, missing...), nil
+}
+
+func (s *Schema) compile() error {
+	s.patterns = []string{}
+
+	for pattern, rule := range s.Rules {
+		_, err := pathpkg.Match(pattern, "")
+		if err != nil {
+			return fmt.Errorf("invalid path pattern %s - %w", pattern, err)
+		}
+
+		switch rule.Type {
+		case TypeUntyped, TypeString, TypeInt, TypeFloat, TypeBool, TypeList, TypeBytes, TypeNull:
+		default:
+			return fmt.Errorf("invalid type %s for path pattern %s", rule.Type, pattern)
+		}
+
+		rule.pattern = nil
+		if rule.Pattern != "" {
+			rule.pattern, err = regexp.Compile(rule.Pattern)
+			if err != nil {
+				return fmt.Errorf("invalid regular expression for path pattern %s - %w", pattern, err)
+			}
+		}
+
+		s.patterns = append(s.patterns, pattern)
+	}
+
+	sort.Strings(s.patterns)
+
+	return nil
+}
+
+func validateValue(path string, value string, valueType ValueType) error {
+	if schema == nil {
+		return callValidators(path, value)
+	}
+
+	for _, pattern := range schema.patterns {
+		if matched, _ := matchPath(pattern, path); !matched {
+			continue
+		}
+
+		reason := schema.Rules[pattern].check(value, valueType)
+		if reason != "" {
+			return &ValidationError{Path: path, Value: value, Pattern: pattern, Reason: reason}
+		}
+	}
+
+	return callValidators(path, value)
+}
+
+func (r *SchemaRule) check(value string, valueType ValueType) string {
+	size := float64(utf8.RuneCountInString(value))
+
+	switch r.Type {
+	case TypeInt:
+		i, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return "value is not an int"
+		}
+
+		size = float64(i)
+	case TypeFloat:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return "value is not a float"
+		}
+
+		size = f
+	case TypeBool:
+		_, err := strconv.ParseBool(value)
+		if err != nil {
+			return "value is not a bool"
+		}
+	case TypeList:
+		if valueType != TypeList {
+			return "value is not a list"
+		}
+
+		elements, err := decodeList(value)
+		if err != nil {
+			return "value is not a valid list"
+		}
+
+		size = float64(len(elements))
+	case TypeBytes:
+		if valueType != TypeBytes && valueType != TypeStream {
+			return "value is not binary"
+		}
+	case TypeNull:
+		if valueType != TypeNull {
+			return "value is not null"
+		}
+	}
+
+	if r.Min != nil && size < *r.Min {
+		return fmt.Sprintf("value is less than %v", *r.Min)
+	}
+
+	if r.Max != nil && size > *r.Max {
+		return fmt.Sprintf("value is greater than %v", *r.Max)
+	}
+
+	if r.pattern != nil && !r.pattern.MatchString(value) {
+		return fmt.Sprintf("value does not match %s", r.Pattern)
+	}
+
+	return ""
+}
+
+/*
+missingRequired returns the required paths that do not exist
+*/
+func missingRequired(tx *sql.Tx) ([]*ValidationError, error) {
+	missing := []*ValidationError{}
+	if schema == nil {
+		return missing, nil
+	}
+
+	for _, pattern := range schema.patterns {
+		if !schema.Rules[pattern].Required || strings.ContainsAny(pattern, "*?[\\") {
+			continue
+		}
+
+		path := normalizePath(pattern)
+		e, err := exists(path, tx)
+		if err != nil {
+			return nil, err
+		}
+
+		if !e {
+			missing = append(missing, &ValidationError{Path: path, Pattern: pattern, Reason: "required path is missing"})
+		}
+	}
+
+	return missing, nil
+}
+
+func checkRequired(tx *sql.Tx) error {
+	missing, err := missingRequired(tx)
+	if err != nil {
+		return err
+	}
+
+	if len(missing) > 0 {
+		return missing[0]
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package camellia
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	pathpkg "path"
+	"sort"
+	"strings"
+)
+
+/*
+LoadDefaults sets the values found in the JSON representation read from reader, only where no Entry exists yet in the
+DB. It's meant to be called at startup, right after Open, so that a first boot initializes the configuration, while the
+values set later are kept across restarts:
+
+	//go:embed defaults.json
+	var defaults []byte
+	...
+	err = camellia.LoadDefaults(bytes.NewReader(defaults))
+
+JSON numbers and booleans keep their type (see ImportOptions.NativeTypes).
+*/
+func LoadDefaults(reader io.Reader) error {
+	return ImportJSON(reader, ImportOptions{OnlyMerge: true, NativeTypes: true})
+}
+
+/*
+LoadDefaultsFS calls LoadDefaults on each file of fsys matching pattern, with the syntax of fs.Glob, in lexical order.
+Each file is loaded in its own transaction, so the values of the files loaded before a failing one are kept. Meant to
+be used with an embedded file system:
+
+	//go:embed defaults
+	var defaults embed.FS
+	...
+	err = camellia.LoadDefaultsFS(defaults, "defaults/*.json")
+
+Only JSON files are supported: files with a YAML extension (.yaml, .yml) fail before any file is loaded.
+*/
+func LoadDefaultsFS(fsys fs.FS, pattern string) error {
+	names, err := fs.Glob(fsys, pattern)
+	if err != nil {
+		return fmt.Errorf("error matching pattern %s - %w", pattern, err)
+	}
+
+	sort.Strings(names)
+
+	files := []string{}
+	for _, name := range names {
+		info, err := fs.Stat(fsys, name)
+		if err != nil {
+			return fmt.Errorf("error reading file %s - %w", name, err)
+		}
+
+		if info.IsDir() {
+			continue
+		}
+
+		switch strings.ToLower(pathpkg.Ext(name)) {
+		case ".yaml", ".yml":
+			return fmt.Errorf("unsupported format of file %s, defaults must be in JSON", name)
+		}
+
+		files = append(files, name)
+	}
+
+	for _, name := range files {
+		err = loadDefaultsFile(fsys, name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func loadDefaultsFile(fsys fs.FS, name string) error {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return fmt.Errorf("error opening file %s - %w", name, err)
+	}
+	defer file.Close()
+
+	err = LoadDefaults(file)
+	if err != nil {
+		return fmt.Errorf("error loading defaults from %s - %w", name, err)
+	}
+
+	return nil
+}
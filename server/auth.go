@@ -0,0 +1,171 @@
+package server
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	cml "github.com/debevv/camellia"
+)
+
+/*
+Credential grants access to the server, to clients presenting either Token (as an "Authorization: Bearer <token>"
+header) or Username and Password (with HTTP basic authentication).
+
+Prefixes limits the access to the Entries at, or under, the specified paths. With no Prefixes, the whole tree is
+accessible. Imports, which can touch any path, are allowed only to Credentials without Prefixes.
+
+With ReadOnly == true, only read operations (entry reads, exports and dry run imports) are allowed.
+
+Principal identifies the client to the ACL of the server, if any. If empty, Username is used.
+*/
+type Credential struct {
+	Principal string   `json:"principal,omitempty"`
+	Token     string   `json:"token,omitempty"`
+	Username  string   `json:"username,omitempty"`
+	Password  string   `json:"password,omitempty"`
+	Prefixes  []string `json:"prefixes,omitempty"`
+	ReadOnly  bool     `json:"read_only,omitempty"`
+}
+
+var errUnauthorized = errors.New("unauthorized")
+var errForbidden = errors.New("forbidden")
+
+/*
+authorize verifies that the credential presented with r grants access to path (or to the whole tree, if path is nil),
+writing an error response and returning false otherwise. With no configured Credentials, everything is allowed.
+*/
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request, path *string, write bool) bool {
+	if len(s.options.Credentials) == 0 {
+		return true
+	}
+
+	credential := s.findCredential(r)
+	if credential == nil {
+		w.Header().Set("WWW-Authenticate", `Basic realm="camellia"`)
+		writeError(w, http.StatusUnauthorized, errUnauthorized)
+		return false
+	}
+
+	if (write && credential.ReadOnly) || !credential.allows(path) {
+		writeError(w, http.StatusForbidden, errForbidden)
+		return false
+	}
+
+	return true
+}
+
+func (s *Server) findCredential(r *http.Request) *Credential {
+	token := ""
+	authorization := r.Header.Get("Authorization")
+	if strings.HasPrefix(authorization, "Bearer ") {
+		token = strings.TrimSpace(strings.TrimPrefix(authorization, "Bearer "))
+	}
+
+	username, password, basic := r.BasicAuth()
+
+	for i := range s.options.Credentials {
+		c := &s.options.Credentials[i]
+
+		if token != "" && c.Token != "" && secureEquals(token, c.Token) {
+			return c
+		}
+
+		if basic && c.Username != "" && secureEquals(username, c.Username) && secureEquals(password, c.Password) {
+			return c
+		}
+	}
+
+	return nil
+}
+
+/*
+principal returns the principal making r (see Options.ACL), or an empty string for anonymous clients
+*/
+func (s *Server) principal(r *http.Request) string {
+	credential := s.findCredential(r)
+	if credential != nil {
+		return credential.principal()
+	}
+
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return "cert:" + r.TLS.VerifiedChains[0][0].Subject.CommonName
+	}
+
+	return ""
+}
+
+/*
+checkAccess verifies that principal is allowed by the ACL of the Server, if any, to access path
+*/
+func (s *Server) checkAccess(principal string, path string, write bool) error {
+	if s.options.ACL == nil {
+		return nil
+	}
+
+	return s.options.ACL.Check(principal, path, write)
+}
+
+/*
+importJSON imports data, after verifying that principal is allowed to write (or, with dryRun == true, to read) every
+path changed by the import. The check is based on a dry run of the import.
+*/
+func (s *Server) importJSON(data []byte, options cml.ImportOptions, dryRun bool, principal string) ([]cml.Change,
+	error) {
+	if s.options.ACL == nil && !dryRun {
+		return nil, cml.ImportJSON(bytes.NewReader(data), options)
+	}
+
+	changes, err := cml.DryRunImportJSON(bytes.NewReader(data), options)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range changes {
+		err := s.checkAccess(principal, c.Path, !dryRun)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if dryRun {
+		return changes, nil
+	}
+
+	return nil, cml.ImportJSON(bytes.NewReader(data), options)
+}
+
+func (c *Credential) principal() string {
+	if c.Principal != "" {
+		return c.Principal
+	}
+
+	return c.Username
+}
+
+func (c *Credential) allows(path *string) bool {
+	if len(c.Prefixes) == 0 {
+		return true
+	}
+
+	if path == nil {
+		return false
+	}
+
+	// Compare the paths as the DB stores them, or "prefix/ ../other" would pass the check while writing "other"
+	p := cml.NormalizePath(*path)
+	for _, prefix := range c.Prefixes {
+		prefix = cml.NormalizePath(prefix)
+		if prefix == "" || p == prefix || strings.HasPrefix(p, prefix+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func secureEquals(a string, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"net/http"
+
+	cml "github.com/debevv/camellia"
+)
+
+const healthPath = "/healthz"
+
+type jsonHealth struct {
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	Revision  uint64 `json:"revision"`
+	Writable  bool   `json:"writable"`
+	WALSize   int64  `json:"wal_size"`
+	FreeSpace uint64 `json:"free_space"`
+}
+
+/*
+handleHealth serves the result of camellia.Health, with 200 if the DB is healthy and 503 otherwise. It requires no
+authentication, so that supervisors can probe the server without credentials, and it does not report the path of the
+DB
+*/
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	report, err := cml.Health()
+
+	health := jsonHealth{
+		Status:    "ok",
+		Revision:  report.Revision,
+		Writable:  report.Writable,
+		WALSize:   report.WALSize,
+		FreeSpace: report.FreeSpace}
+
+	status := http.StatusOK
+	if err != nil {
+		health.Status = "error"
+		health.Error = err.Error()
+		status = http.StatusServiceUnavailable
+	}
+
+	writeJSON(w, status, health)
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	cml "github.com/debevv/camellia"
+)
+
+const infoPath = "/v1/info"
+
+/*
+Info describes the DB served by a Server and the hooks registered in its process, to debug hooks not being called.
+
+SchemaVersion: the schema version of the DB. Revision: the current revision of the DB. HooksEnabled: whether hooks
+are called (see camellia.SetHooksEnabled). Hooks: the hooks registered (see camellia.ListHooks).
+*/
+type Info struct {
+	SchemaVersion uint64         `json:"schema_version"`
+	Revision      uint64         `json:"revision"`
+	HooksEnabled  bool           `json:"hooks_enabled"`
+	Hooks         []cml.HookInfo `json:"hooks"`
+}
+
+func (s *Server) handleInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	if !s.authorize(w, r, nil, false) {
+		return
+	}
+
+	revision, err := cml.GetRevision()
+	if err != nil {
+		writeCamelliaError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, Info{
+		SchemaVersion: cml.GetSupportedDBSchemaVersion(),
+		Revision:      revision,
+		HooksEnabled:  cml.GetHooksEnabled(),
+		Hooks:         cml.ListHooks()})
+}
+
+/*
+Info returns the description of the DB served by the server and of the hooks registered in its process. Supported on
+HTTP servers only.
+*/
+func (c *Client) Info() (*Info, error) {
+	if c.socketPath != "" {
+		return nil, errors.New("info is supported on HTTP servers only")
+	}
+
+	body, err := c.httpRequest(http.MethodGet, infoPath, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer body.Close()
+
+	var info Info
+	err = json.NewDecoder(body).Decode(&info)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding info - %w", err)
+	}
+
+	return &info, nil
+}
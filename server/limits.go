@@ -0,0 +1,224 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultMaxBodySize = 16 * 1024 * 1024
+
+	// Idle buckets are pruned only when there are more than this many, to bound the memory used by many clients
+	maxIdleBuckets = 1024
+)
+
+var errRateLimited = errors.New("too many requests")
+var errBodyTooLarge = errors.New("request body too large")
+
+/*
+Metrics counts the requests handled by a Server.
+
+Requests: all the requests received. RateLimited: the requests rejected with 429 by the rate limiter. TooLarge: the
+requests rejected with 413 because their body exceeded Options.MaxBodySize.
+*/
+type Metrics struct {
+	Requests    uint64 `json:"requests"`
+	RateLimited uint64 `json:"rate_limited"`
+	TooLarge    uint64 `json:"too_large"`
+}
+
+/*
+rateLimiter is a token bucket rate limiter, with a bucket for each client
+*/
+type rateLimiter struct {
+	mutex   sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+
+	if burst <= 0 {
+		burst = int(math.Max(1, math.Ceil(rate)))
+	}
+
+	return &rateLimiter{rate: rate, burst: float64(burst), buckets: make(map[string]*bucket)}
+}
+
+/*
+allow consumes a token from the bucket of client, returning false if the bucket is empty
+*/
+func (l *rateLimiter) allow(client string) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+
+	b := l.buckets[client]
+	if b == nil {
+		if len(l.buckets) >= maxIdleBuckets {
+			l.prune(now)
+		}
+
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+	return true
+}
+
+/*
+prune removes the buckets that have been refilled completely, since they are equivalent to new ones
+*/
+func (l *rateLimiter) prune(now time.Time) {
+	for client, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+/*
+Metrics returns the current values of the request counters of the Server.
+*/
+func (s *Server) Metrics() Metrics {
+	return Metrics{
+		Requests:    atomic.LoadUint64(&s.requests),
+		RateLimited: atomic.LoadUint64(&s.rateLimited),
+		TooLarge:    atomic.LoadUint64(&s.tooLarge),
+	}
+}
+
+func (s *Server) maxBodySize() int64 {
+	if s.options.MaxBodySize > 0 {
+		return s.options.MaxBodySize
+	}
+
+	return defaultMaxBodySize
+}
+
+/*
+allow counts a request from client, returning false if it exceeds the rate limit
+*/
+func (s *Server) allow(client string) bool {
+	atomic.AddUint64(&s.requests, 1)
+
+	if s.limiter == nil || s.limiter.allow(client) {
+		return true
+	}
+
+	atomic.AddUint64(&s.rateLimited, 1)
+	return false
+}
+
+/*
+clientKey identifies the client making r for rate limiting: by its principal, if any, or by its IP address
+*/
+func (s *Server) clientKey(r *http.Request) string {
+	principal := s.principal(r)
+	if principal != "" {
+		return principal
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+/*
+limit applies the rate limit and the maximum body size to r, writing an error response and returning false if r
+exceeds them
+*/
+func (s *Server) limit(w http.ResponseWriter, r *http.Request) bool {
+	if !s.allow(s.clientKey(r)) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(1/s.options.RateLimit))))
+		writeError(w, http.StatusTooManyRequests, errRateLimited)
+		return false
+	}
+
+	if r.ContentLength > s.maxBodySize() {
+		atomic.AddUint64(&s.tooLarge, 1)
+		writeError(w, http.StatusRequestEntityTooLarge, errBodyTooLarge)
+		return false
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, s.maxBodySize())
+	return true
+}
+
+/*
+readBody reads the body of r, writing an error response and returning false if it fails, which happens mostly when
+the body exceeds the maximum size
+*/
+func (s *Server) readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		atomic.AddUint64(&s.tooLarge, 1)
+		writeError(w, http.StatusRequestEntityTooLarge, errBodyTooLarge)
+		return nil, false
+	}
+
+	return body, true
+}
+
+/*
+gunzip returns data decompressed, if it's compressed with gzip, as detected by its magic bytes, or data itself
+otherwise. Decompressed data larger than limit, if positive, fails with errBodyTooLarge, so that small compressed
+requests can't expand without bounds
+*/
+func gunzip(data []byte, limit int64) ([]byte, error) {
+	if len(data) < 2 || data[0] != 0x1f || data[1] != 0x8b {
+		return data, nil
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("error reading gzip header - %w", err)
+	}
+
+	var r io.Reader = zr
+	if limit > 0 {
+		r = io.LimitReader(zr, limit+1)
+	}
+
+	decompressed, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("error decompressing body - %w", err)
+	}
+
+	if limit > 0 && int64(len(decompressed)) > limit {
+		return nil, errBodyTooLarge
+	}
+
+	return decompressed, nil
+}
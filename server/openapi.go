@@ -0,0 +1,29 @@
+package server
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+const openAPIPath = "/openapi.json"
+
+/*
+OpenAPI is the OpenAPI 3 document describing the HTTP API of the Server, served at /openapi.json. It is kept in sync
+with the handlers by the tests of the package.
+*/
+//go:embed openapi.json
+var OpenAPI []byte
+
+/*
+handleOpenAPI serves the OpenAPI document. It requires no authentication, since the document is the same for every
+server and describes nothing about the DB
+*/
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(OpenAPI)
+}
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"net"
+	"strconv"
+	"syscall"
+)
+
+/*
+socketPrincipal returns the "uid:<user ID>" principal of the process connected to conn, or an empty string if it
+can't be determined
+*/
+func socketPrincipal(conn net.Conn) string {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return ""
+	}
+
+	rawConn, err := unixConn.SyscallConn()
+	if err != nil {
+		return ""
+	}
+
+	var cred *syscall.Ucred
+	err = rawConn.Control(func(fd uintptr) {
+		cred, err = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+
+	if err != nil || cred == nil {
+		return ""
+	}
+
+	return "uid:" + strconv.FormatUint(uint64(cred.Uid), 10)
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package server
+
+import "net"
+
+/*
+socketPrincipal returns an empty string, since the credentials of peer processes are available only on Linux
+*/
+func socketPrincipal(conn net.Conn) string {
+	return ""
+}
@@ -0,0 +1,269 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	cml "github.com/debevv/camellia"
+)
+
+/*
+ReplicateOptions configures Client.Replicate.
+
+Path: the path of the replicated hierarchy, the root if empty.
+
+PollTimeout: how long each request waits on the primary for new changes, 30 seconds if 0.
+*/
+type ReplicateOptions struct {
+	Path        string
+	PollTimeout time.Duration
+}
+
+/*
+GetEvents returns, in order, the changes to the Entry at path and to its children made on the server after
+sinceRevision, waiting up to timeout for at least one, along with the revision to continue from (see
+camellia.GetEvents). Fails with a RemoteError matching camellia.ErrRevisionCompacted if the server no longer has the
+changes after sinceRevision. Supported on HTTP servers only.
+*/
+func (c *Client) GetEvents(path string, sinceRevision uint64, timeout time.Duration) ([]cml.Event, uint64, error) {
+	return c.pollEvents(context.Background(), path, &sinceRevision, timeout)
+}
+
+/*
+GetRevision returns the current revision of the DB served by the server (see camellia.GetRevision). Supported on HTTP
+servers only.
+*/
+func (c *Client) GetRevision() (uint64, error) {
+	_, revision, err := c.pollEvents(context.Background(), "", nil, 0)
+	return revision, err
+}
+
+/*
+Replicate makes the open DB a replica of the server, the primary, until ctx is done, returning nil.
+
+The hierarchy at options.Path is first copied in full from the primary, replacing the local one, then the changes made
+on the primary are streamed from its change log and applied in batches, each one in a single transaction (see
+camellia.ApplyReplicated). The revision of the primary reached is stored in the DB, so replication resumes from it
+after a restart, falling back to a full copy only if the primary no longer has the changes after it. Several replicas
+can follow the same primary, but a DB can replicate a single primary.
+
+Changes are applied with their types, but without their timestamps and writers. Local changes to the replicated
+hierarchy are overwritten as soon as the primary changes the same Entries, so replicas should be treated as read-only.
+Fails on the first request to the primary failing: the caller retries, resuming from the last revision applied.
+Supported on HTTP servers only.
+*/
+func (c *Client) Replicate(ctx context.Context, options ReplicateOptions) error {
+	if c.socketPath != "" {
+		return errors.New("replication is supported on HTTP servers only")
+	}
+
+	path := cml.Path(options.Path).String()
+
+	timeout := options.PollTimeout
+	if timeout == 0 {
+		timeout = defaultPollTimeout
+	}
+
+	revision, err := cml.GetReplicatedRevision()
+	if err != nil {
+		return err
+	}
+
+	full := revision == 0
+
+	for ctx.Err() == nil {
+		if full {
+			revision, err = c.replicateFull(ctx, path)
+			if err != nil {
+				break
+			}
+
+			full = false
+		}
+
+		var events []cml.Event
+		var next uint64
+		events, next, err = c.pollEvents(ctx, path, &revision, timeout)
+		if errors.Is(err, cml.ErrRevisionCompacted) {
+			full = true
+			continue
+		}
+
+		if err != nil {
+			break
+		}
+
+		// The primary was replaced by one with a shorter history
+		if next < revision {
+			full = true
+			continue
+		}
+
+		if len(events) > 0 {
+			var entries []cml.ReplicatedEntry
+			entries, err = c.replicatedEntries(ctx, path, events)
+			if err != nil {
+				break
+			}
+
+			err = cml.ApplyReplicated(entries, next)
+			if err != nil {
+				return err
+			}
+		}
+
+		revision = next
+	}
+
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	return err
+}
+
+/*
+replicateFull copies the hierarchy at path from the primary, returning the revision to continue from
+*/
+func (c *Client) replicateFull(ctx context.Context, path string) (uint64, error) {
+	// Read before the hierarchy, so that the changes made in between are applied again
+	_, revision, err := c.pollEvents(ctx, path, nil, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	entry, err := c.replicatedEntry(ctx, path)
+	if err != nil {
+		return 0, err
+	}
+
+	err = cml.ApplyReplicated([]cml.ReplicatedEntry{{Path: path, Entry: entry}}, revision)
+	if err != nil {
+		return 0, err
+	}
+
+	return revision, nil
+}
+
+/*
+replicatedEntries returns the current state, on the primary, of the hierarchies changed by events. Changes below other
+changed Entries are covered by the state of the latter
+*/
+func (c *Client) replicatedEntries(ctx context.Context, path string, events []cml.Event) ([]cml.ReplicatedEntry,
+	error) {
+	changed := map[string]bool{}
+	for _, e := range events {
+		// Changes above the replicated path, like deletions of its ancestors, affect it all
+		if isAncestor(path, e.Path) {
+			changed[e.Path] = true
+		} else {
+			changed[path] = true
+		}
+	}
+
+	paths := make([]string, 0, len(changed))
+	for p := range changed {
+		paths = append(paths, p)
+	}
+
+	sort.Strings(paths)
+
+	entries := []cml.ReplicatedEntry{}
+	for _, p := range paths {
+		covered := false
+		for _, e := range entries {
+			if isAncestor(e.Path, p) {
+				covered = true
+				break
+			}
+		}
+
+		if covered {
+			continue
+		}
+
+		entry, err := c.replicatedEntry(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, cml.ReplicatedEntry{Path: p, Entry: entry})
+	}
+
+	return entries, nil
+}
+
+/*
+replicatedEntry reads the hierarchy at path from the primary, in the extended JSON format, returning nil if it doesn't
+exist
+*/
+func (c *Client) replicatedEntry(ctx context.Context, path string) (*cml.Entry, error) {
+	body, err := c.httpRequestCtx(ctx, http.MethodGet, exportPrefix+escapePath(path)+"?extended=true", nil)
+	if errors.Is(err, cml.ErrPathNotFound) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer body.Close()
+
+	var entry cml.Entry
+	err = json.NewDecoder(body).Decode(&entry)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding entry %s - %w", path, err)
+	}
+
+	return &entry, nil
+}
+
+/*
+pollEvents returns the changes after since, or none and the current revision if since is nil, waiting up to timeout
+for at least one
+*/
+func (c *Client) pollEvents(ctx context.Context, path string, since *uint64, timeout time.Duration) ([]cml.Event,
+	uint64, error) {
+	if c.socketPath != "" {
+		return nil, 0, errors.New("events are supported on HTTP servers only")
+	}
+
+	query := url.Values{}
+	query.Set("poll", "true")
+	query.Set("timeout", strconv.FormatUint(uint64(timeout/time.Second), 10))
+	if since != nil {
+		query.Set("sinceRev", strconv.FormatUint(*since, 10))
+	}
+
+	body, err := c.httpRequestCtx(ctx, http.MethodGet, watchPrefix+escapePath(path)+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	defer body.Close()
+
+	var jEvents jsonEvents
+	err = json.NewDecoder(body).Decode(&jEvents)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error decoding events - %w", err)
+	}
+
+	if since == nil {
+		return []cml.Event{}, jEvents.Revision, nil
+	}
+
+	changes := fromJSONChanges(jEvents.Events)
+	events := make([]cml.Event, 0, len(changes))
+	for i, change := range changes {
+		events = append(events, cml.Event{Change: change, Revision: jEvents.Events[i].Revision})
+	}
+
+	return events, jEvents.Revision, nil
+}
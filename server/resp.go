@@ -0,0 +1,779 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	cml "github.com/debevv/camellia"
+)
+
+const (
+	// Maximum length of inline commands and of the headers of multi bulk commands
+	respMaxLine = 64 * 1024
+	// Maximum number of arguments of a command
+	respMaxArgs = 1024 * 1024
+	// Default number of keys returned by SCAN
+	respScanCount = 10
+)
+
+var errRESPProtocol = errors.New("protocol error")
+
+/*
+respArity holds the minimum and maximum (-1 for unbounded) number of arguments of the supported commands
+*/
+var respArity = map[string][2]int{
+	"PING":    {0, 1},
+	"ECHO":    {1, 1},
+	"QUIT":    {0, 0},
+	"AUTH":    {1, 2},
+	"SELECT":  {1, 1},
+	"GET":     {1, 1},
+	"SET":     {2, -1},
+	"DEL":     {1, -1},
+	"EXISTS":  {1, -1},
+	"KEYS":    {1, 1},
+	"SCAN":    {1, -1},
+	"COMMAND": {0, -1},
+	"CLIENT":  {1, -1},
+}
+
+/*
+respConn is a connection of a client speaking RESP
+*/
+type respConn struct {
+	s          *Server
+	conn       net.Conn
+	reader     *bufio.Reader
+	writer     *bufio.Writer
+	credential *Credential
+	principal  string
+	host       string
+}
+
+/*
+ListenAndServeRESP serves the camellia DB currently open on the TCP address addr, speaking a subset of the Redis
+protocol (RESP). See ServeRESP.
+*/
+func ListenAndServeRESP(addr string) error {
+	return ListenAndServeRESPWithOptions(addr, Options{})
+}
+
+/*
+ListenAndServeRESPWithOptions calls ListenAndServeRESP, serving with the specified Options, over TLS if a TLS
+certificate is specified.
+*/
+func ListenAndServeRESPWithOptions(addr string, options Options) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return ServeRESPWithOptions(l, options)
+}
+
+/*
+ServeRESPWithOptions serves the camellia DB currently open on the connections accepted by l (see ServeRESP), with the
+specified Options.
+*/
+func ServeRESPWithOptions(l net.Listener, options Options) error {
+	return NewWithOptions(options).ServeRESP(l)
+}
+
+/*
+ServeRESP serves the camellia DB currently open on the connections accepted by l, each on its own goroutine, speaking
+a subset of the Redis protocol, so that Redis clients and tools can be used with camellia. Keys are the paths of
+values, like "network/hostname".
+
+Supported commands:
+
+GET <key>: returns the value at <key>, or nil if it doesn't exist. Fails with WRONGTYPE for non-value Entries.
+
+SET <key> <value>: sets the value at <key>. Fails with WRONGTYPE for non-value Entries. No option is supported.
+
+DEL <key> [<key>...]: deletes the Entries at the <key>s, and their children, returning the number of deleted Entries.
+
+EXISTS <key> [<key>...]: returns the number of <key>s existing.
+
+KEYS <pattern>: returns the keys of all the values matching the glob-style <pattern> (see the Redis documentation).
+
+SCAN <cursor> [MATCH <pattern>] [COUNT <count>] [TYPE <type>]: iterates over the keys of the values, in path order.
+All the values have type "string".
+
+AUTH [<username>] <password>: authenticates with a Credential, by its Username and Password, or by its Token alone.
+
+PING, ECHO, QUIT, SELECT 0, and the COMMAND and CLIENT SETNAME/SETINFO commands issued by client libraries on
+connection.
+
+With Credentials configured, clients must authenticate with AUTH before any other command. The ACL, the rate limit
+and the maximum request size of the Server are enforced like on HTTP.
+
+Connections are served over TLS if a TLS certificate is specified in the Options of the Server. l is closed when
+ServeRESP returns.
+*/
+func (s *Server) ServeRESP(l net.Listener) error {
+	defer l.Close()
+
+	tlsConfig, err := serverTLSConfig(s.options)
+	if err != nil {
+		return err
+	}
+
+	if !s.trackListener(l, true) {
+		return http.ErrServerClosed
+	}
+
+	defer s.trackListener(l, false)
+
+	if tlsConfig != nil {
+		l = tls.NewListener(l, tlsConfig)
+	}
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return s.acceptError(err)
+		}
+
+		go s.serveRESPConn(conn)
+	}
+}
+
+func (s *Server) serveRESPConn(conn net.Conn) {
+	defer conn.Close()
+
+	if !s.trackConn(conn, true) {
+		return
+	}
+
+	defer s.trackConn(conn, false)
+
+	c := &respConn{
+		s:      s,
+		conn:   conn,
+		reader: bufio.NewReaderSize(conn, respMaxLine),
+		writer: bufio.NewWriter(conn),
+	}
+
+	c.host, _, _ = net.SplitHostPort(conn.RemoteAddr().String())
+
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		err := tlsConn.Handshake()
+		if err != nil {
+			return
+		}
+
+		chains := tlsConn.ConnectionState().VerifiedChains
+		if len(chains) > 0 {
+			c.principal = "cert:" + chains[0][0].Subject.CommonName
+		}
+	}
+
+	for {
+		args, err := c.readCommand()
+		if err != nil {
+			if errors.Is(err, errBodyTooLarge) {
+				atomic.AddUint64(&s.tooLarge, 1)
+			}
+
+			if !errors.Is(err, io.EOF) {
+				c.writeError("ERR " + err.Error())
+				c.writer.Flush()
+			}
+
+			return
+		}
+
+		if len(args) == 0 {
+			continue
+		}
+
+		if !s.startRequest() {
+			c.writer.Flush()
+			return
+		}
+
+		quit := c.handle(strings.ToUpper(string(args[0])), args[1:])
+
+		// Replies to pipelined commands are flushed together
+		if quit || c.reader.Buffered() == 0 {
+			err = c.writer.Flush()
+		}
+
+		s.endRequest()
+		if err != nil || quit {
+			return
+		}
+	}
+}
+
+/*
+readCommand reads a command, either as a RESP array of bulk strings or as an inline command
+*/
+func (c *respConn) readCommand() ([][]byte, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) == 0 || line[0] != '*' {
+		args := [][]byte{}
+		for _, field := range bytes.Fields(line) {
+			args = append(args, append([]byte{}, field...))
+		}
+
+		return args, nil
+	}
+
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n < 0 || n > respMaxArgs {
+		return nil, errRESPProtocol
+	}
+
+	args := make([][]byte, 0, minInt(n, 64))
+	size := int64(0)
+	for i := 0; i < n; i++ {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(line) == 0 || line[0] != '$' {
+			return nil, errRESPProtocol
+		}
+
+		length, err := strconv.Atoi(string(line[1:]))
+		if err != nil || length < 0 {
+			return nil, errRESPProtocol
+		}
+
+		size += int64(length)
+		if size > c.s.maxBodySize() {
+			return nil, errBodyTooLarge
+		}
+
+		arg := make([]byte, length+2)
+		_, err = io.ReadFull(c.reader, arg)
+		if err != nil {
+			return nil, err
+		}
+
+		if !bytes.HasSuffix(arg, []byte("\r\n")) {
+			return nil, errRESPProtocol
+		}
+
+		args = append(args, arg[:length])
+	}
+
+	return args, nil
+}
+
+func (c *respConn) readLine() ([]byte, error) {
+	line, err := c.reader.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return nil, errRESPProtocol
+	} else if err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimRight(line, "\r\n"), nil
+}
+
+/*
+handle runs the command name with args, returning true if the connection must be closed
+*/
+func (c *respConn) handle(name string, args [][]byte) bool {
+	client := c.principal
+	if client == "" {
+		client = c.host
+	}
+
+	if !c.s.allow(client) {
+		c.writeError("ERR " + errRateLimited.Error())
+		return false
+	}
+
+	if len(c.s.options.Credentials) > 0 && c.credential == nil && name != "AUTH" && name != "QUIT" {
+		c.writeError("NOAUTH Authentication required.")
+		return false
+	}
+
+	a, ok := respArity[name]
+	if !ok {
+		c.writeError(fmt.Sprintf("ERR unknown command '%s'", sanitizeRESP(name)))
+		return false
+	}
+
+	if len(args) < a[0] || (a[1] >= 0 && len(args) > a[1]) {
+		c.writeError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(name)))
+		return false
+	}
+
+	switch name {
+	case "PING":
+		if len(args) == 0 {
+			c.writeSimple("PONG")
+		} else {
+			c.writeBulk(string(args[0]))
+		}
+
+	case "ECHO":
+		c.writeBulk(string(args[0]))
+
+	case "QUIT":
+		c.writeSimple("OK")
+		return true
+
+	case "AUTH":
+		c.auth(args)
+
+	case "SELECT":
+		if string(args[0]) != "0" {
+			c.writeError("ERR DB index is out of range")
+		} else {
+			c.writeSimple("OK")
+		}
+
+	case "COMMAND":
+		c.writeArray(nil)
+
+	case "CLIENT":
+		switch strings.ToUpper(string(args[0])) {
+		case "SETNAME", "SETINFO":
+			c.writeSimple("OK")
+		default:
+			c.writeError("ERR unknown subcommand")
+		}
+
+	case "GET":
+		c.get(string(args[0]))
+
+	case "SET":
+		if len(args) > 2 {
+			c.writeError("ERR syntax error")
+		} else {
+			c.set(string(args[0]), string(args[1]))
+		}
+
+	case "DEL":
+		c.del(args)
+
+	case "EXISTS":
+		c.exists(args)
+
+	case "KEYS":
+		c.scan(0, string(args[0]), -1)
+
+	case "SCAN":
+		c.scanCommand(args)
+	}
+
+	return false
+}
+
+func (c *respConn) auth(args [][]byte) {
+	if len(c.s.options.Credentials) == 0 {
+		c.writeError("ERR AUTH called without any password configured")
+		return
+	}
+
+	c.credential = nil
+
+	for i := range c.s.options.Credentials {
+		credential := &c.s.options.Credentials[i]
+
+		if len(args) == 1 && credential.Token != "" && secureEquals(string(args[0]), credential.Token) {
+			c.credential = credential
+			break
+		}
+
+		if len(args) == 2 && credential.Username != "" && secureEquals(string(args[0]), credential.Username) &&
+			secureEquals(string(args[1]), credential.Password) {
+			c.credential = credential
+			break
+		}
+	}
+
+	if c.credential == nil {
+		c.writeError("WRONGPASS invalid username-password pair or user is disabled.")
+		return
+	}
+
+	c.principal = c.credential.principal()
+	c.writeSimple("OK")
+}
+
+/*
+checkAccess verifies that the authenticated Credential, if any, and the ACL allow access to path
+*/
+func (c *respConn) checkAccess(path string, write bool) error {
+	if c.credential != nil && ((write && c.credential.ReadOnly) || !c.credential.allows(&path)) {
+		return errForbidden
+	}
+
+	return c.s.checkAccess(c.principal, path, write)
+}
+
+func (c *respConn) get(path string) {
+	err := c.checkAccess(path, false)
+	if err != nil {
+		c.writeCamelliaError(err)
+		return
+	}
+
+	value, err := cml.Get[string](path)
+	if errors.Is(err, cml.ErrPathNotFound) {
+		c.writeNull()
+		return
+	} else if err != nil {
+		c.writeCamelliaError(err)
+		return
+	}
+
+	c.writeBulk(value)
+}
+
+func (c *respConn) set(path string, value string) {
+	err := c.checkAccess(path, true)
+	if err != nil {
+		c.writeCamelliaError(err)
+		return
+	}
+
+	err = write(context.Background(), c.principal, func(tx *cml.Tx) error {
+		return tx.Set(path, value)
+	})
+	if err != nil {
+		c.writeCamelliaError(err)
+		return
+	}
+
+	c.writeSimple("OK")
+}
+
+func (c *respConn) del(args [][]byte) {
+	for _, arg := range args {
+		err := c.checkAccess(string(arg), true)
+		if err != nil {
+			c.writeCamelliaError(err)
+			return
+		}
+	}
+
+	deleted := 0
+	err := write(context.Background(), c.principal, func(tx *cml.Tx) error {
+		for _, arg := range args {
+			exists, err := tx.Exists(string(arg))
+			if err != nil {
+				return err
+			}
+
+			if !exists {
+				continue
+			}
+
+			err = tx.Delete(string(arg))
+			if err != nil {
+				return err
+			}
+
+			deleted++
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		c.writeCamelliaError(err)
+		return
+	}
+
+	c.writeInt(deleted)
+}
+
+func (c *respConn) exists(args [][]byte) {
+	count := 0
+	for _, arg := range args {
+		err := c.checkAccess(string(arg), false)
+		if err != nil {
+			c.writeCamelliaError(err)
+			return
+		}
+
+		exists, err := cml.Exists(string(arg))
+		if err != nil {
+			c.writeCamelliaError(err)
+			return
+		}
+
+		if exists {
+			count++
+		}
+	}
+
+	c.writeInt(count)
+}
+
+func (c *respConn) scanCommand(args [][]byte) {
+	cursor, err := strconv.Atoi(string(args[0]))
+	if err != nil || cursor < 0 {
+		c.writeError("ERR invalid cursor")
+		return
+	}
+
+	pattern := "*"
+	count := respScanCount
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			c.writeError("ERR syntax error")
+			return
+		}
+
+		value := string(args[i+1])
+
+		switch strings.ToUpper(string(args[i])) {
+		case "MATCH":
+			pattern = value
+		case "COUNT":
+			count, err = strconv.Atoi(value)
+			if err != nil || count < 1 {
+				c.writeError("ERR value is not an integer or out of range")
+				return
+			}
+		case "TYPE":
+			if !strings.EqualFold(value, "string") {
+				pattern = ""
+			}
+		default:
+			c.writeError("ERR syntax error")
+			return
+		}
+	}
+
+	c.scan(cursor, pattern, count)
+}
+
+/*
+scan replies with count (or all, if count < 0) of the keys matching pattern, starting from the cursor-th key, in path
+order. For SCAN, the reply is preceded by the next cursor, which is 0 when the iteration is complete
+*/
+func (c *respConn) scan(cursor int, pattern string, count int) {
+	keys, err := c.keys()
+	if err != nil {
+		c.writeCamelliaError(err)
+		return
+	}
+
+	matching := []string{}
+	next := cursor
+	for ; next < len(keys) && (count < 0 || next < cursor+count); next++ {
+		if pattern != "" && globMatch(pattern, keys[next]) {
+			matching = append(matching, keys[next])
+		}
+	}
+
+	if count < 0 {
+		c.writeArray(matching)
+		return
+	}
+
+	if next >= len(keys) {
+		next = 0
+	}
+
+	c.writer.WriteString("*2\r\n")
+	c.writeBulk(strconv.Itoa(next))
+	c.writeArray(matching)
+}
+
+/*
+keys returns the sorted paths of all the values readable by the client
+*/
+func (c *respConn) keys() ([]string, error) {
+	root, err := cml.GetEntryDepth("", -1)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{}
+
+	var visit func(entry *cml.Entry)
+	visit = func(entry *cml.Entry) {
+		if entry.IsValue {
+			if c.checkAccess(entry.Path, false) == nil {
+				keys = append(keys, entry.Path)
+			}
+
+			return
+		}
+
+		for _, child := range entry.Children {
+			visit(child)
+		}
+	}
+
+	visit(root)
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+func (c *respConn) writeCamelliaError(err error) {
+	switch {
+	case errors.Is(err, errForbidden), errors.Is(err, cml.ErrAccessDenied):
+		c.writeError("NOPERM " + err.Error())
+	case errors.Is(err, cml.ErrPathIsNotAValue):
+		c.writeError("WRONGTYPE " + err.Error())
+	default:
+		c.writeError("ERR " + err.Error())
+	}
+}
+
+func (c *respConn) writeSimple(s string) {
+	c.writer.WriteString("+" + s + "\r\n")
+}
+
+func (c *respConn) writeError(s string) {
+	c.writer.WriteString("-" + sanitizeRESP(s) + "\r\n")
+}
+
+func (c *respConn) writeInt(n int) {
+	c.writer.WriteString(":" + strconv.Itoa(n) + "\r\n")
+}
+
+func (c *respConn) writeBulk(s string) {
+	c.writer.WriteString("$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n")
+}
+
+func (c *respConn) writeNull() {
+	c.writer.WriteString("$-1\r\n")
+}
+
+func (c *respConn) writeArray(items []string) {
+	c.writer.WriteString("*" + strconv.Itoa(len(items)) + "\r\n")
+	for _, item := range items {
+		c.writeBulk(item)
+	}
+}
+
+/*
+sanitizeRESP removes line breaks from s, which can't appear in simple strings and errors
+*/
+func sanitizeRESP(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
+
+/*
+globMatch reports whether s matches the glob-style pattern, with the syntax of Redis: "*" matches any sequence of
+characters (including "/"), "?" any single character, "[abc]", "[^abc]" and "[a-z]" sets of characters, and "\"
+escapes the next character
+*/
+func globMatch(pattern string, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+
+			if len(pattern) == 1 {
+				return true
+			}
+
+			for i := 0; i <= len(s); i++ {
+				if globMatch(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+
+			return false
+
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+
+			pattern = pattern[1:]
+			s = s[1:]
+
+		case '[':
+			end := strings.IndexByte(pattern[1:], ']')
+			if end < 0 {
+				if len(s) == 0 || s[0] != '[' {
+					return false
+				}
+
+				pattern = pattern[1:]
+				s = s[1:]
+				continue
+			}
+
+			if len(s) == 0 {
+				return false
+			}
+
+			class := pattern[1 : end+1]
+			negate := strings.HasPrefix(class, "^")
+			if negate {
+				class = class[1:]
+			}
+
+			matched := false
+			for i := 0; i < len(class); i++ {
+				if i+2 < len(class) && class[i+1] == '-' {
+					lo, hi := class[i], class[i+2]
+					if lo > hi {
+						lo, hi = hi, lo
+					}
+
+					if s[0] >= lo && s[0] <= hi {
+						matched = true
+					}
+
+					i += 2
+				} else if class[i] == s[0] {
+					matched = true
+				}
+			}
+
+			if matched == negate {
+				return false
+			}
+
+			pattern = pattern[end+2:]
+			s = s[1:]
+
+		default:
+			if pattern[0] == '\\' && len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+
+			pattern = pattern[1:]
+			s = s[1:]
+		}
+	}
+
+	return len(s) == 0
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
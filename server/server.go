@@ -0,0 +1,568 @@
+/*
+Package server exposes the camellia DB currently open over HTTP.
+
+Endpoints:
+
+GET /v1/entries/<path>[?depth=<depth>][&structure=true]: returns the Entry at <path> in the extended JSON format,
+including its children up to <depth> (1 by default, -1 for the full hierarchy), with its revision as ETag. With
+structure=true, values are left empty (see camellia.GetStructure). With an If-None-Match
+header carrying that ETag, returns 304 without a body if the Entry is a value not changed since (see
+camellia.GetIfChanged).
+
+PUT /v1/entries/<path>[?force=true]: sets the value at <path> to the request body. With force=true, overwrites
+non-value Entries. With an If-Match header carrying the ETag returned by GET (the revision of the Entry), sets the
+value only if the Entry was not changed in the meantime (see camellia.SetWithRevision), failing with 412 otherwise.
+
+DELETE /v1/entries/<path>: deletes the Entry at <path>, and its children.
+
+GET /v1/export/<path>[?extended=true][&canonical=true][&native=true][&runtime=true][&include=<glob>][&exclude=<glob>]
+[&gzip=true]: exports the hierarchy at <path> in JSON (see camellia.ExportOptions), compressed with gzip with
+gzip=true. include and exclude can be repeated.
+
+POST /v1/import[?extended=true][&merge=true][&native=true][&dry_run=true]: imports the JSON representation in the
+request body (see camellia.ImportOptions), which can be compressed with gzip. With dry_run=true, returns the changes
+that would be applied, without applying them.
+
+GET /v1/watch/<path>[?sinceRev=<revision>][&poll=true][&timeout=<seconds>]: returns the changes to the Entry at
+<path>, and to its children, made after <revision> (by default, the current one), in order (see camellia.GetEvents).
+Changes are JSON objects carrying the revision of the transaction that made them, like
+{"revision": 12, "type": "updated", "path": "a/b", "is_value": true, "old_value": "1", "value": "2"}.
+By default, changes are streamed as server-sent events of type "change", with the revision as event ID, so that
+reconnecting EventSources resume where they left off. With poll=true, the request waits up to <seconds> (30 by
+default) for changes, and returns {"revision": <revision to continue from>, "events": [<changes>]}. Revisions older
+than the change log fail with 410.
+
+GET /v1/metrics: returns the request counters of the server (see Metrics).
+
+GET /v1/info: returns the schema version and revision of the DB, and the hooks registered in the server (see Info).
+
+GET /openapi.json: returns the OpenAPI 3 document describing the endpoints (see OpenAPI), to generate clients.
+
+GET /healthz: probes the DB with camellia.Health, returning 200 if it is healthy and 503 otherwise, along with
+{"status": "ok" or "error", "error": <error>, "revision": <revision>, "writable": <bool>, "wal_size": <bytes>,
+"free_space": <bytes>}. It requires no authentication, to be used as a liveness check by supervisors.
+
+With Options.UI == true, a web UI browsing and editing the hierarchy, and tailing the changes, is served at /ui/.
+
+Errors are returned as a JSON object, like {"error": "path not found"}, with a status code derived from the error.
+
+Requests can be authenticated with bearer tokens or HTTP basic authentication, see Credential.
+
+The same operations are available to local processes through a Unix domain socket, see ServeSocket, and to Redis
+clients through a subset of the Redis protocol, see ServeRESP. Access to the
+socket is controlled by the permissions of the socket file.
+*/
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	cml "github.com/debevv/camellia"
+)
+
+const (
+	entriesPrefix = "/v1/entries/"
+	exportPrefix  = "/v1/export/"
+	importPath    = "/v1/import"
+	metricsPath   = "/v1/metrics"
+)
+
+/*
+Server is an http.Handler serving the camellia DB currently open. The DB must be opened with camellia.Open before
+serving requests.
+*/
+type Server struct {
+	// Accessed atomically, kept first for 64-bit alignment on 32-bit platforms
+	requests    uint64
+	rateLimited uint64
+	tooLarge    uint64
+
+	mux     *http.ServeMux
+	options Options
+	limiter *rateLimiter
+
+	// Listeners, connections and requests being served, tracked for Shutdown
+	mutex       sync.Mutex
+	closing     bool
+	done        chan struct{}
+	listeners   map[net.Listener]struct{}
+	conns       map[net.Conn]struct{}
+	httpServers map[*http.Server]struct{}
+	inFlight    sync.WaitGroup
+}
+
+/*
+Options configures a Server.
+
+Credentials: the credentials accepted by the server. With no Credentials, the server is accessible without
+authentication.
+
+TLSCertFile, TLSKeyFile: the PEM encoded certificate and key used to serve HTTPS. If empty, plain HTTP is served.
+
+TLSClientCAFile: the PEM encoded CAs used to verify client certificates. If set, clients must present a valid
+certificate (mutual TLS).
+
+ACL: the ACL enforced on every request, on behalf of the principal making it (see Credential.Principal and
+Server.ServeSocket). Clients presenting a verified certificate, and no credential, are identified by the
+"cert:<common name>" principal. If nil, no ACL is enforced.
+
+RateLimit, RateBurst: the number of requests per second allowed to each client (identified by its principal, or by
+its IP address), and the number of requests allowed in a burst (by default, RateLimit rounded up). Exceeding requests
+fail with 429. With RateLimit == 0, requests are not limited.
+
+MaxBodySize: the maximum size of request bodies, 16 MiB by default. Larger requests fail with 413.
+
+UI: serves the web UI at /ui/.
+*/
+type Options struct {
+	Credentials     []Credential
+	TLSCertFile     string
+	TLSKeyFile      string
+	TLSClientCAFile string
+	ACL             *cml.ACL
+	RateLimit       float64
+	RateBurst       int
+	MaxBodySize     int64
+	UI              bool
+}
+
+type jsonChange struct {
+	Revision uint64 `json:"revision,omitempty"`
+	Type     string `json:"type"`
+	Path     string `json:"path"`
+	IsValue  bool   `json:"is_value"`
+	OldValue string `json:"old_value,omitempty"`
+	Value    string `json:"value,omitempty"`
+}
+
+/*
+New creates a new Server, with the default Options.
+*/
+func New() *Server {
+	return NewWithOptions(Options{})
+}
+
+/*
+NewWithOptions creates a new Server, with the specified Options.
+*/
+func NewWithOptions(options Options) *Server {
+	s := &Server{
+		mux:         http.NewServeMux(),
+		options:     options,
+		limiter:     newRateLimiter(options.RateLimit, options.RateBurst),
+		done:        make(chan struct{}),
+		listeners:   make(map[net.Listener]struct{}),
+		conns:       make(map[net.Conn]struct{}),
+		httpServers: make(map[*http.Server]struct{}),
+	}
+
+	s.mux.HandleFunc(entriesPrefix, s.handleEntries)
+	s.mux.HandleFunc(exportPrefix, s.handleExport)
+	s.mux.HandleFunc(importPath, s.handleImport)
+	s.mux.HandleFunc(metricsPath, s.handleMetrics)
+	s.mux.HandleFunc(infoPath, s.handleInfo)
+	s.mux.HandleFunc(openAPIPath, s.handleOpenAPI)
+	s.mux.HandleFunc(watchPrefix, s.handleWatch)
+	s.mux.HandleFunc(healthPath, s.handleHealth)
+
+	if options.UI {
+		s.mux.Handle(uiPrefix, uiHandler())
+	}
+
+	return s
+}
+
+/*
+ListenAndServe serves the camellia DB currently open on the TCP address addr, like http.ListenAndServe.
+*/
+func ListenAndServe(addr string) error {
+	return ListenAndServeWithOptions(addr, Options{})
+}
+
+/*
+ListenAndServeWithOptions calls ListenAndServe, serving with the specified Options, over HTTPS if a TLS certificate
+is specified.
+*/
+func ListenAndServeWithOptions(addr string, options Options) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return ServeWithOptions(l, options)
+}
+
+/*
+ServeWithOptions serves the camellia DB currently open on the connections accepted by l, like http.Serve, with the
+specified Options. See Server.Serve.
+*/
+func ServeWithOptions(l net.Listener, options Options) error {
+	return NewWithOptions(options).Serve(l)
+}
+
+/*
+Serve serves the camellia DB currently open over HTTP on the connections accepted by l, over HTTPS if a TLS
+certificate is specified in the Options of the Server. l is closed when Serve returns.
+*/
+func (s *Server) Serve(l net.Listener) error {
+	tlsConfig, err := serverTLSConfig(s.options)
+	if err != nil {
+		l.Close()
+		return err
+	}
+
+	httpServer := &http.Server{
+		Handler:   s,
+		TLSConfig: tlsConfig,
+	}
+
+	if !s.trackHTTPServer(httpServer, true) {
+		l.Close()
+		return http.ErrServerClosed
+	}
+
+	defer s.trackHTTPServer(httpServer, false)
+
+	if tlsConfig != nil {
+		return httpServer.ServeTLS(l, "", "")
+	}
+
+	return httpServer.Serve(l)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.limit(w, r) {
+		return
+	}
+
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) handleEntries(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, entriesPrefix)
+
+	if !s.authorize(w, r, &path, r.Method != http.MethodGet) {
+		return
+	}
+
+	err := s.checkAccess(s.principal(r), path, r.Method != http.MethodGet)
+	if err != nil {
+		writeCamelliaError(w, err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		depth := 1
+		if r.URL.Query().Has("depth") {
+			var err error
+			depth, err = strconv.Atoi(r.URL.Query().Get("depth"))
+			if err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid depth - %w", err))
+				return
+			}
+		}
+
+		revision, ifNoneMatch, err := revisionHeader(r, "If-None-Match")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		// Unchanged values are not sent again, while non-values are, as their revision doesn't cover their children
+		if ifNoneMatch {
+			_, revision, err = cml.GetIfChanged[string](path, revision)
+			if errors.Is(err, cml.ErrNotModified) {
+				w.Header().Set("ETag", fmt.Sprintf("\"%d\"", revision))
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+
+		var entry *cml.Entry
+		if queryFlag(r, "structure") {
+			entry, err = cml.GetStructure(path, depth)
+		} else {
+			entry, err = cml.GetEntryDepth(path, depth)
+		}
+
+		if err != nil {
+			writeCamelliaError(w, err)
+			return
+		}
+
+		w.Header().Set("ETag", fmt.Sprintf("\"%d\"", entry.Revision))
+		writeJSON(w, http.StatusOK, entry)
+
+	case http.MethodPut:
+		revision, ifMatch, err := revisionHeader(r, "If-Match")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		value, ok := s.readBody(w, r)
+		if !ok {
+			return
+		}
+
+		force := queryFlag(r, "force")
+		err = write(r.Context(), s.principal(r), func(tx *cml.Tx) error {
+			if ifMatch {
+				return tx.SetWithRevision(path, string(value), revision)
+			}
+
+			if force {
+				return tx.Force(path, string(value))
+			}
+
+			return tx.Set(path, string(value))
+		})
+
+		if err != nil {
+			writeCamelliaError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+
+	case http.MethodDelete:
+		err := write(r.Context(), s.principal(r), func(tx *cml.Tx) error {
+			return tx.Delete(path)
+		})
+		if err != nil {
+			writeCamelliaError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodPut, http.MethodDelete)
+	}
+}
+
+func (s *Server) handleExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, exportPrefix)
+
+	if !s.authorize(w, r, &path, false) {
+		return
+	}
+
+	err := s.checkAccess(s.principal(r), path, false)
+	if err != nil {
+		writeCamelliaError(w, err)
+		return
+	}
+
+	exists, err := cml.Exists(path)
+	if err != nil {
+		writeCamelliaError(w, err)
+		return
+	}
+
+	if !exists {
+		writeCamelliaError(w, cml.ErrPathNotFound)
+		return
+	}
+
+	// Errors after this point can't change the status code anymore, so the response is just truncated
+	if queryFlag(r, "gzip") {
+		w.Header().Set("Content-Type", "application/gzip")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+
+	cml.ExportJSON(path, w, cml.ExportOptions{
+		Extended:    queryFlag(r, "extended"),
+		Canonical:   queryFlag(r, "canonical"),
+		NativeTypes: queryFlag(r, "native"),
+		Runtime:     queryFlag(r, "runtime"),
+		Include:     r.URL.Query()["include"],
+		Exclude:     r.URL.Query()["exclude"],
+		Gzip:        queryFlag(r, "gzip")})
+}
+
+func (s *Server) handleImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	var path *string
+	if r.URL.Query().Has("path") {
+		p := r.URL.Query().Get("path")
+		path = &p
+	}
+
+	if !s.authorize(w, r, path, !queryFlag(r, "dry_run")) {
+		return
+	}
+
+	options := cml.ImportOptions{
+		Extended:    queryFlag(r, "extended"),
+		OnlyMerge:   queryFlag(r, "merge"),
+		NativeTypes: queryFlag(r, "native"),
+		FireHooks:   queryFlag(r, "fire_hooks")}
+
+	if path != nil {
+		options.Path = *path
+	}
+
+	data, ok := s.readBody(w, r)
+	if !ok {
+		return
+	}
+
+	data, err := gunzip(data, s.maxBodySize())
+	if errors.Is(err, errBodyTooLarge) {
+		atomic.AddUint64(&s.tooLarge, 1)
+		writeError(w, http.StatusRequestEntityTooLarge, err)
+		return
+	}
+
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	principal := s.principal(r)
+	options.Writer = principal
+
+	dryRun := queryFlag(r, "dry_run")
+	changes, err := s.importJSON(data, options, dryRun, principal)
+	if err != nil {
+		writeCamelliaError(w, err)
+		return
+	}
+
+	if dryRun {
+		writeJSON(w, http.StatusOK, toJSONChanges(changes))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+/*
+write runs fn in a transaction bound to ctx, recording principal as the writer of the changed Entries
+*/
+func write(ctx context.Context, principal string, fn func(tx *cml.Tx) error) error {
+	return cml.UpdateCtx(ctx, func(tx *cml.Tx) error {
+		tx.SetWriter(principal)
+		return fn(tx)
+	})
+}
+
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	if !s.authorize(w, r, nil, false) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.Metrics())
+}
+
+/*
+revisionHeader returns the revision in the name header of r (like If-Match), if any, as set by clients from the ETag of
+an Entry
+*/
+func revisionHeader(r *http.Request, name string) (uint64, bool, error) {
+	header := r.Header.Get(name)
+	if header == "" {
+		return 0, false, nil
+	}
+
+	revision, err := strconv.ParseUint(strings.Trim(strings.TrimSpace(header), "\""), 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid %s header %s - %w", name, header, err)
+	}
+
+	return revision, true, nil
+}
+
+func toJSONChanges(changes []cml.Change) []jsonChange {
+	jChanges := make([]jsonChange, 0, len(changes))
+	for _, c := range changes {
+		jChanges = append(jChanges, jsonChange{
+			Type:     c.Type.String(),
+			Path:     c.Path,
+			IsValue:  c.IsValue,
+			OldValue: c.OldValue,
+			Value:    c.Value})
+	}
+
+	return jChanges
+}
+
+func queryFlag(r *http.Request, name string) bool {
+	flag, _ := strconv.ParseBool(r.URL.Query().Get(name))
+	return flag
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func writeMethodNotAllowed(w http.ResponseWriter, methods ...string) {
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+}
+
+/*
+errorStatus returns the HTTP status code matching the camellia error wrapped by err
+*/
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, cml.ErrPathNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, cml.ErrPathInvalid), errors.Is(err, cml.ErrValidationFailed),
+		errors.Is(err, cml.ErrTypeMismatch), errors.Is(err, cml.ErrValueEmpty):
+		return http.StatusBadRequest
+	case errors.Is(err, cml.ErrPathIsNotAValue):
+		return http.StatusConflict
+	case errors.Is(err, cml.ErrAccessDenied):
+		return http.StatusForbidden
+	case errors.Is(err, cml.ErrRevisionCompacted):
+		return http.StatusGone
+	case errors.Is(err, cml.ErrRevisionMismatch):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, cml.ErrNoDB):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, cml.ErrNotModified):
+		return http.StatusNotModified
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeCamelliaError(w http.ResponseWriter, err error) {
+	writeError(w, errorStatus(err), err)
+}
@@ -0,0 +1,1545 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	cml "github.com/debevv/camellia"
+)
+
+func check(err error, t *testing.T) {
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+var testDBPath string
+
+func TestMain(m *testing.M) {
+	testDBFile, err := os.CreateTemp("", "camellia-server")
+	if err != nil {
+		os.Stderr.WriteString("Error creating test DB file")
+		os.Exit(1)
+	}
+
+	testDBPath = testDBFile.Name()
+	testDBFile.Close()
+
+	_, err = cml.Open(testDBPath)
+	if err != nil {
+		os.Exit(1)
+	}
+
+	ret := m.Run()
+
+	err = cml.Close()
+	if err != nil {
+		os.Exit(1)
+	}
+
+	os.Remove(testDBPath)
+
+	os.Exit(ret)
+}
+
+func request(t *testing.T, s *Server, method string, url string, body string) (int, string) {
+	r := httptest.NewRequest(method, url, strings.NewReader(body))
+	w := httptest.NewRecorder()
+
+	s.ServeHTTP(w, r)
+
+	b, err := io.ReadAll(w.Result().Body)
+	check(err, t)
+
+	return w.Result().StatusCode, string(b)
+}
+
+func TestEntries(t *testing.T) {
+	s := New()
+
+	t.Log("Should set and get a value")
+
+	status, _ := request(t, s, http.MethodPut, "/v1/entries/a/b", "v1")
+	if status != http.StatusNoContent {
+		t.FailNow()
+	}
+
+	status, body := request(t, s, http.MethodGet, "/v1/entries/a/b", "")
+	if status != http.StatusOK {
+		t.FailNow()
+	}
+
+	var entry cml.Entry
+	err := json.Unmarshal([]byte(body), &entry)
+	check(err, t)
+	if !entry.IsValue || entry.Value != "v1" {
+		t.FailNow()
+	}
+
+	t.Log("Should get a non-value with its children")
+
+	status, body = request(t, s, http.MethodGet, "/v1/entries/a", "")
+	if status != http.StatusOK {
+		t.FailNow()
+	}
+
+	entry = cml.Entry{}
+	err = json.Unmarshal([]byte(body), &entry)
+	check(err, t)
+	if entry.IsValue || entry.Children["b"] == nil || entry.Children["b"].Value != "v1" {
+		t.FailNow()
+	}
+
+	t.Log("Should fail to set a non-value without forcing")
+
+	status, _ = request(t, s, http.MethodPut, "/v1/entries/a", "v2")
+	if status != http.StatusConflict {
+		t.FailNow()
+	}
+
+	status, _ = request(t, s, http.MethodPut, "/v1/entries/a?force=true", "v2")
+	if status != http.StatusNoContent {
+		t.FailNow()
+	}
+
+	t.Log("Should delete an Entry")
+
+	status, _ = request(t, s, http.MethodDelete, "/v1/entries/a", "")
+	if status != http.StatusNoContent {
+		t.FailNow()
+	}
+
+	status, _ = request(t, s, http.MethodGet, "/v1/entries/a", "")
+	if status != http.StatusNotFound {
+		t.FailNow()
+	}
+
+	status, _ = request(t, s, http.MethodPost, "/v1/entries/a", "")
+	if status != http.StatusMethodNotAllowed {
+		t.FailNow()
+	}
+}
+
+func TestIfMatch(t *testing.T) {
+	s := New()
+
+	put := func(value string, ifMatch string) int {
+		r := httptest.NewRequest(http.MethodPut, "/v1/entries/if-match/a", strings.NewReader(value))
+		r.Header.Set("If-Match", ifMatch)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+
+		return w.Result().StatusCode
+	}
+
+	t.Log("Should set a missing value with revision 0")
+
+	if put("1", `"0"`) != http.StatusNoContent {
+		t.FailNow()
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/entries/if-match/a", nil)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+
+	etag := w.Result().Header.Get("ETag")
+	if etag == "" || etag == `"0"` {
+		t.FailNow()
+	}
+
+	t.Log("Should fail on a stale revision")
+
+	if put("2", `"0"`) != http.StatusPreconditionFailed {
+		t.FailNow()
+	}
+
+	t.Log("Should set the value with the current revision")
+
+	if put("2", etag) != http.StatusNoContent || put("3", etag) != http.StatusPreconditionFailed {
+		t.FailNow()
+	}
+
+	if put("3", "invalid") != http.StatusBadRequest {
+		t.FailNow()
+	}
+}
+
+func TestIfNoneMatch(t *testing.T) {
+	s := New()
+
+	get := func(path string, ifNoneMatch string) (int, string) {
+		r := httptest.NewRequest(http.MethodGet, "/v1/entries/"+path, nil)
+		r.Header.Set("If-None-Match", ifNoneMatch)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+
+		return w.Result().StatusCode, w.Result().Header.Get("ETag")
+	}
+
+	check(cml.Set("if-none-match/a", "1"), t)
+
+	status, etag := get("if-none-match/a", "")
+	if status != http.StatusOK {
+		t.FailNow()
+	}
+
+	t.Log("Should return 304 on an unchanged value")
+
+	status, _ = get("if-none-match/a", etag)
+	if status != http.StatusNotModified {
+		t.Fatalf("Expected 304, got %d", status)
+	}
+
+	t.Log("Should return a changed value")
+
+	check(cml.Set("if-none-match/a", "2"), t)
+
+	status, newETag := get("if-none-match/a", etag)
+	if status != http.StatusOK || newETag == etag {
+		t.Fatalf("Expected 200 with a new ETag, got %d %s", status, newETag)
+	}
+
+	t.Log("Should always return non-value Entries")
+
+	_, etag = get("if-none-match", "")
+	status, _ = get("if-none-match", etag)
+	if status != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", status)
+	}
+}
+
+func TestImportExport(t *testing.T) {
+	s := New()
+
+	t.Log("Should list the changes of a dry run import")
+
+	status, body := request(t, s, http.MethodPost, "/v1/import?dry_run=true", `{"c": {"d": "v1"}}`)
+	if status != http.StatusOK {
+		t.FailNow()
+	}
+
+	var changes []jsonChange
+	err := json.Unmarshal([]byte(body), &changes)
+	check(err, t)
+	if len(changes) == 0 {
+		t.FailNow()
+	}
+
+	exists, err := cml.Exists("c")
+	check(err, t)
+	if exists {
+		t.FailNow()
+	}
+
+	t.Log("Should import and export a hierarchy")
+
+	status, _ = request(t, s, http.MethodPost, "/v1/import", `{"c": {"d": "v1"}}`)
+	if status != http.StatusNoContent {
+		t.FailNow()
+	}
+
+	status, body = request(t, s, http.MethodGet, "/v1/export/c", "")
+	if status != http.StatusOK {
+		t.FailNow()
+	}
+
+	var values map[string]string
+	err = json.Unmarshal([]byte(body), &values)
+	check(err, t)
+	if values["d"] != "v1" {
+		t.FailNow()
+	}
+
+	err = cml.SetRuntime("c/e", "r1")
+	check(err, t)
+
+	status, body = request(t, s, http.MethodGet, "/v1/export/c?runtime=true", "")
+	if status != http.StatusOK {
+		t.FailNow()
+	}
+
+	values = nil
+	err = json.Unmarshal([]byte(body), &values)
+	check(err, t)
+	if values["e"] != "r1" {
+		t.FailNow()
+	}
+
+	status, _ = request(t, s, http.MethodGet, "/v1/export/missing", "")
+	if status != http.StatusNotFound {
+		t.FailNow()
+	}
+}
+
+func TestSocket(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "camellia.sock")
+
+	l, err := net.Listen("unix", socketPath)
+	check(err, t)
+	defer l.Close()
+
+	go ServeSocket(l)
+
+	conn, err := net.Dial("unix", socketPath)
+	check(err, t)
+	defer conn.Close()
+
+	encoder := json.NewEncoder(conn)
+	decoder := json.NewDecoder(conn)
+
+	roundTrip := func(req SocketRequest) SocketResponse {
+		err := encoder.Encode(req)
+		check(err, t)
+
+		var res SocketResponse
+		err = decoder.Decode(&res)
+		check(err, t)
+
+		return res
+	}
+
+	t.Log("Should set and get a value through the socket")
+
+	res := roundTrip(SocketRequest{Op: "set", Path: "socket/a", Value: "v1"})
+	if res.Status != http.StatusOK || res.Error != "" {
+		t.FailNow()
+	}
+
+	res = roundTrip(SocketRequest{Op: "get", Path: "socket/a"})
+	if res.Status != http.StatusOK || res.Value == nil || *res.Value != "v1" {
+		t.FailNow()
+	}
+
+	t.Log("Should report errors with their status code")
+
+	res = roundTrip(SocketRequest{Op: "get", Path: "socket/missing"})
+	if res.Status != http.StatusNotFound || res.Error == "" {
+		t.FailNow()
+	}
+
+	res = roundTrip(SocketRequest{Op: "unknown"})
+	if res.Status != http.StatusBadRequest {
+		t.FailNow()
+	}
+
+	t.Log("Should export a hierarchy through the socket")
+
+	res = roundTrip(SocketRequest{Op: "export", Path: "socket"})
+	if res.Status != http.StatusOK {
+		t.FailNow()
+	}
+
+	var values map[string]string
+	err = json.Unmarshal(res.Data, &values)
+	check(err, t)
+	if values["a"] != "v1" {
+		t.FailNow()
+	}
+}
+
+func testClient(t *testing.T, c *Client) {
+	err := c.Set("client/a", "v1")
+	check(err, t)
+
+	v, err := c.Get("client/a")
+	check(err, t)
+	if v != "v1" {
+		t.FailNow()
+	}
+
+	exists, err := c.Exists("client/missing")
+	check(err, t)
+	if exists {
+		t.FailNow()
+	}
+
+	_, err = c.Get("client/missing")
+	if !errors.Is(err, cml.ErrPathNotFound) {
+		t.FailNow()
+	}
+
+	err = c.Set("client", "v2")
+	if !errors.Is(err, cml.ErrPathIsNotAValue) {
+		t.FailNow()
+	}
+
+	changes, err := c.DryRunImportJSON(strings.NewReader(`{"client": {"b": "v2"}}`), cml.ImportOptions{})
+	check(err, t)
+	if len(changes) != 1 || changes[0].Type != cml.ChangeCreated || changes[0].Path != "client/b" {
+		t.FailNow()
+	}
+
+	err = c.ImportJSON(strings.NewReader(`{"client": {"b": "v2"}}`), cml.ImportOptions{})
+	check(err, t)
+
+	w := strings.Builder{}
+	err = c.ExportJSON("client", &w, cml.ExportOptions{})
+	check(err, t)
+
+	var values map[string]string
+	err = json.Unmarshal([]byte(w.String()), &values)
+	check(err, t)
+	if values["a"] != "v1" || values["b"] != "v2" {
+		t.FailNow()
+	}
+
+	w.Reset()
+	err = c.ExportJSON("client", &w, cml.ExportOptions{Exclude: []string{"b"}})
+	check(err, t)
+
+	values = nil
+	err = json.Unmarshal([]byte(w.String()), &values)
+	check(err, t)
+	if len(values) != 1 || values["a"] != "v1" {
+		t.FailNow()
+	}
+
+	compressed := bytes.Buffer{}
+	err = c.ExportJSON("client", &compressed, cml.ExportOptions{Gzip: true})
+	check(err, t)
+
+	err = c.ImportJSON(&compressed, cml.ImportOptions{Path: "copy"})
+	check(err, t)
+
+	v, err = c.Get("copy/b")
+	check(err, t)
+	if v != "v2" {
+		t.FailNow()
+	}
+
+	err = c.Delete("client")
+	check(err, t)
+
+	exists, err = c.Exists("client")
+	check(err, t)
+	if exists {
+		t.FailNow()
+	}
+}
+
+func TestClient(t *testing.T) {
+	t.Log("Should operate on a remote DB over HTTP")
+
+	httpServer := httptest.NewServer(New())
+	defer httpServer.Close()
+
+	c, err := NewClient(httpServer.URL)
+	check(err, t)
+
+	testClient(t, c)
+
+	t.Log("Should operate on a remote DB over a Unix domain socket")
+
+	socketPath := filepath.Join(t.TempDir(), "camellia.sock")
+
+	l, err := net.Listen("unix", socketPath)
+	check(err, t)
+	defer l.Close()
+
+	go ServeSocket(l)
+
+	c, err = NewClient(socketPath)
+	check(err, t)
+
+	testClient(t, c)
+}
+
+func requestAuth(t *testing.T, s *Server, method string, url string, setAuth func(r *http.Request)) int {
+	r := httptest.NewRequest(method, url, strings.NewReader("v"))
+	setAuth(r)
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+
+	return w.Result().StatusCode
+}
+
+func TestAuth(t *testing.T) {
+	s := NewWithOptions(Options{Credentials: []Credential{
+		{Username: "admin", Password: "secret"},
+		{Token: "reader", Prefixes: []string{"auth/public"}, ReadOnly: true},
+		{Token: "writer", Prefixes: []string{"auth/public"}},
+	}})
+
+	noAuth := func(r *http.Request) {}
+	admin := func(r *http.Request) { r.SetBasicAuth("admin", "secret") }
+	wrongPassword := func(r *http.Request) { r.SetBasicAuth("admin", "wrong") }
+	reader := func(r *http.Request) { r.Header.Set("Authorization", "Bearer reader") }
+	writer := func(r *http.Request) { r.Header.Set("Authorization", "Bearer writer") }
+
+	t.Log("Should reject requests without valid credentials")
+
+	if requestAuth(t, s, http.MethodGet, "/v1/entries/auth", noAuth) != http.StatusUnauthorized {
+		t.FailNow()
+	}
+
+	if requestAuth(t, s, http.MethodGet, "/v1/entries/auth", wrongPassword) != http.StatusUnauthorized {
+		t.FailNow()
+	}
+
+	t.Log("Should allow unscoped credentials everywhere")
+
+	if requestAuth(t, s, http.MethodPut, "/v1/entries/auth/public/a", admin) != http.StatusNoContent {
+		t.FailNow()
+	}
+
+	if requestAuth(t, s, http.MethodPut, "/v1/entries/auth/private/a", admin) != http.StatusNoContent {
+		t.FailNow()
+	}
+
+	t.Log("Should limit scoped credentials to their prefixes and permissions")
+
+	if requestAuth(t, s, http.MethodGet, "/v1/entries/auth/public/a", reader) != http.StatusOK {
+		t.FailNow()
+	}
+
+	if requestAuth(t, s, http.MethodPut, "/v1/entries/auth/public/a", reader) != http.StatusForbidden {
+		t.FailNow()
+	}
+
+	if requestAuth(t, s, http.MethodPut, "/v1/entries/auth/public/b", writer) != http.StatusNoContent {
+		t.FailNow()
+	}
+
+	if requestAuth(t, s, http.MethodGet, "/v1/entries/auth/private/a", writer) != http.StatusForbidden {
+		t.FailNow()
+	}
+
+	if requestAuth(t, s, http.MethodGet, "/v1/entries/auth/publicity", writer) != http.StatusForbidden {
+		t.FailNow()
+	}
+
+	if requestAuth(t, s, http.MethodPost, "/v1/import", writer) != http.StatusForbidden {
+		t.FailNow()
+	}
+}
+
+/*
+openWithPathRules reopens the test DB with rules, restoring the default ones at the end of the test
+*/
+func openWithPathRules(t *testing.T, rules cml.PathRules) {
+	err := cml.Close()
+	check(err, t)
+
+	t.Cleanup(func() {
+		cml.Close()
+		cml.Open(testDBPath)
+	})
+
+	_, err = cml.OpenWithOptions(testDBPath, cml.Options{PathRules: rules})
+	check(err, t)
+}
+
+func TestAuthPathRules(t *testing.T) {
+	openWithPathRules(t, cml.PathRules{DotSegments: cml.DotSegmentsResolve, TrimSpace: true})
+
+	s := NewWithOptions(Options{Credentials: []Credential{{Token: "network", Prefixes: []string{"network"}}}})
+	network := func(r *http.Request) { r.Header.Set("Authorization", "Bearer network") }
+
+	err := cml.Set("secrets/key", "secret")
+	check(err, t)
+
+	t.Log("Should check prefixes against paths normalized like the DB does")
+
+	if requestAuth(t, s, http.MethodGet, "/v1/entries/network/%20../secrets/key", network) != http.StatusForbidden {
+		t.FailNow()
+	}
+
+	if requestAuth(t, s, http.MethodPut, "/v1/entries/network/%20../secrets/key", network) != http.StatusForbidden {
+		t.FailNow()
+	}
+
+	if requestAuth(t, s, http.MethodPut, "/v1/entries/network/%20b%20", network) != http.StatusNoContent {
+		t.FailNow()
+	}
+
+	value, err := cml.Get[string]("secrets/key")
+	check(err, t)
+	if value != "secret" {
+		t.FailNow()
+	}
+
+	value, err = cml.Get[string]("network/b")
+	check(err, t)
+	if value != "v" {
+		t.FailNow()
+	}
+}
+
+/*
+writeCert generates a certificate for localhost, signed by parent (or self-signed, if nil), writing it and its key
+to PEM files in dir
+*/
+func writeCert(t *testing.T, dir string, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey,
+	isCA bool) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	check(err, t)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+
+	if parent == nil {
+		parent = template
+		parentKey = key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	check(err, t)
+
+	cert, err := x509.ParseCertificate(der)
+	check(err, t)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	check(err, t)
+
+	err = os.WriteFile(filepath.Join(dir, name+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		0600)
+	check(err, t)
+
+	err = os.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY",
+		Bytes: keyDer}), 0600)
+	check(err, t)
+
+	return cert, key
+}
+
+func TestTLS(t *testing.T) {
+	dir := t.TempDir()
+	file := func(name string) string {
+		return filepath.Join(dir, name)
+	}
+
+	ca, caKey := writeCert(t, dir, "ca", nil, nil, true)
+	writeCert(t, dir, "server", ca, caKey, false)
+	writeCert(t, dir, "client", ca, caKey, false)
+
+	tlsConfig, err := serverTLSConfig(Options{
+		TLSCertFile:     file("server.crt"),
+		TLSKeyFile:      file("server.key"),
+		TLSClientCAFile: file("ca.crt")})
+	check(err, t)
+
+	httpServer := httptest.NewUnstartedServer(New())
+	httpServer.TLS = tlsConfig
+	httpServer.StartTLS()
+	defer httpServer.Close()
+
+	t.Log("Should connect to the server with a client certificate")
+
+	c, err := NewClient(httpServer.URL)
+	check(err, t)
+
+	clientConfig, err := ClientTLSConfig(file("ca.crt"), file("client.crt"), file("client.key"))
+	check(err, t)
+	c.SetTLSConfig(clientConfig)
+
+	err = c.Set("tls/a", "v1")
+	check(err, t)
+
+	t.Log("Should fail to connect without a client certificate")
+
+	clientConfig, err = ClientTLSConfig(file("ca.crt"), "", "")
+	check(err, t)
+	c.SetTLSConfig(clientConfig)
+
+	_, err = c.Get("tls/a")
+	if err == nil {
+		t.FailNow()
+	}
+}
+
+func TestACL(t *testing.T) {
+	acl, err := cml.LoadACL(strings.NewReader(`[
+		{ "principal": "writer", "path": "acl/public", "read": true, "write": true },
+		{ "principal": "*", "path": "acl/public", "read": true }
+	]`))
+	check(err, t)
+
+	s := NewWithOptions(Options{
+		Credentials: []Credential{{Token: "writer-token", Principal: "writer"}, {Token: "reader-token"}},
+		ACL:         acl})
+
+	writer := func(r *http.Request) { r.Header.Set("Authorization", "Bearer writer-token") }
+	reader := func(r *http.Request) { r.Header.Set("Authorization", "Bearer reader-token") }
+
+	t.Log("Should enforce the ACL on behalf of the principal of the credential")
+
+	if requestAuth(t, s, http.MethodPut, "/v1/entries/acl/public/a", writer) != http.StatusNoContent {
+		t.FailNow()
+	}
+
+	if requestAuth(t, s, http.MethodPut, "/v1/entries/acl/private/a", writer) != http.StatusForbidden {
+		t.FailNow()
+	}
+
+	if requestAuth(t, s, http.MethodGet, "/v1/entries/acl/public/a", reader) != http.StatusOK {
+		t.FailNow()
+	}
+
+	if requestAuth(t, s, http.MethodPut, "/v1/entries/acl/public/a", reader) != http.StatusForbidden {
+		t.FailNow()
+	}
+
+	t.Log("Should check every path changed by an import")
+
+	r := httptest.NewRequest(http.MethodPost, "/v1/import", strings.NewReader(`{"acl": {"private": {"b": "v"}}}`))
+	writer(r)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Result().StatusCode != http.StatusForbidden {
+		t.FailNow()
+	}
+
+	t.Log("Should enforce the ACL on the socket")
+
+	socketPath := filepath.Join(t.TempDir(), "camellia.sock")
+
+	l, err := net.Listen("unix", socketPath)
+	check(err, t)
+	defer l.Close()
+
+	go s.ServeSocket(l)
+
+	c, err := NewClient(socketPath)
+	check(err, t)
+
+	err = c.Set("acl/public/a", "v")
+	if !errors.Is(err, cml.ErrAccessDenied) {
+		t.FailNow()
+	}
+
+	_, err = c.Get("acl/public/a")
+	check(err, t)
+}
+
+func TestWriter(t *testing.T) {
+	s := NewWithOptions(Options{Credentials: []Credential{{Token: "token", Principal: "updater"}}})
+
+	t.Log("Should record the principal making the request as the writer")
+
+	status := requestAuth(t, s, http.MethodPut, "/v1/entries/writer/a", func(r *http.Request) {
+		r.Header.Set("Authorization", "Bearer token")
+	})
+	if status != http.StatusNoContent {
+		t.FailNow()
+	}
+
+	entry, err := cml.GetEntry("writer/a")
+	check(err, t)
+	if entry.Writer != "updater" {
+		t.FailNow()
+	}
+}
+
+func TestLimits(t *testing.T) {
+	s := NewWithOptions(Options{RateLimit: 0.001, RateBurst: 2, MaxBodySize: 4})
+
+	t.Log("Should reject requests exceeding the rate limit")
+
+	for i := 0; i < 2; i++ {
+		status, _ := request(t, s, http.MethodPut, "/v1/entries/limits/a", "v")
+		if status != http.StatusNoContent {
+			t.FailNow()
+		}
+	}
+
+	status, _ := request(t, s, http.MethodGet, "/v1/entries/limits/a", "")
+	if status != http.StatusTooManyRequests {
+		t.FailNow()
+	}
+
+	t.Log("Should reject requests exceeding the maximum body size")
+
+	s = NewWithOptions(Options{MaxBodySize: 4})
+
+	status, _ = request(t, s, http.MethodPut, "/v1/entries/limits/a", "12345")
+	if status != http.StatusRequestEntityTooLarge {
+		t.FailNow()
+	}
+
+	status, _ = request(t, s, http.MethodPut, "/v1/entries/limits/a", "1234")
+	if status != http.StatusNoContent {
+		t.FailNow()
+	}
+
+	metrics := s.Metrics()
+	if metrics.Requests != 2 || metrics.TooLarge != 1 || metrics.RateLimited != 0 {
+		t.FailNow()
+	}
+
+	status, body := request(t, s, http.MethodGet, "/v1/metrics", "")
+	if status != http.StatusOK {
+		t.FailNow()
+	}
+
+	err := json.Unmarshal([]byte(body), &metrics)
+	check(err, t)
+	if metrics.Requests != 3 {
+		t.FailNow()
+	}
+}
+
+func TestInfo(t *testing.T) {
+	defer cml.Wipe()
+
+	check(cml.SetPreSetHook("info/a", func(path, value string) error {
+		return nil
+	}), t)
+
+	check(cml.Set("info/a", "1"), t)
+
+	ts := httptest.NewServer(New())
+	defer ts.Close()
+
+	c, err := NewClient(ts.URL)
+	check(err, t)
+
+	t.Log("Should describe the DB and the hooks registered")
+
+	info, err := c.Info()
+	check(err, t)
+
+	if info.SchemaVersion != cml.GetSupportedDBSchemaVersion() || info.Revision == 0 || !info.HooksEnabled {
+		t.Fatalf("Unexpected info %v", info)
+	}
+
+	if len(info.Hooks) != 1 || info.Hooks[0].Path != "info/a" || info.Hooks[0].Invocations != 1 {
+		t.Fatalf("Unexpected hooks %v", info.Hooks)
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	s := New()
+
+	status, body := request(t, s, http.MethodGet, "/openapi.json", "")
+	if status != http.StatusOK || body != string(OpenAPI) {
+		t.FailNow()
+	}
+
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+
+	err := json.Unmarshal(OpenAPI, &spec)
+	check(err, t)
+
+	t.Log("Should document every endpoint")
+
+	endpoints := []string{entriesPrefix + "{path}", exportPrefix + "{path}", importPath, watchPrefix + "{path}",
+		metricsPath, infoPath, openAPIPath, healthPath}
+	if len(spec.Paths) != len(endpoints) {
+		t.FailNow()
+	}
+
+	for _, endpoint := range endpoints {
+		if spec.Paths[endpoint] == nil {
+			t.Fatalf("%s not documented", endpoint)
+		}
+	}
+
+	t.Log("Should document exactly the methods accepted by every endpoint")
+
+	for endpoint, operations := range spec.Paths {
+		url := strings.Replace(endpoint, "{path}", "openapi/a", 1)
+
+		r := httptest.NewRequest(http.MethodPatch, url, nil)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+
+		if w.Result().StatusCode != http.StatusMethodNotAllowed {
+			t.Fatalf("%s not served", endpoint)
+		}
+
+		allowed := strings.Split(w.Result().Header.Get("Allow"), ", ")
+		documented := 0
+		for method := range operations {
+			if method != "parameters" {
+				documented++
+			}
+		}
+
+		if len(allowed) != documented {
+			t.Fatalf("%s: methods %v, documented %d", endpoint, allowed, documented)
+		}
+
+		for _, method := range allowed {
+			if operations[strings.ToLower(method)] == nil {
+				t.Fatalf("%s: %s not documented", endpoint, method)
+			}
+		}
+	}
+}
+
+func TestHealth(t *testing.T) {
+	s := NewWithOptions(Options{Credentials: []Credential{{Token: "secret"}}})
+
+	t.Log("Should report a healthy DB without authentication")
+
+	status, body := request(t, s, http.MethodGet, healthPath, "")
+	if status != http.StatusOK {
+		t.FailNow()
+	}
+
+	var health jsonHealth
+	err := json.Unmarshal([]byte(body), &health)
+	check(err, t)
+
+	if health.Status != "ok" || health.Error != "" || !health.Writable {
+		t.FailNow()
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	s := New()
+
+	httpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	check(err, t)
+
+	socketListener, err := net.Listen("unix", filepath.Join(t.TempDir(), "camellia.sock"))
+	check(err, t)
+
+	errs := make(chan error, 2)
+	go func() {
+		errs <- s.Serve(httpListener)
+	}()
+
+	go func() {
+		errs <- s.ServeSocket(socketListener)
+	}()
+
+	t.Log("Should end watch requests")
+
+	watch := make(chan int)
+	go func() {
+		res, err := http.Get("http://" + httpListener.Addr().String() + "/v1/watch/shutdown?poll=true")
+		if err != nil {
+			watch <- 0
+			return
+		}
+
+		res.Body.Close()
+		watch <- res.StatusCode
+	}()
+
+	conn, err := net.Dial("unix", socketListener.Addr().String())
+	check(err, t)
+	defer conn.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	err = s.Shutdown(context.Background())
+	check(err, t)
+
+	if <-watch != http.StatusOK {
+		t.FailNow()
+	}
+
+	t.Log("Should stop serving")
+
+	for i := 0; i < 2; i++ {
+		if !errors.Is(<-errs, http.ErrServerClosed) {
+			t.FailNow()
+		}
+	}
+
+	t.Log("Should close the connections")
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	if !errors.Is(err, io.EOF) {
+		t.FailNow()
+	}
+}
+
+func TestWatch(t *testing.T) {
+	ts := httptest.NewServer(New())
+	defer ts.Close()
+
+	t.Log("Should stream the changes under the watched path")
+
+	res, err := http.Get(ts.URL + "/v1/watch/watch")
+	check(err, t)
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "text/event-stream" {
+		t.FailNow()
+	}
+
+	err = cml.Set("unwatched/a", "1")
+	check(err, t)
+
+	err = cml.Set("watch/a", "1")
+	check(err, t)
+
+	events := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			events <- scanner.Text()
+		}
+
+		close(events)
+	}()
+
+events:
+	for {
+		select {
+		case event := <-events:
+			if strings.Contains(event, "unwatched") {
+				t.Fatalf("unexpected event line %s", event)
+			}
+
+			if strings.HasPrefix(event, `data: {"revision":`) &&
+				strings.HasSuffix(event, `"type":"created","path":"watch/a","is_value":true,"value":"1"}`) {
+				break events
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for change")
+		}
+	}
+
+	t.Log("Should return the changes after a revision")
+
+	poll := func(url string) jsonEvents {
+		status, body := request(t, New(), http.MethodGet, url, "")
+		if status != http.StatusOK {
+			t.Fatalf("unexpected status %d", status)
+		}
+
+		var events jsonEvents
+		err := json.Unmarshal([]byte(body), &events)
+		check(err, t)
+
+		return events
+	}
+
+	polled := poll("/v1/watch/watch?poll=true&sinceRev=0")
+	if len(polled.Events) != 2 || polled.Events[1].Path != "watch/a" || polled.Events[1].Revision == 0 ||
+		polled.Revision < polled.Events[1].Revision {
+		t.FailNow()
+	}
+
+	polled = poll("/v1/watch/watch?poll=true&timeout=0&sinceRev=" + strconv.FormatUint(polled.Revision, 10))
+	if len(polled.Events) != 0 {
+		t.FailNow()
+	}
+
+	t.Log("Should wait for changes")
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cml.Set("watch/a", "2")
+	}()
+
+	polled = poll("/v1/watch/watch?poll=true&timeout=5&sinceRev=" + strconv.FormatUint(polled.Revision, 10))
+	if len(polled.Events) != 1 || polled.Events[0].Type != "updated" || polled.Events[0].Value != "2" {
+		t.FailNow()
+	}
+
+	status, _ := request(t, New(), http.MethodGet, "/v1/watch/watch?sinceRev=x", "")
+	if status != http.StatusBadRequest {
+		t.FailNow()
+	}
+}
+
+func TestWatchACL(t *testing.T) {
+	acl, err := cml.LoadACL(strings.NewReader(`[{ "principal": "reader", "path": "watchacl/a/b", "read": true }]`))
+	check(err, t)
+
+	s := NewWithOptions(Options{Credentials: []Credential{{Token: "reader-token", Principal: "reader"}}, ACL: acl})
+
+	err = cml.Set("watchacl/a/b", "1")
+	check(err, t)
+
+	revision, err := cml.GetRevision()
+	check(err, t)
+
+	err = cml.Force("watchacl/a", "secret")
+	check(err, t)
+
+	t.Log("Should report the changes to unreadable ancestors without their values")
+
+	r := httptest.NewRequest(http.MethodGet,
+		"/v1/watch/watchacl/a/b?poll=true&sinceRev="+strconv.FormatUint(revision, 10), nil)
+	r.Header.Set("Authorization", "Bearer reader-token")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+
+	if w.Result().StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status %d", w.Result().StatusCode)
+	}
+
+	var polled jsonEvents
+	err = json.NewDecoder(w.Result().Body).Decode(&polled)
+	check(err, t)
+
+	if len(polled.Events) != 1 || polled.Events[0].Path != "watchacl/a" || polled.Events[0].Type != "overwritten" ||
+		polled.Events[0].Value != "" || polled.Events[0].OldValue != "" {
+		t.Fatalf("unexpected events %+v", polled.Events)
+	}
+}
+
+func TestUI(t *testing.T) {
+	t.Log("Should serve the UI only if enabled")
+
+	status, _ := request(t, New(), http.MethodGet, "/ui/", "")
+	if status != http.StatusNotFound {
+		t.FailNow()
+	}
+
+	status, body := request(t, NewWithOptions(Options{UI: true}), http.MethodGet, "/ui/", "")
+	if status != http.StatusOK || !strings.Contains(body, "<title>camellia</title>") {
+		t.FailNow()
+	}
+}
+
+/*
+readRESP reads a RESP reply, returning it in a compact textual form, like "+OK", "$value", "$nil", ":1" or
+"[$a $b]" for arrays
+*/
+func readRESP(t *testing.T, reader *bufio.Reader) string {
+	line, err := reader.ReadString('\n')
+	check(err, t)
+	line = strings.TrimSuffix(line, "\r\n")
+
+	switch line[0] {
+	case '$':
+		if line == "$-1" {
+			return "$nil"
+		}
+
+		length, err := strconv.Atoi(line[1:])
+		check(err, t)
+
+		data := make([]byte, length+2)
+		_, err = io.ReadFull(reader, data)
+		check(err, t)
+
+		return "$" + string(data[:length])
+
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		check(err, t)
+
+		items := []string{}
+		for i := 0; i < n; i++ {
+			items = append(items, readRESP(t, reader))
+		}
+
+		return "[" + strings.Join(items, " ") + "]"
+
+	default:
+		return line
+	}
+}
+
+/*
+serveRESP serves s over RESP on a local port, returning a connection to it and a function sending a command over the
+connection and returning the reply, as formatted by readRESP
+*/
+func serveRESP(t *testing.T, s *Server) (net.Conn, *bufio.Reader, func(args ...string) string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	check(err, t)
+	t.Cleanup(func() { l.Close() })
+
+	go s.ServeRESP(l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	check(err, t)
+	t.Cleanup(func() { conn.Close() })
+
+	reader := bufio.NewReader(conn)
+
+	command := func(args ...string) string {
+		cmd := "*" + strconv.Itoa(len(args)) + "\r\n"
+		for _, arg := range args {
+			cmd += "$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n"
+		}
+
+		_, err := conn.Write([]byte(cmd))
+		check(err, t)
+
+		return readRESP(t, reader)
+	}
+
+	return conn, reader, command
+}
+
+func TestRESP(t *testing.T) {
+	conn, reader, command := serveRESP(t, NewWithOptions(Options{Credentials: []Credential{{Token: "secret",
+		Prefixes: []string{"resp"}}}}))
+
+	t.Log("Should require authentication")
+
+	if !strings.HasPrefix(command("GET", "resp/a"), "-NOAUTH") {
+		t.FailNow()
+	}
+
+	if !strings.HasPrefix(command("AUTH", "wrong"), "-WRONGPASS") {
+		t.FailNow()
+	}
+
+	if command("AUTH", "secret") != "+OK" {
+		t.FailNow()
+	}
+
+	t.Log("Should set, get and delete values")
+
+	if command("SET", "resp/a/b", "v1") != "+OK" || command("SET", "resp/a/c", "v2") != "+OK" ||
+		command("SET", "resp/d", "v3") != "+OK" {
+		t.FailNow()
+	}
+
+	if command("GET", "resp/a/b") != "$v1" || command("GET", "resp/missing") != "$nil" {
+		t.FailNow()
+	}
+
+	if !strings.HasPrefix(command("GET", "resp/a"), "-WRONGTYPE") {
+		t.FailNow()
+	}
+
+	if command("EXISTS", "resp/a/b", "resp/missing", "resp/d") != ":2" {
+		t.FailNow()
+	}
+
+	t.Log("Should list keys matching a pattern")
+
+	if command("KEYS", "resp/a/*") != "[$resp/a/b $resp/a/c]" || command("KEYS", "resp/?") != "[$resp/d]" {
+		t.FailNow()
+	}
+
+	if command("SCAN", "0", "COUNT", "2") != "[$2 [$resp/a/b $resp/a/c]]" ||
+		command("SCAN", "2", "COUNT", "2") != "[$0 [$resp/d]]" {
+		t.FailNow()
+	}
+
+	t.Log("Should enforce the prefixes of the credential")
+
+	if !strings.HasPrefix(command("SET", "other", "v"), "-NOPERM") {
+		t.FailNow()
+	}
+
+	if command("DEL", "resp/a", "resp/missing") != ":1" || command("KEYS", "*") != "[$resp/d]" {
+		t.FailNow()
+	}
+
+	t.Log("Should accept inline commands")
+
+	_, err := conn.Write([]byte("PING\r\n"))
+	check(err, t)
+	if readRESP(t, reader) != "+PONG" {
+		t.FailNow()
+	}
+}
+
+func TestRESPProtocolErrors(t *testing.T) {
+	conn, reader, _ := serveRESP(t, New())
+
+	t.Log("Should reject negative array counts, and keep serving")
+
+	_, err := conn.Write([]byte("*-1\r\n"))
+	check(err, t)
+	if !strings.HasPrefix(readRESP(t, reader), "-ERR") {
+		t.FailNow()
+	}
+
+	conn, err = net.Dial("tcp", conn.RemoteAddr().String())
+	check(err, t)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("PING\r\n"))
+	check(err, t)
+	if readRESP(t, bufio.NewReader(conn)) != "+PONG" {
+		t.FailNow()
+	}
+}
+
+func TestRESPPathRules(t *testing.T) {
+	openWithPathRules(t, cml.PathRules{DotSegments: cml.DotSegmentsResolve, TrimSpace: true})
+
+	_, _, command := serveRESP(t, NewWithOptions(Options{Credentials: []Credential{{Token: "network",
+		Prefixes: []string{"network"}}}}))
+
+	err := cml.Set("secrets/key", "secret")
+	check(err, t)
+
+	if command("AUTH", "network") != "+OK" {
+		t.FailNow()
+	}
+
+	t.Log("Should check prefixes against paths normalized like the DB does")
+
+	if !strings.HasPrefix(command("GET", "network/../secrets/key"), "-NOPERM") ||
+		!strings.HasPrefix(command("SET", "network/ ../secrets/key", "v"), "-NOPERM") {
+		t.FailNow()
+	}
+
+	if command("SET", "network/./a/../b", "v") != "+OK" || command("GET", "network/b") != "$v" {
+		t.FailNow()
+	}
+
+	value, err := cml.Get[string]("secrets/key")
+	check(err, t)
+	if value != "secret" {
+		t.FailNow()
+	}
+}
+
+func TestGlobMatch(t *testing.T) {
+	matches := map[[2]string]bool{
+		{"*", "a/b"}:          true,
+		{"a/*", "a/b/c"}:      true,
+		{"a/?", "a/bc"}:       false,
+		{"a/[bc]", "a/c"}:     true,
+		{"a/[^bc]", "a/c"}:    false,
+		{"a/[a-z]x", "a/qx"}:  true,
+		{"a\\*", "a*"}:        true,
+		{"a\\*", "ab"}:        false,
+		{"*b*d", "abcd"}:      true,
+		{"*b*d", "abcde"}:     false,
+		{"network/*", "net"}:  false,
+		{"[", "["}:            true,
+		{"a/**/c", "a/x/y/c"}: true,
+	}
+
+	for c, expected := range matches {
+		if globMatch(c[0], c[1]) != expected {
+			t.Fatalf("globMatch(%s, %s) != %v", c[0], c[1], expected)
+		}
+	}
+}
+
+func TestReplicate(t *testing.T) {
+	defer cml.Delete("primary")
+	defer cml.Delete("replica")
+
+	check(cml.Set("primary/a", 1), t)
+	check(cml.Set("primary/b/c", "x"), t)
+	check(cml.Set("replica/stale", "s"), t)
+
+	// The replica and the primary share the DB of the test, so the hierarchy at "primary" is served as if it was at
+	// "replica"
+	s := New()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.URL.Path = strings.Replace(r.URL.Path, "/replica", "/primary", 1)
+
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, r)
+
+		body := rec.Body.String()
+		if strings.HasPrefix(r.URL.Path, watchPrefix) {
+			body = strings.ReplaceAll(body, `"path":"primary`, `"path":"replica`)
+		}
+
+		w.WriteHeader(rec.Code)
+		w.Write([]byte(body))
+	}))
+	defer ts.Close()
+
+	client, err := NewClient(ts.URL)
+	check(err, t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Replicate(ctx, ReplicateOptions{Path: "replica", PollTimeout: time.Second})
+	}()
+
+	waitFor := func(what string, cond func() bool) {
+		for i := 0; i < 300; i++ {
+			if cond() {
+				return
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		t.Fatalf("Timeout waiting for %s", what)
+	}
+
+	t.Log("Should copy the hierarchy in full, with the types of the values")
+
+	waitFor("full copy", func() bool {
+		entry, err := cml.GetEntry("replica/a")
+		return err == nil && entry.Value == "1" && entry.Type == cml.TypeInt
+	})
+
+	exists, err := cml.Exists("replica/stale")
+	check(err, t)
+	if exists {
+		t.Fatal("Expected stale entry to be deleted")
+	}
+
+	t.Log("Should apply the changes made on the primary")
+
+	check(cml.Set("primary/b/d", "y"), t)
+	waitFor("new value", func() bool {
+		value, err := cml.Get[string]("replica/b/d")
+		return err == nil && value == "y"
+	})
+
+	check(cml.Delete("primary/b"), t)
+	waitFor("deletion", func() bool {
+		exists, err := cml.Exists("replica/b")
+		return err == nil && !exists
+	})
+
+	t.Log("Should record the revision of the primary reached")
+
+	revision, err := cml.GetReplicatedRevision()
+	check(err, t)
+
+	current, err := cml.GetRevision()
+	check(err, t)
+
+	if revision == 0 || revision > current {
+		t.Fatalf("Unexpected replicated revision %d (current %d)", revision, current)
+	}
+
+	t.Log("Should stop when the context is done")
+
+	cancel()
+	check(<-done, t)
+
+	t.Log("Should fail on socket clients")
+
+	socketClient := &Client{socketPath: "/nonexistent"}
+	if socketClient.Replicate(context.Background(), ReplicateOptions{}) == nil {
+		t.Fatal("Expected error")
+	}
+}
+
+func TestPushPull(t *testing.T) {
+	defer cml.Delete("primary")
+	defer cml.Delete("replica")
+
+	check(cml.Set("primary/a", 1), t)
+
+	// Like in TestReplicate, the hierarchy at "primary" is served as if it was at "replica"
+	s := New()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.URL.Path = strings.Replace(r.URL.Path, "/replica", "/primary", 1)
+		r.URL.RawQuery = strings.Replace(r.URL.RawQuery, "path=replica", "path=primary", 1)
+
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, r)
+
+		body := rec.Body.String()
+		if strings.HasPrefix(r.URL.Path, watchPrefix) {
+			body = strings.ReplaceAll(body, `"path":"primary`, `"path":"replica`)
+		}
+
+		w.WriteHeader(rec.Code)
+		w.Write([]byte(body))
+	}))
+	defer ts.Close()
+
+	client, err := NewClient(ts.URL)
+	check(err, t)
+
+	options := SyncOptions{Path: "replica"}
+
+	t.Log("Should pull the hierarchy in full the first time")
+
+	_, err = client.Pull(context.Background(), options)
+	check(err, t)
+
+	entry, err := cml.GetEntry("replica/a")
+	check(err, t)
+	if entry.Value != "1" || entry.Type != cml.TypeInt {
+		t.Fatalf("Unexpected pulled entry %+v", entry)
+	}
+
+	t.Log("Should push the local changes only")
+
+	check(cml.Set("replica/b/c", "x"), t)
+
+	n, err := client.Push(context.Background(), options)
+	check(err, t)
+	if n != 1 {
+		t.Fatalf("Expected 1 changed hierarchy, got %d", n)
+	}
+
+	value, err := cml.Get[string]("primary/b/c")
+	check(err, t)
+	if value != "x" {
+		t.Fatalf("Expected x, got %s", value)
+	}
+
+	n, err = client.Push(context.Background(), options)
+	check(err, t)
+	if n != 0 {
+		t.Fatalf("Expected nothing to push, got %d", n)
+	}
+
+	t.Log("Should pull the remote changes only")
+
+	check(cml.Set("primary/a", 2), t)
+	check(cml.Set("replica/local", "l"), t)
+
+	_, err = client.Pull(context.Background(), options)
+	check(err, t)
+
+	a, err := cml.Get[int]("replica/a")
+	check(err, t)
+	if a != 2 {
+		t.Fatalf("Expected 2, got %d", a)
+	}
+
+	exists, err := cml.Exists("replica/local")
+	check(err, t)
+	if !exists {
+		t.Fatal("Expected local changes to be kept")
+	}
+
+	t.Log("Should push deletions")
+
+	check(cml.Delete("replica/b"), t)
+
+	_, err = client.Push(context.Background(), options)
+	check(err, t)
+
+	exists, err = cml.Exists("primary/b")
+	check(err, t)
+	if exists {
+		t.Fatal("Expected primary/b to be deleted")
+	}
+
+	value, err = cml.Get[string]("primary/local")
+	check(err, t)
+	if value != "l" {
+		t.Fatalf("Expected l, got %s", value)
+	}
+}
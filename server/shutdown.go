@@ -0,0 +1,166 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+)
+
+/*
+Shutdown gracefully shuts down the Server: it stops accepting connections on all the listeners it serves, ends the
+watch requests, waits for the in-flight requests to complete, and closes the connections. If ctx is done first, the
+remaining connections are closed anyway, and the error of ctx is returned.
+
+After Shutdown, the Serve methods of the Server return http.ErrServerClosed. Shutdown does not close the DB.
+*/
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mutex.Lock()
+	if !s.closing {
+		s.closing = true
+		close(s.done)
+	}
+
+	for l := range s.listeners {
+		l.Close()
+	}
+
+	httpServers := make([]*http.Server, 0, len(s.httpServers))
+	for h := range s.httpServers {
+		httpServers = append(httpServers, h)
+	}
+	s.mutex.Unlock()
+
+	errs := make(chan error, len(httpServers))
+	for _, h := range httpServers {
+		go func(h *http.Server) {
+			err := h.Shutdown(ctx)
+			if err != nil {
+				h.Close()
+			}
+
+			errs <- err
+		}(h)
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		s.inFlight.Wait()
+		close(drained)
+	}()
+
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	s.mutex.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mutex.Unlock()
+
+	for range httpServers {
+		httpErr := <-errs
+		if err == nil {
+			err = httpErr
+		}
+	}
+
+	return err
+}
+
+/*
+trackHTTPServer adds h to the HTTP servers closed by Shutdown, or removes it. Fails if the Server is shutting down
+*/
+func (s *Server) trackHTTPServer(h *http.Server, add bool) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !add {
+		delete(s.httpServers, h)
+		return true
+	}
+
+	if s.closing {
+		return false
+	}
+
+	s.httpServers[h] = struct{}{}
+	return true
+}
+
+/*
+trackListener adds l to the listeners closed by Shutdown, or removes it. Fails if the Server is shutting down
+*/
+func (s *Server) trackListener(l net.Listener, add bool) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !add {
+		delete(s.listeners, l)
+		return true
+	}
+
+	if s.closing {
+		return false
+	}
+
+	s.listeners[l] = struct{}{}
+	return true
+}
+
+/*
+trackConn adds conn to the connections closed by Shutdown, or removes it. Fails if the Server is shutting down
+*/
+func (s *Server) trackConn(conn net.Conn, add bool) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !add {
+		delete(s.conns, conn)
+		return true
+	}
+
+	if s.closing {
+		return false
+	}
+
+	s.conns[conn] = struct{}{}
+	return true
+}
+
+/*
+startRequest marks a request of a socket or RESP connection as in-flight, so that Shutdown waits for it. Fails if the
+Server is shutting down, in which case the request must not be handled
+*/
+func (s *Server) startRequest() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closing {
+		return false
+	}
+
+	s.inFlight.Add(1)
+	return true
+}
+
+func (s *Server) endRequest() {
+	s.inFlight.Done()
+}
+
+/*
+acceptError returns http.ErrServerClosed in place of err if the listener failed because the Server is shutting down
+*/
+func (s *Server) acceptError(err error) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closing {
+		return http.ErrServerClosed
+	}
+
+	return err
+}
@@ -0,0 +1,275 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync/atomic"
+
+	cml "github.com/debevv/camellia"
+)
+
+/*
+SocketRequest is a request of the Unix domain socket protocol. Requests and responses are JSON objects, one per line.
+
+Op selects the operation:
+
+"get": returns the value at Path in Value.
+
+"entry": returns the Entry at Path in Entry, with children up to Depth (1 if nil, -1 for the full hierarchy).
+
+"exists": returns whether an Entry exists at Path in Exists.
+
+"set": sets the value at Path to Value. With Force == true, overwrites non-value Entries.
+
+"delete": deletes the Entry at Path, and its children.
+
+"export": returns the hierarchy at Path in Data, in the JSON format selected by Extended, Canonical and Native, with
+the Entries selected by Include and Exclude.
+
+"import": imports the JSON representation in Data at Path, as selected by Extended, Merge, Native and FireHooks. With DryRun ==
+true, returns the changes that would be applied in Changes, without applying them.
+*/
+type SocketRequest struct {
+	Op        string          `json:"op"`
+	Path      string          `json:"path,omitempty"`
+	Value     string          `json:"value,omitempty"`
+	Force     bool            `json:"force,omitempty"`
+	Depth     *int            `json:"depth,omitempty"`
+	Extended  bool            `json:"extended,omitempty"`
+	Canonical bool            `json:"canonical,omitempty"`
+	Native    bool            `json:"native,omitempty"`
+	Runtime   bool            `json:"runtime,omitempty"`
+	Merge     bool            `json:"merge,omitempty"`
+	DryRun    bool            `json:"dry_run,omitempty"`
+	FireHooks bool            `json:"fire_hooks,omitempty"`
+	Include   []string        `json:"include,omitempty"`
+	Exclude   []string        `json:"exclude,omitempty"`
+	Data      json.RawMessage `json:"data,omitempty"`
+}
+
+/*
+SocketResponse is a response of the Unix domain socket protocol. On failure, Error carries the error message and
+Status the HTTP status code matching it (like 404 for missing paths), otherwise Status is 200.
+*/
+type SocketResponse struct {
+	Status  int             `json:"status"`
+	Error   string          `json:"error,omitempty"`
+	Value   *string         `json:"value,omitempty"`
+	Exists  *bool           `json:"exists,omitempty"`
+	Entry   *cml.Entry      `json:"entry,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+	Changes []jsonChange    `json:"changes,omitempty"`
+}
+
+/*
+ListenAndServeSocket serves the camellia DB currently open on the Unix domain socket at path, removing a stale
+socket file, if any. See ServeSocket.
+*/
+func ListenAndServeSocket(path string) error {
+	return ListenAndServeSocketWithOptions(path, Options{})
+}
+
+/*
+ListenAndServeSocketWithOptions calls ListenAndServeSocket, serving with the specified Options.
+*/
+func ListenAndServeSocketWithOptions(path string, options Options) error {
+	err := os.Remove(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error removing stale socket - %w", err)
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+
+	defer l.Close()
+
+	return NewWithOptions(options).ServeSocket(l)
+}
+
+/*
+ServeSocket serves the camellia DB currently open on the connections accepted by l, each on its own goroutine,
+using the line-based JSON protocol described by SocketRequest and SocketResponse.
+
+All the connections share the same DB handle, so that multiple processes on the same device can use a single writer
+instead of contending on the SQLite file.
+*/
+func ServeSocket(l net.Listener) error {
+	return New().ServeSocket(l)
+}
+
+/*
+ServeSocket serves the camellia DB currently open on the connections accepted by l (see the package-level
+ServeSocket), enforcing the ACL, the rate limit and the maximum request size of the Server. Credentials are not used
+on sockets: on Linux, clients are identified by the "uid:<user ID>" principal of the connected process, elsewhere
+they are anonymous.
+*/
+func (s *Server) ServeSocket(l net.Listener) error {
+	if !s.trackListener(l, true) {
+		return http.ErrServerClosed
+	}
+
+	defer s.trackListener(l, false)
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return s.acceptError(err)
+		}
+
+		go s.serveSocketConn(conn)
+	}
+}
+
+func (s *Server) serveSocketConn(conn net.Conn) {
+	defer conn.Close()
+
+	if !s.trackConn(conn, true) {
+		return
+	}
+
+	defer s.trackConn(conn, false)
+
+	principal := socketPrincipal(conn)
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 64*1024), int(s.maxBodySize()))
+
+	encoder := json.NewEncoder(conn)
+	encoder.SetEscapeHTML(false)
+
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		if !s.startRequest() {
+			return
+		}
+
+		var req SocketRequest
+		var res *SocketResponse
+
+		err := json.Unmarshal(line, &req)
+		if !s.allow(principal) {
+			res = &SocketResponse{Status: http.StatusTooManyRequests, Error: errRateLimited.Error()}
+		} else if err != nil {
+			res = &SocketResponse{Status: http.StatusBadRequest, Error: fmt.Sprintf("invalid request - %v", err)}
+		} else {
+			res = s.handleSocketRequest(&req, principal)
+		}
+
+		err = encoder.Encode(res)
+		s.endRequest()
+		if err != nil {
+			return
+		}
+	}
+
+	if errors.Is(scanner.Err(), bufio.ErrTooLong) {
+		atomic.AddUint64(&s.tooLarge, 1)
+		encoder.Encode(&SocketResponse{Status: http.StatusRequestEntityTooLarge, Error: errBodyTooLarge.Error()})
+	}
+}
+
+func (s *Server) handleSocketRequest(req *SocketRequest, principal string) *SocketResponse {
+	res := &SocketResponse{Status: http.StatusOK}
+
+	var err error
+	switch req.Op {
+	case "get", "entry", "exists", "export":
+		err = s.checkAccess(principal, req.Path, false)
+	case "set", "delete":
+		err = s.checkAccess(principal, req.Path, true)
+	}
+
+	if err != nil {
+		return &SocketResponse{Status: errorStatus(err), Error: err.Error()}
+	}
+
+	switch req.Op {
+	case "get":
+		var value string
+		value, err = cml.Get[string](req.Path)
+		res.Value = &value
+
+	case "entry":
+		depth := 1
+		if req.Depth != nil {
+			depth = *req.Depth
+		}
+
+		res.Entry, err = cml.GetEntryDepth(req.Path, depth)
+
+	case "exists":
+		var exists bool
+		exists, err = cml.Exists(req.Path)
+		res.Exists = &exists
+
+	case "set":
+		err = write(context.Background(), principal, func(tx *cml.Tx) error {
+			if req.Force {
+				return tx.Force(req.Path, req.Value)
+			}
+
+			return tx.Set(req.Path, req.Value)
+		})
+
+	case "delete":
+		err = write(context.Background(), principal, func(tx *cml.Tx) error {
+			return tx.Delete(req.Path)
+		})
+
+	case "export":
+		var exists bool
+		exists, err = cml.Exists(req.Path)
+		if err == nil && !exists {
+			err = cml.ErrPathNotFound
+		}
+
+		if err == nil {
+			buffer := bytes.Buffer{}
+			err = cml.ExportJSON(req.Path, &buffer, cml.ExportOptions{
+				Extended:    req.Extended,
+				Canonical:   req.Canonical,
+				NativeTypes: req.Native,
+				Runtime:     req.Runtime,
+				Include:     req.Include,
+				Exclude:     req.Exclude})
+			res.Data = buffer.Bytes()
+		}
+
+	case "import":
+		options := cml.ImportOptions{
+			Extended:    req.Extended,
+			OnlyMerge:   req.Merge,
+			NativeTypes: req.Native,
+			FireHooks:   req.FireHooks,
+			Writer:      principal,
+			Path:        req.Path}
+
+		var changes []cml.Change
+		changes, err = s.importJSON(req.Data, options, req.DryRun, principal)
+		if req.DryRun {
+			res.Changes = toJSONChanges(changes)
+		}
+
+	default:
+		return &SocketResponse{Status: http.StatusBadRequest, Error: fmt.Sprintf("unknown operation %s", req.Op)}
+	}
+
+	if err != nil {
+		return &SocketResponse{Status: errorStatus(err), Error: err.Error()}
+	}
+
+	return res
+}
@@ -0,0 +1,256 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sort"
+
+	cml "github.com/debevv/camellia"
+)
+
+/*
+SyncOptions configures Client.Push and Client.Pull.
+
+Path: the path of the synchronized hierarchy, the root if empty.
+*/
+type SyncOptions struct {
+	Path string
+}
+
+/*
+Pull applies to the open DB the changes made on the server to the hierarchy at options.Path since the last Pull,
+like git pull, returning the number of changed hierarchies applied. Only the Entries changed on the server are read,
+and they replace the local ones (see camellia.ApplyReplicatedFrom). The first Pull, and any Pull after the server
+discarded the changes since the previous one, copies the hierarchy in full.
+
+The revision of the server reached is stored in the open DB, keyed by the URL of the server, so each server is
+tracked separately. Supported on HTTP servers only.
+*/
+func (c *Client) Pull(ctx context.Context, options SyncOptions) (int, error) {
+	if c.socketPath != "" {
+		return 0, errors.New("pull is supported on HTTP servers only")
+	}
+
+	path := cml.Path(options.Path).String()
+
+	pulled, err := cml.GetReplicatedRevisionFrom(c.baseURL)
+	if err != nil {
+		return 0, err
+	}
+
+	pushed, err := cml.GetPushedRevision(c.baseURL)
+	if err != nil {
+		return 0, err
+	}
+
+	local, err := cml.GetRevision()
+	if err != nil {
+		return 0, err
+	}
+
+	var entries []cml.ReplicatedEntry
+	var revision uint64
+
+	if pulled > 0 {
+		var events []cml.Event
+		events, revision, err = c.pollEvents(ctx, path, &pulled, 0)
+		if err != nil && !errors.Is(err, cml.ErrRevisionCompacted) {
+			return 0, err
+		}
+
+		if err == nil && revision >= pulled {
+			entries, err = c.replicatedEntries(ctx, path, events)
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	if entries == nil {
+		_, revision, err = c.pollEvents(ctx, path, nil, 0)
+		if err != nil {
+			return 0, err
+		}
+
+		entry, err := c.replicatedEntry(ctx, path)
+		if err != nil {
+			return 0, err
+		}
+
+		entries = []cml.ReplicatedEntry{{Path: path, Entry: entry}}
+	}
+
+	err = cml.ApplyReplicatedFrom(c.baseURL, entries, revision)
+	if err != nil {
+		return 0, err
+	}
+
+	// Without local changes left to push, the ones just pulled are not pushed back
+	if pushed == local {
+		local, err = cml.GetRevision()
+		if err != nil {
+			return 0, err
+		}
+
+		err = cml.SetPushedRevision(c.baseURL, local)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(entries), nil
+}
+
+/*
+Push sends to the server the changes made to the hierarchy at options.Path of the open DB since the last Push, like git
+push, returning the number of changed hierarchies sent. Only the changed Entries are sent: deleted Entries are deleted
+on the server, and the others imported, replacing the ones on the server. The first Push, and any Push after the
+change log of the open DB discarded the changes since the previous one, sends the hierarchy in full, without deleting
+anything.
+
+The revision of the open DB pushed is stored in it, keyed by the URL of the server (see camellia.SetPushedRevision).
+Supported on HTTP servers only.
+*/
+func (c *Client) Push(ctx context.Context, options SyncOptions) (int, error) {
+	if c.socketPath != "" {
+		return 0, errors.New("push is supported on HTTP servers only")
+	}
+
+	path := cml.Path(options.Path).String()
+
+	pushed, err := cml.GetPushedRevision(c.baseURL)
+	if err != nil {
+		return 0, err
+	}
+
+	pulled, err := cml.GetReplicatedRevisionFrom(c.baseURL)
+	if err != nil {
+		return 0, err
+	}
+
+	_, remote, err := c.pollEvents(ctx, path, nil, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := []string{}
+	changed := []string{}
+
+	events, local, err := cml.GetEvents(path, pushed)
+	if pushed == 0 || errors.Is(err, cml.ErrRevisionCompacted) {
+		local, err = cml.GetRevision()
+		changed = append(changed, path)
+	} else {
+		deleted, changed = pushedPaths(path, events)
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	sent := map[string]bool{}
+	for _, p := range deleted {
+		err = c.Delete(p)
+		if err != nil && !errors.Is(err, cml.ErrPathNotFound) {
+			return 0, err
+		}
+
+		sent[p] = true
+	}
+
+	for _, p := range changed {
+		buffer := bytes.Buffer{}
+		err = cml.ExportJSON(p, &buffer, cml.ExportOptions{Extended: true})
+		if errors.Is(err, cml.ErrPathNotFound) {
+			continue
+		}
+
+		if err != nil {
+			return 0, err
+		}
+
+		err = c.ImportJSON(&buffer, cml.ImportOptions{Extended: true, Path: p})
+		if err != nil {
+			return 0, err
+		}
+
+		sent[p] = true
+	}
+
+	err = cml.SetPushedRevision(c.baseURL, local)
+	if err != nil {
+		return 0, err
+	}
+
+	// Without changes left to pull, the ones just pushed are not pulled back
+	if pulled == remote && pulled > 0 {
+		_, remote, err = c.pollEvents(ctx, path, nil, 0)
+		if err != nil {
+			return 0, err
+		}
+
+		err = cml.ApplyReplicatedFrom(c.baseURL, nil, remote)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(sent), nil
+}
+
+/*
+pushedPaths returns the paths to delete and the ones to send to push events, in order. Changed paths below other
+changed paths are covered by the latter
+*/
+func pushedPaths(path string, events []cml.Event) ([]string, []string) {
+	deleted := []string{}
+	isDeleted := map[string]bool{}
+	isChanged := map[string]bool{}
+
+	for _, e := range events {
+		p := e.Path
+		// Changes above the pushed path, like deletions of its ancestors, affect it all
+		if !isAncestor(path, p) {
+			p = path
+		}
+
+		if e.Type == cml.ChangeDeleted {
+			if !isDeleted[p] {
+				isDeleted[p] = true
+				deleted = append(deleted, p)
+			}
+		} else {
+			isChanged[p] = true
+		}
+	}
+
+	// Deleted Entries created again are sent after the deletion
+	for _, p := range deleted {
+		isChanged[p] = true
+	}
+
+	paths := make([]string, 0, len(isChanged))
+	for p := range isChanged {
+		paths = append(paths, p)
+	}
+
+	sort.Strings(paths)
+
+	changed := []string{}
+	for _, p := range paths {
+		covered := false
+		for _, c := range changed {
+			if isAncestor(c, p) {
+				covered = true
+				break
+			}
+		}
+
+		if !covered {
+			changed = append(changed, p)
+		}
+	}
+
+	return deleted, changed
+}
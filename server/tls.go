@@ -0,0 +1,88 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+/*
+serverTLSConfig returns the TLS configuration of the HTTP server, or nil if TLS is not enabled by options
+*/
+func serverTLSConfig(options Options) (*tls.Config, error) {
+	if options.TLSCertFile == "" && options.TLSKeyFile == "" {
+		if options.TLSClientCAFile != "" {
+			return nil, errors.New("client CA specified without a server certificate")
+		}
+
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(options.TLSCertFile, options.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading certificate - %w", err)
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if options.TLSClientCAFile != "" {
+		config.ClientCAs, err = loadCertPool(options.TLSClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client CA - %w", err)
+		}
+
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return config, nil
+}
+
+/*
+loadCertPool reads a pool of PEM encoded certificates from filePath
+*/
+func loadCertPool(filePath string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", filePath)
+	}
+
+	return pool, nil
+}
+
+/*
+ClientTLSConfig returns a TLS configuration for a Client, trusting the server certificates signed by the CA in
+caFile (or the system CAs, if empty), and presenting the certificate in certFile and keyFile (if not empty) to servers
+requiring client certificates.
+*/
+func ClientTLSConfig(caFile string, certFile string, keyFile string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if caFile != "" {
+		var err error
+		config.RootCAs, err = loadCertPool(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading CA - %w", err)
+		}
+	}
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client certificate - %w", err)
+		}
+
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
@@ -0,0 +1,25 @@
+package server
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+const uiPrefix = "/ui/"
+
+//go:embed ui
+var uiFiles embed.FS
+
+/*
+uiHandler serves the web UI, a single page browsing and editing the hierarchy through the HTTP API, and showing the
+changes streamed by /v1/watch/. The page itself requires no authentication, the API requests it makes do
+*/
+func uiHandler() http.Handler {
+	files, err := fs.Sub(uiFiles, "ui")
+	if err != nil {
+		panic(err)
+	}
+
+	return http.StripPrefix(uiPrefix, http.FileServer(http.FS(files)))
+}
@@ -0,0 +1,262 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	cml "github.com/debevv/camellia"
+)
+
+const (
+	watchPrefix = "/v1/watch/"
+
+	// Interval of the comments keeping idle streams alive through proxies
+	watchKeepAlive = 15 * time.Second
+
+	defaultPollTimeout = 30 * time.Second
+	maxPollTimeout     = 5 * time.Minute
+)
+
+type jsonEvents struct {
+	Revision uint64       `json:"revision"`
+	Events   []jsonChange `json:"events"`
+}
+
+/*
+handleWatch returns the changes to the Entry at the requested path, and to its children, after the revision in the
+sinceRev parameter (or in the Last-Event-ID header, sent by reconnecting EventSources), or after the current
+revision. Changes are read from the change log of the DB (see camellia.GetEvents), so no change is lost between
+requests, as long as clients don't fall behind the history of the log.
+
+By default, changes are streamed as server-sent events. With poll=true, the request waits up to timeout seconds for
+at least one change, and returns the changes and the revision to continue from in a JSON object.
+*/
+func (s *Server) handleWatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, watchPrefix)
+
+	if !s.authorize(w, r, &path, false) {
+		return
+	}
+
+	principal := s.principal(r)
+	err := s.checkAccess(principal, path, false)
+	if err != nil {
+		writeCamelliaError(w, err)
+		return
+	}
+
+	// Registered before reading the current revision, so that no change goes unnoticed
+	notify := make(chan struct{}, 1)
+	unwatch, err := cml.Watch(path, func(change cml.Change) {
+		select {
+		case notify <- struct{}{}:
+		default:
+		}
+	})
+	if err != nil {
+		writeCamelliaError(w, err)
+		return
+	}
+
+	defer unwatch()
+
+	since, err := watchStart(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if since == nil {
+		revision, err := cml.GetRevision()
+		if err != nil {
+			writeCamelliaError(w, err)
+			return
+		}
+
+		since = &revision
+	}
+
+	// Fails early for compacted revisions, before committing to a response
+	events, revision, err := s.readEvents(path, principal, *since)
+	if err != nil {
+		writeCamelliaError(w, err)
+		return
+	}
+
+	if queryFlag(r, "poll") {
+		s.pollEvents(w, r, path, principal, events, revision, notify)
+	} else {
+		s.streamEvents(w, r, path, principal, events, revision, notify)
+	}
+}
+
+/*
+watchStart returns the revision to start watching from, or nil if not specified
+*/
+func watchStart(r *http.Request) (*uint64, error) {
+	value := r.URL.Query().Get("sinceRev")
+	if value == "" {
+		value = r.Header.Get("Last-Event-ID")
+	}
+
+	if value == "" {
+		return nil, nil
+	}
+
+	since, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid revision - %w", err)
+	}
+
+	return &since, nil
+}
+
+/*
+readEvents returns the changes after the since revision readable by principal, along with the current revision
+*/
+func (s *Server) readEvents(path string, principal string, since uint64) ([]jsonChange, uint64, error) {
+	events, revision, err := cml.GetEvents(path, since)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	watchPath := cml.NormalizePath(path)
+
+	jEvents := []jsonChange{}
+	for _, e := range events {
+		change := e.Change
+		if s.checkAccess(principal, e.Path, false) != nil {
+			// Changes to ancestors (deletions and overwrites) are always visible, since they affect the watched
+			// path, but without the values the principal can't read
+			if !isAncestor(e.Path, watchPath) {
+				continue
+			}
+
+			change.OldValue = ""
+			change.Value = ""
+		}
+
+		jEvent := toJSONChanges([]cml.Change{change})[0]
+		jEvent.Revision = e.Revision
+		jEvents = append(jEvents, jEvent)
+	}
+
+	return jEvents, revision, nil
+}
+
+func (s *Server) pollEvents(w http.ResponseWriter, r *http.Request, path string, principal string,
+	events []jsonChange, revision uint64, notify chan struct{}) {
+	timeout := defaultPollTimeout
+	if r.URL.Query().Has("timeout") {
+		seconds, err := strconv.ParseUint(r.URL.Query().Get("timeout"), 10, 32)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid timeout - %w", err))
+			return
+		}
+
+		timeout = time.Duration(seconds) * time.Second
+		if timeout > maxPollTimeout {
+			timeout = maxPollTimeout
+		}
+	}
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for len(events) == 0 {
+		select {
+		case <-notify:
+			var err error
+			events, revision, err = s.readEvents(path, principal, revision)
+			if err != nil {
+				writeCamelliaError(w, err)
+				return
+			}
+
+		case <-deadline.C:
+			writeJSON(w, http.StatusOK, jsonEvents{Revision: revision, Events: events})
+			return
+
+		case <-s.done:
+			writeJSON(w, http.StatusOK, jsonEvents{Revision: revision, Events: events})
+			return
+
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, jsonEvents{Revision: revision, Events: events})
+}
+
+func (s *Server) streamEvents(w http.ResponseWriter, r *http.Request, path string, principal string,
+	events []jsonChange, revision uint64, notify chan struct{}) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming not supported"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(watchKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		for _, e := range events {
+			data, err := json.Marshal(e)
+			if err != nil {
+				return
+			}
+
+			_, err = fmt.Fprintf(w, "id: %d\nevent: change\ndata: %s\n\n", e.Revision, data)
+			if err != nil {
+				return
+			}
+		}
+
+		flusher.Flush()
+
+		select {
+		case <-notify:
+			var err error
+			events, revision, err = s.readEvents(path, principal, revision)
+			if err != nil {
+				// Most likely, the client fell behind the change log: it has to start over
+				return
+			}
+
+		case <-keepAlive.C:
+			events = nil
+
+			_, err := fmt.Fprint(w, ": keep-alive\n\n")
+			if err != nil {
+				return
+			}
+
+		case <-s.done:
+			// The client reconnects to another server, or to this one once restarted, from the last event ID
+			return
+
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func isAncestor(ancestor string, path string) bool {
+	return ancestor == "" || ancestor == path || strings.HasPrefix(path, ancestor+"/")
+}
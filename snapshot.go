@@ -0,0 +1,170 @@
+package camellia
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+ErrSnapshotEnded is returned by the methods of a Snapshot called after End.
+*/
+var ErrSnapshotEnded = errors.New("snapshot ended")
+
+/*
+Snapshot is a consistent, read-only view of the DB, taken by BeginSnapshot: every read performed through it observes
+the DB as it was when the Snapshot began, ignoring the writes committed later, by this or other processes. This allows
+performing several reads, like a Get followed by an export, that agree with each other.
+
+Unlike the package-level API, a Snapshot reads the persisted Entries only: runtime values are not applied,
+and references are not resolved. A Snapshot is safe for concurrent use, and must be ended with End, before closing the
+DB.
+*/
+type Snapshot struct {
+	mutex    sync.Mutex
+	tx       *sql.Tx
+	revision uint64
+}
+
+/*
+BeginSnapshot begins a Snapshot of the DB. A Snapshot holds a read transaction, which in WAL mode doesn't block the
+writers, but prevents the DB file from being checkpointed past it, so it should be ended as soon as possible.
+*/
+func BeginSnapshot() (*Snapshot, error) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return nil, ErrNoDB
+	}
+
+	tx, err := beginReadTx(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("error beginning transaction - %w", err)
+	}
+
+	// SQLite takes the snapshot on the first read of the transaction, not when it begins
+	revision, err := getRevision(tx)
+	if err != nil {
+		rollbackTx(tx)
+		return nil, fmt.Errorf("error reading revision - %w", err)
+	}
+
+	return &Snapshot{tx: tx, revision: revision}, nil
+}
+
+/*
+Revision returns the revision of the DB the Snapshot was taken at (see GetRevision).
+*/
+func (s *Snapshot) Revision() uint64 {
+	return s.revision
+}
+
+/*
+Get returns the value at the specified path.
+*/
+func (s *Snapshot) Get(path string) (string, error) {
+	var value string
+	err := s.read(func(tx *sql.Tx) (err error) {
+		path = normalizePath(path)
+		value, err = getValue(path, tx)
+		return pathError("get", path, err)
+	})
+
+	return value, err
+}
+
+/*
+GetEntry returns the Entry at the specified path, including its children.
+*/
+func (s *Snapshot) GetEntry(path string) (*Entry, error) {
+	var entry *Entry
+	err := s.read(func(tx *sql.Tx) (err error) {
+		path = normalizePath(path)
+		entry, err = getEntryDepth(path, -1, tx)
+		return pathError("get", path, err)
+	})
+
+	return entry, err
+}
+
+/*
+Exists returns whether an Entry exists at the specified path.
+*/
+func (s *Snapshot) Exists(path string) (bool, error) {
+	var ok bool
+	err := s.read(func(tx *sql.Tx) (err error) {
+		ok, err = exists(normalizePath(path), tx)
+		return err
+	})
+
+	return ok, err
+}
+
+/*
+Recurse recurses the hierarchy of Entries at the specified path, like the package-level Recurse. cb must not use the
+Snapshot.
+*/
+func (s *Snapshot) Recurse(path string, depth int, cb func(entry *Entry, parent *Entry, depth uint) error) error {
+	return s.read(func(tx *sql.Tx) error {
+		path = normalizePath(path)
+		return pathError("recurse", path, recurse(path, depth, cb, tx))
+	})
+}
+
+/*
+ExportJSON writes the hierarchy of Entries at the specified path to w, like the package-level ExportJSON.
+*/
+func (s *Snapshot) ExportJSON(path string, w io.Writer, options ExportOptions) error {
+	return s.read(func(tx *sql.Tx) error {
+		return writeJSON(normalizePath(path), w, options, tx)
+	})
+}
+
+/*
+End ends the Snapshot, releasing its read transaction. Ending an ended Snapshot does nothing.
+*/
+func (s *Snapshot) End() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.tx == nil {
+		return nil
+	}
+
+	tx := s.tx
+	s.tx = nil
+
+	err := endReadTx(context.Background(), tx)
+	if err != nil {
+		rollbackTx(tx)
+		return fmt.Errorf("error committing transaction - %w", err)
+	}
+
+	return nil
+}
+
+/*
+read calls fn with the transaction of the Snapshot, while the DB is open
+*/
+func (s *Snapshot) read(fn func(tx *sql.Tx) error) error {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return ErrNoDB
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.tx == nil {
+		return ErrSnapshotEnded
+	}
+
+	return fn(s.tx)
+}
@@ -0,0 +1,279 @@
+package camellia
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+)
+
+/* Size of the chunks streamed values are split into */
+const streamChunkSize = 64 * 1024
+
+/*
+SetReader sets the value at the specified path to the content read from r, until EOF.
+
+The content is stored in chunks and tagged with TypeStream, so it's never held fully in memory. Hooks are called with
+an empty value.
+*/
+func SetReader(path string, r io.Reader) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return ErrNoDB
+	}
+
+	path = normalizePath(path)
+
+	tx, err := beginTx()
+	if err != nil {
+		return fmt.Errorf("error beginning transaction - %w", err)
+	}
+
+	err = setValue(path, "", TypeStream, tx, false, false)
+	if err != nil {
+		rollbackTx(tx)
+		return err
+	}
+
+	err = writeChunks(path, r, tx)
+	if err != nil {
+		rollbackTx(tx)
+		return err
+	}
+
+	err = commitTx(tx)
+	if err != nil {
+		rollbackTx(tx)
+		return fmt.Errorf("error committing transaction - %w", err)
+	}
+
+	return nil
+}
+
+/*
+GetReader returns a reader of the value at the specified path.
+
+Values set with SetReader are read from the DB one chunk at a time, so the value should not be modified while it's
+being read. Other values are read as with GetBytes.
+*/
+func GetReader(path string) (io.ReadCloser, error) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return nil, ErrNoDB
+	}
+
+	path = normalizePath(path)
+
+	tx, err := beginReadTx(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("error beginning transaction - %w", err)
+	}
+
+	warnDeprecated(path, "get")
+
+	entry, err := getEntry(path, tx)
+	if err != nil {
+		rollbackTx(tx)
+		return nil, err
+	}
+
+	err = endReadTx(context.Background(), tx)
+	if err != nil {
+		rollbackTx(tx)
+		return nil, fmt.Errorf("error committing transaction - %w", err)
+	}
+
+	if !entry.IsValue {
+		return nil, ErrPathIsNotAValue
+	}
+
+	switch entry.Type {
+	case TypeStream:
+		return &streamReader{path: path}, nil
+	case TypeBytes:
+		value, err := base64.StdEncoding.DecodeString(entry.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		return io.NopCloser(bytes.NewReader(value)), nil
+	default:
+		return io.NopCloser(strings.NewReader(entry.Value)), nil
+	}
+}
+
+type streamReader struct {
+	path   string
+	seq    int64
+	chunk  []byte
+	eof    bool
+	closed bool
+}
+
+func (r *streamReader) Read(p []byte) (int, error) {
+	if r.closed {
+		return 0, fmt.Errorf("reader is closed")
+	}
+
+	for len(r.chunk) == 0 {
+		if r.eof {
+			return 0, io.EOF
+		}
+
+		err := r.next()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.chunk)
+	r.chunk = r.chunk[n:]
+
+	return n, nil
+}
+
+func (r *streamReader) next() error {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return ErrNoDB
+	}
+
+	var checksum sql.NullInt64
+	err := stmts["getChunk"].QueryRow(r.path, r.seq).Scan(&r.chunk, &checksum)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			r.eof = true
+			return nil
+		}
+
+		return fmt.Errorf("error reading chunk %d of %s - %w", r.seq, r.path, err)
+	}
+
+	err = verifyChecksum(r.path, "", r.chunk, checksum)
+	if err != nil {
+		return err
+	}
+
+	r.seq++
+
+	return nil
+}
+
+func (r *streamReader) Close() error {
+	r.closed = true
+	r.chunk = nil
+
+	return nil
+}
+
+func writeChunks(path string, r io.Reader, tx *sql.Tx) error {
+	buf := make([]byte, streamChunkSize)
+	seq := int64(0)
+
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			_, err := pathStmt(tx, "insertChunk", path).Exec(path, seq, buf[:n], valueChecksum("", buf[:n]))
+			if err != nil {
+				return fmt.Errorf("error writing chunk %d of %s - %w", seq, path, err)
+			}
+
+			seq++
+		}
+
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil
+			}
+
+			return fmt.Errorf("error reading value - %w", err)
+		}
+	}
+}
+
+func copyChunks(path string, w io.Writer, tx *sql.Tx) error {
+	for seq := int64(0); ; seq++ {
+		var chunk []byte
+		var checksum sql.NullInt64
+		err := txStmt(tx, "getChunk").QueryRow(path, seq).Scan(&chunk, &checksum)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil
+			}
+
+			return fmt.Errorf("error reading chunk %d of %s - %w", seq, path, err)
+		}
+
+		err = verifyChecksum(path, "", chunk, checksum)
+		if err != nil {
+			return err
+		}
+
+		_, err = w.Write(chunk)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+/*
+replaceChunks deletes the chunks stored for path, and, for streamed values, stores value (in its base64 form) as the
+new chunks
+*/
+func replaceChunks(path string, value string, valueType ValueType, tx *sql.Tx) error {
+	_, err := pathStmt(tx, "deleteChunks", path).Exec(path)
+	if err != nil {
+		return err
+	}
+
+	if valueType != TypeStream || value == "" {
+		return nil
+	}
+
+	return writeChunks(path, base64.NewDecoder(base64.StdEncoding, strings.NewReader(value)), tx)
+}
+
+func getChunksBase64(path string, tx *sql.Tx) (string, error) {
+	var b strings.Builder
+	encoder := base64.NewEncoder(base64.StdEncoding, &b)
+
+	err := copyChunks(path, encoder, tx)
+	if err != nil {
+		return "", err
+	}
+
+	encoder.Close()
+
+	return b.String(), nil
+}
+
+/*
+writeJSONStream writes the content of a streamed value as a base64 JSON string, without loading it in memory
+*/
+func writeJSONStream(w *bufio.Writer, path string, tx *sql.Tx) error {
+	w.WriteString("\"")
+
+	encoder := base64.NewEncoder(base64.StdEncoding, w)
+
+	err := copyChunks(path, encoder, tx)
+	if err != nil {
+		return err
+	}
+
+	encoder.Close()
+	w.WriteString("\"")
+
+	return nil
+}
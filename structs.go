@@ -0,0 +1,326 @@
+package camellia
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+)
+
+const structTag = "cml"
+
+/*
+SetStruct maps the exported fields of the struct v (or pointer to struct) onto the hierarchy of Entries at the
+specified path, in a single transaction.
+
+Each field is set at a child path named after the field, or after its `cml:"name"` tag, if present. Fields tagged
+with `cml:"-"` are skipped. Fields of a type supported by Set are set as values, slices and arrays of such types as
+lists and nested structs as nested paths. Embedded structs without a tag are flattened into the parent path. Lists are
+read back into arrays only if they have the same length.
+*/
+func SetStruct(path string, v any) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return ErrNoDB
+	}
+
+	value := reflect.ValueOf(v)
+	for value.Kind() == reflect.Pointer {
+		value = value.Elem()
+	}
+
+	if value.Kind() != reflect.Struct {
+		return fmt.Errorf("value is not a struct")
+	}
+
+	tx, err := beginTx()
+	if err != nil {
+		return fmt.Errorf("error beginning transaction - %w", err)
+	}
+
+	err = setStruct(normalizePath(path), value, tx)
+	if err != nil {
+		rollbackTx(tx)
+		return err
+	}
+
+	err = commitTx(tx)
+	if err != nil {
+		rollbackTx(tx)
+		return fmt.Errorf("error committing transaction - %w", err)
+	}
+
+	return nil
+}
+
+/*
+GetStruct populates the exported fields of the struct pointed by v with the values found in the hierarchy of Entries
+at the specified path, following the same mapping rules of SetStruct.
+
+Fields without a corresponding Entry are left untouched. Fails with ErrTypeMismatch if the Entry at path, or the one
+of a nested struct field, is a value.
+*/
+func GetStruct(path string, v any) error {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return ErrNoDB
+	}
+
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Pointer || value.IsNil() || value.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("value is not a pointer to a struct")
+	}
+
+	tx, err := beginReadTx(context.Background())
+	if err != nil {
+		return fmt.Errorf("error beginning transaction - %w", err)
+	}
+
+	path = normalizePath(path)
+	entry, err := getEntryDepth(path, -1, tx)
+	entry, err = withRuntime(path, -1, entry, err)
+	if err == nil {
+		err = interpolateEntry(entry, tx)
+	}
+
+	if err != nil {
+		rollbackTx(tx)
+		return err
+	}
+
+	err = endReadTx(context.Background(), tx)
+	if err != nil {
+		rollbackTx(tx)
+		return fmt.Errorf("error committing transaction - %w", err)
+	}
+
+	return getStruct(entry, value.Elem())
+}
+
+/*
+structFieldName returns the name of the child path mapped to field, or "" if the field must be skipped
+*/
+func structFieldName(field reflect.StructField) string {
+	if !field.IsExported() {
+		return ""
+	}
+
+	tag, ok := field.Tag.Lookup(structTag)
+	if !ok {
+		return field.Name
+	}
+
+	if tag == "-" {
+		return ""
+	}
+
+	if tag == "" {
+		return field.Name
+	}
+
+	return tag
+}
+
+func isEmbeddedStruct(field reflect.StructField) bool {
+	_, tagged := field.Tag.Lookup(structTag)
+	return field.Anonymous && !tagged && field.Type.Kind() == reflect.Struct
+}
+
+func setStruct(path string, value reflect.Value, tx *sql.Tx) error {
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+
+		if isEmbeddedStruct(field) {
+			err := setStruct(path, value.Field(i), tx)
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		name := structFieldName(field)
+		if name == "" {
+			continue
+		}
+
+		err := setStructField(normalizePath(path+"/"+name), value.Field(i), tx)
+		if err != nil {
+			return fmt.Errorf("error setting field %s - %w", field.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func setStructField(path string, value reflect.Value, tx *sql.Tx) error {
+	if value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return nil
+		}
+
+		value = value.Elem()
+	}
+
+	if isScalarType(value.Type()) {
+		valueString, err := encodeReflectValue(value)
+		if err != nil {
+			return err
+		}
+
+		return setValue(path, valueString, reflectValueType(value.Type()), tx, false, false)
+	}
+
+	switch value.Kind() {
+	case reflect.Struct:
+		return setStruct(path, value, tx)
+	case reflect.Slice, reflect.Array:
+		list, err := reflectListToJSON(value)
+		if err != nil {
+			return err
+		}
+
+		return setValue(path, list, TypeList, tx, false, false)
+	default:
+		return fmt.Errorf("%w %s", ErrUnsupportedType, value.Type())
+	}
+}
+
+func getStruct(entry *Entry, value reflect.Value) error {
+	if entry.IsValue {
+		return fmt.Errorf("%w - %s is a value, not a struct", ErrTypeMismatch, entry.Path)
+	}
+
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+
+		if isEmbeddedStruct(field) {
+			err := getStruct(entry, value.Field(i))
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		name := structFieldName(field)
+		if name == "" {
+			continue
+		}
+
+		child := entry.Children[name]
+		if child == nil {
+			continue
+		}
+
+		err := getStructField(child, value.Field(i))
+		if err != nil {
+			return fmt.Errorf("error getting field %s - %w", field.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func getStructField(entry *Entry, value reflect.Value) error {
+	if value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			value.Set(reflect.New(value.Type().Elem()))
+		}
+
+		value = value.Elem()
+	}
+
+	if isScalarType(value.Type()) {
+		if !entry.IsValue {
+			return ErrPathIsNotAValue
+		}
+
+		err := checkValueType(entry.Type, reflectValueType(value.Type()))
+		if err != nil {
+			return err
+		}
+
+		return decodeReflectValue(value, entry.Value)
+	}
+
+	switch value.Kind() {
+	case reflect.Struct:
+		return getStruct(entry, value)
+	case reflect.Slice, reflect.Array:
+		if !entry.IsValue {
+			return ErrPathIsNotAValue
+		}
+
+		if entry.Type != TypeList {
+			return ErrValueIsNotAList
+		}
+
+		elements, err := listElements(entry.Value)
+		if err != nil {
+			return err
+		}
+
+		// Arrays are decoded into a copy, so they are left untouched on errors
+		list := reflect.New(value.Type()).Elem()
+		if value.Kind() == reflect.Slice {
+			list = reflect.MakeSlice(value.Type(), len(elements), len(elements))
+		} else if len(elements) != value.Len() {
+			return fmt.Errorf("%w - list of %d elements read as %s", ErrTypeMismatch, len(elements), value.Type())
+		}
+
+		for i, element := range elements {
+			err = decodeReflectValue(list.Index(i), element)
+			if err != nil {
+				return fmt.Errorf("error converting list element %d - %w", i, err)
+			}
+		}
+
+		value.Set(list)
+
+		return nil
+	default:
+		return fmt.Errorf("%w %s", ErrUnsupportedType, value.Type())
+	}
+}
+
+func isBaseKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Uint, reflect.Int8, reflect.Uint8, reflect.Int16, reflect.Uint16, reflect.Int32,
+		reflect.Uint32, reflect.Int64, reflect.Uint64, reflect.Float32, reflect.Float64, reflect.Bool, reflect.String:
+		return true
+	default:
+		return false
+	}
+}
+
+/*
+setBaseValue converts s to the type of value, which must be of a base kind, and stores it in value
+*/
+func setBaseValue(value reflect.Value, s string) error {
+	if !isBaseKind(value.Kind()) {
+		return fmt.Errorf("unsupported type %s", value.Type())
+	}
+
+	if value.Kind() == reflect.String {
+		value.SetString(s)
+		return nil
+	}
+
+	n, err := fmt.Sscan(s, value.Addr().Interface())
+	if err != nil {
+		return fmt.Errorf("error converting value to requested type - %w", err)
+	}
+
+	if n != 1 {
+		return errors.New("error converting value to requested type")
+	}
+
+	return nil
+}
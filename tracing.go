@@ -0,0 +1,126 @@
+package camellia
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+)
+
+/*
+Tracer is the interface used by the library to trace its operations, so that they can be exported to any tracing
+system, like OpenTelemetry, without depending on it.
+
+Start is called at the beginning of an operation, with the context passed to the Ctx variants of the API (or
+context.Background() for the other variants), the name of the operation (like "camellia.Set") and the path it
+operates on. It returns the context of the new span, propagated to the nested spans (like "camellia.sql.commit", around
+the commit of the transaction), and the span itself, ended with the error returned by the operation, if any.
+
+Operations are traced while the DB is locked, so a Tracer must not call the API.
+*/
+type Tracer interface {
+	Start(ctx context.Context, name string, path string) (context.Context, Span)
+}
+
+/*
+Span is a traced operation, started by Tracer.Start.
+*/
+type Span interface {
+	End(err error)
+}
+
+var tracer Tracer
+var tracerMutex sync.Mutex
+
+/*
+txCtx is the context of the operation running the current transaction, set while the global mutex is held
+*/
+var txCtx context.Context
+
+/*
+txOperations maps the transactions of the operations being measured to their operation, so that concurrent read
+transactions count their rows separately
+*/
+var txOperations sync.Map
+
+type noopSpan struct{}
+
+func (noopSpan) End(err error) {}
+
+/*
+SetTracer sets the Tracer used by the library. A nil tracer (the default) disables tracing.
+*/
+func SetTracer(t Tracer) {
+	tracerMutex.Lock()
+	defer tracerMutex.Unlock()
+
+	tracer = t
+}
+
+/*
+operation is a Span measuring the duration of an operation, and the rows it read and changed, to log it if it is
+slower than the threshold set with SetSlowOperationThreshold
+*/
+type operation struct {
+	span    Span
+	name    string
+	path    string
+	start   time.Time
+	rows    int
+	changes int
+	txs     []*sql.Tx
+}
+
+type operationKey struct{}
+
+func (o *operation) End(err error) {
+	o.span.End(err)
+
+	for _, tx := range o.txs {
+		txOperations.Delete(tx)
+	}
+
+	threshold := getSlowOperationThreshold()
+	duration := time.Since(o.start)
+	if threshold > 0 && duration >= threshold {
+		logWarn("slow operation", "op", o.name, "path", o.path, "duration", duration, "rows", o.rows,
+			"changes", o.changes)
+	}
+}
+
+func startSpan(ctx context.Context, name string, path string) (context.Context, Span) {
+	tracerMutex.Lock()
+	t := tracer
+	tracerMutex.Unlock()
+
+	var span Span = noopSpan{}
+	if t != nil {
+		ctx, span = t.Start(ctx, name, path)
+	}
+
+	if getSlowOperationThreshold() <= 0 {
+		return ctx, span
+	}
+
+	o := &operation{span: span, name: name, path: path, start: time.Now()}
+	return context.WithValue(ctx, operationKey{}, o), o
+}
+
+/*
+trackOperation binds tx to the operation in ctx, if it is being measured
+*/
+func trackOperation(ctx context.Context, tx *sql.Tx) {
+	if o, ok := ctx.Value(operationKey{}).(*operation); ok {
+		o.txs = append(o.txs, tx)
+		txOperations.Store(tx, o)
+	}
+}
+
+/*
+countRows adds the rows read by tx to the ones of its operation, if it is being measured
+*/
+func countRows(tx *sql.Tx, rows int) {
+	if o, ok := txOperations.Load(tx); ok {
+		o.(*operation).rows += rows
+	}
+}
@@ -0,0 +1,265 @@
+package camellia
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync/atomic"
+)
+
+/*
+Tree collects changes in memory, without touching the DB, to be applied all at once with CommitTree. This allows
+building complex configurations piecemeal, without other readers observing the intermediate states.
+
+A Tree can be built before the DB is opened, and is not safe for concurrent use.
+*/
+type Tree struct {
+	ops []treeOp
+}
+
+/*
+treeOp is a change collected by a Tree: a deletion, or a value set to path
+*/
+type treeOp struct {
+	path      string
+	delete    bool
+	force     bool
+	value     string
+	valueType ValueType
+}
+
+/*
+NewTree returns an empty Tree.
+*/
+func NewTree() *Tree {
+	return &Tree{}
+}
+
+/*
+Set records that value is to be set to the specified path. value can be of any type supported by the package-level Set,
+and is converted right away, so that unsupported values fail here, instead of when committing.
+*/
+func (t *Tree) Set(path string, value any) error {
+	return t.set(path, value, false)
+}
+
+/*
+Force records that value is to be set to the specified path, deleting any non-value Entry existing at the path first.
+*/
+func (t *Tree) Force(path string, value any) error {
+	return t.set(path, value, true)
+}
+
+func (t *Tree) set(path string, value any, force bool) error {
+	v := reflect.ValueOf(value)
+	if !v.IsValid() {
+		return fmt.Errorf("%w nil", ErrUnsupportedType)
+	}
+
+	valueString, err := encodeReflectValue(v)
+	if err != nil {
+		return fmt.Errorf("error converting value to string - %w", err)
+	}
+
+	t.ops = append(t.ops, treeOp{
+		path:      path,
+		force:     force,
+		value:     valueString,
+		valueType: reflectValueType(v.Type())})
+
+	return nil
+}
+
+/*
+Delete records that the Entry at the specified path, and its children, are to be deleted.
+*/
+func (t *Tree) Delete(path string) {
+	t.ops = append(t.ops, treeOp{path: path, delete: true})
+}
+
+/*
+Len returns the number of changes recorded in the Tree.
+*/
+func (t *Tree) Len() int {
+	return len(t.ops)
+}
+
+/*
+CommitTree applies the changes recorded in tree to the DB, in the order they were recorded, in a single transaction:
+either all of them are committed, or, on the first failure, none is. Values are set like the package-level Set and
+Force do, calling their hooks, and deleting a missing Entry is not an error. tree can be committed again, or to
+another DB.
+*/
+func CommitTree(tree *Tree) error {
+	return CommitTreeCtx(context.Background(), tree)
+}
+
+/*
+CommitTreeCtx calls CommitTree, tracing the operation as a child of ctx (see SetTracer). The transaction is rolled back
+if ctx is done before it is committed.
+*/
+func CommitTreeCtx(ctx context.Context, tree *Tree) error {
+	_, err := commitTree(ctx, tree, false)
+	return err
+}
+
+/*
+DryRunCommitTree behaves like CommitTree, but instead of committing the changes to the DB, returns the list of changes
+that would be applied. Hooks are not called.
+*/
+func DryRunCommitTree(tree *Tree) ([]Change, error) {
+	return commitTree(context.Background(), tree, true)
+}
+
+func commitTree(ctx context.Context, tree *Tree, dryRun bool) (changes []Change, err error) {
+	name := "camellia.CommitTree"
+	if dryRun {
+		name = "camellia.DryRunCommitTree"
+	}
+
+	ctx, span := startSpan(ctx, name, "")
+	defer func() {
+		span.End(err)
+	}()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return nil, ErrNoDB
+	}
+
+	tx, err := beginTxCtx(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error beginning transaction - %w", err)
+	}
+
+	err = applyTree(tree, tx, dryRun)
+	if err == nil {
+		err = checkRequired(tx)
+	}
+
+	if err != nil {
+		rollbackTx(tx)
+		return nil, err
+	}
+
+	if dryRun {
+		changes = recordedChanges
+		recordChanges = false
+		recordedChanges = nil
+
+		sort.SliceStable(changes, func(i, j int) bool {
+			return changes[i].Path < changes[j].Path
+		})
+
+		err = rollbackTx(tx)
+		if err != nil {
+			return nil, fmt.Errorf("error rolling back transaction - %w", err)
+		}
+
+		return changes, nil
+	}
+
+	err = commitTx(tx)
+	if err != nil {
+		return nil, fmt.Errorf("error committing transaction - %w", err)
+	}
+
+	return nil, nil
+}
+
+/*
+applyTree applies the changes recorded in tree, skipping the hooks if skipHooks is true
+*/
+func applyTree(tree *Tree, tx *sql.Tx, skipHooks bool) error {
+	for _, op := range tree.ops {
+		// Paths are normalized by the rules of the DB committed to
+		path := normalizePath(op.path)
+
+		var err error
+		if op.delete {
+			err = deletePath(path, tx)
+		} else {
+			err = setValue(path, op.value, op.valueType, tx, op.force, skipHooks)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+OpType is the type of an Op.
+*/
+type OpType int
+
+const (
+	// OpSet sets a value, like Set
+	OpSet OpType = iota
+	// OpForce sets a value, deleting any non-value Entry existing at the path first, like Force
+	OpForce
+	// OpDelete deletes an Entry and its children, like Delete
+	OpDelete
+)
+
+/*
+Op is an operation applied by Apply. Value is ignored by OpDelete.
+*/
+type Op struct {
+	Type  OpType
+	Path  string
+	Value any
+}
+
+/*
+Apply executes ops, in order, in a single transaction, along with the validators and the synchronous pre and post set
+hooks of the values they set: if any of the operations, validators or hooks fails, the whole transaction is rolled
+back, and none of the operations is applied. This allows hooks to veto related changes as a whole, like the bounds of
+a range, which are then never observed half-updated:
+
+	err := camellia.Apply([]camellia.Op{
+		{Type: camellia.OpSet, Path: "range/min", Value: 10},
+		{Type: camellia.OpSet, Path: "range/max", Value: 20},
+	})
+
+Hooks are called while the DB is locked, so they can't call the functions of the package.
+
+Asynchronous post set hooks are started while the transaction is still open, so they may observe changes that are
+rolled back later. Deleting a missing Entry is not an error.
+*/
+func Apply(ops []Op) error {
+	return ApplyCtx(context.Background(), ops)
+}
+
+/*
+ApplyCtx calls Apply, tracing the operation as a child of ctx (see SetTracer). The transaction is rolled back if ctx is
+done before it is committed.
+*/
+func ApplyCtx(ctx context.Context, ops []Op) error {
+	tree := NewTree()
+	for _, op := range ops {
+		var err error
+		switch op.Type {
+		case OpSet:
+			err = tree.Set(op.Path, op.Value)
+		case OpForce:
+			err = tree.Force(op.Path, op.Value)
+		case OpDelete:
+			tree.Delete(op.Path)
+		default:
+			err = fmt.Errorf("unknown operation type %d", op.Type)
+		}
+
+		if err != nil {
+			return &PathError{Op: "apply", Path: op.Path, Err: err}
+		}
+	}
+
+	return CommitTreeCtx(ctx, tree)
+}
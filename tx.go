@@ -0,0 +1,395 @@
+package camellia
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync/atomic"
+)
+
+/*
+Tx is a write transaction, passed to the callbacks of Update, UpdateAs and of config migrations.
+
+Every operation performed through a Tx is committed atomically when the callback returns nil, and rolled back
+otherwise. Since the DB is locked for the whole transaction, callbacks must only use the Tx, and never call the
+package-level API. Required paths (see Schema) are checked only at the end of the transaction.
+*/
+type Tx struct {
+	tx        *sql.Tx
+	acl       *ACL
+	principal string
+	writer    string
+	// The path of the namespace of the Tx, if any, prepended to every path (see Namespace)
+	prefix string
+	// The open savepoints, innermost last
+	savepoints []txSavepoint
+}
+
+/*
+txSavepoint is a savepoint of a Tx, along with the number of changes recorded when it was created
+*/
+type txSavepoint struct {
+	name    string
+	changes int
+}
+
+/*
+Update runs fn inside a write transaction, committing it if fn returns nil and rolling it back otherwise.
+*/
+func Update(fn func(tx *Tx) error) error {
+	return UpdateCtx(context.Background(), fn)
+}
+
+/*
+UpdateCtx calls Update, tracing the transaction as a child of ctx (see SetTracer). The transaction is rolled back if
+ctx is done before it is committed.
+*/
+func UpdateCtx(ctx context.Context, fn func(tx *Tx) error) (err error) {
+	ctx, span := startSpan(ctx, "camellia.Update", "")
+	defer func() {
+		span.End(err)
+	}()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return ErrNoDB
+	}
+
+	return update(ctx, &Tx{}, fn)
+}
+
+func update(ctx context.Context, t *Tx, fn func(tx *Tx) error) error {
+	tx, err := beginTxCtx(ctx)
+	if err != nil {
+		return fmt.Errorf("error beginning transaction - %w", err)
+	}
+
+	t.tx = tx
+
+	txWriter = t.writer
+	defer func() {
+		txWriter = ""
+	}()
+
+	err = fn(t)
+	if err == nil {
+		err = checkRequired(tx)
+	}
+
+	if err != nil {
+		rollbackTx(tx)
+		return err
+	}
+
+	err = commitTx(tx)
+	if err != nil {
+		rollbackTx(tx)
+		return fmt.Errorf("error committing transaction - %w", err)
+	}
+
+	return nil
+}
+
+/*
+SetWriter sets the identity recorded on the Entries written by the rest of the Tx, overriding the one set with the
+package-level SetWriter. Transactions run by UpdateAs record their principal by default.
+*/
+func (t *Tx) SetWriter(identity string) {
+	t.writer = identity
+	txWriter = identity
+}
+
+/*
+Set sets value to the specified path. value can be of any type supported by the package-level Set.
+*/
+func (t *Tx) Set(path string, value any) error {
+	return t.set(path, value, false)
+}
+
+/*
+Force sets value to the specified path, deleting any non-value Entry existing at the path first.
+*/
+func (t *Tx) Force(path string, value any) error {
+	return t.set(path, value, true)
+}
+
+func (t *Tx) set(path string, value any, force bool) error {
+	err := t.checkAccess(path, true)
+	if err != nil {
+		return err
+	}
+
+	v := reflect.ValueOf(value)
+	if !v.IsValid() {
+		return fmt.Errorf("%w nil", ErrUnsupportedType)
+	}
+
+	valueString, err := encodeReflectValue(v)
+	if err != nil {
+		return fmt.Errorf("error converting value to string - %w", err)
+	}
+
+	return setValue(t.path(path), valueString, reflectValueType(v.Type()), t.tx, force, false)
+}
+
+/*
+SetWithRevision sets value to the specified path only if the Entry at the path is at expectedRevision (see the
+package-level SetWithRevision).
+*/
+func (t *Tx) SetWithRevision(path string, value any, expectedRevision uint64) error {
+	v := reflect.ValueOf(value)
+	if !v.IsValid() {
+		return fmt.Errorf("%w nil", ErrUnsupportedType)
+	}
+
+	valueString, err := encodeReflectValue(v)
+	if err != nil {
+		return fmt.Errorf("error converting value to string - %w", err)
+	}
+
+	return t.setWithRevision(path, valueString, reflectValueType(v.Type()), expectedRevision)
+}
+
+func (t *Tx) setWithRevision(path string, value string, valueType ValueType, expectedRevision uint64) error {
+	err := t.checkAccess(path, true)
+	if err != nil {
+		return err
+	}
+
+	path = t.path(path)
+
+	err = checkRevision(path, expectedRevision, t.tx)
+	if err != nil {
+		return err
+	}
+
+	return setValue(path, value, valueType, t.tx, false, false)
+}
+
+/*
+Get returns the value at the specified path.
+*/
+func (t *Tx) Get(path string) (string, error) {
+	err := t.checkAccess(path, false)
+	if err != nil {
+		return "", err
+	}
+
+	return getValue(t.path(path), t.tx)
+}
+
+/*
+GetEntry returns the Entry at the specified path, including its children.
+*/
+func (t *Tx) GetEntry(path string) (*Entry, error) {
+	err := t.checkAccess(path, false)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := getEntryDepth(t.path(path), -1, t.tx)
+	if err != nil {
+		return nil, err
+	}
+
+	relativizeEntry(entry, t.prefix)
+
+	return entry, nil
+}
+
+/*
+Exists returns whether an Entry exists at the specified path.
+*/
+func (t *Tx) Exists(path string) (bool, error) {
+	err := t.checkAccess(path, false)
+	if err != nil {
+		return false, err
+	}
+
+	return exists(t.path(path), t.tx)
+}
+
+/*
+Delete deletes the Entry at the specified path, and its children.
+*/
+func (t *Tx) Delete(path string) error {
+	err := t.checkAccess(path, true)
+	if err != nil {
+		return err
+	}
+
+	return deletePath(t.path(path), t.tx)
+}
+
+/*
+Move moves the values under the Entry at path from to path to, overwriting any Entry existing at to.
+*/
+func (t *Tx) Move(from string, to string) error {
+	err := t.checkAccess(from, true)
+	if err != nil {
+		return err
+	}
+
+	err = t.checkAccess(to, true)
+	if err != nil {
+		return err
+	}
+
+	return movePath(t.path(from), t.path(to), t.tx)
+}
+
+/*
+Savepoint creates a savepoint called name, marking the current state of the Tx, so that the operations performed after
+it can be undone with RollbackTo, without abandoning the whole transaction. This allows tolerant bulk imports to skip
+the records that fail:
+
+	err := tx.Savepoint("record")
+	if err == nil && tx.Set(path, value) != nil {
+		err = tx.RollbackTo("record")
+	}
+
+	if err == nil {
+		err = tx.Release("record")
+	}
+
+Savepoints can be nested, and names reused: RollbackTo and Release refer to the innermost savepoint with the name.
+*/
+func (t *Tx) Savepoint(name string) error {
+	_, err := t.tx.Exec("SAVEPOINT " + quoteSavepoint(name))
+	if err != nil {
+		return fmt.Errorf("error creating savepoint %s - %w", name, err)
+	}
+
+	t.savepoints = append(t.savepoints, txSavepoint{name: name, changes: len(recordedChanges)})
+
+	return nil
+}
+
+/*
+RollbackTo undoes the operations performed since the savepoint called name was created, releasing the savepoints
+created after it. The savepoint itself stays open, so the operations can be retried. Hooks already called for the
+undone operations are not undone. Fails with ErrSavepointNotFound if no savepoint called name is open.
+*/
+func (t *Tx) RollbackTo(name string) error {
+	i := t.findSavepoint(name)
+	if i < 0 {
+		return fmt.Errorf("%w: %s", ErrSavepointNotFound, name)
+	}
+
+	_, err := t.tx.Exec("ROLLBACK TO " + quoteSavepoint(name))
+	if err != nil {
+		return fmt.Errorf("error rolling back to savepoint %s - %w", name, err)
+	}
+
+	// The undone changes must not reach the change log and the watchers
+	recordedChanges = recordedChanges[:t.savepoints[i].changes]
+	t.savepoints = t.savepoints[:i+1]
+
+	return nil
+}
+
+/*
+Release releases the savepoint called name, and the ones created after it, keeping the operations performed since
+then as part of the transaction. Fails with ErrSavepointNotFound if no savepoint called name is open.
+*/
+func (t *Tx) Release(name string) error {
+	i := t.findSavepoint(name)
+	if i < 0 {
+		return fmt.Errorf("%w: %s", ErrSavepointNotFound, name)
+	}
+
+	_, err := t.tx.Exec("RELEASE " + quoteSavepoint(name))
+	if err != nil {
+		return fmt.Errorf("error releasing savepoint %s - %w", name, err)
+	}
+
+	t.savepoints = t.savepoints[:i]
+
+	return nil
+}
+
+/*
+findSavepoint returns the index of the innermost open savepoint called name, or -1
+*/
+func (t *Tx) findSavepoint(name string) int {
+	for i := len(t.savepoints) - 1; i >= 0; i-- {
+		if t.savepoints[i].name == name {
+			return i
+		}
+	}
+
+	return -1
+}
+
+/*
+quoteSavepoint quotes name as an SQL identifier
+*/
+func quoteSavepoint(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+/*
+checkAccess verifies that the principal of the Tx, if any, is allowed to access path
+*/
+func (t *Tx) checkAccess(path string, write bool) error {
+	if t.acl == nil {
+		return nil
+	}
+
+	return t.acl.Check(t.principal, t.path(path), write)
+}
+
+/*
+path normalizes path, prepending the namespace of the Tx, if any
+*/
+func (t *Tx) path(path string) string {
+	return namespacePath(t.prefix, path)
+}
+
+func movePath(from string, to string, tx *sql.Tx) error {
+	if from == "" || to == "" || to == from || strings.HasPrefix(to, from+"/") {
+		return ErrPathInvalid
+	}
+
+	entry, err := getEntryDepth(from, -1, tx)
+	if err != nil {
+		return err
+	}
+
+	err = deletePath(to, tx)
+	if err != nil {
+		return err
+	}
+
+	var visit func(entry *Entry) error
+	visit = func(entry *Entry) error {
+		if entry.IsValue {
+			value, valueType, err := getTypedValue(entry.Path, tx)
+			if err != nil {
+				return err
+			}
+
+			return setValue(to+strings.TrimPrefix(entry.Path, from), value, valueType, tx, true, false)
+		}
+
+		for _, child := range entry.Children {
+			err := visit(child)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	err = visit(entry)
+	if err != nil {
+		return err
+	}
+
+	return deletePath(from, tx)
+}
@@ -0,0 +1,87 @@
+package camellia
+
+import (
+	"fmt"
+	pathpkg "path"
+	"sync/atomic"
+)
+
+type validator struct {
+	pattern  string
+	callback func(path string, value string) error
+}
+
+var validators = map[uint64]*validator{}
+var validatorIDs = []uint64{}
+var nextValidatorID = uint64(0)
+
+/*
+RegisterValidator registers a callback validating the values set at the paths matching pathGlob (with the syntax of
+path.Match, so a "*" matches a single path segment).
+
+Validators are called inside the write transaction, after the Schema (see SetSchema) is enforced and before any hook
+is called, in the same order as they were registered. If a validator returns an error, the whole operation is rolled
+back and fails with a *ValidationError wrapping it. Unlike hooks, validators are also called when importing values.
+
+Validators should only check the value, without side effects or calls to the API.
+
+Returns a function that unregisters the validator.
+*/
+func RegisterValidator(pathGlob string, callback func(path string, value string) error) (func(), error) {
+	hooksMutex.Lock()
+	defer hooksMutex.Unlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return nil, ErrNoDB
+	}
+
+	pattern := normalizePath(pathGlob)
+	_, err := pathpkg.Match(pattern, "")
+	if err != nil {
+		return nil, fmt.Errorf("invalid path pattern %s - %w", pathGlob, err)
+	}
+
+	nextValidatorID++
+	id := nextValidatorID
+	validators[id] = &validator{pattern: pattern, callback: callback}
+	validatorIDs = append(validatorIDs, id)
+
+	return func() {
+		hooksMutex.Lock()
+		defer hooksMutex.Unlock()
+
+		delete(validators, id)
+		for i, v := range validatorIDs {
+			if v == id {
+				validatorIDs = append(validatorIDs[:i:i], validatorIDs[i+1:]...)
+				break
+			}
+		}
+	}, nil
+}
+
+func callValidators(path string, value string) error {
+	hooksMutex.Lock()
+	matching := []*validator{}
+	for _, id := range validatorIDs {
+		v := validators[id]
+		if matched, _ := matchPath(v.pattern, path); matched {
+			matching = append(matching, v)
+		}
+	}
+	hooksMutex.Unlock()
+
+	for _, v := range matching {
+		err := v.callback(path, value)
+		if err != nil {
+			return &ValidationError{Path: path, Value: value, Pattern: v.pattern, Reason: err.Error(), Err: err}
+		}
+	}
+
+	return nil
+}
+
+func wipeValidators() {
+	validators = map[uint64]*validator{}
+	validatorIDs = []uint64{}
+}
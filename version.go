@@ -0,0 +1,81 @@
+package camellia
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"runtime/debug"
+)
+
+/* Path of the camellia module, looked up in the build info of the program */
+const modulePath = "github.com/debevv/camellia"
+
+/*
+Version returns the version of the camellia module the program was built with, like v1.4.0, as recorded by the Go
+toolchain. Programs built from a checkout of the module report (devel), and programs built without module support
+unknown.
+*/
+func Version() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+
+	if info.Main.Path == modulePath {
+		return info.Main.Version
+	}
+
+	for _, d := range info.Deps {
+		if d.Path == modulePath {
+			if d.Replace != nil {
+				return d.Replace.Version
+			}
+
+			return d.Version
+		}
+	}
+
+	return "unknown"
+}
+
+/*
+GetDBFileSchemaVersion returns the schema version of the DB file at path, 0 for a file not initialized as a camellia
+DB yet. The file is opened read-only, with key if encrypted, and independently of the DB currently open, so it is
+neither created nor migrated: compared with GetSupportedDBSchemaVersion, it tells whether the file needs a migration
+before being opened. Fails with ErrInvalidKey if key is wrong.
+*/
+func GetDBFileSchemaVersion(path string, key string) (uint64, error) {
+	if path == "" {
+		return 0, fmt.Errorf("DB path is empty")
+	}
+
+	d, err := sql.Open("sqlite3", mountURI(mountPoint{path: path, readOnly: true}))
+	if err != nil {
+		return 0, fmt.Errorf("error opening DB - %w", err)
+	}
+
+	defer d.Close()
+
+	conn, err := d.Conn(context.Background())
+	if err != nil {
+		return 0, fmt.Errorf("error opening DB - %w", err)
+	}
+
+	defer conn.Close()
+
+	if key != "" {
+		// The key must be set before any other statement is executed on the connection
+		_, err = conn.ExecContext(context.Background(), keyPragma("key", key))
+		if err != nil {
+			return 0, fmt.Errorf("error setting encryption key - %w", err)
+		}
+	}
+
+	var version uint64
+	err = conn.QueryRowContext(context.Background(), "PRAGMA user_version").Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("error getting DB version - %w", wrapKeyError(err))
+	}
+
+	return version, nil
+}
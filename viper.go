@@ -0,0 +1,87 @@
+package camellia
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+/*
+GetSettings returns the hierarchy of Entries at the specified path as nested maps, in the form taken by the map based
+API of configuration libraries like spf13/viper, so that applications built around them keep their code while the
+configuration is persisted in camellia.
+
+GetSettings, SetSettings and WatchSettings are a map adapter, not a viper remote provider: camellia doesn't depend on
+viper, so viper.AddRemoteProvider can't be pointed at a DB. Settings are moved between the two explicitly:
+
+	v := viper.New()
+
+	settings, err := camellia.GetSettings("app")
+	...
+	err = v.MergeConfigMap(settings)
+
+Values tagged as numbers, booleans and nulls are returned as float64, bool and nil, lists as []any, and the others as
+strings. Runtime values are included (see SetRuntime). Fails with ErrTypeMismatch if the Entry at path is a value.
+*/
+func GetSettings(path string) (map[string]any, error) {
+	buf := bytes.Buffer{}
+
+	err := ExportJSON(path, &buf, ExportOptions{NativeTypes: true, Runtime: true})
+	if err != nil {
+		return nil, err
+	}
+
+	var settings any
+	err = json.Unmarshal(buf.Bytes(), &settings)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding settings - %w", err)
+	}
+
+	m, ok := settings.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%w - %s is a value", ErrTypeMismatch, normalizePath(path))
+	}
+
+	return m, nil
+}
+
+/*
+SetSettings sets (forces) the values found in settings, nested maps like the ones returned by viper's AllSettings,
+under the specified path, in a single transaction. Values are stored with their type, like JSON imports with
+ImportOptions.NativeTypes, and Entries not found in settings are left as they are:
+
+	v.Set("log.level", "debug")
+	err = camellia.SetSettings("app", v.AllSettings())
+*/
+func SetSettings(path string, settings map[string]any) error {
+	encoded, err := json.Marshal(settings)
+	if err != nil {
+		return fmt.Errorf("error encoding settings - %w", err)
+	}
+
+	return ImportJSON(bytes.NewReader(encoded), ImportOptions{NativeTypes: true, Path: path})
+}
+
+/*
+WatchSettings calls callback with the settings at the specified path (see GetSettings) whenever an Entry under it
+changes, so that they can be merged back into the configuration library:
+
+	unwatch, err := camellia.WatchSettings("app", func(settings map[string]any, err error) {
+		if err == nil {
+			v.MergeConfigMap(settings)
+		}
+	})
+
+Callbacks are called like the ones registered with Watch, but once for each committed transaction changing the
+settings, however many Entries it changes, like the imports of SetSettings. The error of reading the settings is
+passed to callback, like ErrPathNotFound after the path is deleted.
+
+Returns a function that unregisters the callback.
+*/
+func WatchSettings(path string, callback func(settings map[string]any, err error)) (func(), error) {
+	path = normalizePath(path)
+
+	return watchCommits(path, func(changes []Change) {
+		callback(GetSettings(path))
+	})
+}
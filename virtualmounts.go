@@ -0,0 +1,251 @@
+package camellia
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+/*
+maxVirtualMountDepth is the maximum depth of the directories listed under a directory mounted with MountDir, so that
+symbolic links pointing to their parents, common in /sys, don't list forever
+*/
+const maxVirtualMountDepth = 16
+
+/*
+MountEnv exposes the environment variables of the process under prefix, read-only, so that they are inspected in the
+same hierarchy as the configuration: each variable is a value named after it, like "env/HOME".
+
+Like the values computed by providers (see RegisterProvider), mounted values are read on every read, are never stored,
+appear in exports only with ExportOptions.Runtime, and writing them fails with ErrReadOnly. Mount fails with
+ErrPathInvalid if prefix is the root, overlaps another mount, or already exists in the DB. Virtual mounts are removed
+with Unmount, and by Close.
+*/
+func MountEnv(prefix string) error {
+	return mountVirtual(prefix, "environment", func(p *provider) {
+		p.callback = func(path string) (string, error) {
+			name := strings.TrimPrefix(path, p.prefix+"/")
+			if len(splitPath(name)) != 1 {
+				return "", ErrPathNotFound
+			}
+
+			value, ok := os.LookupEnv(UnescapeSegment(name))
+			if !ok {
+				return "", ErrPathNotFound
+			}
+
+			return value, nil
+		}
+
+		p.list = func(path string) []string {
+			paths := []string{}
+			for _, kv := range os.Environ() {
+				name, _, _ := strings.Cut(kv, "=")
+				if name == "" {
+					continue
+				}
+
+				value := p.prefix + "/" + EscapeSegment(name)
+				if path == "" || value == path || strings.HasPrefix(value, path+"/") {
+					paths = append(paths, value)
+				}
+			}
+
+			return paths
+		}
+	})
+}
+
+/*
+MountDir exposes the directory at dir under prefix, read-only, like MountEnv: each directory is a non-value Entry, and
+each file a value holding its content, without the trailing new line. Files are read on every read, so /proc-style
+sources are mounted too, like MountDir("net/ipv4", "/proc/sys/net/ipv4"). Symbolic links are followed, down to 16
+levels below dir.
+*/
+func MountDir(prefix string, dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("error reading directory %s - %w", dir, err)
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return fmt.Errorf("error resolving path of %s - %w", dir, err)
+	}
+
+	return mountVirtual(prefix, dir, func(p *provider) {
+		p.callback = func(path string) (string, error) {
+			file, err := mountedFile(dir, p.prefix, path)
+			if err != nil {
+				return "", err
+			}
+
+			info, err := os.Stat(file)
+			if errors.Is(err, os.ErrNotExist) {
+				return "", ErrPathNotFound
+			}
+
+			if err != nil {
+				return "", err
+			}
+
+			if info.IsDir() {
+				return "", ErrPathIsNotAValue
+			}
+
+			content, err := os.ReadFile(file)
+			if err != nil {
+				return "", err
+			}
+
+			return strings.TrimSuffix(string(content), "\n"), nil
+		}
+
+		p.list = func(path string) []string {
+			file, base := dir, p.prefix
+			if path != "" && path != p.prefix && !strings.HasPrefix(p.prefix, path+"/") {
+				var err error
+				file, err = mountedFile(dir, p.prefix, path)
+				if err != nil {
+					return nil
+				}
+
+				base = path
+			}
+
+			paths := []string{}
+			listMountedDir(file, base, 0, &paths)
+
+			return paths
+		}
+	})
+}
+
+/*
+mountVirtual registers the provider of a virtual mount at prefix, initialized by init, once verified that prefix can be
+mounted. source describes the mounted source in the log
+*/
+func mountVirtual(prefix string, source string, init func(p *provider)) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return ErrNoDB
+	}
+
+	prefix = normalizePath(prefix)
+	if prefix == "" {
+		return ErrPathInvalid
+	}
+
+	for _, m := range mounts {
+		if m.prefix == prefix || strings.HasPrefix(prefix, m.prefix+"/") || strings.HasPrefix(m.prefix, prefix+"/") {
+			return fmt.Errorf("%w - %s overlaps the mount at %s", ErrPathInvalid, prefix, m.prefix)
+		}
+	}
+
+	for _, p := range providers {
+		if p.prefix != "" && (p.prefix == prefix || strings.HasPrefix(prefix, p.prefix+"/") ||
+			strings.HasPrefix(p.prefix, prefix+"/")) {
+			return fmt.Errorf("%w - %s overlaps the mount at %s", ErrPathInvalid, prefix, p.prefix)
+		}
+	}
+
+	tx, err := beginReadTx(context.Background())
+	if err != nil {
+		return fmt.Errorf("error beginning transaction - %w", err)
+	}
+
+	found, err := exists(prefix, tx)
+	if err != nil {
+		rollbackTx(tx)
+		return err
+	}
+
+	err = endReadTx(context.Background(), tx)
+	if err != nil {
+		rollbackTx(tx)
+		return fmt.Errorf("error committing transaction - %w", err)
+	}
+
+	if found {
+		return fmt.Errorf("%w - %s already exists", ErrPathInvalid, prefix)
+	}
+
+	p := &provider{pattern: prefix + "/**", prefix: prefix}
+	init(p)
+
+	providers = append(providers, p)
+
+	logInfo("mounted virtual source", "prefix", prefix, "source", source)
+
+	return nil
+}
+
+/*
+unmountVirtual removes the virtual mount at prefix, returning whether there was one
+*/
+func unmountVirtual(prefix string) bool {
+	for i, p := range providers {
+		if p.prefix != "" && p.prefix == prefix {
+			providers = append(providers[:i:i], providers[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+mountedFile returns the file under dir of the Entry at path, under the prefix where dir is mounted
+*/
+func mountedFile(dir string, prefix string, path string) (string, error) {
+	names := SplitSegments(strings.TrimPrefix(path, prefix+"/"))
+
+	for _, name := range names {
+		if name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+			return "", ErrPathNotFound
+		}
+	}
+
+	return filepath.Join(append([]string{dir}, names...)...), nil
+}
+
+/*
+listMountedDir appends to paths the paths of the files at file and below it, where path is the path of file
+*/
+func listMountedDir(file string, path string, depth int, paths *[]string) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return
+	}
+
+	if !info.IsDir() {
+		if info.Mode().IsRegular() {
+			*paths = append(*paths, path)
+		}
+
+		return
+	}
+
+	if depth >= maxVirtualMountDepth {
+		return
+	}
+
+	entries, err := os.ReadDir(file)
+	if err != nil {
+		return
+	}
+
+	for _, e := range entries {
+		listMountedDir(filepath.Join(file, e.Name()), path+"/"+EscapeSegment(e.Name()), depth+1, paths)
+	}
+}
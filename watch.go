@@ -0,0 +1,162 @@
+package camellia
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+watcher is a callback registered with Watch, called once for each change, or, if batchCallback is set, once for each
+transaction, with all of its changes
+*/
+type watcher struct {
+	path          string
+	callback      func(change Change)
+	batchCallback func(changes []Change)
+}
+
+type watchBatch struct {
+	watcher *watcher
+	changes []Change
+}
+
+var watchers = map[uint64]*watcher{}
+var nextWatcherID = uint64(0)
+
+var watchQueue []watchBatch
+var watchQueueCond = sync.NewCond(&sync.Mutex{})
+var watchDispatcherStarted = false
+
+/*
+Watch registers a callback to be called for every change to the Entry at the specified path and to its children,
+including the deletion of the Entry itself or of one of its ancestors.
+
+Callbacks are called after the transaction carrying the changes is committed, on a dedicated goroutine, in the same
+order as the changes happened. Since they are called outside of any transaction, callbacks are free to call the API.
+
+Returns a function that unregisters the callback.
+*/
+func Watch(path string, callback func(change Change)) (func(), error) {
+	return addWatcher(&watcher{path: normalizePath(path), callback: callback})
+}
+
+/*
+watchCommits registers a callback like Watch, but called once for each committed transaction changing the Entry at path
+or its children, with all of the changes of the transaction
+*/
+func watchCommits(path string, callback func(changes []Change)) (func(), error) {
+	return addWatcher(&watcher{path: normalizePath(path), batchCallback: callback})
+}
+
+func addWatcher(w *watcher) (func(), error) {
+	hooksMutex.Lock()
+	defer hooksMutex.Unlock()
+
+	if atomic.LoadInt32(&initialized) == 0 {
+		return nil, ErrNoDB
+	}
+
+	nextWatcherID++
+	id := nextWatcherID
+	watchers[id] = w
+
+	startWatchDispatcher()
+
+	return func() {
+		hooksMutex.Lock()
+		defer hooksMutex.Unlock()
+
+		delete(watchers, id)
+	}, nil
+}
+
+func wipeWatchers() {
+	watchers = map[uint64]*watcher{}
+}
+
+/*
+watchMatches returns whether a change is relevant for a watcher on watchPath: the change is either at watchPath, below
+it, or above it, deleting or overwriting it
+*/
+func watchMatches(watchPath string, change Change) bool {
+	if watchPath == "" || watchPath == change.Path || strings.HasPrefix(change.Path, watchPath+"/") {
+		return true
+	}
+
+	if change.Type != ChangeDeleted && change.Type != ChangeOverwritten {
+		return false
+	}
+
+	return change.Path == "" || strings.HasPrefix(watchPath, change.Path+"/")
+}
+
+func notifyWatchers(changes []Change) {
+	hooksMutex.Lock()
+	defer hooksMutex.Unlock()
+
+	batches := []watchBatch{}
+	for _, w := range watchers {
+		matching := []Change{}
+		for _, c := range changes {
+			if watchMatches(w.path, c) {
+				matching = append(matching, c)
+			}
+		}
+
+		if len(matching) > 0 {
+			batches = append(batches, watchBatch{watcher: w, changes: matching})
+		}
+	}
+
+	if len(batches) == 0 {
+		return
+	}
+
+	watchQueueCond.L.Lock()
+	watchQueue = append(watchQueue, batches...)
+	watchQueueCond.L.Unlock()
+	watchQueueCond.Signal()
+}
+
+func startWatchDispatcher() {
+	if watchDispatcherStarted {
+		return
+	}
+
+	watchDispatcherStarted = true
+
+	go func() {
+		for {
+			watchQueueCond.L.Lock()
+			for len(watchQueue) == 0 {
+				watchQueueCond.Wait()
+			}
+
+			batch := watchQueue[0]
+			watchQueue = watchQueue[1:]
+			watchQueueCond.L.Unlock()
+
+			if batch.watcher.batchCallback != nil {
+				callBatchWatcher(batch.watcher, batch.changes)
+				continue
+			}
+
+			for _, c := range batch.changes {
+				callWatcher(batch.watcher, c)
+			}
+		}
+	}()
+}
+
+func callWatcher(w *watcher, change Change) {
+	defer recoverCallback("watch callback", change.Path)
+
+	w.callback(change)
+}
+
+func callBatchWatcher(w *watcher, changes []Change) {
+	defer recoverCallback("watch callback", w.path)
+
+	w.batchCallback(changes)
+}
@@ -0,0 +1,38 @@
+package camellia
+
+/*
+writer is the identity recorded on the Entries written through this handle, txWriter the one overriding it for the
+duration of a single transaction (always under the global mutex)
+*/
+var writer = ""
+var txWriter = ""
+
+/*
+SetWriter sets the identity (like a component name, a user, or a token subject) recorded on every Entry created or
+updated through this handle, and exposed as Entry.Writer. Single transactions can record a different identity, see
+Tx.SetWriter and ImportOptions.Writer.
+*/
+func SetWriter(identity string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	writer = identity
+}
+
+/*
+GetWriter returns the identity set with SetWriter.
+*/
+func GetWriter() string {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	return writer
+}
+
+func currentWriter() string {
+	if txWriter != "" {
+		return txWriter
+	}
+
+	return writer
+}